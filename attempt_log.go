@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultAttemptLogSize is the number of onboarding attempts kept by an
+// AttemptLogStore which is not configured with a size.
+const DefaultAttemptLogSize = 16
+
+// OnboardingAttempt records a run of TO1 or TO2 by a device, so that devices
+// which repeatedly fail in the field can report how far they got.
+type OnboardingAttempt struct {
+	// Timestamp is when the attempt started, in seconds since the Unix epoch.
+	Timestamp int64
+
+	// Protocol is TO1Protocol or TO2Protocol.
+	Protocol protocol.Protocol
+
+	// BaseURL is the rendezvous or owner service of the attempt.
+	BaseURL string
+
+	// MsgType is the last message received from the server, or zero if the
+	// attempt failed before any response was received. For a successful
+	// attempt, it is the final message of the protocol.
+	MsgType uint8
+
+	// ErrCode is the code of the error message received from the server, if
+	// any.
+	ErrCode uint16
+
+	// Err describes the failure of the attempt. It is empty if the attempt
+	// succeeded.
+	Err string
+}
+
+// AttemptLogStore is implemented by a CredentialStore which persists a ring
+// of recent onboarding attempts alongside the device credential. [Onboard]
+// appends each TO1 and TO2 attempt to it.
+type AttemptLogStore interface {
+	CredentialStore
+
+	// AddAttempt appends an attempt to the log, discarding the oldest
+	// attempts once the log is full.
+	AddAttempt(OnboardingAttempt) error
+
+	// Attempts returns the logged attempts, oldest first.
+	Attempts() ([]OnboardingAttempt, error)
+}
+
+// attemptLogger records the attempts of Onboard. A nil attemptLogger records
+// nothing.
+type attemptLogger struct {
+	store   AttemptLogStore
+	msgType uint8
+}
+
+func newAttemptLogger(store CredentialStore) *attemptLogger {
+	if store, ok := store.(AttemptLogStore); ok {
+		return &attemptLogger{store: store}
+	}
+	return nil
+}
+
+// hooks returns hooks which also capture the last message type received
+// before an attempt fails.
+func (l *attemptLogger) hooks(hooks ClientHooks) ClientHooks {
+	if l == nil {
+		return hooks
+	}
+	onError := hooks.OnError
+	hooks.OnError = func(prot protocol.Protocol, prevMsgType uint8, err error) {
+		l.msgType = prevMsgType
+		if onError != nil {
+			onError(prot, prevMsgType, err)
+		}
+	}
+	return hooks
+}
+
+// record appends an attempt which started at the given time. A failure to
+// record the attempt does not fail onboarding.
+func (l *attemptLogger) record(prot protocol.Protocol, baseURL string, start time.Time, err error) {
+	if l == nil {
+		return
+	}
+	attempt := OnboardingAttempt{
+		Timestamp: start.Unix(),
+		Protocol:  prot,
+		BaseURL:   baseURL,
+		MsgType:   l.msgType,
+	}
+	l.msgType = 0
+	switch {
+	case err != nil:
+		attempt.Err = err.Error()
+		var errMsg protocol.ErrorMessage
+		if errors.As(err, &errMsg) {
+			attempt.ErrCode = errMsg.Code
+		}
+	case prot == protocol.TO1Protocol:
+		attempt.MsgType = protocol.TO1RVRedirectMsgType
+	case prot == protocol.TO2Protocol:
+		attempt.MsgType = protocol.TO2Done2MsgType
+	}
+	if err := l.store.AddAttempt(attempt); err != nil {
+		slog.Warn("error recording onboarding attempt", "protocol", prot, "error", err)
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"unsafe"
+)
+
+// Linux key management constants, see keyctl(2) and add_key(2).
+const (
+	keyctlUnlink = 9
+	keyctlSearch = 10
+	keyctlRead   = 11
+
+	// Maximum payload size of a "user" key
+	keyringMaxPayload = 32767
+)
+
+// keySpecUserKeyring is a variable so that it is sign-extended when converted
+// to a syscall argument.
+var keySpecUserKeyring = -4
+
+// NewKeyringStore returns a Store which persists a blob device credential as
+// a "user" key in the Linux user keyring of the calling process. Staged
+// credentials are stored in a second key with a ".staged" suffix and
+// onboarding attempts in a third key with an ".attempts" suffix.
+//
+// Keys in the user keyring do not survive a reboot unless the keyring is
+// backed by persistent storage, so this backend is most useful when the
+// keyring is populated at boot, e.g. from a sealed blob.
+func NewKeyringStore(description string) *Store {
+	return &Store{
+		storage: keyringStorage(description),
+		log:     keyringStorage(description + attemptLogSuffix),
+	}
+}
+
+type keyringStorage string
+
+func (k keyringStorage) description(staged bool) string {
+	if staged {
+		return string(k) + ".staged"
+	}
+	return string(k)
+}
+
+func (k keyringStorage) read(staged bool) ([]byte, error) {
+	id, err := keyringSearch(k.description(staged))
+	if errors.Is(err, syscall.ENOKEY) {
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	} else if err != nil {
+		return nil, err
+	}
+	return keyringRead(id)
+}
+
+func (k keyringStorage) write(staged bool, data []byte) error {
+	if len(data) > keyringMaxPayload {
+		return fmt.Errorf("encoded credential of %d bytes exceeds keyring payload limit", len(data))
+	}
+	// add_key atomically updates the payload of an existing key with the same
+	// type and description
+	_, err := keyringAdd(k.description(staged), data)
+	return err
+}
+
+func (k keyringStorage) commit() error {
+	id, err := keyringSearch(k.description(true))
+	if errors.Is(err, syscall.ENOKEY) {
+		return ErrNotStaged
+	} else if err != nil {
+		return err
+	}
+	data, err := keyringRead(id)
+	if err != nil {
+		return err
+	}
+	if _, err := keyringAdd(k.description(false), data); err != nil {
+		return err
+	}
+	// A staged key left behind after failing to unlink is harmless, because
+	// it is overwritten by the next Stage
+	return keyctl(keyctlUnlink, uintptr(id), uintptr(keySpecUserKeyring), 0, 0)
+}
+
+func keyringAdd(description string, payload []byte) (int, error) {
+	typ, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return 0, err
+	}
+	desc, err := syscall.BytePtrFromString(description)
+	if err != nil {
+		return 0, err
+	}
+	var data unsafe.Pointer
+	if len(payload) > 0 {
+		data = unsafe.Pointer(&payload[0])
+	}
+	id, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY,
+		uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)),
+		uintptr(data), uintptr(len(payload)), uintptr(keySpecUserKeyring), 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("add_key %q: %w", description, errno)
+	}
+	return int(id), nil
+}
+
+func keyringSearch(description string) (int, error) {
+	typ, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return 0, err
+	}
+	desc, err := syscall.BytePtrFromString(description)
+	if err != nil {
+		return 0, err
+	}
+	id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(keySpecUserKeyring),
+		uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)), 0, 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("keyctl search %q: %w", description, errno)
+	}
+	return int(id), nil
+}
+
+func keyringRead(id int) ([]byte, error) {
+	buf := make([]byte, keyringMaxPayload)
+	n, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, uintptr(id),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("keyctl read %d: %w", id, errno)
+	}
+	if int(n) > len(buf) {
+		return nil, fmt.Errorf("keyctl read %d: payload of %d bytes exceeds limit", id, n)
+	}
+	return buf[:n], nil
+}
+
+func keyctl(cmd int, arg2, arg3, arg4, arg5 uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, uintptr(cmd), arg2, arg3, arg4, arg5, 0)
+	if errno != 0 {
+		return fmt.Errorf("keyctl %d: %w", cmd, errno)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package blob_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/blob"
+)
+
+func TestKeyringStore(t *testing.T) {
+	store := blob.NewKeyringStore("go-fdo-test-" + t.Name())
+	if _, err := store.Read(); errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		t.Skipf("keyring unavailable: %v", err)
+	}
+	testStore(t, store)
+}
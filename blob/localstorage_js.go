@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build js && wasm
+
+package blob
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall/js"
+)
+
+// NewLocalStorageStore returns a Store which persists a blob device
+// credential in the Web Storage localStorage of a browser, base64-encoded
+// under the given key. Staged credentials are stored under a second key with
+// a ".staged" suffix and onboarding attempts under a third key with an
+// ".attempts" suffix.
+//
+// The device secret and private key are readable by any script of the same
+// origin, so this backend is only suitable for demo devices.
+func NewLocalStorageStore(key string) *Store {
+	return &Store{
+		storage: localStorage(key),
+		log:     localStorage(key + attemptLogSuffix),
+	}
+}
+
+type localStorage string
+
+func (l localStorage) key(staged bool) string {
+	if staged {
+		return string(l) + ".staged"
+	}
+	return string(l)
+}
+
+func (l localStorage) read(staged bool) (_ []byte, err error) {
+	defer recoverJSError(&err)
+	item := js.Global().Get("localStorage").Call("getItem", l.key(staged))
+	if item.IsNull() {
+		return nil, fmt.Errorf("localStorage item %q not found: %w", l.key(staged), fs.ErrNotExist)
+	}
+	return base64.StdEncoding.DecodeString(item.String())
+}
+
+func (l localStorage) write(staged bool, data []byte) (err error) {
+	defer recoverJSError(&err)
+	js.Global().Get("localStorage").Call("setItem", l.key(staged), base64.StdEncoding.EncodeToString(data))
+	return nil
+}
+
+func (l localStorage) commit() (err error) {
+	defer recoverJSError(&err)
+	storage := js.Global().Get("localStorage")
+	item := storage.Call("getItem", l.key(true))
+	if item.IsNull() {
+		return ErrNotStaged
+	}
+	storage.Call("setItem", l.key(false), item)
+	storage.Call("removeItem", l.key(true))
+	return nil
+}
+
+// recoverJSError converts an exception thrown by a JavaScript call, such as a
+// QuotaExceededError or a SecurityError when storage is disabled, to an
+// error.
+func recoverJSError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	var jsErr js.Error
+	if e, ok := r.(error); ok && errors.As(e, &jsErr) {
+		*err = fmt.Errorf("localStorage: %w", jsErr)
+		return
+	}
+	panic(r)
+}
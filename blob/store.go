@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package blob
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+)
+
+// ErrNotStaged is returned by Commit when no credential has been staged.
+var ErrNotStaged = errors.New("no staged device credential")
+
+// storage persists an encoded device credential in an active and a staged
+// slot.
+type storage interface {
+	read(staged bool) ([]byte, error)
+	write(staged bool, data []byte) error
+	commit() error
+}
+
+// Store implements [fdo.CredentialStore] for a blob device credential. The
+// device secret and private key are persisted alongside the credential, so
+// the backing storage must be protected accordingly.
+//
+// Onboarding attempts are persisted separately from the credential, so that
+// logging an attempt cannot corrupt the credential.
+type Store struct {
+	// AttemptLogSize is the number of onboarding attempts kept. If zero,
+	// fdo.DefaultAttemptLogSize is used.
+	AttemptLogSize int
+
+	storage storage
+	log     storage
+}
+
+var _ fdo.ActivityStore = (*Store)(nil)
+var _ fdo.AttemptLogStore = (*Store)(nil)
+
+// NewFileStore returns a Store which persists a blob device credential to a
+// file. Staged credentials are written next to it and renamed over the active
+// file on commit. Onboarding attempts are written next to it with an
+// ".attempts" suffix.
+func NewFileStore(path string) *Store {
+	path = filepath.Clean(path)
+	return &Store{
+		storage: fileStorage(path),
+		log:     fileStorage(path + attemptLogSuffix),
+	}
+}
+
+// NewEncryptedFileStore returns a Store which persists a blob device
+// credential to a file, sealed with the given Sealer. Onboarding attempts are
+// sealed as well.
+func NewEncryptedFileStore(path string, sealer kex.Sealer) *Store {
+	path = filepath.Clean(path)
+	return &Store{
+		storage: sealedStorage{
+			storage: fileStorage(path),
+			sealer:  sealer,
+			aad:     sealedStorageAAD,
+		},
+		log: sealedStorage{
+			storage: fileStorage(path + attemptLogSuffix),
+			sealer:  sealer,
+			aad:     sealedAttemptLogAAD,
+		},
+	}
+}
+
+// attemptLogSuffix is appended to the path or key of a credential to store its
+// onboarding attempts.
+const attemptLogSuffix = ".attempts"
+
+// Save persists a blob device credential as the active credential, such as
+// after DI.
+func (s *Store) Save(dc *DeviceCredential) error {
+	data, err := cbor.Marshal(dc)
+	if err != nil {
+		return fmt.Errorf("error encoding device credential: %w", err)
+	}
+	return s.storage.write(false, data)
+}
+
+// Load returns the active blob device credential, including secrets.
+func (s *Store) Load() (*DeviceCredential, error) {
+	data, err := s.storage.read(false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading device credential: %w", err)
+	}
+	var dc DeviceCredential
+	if err := cbor.Unmarshal(data, &dc); err != nil {
+		return nil, fmt.Errorf("error decoding device credential: %w", err)
+	}
+	return &dc, nil
+}
+
+// Read returns the active device credential.
+func (s *Store) Read() (*fdo.DeviceCredential, error) {
+	dc, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &dc.DeviceCredential, nil
+}
+
+// HMACs returns HMAC-SHA256 and HMAC-SHA384 hashes keyed with the device
+// secret.
+func (s *Store) HMACs() (hmacSha256, hmacSha384 hash.Hash, _ error) {
+	dc, err := s.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	hmacSha256, hmacSha384 = dc.HMACs()
+	return hmacSha256, hmacSha384, nil
+}
+
+// Signer returns the device key.
+func (s *Store) Signer() (crypto.Signer, error) {
+	dc, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	if !dc.PrivateKey.IsValid() {
+		return nil, fmt.Errorf("private key is an invalid type or curve/size for FDO device credential usage")
+	}
+	return dc.PrivateKey.Signer, nil
+}
+
+// Stage persists a replacement device credential, retaining the device secret
+// and key of the active credential. The replacement is marked inactive, so
+// that committing it also records that the device has onboarded.
+func (s *Store) Stage(cred fdo.DeviceCredential) error {
+	dc, err := s.Load()
+	if err != nil {
+		return err
+	}
+	dc.DeviceCredential = cred
+	dc.Active = false
+	data, err := cbor.Marshal(dc)
+	if err != nil {
+		return fmt.Errorf("error encoding device credential: %w", err)
+	}
+	if err := s.storage.write(true, data); err != nil {
+		return fmt.Errorf("error staging device credential: %w", err)
+	}
+	return nil
+}
+
+// Commit makes the staged credential active.
+func (s *Store) Commit() error {
+	if err := s.storage.commit(); err != nil {
+		return fmt.Errorf("error committing device credential: %w", err)
+	}
+	return nil
+}
+
+// Active reports whether the active device credential is marked active.
+func (s *Store) Active() (bool, error) {
+	dc, err := s.Load()
+	if err != nil {
+		return false, err
+	}
+	return dc.Active, nil
+}
+
+// SetActive marks the active device credential active or inactive.
+func (s *Store) SetActive(active bool) error {
+	dc, err := s.Load()
+	if err != nil {
+		return err
+	}
+	dc.Active = active
+	return s.Save(dc)
+}
+
+// AddAttempt appends an onboarding attempt to the log, discarding the oldest
+// attempts once AttemptLogSize is exceeded.
+func (s *Store) AddAttempt(attempt fdo.OnboardingAttempt) error {
+	attempts, err := s.Attempts()
+	if err != nil {
+		return err
+	}
+	size := s.AttemptLogSize
+	if size <= 0 {
+		size = fdo.DefaultAttemptLogSize
+	}
+	attempts = append(attempts, attempt)
+	if len(attempts) > size {
+		attempts = attempts[len(attempts)-size:]
+	}
+	data, err := cbor.Marshal(attempts)
+	if err != nil {
+		return fmt.Errorf("error encoding onboarding attempts: %w", err)
+	}
+	if err := s.log.write(false, data); err != nil {
+		return fmt.Errorf("error writing onboarding attempts: %w", err)
+	}
+	return nil
+}
+
+// Attempts returns the logged onboarding attempts, oldest first. If none have
+// been logged, the result is empty.
+func (s *Store) Attempts() ([]fdo.OnboardingAttempt, error) {
+	data, err := s.log.read(false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading onboarding attempts: %w", err)
+	}
+	var attempts []fdo.OnboardingAttempt
+	if err := cbor.Unmarshal(data, &attempts); err != nil {
+		return nil, fmt.Errorf("error decoding onboarding attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// fileStorage stores the active credential at its path and the staged
+// credential at the path with a ".staged" suffix.
+type fileStorage string
+
+func (f fileStorage) path(staged bool) string {
+	if staged {
+		return string(f) + ".staged"
+	}
+	return string(f)
+}
+
+func (f fileStorage) read(staged bool) ([]byte, error) {
+	return os.ReadFile(f.path(staged))
+}
+
+func (f fileStorage) write(staged bool, data []byte) error {
+	// Write to a temp file in the same directory, so that rename is atomic
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), "."+filepath.Base(string(f))+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	defer func() { _ = tmp.Close() }()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(staged))
+}
+
+func (f fileStorage) commit() error {
+	if err := os.Rename(f.path(true), f.path(false)); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotStaged
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// sealedStorage encrypts credentials before passing them to the underlying
+// storage.
+type sealedStorage struct {
+	storage
+	sealer kex.Sealer
+	aad    []byte
+}
+
+// sealedStorageAAD binds sealed data to its use as a device credential and
+// sealedAttemptLogAAD to its use as a log of onboarding attempts.
+var (
+	sealedStorageAAD    = []byte("fdo device credential")
+	sealedAttemptLogAAD = []byte("fdo onboarding attempts")
+)
+
+func (s sealedStorage) read(staged bool) ([]byte, error) {
+	sealed, err := s.storage.read(staged)
+	if err != nil {
+		return nil, err
+	}
+	return s.sealer.Open(sealed, s.aad)
+}
+
+func (s sealedStorage) write(staged bool, data []byte) error {
+	sealed, err := s.sealer.Seal(data, s.aad)
+	if err != nil {
+		return err
+	}
+	return s.storage.write(staged, sealed)
+}
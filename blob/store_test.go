@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package blob_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/blob"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cred.bin")
+	testStore(t, blob.NewFileStore(path))
+	testAttemptLog(t, blob.NewFileStore(path))
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cred.bin")
+	sealer := kex.AEADSealer{Alg: cose.A256GCM, Key: make([]byte, 32)}
+	if _, err := rand.Read(sealer.Key); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, blob.NewEncryptedFileStore(path, sealer))
+	testAttemptLog(t, blob.NewEncryptedFileStore(path, sealer))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("replacement")) {
+		t.Fatal("expected credential file to be encrypted")
+	}
+}
+
+func testStore(t *testing.T, store *blob.Store) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&blob.DeviceCredential{
+		Active: true,
+		DeviceCredential: fdo.DeviceCredential{
+			Version:    101,
+			DeviceInfo: "initial",
+			GUID:       protocol.GUID{1},
+		},
+		HmacSecret: []byte("secret"),
+		PrivateKey: blob.Pkcs8Key{Signer: key},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(); !errors.Is(err, blob.ErrNotStaged) {
+		t.Fatalf("expected ErrNotStaged, got %v", err)
+	}
+
+	// Staging must not affect the active credential
+	if err := store.Stage(fdo.DeviceCredential{
+		Version:    101,
+		DeviceInfo: "replacement",
+		GUID:       protocol.GUID{2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cred, err := store.Read(); err != nil {
+		t.Fatal(err)
+	} else if cred.GUID != (protocol.GUID{1}) {
+		t.Fatalf("expected initial credential before commit, got GUID %x", cred.GUID)
+	}
+
+	// Commit activates the staged credential and retains secrets
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	cred, err := store.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.GUID != (protocol.GUID{2}) || cred.DeviceInfo != "replacement" {
+		t.Fatalf("expected replacement credential after commit, got %+v", cred)
+	}
+	signer, err := store.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(signer) {
+		t.Fatal("expected device key to be retained")
+	}
+	h256, h384, err := store.HMACs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h256 == nil || h384 == nil {
+		t.Fatal("expected both HMACs")
+	}
+
+	// Commit marks the replacement inactive and active state can be toggled
+	if active, err := store.Active(); err != nil {
+		t.Fatal(err)
+	} else if active {
+		t.Fatal("expected credential to be inactive after commit")
+	}
+	if err := store.SetActive(true); err != nil {
+		t.Fatal(err)
+	}
+	if active, err := store.Active(); err != nil {
+		t.Fatal(err)
+	} else if !active {
+		t.Fatal("expected credential to be active")
+	}
+	if cred, err := store.Read(); err != nil {
+		t.Fatal(err)
+	} else if cred.GUID != (protocol.GUID{2}) {
+		t.Fatalf("expected credential to be unchanged by SetActive, got GUID %x", cred.GUID)
+	}
+}
+
+func testAttemptLog(t *testing.T, store *blob.Store) {
+	if attempts, err := store.Attempts(); err != nil || len(attempts) != 0 {
+		t.Fatalf("expected no attempts, got %+v, %v", attempts, err)
+	}
+
+	// The oldest attempts are discarded once the log is full
+	store.AttemptLogSize = 2
+	for i := range 3 {
+		if err := store.AddAttempt(fdo.OnboardingAttempt{
+			Timestamp: int64(i),
+			Protocol:  protocol.TO2Protocol,
+			Err:       "failed",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempts, err := store.Attempts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].Timestamp != 1 || attempts[1].Timestamp != 2 || attempts[1].Err != "failed" {
+		t.Fatalf("expected the last 2 attempts, got %+v", attempts)
+	}
+
+	// Logging attempts does not affect the credential
+	if _, err := store.Read(); err != nil {
+		t.Fatal(err)
+	}
+}
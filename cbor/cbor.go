@@ -211,6 +211,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // MaxArrayDecodeLength limits the max size of an array, string, byte slice, or
@@ -769,36 +770,39 @@ func (d *Decoder) decodeArrayToStruct(rv reflect.Value, additional []byte) error
 	}
 
 	// Get order of fields and filter out up to one if necessary
-	indices, omittable := fieldOrder(rv.NumField(), rv.Type().Field)
-	if int(length) != len(indices) {
+	fields := fieldOrder(rv.Type())
+	if int(length) != len(fields) {
 		omittedOne := false
-		for i, idx := range indices {
-			if omittable(idx) {
+		filtered := make([]structField, 0, len(fields))
+		for _, f := range fields {
+			if f.omittable {
 				if omittedOne {
 					return fmt.Errorf("%w: unmarshaling to a struct with more than one omittable field is not supported",
 						ErrUnsupportedType{typeName: rv.Type().String()})
 				}
 
 				omittedOne = true
-				indices = append(indices[:i], indices[i+1:]...)
+				continue
 			}
+			filtered = append(filtered, f)
 		}
+		fields = filtered
 	}
-	if int(length) != len(indices) {
+	if int(length) != len(fields) {
 		return fmt.Errorf("%w: struct has an incorrect number of fields: has %d, expected %d",
-			ErrUnsupportedType{typeName: rv.Type().String()}, len(indices), length)
+			ErrUnsupportedType{typeName: rv.Type().String()}, len(fields), length)
 	}
 
 	// Decode each item into the appropriate field
-	for i, idx := range indices {
+	for i, f := range fields {
 		// If the previous index was the same, skip, because FlatUnmarshaler
 		// already decoded all of its values. (The duplicate indices are just
 		// so the length of the indices slice matches the array size.)
-		if i > 0 && slices.Equal(idx, indices[i-1]) {
+		if i > 0 && slices.Equal(f.index, fields[i-1].index) {
 			continue
 		}
 
-		if err := d.decodeStructField(rv, idx); err != nil {
+		if err := d.decodeStructField(rv, f); err != nil {
 			return err
 		}
 	}
@@ -806,7 +810,9 @@ func (d *Decoder) decodeArrayToStruct(rv reflect.Value, additional []byte) error
 	return nil
 }
 
-func (d *Decoder) decodeStructField(rv reflect.Value, idx []int) error {
+func (d *Decoder) decodeStructField(rv reflect.Value, sf structField) error {
+	idx := sf.index
+
 	// Allocate any nil embedded struct pointer fields on the index path
 	for i := 1; i < len(idx); i++ {
 		embed := rv.FieldByIndex(idx[:i])
@@ -818,7 +824,7 @@ func (d *Decoder) decodeStructField(rv reflect.Value, idx []int) error {
 	f := rv.FieldByIndex(idx)
 
 	// Use FlatUnmarshaler if flatN option is given
-	if n, ok := flatN(rv.Type().FieldByIndex(idx)); ok {
+	if n := sf.flat; n > 0 {
 		fm, ok := f.Interface().(FlatUnmarshaler)
 		if !ok && f.CanAddr() {
 			fm, ok = f.Addr().Interface().(FlatUnmarshaler)
@@ -1125,7 +1131,7 @@ func (e *Encoder) Encode(v any) error {
 	case rv.Kind() == reflect.Array || rv.Kind() == reflect.Slice:
 		return e.encodeArray(rv.Len(), rv.Index)
 	case rv.Kind() == reflect.Struct:
-		return e.encodeStruct(rv.NumField(), rv.FieldByIndex, rv.Type().FieldByIndex)
+		return e.encodeStruct(rv)
 	case rv.Kind() == reflect.Map:
 		return e.encodeMap(rv.Len(), rv.MapKeys(), rv.MapIndex)
 	case rv.Kind() == reflect.Bool:
@@ -1281,35 +1287,44 @@ func (e *Encoder) encodeArray(size int, get func(int) reflect.Value) error {
 	return nil
 }
 
-func (e *Encoder) encodeStruct(size int, get func([]int) reflect.Value, field func([]int) reflect.StructField) error {
+func (e *Encoder) encodeStruct(rv reflect.Value) error {
 	// Get encoding order of fields
-	indices, omittable := fieldOrder(size, func(i int) reflect.StructField { return field([]int{i}) })
+	fields := fieldOrder(rv.Type())
 
-	// Filter omittable fields which are the zero value for the associated type
-	for i, idx := range indices {
-		if omittable(idx) && get(idx).IsZero() {
-			indices = append(indices[:i], indices[i+1:]...)
+	// Filter omittable fields which are the zero value for the associated
+	// type, copying so that the cached field order is not modified
+	for i, f := range fields {
+		if !f.omittable || !rv.FieldByIndex(f.index).IsZero() {
+			continue
+		}
+		filtered := slices.Clone(fields[:i])
+		for _, f := range fields[i+1:] {
+			if !f.omittable || !rv.FieldByIndex(f.index).IsZero() {
+				filtered = append(filtered, f)
+			}
 		}
+		fields = filtered
+		break
 	}
 
 	// Write the length as additional info
-	info := u64Bytes(uint64(len(indices)))
+	info := u64Bytes(uint64(len(fields)))
 	if err := e.write(additionalInfo(arrayMajorType, info)); err != nil {
 		return err
 	}
 
 	// Write each item
-	for i, idx := range indices {
+	for i, f := range fields {
 		// If the previous index was the same, skip, because FlatMarshaler
 		// already encoded all of its values. (The duplicate indices are just
 		// so the length of the indices slice matches the array size.)
-		if i > 0 && slices.Equal(idx, indices[i-1]) {
+		if i > 0 && slices.Equal(f.index, fields[i-1].index) {
 			continue
 		}
 
 		// Use FlatMarshaler, if available
-		if n, ok := flatN(field(idx)); ok {
-			rv := get(idx)
+		if n := f.flat; n > 0 {
+			rv := rv.FieldByIndex(f.index)
 			fm, ok := rv.Interface().(FlatMarshaler)
 			if !ok && rv.CanAddr() {
 				fm, ok = rv.Addr().Interface().(FlatMarshaler)
@@ -1318,13 +1333,13 @@ func (e *Encoder) encodeStruct(size int, get func([]int) reflect.Value, field fu
 				panic("struct field with cbor flat option must implement FlatMarshaler")
 			}
 			if err := fm.FlatMarshalCBOR(e.w); err != nil {
-				return fmt.Errorf("error encoding struct field %+v (flat %d): %w", idx, n, err)
+				return fmt.Errorf("error encoding struct field %+v (flat %d): %w", f.index, n, err)
 			}
 			continue
 		}
 
-		if err := e.Encode(get(idx).Interface()); err != nil {
-			return fmt.Errorf("error encoding struct field %+v: %w", idx, err)
+		if err := e.Encode(rv.FieldByIndex(f.index).Interface()); err != nil {
+			return fmt.Errorf("error encoding struct field %+v: %w", f.index, err)
 		}
 	}
 
@@ -1418,10 +1433,26 @@ type weightedField struct {
 	omittable bool
 }
 
+// structField is a field of a struct in encoding order.
+type structField struct {
+	index     []int
+	omittable bool
+	flat      int // number of items of a FlatMarshaler, or zero
+}
+
+// structFieldsCache maps a struct type to its []structField. Collecting the
+// fields of a struct type requires reflecting on each field and parsing its
+// tag, so it is done once per type.
+var structFieldsCache sync.Map
+
 // Handle weighting/skipping options in struct tags
-func fieldOrder(n int, field func(int) reflect.StructField) (indices [][]int, omittable func([]int) bool) {
+func fieldOrder(t reflect.Type) []structField {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.([]structField)
+	}
+
 	// Collect weights by struct tag and skip fields with "-"
-	fields := collectFieldWeights(nil, 0, n, field, nil)
+	fields := collectFieldWeights(nil, 0, t.NumField(), t.Field, nil)
 
 	// Use weights to order indices using the following algorithm:
 	//
@@ -1442,18 +1473,14 @@ func fieldOrder(n int, field func(int) reflect.StructField) (indices [][]int, om
 		return false // equal - allowed for FlatMarshaler fields which get encoded/decoded n times
 	})
 
-	// Strip weights, leaving only the ordered indices
-	for _, x := range fields {
-		indices = append(indices, x.index)
-	}
-	return indices, func(idx []int) bool {
-		for _, f := range fields {
-			if slices.Equal(f.index, idx) {
-				return f.omittable
-			}
-		}
-		return false
+	// Strip weights, leaving only the ordered fields
+	ordered := make([]structField, len(fields))
+	for i, f := range fields {
+		flat, _ := flatN(t.FieldByIndex(f.index))
+		ordered[i] = structField{index: f.index, omittable: f.omittable, flat: flat}
 	}
+	cached, _ := structFieldsCache.LoadOrStore(t, ordered)
+	return cached.([]structField)
 }
 
 func collectFieldWeights(parents []int, i, upper int, field func(int) reflect.StructField, fields []weightedField) []weightedField {
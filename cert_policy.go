@@ -0,0 +1,346 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// ErrCertRevoked indicates that a certificate in a chain has been revoked.
+var ErrCertRevoked = errors.New("certificate revoked")
+
+// ErrCertExpired indicates that a certificate in a chain is expired or not yet
+// valid.
+var ErrCertExpired = errors.New("certificate chain expired")
+
+// DeviceCertPolicy validates the device certificate chain of vouchers when
+// they are imported and when devices onboard.
+type DeviceCertPolicy struct {
+	// Roots are the trusted root certificates. If nil, the last certificate
+	// in the chain is implicitly trusted.
+	Roots *x509.CertPool
+
+	// ExtKeyUsages, if not empty, requires the device certificate to be valid
+	// for at least one of the extended key usages. Certificates without an
+	// extended key usage extension are valid for any usage.
+	ExtKeyUsages []x509.ExtKeyUsage
+
+	// PermittedSANs, if not empty, requires the device certificate to have
+	// at least one DNS, email, IP, or URI subject alternative name matching
+	// one of the patterns, using the syntax of path.Match.
+	PermittedSANs []string
+
+	// MaxChainLength, if positive, is the maximum number of certificates in
+	// the device certificate chain, including the device certificate.
+	MaxChainLength int
+
+	// Revocation, if set, is used to check each certificate in the chain,
+	// except the root, for revocation.
+	Revocation RevocationChecker
+
+	// ExpiryWarning, if positive, causes chains which expire within the
+	// duration to be logged and flagged with ExpiresSoon in the result, so
+	// that they may be replaced before devices can no longer onboard.
+	ExpiryWarning time.Duration
+
+	// AllowExpired, if not nil, decides whether a chain which is expired or
+	// not yet valid satisfies the policy. Device certificates are often
+	// issued with validity periods shorter than the service life of the
+	// device, so owners may choose to accept them. An allowed chain is
+	// otherwise verified as of the end (or start) of its validity period.
+	//
+	// If AllowExpired is nil, such chains fail the policy with
+	// ErrCertExpired.
+	AllowExpired func(ctx context.Context, ov *Voucher, validity CertChainValidity) bool
+
+	// ReportOnly causes chains which fail the policy to be logged and passed
+	// to acceptance hooks, such as TO2Server.VerifyVoucher, rather than
+	// rejected.
+	ReportOnly bool
+
+	// Clock, if not nil, is used in place of the system clock to check the
+	// validity period of chains.
+	Clock Clock
+
+	// ClockSkew is how far outside of its validity period a chain may be
+	// and still be considered valid, to tolerate clocks which are slightly
+	// wrong. Such chains are verified as of the nearest valid time.
+	ClockSkew time.Duration
+}
+
+// CertChainValidity is the validity period of a certificate chain, which is
+// the intersection of the validity periods of its certificates.
+type CertChainValidity struct {
+	NotBefore, NotAfter time.Time
+
+	// Subject of the certificate which expires first.
+	Subject string
+}
+
+// newCertChainValidity computes the validity period of a chain.
+func newCertChainValidity(chain []*x509.Certificate) CertChainValidity {
+	var v CertChainValidity
+	for i, cert := range chain {
+		if i == 0 || cert.NotBefore.After(v.NotBefore) {
+			v.NotBefore = cert.NotBefore
+		}
+		if i == 0 || cert.NotAfter.Before(v.NotAfter) {
+			v.NotAfter, v.Subject = cert.NotAfter, cert.Subject.String()
+		}
+	}
+	return v
+}
+
+// Valid reports whether every certificate of the chain is valid at the given
+// time.
+func (v CertChainValidity) Valid(at time.Time) bool {
+	return !at.Before(v.NotBefore) && !at.After(v.NotAfter)
+}
+
+// nearest returns the time within the validity period which is nearest to at.
+func (v CertChainValidity) nearest(at time.Time) time.Time {
+	switch {
+	case at.Before(v.NotBefore):
+		return v.NotBefore
+	case at.After(v.NotAfter):
+		return v.NotAfter
+	default:
+		return at
+	}
+}
+
+// withinSkew reports whether at is no further than skew outside of the
+// validity period.
+func (v CertChainValidity) withinSkew(at time.Time, skew time.Duration) bool {
+	d := v.nearest(at).Sub(at)
+	return v.Valid(v.nearest(at)) && d <= skew && -d <= skew
+}
+
+// DeviceCertResult is the result of validating the device certificate chain
+// of a voucher against a DeviceCertPolicy.
+type DeviceCertResult struct {
+	// Chain is the verified chain from the device certificate to a trusted
+	// root. It is nil if the chain could not be verified.
+	Chain []*x509.Certificate
+
+	// Validity is the validity period of the chain of the voucher.
+	Validity CertChainValidity
+
+	// Expired is true if the chain is expired or not yet valid, whether or
+	// not the policy allowed it.
+	Expired bool
+
+	// ExpiresSoon is true if the chain is valid but expires within the
+	// ExpiryWarning of the policy.
+	ExpiresSoon bool
+
+	// Err is nil if the chain satisfies the policy.
+	Err error
+}
+
+// Verify validates the device certificate chain of a voucher. Vouchers
+// without a device certificate chain, i.e. for devices with ECDAA keys, fail
+// the policy.
+func (p *DeviceCertPolicy) Verify(ctx context.Context, ov *Voucher) DeviceCertResult {
+	if ov.CertChain == nil || len(*ov.CertChain) == 0 {
+		return DeviceCertResult{Err: errors.New("voucher has no device certificate chain")}
+	}
+	chain := make([]*x509.Certificate, len(*ov.CertChain))
+	for i, cert := range *ov.CertChain {
+		chain[i] = (*x509.Certificate)(cert)
+	}
+	if p.MaxChainLength > 0 && len(chain) > p.MaxChainLength {
+		return DeviceCertResult{Err: fmt.Errorf("device certificate chain length %d exceeds maximum of %d", len(chain), p.MaxChainLength)}
+	}
+
+	// Check validity period, verifying allowed expired chains as of when
+	// they were last (or will first be) valid
+	var result DeviceCertResult
+	result.Validity = newCertChainValidity(chain)
+	now := clockNow(p.Clock)
+	verifyTime := now
+	if p.ClockSkew > 0 && result.Validity.withinSkew(now, p.ClockSkew) {
+		// Treat the clock as if it were not skewed
+		now = result.Validity.nearest(now)
+		verifyTime = now
+	}
+	switch {
+	case !result.Validity.Valid(now):
+		result.Expired = true
+		if p.AllowExpired == nil || !p.AllowExpired(ctx, ov, result.Validity) {
+			result.Err = fmt.Errorf("%w: %q is valid from %s to %s",
+				ErrCertExpired, result.Validity.Subject, result.Validity.NotBefore, result.Validity.NotAfter)
+			return result
+		}
+		slog.Warn("device certificate chain is expired or not yet valid, but allowed", "guid", ov.Header.Val.GUID,
+			"subject", result.Validity.Subject, "not before", result.Validity.NotBefore, "not after", result.Validity.NotAfter)
+		verifyTime = result.Validity.nearest(now)
+	case p.ExpiryWarning > 0 && now.Add(p.ExpiryWarning).After(result.Validity.NotAfter):
+		result.ExpiresSoon = true
+		slog.Warn("device certificate chain expires soon", "guid", ov.Header.Val.GUID,
+			"subject", result.Validity.Subject, "expires", result.Validity.NotAfter)
+	}
+
+	// Verify chain to a trusted root
+	roots, intermediates := p.Roots, x509.NewCertPool()
+	if roots == nil {
+		roots = x509.NewCertPool()
+		roots.AddCert(chain[len(chain)-1])
+	}
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	usages := p.ExtKeyUsages
+	if len(usages) == 0 {
+		usages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+	verified, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     usages,
+		CurrentTime:   verifyTime,
+	})
+	if err != nil {
+		result.Err = fmt.Errorf("%w: %w", ErrCryptoVerifyFailed, err)
+		return result
+	}
+	result.Chain = verified[0]
+
+	if len(p.PermittedSANs) > 0 && !p.sanPermitted(chain[0]) {
+		result.Err = errors.New("device certificate has no permitted subject alternative name")
+		return result
+	}
+
+	if p.Revocation != nil {
+		for i, cert := range result.Chain[:len(result.Chain)-1] {
+			if err := p.Revocation.CheckRevocation(ctx, cert, result.Chain[i+1]); err != nil {
+				result.Err = fmt.Errorf("revocation check of %q: %w", cert.Subject, err)
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func (p *DeviceCertPolicy) sanPermitted(cert *x509.Certificate) bool {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		for _, pattern := range p.PermittedSANs {
+			if ok, _ := path.Match(pattern, san); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckVoucher verifies the device certificate chain of a voucher, returning
+// an error if it fails the policy, unless ReportOnly is set, in which case the
+// failure is logged.
+func (p *DeviceCertPolicy) CheckVoucher(ctx context.Context, ov *Voucher) (DeviceCertResult, error) {
+	result := p.Verify(ctx, ov)
+	if result.Err == nil {
+		return result, nil
+	}
+	if p.ReportOnly {
+		slog.Warn("device certificate chain does not satisfy policy", "guid", ov.Header.Val.GUID, "error", result.Err)
+		return result, nil
+	}
+	return result, fmt.Errorf("device certificate chain of %x does not satisfy policy: %w", ov.Header.Val.GUID, result.Err)
+}
+
+// Context key to hold the DeviceCertResult for acceptance hooks.
+type deviceCertResultKey struct{}
+
+// DeviceCertResultFromContext returns the result of validating the device
+// certificate chain of the voucher being verified, when called from an
+// acceptance hook, such as TO2Server.VerifyVoucher, of a server configured
+// with a DeviceCertPolicy.
+func DeviceCertResultFromContext(ctx context.Context) (DeviceCertResult, bool) {
+	result, ok := ctx.Value(deviceCertResultKey{}).(DeviceCertResult)
+	return result, ok
+}
+
+func contextWithDeviceCertResult(parent context.Context, result DeviceCertResult) context.Context {
+	return context.WithValue(parent, deviceCertResultKey{}, result)
+}
+
+// RevocationChecker checks whether a certificate has been revoked, i.e. using
+// CRLs or OCSP.
+type RevocationChecker interface {
+	// CheckRevocation returns an error wrapping ErrCertRevoked if cert, which
+	// was issued by issuer, has been revoked. Other errors indicate that the
+	// revocation status could not be determined.
+	CheckRevocation(ctx context.Context, cert, issuer *x509.Certificate) error
+}
+
+// RevocationCheckerFunc is a function implementing RevocationChecker, i.e. to
+// query an OCSP responder.
+type RevocationCheckerFunc func(ctx context.Context, cert, issuer *x509.Certificate) error
+
+// CheckRevocation implements RevocationChecker.
+func (f RevocationCheckerFunc) CheckRevocation(ctx context.Context, cert, issuer *x509.Certificate) error {
+	return f(ctx, cert, issuer)
+}
+
+// CRLChecker checks certificates against certificate revocation lists.
+type CRLChecker struct {
+	// Lists are the revocation lists of one or more issuers. Each list must
+	// be signed by the issuer of the certificates it applies to.
+	Lists []*x509.RevocationList
+
+	// RequireList causes certificates whose issuer has no revocation list to
+	// fail the check.
+	RequireList bool
+
+	// Clock, if not nil, is used in place of the system clock to check
+	// whether revocation lists have expired.
+	Clock Clock
+
+	// ClockSkew is how long after its next update a revocation list is still
+	// used, to tolerate clocks which are slightly wrong.
+	ClockSkew time.Duration
+}
+
+// CheckRevocation implements RevocationChecker.
+func (c CRLChecker) CheckRevocation(_ context.Context, cert, issuer *x509.Certificate) error {
+	var found bool
+	for _, crl := range c.Lists {
+		if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("invalid revocation list of %q: %w", issuer.Subject, err)
+		}
+		if !crl.NextUpdate.IsZero() && clockNow(c.Clock).After(crl.NextUpdate.Add(c.ClockSkew)) {
+			return fmt.Errorf("revocation list of %q expired at %s", issuer.Subject, crl.NextUpdate)
+		}
+		found = true
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("%w: serial %s revoked at %s", ErrCertRevoked, cert.SerialNumber, entry.RevocationTime)
+			}
+		}
+	}
+	if !found && c.RequireList {
+		return fmt.Errorf("no revocation list for issuer %q", issuer.Subject)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestDeviceCertPolicy(t *testing.T) {
+	newCert := func(template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.NotBefore = time.Now().Add(-time.Minute)
+		template.NotAfter = time.Now().Add(time.Hour)
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		}
+	}
+	root, rootKey := newCert(ca(1, "Root"), nil, nil)
+	intermediate, intermediateKey := newCert(ca(2, "Intermediate"), root, rootKey)
+	device, _ := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "Device"},
+		DNSNames:     []string{"device-1.factory.example.com"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, intermediate, intermediateKey)
+
+	ov := &fdo.Voucher{CertChain: &[]*cbor.X509Certificate{
+		(*cbor.X509Certificate)(device),
+		(*cbor.X509Certificate)(intermediate),
+		(*cbor.X509Certificate)(root),
+	}}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	otherRoot, _ := newCert(ca(4, "Other Root"), nil, nil)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: device.SerialNumber, RevocationTime: time.Now()},
+		},
+	}, intermediate, intermediateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(crlDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		policy  fdo.DeviceCertPolicy
+		wantErr string
+	}{
+		{name: "implicit root"},
+		{name: "trusted root", policy: fdo.DeviceCertPolicy{
+			Roots:          roots,
+			ExtKeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			PermittedSANs:  []string{"*.factory.example.com"},
+			MaxChainLength: 3,
+			Revocation:     fdo.CRLChecker{},
+		}},
+		{name: "untrusted root", policy: fdo.DeviceCertPolicy{Roots: otherRoots}, wantErr: "unknown authority"},
+		{name: "chain too long", policy: fdo.DeviceCertPolicy{MaxChainLength: 2}, wantErr: "exceeds maximum"},
+		{name: "key usage", policy: fdo.DeviceCertPolicy{
+			ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, wantErr: "key usage"},
+		{name: "SAN", policy: fdo.DeviceCertPolicy{PermittedSANs: []string{"*.other.example.com"}}, wantErr: "subject alternative name"},
+		{name: "revoked", policy: fdo.DeviceCertPolicy{
+			Revocation: fdo.CRLChecker{Lists: []*x509.RevocationList{crl}},
+		}, wantErr: fdo.ErrCertRevoked.Error()},
+		{name: "missing CRL", policy: fdo.DeviceCertPolicy{
+			Revocation: fdo.CRLChecker{RequireList: true},
+		}, wantErr: "no revocation list"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.policy.Verify(context.Background(), ov)
+			switch {
+			case test.wantErr == "" && result.Err != nil:
+				t.Fatal(result.Err)
+			case test.wantErr == "":
+				if len(result.Chain) != 3 || !result.Chain[2].Equal(root) {
+					t.Fatalf("expected verified chain to end with root, got %d certificates", len(result.Chain))
+				}
+			case result.Err == nil || !strings.Contains(result.Err.Error(), test.wantErr):
+				t.Fatalf("expected error containing %q, got %v", test.wantErr, result.Err)
+			}
+
+			// Report-only policies never reject
+			if _, err := test.policy.CheckVoucher(context.Background(), ov); (err != nil) != (test.wantErr != "") {
+				t.Fatalf("unexpected check result: %v", err)
+			}
+			test.policy.ReportOnly = true
+			if _, err := test.policy.CheckVoucher(context.Background(), ov); err != nil {
+				t.Fatalf("report-only policy rejected voucher: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeviceCertPolicyExpiry(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfSigned := func(notBefore, notAfter time.Time) *fdo.Voucher {
+		t.Helper()
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "Device"},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &fdo.Voucher{CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(cert)}}
+	}
+	now := time.Now()
+	expired := selfSigned(now.Add(-2*time.Hour), now.Add(-time.Hour))
+	notYetValid := selfSigned(now.Add(time.Hour), now.Add(2*time.Hour))
+	expiring := selfSigned(now.Add(-time.Hour), now.Add(time.Hour))
+
+	// Expired and not yet valid chains fail by default
+	var policy fdo.DeviceCertPolicy
+	for _, ov := range []*fdo.Voucher{expired, notYetValid} {
+		result := policy.Verify(context.Background(), ov)
+		if !errors.Is(result.Err, fdo.ErrCertExpired) || !result.Expired {
+			t.Fatalf("expected expired chain to fail, got %+v", result)
+		}
+	}
+
+	// The hook may allow them, and is given the validity period
+	var validity fdo.CertChainValidity
+	policy.AllowExpired = func(_ context.Context, _ *fdo.Voucher, v fdo.CertChainValidity) bool {
+		validity = v
+		return true
+	}
+	for _, ov := range []*fdo.Voucher{expired, notYetValid} {
+		result := policy.Verify(context.Background(), ov)
+		if result.Err != nil || !result.Expired || len(result.Chain) != 1 {
+			t.Fatalf("expected allowed expired chain to pass, got %+v", result)
+		}
+		if !validity.NotAfter.Equal((*ov.CertChain)[0].NotAfter) || validity.Subject != "CN=Device" {
+			t.Fatalf("unexpected validity: %+v", validity)
+		}
+	}
+	policy.AllowExpired = func(context.Context, *fdo.Voucher, fdo.CertChainValidity) bool { return false }
+	if result := policy.Verify(context.Background(), expired); !errors.Is(result.Err, fdo.ErrCertExpired) {
+		t.Fatalf("expected rejected expired chain to fail, got %v", result.Err)
+	}
+
+	// Chains expiring within the warning period pass, but are flagged
+	policy.ExpiryWarning = 24 * time.Hour
+	if result := policy.Verify(context.Background(), expiring); result.Err != nil || result.Expired || !result.ExpiresSoon {
+		t.Fatalf("expected chain to be flagged as expiring soon, got %+v", result)
+	}
+	policy.ExpiryWarning = time.Minute
+	if result := policy.Verify(context.Background(), expiring); result.Err != nil || result.ExpiresSoon {
+		t.Fatalf("expected chain not to be flagged as expiring soon, got %+v", result)
+	}
+}
+
+func TestDeviceCertPolicyClockSkew(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := time.Now().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Device"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov := &fdo.Voucher{CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(cert)}}
+
+	// A clock which is behind fails without skew tolerance
+	policy := fdo.DeviceCertPolicy{
+		Clock: fdo.ClockFunc(func() time.Time { return notBefore.Add(-2 * time.Minute) }),
+	}
+	if result := policy.Verify(context.Background(), ov); !errors.Is(result.Err, fdo.ErrCertExpired) {
+		t.Fatalf("expected chain to be not yet valid, got %+v", result)
+	}
+
+	// Skew within the tolerance is accepted
+	policy.ClockSkew = 5 * time.Minute
+	if result := policy.Verify(context.Background(), ov); result.Err != nil || result.Expired {
+		t.Fatalf("expected skewed clock to be tolerated, got %+v", result)
+	}
+
+	// Skew beyond the tolerance is not
+	policy.Clock = fdo.ClockFunc(func() time.Time { return notBefore.Add(time.Hour + 10*time.Minute) })
+	if result := policy.Verify(context.Background(), ov); !errors.Is(result.Err, fdo.ErrCertExpired) {
+		t.Fatalf("expected chain to be expired, got %+v", result)
+	}
+
+	// A trusted clock corrects the time of the system clock
+	var clock fdo.TrustedClock
+	clock.Set(notBefore.Add(2 * time.Hour))
+	if !clock.Synced() || clock.Offset() < time.Hour {
+		t.Fatalf("expected clock to be set ahead, got offset %s", clock.Offset())
+	}
+	policy.Clock, policy.ClockSkew = &clock, 0
+	if result := policy.Verify(context.Background(), ov); !errors.Is(result.Err, fdo.ErrCertExpired) {
+		t.Fatalf("expected chain to be expired by the trusted time, got %+v", result)
+	}
+}
+
+func TestTO2WithDeviceCertPolicy(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+
+	// Device certificate chains of fdotest devices have a length of two
+	server.TO2.DeviceCertPolicy = &fdo.DeviceCertPolicy{MaxChainLength: 1}
+	if err := server.Onboard(t, dev, nil); err == nil || !strings.Contains(err.Error(), "exceeds maximum") {
+		t.Fatalf("expected device certificate policy to reject onboarding, got %v", err)
+	}
+
+	// Report-only results are passed to VerifyVoucher
+	server.TO2.DeviceCertPolicy.ReportOnly = true
+	var result fdo.DeviceCertResult
+	server.TO2.VerifyVoucher = func(ctx context.Context, _ fdo.Voucher) error {
+		var ok bool
+		if result, ok = fdo.DeviceCertResultFromContext(ctx); !ok {
+			return errors.New("missing device certificate result")
+		}
+		return nil
+	}
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+	if result.Err == nil || len(result.Chain) != 0 {
+		t.Fatalf("expected policy failure to be reported, got %+v", result)
+	}
+}
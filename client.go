@@ -0,0 +1,346 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// Client runs the device side of TO1 and TO2 using a single configuration.
+//
+// Clients should usually be created with [NewClient], which validates that
+// the configuration is complete and consistent. The struct may also be
+// constructed directly for advanced use, in which case no validation occurs
+// until the protocol runs.
+type Client struct {
+	// Transport used for all protocol messages.
+	Transport Transport
+
+	// Store is the credential store set by [WithCredentialStore], if any. If
+	// it is an [ActivityStore], then a successful TO2 which reuses the device
+	// credential marks it inactive.
+	Store CredentialStore
+
+	// TO2Config contains the device credential, secrets, and TO2 options.
+	// Cred, HmacSha256, HmacSha384, Key, and PSS are also used for TO1.
+	TO2Config
+}
+
+// ClientOption configures a Client created by [NewClient].
+type ClientOption func(*Client) error
+
+// NewClient creates a Client from the given options and validates it.
+//
+// A transport, device credential, HMAC-SHA256, and device key are required.
+// All other options have defaults: ECDH384 key exchange (ECDH256 for P-256
+// device keys), A256GCM encryption, and the default service info MTU.
+func NewClient(opts ...ClientOption) (*Client, error) {
+	var c Client
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Client) validate() error {
+	if c.Transport == nil {
+		return errors.New("client: transport is required")
+	}
+	if c.Cred.GUID == (protocol.GUID{}) {
+		return errors.New("client: device credential is required")
+	}
+	if c.HmacSha256 == nil && c.DeviceHmac == nil {
+		return errors.New("client: HMAC-SHA256 is required")
+	}
+	if c.Key == nil {
+		return errors.New("client: device key is required")
+	}
+
+	// Check key type and that HMAC-SHA384 is provided where required
+	switch pub := c.Key.Public().(type) {
+	case *ecdsa.PublicKey:
+		if c.PSS {
+			return errors.New("client: RSA-PSS requires an RSA device key")
+		}
+		switch pub.Curve {
+		case elliptic.P256():
+			if c.KeyExchange == "" {
+				c.KeyExchange = kex.ECDH256Suite
+			}
+		case elliptic.P384():
+			if c.HmacSha384 == nil && c.DeviceHmac == nil {
+				return errors.New("client: HMAC-SHA384 is required for a P-384 device key")
+			}
+		default:
+			return fmt.Errorf("client: unsupported device key curve %s", pub.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		switch pub.Size() {
+		case 2048 / 8:
+		case 3072 / 8:
+			if c.HmacSha384 == nil && c.DeviceHmac == nil {
+				return errors.New("client: HMAC-SHA384 is required for an RSA 3072 device key")
+			}
+		default:
+			return fmt.Errorf("client: unsupported RSA device key size %d", pub.Size()*8)
+		}
+	default:
+		return fmt.Errorf("client: unsupported device key type %T", pub)
+	}
+
+	// Apply remaining defaults and check that suites are supported
+	if c.KeyExchange == "" {
+		c.KeyExchange = kex.ECDH384Suite
+	}
+	if c.CipherSuite == 0 {
+		c.CipherSuite = kex.A256GcmCipher
+	}
+	if !kex.Available(c.KeyExchange, c.CipherSuite) {
+		return fmt.Errorf("client: unsupported key exchange %q with cipher suite %d", c.KeyExchange, c.CipherSuite)
+	}
+	if c.KeyProvider != nil && c.KeyExchange != kex.ECDH256Suite && c.KeyExchange != kex.ECDH384Suite {
+		return errors.New("client: key provider requires ECDH256 or ECDH384 key exchange")
+	}
+
+	return nil
+}
+
+// TO1 runs the TO1 protocol. See [TO1].
+func (c *Client) TO1(ctx context.Context) (*cose.Sign1[protocol.To1d, []byte], error) {
+	return TO1(ctx, c.Transport, c.Cred, c.Key, &TO1Options{PSS: c.PSS, Hooks: c.Hooks})
+}
+
+// TO2 runs the TO2 protocol. See [TO2].
+//
+// The replacement credential is not persisted, but it is returned for the
+// caller to stage and commit. If the client has an [ActivityStore], then
+// committing the replacement marks it inactive, so the store is not changed
+// until the caller commits. If the owner reuses the credential, then there is
+// no replacement and the device credential is marked inactive after TO2
+// succeeds, unless VerifyOnly is set.
+func (c *Client) TO2(ctx context.Context, to1d *cose.Sign1[protocol.To1d, []byte]) (*DeviceCredential, error) {
+	cred, err := TO2(ctx, c.Transport, to1d, c.TO2Config)
+	if err != nil || cred != nil || c.VerifyOnly {
+		return cred, err
+	}
+	if store, ok := c.Store.(ActivityStore); ok {
+		if err := store.SetActive(false); err != nil {
+			return cred, fmt.Errorf("client: error marking device credential inactive: %w", err)
+		}
+	}
+	return cred, nil
+}
+
+// Active reports whether the device credential is active, meaning the device
+// should onboard. It requires a credential store implementing
+// [ActivityStore].
+func (c *Client) Active() (bool, error) {
+	store, ok := c.Store.(ActivityStore)
+	if !ok {
+		return false, errors.New("client: credential store does not persist active state")
+	}
+	return store.Active()
+}
+
+// SetActive marks the device credential active or inactive. Setting it active
+// after onboarding causes the device to onboard again on its next boot. It
+// requires a credential store implementing [ActivityStore].
+func (c *Client) SetActive(active bool) error {
+	store, ok := c.Store.(ActivityStore)
+	if !ok {
+		return errors.New("client: credential store does not persist active state")
+	}
+	return store.SetActive(active)
+}
+
+// WithTransport sets the transport used for all protocol messages.
+func WithTransport(transport Transport) ClientOption {
+	return func(c *Client) error {
+		if transport == nil {
+			return errors.New("client: transport must not be nil")
+		}
+		c.Transport = transport
+		return nil
+	}
+}
+
+// WithCredential sets the device credential.
+func WithCredential(cred DeviceCredential) ClientOption {
+	return func(c *Client) error {
+		c.Cred = cred
+		return nil
+	}
+}
+
+// WithHmac sets the HMAC-SHA256 and optional HMAC-SHA384 hashes keyed with the
+// device secret.
+func WithHmac(hmacSha256, hmacSha384 hash.Hash) ClientOption {
+	return func(c *Client) error {
+		if hmacSha256 == nil {
+			return errors.New("client: HMAC-SHA256 must not be nil")
+		}
+		c.HmacSha256, c.HmacSha384 = hmacSha256, hmacSha384
+		return nil
+	}
+}
+
+// WithDeviceHmac sets an external source of HMACs keyed with the device
+// secret, such as an HSM service, in place of WithHmac.
+func WithDeviceHmac(h DeviceHmac) ClientOption {
+	return func(c *Client) error {
+		if h == nil {
+			return errors.New("client: device HMAC must not be nil")
+		}
+		c.DeviceHmac = h
+		return nil
+	}
+}
+
+// WithKey sets the device key.
+func WithKey(key crypto.Signer) ClientOption {
+	return func(c *Client) error {
+		if key == nil {
+			return errors.New("client: device key must not be nil")
+		}
+		c.Key = key
+		return nil
+	}
+}
+
+// WithCredentialStore sets the device credential, HMACs, and device key from
+// a credential store. The store is retained, see [Client.Store].
+func WithCredentialStore(store CredentialStore) ClientOption {
+	return func(c *Client) error {
+		cred, err := store.Read()
+		if err != nil {
+			return fmt.Errorf("client: %w", err)
+		}
+		hmacSha256, hmacSha384, err := store.HMACs()
+		if err != nil {
+			return fmt.Errorf("client: %w", err)
+		}
+		key, err := store.Signer()
+		if err != nil {
+			return fmt.Errorf("client: %w", err)
+		}
+		c.Store, c.Cred, c.HmacSha256, c.HmacSha384, c.Key = store, *cred, hmacSha256, hmacSha384, key
+		return nil
+	}
+}
+
+// WithPSS uses RSA-PSS for signing with an RSA device key.
+func WithPSS() ClientOption {
+	return func(c *Client) error {
+		c.PSS = true
+		return nil
+	}
+}
+
+// WithKeyExchange selects the key exchange and cipher suites for TO2.
+func WithKeyExchange(suite kex.Suite, cipher kex.CipherSuiteID) ClientOption {
+	return func(c *Client) error {
+		if !kex.Available(suite, cipher) {
+			return fmt.Errorf("client: unsupported key exchange %q with cipher suite %d", suite, cipher)
+		}
+		c.KeyExchange, c.CipherSuite = suite, cipher
+		return nil
+	}
+}
+
+// WithKeyProvider performs ECDH key exchange with a hardware-backed key.
+func WithKeyProvider(provider kex.KeyProvider) ClientOption {
+	return func(c *Client) error {
+		c.KeyProvider = provider
+		return nil
+	}
+}
+
+// WithDevmod sets the devmod messages sent in TO2.
+func WithDevmod(devmod serviceinfo.Devmod) ClientOption {
+	return func(c *Client) error {
+		c.Devmod = devmod
+		return nil
+	}
+}
+
+// WithDeviceModule adds a service info module. It may be used more than once.
+func WithDeviceModule(name string, module serviceinfo.DeviceModule) ClientOption {
+	return WithDeviceModuleInfo(name, module, serviceinfo.ModuleInfo{})
+}
+
+// WithDeviceModuleInfo adds a service info module with its capabilities. It
+// may be used more than once.
+func WithDeviceModuleInfo(name string, module serviceinfo.DeviceModule, info serviceinfo.ModuleInfo) ClientOption {
+	return func(c *Client) error {
+		if c.DeviceModules == nil {
+			c.DeviceModules = new(serviceinfo.Registry)
+		}
+		if err := c.DeviceModules.Register(name, module, info); err != nil {
+			return fmt.Errorf("client: %w", err)
+		}
+		return nil
+	}
+}
+
+// WithMaxServiceInfoSizeReceive sets the service info MTU to request from the
+// owner service. If unset or zero, serviceinfo.DefaultMTU is used.
+func WithMaxServiceInfoSizeReceive(size uint16) ClientOption {
+	return func(c *Client) error {
+		c.MaxServiceInfoSizeReceive = size
+		return nil
+	}
+}
+
+// WithCredentialReuse allows the Credential Reuse Protocol.
+func WithCredentialReuse() ClientOption {
+	return func(c *Client) error {
+		c.AllowCredentialReuse = true
+		return nil
+	}
+}
+
+// WithVerifyOnly stops TO2 after owner attestation, before the device
+// credential is replaced or service info is exchanged. See
+// [TO2Config.VerifyOnly].
+func WithVerifyOnly() ClientOption {
+	return func(c *Client) error {
+		c.VerifyOnly = true
+		return nil
+	}
+}
+
+// WithHooks sets callbacks for onboarding progress and errors.
+func WithHooks(hooks ClientHooks) ClientOption {
+	return func(c *Client) error {
+		c.Hooks = hooks
+		return nil
+	}
+}
+
+// WithRand sets the source of randomness for nonces and key exchange
+// parameters. It should only be used for testing.
+func WithRand(r io.Reader) ClientOption {
+	return func(c *Client) error {
+		c.Rand = r
+		return nil
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/blob"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/loopback"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+type nopTransport struct{}
+
+func (nopTransport) Send(context.Context, uint8, any, kex.Session) (uint8, io.ReadCloser, error) {
+	return 0, nil, io.EOF
+}
+
+func TestNewClient(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	cred := fdo.DeviceCredential{Version: 101, GUID: protocol.GUID{1}}
+	required := []fdo.ClientOption{
+		fdo.WithTransport(nopTransport{}),
+		fdo.WithCredential(cred),
+		fdo.WithHmac(hmac.New(sha256.New, secret), nil),
+		fdo.WithKey(p256),
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		c, err := fdo.NewClient(required...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.KeyExchange != kex.ECDH256Suite {
+			t.Errorf("expected ECDH256 default for P-256 key, got %s", c.KeyExchange)
+		}
+		if c.CipherSuite != kex.A256GcmCipher {
+			t.Errorf("expected A256GCM default, got %d", c.CipherSuite)
+		}
+	})
+
+	for _, test := range []struct {
+		name string
+		opts []fdo.ClientOption
+	}{
+		{name: "missing transport", opts: required[1:]},
+		{name: "missing credential", opts: []fdo.ClientOption{required[0], required[2], required[3]}},
+		{name: "missing key", opts: required[:3]},
+		{name: "nil key", opts: append(required[:3:3], fdo.WithKey(nil))},
+		{name: "PSS with EC key", opts: append(required[:4:4], fdo.WithPSS())},
+		{name: "P-384 without HMAC-SHA384", opts: append(required[:3:3], fdo.WithKey(p384))},
+		{name: "unknown key exchange", opts: append(required[:4:4], fdo.WithKeyExchange("ECDH521", kex.A256GcmCipher))},
+		{name: "nil module", opts: append(required[:4:4],
+			fdo.WithDeviceModule("fdo.example", nil),
+		)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := fdo.NewClient(test.opts...); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	t.Run("P-384", func(t *testing.T) {
+		c, err := fdo.NewClient(append(required[:2:2],
+			fdo.WithHmac(hmac.New(sha256.New, secret), hmac.New(sha512.New384, secret)),
+			fdo.WithKey(p384),
+		)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.KeyExchange != kex.ECDH384Suite {
+			t.Errorf("expected ECDH384 default for P-384 key, got %s", c.KeyExchange)
+		}
+	})
+}
+
+func TestClientTO2ActiveState(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	store := blob.NewFileStore(filepath.Join(t.TempDir(), "cred.bin"))
+	if err := store.Save(&blob.DeviceCredential{
+		Active:           true,
+		DeviceCredential: dev.Cred,
+		HmacSecret:       dev.HmacSecret,
+		PrivateKey:       blob.Pkcs8Key{Signer: dev.Key},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := fdo.NewClient(
+		fdo.WithTransport(&loopback.Transport{Tokens: server.State, TO2Responder: server.TO2}),
+		fdo.WithCredentialStore(store),
+		fdo.WithKeyExchange(kex.ECDH256Suite, kex.A128GcmCipher),
+		fdo.WithDevmod(serviceinfo.Devmod{
+			Os:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Version: "go-fdo test",
+			Device:  "go-validation",
+			FileSep: ";",
+			Bin:     runtime.GOARCH,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCred, err := client.TO2(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCred == nil {
+		t.Fatal("expected replacement credential")
+	}
+
+	// The store is unchanged until the replacement is committed, so that a
+	// failure before then leaves the device to onboard again
+	if active, err := store.Active(); err != nil {
+		t.Fatal(err)
+	} else if !active {
+		t.Fatal("expected credential to remain active until the replacement is committed")
+	}
+	if err := store.Stage(*newCred); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if active, err := store.Active(); err != nil {
+		t.Fatal(err)
+	} else if active {
+		t.Fatal("expected committed replacement to be inactive")
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is a source of the current time. It allows time-dependent checks and
+// timestamps to use a time other than that of the system clock, such as on
+// devices without a real-time clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function implementing Clock.
+type ClockFunc func() time.Time
+
+// Now implements Clock.
+func (f ClockFunc) Now() time.Time { return f() }
+
+// clockNow returns the time of a clock, or of the system clock if c is nil.
+func clockNow(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// TrustedClock is a Clock which follows the system clock, offset by the
+// difference to the time it was last set to from a trusted source, such as
+// the time FSIM of the owner service. It is safe for concurrent use and its
+// zero value follows the system clock.
+type TrustedClock struct {
+	mu     sync.RWMutex
+	offset time.Duration
+	synced bool
+}
+
+// Now implements Clock.
+func (c *TrustedClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Now().Add(c.offset)
+}
+
+// Set adjusts the clock to a trusted time.
+func (c *TrustedClock) Set(trusted time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset = time.Until(trusted)
+	c.synced = true
+}
+
+// Offset returns how far the system clock is behind the trusted time, or
+// zero if the clock has not been set.
+func (c *TrustedClock) Offset() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offset
+}
+
+// Synced reports whether the clock has been set from a trusted time.
+func (c *TrustedClock) Synced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.synced
+}
+
+type clockKey struct{}
+
+// contextWithClock sets the clock used for timestamps of a protocol run, such
+// as of error messages. A nil clock is not set.
+func contextWithClock(parent context.Context, c Clock) context.Context {
+	if c == nil {
+		return parent
+	}
+	return context.WithValue(parent, clockKey{}, c)
+}
+
+// nowFromContext returns the time of the clock set with contextWithClock, or
+// of the system clock if none was set.
+func nowFromContext(ctx context.Context) time.Time {
+	c, _ := ctx.Value(clockKey{}).(Clock)
+	return clockNow(c)
+}
@@ -0,0 +1,289 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// CompressionModuleName is the name of the vendor service info module used to
+// negotiate compression of service info.
+//
+// When both the device and owner service are configured with compression
+// algorithms, the owner service activates the module and sends its
+// algorithms in order of preference with an "algorithms" message. The device
+// responds with the first one it supports in an "algorithm" message, or an
+// empty string if none. Once an algorithm is selected, service info of all
+// other modules is compressed per KV whenever doing so makes it smaller.
+const CompressionModuleName = "go-fdo.compression"
+
+// compressedMessageName is the message of CompressionModuleName which wraps a
+// compressed service info KV.
+const compressedMessageName = "compressed"
+
+// ServiceInfoCompression is a compression algorithm for service info values.
+// Algorithms other than DeflateCompression, such as zstd, may be provided by
+// implementing this type.
+type ServiceInfoCompression struct {
+	// Name identifies the algorithm in negotiation, i.e. "deflate".
+	Name string
+
+	// Compress returns the compressed data.
+	Compress func(data []byte) ([]byte, error)
+
+	// Decompress returns the decompressed data. It must fail if the result
+	// would be larger than maxSize bytes.
+	Decompress func(data []byte, maxSize int) ([]byte, error)
+}
+
+// DeflateCompression compresses service info with DEFLATE (RFC 1951).
+var DeflateCompression = ServiceInfoCompression{
+	Name: "deflate",
+	Compress: func(data []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	},
+	Decompress: func(data []byte, maxSize int) ([]byte, error) {
+		r := flate.NewReader(bytes.NewReader(data))
+		defer func() { _ = r.Close() }()
+		out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxSize {
+			return nil, fmt.Errorf("decompressed size exceeds %d bytes", maxSize)
+		}
+		return out, nil
+	},
+}
+
+// compressedKV is the value of a compressed service info KV.
+type compressedKV struct {
+	Algorithm string
+	Key       string
+	Val       []byte
+}
+
+// serviceInfoCompressor compresses sent service info once an algorithm has
+// been selected and decompresses received service info with any supported
+// algorithm. A nil compressor passes service info through unchanged.
+type serviceInfoCompressor struct {
+	supported []ServiceInfoCompression
+
+	mu       sync.Mutex
+	selected *ServiceInfoCompression
+}
+
+func newServiceInfoCompressor(supported []ServiceInfoCompression) *serviceInfoCompressor {
+	if len(supported) == 0 {
+		return nil
+	}
+	return &serviceInfoCompressor{supported: supported}
+}
+
+func (c *serviceInfoCompressor) names() []string {
+	names := make([]string, len(c.supported))
+	for i, alg := range c.supported {
+		names[i] = alg.Name
+	}
+	return names
+}
+
+func (c *serviceInfoCompressor) lookup(name string) (*ServiceInfoCompression, bool) {
+	i := slices.IndexFunc(c.supported, func(alg ServiceInfoCompression) bool { return alg.Name == name })
+	if i == -1 {
+		return nil, false
+	}
+	return &c.supported[i], true
+}
+
+func (c *serviceInfoCompressor) selectAlgorithm(alg *ServiceInfoCompression) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.selected = alg
+}
+
+// compress returns the KV wrapped in a compressed KV if an algorithm has been
+// selected and compression makes it smaller.
+func (c *serviceInfoCompressor) compress(kv *serviceinfo.KV) (*serviceinfo.KV, error) {
+	if c == nil || strings.HasPrefix(kv.Key, CompressionModuleName+":") {
+		return kv, nil
+	}
+	c.mu.Lock()
+	alg := c.selected
+	c.mu.Unlock()
+	if alg == nil {
+		return kv, nil
+	}
+
+	compressed, err := alg.Compress(kv.Val)
+	if err != nil {
+		return nil, fmt.Errorf("error compressing service info %q with %s: %w", kv.Key, alg.Name, err)
+	}
+	val, err := cbor.Marshal(compressedKV{Algorithm: alg.Name, Key: kv.Key, Val: compressed})
+	if err != nil {
+		return nil, err
+	}
+	if len(val) > math.MaxUint16 {
+		return kv, nil
+	}
+	wrapped := &serviceinfo.KV{Key: CompressionModuleName + ":" + compressedMessageName, Val: val}
+	if wrapped.Size() >= kv.Size() {
+		return kv, nil
+	}
+	return wrapped, nil
+}
+
+// compressAll applies compress to each KV.
+func (c *serviceInfoCompressor) compressAll(kvs []*serviceinfo.KV) ([]*serviceinfo.KV, error) {
+	if c == nil {
+		return kvs, nil
+	}
+	out := make([]*serviceinfo.KV, len(kvs))
+	for i, kv := range kvs {
+		var err error
+		if out[i], err = c.compress(kv); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// decompress unwraps a compressed KV. Other KVs are returned unchanged.
+func (c *serviceInfoCompressor) decompress(kv *serviceinfo.KV) (*serviceinfo.KV, error) {
+	if c == nil || kv.Key != CompressionModuleName+":"+compressedMessageName {
+		return kv, nil
+	}
+	var wrapped compressedKV
+	if err := cbor.Unmarshal(kv.Val, &wrapped); err != nil {
+		return nil, fmt.Errorf("error decoding compressed service info: %w", err)
+	}
+	alg, ok := c.lookup(wrapped.Algorithm)
+	if !ok {
+		return nil, fmt.Errorf("service info compressed with unsupported algorithm %q", wrapped.Algorithm)
+	}
+	val, err := alg.Decompress(wrapped.Val, math.MaxUint16)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing service info %q with %s: %w", wrapped.Key, alg.Name, err)
+	}
+	return &serviceinfo.KV{Key: wrapped.Key, Val: val}, nil
+}
+
+// decompressAll applies decompress to each KV.
+func (c *serviceInfoCompressor) decompressAll(kvs []*serviceinfo.KV) ([]*serviceinfo.KV, error) {
+	if c == nil {
+		return kvs, nil
+	}
+	out := make([]*serviceinfo.KV, len(kvs))
+	for i, kv := range kvs {
+		var err error
+		if out[i], err = c.decompress(kv); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// compressionOwnerModule offers compression algorithms to the device.
+type compressionOwnerModule struct {
+	compressor *serviceInfoCompressor
+	offered    bool
+}
+
+func (m *compressionOwnerModule) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var ignore bool
+		return cbor.NewDecoder(messageBody).Decode(&ignore)
+	case "algorithm":
+		var name string
+		if err := cbor.NewDecoder(messageBody).Decode(&name); err != nil {
+			return err
+		}
+		if name == "" {
+			return nil
+		}
+		alg, ok := m.compressor.lookup(name)
+		if !ok {
+			return fmt.Errorf("device selected compression algorithm %q which was not offered", name)
+		}
+		m.compressor.selectAlgorithm(alg)
+		return nil
+	default:
+		return fmt.Errorf("unknown %s message name: %s", CompressionModuleName, messageName)
+	}
+}
+
+func (m *compressionOwnerModule) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (bool, bool, error) {
+	if m.offered {
+		return false, true, nil
+	}
+	m.offered = true
+
+	if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+		return false, false, err
+	}
+	algorithms, err := cbor.Marshal(m.compressor.names())
+	if err != nil {
+		return false, false, err
+	}
+	if err := producer.WriteChunk("algorithms", algorithms); err != nil {
+		return false, false, err
+	}
+	return false, false, nil
+}
+
+// compressionDeviceModule selects a compression algorithm offered by the owner
+// service.
+type compressionDeviceModule struct {
+	compressor *serviceInfoCompressor
+}
+
+func (m *compressionDeviceModule) Transition(bool) error { return nil }
+
+func (m *compressionDeviceModule) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "algorithms" {
+		_, _ = io.Copy(io.Discard, messageBody)
+		return fmt.Errorf("unknown %s message name: %s", CompressionModuleName, messageName)
+	}
+	var offered []string
+	if err := cbor.NewDecoder(messageBody).Decode(&offered); err != nil {
+		return err
+	}
+
+	var selected string
+	for _, name := range offered {
+		if alg, ok := m.compressor.lookup(name); ok {
+			selected = name
+			m.compressor.selectAlgorithm(alg)
+			break
+		}
+	}
+	return cbor.NewEncoder(respond("algorithm")).Encode(selected)
+}
+
+func (m *compressionDeviceModule) Yield(context.Context, func(string) io.Writer, func()) error {
+	return nil
+}
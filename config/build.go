@@ -0,0 +1,517 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package config
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// Servers are the servers built from a configuration. Servers for sections
+// which are not set are nil.
+type Servers struct {
+	State   *sqlite.DB
+	Handler *transport.Handler
+
+	DI  *fdo.DIServer[custom.DeviceMfgInfo]
+	TO0 *fdo.TO0Server
+	TO1 *fdo.TO1Server
+	TO2 *fdo.TO2Server
+
+	// RvInfo is given to devices in DI and TO2Addrs are the owner service
+	// addresses to register with rendezvous servers.
+	RvInfo   [][]protocol.RvInstruction
+	TO2Addrs []protocol.RvTO2Addr
+}
+
+// Close closes the database.
+func (s *Servers) Close() error { return s.State.Close() }
+
+// Build opens the database, adds configured keys to it, and creates the
+// servers of each configured section. The configuration should already be
+// validated.
+func (c *Config) Build() (_ *Servers, err error) {
+	rvInfo, err := c.rvInfo()
+	if err != nil {
+		return nil, err
+	}
+	to2Addrs, err := c.to2Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var trustedProxies []netip.Prefix
+	for _, proxy := range c.TrustedProxies {
+		prefix, err := parseTrustedProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+
+	state, err := sqlite.Open(c.Database.Path, c.Database.Password, c.Database.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = state.Close()
+		}
+	}()
+	state.SessionLockTTL = time.Duration(c.Database.SessionLockTTL)
+	state.SlowQueryThreshold = time.Duration(c.Database.SlowQueryThreshold)
+
+	if err := c.addKeys(state); err != nil {
+		return nil, err
+	}
+
+	s := &Servers{
+		State:    state,
+		Handler:  &transport.Handler{Tokens: state, TrustedProxies: trustedProxies, RateLimit: c.RateLimit.limiter()},
+		RvInfo:   rvInfo,
+		TO2Addrs: to2Addrs,
+	}
+	if c.DI != nil {
+		s.DI = c.DI.build(state, rvInfo, to2Addrs)
+		s.Handler.DIResponder = s.DI
+	}
+	if c.Rendezvous != nil {
+		s.TO0, s.TO1 = c.Rendezvous.build(state)
+		s.Handler.TO0Responder, s.Handler.TO1Responder = s.TO0, s.TO1
+	}
+	if c.Owner != nil {
+		if s.TO2, err = c.Owner.build(state, rvInfo); err != nil {
+			return nil, err
+		}
+		s.Handler.TO2Responder = s.TO2
+	}
+	if ttl := time.Duration(c.Database.NonceTTL); ttl > 0 {
+		if s.TO0 != nil {
+			s.TO0.Nonces, s.TO0.NonceTTL = state, ttl
+			s.TO1.Nonces, s.TO1.NonceTTL = state, ttl
+		}
+		if s.TO2 != nil {
+			s.TO2.Nonces, s.TO2.NonceTTL = state, ttl
+		}
+	}
+	return s, nil
+}
+
+func (di *DI) build(state *sqlite.DB, rvInfo [][]protocol.RvInstruction, to2Addrs []protocol.RvTO2Addr) *fdo.DIServer[custom.DeviceMfgInfo] {
+	server := &fdo.DIServer[custom.DeviceMfgInfo]{
+		Session:               state,
+		Vouchers:              state,
+		SignDeviceCertificate: custom.SignDeviceCertificate(state),
+		DeviceInfo: func(_ context.Context, info *custom.DeviceMfgInfo, _ []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error) {
+			return info.DeviceInfo, info.KeyType, info.KeyEncoding, nil
+		},
+		RvInfo:       func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) { return rvInfo, nil },
+		DeviceStatus: state,
+	}
+	if di.AutoExtend {
+		server.AutoExtend = state
+	}
+	if di.AutoTO0 {
+		server.AutoTO0, server.AutoTO0Addrs = state, to2Addrs
+	}
+	return server
+}
+
+func (rv *Rendezvous) build(state *sqlite.DB) (*fdo.TO0Server, *fdo.TO1Server) {
+	to0 := &fdo.TO0Server{
+		Session:      state,
+		RVBlobs:      state,
+		DeviceStatus: state,
+	}
+	if maxTTL := uint32(time.Duration(rv.MaxTTL) / time.Second); maxTTL > 0 {
+		to0.NegotiateTTL = func(requestedSeconds uint32, _ fdo.Voucher) uint32 {
+			return min(requestedSeconds, maxTTL)
+		}
+	}
+	to1 := &fdo.TO1Server{
+		Session:      state,
+		RVBlobs:      state,
+		DeviceStatus: state,
+	}
+	if rv.CacheSize > 0 {
+		cache := &fdo.RVBlobCache{Size: rv.CacheSize, TTL: time.Duration(rv.CacheTTL)}
+		to0.Cache, to1.Cache = cache, cache
+	}
+	return to0, to1
+}
+
+func (o *Owner) build(state *sqlite.DB, rvInfo [][]protocol.RvInstruction) (*fdo.TO2Server, error) {
+	profiles, err := o.profiles()
+	if err != nil {
+		return nil, err
+	}
+	reonboarding, err := parseReonboardPolicy(o.Reonboarding)
+	if err != nil {
+		return nil, err
+	}
+	server := &fdo.TO2Server{
+		Session:           state,
+		Vouchers:          state,
+		OwnerKeys:         state,
+		RvInfo:            func(context.Context, fdo.Voucher) ([][]protocol.RvInstruction, error) { return rvInfo, nil },
+		ReuseCredential:   func(context.Context, fdo.Voucher) bool { return o.ReuseCredential },
+		DenyList:          state,
+		History:           state,
+		HelloDevices:      state,
+		Reonboarding:      reonboarding,
+		Devmods:           state,
+		DeviceStatus:      state,
+		ModuleState:       state,
+		InterleaveModules: o.InterleaveModules,
+		MaxSessions:       o.MaxSessions,
+		SessionTimeout:    time.Duration(o.SessionTimeout),
+	}
+	if len(profiles) > 0 {
+		server.ServiceInfo = profiles
+	}
+	return server, nil
+}
+
+func (o *Owner) profiles() (fsim.ServiceInfoProfiles, error) {
+	profiles := make(fsim.ServiceInfoProfiles, len(o.Profiles))
+	for i, p := range o.Profiles {
+		profile := fsim.ServiceInfoProfile{
+			Name:       p.Name,
+			DeviceInfo: p.DeviceInfo,
+			OS:         p.OS,
+			Arch:       p.Arch,
+		}
+		for _, s := range p.GUIDs {
+			guid, err := protocol.ParseGUID(s)
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+			}
+			profile.GUIDs = append(profile.GUIDs, guid)
+		}
+		for _, dl := range p.Downloads {
+			download := fsim.ProfileDownload{
+				Name:         dl.Name,
+				Path:         dl.Path,
+				MustDownload: dl.MustDownload,
+			}
+			if download.Name == "" {
+				download.Name = filepath.Base(dl.Path)
+			}
+			if dl.URL != "" {
+				if err := o.artifact(&download, dl.URL); err != nil {
+					return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+				}
+			}
+			profile.Downloads = append(profile.Downloads, download)
+		}
+		for _, wget := range p.Wgets {
+			u, err := url.Parse(wget.URL)
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: invalid wget URL: %w", p.Name, err)
+			}
+			checksum, err := hex.DecodeString(wget.Checksum)
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: invalid wget checksum: %w", p.Name, err)
+			}
+			name := wget.Name
+			if name == "" {
+				name = path.Base(u.Path)
+			}
+			profile.Wgets = append(profile.Wgets, fsim.ProfileWget{
+				Name:     name,
+				URL:      u,
+				Length:   wget.Length,
+				Checksum: checksum,
+			})
+		}
+		for _, cmd := range p.Commands {
+			profile.Commands = append(profile.Commands, fsim.ProfileCommand(cmd))
+		}
+		for _, up := range p.Uploads {
+			profile.Uploads = append(profile.Uploads, fsim.ProfileUpload(up))
+		}
+		profiles[i] = profile
+	}
+	return profiles, nil
+}
+
+// rvInfo encodes the configured rendezvous directives, defaulting to the
+// external address of this server.
+func (c *Config) rvInfo() ([][]protocol.RvInstruction, error) {
+	directives := c.RvInfo
+	if len(directives) == 0 {
+		host, port, err := c.extHostPort()
+		if err != nil {
+			return nil, fmt.Errorf("invalid external address: %w", err)
+		}
+		directive := RvDirective{DNS: host, DevicePort: port, OwnerPort: port}
+		if net.ParseIP(host) != nil {
+			directive.DNS, directive.IP = "", host
+		}
+		directives = []RvDirective{directive}
+	}
+
+	rvInfo := make([][]protocol.RvInstruction, len(directives))
+	for i, rv := range directives {
+		prot, err := parseRvProtocol(rv.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		var instructions []protocol.RvInstruction
+		add := func(v protocol.RvVar, val any) {
+			var data []byte
+			if val != nil {
+				if data, err = cbor.Marshal(val); err != nil {
+					panic("programming error - rendezvous value must be CBOR encodable: " + err.Error())
+				}
+			}
+			instructions = append(instructions, protocol.RvInstruction{Variable: v, Value: data})
+		}
+		if rv.DeviceOnly {
+			add(protocol.RVDevOnly, nil)
+		}
+		if rv.OwnerOnly {
+			add(protocol.RVOwnerOnly, nil)
+		}
+		add(protocol.RVProtocol, prot)
+		if rv.DNS != "" {
+			add(protocol.RVDns, rv.DNS)
+		}
+		if rv.IP != "" {
+			ip := net.ParseIP(rv.IP)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid rendezvous IP address %q", rv.IP)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			add(protocol.RVIPAddress, ip)
+		}
+		if rv.DevicePort != 0 {
+			add(protocol.RVDevPort, rv.DevicePort)
+		}
+		if rv.OwnerPort != 0 {
+			add(protocol.RVOwnerPort, rv.OwnerPort)
+		}
+		if rv.Delay > 0 {
+			add(protocol.RVDelaysec, uint32(time.Duration(rv.Delay)/time.Second))
+		}
+		if rv.Bypass {
+			add(protocol.RVBypass, nil)
+		}
+		rvInfo[i] = instructions
+	}
+	return rvInfo, nil
+}
+
+// to2Addrs returns the configured owner service addresses, defaulting to the
+// external address of this server.
+func (c *Config) to2Addrs() ([]protocol.RvTO2Addr, error) {
+	if c.Owner == nil {
+		return nil, nil
+	}
+	addrs := c.Owner.TO2Addrs
+	if len(addrs) == 0 {
+		host, port, err := c.extHostPort()
+		if err != nil {
+			return nil, fmt.Errorf("invalid external address: %w", err)
+		}
+		addrs = []TO2Addr{{DNS: host, Port: port}}
+	}
+
+	to2Addrs := make([]protocol.RvTO2Addr, len(addrs))
+	for i, addr := range addrs {
+		prot, err := parseTransportProtocol(addr.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		to2Addrs[i] = protocol.RvTO2Addr{Port: addr.Port, TransportProtocol: prot}
+		if addr.DNS != "" {
+			to2Addrs[i].DNSAddress = &addr.DNS
+		}
+		if addr.IP != "" {
+			ip := net.ParseIP(addr.IP)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid owner IP address %q", addr.IP)
+			}
+			to2Addrs[i].IPAddress = &ip
+		}
+	}
+	return to2Addrs, nil
+}
+
+// addKeys adds the configured manufacturer and owner keys to the database.
+func (c *Config) addKeys(state *sqlite.DB) error {
+	for name, path := range c.Keys.Manufacturer {
+		keyType, err := protocol.ParseKeyType(name)
+		if err != nil {
+			return err
+		}
+		key, chain, err := readKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("manufacturer key %s: %w", name, err)
+		}
+		if len(chain) == 0 {
+			return fmt.Errorf("manufacturer key %s: certificate chain required", name)
+		}
+		if err := state.AddManufacturerKey(keyType, key, chain); err != nil {
+			return fmt.Errorf("error adding manufacturer key %s: %w", name, err)
+		}
+	}
+	for name, path := range c.Keys.Owner {
+		keyType, err := protocol.ParseKeyType(name)
+		if err != nil {
+			return err
+		}
+		key, chain, err := readKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("owner key %s: %w", name, err)
+		}
+		if err := state.AddOwnerKey(keyType, key, chain); err != nil {
+			return fmt.Errorf("error adding owner key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// readKeyFile parses a PEM file containing a private key and any number of
+// certificates.
+func readKeyFile(path string) (crypto.Signer, []*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key any
+	var chain []*x509.Certificate
+	for {
+		var blk *pem.Block
+		blk, data = pem.Decode(data)
+		if blk == nil {
+			break
+		}
+		switch blk.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(blk.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(blk.Bytes)
+			chain = append(chain, cert)
+		default:
+			err = fmt.Errorf("unexpected PEM block type %q", blk.Type)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: no private key found", path)
+	}
+	return signer, chain, nil
+}
+
+// artifact configures a download to stream the artifact at an http, https,
+// or s3 URL.
+func (o *Owner) artifact(download *fsim.ProfileDownload, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid download URL: %w", err)
+	}
+	if download.Name == "" || download.Name == "." {
+		download.Name = path.Base(u.Path)
+	}
+	download.Digests = new(fsim.ArtifactDigests)
+
+	switch u.Scheme {
+	case "http", "https":
+		base := *u
+		base.Path, base.RawPath = path.Dir(u.Path), ""
+		download.Path = path.Base(u.Path)
+		download.Provider = &fsim.HTTPArtifacts{BaseURL: &base}
+
+	case "s3":
+		s3 := &fsim.S3Artifacts{
+			Region:          o.S3.Region,
+			Bucket:          u.Host,
+			AccessKeyID:     o.S3.AccessKeyID,
+			SecretAccessKey: o.S3.SecretAccessKey,
+			SessionToken:    o.S3.SessionToken,
+		}
+		if o.S3.Endpoint != "" {
+			if s3.Endpoint, err = url.Parse(o.S3.Endpoint); err != nil {
+				return fmt.Errorf("invalid S3 endpoint: %w", err)
+			}
+		}
+		download.Path = strings.TrimPrefix(u.Path, "/")
+		download.Provider = s3
+
+	default:
+		return fmt.Errorf("unsupported download URL scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// options returns the sqlite.Open options of the database configuration.
+func (db Database) options() []sqlite.Option {
+	var opts []sqlite.Option
+	if db.WAL {
+		opts = append(opts, sqlite.WithWAL())
+	}
+	if db.BusyTimeout > 0 {
+		opts = append(opts, sqlite.WithBusyTimeout(time.Duration(db.BusyTimeout)))
+	}
+	if db.Synchronous != "" {
+		opts = append(opts, sqlite.WithSynchronous(sqlite.Synchronous(strings.ToLower(db.Synchronous))))
+	}
+	if db.MaxOpenConns > 0 {
+		opts = append(opts, sqlite.WithMaxOpenConns(db.MaxOpenConns))
+	}
+	if db.ReadConns > 0 {
+		opts = append(opts, sqlite.WithReadPool(db.ReadConns))
+	}
+	return opts
+}
+
+// limiter returns the rate limiter of the handler, if any.
+func (r *RateLimit) limiter() *transport.RateLimiter {
+	if r == nil {
+		return nil
+	}
+	period, burst := time.Duration(r.Period), r.Burst
+	if period == 0 {
+		period = time.Second
+	}
+	if burst == 0 {
+		burst = r.Requests
+	}
+	return &transport.RateLimiter{
+		Rate:  float64(r.Requests) / period.Seconds(),
+		Burst: burst,
+	}
+}
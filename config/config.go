@@ -0,0 +1,440 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package config builds FDO servers from a configuration file, so that
+// deployments may change listen addresses, storage, keys, rendezvous info,
+// and service info without changing code.
+//
+// JSON, YAML, and TOML files are supported, chosen by the file extension.
+// Every field has json, yaml, and toml struct tags with the same name and
+// unknown fields are rejected in every format. Other formats may be added with
+// [RegisterFormat].
+//
+// After decoding, fields may be overridden by environment variables named by
+// [EnvPrefix] and the upper-cased path of the field, joined by underscores,
+// such as FDO_DATABASE_PASSWORD or FDO_OWNER_SESSION_TIMEOUT. Only string,
+// boolean, number, and duration fields may be overridden.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables overriding configuration
+// fields.
+const EnvPrefix = "FDO"
+
+// Config configures the FDO servers of a deployment. Each of the DI,
+// Rendezvous, and Owner sections enables its protocols when set.
+type Config struct {
+	// HTTP is the address to listen on. ExtHTTP is the address devices
+	// connect to, if different.
+	HTTP    string `json:"http" yaml:"http" toml:"http"`
+	ExtHTTP string `json:"ext_http" yaml:"ext_http" toml:"ext_http"`
+
+	// TrustedProxies are the IP addresses or CIDR networks of reverse
+	// proxies, such as those terminating TLS, whose X-Forwarded-For and
+	// X-Forwarded-Proto headers identify clients.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+
+	// RateLimit, if set, limits the rate of requests from each client, as
+	// identified through TrustedProxies.
+	RateLimit *RateLimit `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+
+	Database Database `json:"database" yaml:"database" toml:"database"`
+	Keys     Keys     `json:"keys" yaml:"keys" toml:"keys"`
+
+	// RvInfo is given to devices in DI and used to register with rendezvous
+	// servers.
+	RvInfo []RvDirective `json:"rv_info" yaml:"rv_info" toml:"rv_info"`
+
+	DI         *DI         `json:"di" yaml:"di" toml:"di"`
+	Rendezvous *Rendezvous `json:"rendezvous" yaml:"rendezvous" toml:"rendezvous"`
+	Owner      *Owner      `json:"owner" yaml:"owner" toml:"owner"`
+}
+
+// RateLimit allows each client Requests per Period, after a burst of up to
+// Burst requests. Period defaults to one second and Burst to Requests. See
+// http.RateLimiter.
+type RateLimit struct {
+	Requests int      `json:"requests" yaml:"requests" toml:"requests"`
+	Period   Duration `json:"period" yaml:"period" toml:"period"`
+	Burst    int      `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// Database configures the SQLite database storing all server state.
+type Database struct {
+	Path     string `json:"path" yaml:"path" toml:"path"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+
+	// SessionLockTTL is the lease duration of session locks used to serialize
+	// messages across replicas sharing the database.
+	SessionLockTTL Duration `json:"session_lock_ttl" yaml:"session_lock_ttl" toml:"session_lock_ttl"`
+
+	// NonceTTL, if set, stores the nonces issued by TO0, TO1, and TO2 in the
+	// database for the given duration, so that replicas sharing the
+	// database accept each nonce at most once.
+	NonceTTL Duration `json:"nonce_ttl" yaml:"nonce_ttl" toml:"nonce_ttl"`
+
+	// WAL enables write-ahead logging, which allows concurrent sessions to
+	// read while another writes.
+	WAL bool `json:"wal" yaml:"wal" toml:"wal"`
+
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// connection. If unset, sqlite.DefaultBusyTimeout is used.
+	BusyTimeout Duration `json:"busy_timeout" yaml:"busy_timeout" toml:"busy_timeout"`
+
+	// Synchronous is the synchronous pragma: off, normal, full, or extra.
+	Synchronous string `json:"synchronous" yaml:"synchronous" toml:"synchronous"`
+
+	// MaxOpenConns limits the number of pooled connections, if set.
+	MaxOpenConns int `json:"max_open_conns" yaml:"max_open_conns" toml:"max_open_conns"`
+
+	// ReadConns, if set, opens a separate pool of read-only connections for
+	// voucher and GUID lookups. It should be used with WAL.
+	ReadConns int `json:"read_conns" yaml:"read_conns" toml:"read_conns"`
+
+	// SlowQueryThreshold, if set, logs statements taking longer than the
+	// given duration.
+	SlowQueryThreshold Duration `json:"slow_query_threshold" yaml:"slow_query_threshold" toml:"slow_query_threshold"`
+}
+
+// Keys maps key type names, as parsed by protocol.ParseKeyType, to the paths
+// of PEM files containing a PKCS#8 private key and its certificate chain.
+//
+// Keys are added to the database only if it does not already have a key of
+// the same type.
+type Keys struct {
+	// Manufacturer keys sign device certificates and vouchers in DI. Their
+	// certificate chain is required.
+	Manufacturer map[string]string `json:"manufacturer" yaml:"manufacturer" toml:"manufacturer"`
+
+	// Owner keys prove ownership in TO0 and TO2. Their certificate chain is
+	// optional.
+	Owner map[string]string `json:"owner" yaml:"owner" toml:"owner"`
+}
+
+// RvDirective is a group of rendezvous instructions for devices and owners.
+type RvDirective struct {
+	// DNS or IP is the host of the rendezvous server.
+	DNS string `json:"dns" yaml:"dns" toml:"dns"`
+	IP  string `json:"ip" yaml:"ip" toml:"ip"`
+
+	// DevicePort and OwnerPort default to the port implied by the protocol.
+	DevicePort uint16 `json:"device_port" yaml:"device_port" toml:"device_port"`
+	OwnerPort  uint16 `json:"owner_port" yaml:"owner_port" toml:"owner_port"`
+
+	// Protocol is one of rest, http, https, tcp, tls, coap+tcp, or
+	// coap+udp. It defaults to http.
+	Protocol string `json:"protocol" yaml:"protocol" toml:"protocol"`
+
+	DeviceOnly bool     `json:"device_only" yaml:"device_only" toml:"device_only"`
+	OwnerOnly  bool     `json:"owner_only" yaml:"owner_only" toml:"owner_only"`
+	Bypass     bool     `json:"bypass" yaml:"bypass" toml:"bypass"`
+	Delay      Duration `json:"delay" yaml:"delay" toml:"delay"`
+}
+
+// DI configures the manufacturing server.
+type DI struct {
+	// AutoExtend extends vouchers to the owner key of the same type in the
+	// database when DI completes.
+	AutoExtend bool `json:"auto_extend" yaml:"auto_extend" toml:"auto_extend"`
+
+	// AutoTO0 registers rendezvous blobs for new devices in the database, for
+	// deployments which are also their own rendezvous server.
+	AutoTO0 bool `json:"auto_to0" yaml:"auto_to0" toml:"auto_to0"`
+}
+
+// Rendezvous configures the TO0 and TO1 servers.
+type Rendezvous struct {
+	// MaxTTL limits how long rendezvous blobs are kept, if set.
+	MaxTTL Duration `json:"max_ttl" yaml:"max_ttl" toml:"max_ttl"`
+
+	// CacheSize, if set, caches the rendezvous blobs of up to this many
+	// devices in memory for TO1, each for CacheTTL. See fdo.RVBlobCache.
+	CacheSize int      `json:"cache_size" yaml:"cache_size" toml:"cache_size"`
+	CacheTTL  Duration `json:"cache_ttl" yaml:"cache_ttl" toml:"cache_ttl"`
+}
+
+// Owner configures the TO2 server.
+type Owner struct {
+	// TO2Addrs are the addresses registered with rendezvous servers. They
+	// default to the external HTTP address.
+	TO2Addrs []TO2Addr `json:"to2_addrs" yaml:"to2_addrs" toml:"to2_addrs"`
+
+	ReuseCredential   bool     `json:"reuse_credential" yaml:"reuse_credential" toml:"reuse_credential"`
+	InterleaveModules bool     `json:"interleave_modules" yaml:"interleave_modules" toml:"interleave_modules"`
+	MaxSessions       int      `json:"max_sessions" yaml:"max_sessions" toml:"max_sessions"`
+	SessionTimeout    Duration `json:"session_timeout" yaml:"session_timeout" toml:"session_timeout"`
+
+	// Reonboarding is the policy for devices which already onboarded with
+	// the same GUID: allow (default), alert, or reject. See
+	// fdo.ReonboardPolicy.
+	Reonboarding string `json:"reonboarding" yaml:"reonboarding" toml:"reonboarding"`
+
+	// Profiles choose the service info sent to each device, as with
+	// fsim.ServiceInfoProfiles.
+	Profiles []Profile `json:"profiles" yaml:"profiles" toml:"profiles"`
+
+	// S3 configures access to the object store of downloads with s3 URLs.
+	S3 S3 `json:"s3" yaml:"s3" toml:"s3"`
+}
+
+// S3 configures access to an S3-compatible object store. Endpoint defaults to
+// the AWS endpoint of the region.
+type S3 struct {
+	Endpoint        string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+	Region          string `json:"region" yaml:"region" toml:"region"`
+	AccessKeyID     string `json:"access_key_id" yaml:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key" yaml:"secret_access_key" toml:"secret_access_key"`
+	SessionToken    string `json:"session_token" yaml:"session_token" toml:"session_token"`
+}
+
+// TO2Addr is an address of the owner service.
+type TO2Addr struct {
+	DNS  string `json:"dns" yaml:"dns" toml:"dns"`
+	IP   string `json:"ip" yaml:"ip" toml:"ip"`
+	Port uint16 `json:"port" yaml:"port" toml:"port"`
+
+	// Protocol is one of tcp, tls, http, coap, https, or coaps. It defaults
+	// to http.
+	Protocol string `json:"protocol" yaml:"protocol" toml:"protocol"`
+}
+
+// Profile configures the service info modules for the devices it matches.
+// See fsim.ServiceInfoProfile.
+type Profile struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+
+	// GUIDs are in RFC 4122 text form or hex-encoded without dashes.
+	GUIDs      []string `json:"guids" yaml:"guids" toml:"guids"`
+	DeviceInfo string   `json:"device_info" yaml:"device_info" toml:"device_info"`
+	OS         string   `json:"os" yaml:"os" toml:"os"`
+	Arch       string   `json:"arch" yaml:"arch" toml:"arch"`
+
+	Downloads []Download `json:"downloads" yaml:"downloads" toml:"downloads"`
+	Wgets     []Wget     `json:"wgets" yaml:"wgets" toml:"wgets"`
+	Commands  []Command  `json:"commands" yaml:"commands" toml:"commands"`
+	Uploads   []Upload   `json:"uploads" yaml:"uploads" toml:"uploads"`
+}
+
+// Download configures fdo.download to send a local file or to stream an
+// artifact from an http, https, or s3://bucket/key URL. Name defaults to the
+// base name of the path.
+type Download struct {
+	Name         string `json:"name" yaml:"name" toml:"name"`
+	Path         string `json:"path" yaml:"path" toml:"path"`
+	URL          string `json:"url" yaml:"url" toml:"url"`
+	MustDownload bool   `json:"must_download" yaml:"must_download" toml:"must_download"`
+}
+
+// Wget configures fdo.wget. Name defaults to the base name of the URL path
+// and Checksum is a hex-encoded SHA-384 hash.
+type Wget struct {
+	Name     string `json:"name" yaml:"name" toml:"name"`
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	Length   int64  `json:"length" yaml:"length" toml:"length"`
+	Checksum string `json:"checksum" yaml:"checksum" toml:"checksum"`
+}
+
+// Command configures fdo.command.
+type Command struct {
+	Command string   `json:"command" yaml:"command" toml:"command"`
+	Args    []string `json:"args" yaml:"args" toml:"args"`
+	MayFail bool     `json:"may_fail" yaml:"may_fail" toml:"may_fail"`
+}
+
+// Upload configures fdo.upload.
+type Upload struct {
+	Name   string `json:"name" yaml:"name" toml:"name"`
+	Dir    string `json:"dir" yaml:"dir" toml:"dir"`
+	Rename string `json:"rename" yaml:"rename" toml:"rename"`
+}
+
+// Duration is a time.Duration which is decoded from strings such as "30s".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]func([]byte, any) error{
+		".json": unmarshalJSON,
+		".yaml": unmarshalYAML,
+		".yml":  unmarshalYAML,
+		".toml": unmarshalTOML,
+	}
+)
+
+// RegisterFormat registers a function to decode configuration files with the
+// given extension, including the leading dot, replacing any built-in decoder.
+func RegisterFormat(ext string, unmarshal func([]byte, any) error) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[strings.ToLower(ext)] = unmarshal
+}
+
+func unmarshalJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func unmarshalYAML(data []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func unmarshalTOML(data []byte, v any) error {
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// Load reads a configuration file in the format registered for its
+// extension, applies environment overrides, and validates the result.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration: %w", err)
+	}
+	return Parse(data, filepath.Ext(path), os.LookupEnv)
+}
+
+// Parse decodes a configuration in the format registered for the extension,
+// applies overrides from lookupEnv, if not nil, and validates the result.
+func Parse(data []byte, ext string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	formatsMu.RLock()
+	unmarshal, ok := formats[strings.ToLower(ext)]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported configuration file format: %q", ext)
+	}
+
+	var cfg Config
+	if err := unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error decoding configuration: %w", err)
+	}
+	if lookupEnv != nil {
+		if _, err := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix, lookupEnv); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+var textUnmarshalerType = reflect.TypeFor[interface{ UnmarshalText([]byte) error }]()
+
+// applyEnv overrides the fields of a struct from environment variables and
+// reports whether any were set. Struct pointer fields are allocated only if
+// one of their fields is set.
+func applyEnv(v reflect.Value, prefix string, lookupEnv func(string) (string, bool)) (bool, error) {
+	var set bool
+	for i := range v.NumField() {
+		field, value := v.Type().Field(i), v.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			ok, err := applyEnv(value, key, lookupEnv)
+			if err != nil {
+				return false, err
+			}
+			set = set || ok
+
+		case field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct:
+			elem := reflect.New(field.Type.Elem())
+			if !value.IsNil() {
+				elem.Elem().Set(value.Elem())
+			}
+			ok, err := applyEnv(elem.Elem(), key, lookupEnv)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				value.Set(elem)
+				set = true
+			}
+
+		default:
+			env, ok := lookupEnv(key)
+			if !ok {
+				continue
+			}
+			if err := setField(value, env); err != nil {
+				return false, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+			set = true
+		}
+	}
+	return set, nil
+}
+
+func setField(value reflect.Value, env string) error {
+	if value.Addr().Type().Implements(textUnmarshalerType) {
+		return value.Addr().Interface().(interface{ UnmarshalText([]byte) error }).UnmarshalText([]byte(env))
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(env)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(env)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(env, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(env, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(n)
+	default:
+		return errors.New("field cannot be set from the environment")
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package config_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/config"
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`{
+		"http": "0.0.0.0:8080",
+		"ext_http": "owner.example.com:443",
+		"database": {"path": "fdo.db"},
+		"owner": {
+			"session_timeout": "1m",
+			"profiles": [{"name": "all", "commands": [{"command": "date", "args": ["--utc"]}]}]
+		}
+	}`)
+	env := map[string]string{
+		"FDO_DATABASE_PASSWORD":     "secret",
+		"FDO_OWNER_SESSION_TIMEOUT": "2m",
+		"FDO_RENDEZVOUS_MAX_TTL":    "24h",
+	}
+	lookupEnv := func(key string) (string, bool) { v, ok := env[key]; return v, ok }
+
+	cfg, err := config.Parse(data, ".json", lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Password != "secret" {
+		t.Errorf("expected password from environment, got %q", cfg.Database.Password)
+	}
+	if got := time.Duration(cfg.Owner.SessionTimeout); got != 2*time.Minute {
+		t.Errorf("expected session timeout from environment, got %s", got)
+	}
+	if cfg.Rendezvous == nil || time.Duration(cfg.Rendezvous.MaxTTL) != 24*time.Hour {
+		t.Errorf("expected rendezvous section to be enabled by environment, got %+v", cfg.Rendezvous)
+	}
+	if len(cfg.Owner.Profiles) != 1 || cfg.Owner.Profiles[0].Commands[0].Command != "date" {
+		t.Errorf("unexpected profiles: %+v", cfg.Owner.Profiles)
+	}
+
+	if _, err := config.Parse(data, ".json", func(key string) (string, bool) {
+		return "forever", key == "FDO_OWNER_SESSION_TIMEOUT"
+	}); err == nil || !strings.Contains(err.Error(), "FDO_OWNER_SESSION_TIMEOUT") {
+		t.Errorf("expected invalid environment override to fail, got %v", err)
+	}
+	if _, err := config.Parse([]byte(`{"htp": ":8080"}`), ".json", nil); err == nil {
+		t.Error("expected unknown field to fail")
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	for ext, data := range map[string]string{
+		".yaml": `
+http: 0.0.0.0:8080
+database:
+  path: fdo.db
+owner:
+  session_timeout: 1m
+  profiles:
+    - name: all
+      commands:
+        - command: date
+          args: [--utc]
+`,
+		".toml": `
+http = "0.0.0.0:8080"
+
+[database]
+path = "fdo.db"
+
+[owner]
+session_timeout = "1m"
+
+[[owner.profiles]]
+name = "all"
+
+[[owner.profiles.commands]]
+command = "date"
+args = ["--utc"]
+`,
+	} {
+		t.Run(ext, func(t *testing.T) {
+			cfg, err := config.Parse([]byte(data), ext, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.HTTP != "0.0.0.0:8080" || cfg.Database.Path != "fdo.db" {
+				t.Errorf("unexpected configuration: %+v", cfg)
+			}
+			if cfg.Owner == nil || time.Duration(cfg.Owner.SessionTimeout) != time.Minute {
+				t.Fatalf("unexpected owner section: %+v", cfg.Owner)
+			}
+			if len(cfg.Owner.Profiles) != 1 || cfg.Owner.Profiles[0].Commands[0].Args[0] != "--utc" {
+				t.Errorf("unexpected profiles: %+v", cfg.Owner.Profiles)
+			}
+		})
+	}
+
+	if _, err := config.Parse([]byte("htp: :8080\n"), ".yml", nil); err == nil {
+		t.Error("expected unknown YAML field to fail")
+	}
+	if _, err := config.Parse([]byte(`htp = ":8080"`), ".toml", nil); err == nil {
+		t.Error("expected unknown TOML field to fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, err := config.Parse([]byte(`{
+		"trusted_proxies": ["10.0.0.0/8", "proxy.example.com"],
+		"rate_limit": {"requests": 0},
+		"database": {"synchronous": "sometimes"},
+		"rv_info": [{"ip": "not an ip", "protocol": "gopher"}],
+		"keys": {"owner": {"DSA": "dsa.pem"}},
+		"rendezvous": {"cache_size": -1},
+		"owner": {"reonboarding": "ignore", "profiles": [{"guids": ["1234"], "downloads": [{}, {"url": "ftp://example.com/file"}, {"url": "s3://bucket/key"}]}]}
+	}`), ".json", nil)
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, field := range []string{
+		"http:",
+		"trusted_proxies[1]:",
+		"rate_limit.requests:",
+		"database.path:",
+		"database.synchronous:",
+		"keys.owner:",
+		"rv_info[0].ip:",
+		"rv_info[0].protocol:",
+		"rendezvous.cache_size:",
+		"owner.reonboarding:",
+		"owner.profiles[0].guids:",
+		"owner.profiles[0].downloads[0].path:",
+		"owner.profiles[0].downloads[1].url:",
+		"owner.s3.region:",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("expected error for %s in:\n%v", field, err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	writeKeyFile(t, keyPath)
+	cfgPath := filepath.Join(dir, "fdo.json")
+	if err := os.WriteFile(cfgPath, []byte(`{
+		"http": "127.0.0.1:8080",
+		"trusted_proxies": ["10.0.0.0/8", "::1"],
+		"rate_limit": {"requests": 10, "period": "1m"},
+		"database": {"path": "`+filepath.ToSlash(filepath.Join(dir, "fdo.db"))+`", "wal": true, "busy_timeout": "5s", "synchronous": "normal", "read_conns": 4},
+		"keys": {
+			"manufacturer": {"SECP256R1": "`+filepath.ToSlash(keyPath)+`"},
+			"owner": {"SECP256R1": "`+filepath.ToSlash(keyPath)+`"}
+		},
+		"rv_info": [{"dns": "rv.example.com", "device_port": 8041, "delay": "10s"}],
+		"di": {"auto_extend": true},
+		"owner": {
+			"max_sessions": 10,
+			"s3": {"region": "us-east-1"},
+			"profiles": [{"downloads": [{"url": "s3://bucket/firmware/image.bin"}]}]
+		}
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = servers.Close() }()
+
+	if servers.DI == nil || servers.TO2 == nil || servers.TO0 != nil || servers.TO1 != nil {
+		t.Fatalf("unexpected servers: %+v", servers)
+	}
+	if servers.Handler.TO0Responder != nil || servers.Handler.TO2Responder == nil {
+		t.Fatalf("unexpected handler: %+v", servers.Handler)
+	}
+	if proxies := servers.Handler.TrustedProxies; len(proxies) != 2 || proxies[1].String() != "::1/128" {
+		t.Errorf("unexpected trusted proxies: %v", proxies)
+	}
+	if limit := servers.Handler.RateLimit; limit == nil || limit.Burst != 10 || limit.Rate*60 != 10 {
+		t.Errorf("unexpected rate limit: %+v", limit)
+	}
+	if servers.TO2.MaxSessions != 10 {
+		t.Errorf("expected max sessions of 10, got %d", servers.TO2.MaxSessions)
+	}
+	dl := servers.TO2.ServiceInfo.(fsim.ServiceInfoProfiles)[0].Downloads[0]
+	if s3, ok := dl.Provider.(*fsim.S3Artifacts); !ok || s3.Bucket != "bucket" || dl.Path != "firmware/image.bin" || dl.Name != "image.bin" {
+		t.Errorf("unexpected s3 download: %+v", dl)
+	}
+
+	directives := protocol.ParseDeviceRvInfo(servers.RvInfo)
+	if len(directives) != 1 || len(directives[0].URLs) != 1 ||
+		directives[0].URLs[0].String() != "http://rv.example.com:8041" || directives[0].Delay != 10*time.Second {
+		t.Fatalf("unexpected rendezvous directives: %+v", directives)
+	}
+	if len(servers.TO2Addrs) != 1 || servers.TO2Addrs[0].String() != "http://127.0.0.1:8080" {
+		t.Fatalf("unexpected TO2 addresses: %v", servers.TO2Addrs)
+	}
+
+	if _, chain, err := servers.State.ManufacturerKey(protocol.Secp256r1KeyType); err != nil || len(chain) != 1 {
+		t.Fatalf("expected manufacturer key with chain, got %v", err)
+	}
+	if _, _, err := servers.State.OwnerKey(protocol.Secp256r1KeyType); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKeyFile(t *testing.T, path string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
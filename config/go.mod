@@ -0,0 +1,25 @@
+module github.com/fido-device-onboard/go-fdo/config
+
+go 1.23.0
+
+replace github.com/fido-device-onboard/go-fdo => ../
+
+replace github.com/fido-device-onboard/go-fdo/fsim => ../fsim
+
+replace github.com/fido-device-onboard/go-fdo/sqlite => ../sqlite
+
+require (
+	github.com/fido-device-onboard/go-fdo v0.0.0-00010101000000-000000000000
+	github.com/fido-device-onboard/go-fdo/fsim v0.0.0-00010101000000-000000000000
+	github.com/fido-device-onboard/go-fdo/sqlite v0.0.0-00010101000000-000000000000
+	github.com/pelletier/go-toml/v2 v2.2.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/ncruces/go-sqlite3 v0.19.1-0.20241017225339-d6aebe67cc4b // indirect
+	github.com/ncruces/julianday v1.0.0 // indirect
+	github.com/tetratelabs/wazero v1.8.1 // indirect
+	golang.org/x/crypto v0.28.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+)
@@ -0,0 +1,269 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// Validate checks the configuration without reading any files, returning all
+// problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, a...)))
+	}
+
+	if c.HTTP == "" {
+		fail("http", "required")
+	}
+	if _, _, err := c.extHostPort(); c.HTTP != "" && err != nil {
+		fail("ext_http", "%v", err)
+	}
+	for i, proxy := range c.TrustedProxies {
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			fail(fmt.Sprintf("trusted_proxies[%d]", i), "%v", err)
+		}
+	}
+	if c.RateLimit != nil {
+		if c.RateLimit.Requests <= 0 {
+			fail("rate_limit.requests", "must be positive")
+		}
+		if c.RateLimit.Period < 0 {
+			fail("rate_limit.period", "must not be negative")
+		}
+		if c.RateLimit.Burst < 0 {
+			fail("rate_limit.burst", "must not be negative")
+		}
+	}
+	if c.Database.Path == "" {
+		fail("database.path", "required")
+	}
+	switch strings.ToLower(c.Database.Synchronous) {
+	case "", "off", "normal", "full", "extra":
+	default:
+		fail("database.synchronous", "must be one of off, normal, full, or extra")
+	}
+	if c.DI == nil && c.Rendezvous == nil && c.Owner == nil {
+		errs = append(errs, errors.New("at least one of di, rendezvous, or owner must be set"))
+	}
+
+	for _, keys := range []struct {
+		field string
+		paths map[string]string
+	}{
+		{"keys.manufacturer", c.Keys.Manufacturer},
+		{"keys.owner", c.Keys.Owner},
+	} {
+		for name, path := range keys.paths {
+			if _, err := protocol.ParseKeyType(name); err != nil {
+				fail(keys.field, "%v", err)
+			}
+			if path == "" {
+				fail(keys.field+"."+name, "path required")
+			}
+		}
+	}
+
+	for i, rv := range c.RvInfo {
+		field := fmt.Sprintf("rv_info[%d]", i)
+		if rv.DNS == "" && rv.IP == "" && !rv.Bypass {
+			fail(field, "one of dns, ip, or bypass required")
+		}
+		if rv.IP != "" && net.ParseIP(rv.IP) == nil {
+			fail(field+".ip", "invalid IP address %q", rv.IP)
+		}
+		if _, err := parseRvProtocol(rv.Protocol); err != nil {
+			fail(field+".protocol", "%v", err)
+		}
+		if rv.DeviceOnly && rv.OwnerOnly {
+			fail(field, "device_only and owner_only are exclusive")
+		}
+		if rv.Delay < 0 {
+			fail(field+".delay", "must not be negative")
+		}
+	}
+
+	if c.DI != nil && c.DI.AutoTO0 && c.Owner == nil {
+		fail("di.auto_to0", "requires owner to be set")
+	}
+	if c.Rendezvous != nil {
+		if c.Rendezvous.MaxTTL < 0 {
+			fail("rendezvous.max_ttl", "must not be negative")
+		}
+		if c.Rendezvous.CacheSize < 0 {
+			fail("rendezvous.cache_size", "must not be negative")
+		}
+		if c.Rendezvous.CacheTTL < 0 {
+			fail("rendezvous.cache_ttl", "must not be negative")
+		}
+	}
+	if c.Owner != nil {
+		c.Owner.validate(fail)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o *Owner) validate(fail func(field, format string, a ...any)) {
+	for i, addr := range o.TO2Addrs {
+		field := fmt.Sprintf("owner.to2_addrs[%d]", i)
+		if addr.DNS == "" && addr.IP == "" {
+			fail(field, "one of dns or ip required")
+		}
+		if addr.IP != "" && net.ParseIP(addr.IP) == nil {
+			fail(field+".ip", "invalid IP address %q", addr.IP)
+		}
+		if _, err := parseTransportProtocol(addr.Protocol); err != nil {
+			fail(field+".protocol", "%v", err)
+		}
+	}
+	if o.MaxSessions < 0 {
+		fail("owner.max_sessions", "must not be negative")
+	}
+	if o.SessionTimeout < 0 {
+		fail("owner.session_timeout", "must not be negative")
+	}
+	if _, err := parseReonboardPolicy(o.Reonboarding); err != nil {
+		fail("owner.reonboarding", "%v", err)
+	}
+
+	for i, p := range o.Profiles {
+		field := fmt.Sprintf("owner.profiles[%d]", i)
+		for _, guid := range p.GUIDs {
+			if _, err := protocol.ParseGUID(guid); err != nil {
+				fail(field+".guids", "%v", err)
+			}
+		}
+		for j, dl := range p.Downloads {
+			switch {
+			case dl.Path == "" && dl.URL == "":
+				fail(fmt.Sprintf("%s.downloads[%d].path", field, j), "path or url required")
+			case dl.Path != "" && dl.URL != "":
+				fail(fmt.Sprintf("%s.downloads[%d].url", field, j), "must not be set with path")
+			case dl.URL != "":
+				if u, err := url.Parse(dl.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "s3") || u.Host == "" || u.Path == "" {
+					fail(fmt.Sprintf("%s.downloads[%d].url", field, j), "invalid http, https, or s3 URL %q", dl.URL)
+				} else if u.Scheme == "s3" && o.S3.Region == "" {
+					fail("owner.s3.region", "required for s3 downloads")
+				}
+			}
+		}
+		for j, wget := range p.Wgets {
+			if u, err := url.Parse(wget.URL); err != nil || u.Scheme == "" || u.Path == "" {
+				fail(fmt.Sprintf("%s.wgets[%d].url", field, j), "invalid URL %q", wget.URL)
+			}
+			if _, err := hex.DecodeString(wget.Checksum); err != nil {
+				fail(fmt.Sprintf("%s.wgets[%d].checksum", field, j), "%v", err)
+			}
+		}
+		for j, cmd := range p.Commands {
+			if cmd.Command == "" {
+				fail(fmt.Sprintf("%s.commands[%d].command", field, j), "required")
+			}
+		}
+		for j, up := range p.Uploads {
+			if up.Name == "" {
+				fail(fmt.Sprintf("%s.uploads[%d].name", field, j), "required")
+			}
+		}
+	}
+}
+
+// extHostPort returns the host and port devices connect to.
+func (c *Config) extHostPort() (string, uint16, error) {
+	addr := c.ExtHTTP
+	if addr == "" {
+		addr = c.HTTP
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address: %w", err)
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port: %w", err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return host, uint16(port), nil
+}
+
+func parseRvProtocol(name string) (uint8, error) {
+	switch strings.ToLower(name) {
+	case "rest":
+		return protocol.RVProtRest, nil
+	case "", "http":
+		return protocol.RVProtHTTP, nil
+	case "https":
+		return protocol.RVProtHTTPS, nil
+	case "tcp":
+		return protocol.RVProtTCP, nil
+	case "tls":
+		return protocol.RVProtTLS, nil
+	case "coap+tcp":
+		return protocol.RVProtCoapTCP, nil
+	case "coap+udp":
+		return protocol.RVProtCoapUDP, nil
+	default:
+		return 0, fmt.Errorf("unknown rendezvous protocol %q", name)
+	}
+}
+
+func parseTransportProtocol(name string) (protocol.TransportProtocol, error) {
+	if name == "" {
+		return protocol.HTTPTransport, nil
+	}
+	for _, prot := range []protocol.TransportProtocol{
+		protocol.TCPTransport,
+		protocol.TLSTransport,
+		protocol.HTTPTransport,
+		protocol.CoAPTransport,
+		protocol.HTTPSTransport,
+		protocol.CoAPSTransport,
+	} {
+		if strings.EqualFold(name, prot.String()) {
+			return prot, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown transport protocol %q", name)
+}
+
+// parseTrustedProxy parses an IP address or CIDR network.
+func parseReonboardPolicy(name string) (fdo.ReonboardPolicy, error) {
+	if name == "" {
+		return fdo.ReonboardAllow, nil
+	}
+	for _, policy := range []fdo.ReonboardPolicy{
+		fdo.ReonboardAllow,
+		fdo.ReonboardAlert,
+		fdo.ReonboardReject,
+	} {
+		if strings.EqualFold(name, policy.String()) {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown reonboarding policy %q", name)
+}
+
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address or network %q", s)
+	}
+	return prefix.Masked(), nil
+}
@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cose
+
+import (
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// CounterSignature is a COSE_Signature structure used as a countersignature,
+// as defined in RFC 8152 Section 4.5. It is carried in the counter signature
+// unprotected header of the signed message and signs the message's protected
+// header and payload, allowing a third party, such as a notary or auditor, to
+// attest to the message without changing its signature.
+type CounterSignature struct {
+	Header    `cbor:",flat2"`
+	Signature []byte
+}
+
+// CounterSign adds a countersignature to the message using a single private
+// key. Countersignatures are stored in the unprotected header, so the message
+// signature remains valid. Unless it is transported independently of the
+// message (detached), payload must be nil. If no external AAD is supplied, the
+// type should be []byte and the value nil.
+//
+// The signer opts have the same meaning as for Sign.
+func (s1 *Sign1[P, A]) CounterSign(key crypto.Signer, payload *P, additionalData A, opts crypto.SignerOpts) error {
+	counterSigs, err := s1.CounterSignatures()
+	if err != nil {
+		return err
+	}
+
+	// Determine signing algorithm
+	algID, err := SignatureAlgorithmFor(key.Public(), opts)
+	if err != nil {
+		return err
+	}
+	impl, ok := sigAlgorithms[algID]
+	if !ok {
+		return fmt.Errorf("signature algorithm %d not registered", algID)
+	}
+
+	// Sign contents of Sig_structure
+	counterSig := CounterSignature{
+		Header: Header{
+			Protected:   HeaderMap{AlgLabel: int64(algID)},
+			Unprotected: HeaderMap{},
+		},
+	}
+	tbs, err := s1.counterSigStructure(counterSig.Protected, payload, additionalData)
+	if err != nil {
+		return err
+	}
+	sigBytes, err := impl.sign(rand.Reader, key, tbs, opts)
+	if err != nil {
+		return err
+	}
+	counterSig.Signature = sigBytes
+
+	if s1.Unprotected == nil {
+		s1.Unprotected = HeaderMap{}
+	}
+	s1.Unprotected[CounterSignatureLabel] = append(counterSigs, counterSig)
+	return nil
+}
+
+// CounterSignatures returns all countersignatures of the message. The counter
+// signature header may contain either a single COSE_Signature or an array of
+// them.
+func (s1 Sign1[P, A]) CounterSignatures() ([]CounterSignature, error) {
+	raw, ok := s1.Unprotected[CounterSignatureLabel]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	data, err := cbor.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling counter signature header: %w", err)
+	}
+	var counterSigs []CounterSignature
+	if err := cbor.Unmarshal(data, &counterSigs); err == nil {
+		return counterSigs, nil
+	}
+	var counterSig CounterSignature
+	if err := cbor.Unmarshal(data, &counterSig); err != nil {
+		return nil, fmt.Errorf("error parsing counter signature header: %w", err)
+	}
+	return []CounterSignature{counterSig}, nil
+}
+
+// VerifyCounterSignature verifies one countersignature of the message using a
+// single public key. Unless it was transported independently of the message
+// (detached), payload must be nil. If no external AAD is supplied, the type
+// should be []byte and the value nil.
+func (s1 Sign1[P, A]) VerifyCounterSignature(counterSig CounterSignature, key crypto.PublicKey, payload *P, additionalData A) (bool, error) {
+	if len(counterSig.Signature) == 0 {
+		return false, errors.New("signature length insufficient")
+	}
+
+	// Get signature algorithm
+	var alg SignatureAlgorithm
+	if ok, err := counterSig.Protected.Parse(AlgLabel, &alg); err != nil {
+		return false, err
+	} else if !ok {
+		return false, fmt.Errorf("missing signature algorithm protected header")
+	}
+	impl, ok := sigAlgorithms[alg]
+	if !ok {
+		return false, errors.New("unsupported algorithm")
+	}
+
+	// Encode signature structure and verify signature
+	tbs, err := s1.counterSigStructure(counterSig.Protected, payload, additionalData)
+	if err != nil {
+		return false, err
+	}
+	return impl.verify(key, tbs, counterSig.Signature)
+}
+
+// counterSigStructure encodes the Sig_structure of a countersignature over
+// the message.
+func (s1 Sign1[P, A]) counterSigStructure(signProtected HeaderMap, payload *P, additionalData A) ([]byte, error) {
+	// Check that some payload was given
+	if s1.Payload == nil && payload == nil {
+		return nil, errors.New("payload was transported independently but not given as an argument")
+	}
+	if s1.Payload != nil && payload != nil {
+		return nil, errors.New("payload given as an argument must be detached from the message")
+	}
+	sigPayload := s1.Payload
+	if sigPayload == nil {
+		sigPayload = cbor.NewByteWrap(*payload)
+	}
+
+	body, err := newEmptyOrSerializedMap(s1.Protected)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling signature protected body: %w", err)
+	}
+	sign, err := newEmptyOrSerializedMap(signProtected)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling counter signature protected header: %w", err)
+	}
+	return cbor.Marshal(signature[P, A]{
+		Context:       sigCtrContext,
+		BodyProtected: body,
+		SignProtected: sign,
+		ExternalAad:   *cbor.NewByteWrap(additionalData),
+		Payload:       *sigPayload,
+	})
+}
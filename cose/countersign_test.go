@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cose_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+)
+
+func TestCounterSign(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecNotary, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaNotary, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1 := cose.Sign1[[]byte, []byte]{
+		Payload: cbor.NewByteWrap([]byte("This is the content.")),
+	}
+	if err := s1.Sign(signer, nil, nil, nil); err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	if err := s1.CounterSign(ecNotary, nil, nil, nil); err != nil {
+		t.Fatalf("error countersigning: %v", err)
+	}
+	if err := s1.CounterSign(rsaNotary, nil, nil, crypto.SHA256); err != nil {
+		t.Fatalf("error countersigning: %v", err)
+	}
+
+	// Round trip through CBOR
+	data, err := cbor.Marshal(s1.Tag())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got cose.Sign1Tag[[]byte, []byte]
+	if err := cbor.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message signature is unaffected
+	if ok, err := got.Verify(signer.Public(), nil, nil); err != nil || !ok {
+		t.Fatalf("expected message signature to verify: ok=%t, err=%v", ok, err)
+	}
+
+	counterSigs, err := got.CounterSignatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counterSigs) != 2 {
+		t.Fatalf("expected 2 countersignatures, got %d", len(counterSigs))
+	}
+	for i, key := range []crypto.PublicKey{ecNotary.Public(), rsaNotary.Public()} {
+		if ok, err := got.VerifyCounterSignature(counterSigs[i], key, nil, nil); err != nil || !ok {
+			t.Errorf("expected countersignature %d to verify: ok=%t, err=%v", i, ok, err)
+		}
+	}
+	if ok, _ := got.VerifyCounterSignature(counterSigs[0], rsaNotary.Public(), nil, nil); ok {
+		t.Error("expected countersignature to fail with the wrong key")
+	}
+
+	// Countersignatures cover the payload
+	got.Payload = cbor.NewByteWrap([]byte("This is other content."))
+	if ok, _ := got.VerifyCounterSignature(counterSigs[0], ecNotary.Public(), nil, nil); ok {
+		t.Error("expected countersignature to fail with a modified payload")
+	}
+
+	// A single COSE_Signature, rather than an array, is also accepted
+	s1.Unprotected[cose.CounterSignatureLabel] = counterSigs[0]
+	if single, err := s1.CounterSignatures(); err != nil || len(single) != 1 {
+		t.Fatalf("expected single countersignature: %v, err=%v", single, err)
+	} else if ok, err := s1.VerifyCounterSignature(single[0], ecNotary.Public(), nil, nil); err != nil || !ok {
+		t.Errorf("expected single countersignature to verify: ok=%t, err=%v", ok, err)
+	}
+}
@@ -4,6 +4,7 @@
 package cose
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -57,67 +58,169 @@ func (e0 Encrypt0[P, A]) Tag() *Encrypt0Tag[P, A] { return &Encrypt0Tag[P, A]{e0
 // data, aad must be nil. To pass no AAD to an AEAD, E should be []byte and aad
 // should be either nil or a pointer to an empty byte slice.
 func (e0 *Encrypt0[P, A]) Encrypt(alg EncryptAlgorithm, key []byte, payload P, aad *A) error {
+	ciphertext, err := encrypt(enc0Context, &e0.Header, alg, key, payload, aad)
+	if err != nil {
+		return err
+	}
+	e0.Ciphertext = &ciphertext
+	return nil
+}
+
+// Decrypt a payload from the Ciphertext field, automatically unmarshaling it
+// to type P.
+//
+// For encryption algorithms that do not take external additional authenticated
+// data, aad must be nil. To pass no AAD to an AEAD, E should be []byte and aad
+// should be either nil or a pointer to an empty byte slice.
+func (e0 Encrypt0[P, A]) Decrypt(alg EncryptAlgorithm, key []byte, aad *A) (*P, error) {
+	return decrypt[P](enc0Context, e0.Header, e0.Ciphertext, alg, key, aad)
+}
+
+// Encrypt holds the encrypted content of an enveloped structure along with
+// the information needed by one or more recipients to obtain the content
+// encryption key.
+type Encrypt[P, A any] struct {
+	Header     `cbor:",flat2"`
+	Ciphertext *[]byte // byte string or null when transported separately
+	Recipients []Recipient
+}
+
+// Tag is a helper for converting to a tag value.
+func (e Encrypt[P, A]) Tag() *EncryptTag[P, A] { return &EncryptTag[P, A]{e} }
+
+// Encrypt a payload, setting the Chiphertext field value. Recipients are not
+// modified and must be set by the caller. Because only direct key agreement
+// is currently supported, the key is used directly as the content encryption
+// key.
+//
+// The marshaling rules of payload and aad follow those of
+// [Encrypt0.Encrypt].
+func (e *Encrypt[P, A]) Encrypt(alg EncryptAlgorithm, key []byte, payload P, aad *A) error {
+	if len(e.Recipients) == 0 {
+		return fmt.Errorf("at least one recipient is required")
+	}
+	ciphertext, err := encrypt(encContext, &e.Header, alg, key, payload, aad)
+	if err != nil {
+		return err
+	}
+	e.Ciphertext = &ciphertext
+	return nil
+}
+
+// Decrypt a payload from the Ciphertext field, automatically unmarshaling it
+// to type P. The key must be the content encryption key, which for direct key
+// agreement is the shared secret identified by the recipient.
+func (e Encrypt[P, A]) Decrypt(alg EncryptAlgorithm, key []byte, aad *A) (*P, error) {
+	return decrypt[P](encContext, e.Header, e.Ciphertext, alg, key, aad)
+}
+
+// Recipient returns the first recipient with a matching key ID. If kid is
+// nil, then the first recipient without a key ID is returned.
+func (e Encrypt[P, A]) Recipient(kid []byte) (*Recipient, bool) {
+	for i, r := range e.Recipients {
+		if bytes.Equal(r.KeyID(), kid) {
+			return &e.Recipients[i], true
+		}
+	}
+	return nil, false
+}
+
+// DirectKeyAlg is the algorithm identifier for a recipient which uses a
+// shared secret directly as the content encryption key.
+const DirectKeyAlg int64 = -6
+
+// Recipient is a COSE_recipient structure, which holds the information a
+// recipient needs to obtain the content encryption key.
+type Recipient struct {
+	Header     `cbor:",flat2"`
+	Ciphertext []byte      // empty for direct key agreement
+	Recipients []Recipient `cbor:",omitempty"`
+}
+
+// NewDirectRecipient creates a recipient of a COSE_Encrypt structure which
+// uses the pre-shared key identified by kid as the content encryption key.
+func NewDirectRecipient(kid []byte) Recipient {
+	r := Recipient{
+		Header: Header{
+			Protected:   HeaderMap{},
+			Unprotected: HeaderMap{AlgLabel: DirectKeyAlg},
+		},
+		Ciphertext: []byte{},
+	}
+	if kid != nil {
+		r.Unprotected[KidLabel] = kid
+	}
+	return r
+}
+
+// KeyID returns the key ID from the protected or unprotected headers or nil
+// if not present.
+func (r Recipient) KeyID() []byte {
+	var kid []byte
+	if ok, err := r.Protected.Parse(KidLabel, &kid); ok && err == nil {
+		return kid
+	}
+	if ok, err := r.Unprotected.Parse(KidLabel, &kid); ok && err == nil {
+		return kid
+	}
+	return nil
+}
+
+func encrypt[P, A any](context string, hdr *Header, alg EncryptAlgorithm, key []byte, payload P, aad *A) ([]byte, error) {
 	// Get Crypter to perform encryption/decryption
 	c, err := alg.NewCrypter(key)
 	if err != nil {
-		return fmt.Errorf("error intializing crypter for alg %d: %w", alg, err)
+		return nil, fmt.Errorf("error intializing crypter for alg %d: %w", alg, err)
 	}
 
 	// Set alg header
-	if e0.Protected == nil {
-		e0.Protected = HeaderMap{}
+	if hdr.Protected == nil {
+		hdr.Protected = HeaderMap{}
 	}
-	if e0.Unprotected == nil {
-		e0.Unprotected = HeaderMap{}
+	if hdr.Unprotected == nil {
+		hdr.Unprotected = HeaderMap{}
 	}
 	if alg.SupportsAD() {
-		e0.Protected[AlgLabel] = alg
+		hdr.Protected[AlgLabel] = alg
 	} else {
-		e0.Unprotected[AlgLabel] = alg
+		hdr.Unprotected[AlgLabel] = alg
 	}
 
 	// Encode payload to plaintext
 	plaintext, err := cbor.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("error marshaling payload to plaintext: %w", err)
+		return nil, fmt.Errorf("error marshaling payload to plaintext: %w", err)
 	}
 
 	// Encode additional authenticated data
 	var additionalData []byte
 	if alg.SupportsAD() {
 		var err error
-		additionalData, err = e0.additionalData(aad)
+		additionalData, err = encStructure(context, hdr.Protected, aad)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	} else if aad != nil || len(e0.Protected) > 0 {
-		return fmt.Errorf("additional data provided, but the encryption algorithm does not support it")
+	} else if aad != nil || len(hdr.Protected) > 0 {
+		return nil, fmt.Errorf("additional data provided, but the encryption algorithm does not support it")
 	}
 
 	// Perform encryption to ciphertext
 	ciphertext, newUnprotected, err := c.Encrypt(rand.Reader, plaintext, additionalData)
 	if err != nil {
-		return fmt.Errorf("error encrypting plaintext: %w", err)
+		return nil, fmt.Errorf("error encrypting plaintext: %w", err)
 	}
 	for label, val := range newUnprotected {
-		e0.Unprotected[label] = val
+		hdr.Unprotected[label] = val
 	}
-	e0.Ciphertext = &ciphertext
 
-	return nil
+	return ciphertext, nil
 }
 
-// Decrypt a payload from the Ciphertext field, automatically unmarshaling it
-// to type P.
-//
-// For encryption algorithms that do not take external additional authenticated
-// data, aad must be nil. To pass no AAD to an AEAD, E should be []byte and aad
-// should be either nil or a pointer to an empty byte slice.
-func (e0 Encrypt0[P, A]) Decrypt(alg EncryptAlgorithm, key []byte, aad *A) (*P, error) {
+func decrypt[P, A any](context string, hdr Header, ciphertext *[]byte, alg EncryptAlgorithm, key []byte, aad *A) (*P, error) {
 	// Validate algorithm header matches expected
-	header := e0.Protected
+	header := hdr.Protected
 	if !alg.SupportsAD() {
-		header = e0.Unprotected
+		header = hdr.Unprotected
 	}
 	var headerAlg EncryptAlgorithm
 	if ok, err := header.Parse(AlgLabel, &headerAlg); err != nil {
@@ -138,7 +241,7 @@ func (e0 Encrypt0[P, A]) Decrypt(alg EncryptAlgorithm, key []byte, aad *A) (*P,
 	var additionalData []byte
 	if alg.SupportsAD() {
 		var err error
-		additionalData, err = e0.additionalData(aad)
+		additionalData, err = encStructure(context, hdr.Protected, aad)
 		if err != nil {
 			return nil, err
 		}
@@ -147,10 +250,10 @@ func (e0 Encrypt0[P, A]) Decrypt(alg EncryptAlgorithm, key []byte, aad *A) (*P,
 	}
 
 	// Perform decryption to plaintext
-	if e0.Ciphertext == nil {
+	if ciphertext == nil {
 		return nil, fmt.Errorf("nil ciphertext")
 	}
-	plaintext, err := c.Decrypt(rand.Reader, *e0.Ciphertext, additionalData, e0.Unprotected)
+	plaintext, err := c.Decrypt(rand.Reader, *ciphertext, additionalData, hdr.Unprotected)
 	if err != nil {
 		return nil, fmt.Errorf("error decrypting ciphertext: %w", err)
 	}
@@ -164,23 +267,25 @@ func (e0 Encrypt0[P, A]) Decrypt(alg EncryptAlgorithm, key []byte, aad *A) (*P,
 }
 
 // Build and encode Enc_structure
-func (e0 Encrypt0[P, A]) additionalData(aad *A) ([]byte, error) {
-	protected, err := newEmptyOrSerializedMap(e0.Protected)
+func encStructure[A any](context string, protected HeaderMap, aad *A) ([]byte, error) {
+	body, err := newEmptyOrSerializedMap(protected)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling protected header map: %w", err)
 	}
-	var additionalData []byte
+	externalAAD := []byte{}
 	if aad != nil {
-		var err error
-		additionalData, err = cbor.Marshal(cbor.NewByteWrap(*aad))
-		if err != nil {
+		// Follow the same rules as ByteWrap: byte slices are used as-is and
+		// all other types are marshaled to CBOR
+		if b, ok := any(*aad).([]byte); ok {
+			externalAAD = b
+		} else if externalAAD, err = cbor.Marshal(*aad); err != nil {
 			return nil, fmt.Errorf("error marshaling AAD: %w", err)
 		}
 	}
 	enc, err := cbor.Marshal(encStruct{
-		Context:     enc0Context,
-		Protected:   protected,
-		ExternalAAD: additionalData,
+		Context:     context,
+		Protected:   body,
+		ExternalAAD: externalAAD,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling Enc_structure: %w", err)
@@ -232,3 +337,32 @@ func (t *Encrypt0Tag[P, A]) UnmarshalCBOR(data []byte) error {
 	*t = Encrypt0Tag[P, A]{tag.Val}
 	return nil
 }
+
+// EncryptTag encodes to a CBOR tag while ensuring the right tag number.
+type EncryptTag[P, A any] struct {
+	Encrypt[P, A]
+}
+
+// Untag is a helper for accessing the tag value.
+func (t EncryptTag[P, A]) Untag() *Encrypt[P, A] { return &t.Encrypt }
+
+// MarshalCBOR implements cbor.Marshaler.
+func (t EncryptTag[P, A]) MarshalCBOR() ([]byte, error) {
+	return cbor.Marshal(cbor.Tag[Encrypt[P, A]]{
+		Num: EncryptTagNum,
+		Val: t.Encrypt,
+	})
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (t *EncryptTag[P, A]) UnmarshalCBOR(data []byte) error {
+	var tag cbor.Tag[Encrypt[P, A]]
+	if err := cbor.Unmarshal(data, &tag); err != nil {
+		return err
+	}
+	if tag.Num != EncryptTagNum {
+		return fmt.Errorf("mismatched tag number %d for Encrypt, expected %d", tag.Num, EncryptTagNum)
+	}
+	*t = EncryptTag[P, A]{tag.Val}
+	return nil
+}
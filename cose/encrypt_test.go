@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cose_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+)
+
+func TestEncrypt0(t *testing.T) {
+	// Input:
+	//
+	//   o  Encryption: AES-GCM, 128-bit key
+	//   o  Recipient class: direct shared secret
+	//   o  Shared secret: '849b57219dae48de646d07dbb533566e'
+	//   o  IV: '02d1f7e6f26c43d4868d87ce'
+	//   o  Payload: 'This is the content.' (encoded as a CBOR byte string)
+	key, _ := hex.DecodeString("849b57219dae48de646d07dbb533566e")
+	payload := []byte("This is the content.")
+
+	t.Run("decode fixture", func(t *testing.T) {
+		for _, test := range []struct {
+			Name string
+			AAD  *[]byte
+			Data string
+		}{
+			{
+				Name: "no external aad",
+				Data: "d0" + "83" + "43a10101" + "a1054c02d1f7e6f26c43d4868d87ce" +
+					"5825" + "60ab3b8ee8618253caf9238a9aa71cc8496c2d1146bd9695386ee91eb2165d5ea408f54350",
+			},
+			{
+				Name: "external aad",
+				AAD:  &[]byte{0x11, 0xaa, 0x22, 0xbb, 0x33, 0xcc, 0x44, 0xdd, 0x55, 0x00, 0x66, 0x99},
+				Data: "d0" + "83" + "43a10101" + "a1054c02d1f7e6f26c43d4868d87ce" +
+					"5825" + "60ab3b8ee8618253caf9238a9aa71cc8496c2d114603369eaae72f5c9a178f332b0751221e",
+			},
+		} {
+			t.Run(test.Name, func(t *testing.T) {
+				data, err := hex.DecodeString(test.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var e0 cose.Encrypt0Tag[[]byte, []byte]
+				if err := cbor.Unmarshal(data, &e0); err != nil {
+					t.Fatal(err)
+				}
+				got, err := e0.Decrypt(cose.A128GCM, key, test.AAD)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(*got, payload) {
+					t.Fatalf("expected %q, got %q", payload, *got)
+				}
+
+				// Decryption must fail when AAD does not match
+				if _, err := e0.Decrypt(cose.A128GCM, key, &[]byte{0x00}); err == nil {
+					t.Fatal("expected decryption with wrong AAD to fail")
+				}
+			})
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, test := range []struct {
+			Alg cose.EncryptAlgorithm
+			AAD *[]byte
+		}{
+			{Alg: cose.A128GCM},
+			{Alg: cose.A192GCM, AAD: &[]byte{0x01, 0x02}},
+			{Alg: cose.A256GCM, AAD: &[]byte{}},
+			{Alg: cose.A128CTR},
+			{Alg: cose.A256CBC},
+		} {
+			key := make([]byte, test.Alg.KeySize())
+
+			var e0 cose.Encrypt0[[]byte, []byte]
+			if err := e0.Encrypt(test.Alg, key, payload, test.AAD); err != nil {
+				t.Fatalf("alg %d: %v", test.Alg, err)
+			}
+
+			// Check untagged and tagged forms
+			for _, v := range []any{e0, e0.Tag()} {
+				data, err := cbor.Marshal(v)
+				if err != nil {
+					t.Fatalf("alg %d: %v", test.Alg, err)
+				}
+
+				var got *[]byte
+				switch v.(type) {
+				case cose.Encrypt0[[]byte, []byte]:
+					var e0 cose.Encrypt0[[]byte, []byte]
+					if err := cbor.Unmarshal(data, &e0); err != nil {
+						t.Fatalf("alg %d: %v", test.Alg, err)
+					}
+					got, err = e0.Decrypt(test.Alg, key, test.AAD)
+				case *cose.Encrypt0Tag[[]byte, []byte]:
+					if data[0] != 0xd0 {
+						t.Fatalf("alg %d: expected tag 16, got %x", test.Alg, data[0])
+					}
+					var e0 cose.Encrypt0Tag[[]byte, []byte]
+					if err := cbor.Unmarshal(data, &e0); err != nil {
+						t.Fatalf("alg %d: %v", test.Alg, err)
+					}
+					got, err = e0.Untag().Decrypt(test.Alg, key, test.AAD)
+				}
+				if err != nil {
+					t.Fatalf("alg %d: %v", test.Alg, err)
+				}
+				if !bytes.Equal(*got, payload) {
+					t.Fatalf("alg %d: expected %q, got %q", test.Alg, payload, *got)
+				}
+			}
+		}
+	})
+
+	t.Run("non-AEAD rejects AAD", func(t *testing.T) {
+		var e0 cose.Encrypt0[[]byte, []byte]
+		if err := e0.Encrypt(cose.A128CBC, make([]byte, 16), payload, &[]byte{0x01}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestEncrypt(t *testing.T) {
+	key, _ := hex.DecodeString("849b57219dae48de646d07dbb533566e")
+	kid := []byte("our-secret")
+	payload := []byte("This is the content.")
+
+	t.Run("decode fixture", func(t *testing.T) {
+		data, _ := hex.DecodeString("d860" + "84" + "43a10101" + "a1054c02d1f7e6f26c43d4868d87ce" +
+			"5825" + "60ab3b8ee8618253caf9238a9aa71cc8496c2d11469e394bf3385bdd243ad8e529a99d24a6" +
+			"81" + "83" + "40" + "a20125044a6f75722d736563726574" + "40")
+		var enc cose.EncryptTag[[]byte, []byte]
+		if err := cbor.Unmarshal(data, &enc); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := enc.Recipient(kid); !ok {
+			t.Fatalf("recipient %q not found", kid)
+		}
+		got, err := enc.Decrypt(cose.A128GCM, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(*got, payload) {
+			t.Fatalf("expected %q, got %q", payload, *got)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		enc := cose.Encrypt[[]byte, []byte]{
+			Recipients: []cose.Recipient{cose.NewDirectRecipient(kid)},
+		}
+		if err := enc.Encrypt(cose.A256GCM, make([]byte, 32), payload, nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := cbor.Marshal(enc.Tag())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got cose.EncryptTag[[]byte, []byte]
+		if err := cbor.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if r, ok := got.Recipient(kid); !ok {
+			t.Fatalf("recipient %q not found", kid)
+		} else if !bytes.Equal(r.KeyID(), kid) {
+			t.Fatalf("expected kid %q, got %q", kid, r.KeyID())
+		}
+		plaintext, err := got.Decrypt(cose.A256GCM, make([]byte, 32), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(*plaintext, payload) {
+			t.Fatalf("expected %q, got %q", payload, *plaintext)
+		}
+	})
+
+	t.Run("requires recipient", func(t *testing.T) {
+		var enc cose.Encrypt[[]byte, []byte]
+		if err := enc.Encrypt(cose.A128GCM, key, payload, nil); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestEncryptThenMac(t *testing.T) {
+	sek, svk := make([]byte, 16), make([]byte, 16)
+	payload := []byte("This is the content.")
+
+	var e0 cose.Encrypt0[[]byte, []byte]
+	if err := e0.Encrypt(cose.A128CTR, sek, payload, nil); err != nil {
+		t.Fatal(err)
+	}
+	m0 := cose.Mac0[cose.Encrypt0[[]byte, []byte], []byte]{
+		Payload: cbor.NewByteWrap(e0),
+	}
+	if err := m0.Digest(cose.HMac256, svk, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cbor.Marshal(m0.Tag())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got cose.Mac0Tag[cose.Encrypt0[[]byte, []byte], []byte]
+	if err := cbor.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := got.Verify(cose.HMac256, svk, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("mac did not match")
+	}
+	plaintext, err := got.Payload.Val.Decrypt(cose.A128CTR, sek, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(*plaintext, payload) {
+		t.Fatalf("expected %q, got %q", payload, *plaintext)
+	}
+}
@@ -137,8 +137,10 @@ Common labels
 	+-----------+-------+----------------+-------------+----------------+
 */
 var (
-	AlgLabel = Label{Int64: 1}
-	IvLabel  = Label{Int64: 5}
+	AlgLabel              = Label{Int64: 1}
+	KidLabel              = Label{Int64: 4}
+	IvLabel               = Label{Int64: 5}
+	CounterSignatureLabel = Label{Int64: 7}
 )
 
 // Label is used for [HeaderMap]s and can be either an int64 or a string.
@@ -8,7 +8,9 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rsa"
 	"fmt"
+	"math"
 	"math/big"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
@@ -62,12 +64,14 @@ type KeyType = IntOrStr
 // | OKP       | 1     | Octet Key Pair                                |
 // | EC2       | 2     | Elliptic Curve Keys w/ x- and y-coordinate    |
 // |           |       | pair                                          |
+// | RSA       | 3     | RSA Key (RFC 8230)                            |
 // | Symmetric | 4     | Symmetric Keys                                |
 // | Reserved  | 0     | This value is reserved                        |
 // +-----------+-------+-----------------------------------------------+
 var (
 	OKPKeyType       = KeyType{Int64: 1}
 	EC2KeyType       = KeyType{Int64: 2}
+	RSAKeyType       = KeyType{Int64: 3}
 	SymmetricKeyType = KeyType{Int64: 4}
 )
 
@@ -87,7 +91,8 @@ var (
 //	}
 type Key map[KeyLabel]any
 
-// NewKey creates a key map structure with only the required fields.
+// NewKey creates a key map structure with only the required fields. The key
+// must be an *ecdsa.PublicKey or *rsa.PublicKey.
 func NewKey(k any) (Key, error) {
 	switch key := k.(type) {
 	case *ecdsa.PublicKey:
@@ -102,12 +107,21 @@ func NewKey(k any) (Key, error) {
 		default:
 			return nil, fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
 		}
+		// Coordinates must be encoded with leading zeros to the full size of
+		// the field
+		size := (key.Curve.Params().BitSize + 7) / 8
 		return Key{
 			IntOrStr{Int64: -1}: crv,
-			IntOrStr{Int64: -2}: key.X.Bytes(),
-			IntOrStr{Int64: -3}: key.Y.Bytes(),
+			IntOrStr{Int64: -2}: key.X.FillBytes(make([]byte, size)),
+			IntOrStr{Int64: -3}: key.Y.FillBytes(make([]byte, size)),
 			KeyTypeKeyLabel:     EC2KeyType,
 		}, nil
+	case *rsa.PublicKey:
+		return Key{
+			IntOrStr{Int64: -1}: key.N.Bytes(),
+			IntOrStr{Int64: -2}: big.NewInt(int64(key.E)).Bytes(),
+			KeyTypeKeyLabel:     RSAKeyType,
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported key type: %T", k)
 	}
@@ -154,6 +168,8 @@ func (k Key) Kty() (IntOrStr, bool) {
 		return IntOrStr{Int64: v}, true
 	case string:
 		return IntOrStr{Str: v}, true
+	case IntOrStr:
+		return v, true
 	default:
 		return IntOrStr{}, false
 	}
@@ -177,6 +193,15 @@ func (k Key) IsEllipticCurveKey() bool {
 	return kty == EC2KeyType || kty.Str == "EC2"
 }
 
+// IsRSAKey returns whether the key type is an RSA key.
+func (k Key) IsRSAKey() bool {
+	kty, ok := k.Kty()
+	if !ok {
+		return false
+	}
+	return kty == RSAKeyType || kty.Str == "RSA"
+}
+
 // IsSymmetricKey returns whether the key type is a symmetric key..
 func (k Key) IsSymmetricKey() bool {
 	kty, ok := k.Kty()
@@ -248,16 +273,21 @@ func (k Key) BaseIV() ([]byte, bool) {
 	return b, ok
 }
 
-// Public returns the public portion of the key.
+// Public returns the public portion of the key as an *ecdsa.PublicKey or
+// *rsa.PublicKey.
 func (k Key) Public() (crypto.PublicKey, error) {
-	if !k.IsEllipticCurveKey() {
-		return nil, fmt.Errorf("only elliptic curve keys are currently supported")
-	}
-	priv, err := k.ec2()
-	if err != nil {
-		return nil, err
+	switch {
+	case k.IsEllipticCurveKey():
+		priv, err := k.ec2()
+		if err != nil {
+			return nil, err
+		}
+		return priv.Public(), nil
+	case k.IsRSAKey():
+		return k.rsa()
+	default:
+		return nil, fmt.Errorf("only elliptic curve and RSA keys are currently supported")
 	}
-	return priv.Public(), nil
 }
 
 // Curve Names
@@ -363,3 +393,45 @@ func (k Key) ec2() (*ecdsa.PrivateKey, error) { //nolint:gocyclo
 
 	return &key, nil
 }
+
+// RSA Key Parameters
+//
+// +-------+-------+-------+-------+-----------------------------------+
+// | Key   | Name  | Label | CBOR  | Description                       |
+// | Type  |       |       | Type  |                                   |
+// +-------+-------+-------+-------+-----------------------------------+
+// | 3     | n     | -1    | bstr  | the RSA modulus n                 |
+// | 3     | e     | -2    | bstr  | the RSA public exponent e         |
+// +-------+-------+-------+-------+-----------------------------------+
+func (k Key) rsa() (*rsa.PublicKey, error) {
+	if !k.IsRSAKey() {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+
+	n, ok := k[KeyLabel{Int64: -1}]
+	if !ok {
+		return nil, fmt.Errorf("RSA n parameter is not present")
+	}
+	nb, ok := n.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid RSA n type: %T", n)
+	}
+
+	e, ok := k[KeyLabel{Int64: -2}]
+	if !ok {
+		return nil, fmt.Errorf("RSA e parameter is not present")
+	}
+	eb, ok := e.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid RSA e type: %T", e)
+	}
+	exp := new(big.Int).SetBytes(eb)
+	if !exp.IsInt64() || exp.Int64() > math.MaxInt32 || exp.Int64() < 3 {
+		return nil, fmt.Errorf("invalid RSA public exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nb),
+		E: int(exp.Int64()),
+	}, nil
+}
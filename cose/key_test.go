@@ -7,6 +7,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"encoding/hex"
 	"testing"
 
@@ -104,3 +105,50 @@ func TestEC2Key(t *testing.T) {
 		}
 	})
 }
+
+func TestRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ckey, err := cose.NewKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ckey.IsRSAKey() {
+		t.Fatal("expected kty to be RSA")
+	}
+	b, err := cbor.Marshal(ckey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ckey2 cose.Key
+	if err := cbor.Unmarshal(b, &ckey2); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := ckey2[cose.KeyLabel{Int64: -2}].([]byte); hex.EncodeToString(e) != "010001" {
+		t.Fatalf("expected e to be encoded as 010001, got %x", e)
+	}
+	cpub, err := ckey2.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, ok := cpub.(*rsa.PublicKey)
+	if !ok {
+		t.Fatal("expected to parse an RSA public key")
+	}
+	if !pub.Equal(key.Public()) {
+		t.Fatal("expected public keys to match")
+	}
+
+	t.Run("invalid exponent", func(t *testing.T) {
+		ckey := cose.Key{
+			cose.KeyTypeKeyLabel:     cose.RSAKeyType,
+			cose.KeyLabel{Int64: -1}: key.N.Bytes(),
+			cose.KeyLabel{Int64: -2}: []byte{0x01},
+		}
+		if _, err := ckey.Public(); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
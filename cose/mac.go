@@ -4,7 +4,9 @@
 package cose
 
 import (
+	"crypto/hmac"
 	"fmt"
+	"maps"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
 )
@@ -64,6 +66,43 @@ func (m0 *Mac0[P, A]) Digest(alg MacAlgorithm, key []byte, payload *P, aad A) er
 	return nil
 }
 
+// Verify the MAC Value using a single symmetric key. Unless it was transported
+// independently of the mac, payload may be nil. For empty AAD, the type should
+// be []byte.
+//
+// The algorithm in the protected header must match alg and the comparison of
+// MAC values is performed in constant time.
+func (m0 Mac0[P, A]) Verify(alg MacAlgorithm, key []byte, payload *P, aad A) (bool, error) {
+	// Check that some payload was given
+	if m0.Payload == nil && payload == nil {
+		return false, fmt.Errorf("payload was transported independently but not given as an argument to Verify")
+	}
+	if payload != nil {
+		m0.Payload = cbor.NewByteWrap(*payload)
+	}
+	if len(m0.Value) == 0 {
+		return false, fmt.Errorf("missing MAC value")
+	}
+
+	// Validate algorithm header matches expected
+	var headerAlg MacAlgorithm
+	if ok, err := m0.Protected.Parse(AlgLabel, &headerAlg); err != nil {
+		return false, fmt.Errorf("error parsing algorithm from protected header: %w", err)
+	} else if !ok {
+		return false, fmt.Errorf("missing required algorithm protected header")
+	} else if headerAlg != alg {
+		return false, fmt.Errorf("message authenticated with alg %d, expected %d", headerAlg, alg)
+	}
+
+	// Recompute the MAC without modifying the headers of the caller
+	expected := m0.Value
+	m0.Protected = maps.Clone(m0.Protected)
+	if err := m0.Digest(alg, key, nil, aad); err != nil {
+		return false, err
+	}
+	return hmac.Equal(expected, m0.Value), nil
+}
+
 const (
 	macContext  = "MAC"
 	mac0Context = "MAC0"
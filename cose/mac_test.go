@@ -66,3 +66,55 @@ func TestMac0OverEncrypt0(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMac0Verify(t *testing.T) {
+	payload := []byte("This is the content.")
+
+	for _, alg := range []cose.MacAlgorithm{cose.HMac256, cose.HMac384, cose.HMac512, cose.AesCbcMac128_128} {
+		key := make([]byte, alg.KeySize())
+		for i := range key {
+			key[i] = byte(i)
+		}
+
+		var m0 cose.Mac0[[]byte, []byte]
+		if err := m0.Digest(alg, key, &payload, nil); err != nil {
+			t.Fatalf("alg %d: %v", alg, err)
+		}
+		data, err := cbor.Marshal(m0.Tag())
+		if err != nil {
+			t.Fatalf("alg %d: %v", alg, err)
+		}
+		var got cose.Mac0Tag[[]byte, []byte]
+		if err := cbor.Unmarshal(data, &got); err != nil {
+			t.Fatalf("alg %d: %v", alg, err)
+		}
+
+		// Detached payload must be supplied when verifying
+		if ok, err := got.Verify(alg, key, &payload, nil); err != nil {
+			t.Fatalf("alg %d: %v", alg, err)
+		} else if !ok {
+			t.Fatalf("alg %d: verification failed", alg)
+		}
+		if ok, err := got.Verify(alg, key, &payload, []byte("extra")); err != nil {
+			t.Fatalf("alg %d: %v", alg, err)
+		} else if ok {
+			t.Fatalf("alg %d: verification with mismatched AAD succeeded", alg)
+		}
+		wrongKey := make([]byte, len(key))
+		if ok, err := got.Verify(alg, wrongKey, &payload, nil); err != nil {
+			t.Fatalf("alg %d: %v", alg, err)
+		} else if ok {
+			t.Fatalf("alg %d: verification with wrong key succeeded", alg)
+		}
+	}
+
+	t.Run("mismatched alg", func(t *testing.T) {
+		var m0 cose.Mac0[[]byte, []byte]
+		if err := m0.Digest(cose.HMac256, make([]byte, 16), &payload, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m0.Verify(cose.HMac384, make([]byte, 32), &payload, nil); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
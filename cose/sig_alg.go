@@ -5,33 +5,70 @@ package cose
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 )
 
 // SignatureAlgorithm is the ECDSA/RSASSA-PKCS1-v1_5/RSASSA-PKCS1-v1_5
 // signature type and hash.
 type SignatureAlgorithm int64
 
-// HashFunc implements crypto.SignerOpts.
+// HashFunc implements crypto.SignerOpts. For algorithms which sign the message
+// directly, such as EdDSA, the zero value is returned.
 func (alg SignatureAlgorithm) HashFunc() crypto.Hash {
-	newHash, ok := sigAlgorithms[alg]
+	impl, ok := sigAlgorithms[alg]
 	if !ok {
 		panic("signature algorithm not registered")
 	}
-	return newHash()
+	return impl.hash()
 }
 
-var sigAlgorithms = make(map[SignatureAlgorithm]func() crypto.Hash)
+// SignFunc signs the encoded ToBeSigned Sig_structure. Any hashing required by
+// the algorithm must be performed by the function.
+type SignFunc func(rand io.Reader, key crypto.Signer, tbs []byte, opts crypto.SignerOpts) ([]byte, error)
+
+// VerifyFunc verifies a signature over the encoded ToBeSigned Sig_structure.
+// Any hashing required by the algorithm must be performed by the function.
+type VerifyFunc func(key crypto.PublicKey, tbs, sig []byte) (bool, error)
+
+type sigAlgorithm struct {
+	hash   func() crypto.Hash
+	sign   SignFunc
+	verify VerifyFunc
+}
+
+var sigAlgorithms = make(map[SignatureAlgorithm]sigAlgorithm)
 
 // RegisterSignatureAlgorithm adds a new signature algorithm for use in this
-// library. This function should be called in an init func.
+// library. Signing and verification are performed by hashing the message with
+// f and then using ECDSA or RSA, depending on the type of key. This function
+// should be called in an init func.
 func RegisterSignatureAlgorithm(alg SignatureAlgorithm, f func() crypto.Hash) {
+	if f == nil {
+		panic("cannot register nil func")
+	}
+	RegisterSignatureFuncs(alg, f, hashAndSign(alg, f), hashAndVerify(alg, f))
+}
+
+// RegisterSignatureFuncs adds a new signature algorithm for use in this library
+// with custom sign and verify implementations. The hash func should return
+// zero if the algorithm does not prehash the message. This function should be
+// called in an init func.
+//
+// To sign with a registered algorithm which cannot be inferred from the key
+// type, pass the algorithm as the signer opts to Sign1.Sign.
+func RegisterSignatureFuncs(alg SignatureAlgorithm, hash func() crypto.Hash, sign SignFunc, verify VerifyFunc) {
 	if _, ok := sigAlgorithms[alg]; ok {
 		panic("sig algorithm already registered")
 	}
-	if f == nil {
+	if hash == nil || sign == nil || verify == nil {
 		panic("cannot register nil func")
 	}
-	sigAlgorithms[alg] = f
+	sigAlgorithms[alg] = sigAlgorithm{hash: hash, sign: sign, verify: verify}
 }
 
 func init() {
@@ -46,6 +83,65 @@ func init() {
 	RegisterSignatureAlgorithm(PS512Alg, crypto.SHA512.HashFunc)
 }
 
+func hashAndSign(alg SignatureAlgorithm, f func() crypto.Hash) SignFunc {
+	return func(rand io.Reader, key crypto.Signer, tbs []byte, opts crypto.SignerOpts) ([]byte, error) {
+		hash := f()
+		if !hash.Available() {
+			return nil, errors.New("unsupported algorithm")
+		}
+		h := hash.New()
+		_, _ = h.Write(tbs)
+
+		// When the algorithm was given as the signer opts, convert it to opts
+		// understood by crypto.Signer implementations
+		if _, ok := opts.(SignatureAlgorithm); ok {
+			switch alg {
+			case PS256Alg, PS384Alg, PS512Alg:
+				opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+			default:
+				opts = hash
+			}
+		}
+
+		// When an ECDSA key is used, override its Sign implementation
+		// to use RFC8152 signature encoding rather than ASN1.
+		if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+			key = RFC8152Signer{key}
+		}
+		return key.Sign(rand, h.Sum(nil), opts)
+	}
+}
+
+func hashAndVerify(alg SignatureAlgorithm, f func() crypto.Hash) VerifyFunc {
+	return func(key crypto.PublicKey, tbs, sig []byte) (bool, error) {
+		hash := f()
+		if !hash.Available() {
+			return false, errors.New("unsupported algorithm")
+		}
+		h := hash.New()
+		_, _ = h.Write(tbs)
+		digest := h.Sum(nil)
+
+		switch pub := key.(type) {
+		case *ecdsa.PublicKey:
+			// Decode signature following RFC8152 8.1.
+			n := (pub.Params().N.BitLen() + 7) / 8
+			if len(sig) != 2*n {
+				return false, fmt.Errorf("invalid ECDSA signature length: expected %d, got %d", 2*n, len(sig))
+			}
+			r := new(big.Int).SetBytes(sig[:n])
+			s := new(big.Int).SetBytes(sig[n:])
+			return ecdsa.Verify(pub, digest, r, s), nil
+
+		case *rsa.PublicKey:
+			return verifyRSA(pub, hash, digest, sig, alg)
+
+		default:
+			return false, fmt.Errorf("unsupported public key type: %T", key)
+		}
+	}
+}
+
 /*
 ECDSA Algorithm Values
 
@@ -96,3 +192,17 @@ const (
 	PS384Alg SignatureAlgorithm = -38
 	PS512Alg SignatureAlgorithm = -39
 )
+
+/*
+EdDSA Algorithm Values from RFC 8152
+
+	+-------+-------+-------------+
+	| Name  | Value | Description |
+	+-------+-------+-------------+
+	| EdDSA | -8    | EdDSA       |
+	+-------+-------+-------------+
+
+EdDSA is not registered by default. Applications which require it may register
+it with RegisterSignatureFuncs.
+*/
+const EdDSAAlg SignatureAlgorithm = -8
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cose_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+)
+
+func init() {
+	cose.RegisterSignatureFuncs(cose.EdDSAAlg,
+		func() crypto.Hash { return 0 },
+		func(rand io.Reader, key crypto.Signer, tbs []byte, _ crypto.SignerOpts) ([]byte, error) {
+			return key.Sign(rand, tbs, crypto.Hash(0))
+		},
+		func(key crypto.PublicKey, tbs, sig []byte) (bool, error) {
+			pub, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return false, fmt.Errorf("unsupported public key type: %T", key)
+			}
+			return ed25519.Verify(pub, tbs, sig), nil
+		},
+	)
+}
+
+func TestRegisteredSignatureAlgorithm(t *testing.T) {
+	payload := []byte("This is the content.")
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := cose.Sign1[[]byte, []byte]{Payload: cbor.NewByteWrap(payload)}
+	if err := s1.Sign(priv, nil, nil, nil); err == nil {
+		t.Fatal("expected error when algorithm cannot be inferred from key")
+	}
+	if err := s1.Sign(priv, nil, nil, cose.EdDSAAlg); err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	data, err := cbor.Marshal(s1.Tag())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s1t cose.Sign1Tag[[]byte, []byte]
+	if err := cbor.Unmarshal(data, &s1t); err != nil {
+		t.Fatal(err)
+	}
+	if passed, err := s1t.Verify(pub, nil, nil); err != nil {
+		t.Fatalf("error verifying: %v", err)
+	} else if !passed {
+		t.Fatal("verification failed")
+	}
+	if passed, err := s1t.Verify(pub, nil, []byte("wrong aad")); err != nil {
+		t.Fatalf("error verifying: %v", err)
+	} else if passed {
+		t.Fatal("verification passed with wrong AAD")
+	}
+}
+
+func TestSignatureAlgorithmAsOpts(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("This is the content.")
+
+	for _, alg := range []cose.SignatureAlgorithm{cose.RS256Alg, cose.RS384Alg, cose.PS256Alg, cose.PS384Alg} {
+		s1 := cose.Sign1[[]byte, []byte]{Payload: cbor.NewByteWrap(payload)}
+		if err := s1.Sign(key, nil, nil, alg); err != nil {
+			t.Fatalf("alg %d: error signing: %v", alg, err)
+		}
+		var got cose.SignatureAlgorithm
+		if _, err := s1.Protected.Parse(cose.AlgLabel, &got); err != nil || got != alg {
+			t.Fatalf("alg %d: unexpected alg header %d: %v", alg, got, err)
+		}
+		if passed, err := s1.Verify(key.Public(), nil, nil); err != nil {
+			t.Fatalf("alg %d: error verifying: %v", alg, err)
+		} else if !passed {
+			t.Fatalf("alg %d: verification failed", alg)
+		}
+	}
+
+	if _, err := cose.SignatureAlgorithmFor(key.Public(), cose.SignatureAlgorithm(-65535)); err == nil {
+		t.Fatal("expected unregistered algorithm to fail")
+	}
+}
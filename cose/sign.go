@@ -20,6 +20,9 @@ import (
 
 // Sign1 is a COSE_Sign1 signature structure, which is used when only one
 // signature is being placed on a message.
+//
+// When Payload is nil, the payload is detached: it is encoded as CBOR null and
+// must be supplied out of band to both Sign and Verify.
 type Sign1[P, A any] struct {
 	Header    `cbor:",flat2"`
 	Payload   *cbor.ByteWrap[P] // non-empty byte string or null
@@ -29,9 +32,25 @@ type Sign1[P, A any] struct {
 // Tag is a helper for converting to a tag value.
 func (s1 Sign1[P, A]) Tag() *Sign1Tag[P, A] { return &Sign1Tag[P, A]{s1} }
 
-// Sign using a single private key. Unless it was transported independently of
-// the signature, payload may be nil. If no external AAD is supplied, the type
-// should be []byte and the value nil.
+// IsDetached returns whether the payload is transported independently of the
+// message.
+func (s1 Sign1[P, A]) IsDetached() bool { return s1.Payload == nil }
+
+// Detach removes the payload from the message and returns it, so that it can
+// be transported independently. The returned payload must be given to Verify.
+// If the message is already detached, nil is returned.
+func (s1 *Sign1[P, A]) Detach() *P {
+	if s1.Payload == nil {
+		return nil
+	}
+	payload := s1.Payload.Val
+	s1.Payload = nil
+	return &payload
+}
+
+// Sign using a single private key. Unless it is transported independently of
+// the signature (detached), payload must be nil. If no external AAD is
+// supplied, the type should be []byte and the value nil.
 //
 // For RSA keys, opts must either be type *rsa.PSSOptions with a SaltLength
 // value of PSSSaltLengthEqualsHash or equivalent numerical value or a valid
@@ -41,21 +60,22 @@ func (s1 *Sign1[P, A]) Sign(key crypto.Signer, payload *P, additionalData A, opt
 	if s1.Payload == nil && payload == nil {
 		return errors.New("payload was transported independently but not given as an argument to Sign")
 	}
+	if s1.Payload != nil && payload != nil {
+		return errors.New("payload given as an argument to Sign must be detached from the message")
+	}
 	sigPayload := s1.Payload
 	if sigPayload == nil {
 		sigPayload = cbor.NewByteWrap(*payload)
 	}
 
-	// Determine hash and signing algorithm
+	// Determine signing algorithm
 	algID, err := SignatureAlgorithmFor(key.Public(), opts)
 	if err != nil {
 		return err
 	}
-
-	// When an ECDSA key is used, override its Sign implementation
-	// to use RFC8152 signature encoding rather than ASN1.
-	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
-		key = RFC8152Signer{key}
+	impl, ok := sigAlgorithms[algID]
+	if !ok {
+		return fmt.Errorf("signature algorithm %d not registered", algID)
 	}
 
 	// Put algorithm ID in the signature protected header before signing
@@ -69,17 +89,16 @@ func (s1 *Sign1[P, A]) Sign(key crypto.Signer, payload *P, additionalData A, opt
 	}
 
 	// Sign contents of Sig_structure
-	sig := signature1[P, A]{
+	tbs, err := cbor.Marshal(signature1[P, A]{
 		Context:       sig1Context,
 		BodyProtected: body,
 		ExternalAad:   *cbor.NewByteWrap(additionalData),
 		Payload:       *sigPayload,
-	}
-	digest := algID.HashFunc().New()
-	if err := cbor.NewEncoder(digest).Encode(sig); err != nil {
+	})
+	if err != nil {
 		return err
 	}
-	sigBytes, err := key.Sign(rand.Reader, digest.Sum(nil)[:], opts)
+	sigBytes, err := impl.sign(rand.Reader, key, tbs, opts)
 	if err != nil {
 		return err
 	}
@@ -95,22 +114,22 @@ func (s1 *Sign1[P, A]) Sign(key crypto.Signer, payload *P, additionalData A, opt
 }
 
 // Verify using a single public key. Unless it was transported independently of
-// the signature, payload may be nil. If no external AAD is supplied, the type
-// should be []byte and the value nil.
+// the signature (detached), payload must be nil. If no external AAD is
+// supplied, the type should be []byte and the value nil.
 func (s1 Sign1[P, A]) Verify(key crypto.PublicKey, payload *P, additionalData A) (bool, error) {
 	// Check that some payload was given
 	if s1.Payload == nil && payload == nil {
 		return false, errors.New("payload was transported independently but not given as an argument to Verify")
 	}
+	if s1.Payload != nil && payload != nil {
+		return false, errors.New("payload given as an argument to Verify must be detached from the message")
+	}
 	if payload != nil {
 		s1.Payload = cbor.NewByteWrap(*payload)
 	}
-	if len(s1.Signature) < 2 {
+	if len(s1.Signature) == 0 {
 		return false, errors.New("signature length insufficient")
 	}
-	if len(s1.Signature)%2 != 0 {
-		return false, errors.New("signature length must be even")
-	}
 
 	// Get signature algorithm
 	var alg SignatureAlgorithm
@@ -119,42 +138,28 @@ func (s1 Sign1[P, A]) Verify(key crypto.PublicKey, payload *P, additionalData A)
 	} else if !ok {
 		return false, fmt.Errorf("missing signature algorithm protected header")
 	}
+	impl, ok := sigAlgorithms[alg]
+	if !ok {
+		return false, errors.New("unsupported algorithm")
+	}
 
-	// Hash signature structure
+	// Encode signature structure
 	protected, err := newEmptyOrSerializedMap(s1.Protected)
 	if err != nil {
 		return false, fmt.Errorf("error marshaling signature protected body: %W", err)
 	}
-	hash := alg.HashFunc()
-	if !hash.Available() {
-		return false, errors.New("unsupported algorithm")
-	}
-	h := hash.New()
-	if err := cbor.NewEncoder(h).Encode(signature1[P, A]{
+	tbs, err := cbor.Marshal(signature1[P, A]{
 		Context:       sig1Context,
 		BodyProtected: protected,
 		ExternalAad:   *cbor.NewByteWrap(additionalData),
 		Payload:       *s1.Payload,
-	}); err != nil {
+	})
+	if err != nil {
 		return false, err
 	}
 
 	// Verify signature
-	switch pub := key.(type) {
-	case *ecdsa.PublicKey:
-		// Decode signature following RFC8152 8.1.
-		n := (pub.Params().N.BitLen() + 7) / 8
-		r := new(big.Int).SetBytes(s1.Signature[:n])
-		s := new(big.Int).SetBytes(s1.Signature[n:])
-		return ecdsa.Verify(pub, h.Sum(nil), r, s), nil
-
-	case *rsa.PublicKey:
-		digest := h.Sum(nil)
-		return verifyRSA(pub, hash, digest, s1.Signature, alg)
-
-	default:
-		return false, fmt.Errorf("")
-	}
+	return impl.verify(key, tbs, s1.Signature)
 }
 
 func verifyRSA(pub *rsa.PublicKey, hash crypto.Hash, digest []byte, sig []byte, alg SignatureAlgorithm) (bool, error) {
@@ -180,8 +185,6 @@ const (
 // Underlying signature struct for
 //   - sigContext
 //   - sigCtrContext
-//
-//nolint:unused
 type signature[P, A any] struct {
 	Context       string
 	BodyProtected emptyOrSerializedMap
@@ -233,8 +236,16 @@ func (key RFC8152Signer) Sign(rand io.Reader, digest []byte, _ crypto.SignerOpts
 }
 
 // SignatureAlgorithmFor returns the Signature Algorithm identifier for the
-// given key and options.
+// given key and options. If opts is a registered SignatureAlgorithm, then it is
+// returned without inspecting the key.
 func SignatureAlgorithmFor(key crypto.PublicKey, opts crypto.SignerOpts) (SignatureAlgorithm, error) {
+	if alg, ok := opts.(SignatureAlgorithm); ok {
+		if _, registered := sigAlgorithms[alg]; !registered {
+			return 0, fmt.Errorf("signature algorithm %d not registered", alg)
+		}
+		return alg, nil
+	}
+
 	switch pub := key.(type) {
 	case *ecdsa.PublicKey:
 		return ecSigAlg(pub)
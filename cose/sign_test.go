@@ -4,6 +4,7 @@
 package cose_test
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -104,6 +105,67 @@ func TestSignAndVerify(t *testing.T) {
 	})
 }
 
+func TestSignDetached(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("This is the content.")
+
+	var s1 cose.Sign1[[]byte, []byte]
+	if err := s1.Sign(key, &payload, nil, nil); err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	if !s1.IsDetached() {
+		t.Fatal("expected payload to remain detached")
+	}
+	data, err := cbor.Marshal(s1.Tag())
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	var s1t cose.Sign1Tag[[]byte, []byte]
+	if err := cbor.Unmarshal(data, &s1t); err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !s1t.IsDetached() {
+		t.Fatal("expected null payload after decoding")
+	}
+
+	if _, err := s1t.Verify(key.Public(), nil, nil); err == nil {
+		t.Fatal("expected verifying without detached payload to fail")
+	}
+	if passed, err := s1t.Verify(key.Public(), &payload, nil); err != nil {
+		t.Fatalf("error verifying: %v", err)
+	} else if !passed {
+		t.Fatal("verification failed")
+	}
+	wrong := []byte("This is not the content.")
+	if passed, err := s1t.Verify(key.Public(), &wrong, nil); err != nil {
+		t.Fatalf("error verifying: %v", err)
+	} else if passed {
+		t.Fatal("verification passed with wrong payload")
+	}
+
+	t.Run("detach after signing", func(t *testing.T) {
+		s1 := cose.Sign1[[]byte, []byte]{Payload: cbor.NewByteWrap(payload)}
+		if err := s1.Sign(key, &payload, nil, nil); err == nil {
+			t.Fatal("expected error when payload is both attached and detached")
+		}
+		if err := s1.Sign(key, nil, nil, nil); err != nil {
+			t.Fatalf("error signing: %v", err)
+		}
+		detached := s1.Detach()
+		if detached == nil || !bytes.Equal(*detached, payload) || !s1.IsDetached() {
+			t.Fatal("expected payload to be detached")
+		}
+		if passed, err := s1.Verify(key.Public(), detached, nil); err != nil {
+			t.Fatalf("error verifying: %v", err)
+		} else if !passed {
+			t.Fatal("verification failed")
+		}
+	})
+}
+
 // Request 255: [101, 61, "cryptographic verification failed: TO2.ProveOVHdr payload signature verification failed", 1727891427, null]
 func TestSomethingThatFailedSignatureVerificationOnceInCIForUnknownReasons(t *testing.T) {
 	// 18([h'a101390100', {256: h'b2d33efa8e5cea10ea364043bc381bc3', 257: [1, 1, h'30820122300d06092a864886f70d01010105000382010f003082010a0282010100d3e882bc85ebe378b5c043f5f51135f39531c5708fb0a455fb680eff25070502ad3f333de6e1bbaac4c133107f125c8056047d4c77dbdde178eb92b43432f249f7ca080be18b04662d03f4d28873b9569094d50b036d4b8b65eee101ec54b2f834a45e4e297464dc231c74e643ec99fa84b49363d3aa7bb5e73aa96b0c74c886c132f997aea110b4f5b89451a52bfa651d50fcabfde7fb570a99f744f849afdc27732f5bdee138ea2d2ae0e95bc010eae36c9eee7286cc615844d7a84946d4b8c6653563004b528771734f30bff2af9c699d9cf23477663c231f936670aa64bbdd4ab4367a62ab34a5dfb44ca03d4ecc74c28e33803b3ca4a04c0271bbe6d1ad0203010001']}, h'8859017186186550ed5c309ac00d13f29b22912649fac98e806b746573745f64657669636583010159012630820122300d06092a864886f70d01010105000382010f003082010a0282010100d3e882bc85ebe378b5c043f5f51135f39531c5708fb0a455fb680eff25070502ad3f333de6e1bbaac4c133107f125c8056047d4c77dbdde178eb92b43432f249f7ca080be18b04662d03f4d28873b9569094d50b036d4b8b65eee101ec54b2f834a45e4e297464dc231c74e643ec99fa84b49363d3aa7bb5e73aa96b0c74c886c132f997aea110b4f5b89451a52bfa651d50fcabfde7fb570a99f744f849afdc27732f5bdee138ea2d2ae0e95bc010eae36c9eee7286cc615844d7a84946d4b8c6653563004b528771734f30bff2af9c699d9cf23477663c231f936670aa64bbdd4ab4367a62ab34a5dfb44ca03d4ecc74c28e33803b3ca4a04c0271bbe6d1ad0203010001822f58209f17599e0a16082abaf313f448add12acd14c981a3dfa786d240c842113d974000820558206552c303917e65450b187727bb6df531c819421e7148790c045b52dcc1dfbc9d509b5473c6ed93fd29c5507fafc0b5824082390100405820829da9590248b6b8f9b559bb2b5bac3ce88984963fc0fae842a5b5f07c3b15e5822f58206ebb2e1467c7162bb953c36092ad805207a8474ccd18b06267198b184c34eaf419ffff', h'881e74d84932c8986341f8423801f43aab92a813f53ee9902cc5d2ebf48f4ea23ca84fe52f709b1c86b6a17295b605b5d5d1e876069cc0bb7fd9115f16f6e7aceb43c4997053161ca1117110e24ea83afb9bf2092dc1e921dac0ecd533fd33b1e6f6e48a04d085d8a3b9552c6a447f39249509de11d2a52f09b13736d0fee2afe63af26ac6a56b615ed7f937b6b087a3d1105c0e07326cd76c8974e12f75c6dc91b18ec08cdded88b9b32b803becb37757210682c9d975be507c8364ad4ae99e5a903db04ab5f94baa039168d070f641f3685437f32972cb79d4f92fcdc47045d9cdcb9385de1dce1421d3cbf09cd73d34775775e4300c7454ada07c92d38613'])
@@ -0,0 +1,284 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cose
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+/*
+X.509 Certificate Header Parameters (RFC 9360)
+
+	+-----------+-------+-------------------+-------------------------+
+	| Name      | Label | Value Type        | Description             |
+	+-----------+-------+-------------------+-------------------------+
+	| x5bag     | 32    | COSE_X509         | An unordered bag of     |
+	|           |       |                   | X.509 certificates      |
+	| x5chain   | 33    | COSE_X509         | An ordered chain of     |
+	|           |       |                   | X.509 certificates      |
+	| x5t       | 34    | COSE_CertHash     | Hash of an X.509        |
+	|           |       |                   | certificate             |
+	+-----------+-------+-------------------+-------------------------+
+
+	COSE_X509 = bstr / [ 2*certs: bstr ]
+	COSE_CertHash = [ hashAlg: (int / tstr), hashValue: bstr ]
+*/
+var (
+	X5BagLabel   = Label{Int64: 32}
+	X5ChainLabel = Label{Int64: 33}
+	X5TLabel     = Label{Int64: 34}
+)
+
+// HashAlgorithm is a COSE hash algorithm identifier, used in the x5t header.
+type HashAlgorithm int64
+
+/*
+Hash Algorithm Values (RFC 9054)
+
+	+-------------+-------+--------------------------------+
+	| Name        | Value | Description                    |
+	+-------------+-------+--------------------------------+
+	| SHA-256/64  | -15   | SHA-2 256-bit truncated to 64  |
+	| SHA-256     | -16   | SHA-2 256-bit                  |
+	| SHA-512/256 | -17   | SHA-2 512-bit truncated to 256 |
+	| SHA-384     | -43   | SHA-2 384-bit                  |
+	| SHA-512     | -44   | SHA-2 512-bit                  |
+	+-------------+-------+--------------------------------+
+*/
+const (
+	Sha256_64Alg  HashAlgorithm = -15
+	Sha256Alg     HashAlgorithm = -16
+	Sha512_256Alg HashAlgorithm = -17
+	Sha384Alg     HashAlgorithm = -43
+	Sha512Alg     HashAlgorithm = -44
+)
+
+// Sum returns the (possibly truncated) digest of data.
+func (alg HashAlgorithm) Sum(data []byte) ([]byte, error) {
+	var h crypto.Hash
+	truncate := 0
+	switch alg {
+	case Sha256_64Alg:
+		h, truncate = crypto.SHA256, 8
+	case Sha256Alg:
+		h = crypto.SHA256
+	case Sha512_256Alg:
+		h = crypto.SHA512_256
+	case Sha384Alg:
+		h = crypto.SHA384
+	case Sha512Alg:
+		h = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %d", alg)
+	}
+	if !h.Available() {
+		return nil, fmt.Errorf("hash algorithm %d not available", alg)
+	}
+	digest := h.New()
+	_, _ = digest.Write(data)
+	sum := digest.Sum(nil)
+	if truncate > 0 {
+		sum = sum[:truncate]
+	}
+	return sum, nil
+}
+
+// CertHash is the value of the x5t header, identifying a single certificate by
+// its thumbprint.
+type CertHash struct {
+	Algorithm HashAlgorithm
+	Value     []byte
+}
+
+// NewCertHash computes the thumbprint of an X.509 certificate.
+func NewCertHash(alg HashAlgorithm, cert *x509.Certificate) (*CertHash, error) {
+	sum, err := alg.Sum(cert.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return &CertHash{Algorithm: alg, Value: sum}, nil
+}
+
+// Matches reports whether the certificate has the same thumbprint.
+func (h CertHash) Matches(cert *x509.Certificate) bool {
+	sum, err := h.Algorithm.Sum(cert.Raw)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(sum, h.Value)
+}
+
+// ErrNoX5Chain is returned when an x5chain header is required but missing.
+var ErrNoX5Chain = errors.New("x5chain header not present")
+
+// SetX5Chain sets the x5chain header to the ordered certificate chain, leaf
+// first. If protected is true, then the protected header is used.
+func (hdr *Header) SetX5Chain(chain []*x509.Certificate, protected bool) {
+	hdr.setCerts(X5ChainLabel, chain, protected)
+}
+
+// SetX5Bag sets the x5bag header to an unordered set of certificates. If
+// protected is true, then the protected header is used.
+func (hdr *Header) SetX5Bag(certs []*x509.Certificate, protected bool) {
+	hdr.setCerts(X5BagLabel, certs, protected)
+}
+
+// SetX5T sets the x5t header to the thumbprint of a certificate. If protected
+// is true, then the protected header is used.
+func (hdr *Header) SetX5T(alg HashAlgorithm, cert *x509.Certificate, protected bool) error {
+	h, err := NewCertHash(alg, cert)
+	if err != nil {
+		return err
+	}
+	hdr.set(X5TLabel, []any{int64(h.Algorithm), h.Value}, protected)
+	return nil
+}
+
+func (hdr *Header) setCerts(l Label, certs []*x509.Certificate, protected bool) {
+	if len(certs) == 1 {
+		hdr.set(l, certs[0].Raw, protected)
+		return
+	}
+	ders := make([][]byte, len(certs))
+	for i, cert := range certs {
+		ders[i] = cert.Raw
+	}
+	hdr.set(l, ders, protected)
+}
+
+func (hdr *Header) set(l Label, v any, protected bool) {
+	if protected {
+		if hdr.Protected == nil {
+			hdr.Protected = make(HeaderMap)
+		}
+		hdr.Protected[l] = v
+		return
+	}
+	if hdr.Unprotected == nil {
+		hdr.Unprotected = make(HeaderMap)
+	}
+	hdr.Unprotected[l] = v
+}
+
+// X5Chain returns the parsed (but not verified) certificate chain from the
+// x5chain header, preferring the protected header. If the header is not
+// present, the returned slice is nil and err is nil.
+func (hdr Header) X5Chain() ([]*x509.Certificate, error) {
+	return hdr.certs(X5ChainLabel)
+}
+
+// X5Bag returns the parsed (but not verified) certificates from the x5bag
+// header, preferring the protected header. If the header is not present, the
+// returned slice is nil and err is nil.
+func (hdr Header) X5Bag() ([]*x509.Certificate, error) {
+	return hdr.certs(X5BagLabel)
+}
+
+// X5T returns the certificate thumbprint from the x5t header, preferring the
+// protected header. If the header is not present, the returned value is nil
+// and err is nil.
+func (hdr Header) X5T() (*CertHash, error) {
+	var raw cbor.RawBytes
+	if ok, err := hdr.parse(X5TLabel, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing x5t header: %w", err)
+	} else if !ok {
+		return nil, nil
+	}
+	var h CertHash
+	if err := cbor.Unmarshal(raw, &h); err != nil {
+		return nil, fmt.Errorf("error parsing x5t header: %w", err)
+	}
+	return &h, nil
+}
+
+// VerifyX5Chain parses the x5chain header and verifies it using the provided
+// options. Certificates after the leaf and any certificates in the x5bag
+// header are added to a copy of the intermediate pool, so that the pool of the
+// options is not modified. If an x5t header is present, it must match the leaf
+// certificate.
+//
+// The first verified chain is returned, leaf first.
+func (hdr Header) VerifyX5Chain(opts x509.VerifyOptions) ([]*x509.Certificate, error) {
+	chain, err := hdr.X5Chain()
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoX5Chain
+	}
+	bag, err := hdr.X5Bag()
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := hdr.X5T()
+	if err != nil {
+		return nil, err
+	}
+	if thumbprint != nil && !thumbprint.Matches(chain[0]) {
+		return nil, fmt.Errorf("x5t header does not match x5chain leaf certificate")
+	}
+
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	} else {
+		opts.Intermediates = opts.Intermediates.Clone()
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	for _, cert := range bag {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := chain[0].Verify(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying x5chain: %w", err)
+	}
+	return chains[0], nil
+}
+
+func (hdr Header) certs(l Label) ([]*x509.Certificate, error) {
+	var raw cbor.RawBytes
+	if ok, err := hdr.parse(l, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing certificate header %s: %w", l, err)
+	} else if !ok {
+		return nil, nil
+	}
+
+	// COSE_X509 is either a single byte string or an array of byte strings
+	var ders [][]byte
+	var der []byte
+	if err := cbor.Unmarshal(raw, &der); err == nil {
+		ders = [][]byte{der}
+	} else if err := cbor.Unmarshal(raw, &ders); err != nil {
+		return nil, fmt.Errorf("error parsing certificate header %s: %w", l, err)
+	}
+	if len(ders) == 0 {
+		return nil, fmt.Errorf("certificate header %s is empty", l)
+	}
+
+	certs := make([]*x509.Certificate, len(ders))
+	for i, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate %d of header %s: %w", i, l, err)
+		}
+		certs[i] = cert
+	}
+	return certs, nil
+}
+
+// parse looks for a label in the protected header, then the unprotected
+// header.
+func (hdr Header) parse(l Label, v any) (bool, error) {
+	if ok, err := hdr.Protected.Parse(l, v); ok || err != nil {
+		return ok, err
+	}
+	return hdr.Unprotected.Parse(l, v)
+}
@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cose_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+)
+
+func newCert(t *testing.T, name string, isCA bool, pub crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestX5Chain(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	intKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newCert(t, "root", true, rootKey.Public(), nil, rootKey)
+	intermediate := newCert(t, "intermediate", true, intKey.Public(), root, rootKey)
+	leaf := newCert(t, "leaf", false, leafKey.Public(), intermediate, intKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+
+	t.Run("chain in protected header", func(t *testing.T) {
+		var s1 cose.Sign1[[]byte, []byte]
+		s1.Payload = cbor.NewByteWrap([]byte("This is the content."))
+		s1.SetX5Chain([]*x509.Certificate{leaf, intermediate}, true)
+		if err := s1.SetX5T(cose.Sha256Alg, leaf, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := s1.Sign(leafKey, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := cbor.Marshal(s1.Tag())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got cose.Sign1Tag[[]byte, []byte]
+		if err := cbor.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		chain, err := got.X5Chain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chain) != 2 || !chain[0].Equal(leaf) || !chain[1].Equal(intermediate) {
+			t.Fatalf("unexpected x5chain: %v", chain)
+		}
+		thumbprint, err := got.X5T()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if thumbprint == nil || !thumbprint.Matches(leaf) {
+			t.Fatal("x5t did not match leaf")
+		}
+
+		verified, err := got.VerifyX5Chain(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(verified) != 3 || !verified[2].Equal(root) {
+			t.Fatalf("unexpected verified chain length %d", len(verified))
+		}
+		if ok, err := got.Verify(verified[0].PublicKey, nil, nil); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("signature verification failed")
+		}
+	})
+
+	t.Run("single cert with bag", func(t *testing.T) {
+		var s1 cose.Sign1[[]byte, []byte]
+		s1.SetX5Chain([]*x509.Certificate{leaf}, false)
+		s1.SetX5Bag([]*x509.Certificate{intermediate}, false)
+		data, err := cbor.Marshal(s1.Header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr cose.Header
+		if err := cbor.Unmarshal(data, &hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := hdr.VerifyX5Chain(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("intermediate pool not modified", func(t *testing.T) {
+		intermediates := x509.NewCertPool()
+		withPool := opts
+		withPool.Intermediates = intermediates
+
+		var s1 cose.Sign1[[]byte, []byte]
+		s1.SetX5Chain([]*x509.Certificate{leaf, intermediate}, true)
+		if _, err := s1.VerifyX5Chain(withPool); err != nil {
+			t.Fatal(err)
+		}
+		if !intermediates.Equal(x509.NewCertPool()) {
+			t.Fatal("expected intermediate pool of options to be unchanged")
+		}
+
+		// A chain without its intermediate must not verify using the
+		// intermediate of a previous chain
+		s1.SetX5Chain([]*x509.Certificate{leaf}, true)
+		if _, err := s1.VerifyX5Chain(withPool); err == nil {
+			t.Fatal("expected verification to fail")
+		}
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		var s1 cose.Sign1[[]byte, []byte]
+		s1.SetX5Chain([]*x509.Certificate{leaf, intermediate}, true)
+		if _, err := s1.VerifyX5Chain(x509.VerifyOptions{Roots: x509.NewCertPool()}); err == nil {
+			t.Fatal("expected verification to fail")
+		}
+	})
+
+	t.Run("mismatched x5t", func(t *testing.T) {
+		var s1 cose.Sign1[[]byte, []byte]
+		s1.SetX5Chain([]*x509.Certificate{leaf, intermediate}, true)
+		if err := s1.SetX5T(cose.Sha256_64Alg, intermediate, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s1.VerifyX5Chain(opts); err == nil {
+			t.Fatal("expected verification to fail")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		var s1 cose.Sign1[[]byte, []byte]
+		if chain, err := s1.X5Chain(); err != nil || chain != nil {
+			t.Fatalf("expected no chain and no error, got %v, %v", chain, err)
+		}
+		if _, err := s1.VerifyX5Chain(opts); !errors.Is(err, cose.ErrNoX5Chain) {
+			t.Fatalf("expected ErrNoX5Chain, got %v", err)
+		}
+	})
+}
@@ -4,6 +4,10 @@
 package fdo
 
 import (
+	"crypto"
+	"errors"
+	"hash"
+
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
@@ -28,3 +32,54 @@ type DeviceCredential struct {
 	RvInfo        [][]protocol.RvInstruction
 	PublicKeyHash protocol.Hash // expected to be a hash of the entire CBOR structure (not just pkBody) for Voucher.VerifyEntries to succeed
 }
+
+// CredentialStore is a persistence backend for a device credential and access
+// to its associated secrets.
+//
+// Replacing a credential at the end of TO2 is done in two steps: Stage
+// persists the replacement without affecting the active credential, and Commit
+// atomically makes the staged credential active. A failure between the two
+// leaves the previous credential in place.
+type CredentialStore interface {
+	// Read returns the active device credential.
+	Read() (*DeviceCredential, error)
+
+	// HMACs returns HMAC-SHA256 and HMAC-SHA384 hashes keyed with the device
+	// secret. HMAC-SHA384 may be nil if unsupported.
+	HMACs() (hmacSha256, hmacSha384 hash.Hash, _ error)
+
+	// Signer returns the device key.
+	Signer() (crypto.Signer, error)
+
+	// Stage persists a replacement device credential without making it
+	// active. The device secret and key are retained.
+	Stage(DeviceCredential) error
+
+	// Commit makes the most recently staged credential active.
+	Commit() error
+}
+
+// ErrNotActive is returned when onboarding a device whose credential has been
+// marked inactive.
+var ErrNotActive = errors.New("device credential is not active")
+
+// ActivityStore is implemented by a CredentialStore which persists the
+// DCActive flag of the device credential.
+//
+// A device with an active credential runs TO1 and TO2 on boot. After a
+// successful TO2, the device marks its credential inactive, because it has
+// been onboarded. Setting the credential active again causes the device to
+// onboard on its next boot.
+//
+// Since a credential is only replaced at the end of TO2, Stage persists the
+// replacement marked inactive, so that Commit replaces the credential and
+// marks it inactive atomically.
+type ActivityStore interface {
+	CredentialStore
+
+	// Active reports whether the device credential is active.
+	Active() (bool, error)
+
+	// SetActive persists the active state of the device credential.
+	SetActive(bool) error
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// ErrAlgorithmNotAllowed indicates that a peer or voucher uses an algorithm
+// which is not allowed by the crypto profile in use.
+var ErrAlgorithmNotAllowed = errors.New("algorithm not allowed by crypto profile")
+
+// CryptoProfile restricts the cryptographic algorithms which clients and
+// servers use and negotiate. A nil profile allows every supported algorithm.
+//
+// Each list, if nil, allows every algorithm of its kind. A non-nil empty list
+// allows none.
+type CryptoProfile struct {
+	// Name identifies the profile in errors.
+	Name string
+
+	// HashAlgs are the allowed hash and HMAC algorithms, used for voucher
+	// header HMACs, certificate chain hashes, and voucher entry hashes.
+	//
+	// Device and owner public keys must be of a size whose hash algorithm (see
+	// section 3.2.2 of the FDO spec) is allowed.
+	HashAlgs []protocol.HashAlg
+
+	// KeyTypes are the allowed types of manufacturer and owner keys.
+	KeyTypes []protocol.KeyType
+
+	// SigAlgs are the allowed device attestation signature algorithms.
+	SigAlgs []cose.SignatureAlgorithm
+
+	// KeyExchanges are the allowed key exchange suites.
+	KeyExchanges []kex.Suite
+
+	// CipherSuites are the allowed cipher suites for encrypting TO2 messages.
+	CipherSuites []kex.CipherSuiteID
+}
+
+// FIPSProfile allows only FIPS-approved algorithms using SHA-384: P-384 and
+// RSA 3072 keys, SHA-384 hashes and HMACs, and AES-256. The DHKEXid suites are
+// excluded, because their modular exponentiation is not performed by the
+// validated module of boringcrypto or go-fips toolchains, as are the GCM
+// cipher suites, because their key derivation uses HMAC-SHA256.
+var FIPSProfile = CryptoProfile{
+	Name:     "FIPS",
+	HashAlgs: []protocol.HashAlg{protocol.Sha384Hash, protocol.HmacSha384Hash},
+	KeyTypes: []protocol.KeyType{
+		protocol.Secp384r1KeyType,
+		protocol.RsaPkcsKeyType,
+		protocol.RsaPssKeyType,
+	},
+	SigAlgs:      []cose.SignatureAlgorithm{cose.ES384Alg, cose.RS384Alg, cose.PS384Alg},
+	KeyExchanges: []kex.Suite{kex.ECDH384Suite, kex.ASYMKEX3072Suite},
+	CipherSuites: []kex.CipherSuiteID{kex.CoseAes256CtrCipher, kex.CoseAes256CbcCipher},
+}
+
+// DefaultCryptoProfile is used by clients and servers which do not set a
+// CryptoProfile. It is nil, unless built with the fips or requirefips build
+// tag, in which case it is FIPSProfile.
+var DefaultCryptoProfile *CryptoProfile
+
+// cryptoProfileOrDefault returns p, or DefaultCryptoProfile if p is nil.
+func cryptoProfileOrDefault(p *CryptoProfile) *CryptoProfile {
+	if p == nil {
+		return DefaultCryptoProfile
+	}
+	return p
+}
+
+func (p *CryptoProfile) errorf(format string, a ...any) error {
+	return fmt.Errorf("%w [profile=%s]: "+format, append([]any{ErrAlgorithmNotAllowed, p.Name}, a...)...)
+}
+
+// CheckHash returns an error if the hash or HMAC algorithm is not allowed.
+func (p *CryptoProfile) CheckHash(alg protocol.HashAlg) error {
+	if p == nil || p.HashAlgs == nil || slices.Contains(p.HashAlgs, alg) {
+		return nil
+	}
+	return p.errorf("hash algorithm %d", alg)
+}
+
+// CheckKeyType returns an error if the key type is not allowed.
+func (p *CryptoProfile) CheckKeyType(typ protocol.KeyType) error {
+	if p == nil || p.KeyTypes == nil || slices.Contains(p.KeyTypes, typ) {
+		return nil
+	}
+	return p.errorf("key type %s", typ)
+}
+
+// CheckSignature returns an error if the signature algorithm is not allowed.
+func (p *CryptoProfile) CheckSignature(alg cose.SignatureAlgorithm) error {
+	if p == nil || p.SigAlgs == nil || slices.Contains(p.SigAlgs, alg) {
+		return nil
+	}
+	return p.errorf("signature algorithm %d", alg)
+}
+
+// CheckPublicKey returns an error if the hash algorithm corresponding to the
+// size of the public key is not allowed.
+func (p *CryptoProfile) CheckPublicKey(pub crypto.PublicKey) error {
+	if p == nil || p.HashAlgs == nil {
+		return nil
+	}
+	size, err := hashSizeForPubKey(pub)
+	if err != nil {
+		return err
+	}
+	alg := protocol.Sha256Hash
+	if size == 384 {
+		alg = protocol.Sha384Hash
+	}
+	if err := p.CheckHash(alg); err != nil {
+		return p.errorf("public key requiring %s", alg)
+	}
+	return nil
+}
+
+// CheckKeyExchange returns an error if the key exchange or cipher suite is not
+// allowed.
+func (p *CryptoProfile) CheckKeyExchange(suite kex.Suite, cipher kex.CipherSuiteID) error {
+	if p == nil {
+		return nil
+	}
+	if p.KeyExchanges != nil && !slices.Contains(p.KeyExchanges, suite) {
+		return p.errorf("key exchange %s", suite)
+	}
+	if p.CipherSuites != nil && !slices.Contains(p.CipherSuites, cipher) {
+		return p.errorf("cipher suite %s", cipher)
+	}
+	return nil
+}
+
+// CheckVoucher returns an error if the manufacturer key, header HMAC,
+// certificate chain hash, or any entry of a voucher uses an algorithm which is
+// not allowed.
+func (p *CryptoProfile) CheckVoucher(ov *Voucher) error {
+	if p == nil {
+		return nil
+	}
+	ovh := ov.Header.Val
+	if err := p.CheckKeyType(ovh.ManufacturerKey.Type); err != nil {
+		return fmt.Errorf("manufacturer key: %w", err)
+	}
+	if err := p.CheckHash(ov.Hmac.Algorithm); err != nil {
+		return fmt.Errorf("header HMAC: %w", err)
+	}
+	if ovh.CertChainHash != nil {
+		if err := p.CheckHash(ovh.CertChainHash.Algorithm); err != nil {
+			return fmt.Errorf("device certificate chain hash: %w", err)
+		}
+	}
+	for i, entry := range ov.Entries {
+		payload := entry.Payload.Val
+		if err := p.CheckKeyType(payload.PublicKey.Type); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		if err := p.CheckHash(payload.PreviousHash.Algorithm); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		if err := p.CheckHash(payload.HeaderHash.Algorithm); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	return nil
+}
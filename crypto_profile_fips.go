@@ -0,0 +1,8 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build fips || requirefips
+
+package fdo
+
+func init() { DefaultCryptoProfile = &FIPSProfile }
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestFIPSProfile(t *testing.T) {
+	profile := &fdo.FIPSProfile
+	for _, test := range []struct {
+		name    string
+		err     error
+		allowed bool
+	}{
+		{"SHA-384", profile.CheckHash(protocol.Sha384Hash), true},
+		{"HMAC-SHA384", profile.CheckHash(protocol.HmacSha384Hash), true},
+		{"SHA-256", profile.CheckHash(protocol.Sha256Hash), false},
+		{"P-384", profile.CheckKeyType(protocol.Secp384r1KeyType), true},
+		{"RSA 2048", profile.CheckKeyType(protocol.Rsa2048RestrKeyType), false},
+		{"ECDH384/AES256CTR", profile.CheckKeyExchange(kex.ECDH384Suite, kex.CoseAes256CtrCipher), true},
+		{"ASYMKEX3072/AES256CBC", profile.CheckKeyExchange(kex.ASYMKEX3072Suite, kex.CoseAes256CbcCipher), true},
+		{"DHKEXid15", profile.CheckKeyExchange(kex.DHKEXid15Suite, kex.CoseAes256CtrCipher), false},
+		{"A256GCM", profile.CheckKeyExchange(kex.ECDH384Suite, kex.A256GcmCipher), false},
+	} {
+		if test.allowed && test.err != nil {
+			t.Errorf("%s: expected to be allowed, got %v", test.name, test.err)
+		}
+		if !test.allowed && !errors.Is(test.err, fdo.ErrAlgorithmNotAllowed) {
+			t.Errorf("%s: expected to be rejected, got %v", test.name, test.err)
+		}
+	}
+
+	var unrestricted *fdo.CryptoProfile
+	if err := unrestricted.CheckKeyExchange(kex.DHKEXid14Suite, kex.A128GcmCipher); err != nil {
+		t.Errorf("nil profile rejected key exchange: %v", err)
+	}
+}
+
+func TestOnboardWithFIPSProfile(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.DI.CryptoProfile = &fdo.FIPSProfile
+	server.TO1.CryptoProfile = &fdo.FIPSProfile
+	server.TO2.CryptoProfile = &fdo.FIPSProfile
+	ctx := context.Background()
+
+	// P-256 devices cannot be initialized
+	key, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("device secret")
+	if _, err := fdo.DI(ctx, server.Transport(), custom.DeviceMfgInfo{
+		KeyType:     protocol.Secp256r1KeyType,
+		KeyEncoding: protocol.X5ChainKeyEnc,
+		DeviceInfo:  "gotest",
+	}, fdo.DIConfig{
+		HmacSha256: hmac.New(sha256.New, secret),
+		HmacSha384: hmac.New(sha512.New384, secret),
+		Key:        key,
+	}); err == nil {
+		t.Fatal("expected DI of P-256 device to fail")
+	}
+
+	// P-384 devices must negotiate an allowed cipher suite
+	dev := server.NewDevice(t, protocol.Secp384r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, nil); err == nil || !strings.Contains(err.Error(), fdo.ErrAlgorithmNotAllowed.Error()) {
+		t.Fatalf("expected A256GCM to be rejected, got %v", err)
+	}
+
+	to1d, err := fdo.TO1(ctx, server.Transport(), dev.Cred, dev.Key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := fdo.TO2Config{
+		Cred:          dev.Cred,
+		HmacSha256:    dev.HmacSha256,
+		HmacSha384:    dev.HmacSha384,
+		Key:           dev.Key,
+		KeyExchange:   kex.ECDH384Suite,
+		CipherSuite:   kex.A256GcmCipher,
+		CryptoProfile: &fdo.FIPSProfile,
+		Devmod: serviceinfo.Devmod{
+			Os:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Version: "go-fdo test",
+			Device:  "go-validation",
+			FileSep: ";",
+			Bin:     runtime.GOARCH,
+		},
+	}
+	if _, err := fdo.TO2(ctx, server.Transport(), to1d, config); !errors.Is(err, fdo.ErrAlgorithmNotAllowed) {
+		t.Fatalf("expected client to refuse A256GCM, got %v", err)
+	}
+	config.CipherSuite = kex.CoseAes256CtrCipher
+	if _, err := fdo.TO2(ctx, server.Transport(), to1d, config); err != nil {
+		t.Fatal(err)
+	}
+}
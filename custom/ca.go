@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package custom
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+)
+
+// NewIntermediateCA creates a CA certificate for key, issued by a manufacturer
+// key, so that a factory may issue device certificates without holding the
+// manufacturer key. The returned key may be passed to
+// SignDeviceCertificateWithKey and its chain includes the issuer's chain.
+//
+// The CA may not issue further CAs. Its validity is limited to that of the
+// issuer.
+func NewIntermediateCA(issuer *fdo.ManufacturerKey, key crypto.Signer, subject pkix.Name, validity time.Duration) (*fdo.ManufacturerKey, error) {
+	if len(issuer.Chain) == 0 {
+		return nil, fmt.Errorf("manufacturer key %q [type=%s] has no certificate chain", issuer.Name, issuer.Type)
+	}
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("error generating certificate serial number: %w", err)
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.Add(validity)
+	if issuerNotAfter := issuer.Chain[0].NotAfter; notAfter.After(issuerNotAfter) {
+		notAfter = issuerNotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.Chain[0], key.Public(), issuer.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing intermediate CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing intermediate CA certificate: %w", err)
+	}
+	return &fdo.ManufacturerKey{
+		Name:  subject.CommonName,
+		Type:  issuer.Type,
+		Key:   key,
+		Chain: append([]*x509.Certificate{cert}, issuer.Chain...),
+	}, nil
+}
+
+// BuildCertChain orders certificates into a chain starting at leaf, in which
+// each certificate is signed by the next, such as to assemble the chain of an
+// intermediate CA from certificates stored separately. The chain ends at a
+// self-signed certificate or at the last issuer found in certs. Certificates
+// which are not part of the chain are ignored.
+//
+// Only signatures are checked, not validity periods or CA constraints, which
+// are left to verification of the chain against trusted roots.
+func BuildCertChain(leaf *x509.Certificate, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	if leaf == nil {
+		return nil, errors.New("missing leaf certificate")
+	}
+	chain := []*x509.Certificate{leaf}
+	for cert := leaf; !isSelfSigned(cert); {
+		issuer := findIssuer(cert, certs)
+		if issuer == nil {
+			break
+		}
+		for _, c := range chain {
+			if c.Equal(issuer) {
+				return nil, fmt.Errorf("certificate chain loops at %q", issuer.Subject)
+			}
+		}
+		chain = append(chain, issuer)
+		cert = issuer
+	}
+	return chain, nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && signedBy(cert, cert)
+}
+
+func signedBy(cert, issuer *x509.Certificate) bool {
+	return issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+func findIssuer(cert *x509.Certificate, certs []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range certs {
+		if candidate.Equal(cert) || !bytes.Equal(cert.RawIssuer, candidate.RawSubject) {
+			continue
+		}
+		if signedBy(cert, candidate) {
+			return candidate
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@
 package custom
 
 import (
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
@@ -37,6 +38,10 @@ import (
 //
 //	DeviceMfgInfo = bstr, MfgInfo.cbor (bstr-wrap MfgInfo CBOR bytes)
 //
+// The deprecated fields are not supported. In their place, a device may
+// present the certificate chain of its IEEE 802.1AR IDevID, which is omitted
+// when empty, so that the encoding is otherwise unchanged.
+//
 // [C client]: https://github.com/fido-device-onboard/client-sdk-fidoiot/
 // [Java client]: https://github.com/fido-device-onboard/pri-fidoiot
 type DeviceMfgInfo struct {
@@ -48,6 +53,11 @@ type DeviceMfgInfo struct {
 	// ODCAChain          []byte // deprecated
 	// TestSig            []byte // deprecated
 	// TestSigMAROEPrefix []byte // deprecated
+
+	// IDevIDChain is the IDevID certificate chain of the device, starting
+	// with the IDevID certificate. The CSR must be signed by the IDevID key.
+	// See SignDeviceCertificateWithIDevID.
+	IDevIDChain []*cbor.X509Certificate `cbor:",omitempty"`
 }
 
 // CertificateAuthority contains the necessary method to get a CA key and chain
@@ -62,13 +72,9 @@ type CertificateAuthority interface {
 // in DI.AppStart.
 func SignDeviceCertificate(ca CertificateAuthority) func(*DeviceMfgInfo) ([]*x509.Certificate, error) {
 	return func(info *DeviceMfgInfo) ([]*x509.Certificate, error) {
-		// Validate device info
-		csr := x509.CertificateRequest(info.CertInfo)
-		if err := csr.CheckSignature(); err != nil {
-			return nil, fmt.Errorf("invalid CSR: %w", err)
+		if info == nil {
+			return nil, fmt.Errorf("missing device info")
 		}
-
-		// Sign CSR
 		key, chain, err := ca.ManufacturerKey(info.KeyType)
 		if err != nil {
 			var unsupportedErr fdo.ErrUnsupportedKeyType
@@ -77,28 +83,81 @@ func SignDeviceCertificate(ca CertificateAuthority) func(*DeviceMfgInfo) ([]*x50
 			}
 			return nil, fmt.Errorf("error retrieving manufacturer key [type=%s]: %w", info.KeyType, err)
 		}
-		serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-		serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+		return SignDeviceCertificateWithKey(info, &fdo.ManufacturerKey{
+			Type:  info.KeyType,
+			Key:   key,
+			Chain: chain,
+		})
+	}
+}
+
+// SignDeviceCertificateWithKey creates a device certificate chain from the
+// info sent in DI.AppStart, issued by the given manufacturer key. It may be
+// used as DIServer.SignDeviceCertificateWithKey.
+func SignDeviceCertificateWithKey(info *DeviceMfgInfo, mfgKey *fdo.ManufacturerKey) ([]*x509.Certificate, error) {
+	// Validate device info
+	if info == nil {
+		return nil, fmt.Errorf("missing device info")
+	}
+	csr := x509.CertificateRequest(info.CertInfo)
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	if len(mfgKey.Chain) == 0 {
+		return nil, fmt.Errorf("manufacturer key %q [type=%s] has no certificate chain", mfgKey.Name, mfgKey.Type)
+	}
+
+	// Sign CSR
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("error generating certificate serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Issuer:       mfgKey.Chain[0].Subject,
+		Subject:      csr.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(30 * 360 * 24 * time.Hour), // Matches Java impl
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, mfgKey.Chain[0], csr.PublicKey, mfgKey.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing CSR: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signed device cert: %w", err)
+	}
+	return append([]*x509.Certificate{cert}, mfgKey.Chain...), nil
+}
+
+// SelectManufacturerKey returns a DIServer.SelectManufacturerKey function
+// which chooses a manufacturer key of the device's key type by name, as
+// returned by keyName, i.e. the product line of the device. If keyName is nil
+// or returns an empty string, the most recently added key is chosen, so that
+// adding a key rotates to it.
+func SelectManufacturerKey(keys fdo.ManufacturerKeysPersistentState, keyName func(*DeviceMfgInfo) string) func(context.Context, *DeviceMfgInfo) (*fdo.ManufacturerKey, error) {
+	return func(ctx context.Context, info *DeviceMfgInfo) (*fdo.ManufacturerKey, error) {
+		if info == nil {
+			return nil, fmt.Errorf("missing device info")
+		}
+		candidates, err := keys.ManufacturerKeys(ctx, info.KeyType)
 		if err != nil {
-			return nil, fmt.Errorf("error generating certificate serial number: %w", err)
+			return nil, fmt.Errorf("error retrieving manufacturer keys [type=%s]: %w", info.KeyType, err)
 		}
-		template := &x509.Certificate{
-			SerialNumber: serialNumber,
-			Issuer:       chain[0].Subject,
-			Subject:      csr.Subject,
-			NotBefore:    time.Now(),
-			NotAfter:     time.Now().Add(30 * 360 * 24 * time.Hour), // Matches Java impl
-			KeyUsage:     x509.KeyUsageDigitalSignature,
+		var name string
+		if keyName != nil {
+			name = keyName(info)
 		}
-		der, err := x509.CreateCertificate(rand.Reader, template, chain[0], csr.PublicKey, key)
-		if err != nil {
-			return nil, fmt.Errorf("error signing CSR: %w", err)
+		for _, key := range candidates {
+			if name == "" || key.Name == name {
+				return &key, nil
+			}
 		}
-		cert, err := x509.ParseCertificate(der)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing signed device cert: %w", err)
+		if name == "" {
+			return nil, fdo.ErrUnsupportedKeyType(info.KeyType)
 		}
-		chain = append([]*x509.Certificate{cert}, chain...)
-		return chain, nil
+		return nil, fmt.Errorf("%w: manufacturer key %q [type=%s]", fdo.ErrNotFound, name, info.KeyType)
 	}
 }
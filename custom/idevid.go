@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package custom
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ErrMissingIDevID is returned when a device which must present an IDevID
+// does not.
+var ErrMissingIDevID = errors.New("device did not present an IDevID")
+
+// VerifyIDevID verifies the IEEE 802.1AR IDevID certificate chain presented by
+// the device against the roots of device manufacturers and returns the IDevID
+// certificate. The device proves possession of the IDevID key by signing its
+// CSR with it, so the CSR key must be the IDevID key.
+func (info *DeviceMfgInfo) VerifyIDevID(roots *x509.CertPool) (*x509.Certificate, error) {
+	if len(info.IDevIDChain) == 0 {
+		return nil, ErrMissingIDevID
+	}
+	if roots == nil {
+		return nil, errors.New("no IDevID roots configured")
+	}
+	idevid := (*x509.Certificate)(info.IDevIDChain[0])
+	intermediates := x509.NewCertPool()
+	for _, cert := range info.IDevIDChain[1:] {
+		intermediates.AddCert((*x509.Certificate)(cert))
+	}
+	if _, err := idevid.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("error verifying IDevID: %w", err)
+	}
+
+	csr := x509.CertificateRequest(info.CertInfo)
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	pub, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(idevid.PublicKey) {
+		return nil, errors.New("CSR was not signed by IDevID key")
+	}
+	return idevid, nil
+}
+
+// IDevIDSerialNumber returns the serial number of the device identified by an
+// IDevID certificate, which IEEE 802.1AR places in the serialNumber attribute
+// of the subject.
+func IDevIDSerialNumber(idevid *x509.Certificate) (string, error) {
+	if idevid.Subject.SerialNumber == "" {
+		return "", errors.New("IDevID subject has no serial number")
+	}
+	return idevid.Subject.SerialNumber, nil
+}
+
+// SignDeviceCertificateWithIDevID returns a DIServer.SignDeviceCertificate
+// function which verifies the IDevID of a device before calling sign, i.e. the
+// result of SignDeviceCertificate.
+//
+// The serial number of the device info is replaced by that of the IDevID and
+// the subject of the CSR by that of the IDevID, so that the device certificate,
+// and therefore the voucher, is bound to the authenticated identity rather
+// than to self-reported values. If required is false, devices which do not
+// present an IDevID are passed to sign as is.
+func SignDeviceCertificateWithIDevID(roots *x509.CertPool, required bool, sign func(*DeviceMfgInfo) ([]*x509.Certificate, error)) func(*DeviceMfgInfo) ([]*x509.Certificate, error) {
+	return func(info *DeviceMfgInfo) ([]*x509.Certificate, error) {
+		if info == nil {
+			return nil, fmt.Errorf("missing device info")
+		}
+		idevid, err := info.VerifyIDevID(roots)
+		if errors.Is(err, ErrMissingIDevID) && !required {
+			return sign(info)
+		}
+		if err != nil {
+			return nil, err
+		}
+		serial, err := IDevIDSerialNumber(idevid)
+		if err != nil {
+			return nil, err
+		}
+		info.SerialNumber = serial
+		info.CertInfo.Subject = idevid.Subject
+		return sign(info)
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// ErrInvalidStatusTransition is returned by DeviceStatusPersistentState when a
+// device cannot move from its current lifecycle state to the next.
+var ErrInvalidStatusTransition = errors.New("invalid device status transition")
+
+// DeviceLifecycle is a stage of the lifecycle of a device, from
+// initialization by its manufacturer to onboarding by its owner.
+type DeviceLifecycle string
+
+// Device lifecycle states
+const (
+	// DI completed and the voucher was stored.
+	DeviceInitialized DeviceLifecycle = "initialized"
+
+	// TO0 accepted the rendezvous blob of the device.
+	DeviceRegistered DeviceLifecycle = "registered"
+
+	// The device proved itself to the rendezvous server in TO1.
+	DeviceContacted DeviceLifecycle = "contacted"
+
+	// TO2.HelloDevice was accepted and TO2 has not yet completed.
+	DeviceOnboarding DeviceLifecycle = "onboarding"
+
+	// TO2.Done was accepted.
+	DeviceOnboarded DeviceLifecycle = "onboarded"
+
+	// The voucher was extended to a new owner.
+	DeviceResold DeviceLifecycle = "resold"
+)
+
+// deviceTransitions are the states which may follow each state. TO1 and TO2
+// may be retried after a failure, a voucher may be resold at any time, and an
+// onboarded or resold device may be registered and onboarded again.
+var deviceTransitions = map[DeviceLifecycle][]DeviceLifecycle{
+	DeviceInitialized: {DeviceRegistered, DeviceContacted, DeviceOnboarding, DeviceResold},
+	DeviceRegistered:  {DeviceRegistered, DeviceContacted, DeviceOnboarding, DeviceResold},
+	DeviceContacted:   {DeviceContacted, DeviceOnboarding, DeviceResold},
+	DeviceOnboarding:  {DeviceContacted, DeviceOnboarding, DeviceOnboarded, DeviceResold},
+	DeviceOnboarded:   {DeviceRegistered, DeviceContacted, DeviceOnboarding, DeviceResold},
+	DeviceResold:      {DeviceRegistered, DeviceContacted, DeviceOnboarding},
+}
+
+// CanTransition reports whether a device in the state may move to the next
+// state. A device without a state, i.e. one first seen by a service which did
+// not perform its earlier steps, may start in any state.
+func (l DeviceLifecycle) CanTransition(next DeviceLifecycle) bool {
+	if _, ok := deviceTransitions[next]; !ok {
+		return false
+	}
+	if l == "" {
+		return true
+	}
+	for _, allowed := range deviceTransitions[l] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceStatus is the lifecycle state of a device.
+type DeviceStatus struct {
+	GUID  protocol.GUID
+	State DeviceLifecycle
+
+	// Updated is when the device entered its current state.
+	Updated time.Time
+
+	// Times contains when the device most recently entered each state it has
+	// been in.
+	Times map[DeviceLifecycle]time.Time
+}
+
+// DeviceStatusEvent describes a stored change of the lifecycle state of a
+// device.
+type DeviceStatusEvent struct {
+	GUID  protocol.GUID
+	State DeviceLifecycle
+	Time  time.Time
+}
+
+// DeviceStatusNotifier wraps a DeviceStatusPersistentState and calls Notify
+// after each status update is stored, i.e. to publish events to a message
+// bus. Notify is called synchronously, so it should return quickly.
+type DeviceStatusNotifier struct {
+	DeviceStatusPersistentState
+	Notify func(context.Context, DeviceStatusEvent)
+}
+
+// UpdateDeviceStatus stores the status update and then calls Notify.
+func (n DeviceStatusNotifier) UpdateDeviceStatus(ctx context.Context, guid protocol.GUID, state DeviceLifecycle, at time.Time) error {
+	if err := n.DeviceStatusPersistentState.UpdateDeviceStatus(ctx, guid, state, at); err != nil {
+		return err
+	}
+	if n.Notify != nil {
+		n.Notify(ctx, DeviceStatusEvent{GUID: guid, State: state, Time: at})
+	}
+	return nil
+}
+
+// updateDeviceStatus records a lifecycle state of a device in the store, if
+// one is set. Failure to record does not fail the protocol.
+func updateDeviceStatus(ctx context.Context, store DeviceStatusPersistentState, guid protocol.GUID, state DeviceLifecycle) {
+	if store == nil {
+		return
+	}
+	err := store.UpdateDeviceStatus(ctx, guid, state, time.Now())
+	switch {
+	case errors.Is(err, ErrInvalidStatusTransition):
+		slog.Debug("device status not updated", "guid", guid, "state", state, "error", err)
+	case err != nil:
+		slog.Warn("error updating device status", "guid", guid, "state", state, "error", err)
+	}
+}
+
+// recordDeviceStatus updates the lifecycle state of a device on the start and
+// completion of TO2.
+func (s *TO2Server) recordDeviceStatus(ctx context.Context, msgType uint8, msgErr error) {
+	if s.DeviceStatus == nil || msgErr != nil {
+		return
+	}
+
+	var state DeviceLifecycle
+	switch msgType {
+	case protocol.TO2HelloDeviceMsgType:
+		state = DeviceOnboarding
+	case protocol.TO2DoneMsgType:
+		state = DeviceOnboarded
+	default:
+		return
+	}
+
+	guid, err := s.Session.GUID(ctx)
+	if err != nil {
+		return
+	}
+	updateDeviceStatus(ctx, s.DeviceStatus, guid, state)
+	if state == DeviceOnboarded {
+		if replacement, err := s.Session.ReplacementGUID(ctx); err == nil && replacement != guid {
+			updateDeviceStatus(ctx, s.DeviceStatus, replacement, state)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestDeviceStatusResell(t *testing.T) {
+	server := fdotest.NewServer(t)
+	var events []fdo.DeviceStatusEvent
+	notifier := fdo.DeviceStatusNotifier{
+		DeviceStatusPersistentState: server.State,
+		Notify: func(_ context.Context, event fdo.DeviceStatusEvent) {
+			events = append(events, event)
+		},
+	}
+	server.DI.DeviceStatus = notifier
+	server.TO2.DeviceStatus = notifier
+
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	nextOwner, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.TO2.Resell(context.Background(), dev.Cred.GUID, nextOwner.Public(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := server.State.DeviceStatus(context.Background(), dev.Cred.GUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != fdo.DeviceResold || status.Times[fdo.DeviceInitialized].IsZero() {
+		t.Fatalf("expected device to be resold after initialization, got %+v", status)
+	}
+	if len(events) != 2 || events[0].State != fdo.DeviceInitialized || events[1].State != fdo.DeviceResold {
+		t.Fatalf("unexpected status events: %+v", events)
+	}
+
+	// A resold device cannot be resold again until it is onboarded or
+	// registered by its new owner
+	if err := notifier.UpdateDeviceStatus(context.Background(), dev.Cred.GUID, fdo.DeviceResold, status.Updated); err == nil {
+		t.Fatal("expected invalid transition")
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected no event for invalid transition, got %+v", events)
+	}
+}
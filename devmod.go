@@ -16,6 +16,10 @@ import (
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 )
 
+// maxDevmodModules limits the devmod nummodules message, which is used to
+// allocate the module list.
+const maxDevmodModules = 1024
+
 type devmodOwnerModule struct {
 	serviceinfo.Devmod
 	Modules    []string
@@ -29,7 +33,13 @@ func (d *devmodOwnerModule) HandleInfo(ctx context.Context, messageName string,
 		var ignore bool
 		return cbor.NewDecoder(messageBody).Decode(&ignore)
 	case "nummodules":
-		return cbor.NewDecoder(messageBody).Decode(&d.numModules)
+		if err := cbor.NewDecoder(messageBody).Decode(&d.numModules); err != nil {
+			return err
+		}
+		if d.numModules < 0 || d.numModules > maxDevmodModules {
+			return fmt.Errorf("invalid devmod nummodules: %d", d.numModules)
+		}
+		return nil
 	case "modules":
 		return d.parseModules(messageBody)
 	}
@@ -70,6 +80,9 @@ func (d *devmodOwnerModule) parseModules(messageBody io.Reader) error {
 			chunk.Start = idx
 		}
 
+		if chunk.Start+chunk.Len > len(d.Modules) {
+			return fmt.Errorf("invalid devmod module chunk: exceeds nummodules")
+		}
 		copy(d.Modules[chunk.Start:chunk.Start+chunk.Len], chunk.Modules)
 		d.done = chunk.Start+chunk.Len == d.numModules
 	}
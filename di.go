@@ -7,7 +7,6 @@ import (
 	"context"
 	"crypto"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"fmt"
@@ -24,7 +23,7 @@ import (
 type DIConfig struct {
 	// HMAC-SHA256 with a device secret that does not change when ownership is
 	// transferred. HMAC-SHA256 support is always required by spec, so this
-	// field must be non-nil.
+	// field must be non-nil unless DeviceHmac is set.
 	//
 	// This hash.Hash may optionally implement the following interface to
 	// return errors from Reset/Write/Sum, noting that implementations of
@@ -48,12 +47,21 @@ type DIConfig struct {
 	// 	}
 	HmacSha384 hash.Hash
 
+	// DeviceHmac, if set, computes HMACs with the device secret in place of
+	// HmacSha256 and HmacSha384, which may then be nil. It allows the secret
+	// to be held by an external service, such as an HSM.
+	DeviceHmac DeviceHmac
+
 	// An ECDSA or RSA private key
 	Key crypto.Signer
 
 	// When true and an RSA key is used as a crypto.Signer argument, RSA-SSAPSS
 	// will be used for signing
 	PSS bool
+
+	// CryptoProfile restricts the manufacturer key type and hash algorithm of
+	// the voucher. If nil, DefaultCryptoProfile is used.
+	CryptoProfile *CryptoProfile
 }
 
 // DI runs the DI protocol and returns the voucher header and manufacturer
@@ -93,6 +101,10 @@ func DI(ctx context.Context, transport Transport, info any, c DIConfig) (*Device
 	if err != nil {
 		return nil, fmt.Errorf("error selecting the appropriate hash algorithm: %w", err)
 	}
+	if err := checkDIProfile(cryptoProfileOrDefault(c.CryptoProfile), ovh.ManufacturerKey.Type, c.Key.Public(), alg); err != nil {
+		errorMsg(ctx, transport, err)
+		return nil, err
+	}
 
 	// Hash initial owner public key
 	ownerKeyDigest := alg.HashFunc().New()
@@ -103,14 +115,12 @@ func DI(ctx context.Context, transport Transport, info any, c DIConfig) (*Device
 	}
 	ownerKeyHash := protocol.Hash{Algorithm: alg, Value: ownerKeyDigest.Sum(nil)[:]}
 
-	var hmac hash.Hash
-	switch alg {
-	case protocol.Sha256Hash:
-		hmac = c.HmacSha256
-	case protocol.Sha384Hash:
-		hmac = c.HmacSha384
+	hmacAlg := protocol.HmacSha256Hash
+	if alg == protocol.Sha384Hash {
+		hmacAlg = protocol.HmacSha384Hash
 	}
-	if err := setHmac(ctx, transport, hmac, ovh); err != nil {
+	hmac := deviceHmacOrLocal(c.DeviceHmac, c.HmacSha256, c.HmacSha384)
+	if err := setHmac(ctx, transport, hmac, hmacAlg, ovh); err != nil {
 		errorMsg(ctx, transport, err)
 		return nil, err
 	}
@@ -124,6 +134,21 @@ func DI(ctx context.Context, transport Transport, info any, c DIConfig) (*Device
 	}, nil
 }
 
+// checkDIProfile checks the manufacturer key type, device public key, and
+// voucher hash algorithm chosen during DI against a crypto profile.
+func checkDIProfile(profile *CryptoProfile, keyType protocol.KeyType, devicePubKey crypto.PublicKey, alg protocol.HashAlg) error {
+	if err := profile.CheckKeyType(keyType); err != nil {
+		return fmt.Errorf("manufacturer key: %w", err)
+	}
+	if err := profile.CheckPublicKey(devicePubKey); err != nil {
+		return fmt.Errorf("device key: %w", err)
+	}
+	if err := profile.CheckHash(alg); err != nil {
+		return fmt.Errorf("voucher hash: %w", err)
+	}
+	return nil
+}
+
 // AppStart(10) -> SetCredentials(11)
 func appStart(ctx context.Context, transport Transport, info any) (*VoucherHeader, error) {
 	// Define request structure
@@ -176,6 +201,7 @@ func (s *DIServer[T]) setCredentials(ctx context.Context, msg io.Reader) (*setCr
 		Info *cbor.Bstr[T]
 	}
 	if err := cbor.NewDecoder(msg).Decode(&appStart); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding device manufacturing info: %w", err)
 	}
 	var info *T // Null info is valid
@@ -184,7 +210,7 @@ func (s *DIServer[T]) setCredentials(ctx context.Context, msg io.Reader) (*setCr
 	}
 
 	// Create and store a new device certificate chain
-	chain, err := s.SignDeviceCertificate(info)
+	chain, err := s.signDeviceCertificate(ctx, info)
 	if err != nil {
 		return nil, fmt.Errorf("error creating device certificate chain: %w", err)
 	}
@@ -210,16 +236,25 @@ func (s *DIServer[T]) setCredentials(ctx context.Context, msg io.Reader) (*setCr
 
 	// Use issuer chain of device certificate to identify manufacturer pubkey
 	// and encode as the device requested
-	mfgPubKey, err := encodePublicKey(keyType, keyEncoding, chain[1:])
+	mfgChain, err := s.manufacturerChain(ctx, keyType, chain)
+	if err != nil {
+		return nil, err
+	}
+	mfgPubKey, err := protocol.PublicKeyFromChain(keyType, keyEncoding, mfgChain)
 	if err != nil {
 		return nil, fmt.Errorf("error constructing manufacturer public key from CA chain: %w", err)
 	}
 
-	// Compute the appropriate cert chain hash
-	alg, err := hashAlgFor(chain[0].PublicKey, chain[1].PublicKey)
+	// Compute the appropriate cert chain hash over the full chain, including
+	// any intermediate CAs
+	alg, err := hashAlgFor(chain[0].PublicKey, mfgChain[0].PublicKey)
 	if err != nil {
 		return nil, fmt.Errorf("error determining appropriate device cert chain hash algorithm: %w", err)
 	}
+	if err := checkDIProfile(cryptoProfileOrDefault(s.CryptoProfile), keyType, chain[0].PublicKey, alg); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return nil, err
+	}
 	certChain := make([]*cbor.X509Certificate, len(chain))
 	certChainHash := alg.HashFunc().New()
 	for i, cert := range chain {
@@ -228,12 +263,14 @@ func (s *DIServer[T]) setCredentials(ctx context.Context, msg io.Reader) (*setCr
 	}
 
 	// Generate voucher header
-	var guid protocol.GUID
-	if _, err := rand.Read(guid[:]); err != nil {
+	guid, err := s.newGUID(ctx)
+	if err != nil {
 		return nil, fmt.Errorf("error generating device GUID: %w", err)
 	}
+	protocol.TraceGUID(ctx, guid)
+	version := protocol.VersionFromContext(ctx)
 	ovh := &VoucherHeader{
-		Version:         101,
+		Version:         version,
 		GUID:            guid,
 		DeviceInfo:      deviceInfo,
 		ManufacturerKey: *mfgPubKey,
@@ -243,7 +280,7 @@ func (s *DIServer[T]) setCredentials(ctx context.Context, msg io.Reader) (*setCr
 		},
 	}
 	rvInfo, err := s.RvInfo(ctx, &Voucher{
-		Version:   101,
+		Version:   version,
 		Header:    *cbor.NewBstr(*ovh),
 		CertChain: &certChain,
 	})
@@ -261,28 +298,86 @@ func (s *DIServer[T]) setCredentials(ctx context.Context, msg io.Reader) (*setCr
 	}, nil
 }
 
-func encodePublicKey(keyType protocol.KeyType, keyEncoding protocol.KeyEncoding, chain []*x509.Certificate) (*protocol.PublicKey, error) {
-	switch keyEncoding {
-	case protocol.X509KeyEnc, protocol.CoseKeyEnc:
-		switch keyType {
-		case protocol.Secp256r1KeyType, protocol.Secp384r1KeyType:
-			return protocol.NewPublicKey(keyType, chain[0].PublicKey.(*ecdsa.PublicKey), keyEncoding == protocol.CoseKeyEnc)
-		case protocol.Rsa2048RestrKeyType, protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
-			return protocol.NewPublicKey(keyType, chain[0].PublicKey.(*rsa.PublicKey), keyEncoding == protocol.CoseKeyEnc)
-		default:
-			return nil, fmt.Errorf("unsupported key type: %s", keyType)
+// signDeviceCertificate creates a device certificate chain, using the selected
+// manufacturer key when multiple manufacturer keys are configured.
+func (s *DIServer[T]) signDeviceCertificate(ctx context.Context, info *T) ([]*x509.Certificate, error) {
+	if s.ManufacturerKeys == nil {
+		return s.SignDeviceCertificate(info)
+	}
+	if s.SelectManufacturerKey == nil || s.SignDeviceCertificateWithKey == nil {
+		return nil, fmt.Errorf("manufacturer key selection and signing must be set when multiple manufacturer keys are used")
+	}
+	key, err := s.SelectManufacturerKey(ctx, info)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting manufacturer key: %w", err)
+	}
+	return s.SignDeviceCertificateWithKey(info, key)
+}
+
+// manufacturerChain returns the certificate chain of the manufacturer key from
+// a device certificate chain. The manufacturer key is the issuer of the device
+// certificate, unless the chain includes the certificate of a manufacturer key
+// used for voucher extension. This allows device certificates to be issued by
+// an intermediate CA rather than by the manufacturer key itself.
+func (s *DIServer[T]) manufacturerChain(ctx context.Context, keyType protocol.KeyType, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("device certificate chain is missing manufacturer certificate")
+	}
+	var keys []crypto.PublicKey
+	switch {
+	case s.ManufacturerKeys != nil:
+		mfgKeys, err := s.ManufacturerKeys.ManufacturerKeys(ctx, keyType)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s manufacturer keys: %w", keyType, err)
 		}
-	case protocol.X5ChainKeyEnc:
-		return protocol.NewPublicKey(keyType, chain, false)
-	default:
-		return nil, fmt.Errorf("unsupported key encoding: %s", keyEncoding)
+		for _, key := range mfgKeys {
+			keys = append(keys, key.Key.Public())
+		}
+	case s.AutoExtend != nil:
+		key, _, err := s.AutoExtend.ManufacturerKey(keyType)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s manufacturer key: %w", keyType, err)
+		}
+		keys = append(keys, key.Public())
 	}
+	for i, cert := range chain[1:] {
+		for _, key := range keys {
+			if publicKeyEqual(key, cert.PublicKey) {
+				return chain[1+i:], nil
+			}
+		}
+	}
+	return chain[1:], nil
+}
+
+// signingManufacturerKey returns the manufacturer key which issued a device
+// certificate chain, directly or through intermediate CAs, or nil if multiple
+// manufacturer keys are not configured.
+func (s *DIServer[T]) signingManufacturerKey(ctx context.Context, keyType protocol.KeyType, chain []*x509.Certificate) (*ManufacturerKey, error) {
+	if s.ManufacturerKeys == nil {
+		return nil, nil
+	}
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("device certificate chain is missing manufacturer certificate")
+	}
+	keys, err := s.ManufacturerKeys.ManufacturerKeys(ctx, keyType)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s manufacturer keys: %w", keyType, err)
+	}
+	for _, cert := range chain[1:] {
+		for _, key := range keys {
+			if publicKeyEqual(key.Key.Public(), cert.PublicKey) {
+				return &key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no %s manufacturer key issued device certificate", ErrNotFound, keyType)
 }
 
 // SetHMAC(12) -> Done(13)
-func setHmac(ctx context.Context, transport Transport, hmac hash.Hash, ovh *VoucherHeader) (err error) {
+func setHmac(ctx context.Context, transport Transport, hmac DeviceHmac, alg protocol.HashAlg, ovh *VoucherHeader) (err error) {
 	// Compute HMAC
-	ovhHash, err := hmacHash(hmac, ovh)
+	ovhHash, err := hmacHash(ctx, hmac, alg, ovh)
 	if err != nil {
 		return fmt.Errorf("error computing HMAC of ownership voucher header: %w", err)
 	}
@@ -326,8 +421,13 @@ func (s *DIServer[T]) diDone(ctx context.Context, msg io.Reader) (struct{}, erro
 		Hmac protocol.Hmac
 	}
 	if err := cbor.NewDecoder(msg).Decode(&req); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return struct{}{}, fmt.Errorf("error parsing DI.SetHMAC request: %w", err)
 	}
+	if err := cryptoProfileOrDefault(s.CryptoProfile).CheckHash(req.Hmac.Algorithm); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return struct{}{}, fmt.Errorf("voucher header HMAC: %w", err)
+	}
 	ovh, err := s.Session.IncompleteVoucherHeader(ctx)
 	if err != nil {
 		return struct{}{}, fmt.Errorf("voucher header not found for session: %w", err)
@@ -341,33 +441,52 @@ func (s *DIServer[T]) diDone(ctx context.Context, msg io.Reader) (struct{}, erro
 		certChain[i] = (*cbor.X509Certificate)(cert)
 	}
 	ov := &Voucher{
-		Version:   101,
+		Version:   ovh.Version,
 		Header:    *cbor.NewBstr(*ovh),
 		Hmac:      req.Hmac,
 		CertChain: &certChain,
 		Entries:   nil,
 	}
-	if err := s.maybeAutoExtend(ov); err != nil {
+	mfgKey, err := s.signingManufacturerKey(ctx, ovh.ManufacturerKey.Type, deviceCertChain)
+	if err != nil {
+		return struct{}{}, err
+	}
+	if err := s.maybeAutoExtend(ov, mfgKey); err != nil {
 		return struct{}{}, fmt.Errorf("error extending voucher: %w", err)
 	}
 	if err := s.Vouchers.NewVoucher(ctx, ov); err != nil {
 		return struct{}{}, fmt.Errorf("error storing voucher: %w", err)
 	}
+	if recorder, ok := s.Vouchers.(VoucherManufacturerKeyPersistentState); ok && mfgKey != nil {
+		if err := recorder.SetVoucherManufacturerKey(ctx, ovh.GUID, mfgKey.Name); err != nil {
+			return struct{}{}, fmt.Errorf("error recording voucher manufacturer key: %w", err)
+		}
+	}
+	if err := s.storeVoucherMetadata(ctx, ov); err != nil {
+		return struct{}{}, fmt.Errorf("error storing voucher metadata: %w", err)
+	}
+	updateDeviceStatus(ctx, s.DeviceStatus, ovh.GUID, DeviceInitialized)
 	if err := s.maybeAutoTO0(ctx, ov); err != nil {
 		return struct{}{}, fmt.Errorf("error auto-registering device for rendezvous: %w", err)
 	}
 	return struct{}{}, nil
 }
 
-func (s *DIServer[T]) maybeAutoExtend(ov *Voucher) error {
+func (s *DIServer[T]) maybeAutoExtend(ov *Voucher, mfgKey *ManufacturerKey) error {
 	if s.AutoExtend == nil {
 		return nil
 	}
 
 	keyType := ov.Header.Val.ManufacturerKey.Type
-	owner, _, err := s.AutoExtend.ManufacturerKey(keyType)
-	if err != nil {
-		return fmt.Errorf("error getting %s manufacturer key: %w", keyType, err)
+	var owner crypto.Signer
+	if mfgKey != nil {
+		owner = mfgKey.Key
+	} else {
+		var err error
+		owner, _, err = s.AutoExtend.ManufacturerKey(keyType)
+		if err != nil {
+			return fmt.Errorf("error getting %s manufacturer key: %w", keyType, err)
+		}
 	}
 	nextOwner, _, err := s.AutoExtend.OwnerKey(keyType)
 	if err != nil {
@@ -383,8 +502,7 @@ func (s *DIServer[T]) maybeAutoExtend(ov *Voucher) error {
 		if err != nil {
 			return err
 		}
-		*ov = *extended
-		return nil
+		return s.counterSign(ov, extended)
 
 	case *rsa.PublicKey:
 		nextOwner, ok := nextOwner.Public().(*rsa.PublicKey)
@@ -395,14 +513,26 @@ func (s *DIServer[T]) maybeAutoExtend(ov *Voucher) error {
 		if err != nil {
 			return err
 		}
-		*ov = *extended
-		return nil
+		return s.counterSign(ov, extended)
 
 	default:
 		return fmt.Errorf("invalid key type %T", owner)
 	}
 }
 
+// counterSign replaces the voucher with its extension, countersigned by the
+// notary if one is set.
+func (s *DIServer[T]) counterSign(ov, extended *Voucher) error {
+	if s.Notary != nil {
+		var err error
+		if extended, err = CounterSignVoucher(extended, s.Notary); err != nil {
+			return err
+		}
+	}
+	*ov = *extended
+	return nil
+}
+
 func (s *DIServer[T]) maybeAutoTO0(ctx context.Context, ov *Voucher) error {
 	if s.AutoTO0 == nil {
 		return nil
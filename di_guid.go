@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// GUIDGenerator assigns the GUIDs of devices during DI, such as to embed plant
+// or line identifiers. GUIDs must be unique, because vouchers are stored and
+// looked up by GUID.
+type GUIDGenerator interface {
+	NewGUID(context.Context) (protocol.GUID, error)
+}
+
+// GUIDGeneratorFunc is a function implementing GUIDGenerator.
+type GUIDGeneratorFunc func(context.Context) (protocol.GUID, error)
+
+// NewGUID implements GUIDGenerator.
+func (f GUIDGeneratorFunc) NewGUID(ctx context.Context) (protocol.GUID, error) { return f(ctx) }
+
+// PrefixGUIDGenerator generates GUIDs which start with a fixed prefix, i.e. a
+// plant and line identifier, followed by random bytes.
+type PrefixGUIDGenerator struct {
+	// Prefix is copied to the start of each GUID. It must leave enough random
+	// bytes for GUIDs to be unique.
+	Prefix []byte
+
+	// Rand is the source of randomness for the remainder of each GUID. If
+	// nil, crypto/rand.Reader is used.
+	Rand io.Reader
+}
+
+// NewGUID implements GUIDGenerator.
+func (g PrefixGUIDGenerator) NewGUID(context.Context) (protocol.GUID, error) {
+	var guid protocol.GUID
+	if len(g.Prefix) >= len(guid) {
+		return guid, fmt.Errorf("GUID prefix must be shorter than %d bytes", len(guid))
+	}
+	n := copy(guid[:], g.Prefix)
+	if _, err := io.ReadFull(randOrDefault(g.Rand), guid[n:]); err != nil {
+		return guid, err
+	}
+	return guid, nil
+}
+
+// newGUID generates the GUID of a device being initialized.
+func (s *DIServer[T]) newGUID(ctx context.Context) (protocol.GUID, error) {
+	if s.GUIDs != nil {
+		return s.GUIDs.NewGUID(ctx)
+	}
+	var guid protocol.GUID
+	if _, err := io.ReadFull(randOrDefault(s.Rand), guid[:]); err != nil {
+		return guid, err
+	}
+	return guid, nil
+}
+
+// storeVoucherMetadata records the metadata of a new voucher, if the server is
+// configured to produce any.
+func (s *DIServer[T]) storeVoucherMetadata(ctx context.Context, ov *Voucher) error {
+	if s.VoucherMetadata == nil {
+		return nil
+	}
+	store, ok := s.Vouchers.(VoucherMetadataPersistentState)
+	if !ok {
+		return errors.New("voucher state does not support metadata")
+	}
+	metadata, err := s.VoucherMetadata(ctx, ov)
+	if err != nil {
+		return err
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return store.SetVoucherMetadata(ctx, ov.Header.Val.GUID, metadata)
+}
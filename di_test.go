@@ -0,0 +1,402 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestDIWithManufacturerKeyRotation(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.DI.ManufacturerKeys = server.State
+	server.DI.SelectManufacturerKey = custom.SelectManufacturerKey(server.State, nil)
+	server.DI.SignDeviceCertificateWithKey = custom.SignDeviceCertificateWithKey
+	ctx := context.Background()
+
+	addKey := func(name string) *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.State.AddNamedManufacturerKey(ctx, name, protocol.Secp256r1KeyType, key, []*x509.Certificate{cert}); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	// Devices initialized before and after adding a key use different keys
+	var devices []*fdotest.Device
+	for _, name := range []string{"gen-1", "gen-2"} {
+		key := addKey(name)
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		devices = append(devices, dev)
+
+		ov := server.Voucher(t, dev.Cred.GUID)
+		mfgPub, err := ov.Header.Val.ManufacturerKey.Public()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.PublicKey.Equal(mfgPub) {
+			t.Fatalf("expected voucher of %s device to have %s manufacturer key", name, name)
+		}
+		if got, err := server.State.VoucherManufacturerKey(ctx, dev.Cred.GUID); err != nil {
+			t.Fatal(err)
+		} else if got != name {
+			t.Fatalf("expected recorded manufacturer key %q, got %q", name, got)
+		}
+	}
+
+	// Vouchers auto-extended by either key are valid for onboarding
+	for _, dev := range devices {
+		server.RegisterBlob(t, dev.Cred.GUID)
+		if err := server.Onboard(t, dev, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDIWithGUIDGeneratorAndVoucherMetadata(t *testing.T) {
+	server := fdotest.NewServer(t)
+	prefix := []byte{0x50, 0x31, 0x4c, 0x02} // plant P1, line 2
+	server.DI.GUIDs = fdo.PrefixGUIDGenerator{Prefix: prefix}
+	var serial int
+	server.DI.VoucherMetadata = func(_ context.Context, ov *fdo.Voucher) (map[string]string, error) {
+		serial++
+		return map[string]string{"serial": strconv.Itoa(serial)}, nil
+	}
+	ctx := context.Background()
+
+	for i := range 2 {
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		if !bytes.HasPrefix(dev.Cred.GUID[:], prefix) {
+			t.Fatalf("expected GUID with plant and line prefix, got %s", dev.Cred.GUID)
+		}
+		metadata, err := server.State.VoucherMetadata(ctx, dev.Cred.GUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strconv.Itoa(i + 1); metadata["serial"] != want {
+			t.Fatalf("expected voucher serial %s, got %q", want, metadata["serial"])
+		}
+	}
+
+	if _, err := (fdo.PrefixGUIDGenerator{Prefix: make([]byte, 16)}).NewGUID(ctx); err == nil {
+		t.Fatal("expected a prefix leaving no random bytes to fail")
+	}
+}
+
+func TestDIWithIntermediateCA(t *testing.T) {
+	server := fdotest.NewServer(t)
+
+	key, chain, err := server.State.ManufacturerKey(protocol.Secp384r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := custom.NewIntermediateCA(&fdo.ManufacturerKey{
+		Type:  protocol.Secp384r1KeyType,
+		Key:   key,
+		Chain: chain,
+	}, caKey, pkix.Name{CommonName: "Factory CA"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.DI.SignDeviceCertificate = func(info *custom.DeviceMfgInfo) ([]*x509.Certificate, error) {
+		return custom.SignDeviceCertificateWithKey(info, intermediate)
+	}
+
+	dev := server.NewDevice(t, protocol.Secp384r1KeyType)
+	ov := server.Voucher(t, dev.Cred.GUID)
+	if ov.CertChain == nil || len(*ov.CertChain) != 3 {
+		t.Fatal("expected device certificate chain to include intermediate CA")
+	}
+	if err := ov.VerifyCertChainHash(); err != nil {
+		t.Fatal(err)
+	}
+	mfgPub, err := ov.Header.Val.ManufacturerKey.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Public().(*ecdsa.PublicKey).Equal(mfgPub) {
+		t.Fatal("expected voucher manufacturer key to be the root, not the intermediate CA")
+	}
+
+	// The chain can be assembled from unordered certificates
+	leaf := (*x509.Certificate)((*ov.CertChain)[0])
+	built, err := custom.BuildCertChain(leaf, []*x509.Certificate{chain[0], intermediate.Chain[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 3 || !built[1].Equal(intermediate.Chain[0]) || !built[2].Equal(chain[0]) {
+		t.Fatal("expected chain to be ordered from device to root")
+	}
+
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDIWithDeviceHmac(t *testing.T) {
+	server := fdotest.NewServer(t)
+	ctx := context.Background()
+	key, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "device.go-fdo"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := custom.DeviceMfgInfo{
+		KeyType:     protocol.Secp256r1KeyType,
+		KeyEncoding: protocol.X5ChainKeyEnc,
+		DeviceInfo:  "gotest",
+		CertInfo:    cbor.X509CertificateRequest(*csr),
+	}
+
+	// Simulate a remote HMAC service, which computes asynchronously and
+	// respects cancellation
+	secret := []byte("device secret")
+	var calls int
+	remote := fdo.DeviceHmacFunc(func(ctx context.Context, alg protocol.HashAlg, data []byte) ([]byte, error) {
+		calls++
+		result := make(chan []byte, 1)
+		go func() {
+			h := hmac.New(sha256.New, secret)
+			if alg == protocol.HmacSha384Hash {
+				h = hmac.New(sha512.New384, secret)
+			}
+			_, _ = h.Write(data)
+			result <- h.Sum(nil)
+		}()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case mac := <-result:
+			return mac, nil
+		}
+	})
+	cred, err := fdo.DI(ctx, server.Transport(), info, fdo.DIConfig{DeviceHmac: remote, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected remote HMAC to be called once, got %d", calls)
+	}
+	ov := server.Voucher(t, cred.GUID)
+	if err := ov.VerifyHeader(hmac.New(sha256.New, secret), hmac.New(sha512.New384, secret)); err != nil {
+		t.Fatalf("remote HMAC did not match local HMAC: %v", err)
+	}
+
+	// The remote HMAC is used to verify the voucher and replace it in TO2
+	client, err := fdo.NewClient(
+		fdo.WithTransport(server.Transport()),
+		fdo.WithCredential(*cred),
+		fdo.WithDeviceHmac(remote),
+		fdo.WithKey(key),
+		fdo.WithDevmod(serviceinfo.Devmod{
+			Os:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Version: "go-fdo test",
+			Device:  "go-validation",
+			FileSep: ";",
+			Bin:     runtime.GOARCH,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterBlob(t, cred.GUID)
+	to1d, err := client.TO1(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.TO2(ctx, to1d); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected remote HMAC to be called for DI and twice for TO2, got %d", calls)
+	}
+
+	// Errors from the remote service fail DI
+	failing := fdo.DeviceHmacFunc(func(context.Context, protocol.HashAlg, []byte) ([]byte, error) {
+		return nil, errors.New("HSM unavailable")
+	})
+	if _, err := fdo.DI(ctx, server.Transport(), info, fdo.DIConfig{DeviceHmac: failing, Key: key}); err == nil || !strings.Contains(err.Error(), "HSM unavailable") {
+		t.Fatalf("expected remote HMAC error, got %v", err)
+	}
+}
+
+func TestDIWithIDevID(t *testing.T) {
+	server := fdotest.NewServer(t)
+	ctx := context.Background()
+
+	// Create a device vendor root and an IDevID issued by it
+	newCert := func(template *x509.Certificate, pub any, parent *x509.Certificate, key *ecdsa.PrivateKey) *x509.Certificate {
+		t.Helper()
+		if parent == nil {
+			parent = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Device Vendor Root"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, rootKey.Public(), nil, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idevid := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "widget", SerialNumber: "WX-0042"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, key.Public(), root, rootKey)
+
+	newInfo := func(csrKey *ecdsa.PrivateKey, chain ...*x509.Certificate) custom.DeviceMfgInfo {
+		t.Helper()
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "device.go-fdo"},
+		}, csrKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := custom.DeviceMfgInfo{
+			KeyType:      protocol.Secp256r1KeyType,
+			KeyEncoding:  protocol.X5ChainKeyEnc,
+			SerialNumber: "self-reported",
+			DeviceInfo:   "gotest",
+			CertInfo:     cbor.X509CertificateRequest(*csr),
+		}
+		for _, cert := range chain {
+			info.IDevIDChain = append(info.IDevIDChain, (*cbor.X509Certificate)(cert))
+		}
+		return info
+	}
+	runDI := func(info custom.DeviceMfgInfo, key *ecdsa.PrivateKey) (*fdo.DeviceCredential, error) {
+		return fdo.DI(ctx, server.Transport(), info, fdo.DIConfig{
+			HmacSha256: hmac.New(sha256.New, []byte("device secret")),
+			HmacSha384: hmac.New(sha512.New384, []byte("device secret")),
+			Key:        key,
+		})
+	}
+
+	server.DI.SignDeviceCertificate = custom.SignDeviceCertificateWithIDevID(roots, true, custom.SignDeviceCertificate(server.State))
+	var serial string
+	server.DI.DeviceInfo = func(_ context.Context, info *custom.DeviceMfgInfo, _ []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error) {
+		serial = info.SerialNumber
+		return info.DeviceInfo, info.KeyType, info.KeyEncoding, nil
+	}
+
+	// The voucher is bound to the serial number of the IDevID
+	cred, err := runDI(newInfo(key, idevid), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != "WX-0042" {
+		t.Fatalf("expected serial number from IDevID, got %q", serial)
+	}
+	ov := server.Voucher(t, cred.GUID)
+	if got := (*ov.CertChain)[0].Subject.SerialNumber; got != "WX-0042" {
+		t.Fatalf("expected device certificate to have IDevID serial number, got %q", got)
+	}
+
+	// Devices without a valid IDevID are rejected
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "widget", SerialNumber: "WX-0043"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, otherKey.Public(), nil, otherKey)
+	for _, test := range []struct {
+		name string
+		key  *ecdsa.PrivateKey
+		info custom.DeviceMfgInfo
+	}{
+		{"missing IDevID", key, newInfo(key)},
+		{"untrusted IDevID", otherKey, newInfo(otherKey, untrusted)},
+		{"IDevID of other device", otherKey, newInfo(otherKey, idevid)},
+	} {
+		if _, err := runDI(test.info, test.key); err == nil {
+			t.Errorf("%s: expected DI to fail", test.name)
+		}
+	}
+}
@@ -4,6 +4,10 @@
 package fdo
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
@@ -133,3 +137,55 @@ func newEAT(guid protocol.GUID, nonce protocol.Nonce, fdo any, other map[cose.La
 	other[eatUeidClaim] = append([]byte{eatRandUeid}, guid[:]...)
 	return other
 }
+
+// EATClaims are the claims of a device Entity Attestation Token used by FDO.
+type EATClaims struct {
+	// Nonce is the EAT-NONCE claim.
+	Nonce protocol.Nonce
+
+	// GUID is the FDO GUID of the EAT-UEID claim.
+	GUID protocol.GUID
+
+	// FDO is the EAT-FDO claim, which is nil if not present.
+	FDO []any
+}
+
+// ParseEAT decodes the payload of a device EAT, as sent in TO1.ProveToRV and
+// TO2.ProveDevice, and validates the form of the claims used by FDO. It does
+// not verify the signature of the EAT.
+func ParseEAT(payload []byte) (*EATClaims, error) {
+	var eat eatoken
+	if err := cbor.Unmarshal(payload, &eat); err != nil {
+		return nil, fmt.Errorf("error decoding EAT: %w", err)
+	}
+	var claims EATClaims
+
+	nonce, ok := eat[eatNonceClaim].([]byte)
+	if !ok {
+		return nil, errors.New("EAT missing nonce claim")
+	}
+	if len(nonce) != len(claims.Nonce) {
+		return nil, errors.New("EAT nonce claim is not a valid length")
+	}
+	copy(claims.Nonce[:], nonce)
+
+	ueid, ok := eat[eatUeidClaim].([]byte)
+	if !ok {
+		return nil, errors.New("EAT missing UEID claim")
+	}
+	if len(ueid) != 1+len(claims.GUID) {
+		return nil, errors.New("EAT UEID claim is not a valid length")
+	}
+	if ueid[0] != eatRandUeid {
+		return nil, errors.New("EAT UEID type must be RAND")
+	}
+	copy(claims.GUID[:], ueid[1:])
+
+	if fdo, ok := eat[eatFdoClaim]; ok {
+		if claims.FDO, ok = fdo.([]any); !ok {
+			return nil, errors.New("EAT FDO claim must be an array")
+		}
+	}
+
+	return &claims, nil
+}
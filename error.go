@@ -50,6 +50,16 @@ func captureErr(ctx context.Context, code uint16, err string) {
 	errMsgFromContext(ctx).ErrString = err
 }
 
+// Set the RetryAfter of the ErrorMessage value in the context, asking the
+// device to back off.
+//
+// If the provided context does not have an *ErrorMessage for errMsgContextKey
+// then this function panics, because it is a programming error to try to
+// capture an error outside of a protocol implementation.
+func captureRetryAfter(ctx context.Context, delay time.Duration) {
+	errMsgFromContext(ctx).RetryAfter = delay
+}
+
 func errorMsg(ctx context.Context, transport Transport, err error) {
 	// If no previous message, then exit, because the protocol hasn't started
 	errMsg := errMsgFromContext(ctx)
@@ -57,16 +67,16 @@ func errorMsg(ctx context.Context, transport Transport, err error) {
 		return
 	}
 
-	// Default to error code 500, error message of err parameter, and timestamp
-	// of the current time
+	// Default to error code 500, structured error message of err parameter, and
+	// timestamp of the current time
 	if errMsg.Code == 0 {
 		errMsg.Code = protocol.InternalServerErrCode
 	}
 	if errMsg.ErrString == "" {
-		errMsg.ErrString = err.Error()
+		errMsg.ErrString = protocol.NewErrorDetail(errMsg.Code, errMsg.PrevMsgType, err).String()
 	}
 	if errMsg.Timestamp == 0 {
-		errMsg.Timestamp = time.Now().Unix()
+		errMsg.Timestamp = nowFromContext(ctx).Unix()
 	}
 
 	// Create a new context, because the previous one may have expired, thus
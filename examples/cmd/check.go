@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/record"
+	"github.com/fido-device-onboard/go-fdo/speccheck"
+)
+
+var checkFlags = flag.NewFlagSet("check", flag.ContinueOnError)
+
+var (
+	checkOwner  string
+	checkRV     string
+	checkDevice string
+	checkRoles  string
+	checkJSON   bool
+)
+
+func init() {
+	checkFlags.StringVar(&checkOwner, "owner", "", "Base `URL` of the owner service to check")
+	checkFlags.StringVar(&checkRV, "rv", "", "Base `URL` of the rendezvous server to check")
+	checkFlags.StringVar(&checkDevice, "device", "", "The `path` to a recording of device exchanges to check, as made by the record flag of the client")
+	checkFlags.StringVar(&checkRoles, "roles", "", "Comma-separated `roles` to check [options: device, owner, rv] (default all)")
+	checkFlags.BoolVar(&checkJSON, "json", false, "Print the report as JSON")
+	checkFlags.BoolVar(&insecureTLS, "insecure-tls", false, "Skip TLS certificate verification")
+}
+
+func checkSpec() error {
+	if debug {
+		level.Set(slog.LevelDebug)
+	}
+
+	target := &speccheck.Target{
+		OwnerURL:      checkOwner,
+		RendezvousURL: checkRV,
+		HTTPClient: http.ConnectionConfig{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureTLS}, //nolint:gosec
+		}.Client(nil),
+	}
+	if checkDevice != "" {
+		f, err := os.Open(filepath.Clean(checkDevice))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		if target.DeviceExchanges, err = record.ReadAll(f); err != nil {
+			return err
+		}
+	}
+
+	var roles []speccheck.Role
+	if checkRoles != "" {
+		for _, role := range strings.Split(checkRoles, ",") {
+			switch role := speccheck.Role(strings.TrimSpace(role)); role {
+			case speccheck.RoleDevice, speccheck.RoleOwner, speccheck.RoleRendezvous:
+				roles = append(roles, role)
+			default:
+				return fmt.Errorf("invalid role %q", role)
+			}
+		}
+	}
+
+	report := speccheck.Run(context.Background(), target, roles...)
+	write := report.WriteText
+	if checkJSON {
+		write = report.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		return err
+	}
+	if !report.Passed() {
+		return errors.New("implementation failed requirement checks")
+	}
+	return nil
+}
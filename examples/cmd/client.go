@@ -15,10 +15,12 @@ import (
 	"crypto/sha512"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"math"
 	"math/big"
 	"net"
@@ -36,7 +38,10 @@ import (
 	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/custom"
 	"github.com/fido-device-onboard/go-fdo/fsim"
+	"github.com/fido-device-onboard/go-fdo/http"
 	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/mdns"
+	"github.com/fido-device-onboard/go-fdo/plugin"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 	"github.com/fido-device-onboard/go-fdo/tpm"
@@ -58,6 +63,11 @@ var (
 	echoCmds    bool
 	uploads     = make(fsVar)
 	wgetDir     string
+	pluginDir   string
+	proxyURL    string
+	noProxy     string
+	headers     stringList
+	recordPath  string
 )
 
 type fsVar map[string]string
@@ -139,14 +149,21 @@ func init() {
 	clientFlags.StringVar(&cipherSuite, "cipher", "A128GCM", "Name of cipher `suite` to use for encryption (see usage)")
 	clientFlags.BoolVar(&debug, "debug", debug, "Print HTTP contents")
 	clientFlags.StringVar(&dlDir, "download", "", "A `dir` to download files into (FSIM disabled if empty)")
-	clientFlags.StringVar(&diURL, "di", "", "HTTP base `URL` for DI server")
+	clientFlags.StringVar(&diURL, "di", "", "HTTP base `URL` for DI server, or \"mdns\" to discover it on the local network")
 	clientFlags.StringVar(&diKey, "di-key", "ec384", "Key for device credential [options: ec256, ec384, rsa2048, rsa3072]")
 	clientFlags.StringVar(&diKeyEnc, "di-key-enc", "x509", "Public key encoding to use for manufacturer key [x509,x5chain,cose]")
 	clientFlags.BoolVar(&echoCmds, "echo-commands", false, "Echo all commands received to stdout (FSIM disabled if false)")
+	clientFlags.StringVar(&pluginDir, "plugins", "", "A `dir` of plugin executables to use as device FSIMs")
 	clientFlags.StringVar(&kexSuite, "kex", "ECDH384", "Name of cipher `suite` to use for key exchange (see usage)")
+	clientFlags.Var(&headers, "header", "Extra `name: value` header to send with requests (flag may be used multiple times)")
+	clientFlags.BoolVar(&disableHTTP2, "http1", false, "Use HTTP/1.1 for all requests, even when the server supports HTTP/2")
 	clientFlags.BoolVar(&insecureTLS, "insecure-tls", false, "Skip TLS certificate verification")
+	clientFlags.StringVar(&noProxy, "no-proxy", "", "Comma-separated `hosts`, domains, and CIDR prefixes to connect to without the proxy (requires proxy flag)")
 	clientFlags.BoolVar(&printDevice, "print", false, "Print device credential blob and stop")
+	clientFlags.StringVar(&proxyURL, "proxy", "", "HTTP proxy `URL` for all requests (default from HTTP_PROXY and HTTPS_PROXY environment variables)")
+	clientFlags.StringVar(&recordPath, "record", "", "Record all exchanges to the file at `path`, i.e. for the check subcommand")
 	clientFlags.BoolVar(&rvOnly, "rv-only", false, "Perform TO1 then stop")
+	clientFlags.StringVar(&userAgent, "user-agent", "", "User-Agent `header` to send with requests")
 	clientFlags.StringVar(&tpmPath, "tpm", "", "Use a TPM at `path` for device credential secrets")
 	clientFlags.Var(&uploads, "upload", "List of dirs and `files` to upload files from, "+
 		"comma-separated and/or flag provided multiple times (FSIM disabled if empty)")
@@ -158,6 +175,42 @@ func client() error {
 		level.Set(slog.LevelDebug)
 	}
 
+	// Record exchanges
+	if recordPath != "" {
+		f, err := os.Create(filepath.Clean(recordPath))
+		if err != nil {
+			return fmt.Errorf("error creating recording: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		recording = f
+	}
+
+	// Add extra request headers
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid header %q: expected \"name: value\"", header)
+		}
+		extraHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	// Configure a proxy manually
+	if proxyURL != "" {
+		var noProxyHosts []string
+		if noProxy != "" {
+			noProxyHosts = strings.Split(noProxy, ",")
+		}
+		var err error
+		proxy, err = http.ProxyConfig{
+			HTTPProxy:  proxyURL,
+			HTTPSProxy: proxyURL,
+			NoProxy:    noProxyHosts,
+		}.ProxyFunc()
+		if err != nil {
+			return err
+		}
+	}
+
 	// Catch interrupts
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -174,6 +227,16 @@ func client() error {
 	}()
 
 	// Perform DI if given a URL
+	if diURL == "mdns" {
+		endpoints, err := mdns.Browse(ctx, mdns.ServiceDI)
+		if err != nil {
+			return fmt.Errorf("error discovering DI server: %w", err)
+		}
+		if len(endpoints) == 0 {
+			return errors.New("no DI server found with mDNS")
+		}
+		diURL = endpoints[0].BaseURL()
+	}
 	if diURL != "" {
 		return di()
 	}
@@ -342,22 +405,29 @@ TO1:
 			continue
 		}
 
+		var retryAfter time.Duration
 		for _, url := range directive.URLs {
 			var err error
 			to1d, err = fdo.TO1(context.TODO(), tlsTransport(url.String(), nil), conf.Cred, conf.Key, nil)
 			if err != nil {
 				slog.Error("TO1 failed", "base URL", url.String(), "error", err)
+				var errMsg protocol.ErrorMessage
+				if errors.As(err, &errMsg) {
+					retryAfter = max(retryAfter, min(errMsg.RetryAfter, fdo.DefaultMaxRetryAfter))
+				}
 				continue
 			}
 			break TO1
 		}
 
-		if directive.Delay != 0 {
+		// Back off for longer than the directive delay if asked to by the
+		// rendezvous server
+		if delay := max(directive.Delay, retryAfter); delay != 0 {
 			// A 25% plus or minus jitter is allowed by spec
 			select {
 			case <-ctx.Done():
 				return nil
-			case <-time.After(directive.Delay):
+			case <-time.After(delay):
 			}
 		}
 	}
@@ -457,7 +527,15 @@ func transferOwnership2(transport fdo.Transport, to1d *cose.Sign1[protocol.To1d,
 			Timeout: 10 * time.Second,
 		}
 	}
-	conf.DeviceModules = fsims
+	if pluginDir != "" {
+		plugins, err := plugin.Discover(pluginDir, nil)
+		if err != nil {
+			slog.Error("loading plugins failed", "error", err)
+			return nil
+		}
+		maps.Copy(fsims, plugin.DeviceModules(plugins))
+	}
+	conf.DeviceModules = serviceinfo.NewRegistry(fsims)
 
 	cred, err := fdo.TO2(context.TODO(), transport, to1d, conf)
 	if err != nil {
@@ -22,12 +22,13 @@ func init() {
 	flags.Usage = usage
 	clientFlags.Usage = func() {}
 	serverFlags.Usage = func() {}
+	checkFlags.Usage = func() {}
 }
 
 func usage() {
 	_, _ = fmt.Fprintf(os.Stderr, `
 Usage:
-  fdo [global_options] [client|server] [--] [options]
+  fdo [global_options] [client|server|check] [--] [options]
 
 Global options:
 %s
@@ -35,6 +36,8 @@ Client options:
 %s
 Server options:
 %s
+Check options:
+%s
 Key types:
   - RSA2048RESTR
   - RSAPKCS
@@ -60,7 +63,7 @@ Key exchange suites:
   - ASYMKEX3072
   - ECDH256
   - ECDH384
-`, options(flags), options(clientFlags), options(serverFlags))
+`, options(flags), options(clientFlags), options(serverFlags), options(checkFlags))
 }
 
 func options(flags *flag.FlagSet) string {
@@ -108,6 +111,15 @@ func main() {
 			_, _ = fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 			os.Exit(2)
 		}
+	case "check":
+		if err := checkFlags.Parse(args); err != nil {
+			usage()
+			os.Exit(1)
+		}
+		if err := checkSpec(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "check error: %v\n", err)
+			os.Exit(2)
+		}
 	default:
 		if sub != "" {
 			_, _ = fmt.Fprintf(os.Stderr, "unknown subcommand %q\n", sub)
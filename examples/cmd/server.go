@@ -27,18 +27,23 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/config"
 	"github.com/fido-device-onboard/go-fdo/custom"
 	"github.com/fido-device-onboard/go-fdo/fsim"
 	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/mdns"
+	"github.com/fido-device-onboard/go-fdo/plugin"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
@@ -46,12 +51,23 @@ import (
 
 var serverFlags = flag.NewFlagSet("server", flag.ContinueOnError)
 
+// to1Stats are counted by the TO1 server and reported by the admin API.
+var to1Stats fdo.TO1Stats
+
+// rvCache, if not nil, is shared by the TO0 and TO1 servers and the admin
+// API.
+var rvCache *fdo.RVBlobCache
+
 var (
 	useTLS           bool
 	addr             string
+	adminAddr        string
+	configPath       string
+	shutdownTimeout  time.Duration
 	dbPath           string
 	dbPass           string
 	extAddr          string
+	mdnsName         string
 	to0Addr          string
 	to0GUID          string
 	resaleGUID       string
@@ -59,6 +75,8 @@ var (
 	reuseCred        bool
 	rvBypass         bool
 	rvDelay          int
+	rvCacheSize      int
+	traceMsgs        bool
 	printOwnerPubKey string
 	importVoucher    string
 	cmdDate          bool
@@ -66,6 +84,8 @@ var (
 	uploadDir        string
 	uploadReqs       stringList
 	wgets            stringList
+	corsOrigins      stringList
+	plugins          []*plugin.Plugin
 )
 
 type stringList []string
@@ -80,6 +100,7 @@ func (list *stringList) String() string {
 }
 
 func init() {
+	serverFlags.StringVar(&configPath, "config", "", "Build the server from the configuration file at `path` (other server options except admin and shutdown-timeout are ignored)")
 	serverFlags.StringVar(&dbPath, "db", "", "SQLite database file path")
 	serverFlags.StringVar(&dbPass, "db-pass", "", "SQLite database encryption-at-rest passphrase")
 	serverFlags.BoolVar(&debug, "debug", debug, "Print HTTP contents")
@@ -87,19 +108,26 @@ func init() {
 	serverFlags.StringVar(&to0GUID, "to0-guid", "", "Device `guid` to immediately register an RV blob (requires to0 flag)")
 	serverFlags.StringVar(&extAddr, "ext-http", "", "External `addr`ess devices should connect to (default \"127.0.0.1:${LISTEN_PORT}\")")
 	serverFlags.StringVar(&addr, "http", "localhost:8080", "The `addr`ess to listen on")
+	serverFlags.StringVar(&mdnsName, "mdns", "", "Advertise the DI, RV, and owner services on the local network with mDNS as instance `name`")
+	serverFlags.StringVar(&adminAddr, "admin", "", "The `addr`ess to serve the unauthenticated admin API on (do not expose publicly)")
+	serverFlags.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum `duration` to wait for in-flight TO2 sessions on interrupt")
 	serverFlags.StringVar(&resaleGUID, "resale-guid", "", "Voucher `guid` to extend for resale")
 	serverFlags.StringVar(&resaleKey, "resale-key", "", "The `path` to a PEM-encoded x.509 public key for the next owner")
 	serverFlags.BoolVar(&reuseCred, "reuse-cred", false, "Perform the Credential Reuse Protocol in TO2")
 	serverFlags.BoolVar(&insecureTLS, "insecure-tls", false, "Listen with a self-signed TLS certificate")
 	serverFlags.BoolVar(&rvBypass, "rv-bypass", false, "Skip TO1")
 	serverFlags.IntVar(&rvDelay, "rv-delay", 0, "Delay TO1 by N `seconds`")
+	serverFlags.IntVar(&rvCacheSize, "rv-cache", 0, "Cache the rendezvous blobs of up to N `devices` in memory for TO1")
+	serverFlags.BoolVar(&traceMsgs, "trace", false, "Record a trace of each protocol message, retrievable with the admin API")
 	serverFlags.StringVar(&printOwnerPubKey, "print-owner-public", "", "Print owner public key of `type` and exit")
 	serverFlags.StringVar(&importVoucher, "import-voucher", "", "Import a PEM encoded voucher file at `path`")
 	serverFlags.BoolVar(&cmdDate, "command-date", false, "Use fdo.command FSIM to have device run \"date --utc\"")
 	serverFlags.Var(&downloads, "download", "Use fdo.download FSIM for each `file` (flag may be used multiple times)")
 	serverFlags.StringVar(&uploadDir, "upload-dir", "uploads", "The directory `path` to put file uploads")
 	serverFlags.Var(&uploadReqs, "upload", "Use fdo.upload FSIM for each `file` (flag may be used multiple times)")
+	serverFlags.StringVar(&pluginDir, "plugins", "", "A `dir` of plugin executables to use as owner FSIMs for devices which support them")
 	serverFlags.Var(&wgets, "wget", "Use fdo.wget FSIM for each `url` (flag may be used multiple times)")
+	serverFlags.Var(&corsOrigins, "cors", "Allow FDO requests from web pages of `origin`, such as the browser device example, or \"*\" for any (flag may be used multiple times)")
 }
 
 func server() error { //nolint:gocyclo
@@ -107,9 +135,22 @@ func server() error { //nolint:gocyclo
 		level.Set(slog.LevelDebug)
 	}
 
+	if configPath != "" {
+		return serveConfig()
+	}
+
 	if dbPath == "" {
 		return errors.New("db flag is required")
 	}
+	if pluginDir != "" {
+		var err error
+		// Plugins handle service info sent by devices, so do not expose
+		// secrets in the environment, such as the database password
+		sandbox := &plugin.Sandbox{ScrubEnv: true, KeepEnv: []string{"PATH", "HOME", "TMPDIR"}}
+		if plugins, err = plugin.Discover(pluginDir, sandbox); err != nil {
+			return err
+		}
+	}
 	state, err := sqlite.Open(dbPath, dbPass)
 	if err != nil {
 		return err
@@ -170,21 +211,102 @@ func server() error { //nolint:gocyclo
 		return resell(state)
 	}
 
-	return serveHTTP(rvInfo, state)
-}
-
-func serveHTTP(rvInfo [][]protocol.RvInstruction, state *sqlite.DB) error {
 	// Create FDO responder
+	if rvCacheSize > 0 {
+		rvCache = &fdo.RVBlobCache{Size: rvCacheSize}
+	}
 	handler, err := newHandler(rvInfo, state)
 	if err != nil {
 		return err
 	}
+	if traceMsgs {
+		handler.Tracer = state
+	}
+	if mdnsName != "" {
+		advertise(mdnsName, port)
+	}
+	return serveHTTP(handler, to2Addrs(host, port), state)
+}
+
+// serveConfig serves the servers built from the configuration file.
+func serveConfig() error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	servers, err := cfg.Build()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = servers.Close() }()
+	if servers.TO1 != nil {
+		servers.TO1.Stats = &to1Stats
+		rvCache = servers.TO1.Cache
+	}
+
+	addr, extAddr = cfg.HTTP, cfg.ExtHTTP
+	if extAddr == "" {
+		extAddr = addr
+	}
+	return serveHTTP(servers.Handler, servers.TO2Addrs, servers.State)
+}
+
+func serveHTTP(handler *transport.Handler, to2Addrs []protocol.RvTO2Addr, state *sqlite.DB) error {
+
+	// Serve the admin API on a separate listener
+	if adminAddr != "" {
+		var traces fdo.ProtocolTracePersistentState
+		if handler.Tracer != nil {
+			traces = state
+		}
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/rv/", http.StripPrefix("/rv", transport.RVAdminHandler{
+			RVBlobs: state,
+			Stats:   &to1Stats,
+			Cache:   rvCache,
+		}))
+		adminMux.Handle("/owner/", http.StripPrefix("/owner", transport.OwnerAdminHandler{
+			Vouchers:  state,
+			OwnerKeys: state,
+			History:   state,
+			DenyList:  state,
+			Devmods:   state,
+			Statuses:  state,
+			Traces:    traces,
+			Hellos:    state,
+			TO0: &fdo.TO0Client{
+				Vouchers:      state,
+				OwnerKeys:     state,
+				Registrations: state,
+				NewTransport:  func(baseURL string) fdo.Transport { return tlsTransport(baseURL, nil) },
+				TO2Addrs:      to2Addrs,
+			},
+		}))
+		adminSrv := &http.Server{
+			Addr:              adminAddr,
+			Handler:           adminMux,
+			ReadHeaderTimeout: 3 * time.Second,
+		}
+		go func() {
+			slog.Info("Admin API listening", "local", adminAddr)
+			if err := adminSrv.ListenAndServe(); err != nil {
+				slog.Error("admin API stopped", "error", err)
+			}
+		}()
+	}
 
 	// Handle messages
 	mux := http.NewServeMux()
 	mux.Handle("POST /fdo/101/msg/{msg}", handler)
+	fdo100 := *handler
+	fdo100.ProtocolVersion = protocol.Version100
+	mux.Handle("POST /fdo/100/msg/{msg}", fdo100)
+	var root http.Handler = mux
+	if len(corsOrigins) > 0 {
+		root = transport.CORSHandler{Handler: mux, AllowedOrigins: corsOrigins}
+	}
 	srv := &http.Server{
-		Handler:           mux,
+		Handler:           root,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
 
@@ -196,6 +318,20 @@ func serveHTTP(rvInfo [][]protocol.RvInstruction, state *sqlite.DB) error {
 	defer func() { _ = lis.Close() }()
 	slog.Info("Listening", "local", lis.Addr().String(), "external", extAddr)
 
+	// On interrupt, let in-flight TO2 sessions end before closing the server
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-sigs
+		slog.Info("Shutting down", "timeout", shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := handler.Shutdown(ctx)
+		shutdownErr <- errors.Join(err, srv.Shutdown(ctx))
+	}()
+
 	if useTLS {
 		cert, err := tlsCert(state.DB())
 		if err != nil {
@@ -205,9 +341,14 @@ func serveHTTP(rvInfo [][]protocol.RvInstruction, state *sqlite.DB) error {
 			MinVersion:   tls.VersionTLS12,
 			Certificates: []tls.Certificate{*cert},
 		}
-		return srv.ServeTLS(lis, "", "")
+		err = srv.ServeTLS(lis, "", "")
+	} else {
+		err = srv.Serve(lis)
 	}
-	return srv.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		return <-shutdownErr
+	}
+	return err
 }
 
 func doPrintOwnerPubKey(state *sqlite.DB) error {
@@ -264,6 +405,40 @@ func doImportVoucher(state *sqlite.DB) error {
 	return state.AddVoucher(context.Background(), &ov)
 }
 
+// to2Addrs are the owner service addresses registered with TO0.
+// advertise announces the services over mDNS in the background. Devices on
+// the same network can then find the server without static configuration.
+func advertise(instance string, port uint16) {
+	var services []mdns.Service
+	for _, typ := range []string{mdns.ServiceDI, mdns.ServiceRendezvous, mdns.ServiceOwner} {
+		services = append(services, mdns.Service{
+			Instance: instance,
+			Type:     typ,
+			Port:     port,
+			TLS:      useTLS,
+		})
+	}
+	go func() {
+		if err := mdns.Advertise(context.Background(), services...); err != nil {
+			slog.Error("mDNS advertisement stopped", "error", err)
+		}
+	}()
+}
+
+func to2Addrs(host string, port uint16) []protocol.RvTO2Addr {
+	proto := protocol.HTTPTransport
+	if useTLS {
+		proto = protocol.HTTPSTransport
+	}
+	return []protocol.RvTO2Addr{
+		{
+			DNSAddress:        &host,
+			Port:              port,
+			TransportProtocol: proto,
+		},
+	}
+}
+
 func registerRvBlob(host string, port uint16, state *sqlite.DB) error {
 	if to0Addr == "" {
 		return fmt.Errorf("to0-guid depends on to0 flag being set")
@@ -280,26 +455,15 @@ func registerRvBlob(host string, port uint16, state *sqlite.DB) error {
 	var guid protocol.GUID
 	copy(guid[:], guidBytes)
 
-	proto := protocol.HTTPTransport
-	if useTLS {
-		proto = protocol.HTTPSTransport
-	}
-
-	to2Addrs := []protocol.RvTO2Addr{
-		{
-			DNSAddress:        &host,
-			Port:              port,
-			TransportProtocol: proto,
-		},
-	}
-	refresh, err := (&fdo.TO0Client{
-		Vouchers:  state,
-		OwnerKeys: state,
-	}).RegisterBlob(context.Background(), tlsTransport(to0Addr, nil), guid, to2Addrs)
+	reg, err := (&fdo.TO0Client{
+		Vouchers:      state,
+		OwnerKeys:     state,
+		Registrations: state,
+	}).Register(context.Background(), tlsTransport(to0Addr, nil), guid, to2Addrs(host, port))
 	if err != nil {
 		return fmt.Errorf("error performing to0: %w", err)
 	}
-	slog.Info("RV blob registered", "ttl", time.Duration(refresh)*time.Second)
+	slog.Info("RV blob registered", "ttl", time.Duration(reg.WaitSeconds)*time.Second, "refresh", reg.RefreshAt)
 
 	return nil
 }
@@ -506,14 +670,20 @@ func newHandler(rvInfo [][]protocol.RvInstruction, state *sqlite.DB) (*transport
 			AutoTO0:      autoTO0,
 			AutoTO0Addrs: autoTO0Addrs,
 			RvInfo:       func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) { return rvInfo, nil },
+			DeviceStatus: state,
 		},
 		TO0Responder: &fdo.TO0Server{
-			Session: state,
-			RVBlobs: state,
+			Session:      state,
+			RVBlobs:      state,
+			DeviceStatus: state,
+			Cache:        rvCache,
 		},
 		TO1Responder: &fdo.TO1Server{
-			Session: state,
-			RVBlobs: state,
+			Session:      state,
+			RVBlobs:      state,
+			Stats:        &to1Stats,
+			DeviceStatus: state,
+			Cache:        rvCache,
 		},
 		TO2Responder: &fdo.TO2Server{
 			Session:         state,
@@ -522,6 +692,12 @@ func newHandler(rvInfo [][]protocol.RvInstruction, state *sqlite.DB) (*transport
 			RvInfo:          func(context.Context, fdo.Voucher) ([][]protocol.RvInstruction, error) { return rvInfo, nil },
 			OwnerModules:    ownerModules,
 			ReuseCredential: func(context.Context, fdo.Voucher) bool { return reuseCred },
+			DenyList:        state,
+			History:         state,
+			HelloDevices:    state,
+			Devmods:         state,
+			DeviceStatus:    state,
+			SessionTimeout:  fdo.DefaultTO2SessionTimeout,
 		},
 	}, nil
 }
@@ -582,6 +758,14 @@ func ownerModules(ctx context.Context, guid protocol.GUID, info string, chain []
 				return
 			}
 		}
+
+		for _, p := range plugins {
+			if slices.Contains(modules, p.Name) {
+				if !yield(p.Name, p.OwnerModule()) {
+					return
+				}
+			}
+		}
 	}
 }
 
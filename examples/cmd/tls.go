@@ -5,15 +5,29 @@ package main
 
 import (
 	"crypto/tls"
-	"net"
+	"io"
 	net_http "net/http"
-	"time"
+	"net/url"
 
 	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/record"
 )
 
-var insecureTLS bool
+var insecureTLS, disableHTTP2 bool
+
+// userAgent and extraHeaders are sent with every request.
+var (
+	userAgent    string
+	extraHeaders = make(net_http.Header)
+)
+
+// proxy selects the proxy for requests, which is configured from the
+// environment unless set by the proxy flag of the client.
+var proxy func(*net_http.Request) (*url.URL, error) = net_http.ProxyFromEnvironment
+
+// recording, if set, receives a recording of all exchanges.
+var recording io.Writer
 
 func tlsTransport(baseURL string, conf *tls.Config) fdo.Transport {
 	if conf == nil {
@@ -22,20 +36,18 @@ func tlsTransport(baseURL string, conf *tls.Config) fdo.Transport {
 		}
 	}
 
-	return &http.Transport{
-		BaseURL: baseURL,
-		Client: &net_http.Client{Transport: &net_http.Transport{
-			Proxy: net_http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSClientConfig:       conf,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		}},
+	var transport fdo.Transport = &http.Transport{
+		BaseURL:   baseURL,
+		Proxy:     proxy,
+		UserAgent: userAgent,
+		Header:    extraHeaders,
+		Connection: &http.ConnectionConfig{
+			TLSClientConfig: conf,
+			DisableHTTP2:    disableHTTP2,
+		},
+	}
+	if recording != nil {
+		transport = &record.Transport{Transport: transport, W: recording}
 	}
+	return transport
 }
@@ -6,12 +6,15 @@ replace github.com/fido-device-onboard/go-fdo/sqlite => ../sqlite
 
 replace github.com/fido-device-onboard/go-fdo => ..
 
+replace github.com/fido-device-onboard/go-fdo/config => ../config
+
 replace github.com/fido-device-onboard/go-fdo/fsim => ../fsim
 
 replace github.com/fido-device-onboard/go-fdo/tpm => ../tpm
 
 require (
 	github.com/fido-device-onboard/go-fdo v0.0.0-00010101000000-000000000000
+	github.com/fido-device-onboard/go-fdo/config v0.0.0-00010101000000-000000000000
 	github.com/fido-device-onboard/go-fdo/fsim v0.0.0-00010101000000-000000000000
 	github.com/fido-device-onboard/go-fdo/sqlite v0.0.0-00010101000000-000000000000
 	github.com/fido-device-onboard/go-fdo/tpm v0.0.0-00010101000000-000000000000
@@ -24,8 +27,10 @@ require (
 	github.com/ncruces/go-sqlite3 v0.19.1-0.20241017225339-d6aebe67cc4b // indirect
 	github.com/ncruces/julianday v1.0.0 // indirect
 	github.com/neilotoole/jsoncolor v0.7.1 // indirect
+	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
 	github.com/tetratelabs/wazero v1.8.1 // indirect
 	golang.org/x/crypto v0.28.0 // indirect
 	golang.org/x/sys v0.26.0 // indirect
 	golang.org/x/term v0.25.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
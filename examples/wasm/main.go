@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build js && wasm
+
+// Package main is a demo FDO device which runs in a web browser. It exports
+// a global fdo object to the page (see index.html) with methods which return
+// promises:
+//
+//	fdo.di(url)      run DI with the manufacturing server at url
+//	fdo.onboard()    run TO1 and TO2 with the stored device credential
+//	fdo.credential() describe the stored device credential
+//	fdo.reset()      delete the stored device credential
+//
+// Requests are sent with the fetch API, so FDO servers must allow the origin
+// of the page (see the -cors flag of the example server).
+//
+// The device credential, including its secrets, is kept in localStorage. It
+// is only suitable for demos.
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"syscall/js"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/blob"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// credentialKey is the localStorage key of the device credential.
+const credentialKey = "fdo.credential"
+
+var store = blob.NewLocalStorageStore(credentialKey)
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(pageLog{}, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	js.Global().Set("fdo", js.ValueOf(map[string]any{
+		"di": promiseFunc(func(args []js.Value) (any, error) {
+			if len(args) != 1 {
+				return nil, errors.New("usage: fdo.di(url)")
+			}
+			return nil, di(args[0].String())
+		}),
+		"onboard": promiseFunc(func([]js.Value) (any, error) {
+			return nil, onboard()
+		}),
+		"credential": promiseFunc(func([]js.Value) (any, error) {
+			return credential()
+		}),
+		"reset": promiseFunc(func([]js.Value) (any, error) {
+			js.Global().Get("localStorage").Call("removeItem", credentialKey)
+			js.Global().Get("localStorage").Call("removeItem", credentialKey+".staged")
+			return nil, nil
+		}),
+	}))
+
+	// Keep the exported functions alive
+	select {}
+}
+
+// di generates a P-256 device key and secret and runs DI with the server at
+// baseURL, storing the new device credential.
+func di(baseURL string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("error generating device secret: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating device key: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "browser.go-fdo"},
+	}, key)
+	if err != nil {
+		return fmt.Errorf("error creating CSR for device certificate chain: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return fmt.Errorf("error parsing CSR for device certificate chain: %w", err)
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return fmt.Errorf("error generating random serial number: %w", err)
+	}
+	cred, err := fdo.DI(context.Background(), &transport.Transport{BaseURL: baseURL}, custom.DeviceMfgInfo{
+		KeyType:      protocol.Secp256r1KeyType,
+		KeyEncoding:  protocol.X509KeyEnc,
+		SerialNumber: fmt.Sprintf("%x", serial),
+		DeviceInfo:   "browser",
+		CertInfo:     cbor.X509CertificateRequest(*csr),
+	}, fdo.DIConfig{
+		HmacSha256: hmac.New(sha256.New, secret),
+		HmacSha384: hmac.New(sha512.New384, secret),
+		Key:        key,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("DI complete", "guid", cred.GUID)
+	return store.Save(&blob.DeviceCredential{
+		Active:           true,
+		DeviceCredential: *cred,
+		HmacSecret:       secret,
+		PrivateKey:       blob.Pkcs8Key{Signer: key},
+	})
+}
+
+// onboard runs TO1 and TO2 with the stored device credential, replacing it
+// on success.
+func onboard() error {
+	cred, err := store.Read()
+	if err != nil {
+		return err
+	}
+	hmacSha256, hmacSha384, err := store.HMACs()
+	if err != nil {
+		return err
+	}
+	key, err := store.Signer()
+	if err != nil {
+		return err
+	}
+
+	result, err := fdo.Onboard(context.Background(), *cred, fdo.OnboardOptions{
+		NewTransport: func(baseURL string) fdo.Transport {
+			return &transport.Transport{BaseURL: baseURL}
+		},
+		Store: store,
+		TO2Config: fdo.TO2Config{
+			HmacSha256: hmacSha256,
+			HmacSha384: hmacSha384,
+			Key:        key,
+			Devmod: serviceinfo.Devmod{
+				Os:      "browser",
+				Arch:    "wasm",
+				Version: "go-fdo browser demo",
+				Device:  js.Global().Get("navigator").Get("userAgent").String(),
+				FileSep: "/",
+				Bin:     "wasm",
+			},
+			DeviceModules: serviceinfo.NewRegistry(map[string]serviceinfo.DeviceModule{
+				"fido_alliance": &fsim.Interop{},
+			}),
+			KeyExchange: kex.ECDH256Suite,
+			CipherSuite: kex.A128GcmCipher,
+			Hooks: fdo.ClientHooks{
+				OnServiceInfoProgress: func(module string, sent, received int) {
+					slog.Debug("service info", "module", module, "sent", sent, "received", received)
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("onboarding complete", "owner", result.OwnerBaseURL, "reused", result.Reused)
+	return nil
+}
+
+// credential describes the stored device credential for display.
+func credential() (any, error) {
+	dc, err := store.Load()
+	if err != nil {
+		return nil, nil //nolint:nilerr // No credential is not an error
+	}
+	return map[string]any{
+		"guid":       dc.GUID.String(),
+		"deviceInfo": dc.DeviceInfo,
+		"active":     dc.Active,
+		"rvInfo":     fmt.Sprint(dc.RvInfo),
+	}, nil
+}
+
+// promiseFunc wraps a blocking function as a JavaScript function returning a
+// promise. The function runs in its own goroutine, because blocking calls,
+// such as fetch, would otherwise deadlock the event loop.
+func promiseFunc(f func(args []js.Value) (any, error)) js.Func {
+	return js.FuncOf(func(_ js.Value, args []js.Value) any {
+		executor := js.FuncOf(func(_ js.Value, cb []js.Value) any {
+			resolve, reject := cb[0], cb[1]
+			go func() {
+				v, err := f(args)
+				if err != nil {
+					slog.Error("request failed", "error", err)
+					reject.Invoke(js.Global().Get("Error").New(err.Error()))
+					return
+				}
+				resolve.Invoke(v)
+			}()
+			return nil
+		})
+		defer executor.Release()
+		return js.Global().Get("Promise").New(executor)
+	})
+}
+
+// pageLog appends log lines to the element with ID "log", if any, as well as
+// the browser console.
+type pageLog struct{}
+
+func (pageLog) Write(p []byte) (int, error) {
+	line := string(p)
+	js.Global().Get("console").Call("log", line)
+	if el := js.Global().Get("document").Call("getElementById", "log"); !el.IsNull() {
+		el.Call("append", line)
+	}
+	return len(p), nil
+}
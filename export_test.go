@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+// Message bodies, exported so that tests may check that they encode the same
+// as the vectors of package testvectors, which defines its own.
+type (
+	SetCredentialsMsg      = setCredentialsMsg
+	To0Ack                 = to0Ack
+	OwnerSign              = ownerSign
+	To0AcceptOwner         = to0AcceptOwner
+	HelloRV                = helloRV
+	RvAck                  = rvAck
+	HelloDeviceMsg         = helloDeviceMsg
+	OvhProof               = ovhProof
+	OvEntry                = ovEntry
+	DeviceSetup            = deviceSetup
+	DeviceServiceInfoReady = deviceServiceInfoReady
+	OwnerServiceInfoReady  = ownerServiceInfoReady
+	DeviceServiceInfo      = deviceServiceInfo
+	OwnerServiceInfo       = ownerServiceInfo
+	DoneMsg                = doneMsg
+	Done2Msg               = done2Msg
+)
@@ -4,19 +4,30 @@
 package fdo_test
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/x509"
+	"errors"
+	"fmt"
 	"io"
 	"iter"
+	"maps"
+	"math/rand/v2"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/plugin"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
@@ -28,6 +39,79 @@ func TestClient(t *testing.T) {
 	fdotest.RunClientTestSuite(t, fdotest.Config{})
 }
 
+// countingReader is a deterministic, concurrency-safe source of randomness.
+type countingReader struct {
+	mu sync.Mutex
+	r  *rand.ChaCha8
+	n  int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n += len(p)
+	return r.r.Read(p)
+}
+
+func TestClientWithDeterministicRand(t *testing.T) {
+	r := &countingReader{r: rand.NewChaCha8([32]byte{})}
+	fdotest.RunClientTestSuite(t, fdotest.Config{Rand: r})
+	if r.n == 0 {
+		t.Error("expected random source to be used")
+	}
+}
+
+func TestClientWithHooks(t *testing.T) {
+	var to1Complete, voucherVerified, to1Errors, to2Errors int
+	progress := make(map[string][2]int)
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		Hooks: fdo.ClientHooks{
+			OnTO1Complete: func(*cose.Sign1[protocol.To1d, []byte]) { to1Complete++ },
+			OnVoucherVerified: func(*fdo.Voucher) {
+				// Progress is reported per TO2 run
+				voucherVerified++
+				clear(progress)
+			},
+			OnServiceInfoProgress: func(module string, sent, received int) {
+				if prev := progress[module]; sent < prev[0] || received < prev[1] {
+					t.Errorf("expected %s progress to be cumulative, got %d/%d after %d/%d", module, sent, received, prev[0], prev[1])
+				}
+				progress[module] = [2]int{sent, received}
+			},
+			OnError: func(prot protocol.Protocol, _ uint8, err error) {
+				switch prot {
+				case protocol.TO1Protocol:
+					to1Errors++
+				default:
+					to2Errors++
+					t.Errorf("unexpected %s error: %v", prot, err)
+				}
+			},
+		},
+	})
+
+	if to1Complete == 0 {
+		t.Error("expected OnTO1Complete to be called")
+	}
+	if voucherVerified == 0 {
+		t.Error("expected OnVoucherVerified to be called")
+	}
+	if progress["devmod"][0] == 0 {
+		t.Error("expected devmod service info progress to be reported")
+	}
+	if to1Errors == 0 {
+		t.Error("expected OnError to be called for TO1 before TO0 registration")
+	}
+}
+
+func TestClientWithEncryption(t *testing.T) {
+	fdotest.RunClientTestSuite(t, fdotest.Config{Encrypt: true, MaxTO2Sessions: 1})
+}
+
+func TestClientWithSessionLimit(t *testing.T) {
+	fdotest.RunClientTestSuite(t, fdotest.Config{MaxTO2Sessions: 1})
+}
+
 func TestClientWithMockModule(t *testing.T) {
 	deviceModule := &fdotest.MockDeviceModule{
 		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
@@ -43,69 +127,953 @@ func TestClientWithMockModule(t *testing.T) {
 			if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
 				return false, false, err
 			}
-			return false, true, nil
+			return false, true, nil
+		},
+	}
+
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			mockModuleName: deviceModule,
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(mockModuleName, ownerModule)
+			}
+		},
+	})
+
+	if !deviceModule.ActiveState {
+		t.Error("device module should be active")
+	}
+}
+
+func TestClientWithInteractiveModule(t *testing.T) {
+	const turns = 4
+	challenge := bytes.Repeat([]byte("challenge"), 200)
+
+	deviceModule := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			var body []byte
+			if err := cbor.NewDecoder(messageBody).Decode(&body); err != nil {
+				return err
+			}
+			if messageName != "challenge" {
+				return fmt.Errorf("unexpected message %q", messageName)
+			}
+			slices.Reverse(body)
+			return cbor.NewEncoder(respond("response")).Encode(body)
+		},
+	}
+
+	var mu sync.Mutex
+	var completed int
+	newOwnerModule := func() serviceinfo.OwnerModule {
+		var turn int
+		return serviceinfo.Interactive(serviceinfo.InteractiveFunc(func(ctx context.Context, t *serviceinfo.Turn) (bool, error) {
+			if turn > 0 {
+				received := t.Received()
+				if len(received) != 1 || received[0].Name != "response" {
+					return false, fmt.Errorf("turn %d: unexpected messages %v", turn, received)
+				}
+				var body []byte
+				if err := cbor.Unmarshal(received[0].Body, &body); err != nil {
+					return false, err
+				}
+				expect := slices.Clone(challenge)
+				slices.Reverse(expect)
+				if !bytes.Equal(body, expect) {
+					return false, fmt.Errorf("turn %d: unexpected response", turn)
+				}
+			}
+			if turn == turns {
+				mu.Lock()
+				completed++
+				mu.Unlock()
+				return true, nil
+			}
+			turn++
+			body, err := cbor.Marshal(challenge)
+			if err != nil {
+				return false, err
+			}
+			t.Send("challenge", body)
+			return false, nil
+		}))
+	}
+
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			mockModuleName: deviceModule,
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(mockModuleName, newOwnerModule())
+			}
+		},
+	})
+
+	if completed == 0 {
+		t.Error("interactive module did not complete")
+	}
+}
+
+func TestClientWithMockModuleAndAutoUnchunking(t *testing.T) {
+	deviceModule := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			var v any
+			return cbor.NewDecoder(messageBody).Decode(&v)
+		},
+	}
+	ownerModule := &fdotest.MockOwnerModule{
+		ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+			if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+				return false, false, err
+			}
+			if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
+				return false, false, err
+			}
+			if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
+				return false, false, err
+			}
+			return false, true, nil
+		},
+	}
+
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			mockModuleName: deviceModule,
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(mockModuleName, ownerModule)
+			}
+		},
+		CustomExpect: func(t *testing.T, err error) {
+			if err == nil {
+				t.Error("expected err to occur when not handling all message chunks")
+			}
+			if !strings.Contains(err.Error(), "device module did not read full body") {
+				t.Error("expected err to refer to device module not reading full message body")
+			}
+		},
+	})
+
+	if !deviceModule.ActiveState {
+		t.Error("device module should be active")
+	}
+}
+
+func TestClientWithUnsupportedMessage(t *testing.T) {
+	deviceModule := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			t.Errorf("unsupported message %q passed to device module", messageName)
+			_, err := io.Copy(io.Discard, messageBody)
+			return err
+		},
+	}
+	ownerModule := &fdotest.MockOwnerModule{
+		HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+			_, err := io.Copy(io.Discard, messageBody)
+			return err
+		},
+		ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+			if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+				return false, false, err
+			}
+			if err := producer.WriteChunk("reboot", []byte{0xf5}); err != nil {
+				return false, false, err
+			}
+			return false, true, nil
+		},
+	}
+
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			mockModuleName: deviceModule,
+		},
+		DeviceModuleInfo: map[string]serviceinfo.ModuleInfo{
+			mockModuleName: {Version: "1.2", Messages: []string{"ping", "status"}},
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(mockModuleName, ownerModule)
+			}
+		},
+		CustomExpect: func(t *testing.T, err error) {
+			if err == nil {
+				t.Fatal("expected err to occur when owner sends an unsupported message")
+			}
+			if want := `unsupported message "reboot" to device module "` + mockModuleName + `" version 1.2, which supports: ping, status`; !strings.Contains(err.Error(), want) {
+				t.Errorf("expected err to contain %q, got %v", want, err)
+			}
+		},
+	})
+}
+
+func TestClientWithStreamingModule(t *testing.T) {
+	const size = 64<<10 + 1
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var received bytes.Buffer
+	var completed int
+	sink := &serviceinfo.StreamSink{Writer: &received}
+	deviceModule := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			done, err := sink.Receive(messageBody)
+			if err != nil {
+				return err
+			}
+			if done {
+				if !bytes.Equal(received.Bytes(), data) {
+					return fmt.Errorf("received %d bytes not matching sent data", received.Len())
+				}
+				completed++
+				received.Reset()
+				sink = &serviceinfo.StreamSink{Writer: &received}
+			}
+			return nil
+		},
+	}
+
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			mockModuleName: deviceModule,
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			var activated bool
+			src := &serviceinfo.StreamSource{MessageName: "data", Reader: bytes.NewReader(data), Window: 4}
+			ownerModule := &fdotest.MockOwnerModule{
+				HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+					_, err := io.Copy(io.Discard, messageBody)
+					return err
+				},
+				ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+					if !activated {
+						activated = true
+						return false, false, producer.WriteChunk("active", []byte{0xf5})
+					}
+					return src.Produce(producer)
+				},
+			}
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(mockModuleName, ownerModule)
+			}
+		},
+	})
+
+	if completed == 0 {
+		t.Error("expected streamed data to be received")
+	}
+}
+
+func TestClientWithServiceInfoLimits(t *testing.T) {
+	for _, quota := range []int64{0, 8 << 10} {
+		deviceModule := &fdotest.MockDeviceModule{
+			ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+				_, err := io.Copy(io.Discard, messageBody)
+				return err
+			},
+		}
+
+		var expectErr func(*testing.T, error)
+		if quota > 0 {
+			expectErr = func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), fdo.ErrQuotaExceeded.Error()) {
+					t.Errorf("expected quota to be exceeded, got %v", err)
+				}
+			}
+		}
+
+		fdotest.RunClientTestSuite(t, fdotest.Config{
+			ServiceInfoLimits: fdo.ServiceInfoLimits{
+				OwnerRate:  4 << 20,
+				DeviceRate: 4 << 20,
+				OwnerQuota: quota,
+			},
+			DeviceModules: map[string]serviceinfo.DeviceModule{
+				mockModuleName: deviceModule,
+			},
+			OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+				var sent int
+				ownerModule := &fdotest.MockOwnerModule{
+					HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+						_, err := io.Copy(io.Discard, messageBody)
+						return err
+					},
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						if sent == 0 {
+							sent++
+							return false, false, producer.WriteChunk("active", []byte{0xf5})
+						}
+						sent++
+						return false, sent > 16, producer.WriteChunk("data", make([]byte, 1000))
+					},
+				}
+				return func(yield func(string, serviceinfo.OwnerModule) bool) {
+					yield(mockModuleName, ownerModule)
+				}
+			},
+			CustomExpect: expectErr,
+		})
+	}
+}
+
+func TestClientWithModuleTimeouts(t *testing.T) {
+	// Hung modules ignore their context until the test ends
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	// Timed out modules fail recoverably, so onboarding completes without them
+	t.Run("device", func(t *testing.T) {
+		var timedOut atomic.Bool
+		deviceModule := &fdotest.MockDeviceModule{
+			ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+				<-release
+				return nil
+			},
+		}
+		fdotest.RunClientTestSuite(t, fdotest.Config{
+			Hooks: fdo.ClientHooks{
+				OnModuleError: func(module string, err error) {
+					if module == mockModuleName && errors.Is(err, fdo.ErrModuleTimeout) {
+						timedOut.Store(true)
+					}
+				},
+			},
+			ModuleTimeouts: fdo.ModuleTimeouts{Message: 50 * time.Millisecond},
+			DeviceModules: map[string]serviceinfo.DeviceModule{
+				mockModuleName: deviceModule,
+			},
+			OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+				ownerModule := &fdotest.MockOwnerModule{
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+							return false, false, err
+						}
+						return false, true, producer.WriteChunk("message", []byte{0xf4})
+					},
+				}
+				return func(yield func(string, serviceinfo.OwnerModule) bool) {
+					yield(mockModuleName, ownerModule)
+				}
+			},
+		})
+		if !timedOut.Load() {
+			t.Error("expected device module to time out")
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		fdotest.RunClientTestSuite(t, fdotest.Config{
+			ModuleTimeouts: fdo.ModuleTimeouts{Module: 50 * time.Millisecond},
+			DeviceModules: map[string]serviceinfo.DeviceModule{
+				mockModuleName: &fdotest.MockDeviceModule{},
+			},
+			OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+				var started bool
+				ownerModule := &fdotest.MockOwnerModule{
+					HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+						_, err := io.Copy(io.Discard, messageBody)
+						return err
+					},
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						if !started {
+							started = true
+							return false, false, producer.WriteChunk("active", []byte{0xf5})
+						}
+						<-release
+						return false, true, nil
+					},
+				}
+				return func(yield func(string, serviceinfo.OwnerModule) bool) {
+					yield(mockModuleName, ownerModule)
+				}
+			},
+		})
+	})
+
+	t.Run("owner modules of the same name", func(t *testing.T) {
+		// Each module takes less than the module timeout, but together they
+		// take more
+		server := fdotest.NewServer(t)
+		server.TO2.ModuleTimeouts = fdo.ModuleTimeouts{Module: 300 * time.Millisecond}
+		server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			newOwnerModule := func() serviceinfo.OwnerModule {
+				var rounds int
+				return &fdotest.MockOwnerModule{
+					HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+						_, err := io.Copy(io.Discard, messageBody)
+						return err
+					},
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						rounds++
+						time.Sleep(100 * time.Millisecond)
+						if rounds == 1 {
+							return false, false, producer.WriteChunk("active", []byte{0xf5})
+						}
+						return false, true, nil
+					},
+				}
+			}
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				if !yield(mockModuleName, newOwnerModule()) {
+					return
+				}
+				yield(mockModuleName, newOwnerModule())
+			}
+		}
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		if err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{mockModuleName: &fdotest.MockDeviceModule{}}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestClientWithRecoverableModuleError(t *testing.T) {
+	const otherModuleName = "fdotest.other"
+
+	var mu sync.Mutex
+	var deviceErrs []string
+	var ownerErrs []*serviceinfo.ModuleError
+	var otherDone int
+
+	failing := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			_, _ = io.Copy(io.Discard, messageBody)
+			return serviceinfo.RecoverableError(errors.New("not supported on this device"))
+		},
+	}
+	other := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			_, _ = io.Copy(io.Discard, messageBody)
+			_, err := respond("done").Write([]byte{0xf5})
+			return err
+		},
+	}
+
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		Hooks: fdo.ClientHooks{
+			OnModuleError: func(module string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				deviceErrs = append(deviceErrs, module)
+			},
+		},
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			mockModuleName:  failing,
+			otherModuleName: other,
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			// Each owner module sends one message and is done once handle
+			// succeeds
+			newOwnerModule := func(handle func(messageName string, messageBody io.Reader) error) serviceinfo.OwnerModule {
+				var sent, done bool
+				return &fdotest.MockOwnerModule{
+					HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+						if messageName == "active" {
+							_, err := io.Copy(io.Discard, messageBody)
+							return err
+						}
+						if err := handle(messageName, messageBody); err != nil {
+							return err
+						}
+						done = true
+						return nil
+					},
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						if sent {
+							return false, done, nil
+						}
+						sent = true
+						if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+							return false, false, err
+						}
+						return false, false, producer.WriteChunk("start", []byte{0xf5})
+					},
+				}
+			}
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				if !yield(mockModuleName, newOwnerModule(func(messageName string, messageBody io.Reader) error {
+					if messageName != serviceinfo.ErrorMessageName {
+						return fmt.Errorf("unexpected message %q", messageName)
+					}
+					modErr, err := serviceinfo.DecodeError(mockModuleName, messageBody)
+					if err != nil {
+						return err
+					}
+					mu.Lock()
+					ownerErrs = append(ownerErrs, modErr)
+					mu.Unlock()
+					return serviceinfo.RecoverableError(modErr)
+				})) {
+					return
+				}
+				yield(otherModuleName, newOwnerModule(func(messageName string, messageBody io.Reader) error {
+					_, err := io.Copy(io.Discard, messageBody)
+					mu.Lock()
+					otherDone++
+					mu.Unlock()
+					return err
+				}))
+			}
+		},
+	})
+
+	if len(deviceErrs) == 0 || deviceErrs[0] != mockModuleName {
+		t.Errorf("expected device hook to report failure of %q, got %v", mockModuleName, deviceErrs)
+	}
+	if len(ownerErrs) == 0 || !ownerErrs[0].Recoverable || ownerErrs[0].Message != "not supported on this device" {
+		t.Errorf("expected owner module to receive recoverable error, got %v", ownerErrs)
+	}
+	if otherDone != len(ownerErrs) {
+		t.Errorf("expected other module to complete after each failure, got %d of %d", otherDone, len(ownerErrs))
+	}
+}
+
+func TestTO2PeerModuleErrorIsFatal(t *testing.T) {
+	server := fdotest.NewServer(t)
+
+	// The owner module returns the decoded error of the device as is
+	server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		var sent bool
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			yield(mockModuleName, &fdotest.MockOwnerModule{
+				HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+					if messageName != serviceinfo.ErrorMessageName {
+						_, err := io.Copy(io.Discard, messageBody)
+						return err
+					}
+					modErr, err := serviceinfo.DecodeError(mockModuleName, messageBody)
+					if err != nil {
+						return err
+					}
+					return modErr
+				},
+				ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+					if sent {
+						return false, false, nil
+					}
+					sent = true
+					if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+						return false, false, err
+					}
+					return false, false, producer.WriteChunk("start", []byte{0xf5})
+				},
+			})
+		}
+	}
+	deviceModules := map[string]serviceinfo.DeviceModule{
+		mockModuleName: &fdotest.MockDeviceModule{
+			ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+				_, _ = io.Copy(io.Discard, messageBody)
+				if messageName == "active" {
+					return nil
+				}
+				return serviceinfo.RecoverableError(errors.New("skip me"))
+			},
+		},
+	}
+
+	// A recoverable error reported by the device does not skip the module
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, deviceModules); err == nil {
+		t.Fatal("expected onboarding to fail when the owner module returns the device's error")
+	}
+}
+
+func TestTO2ClosesOwnerModules(t *testing.T) {
+	server := fdotest.NewServer(t)
+
+	// The first module completes and the second fails TO2 when fail is set
+	var closed []string
+	var fail bool
+	server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		newModule := func(name string, produce func(*serviceinfo.Producer) (bool, error)) serviceinfo.OwnerModule {
+			return &closingOwnerModule{
+				MockOwnerModule: fdotest.MockOwnerModule{
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						done, err := produce(producer)
+						return false, done, err
+					},
+				},
+				close: func() { closed = append(closed, name) },
+			}
+		}
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			if !yield(mockModuleName, newModule("complete", func(*serviceinfo.Producer) (bool, error) { return true, nil })) {
+				return
+			}
+			yield(mockModuleName, newModule("fail", func(*serviceinfo.Producer) (bool, error) {
+				if fail {
+					return false, errors.New("module failed")
+				}
+				return true, nil
+			}))
+		}
+	}
+
+	// Modules are closed once each when TO2 completes or fails
+	for _, fail = range []bool{false, true} {
+		closed = nil
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		err := server.Onboard(t, dev, nil)
+		if fail != (err != nil) {
+			t.Fatalf("expected failure %t, got %v", fail, err)
+		}
+		if !slices.Equal(closed, []string{"complete", "fail"}) {
+			t.Fatalf("expected each owner module to be closed once, got %v", closed)
+		}
+	}
+}
+
+func TestTO2ExpiresAbandonedModules(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.SessionTimeout = 50 * time.Millisecond
+
+	closed := make(chan struct{})
+	server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			yield(mockModuleName, &closingOwnerModule{
+				MockOwnerModule: fdotest.MockOwnerModule{
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+							return false, false, err
+						}
+						if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
+							return false, false, err
+						}
+						return false, true, nil
+					},
+				},
+				close: func() { close(closed) },
+			})
+		}
+	}
+
+	// The device stops sending messages in the middle of service info
+	stalled, resume := make(chan struct{}), make(chan struct{})
+	deviceModules := map[string]serviceinfo.DeviceModule{
+		mockModuleName: &fdotest.MockDeviceModule{
+			ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+				if _, err := io.Copy(io.Discard, messageBody); err != nil {
+					return err
+				}
+				close(stalled)
+				<-resume
+				return nil
+			},
+		},
+	}
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	onboarded := make(chan error, 1)
+	go func() { onboarded <- server.Onboard(t, dev, deviceModules) }()
+	<-stalled
+
+	// Once the session times out, its owner modules are stopped
+	time.Sleep(2 * server.TO2.SessionTimeout)
+	server.TO2.ExpireSessions(context.Background())
+	select {
+	case <-closed:
+	default:
+		t.Fatal("expected owner module of abandoned session to be closed")
+	}
+
+	close(resume)
+	if err := <-onboarded; err == nil {
+		t.Fatal("expected TO2 of expired session to fail")
+	}
+}
+
+func TestClientWithCompression(t *testing.T) {
+	const chunks, chunkSize = 16, 1000
+	var received int
+	deviceModule := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			n, err := io.Copy(io.Discard, messageBody)
+			if err != nil {
+				return err
+			}
+			received += int(n)
+			// Echo the data back
+			_, err = respond("echo").Write(make([]byte, n))
+			return err
 		},
 	}
 
+	// Quotas are smaller than the uncompressed data, so they are only met
+	// if service info is compressed in both directions
 	fdotest.RunClientTestSuite(t, fdotest.Config{
+		Compression: []fdo.ServiceInfoCompression{fdo.DeflateCompression},
+		ServiceInfoLimits: fdo.ServiceInfoLimits{
+			OwnerQuota:  chunks * chunkSize / 2,
+			DeviceQuota: chunks * chunkSize / 2,
+		},
 		DeviceModules: map[string]serviceinfo.DeviceModule{
 			mockModuleName: deviceModule,
 		},
 		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			var sent, echoed int
+			ownerModule := &fdotest.MockOwnerModule{
+				HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+					n, err := io.Copy(io.Discard, messageBody)
+					if messageName == "echo" {
+						echoed += int(n)
+					}
+					return err
+				},
+				ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+					if sent == 0 {
+						sent++
+						return false, false, producer.WriteChunk("active", []byte{0xf5})
+					}
+					if sent > chunks {
+						if echoed != chunks*chunkSize {
+							return false, false, fmt.Errorf("expected %d echoed bytes, got %d", chunks*chunkSize, echoed)
+						}
+						return false, true, nil
+					}
+					sent++
+					return false, false, producer.WriteChunk("data", make([]byte, chunkSize))
+				},
+			}
 			return func(yield func(string, serviceinfo.OwnerModule) bool) {
 				yield(mockModuleName, ownerModule)
 			}
 		},
 	})
 
-	if !deviceModule.ActiveState {
-		t.Error("device module should be active")
+	if received == 0 || received%(chunks*chunkSize) != 0 {
+		t.Errorf("expected a multiple of %d bytes received, got %d", chunks*chunkSize, received)
 	}
 }
 
-func TestClientWithMockModuleAndAutoUnchunking(t *testing.T) {
-	deviceModule := &fdotest.MockDeviceModule{
-		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
-			var v any
-			return cbor.NewDecoder(messageBody).Decode(&v)
-		},
+func TestClientWithInterleavedModules(t *testing.T) {
+	const slowModuleName, fastModuleName = "fdotest.slow", "fdotest.fast"
+	const slowRounds = 5
+
+	discard := func() *fdotest.MockDeviceModule {
+		return &fdotest.MockDeviceModule{
+			ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+				_, err := io.Copy(io.Discard, messageBody)
+				return err
+			},
+		}
 	}
-	ownerModule := &fdotest.MockOwnerModule{
-		ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
-			if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
-				return false, false, err
+	handle := func(ctx context.Context, messageName string, messageBody io.Reader) error {
+		_, err := io.Copy(io.Discard, messageBody)
+		return err
+	}
+
+	var runs int
+	fdotest.RunClientTestSuite(t, fdotest.Config{
+		InterleaveModules: true,
+		DeviceModules: map[string]serviceinfo.DeviceModule{
+			slowModuleName: discard(),
+			fastModuleName: discard(),
+		},
+		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			// The slow module waits for several rounds, as if a command were
+			// running, and the fast module must complete in the meantime
+			var slow, fast int
+			slowModule := &fdotest.MockOwnerModule{
+				HandleInfoFunc: handle,
+				ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+					slow++
+					if slow == 1 {
+						return false, false, producer.WriteChunk("active", []byte{0xf5})
+					}
+					if slow < slowRounds {
+						return false, false, nil
+					}
+					if fast < 2 {
+						return false, false, fmt.Errorf("slow module blocked fast module")
+					}
+					runs++
+					return false, true, nil
+				},
 			}
-			if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
-				return false, false, err
+			fastModule := &fdotest.MockOwnerModule{
+				HandleInfoFunc: handle,
+				ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+					fast++
+					if fast == 1 {
+						return false, false, producer.WriteChunk("active", []byte{0xf5})
+					}
+					return false, true, producer.WriteChunk("message", []byte{0xf4})
+				},
 			}
-			if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
-				return false, false, err
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				if !yield(slowModuleName, slowModule) {
+					return
+				}
+				yield(fastModuleName, fastModule)
+			}
+		},
+	})
+
+	if runs == 0 {
+		t.Error("expected interleaved modules to complete")
+	}
+}
+
+func TestClientWithInterleavedModulesOfSameName(t *testing.T) {
+	const fileModuleName, otherModuleName = "fdotest.file", "fdotest.other"
+
+	// The device module receives one file at a time and acknowledges each
+	var mu sync.Mutex
+	var current *bytes.Buffer
+	received := make(map[string]string)
+	var name string
+	fileDevice := &fdotest.MockDeviceModule{
+		ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+			mu.Lock()
+			defer mu.Unlock()
+			switch messageName {
+			case "active":
+				_, err := io.Copy(io.Discard, messageBody)
+				return err
+			case "start":
+				if current != nil {
+					return fmt.Errorf("file started before %q was done", name)
+				}
+				if err := cbor.NewDecoder(messageBody).Decode(&name); err != nil {
+					return err
+				}
+				current = new(bytes.Buffer)
+				return nil
+			case "data":
+				if current == nil {
+					return fmt.Errorf("data before start")
+				}
+				var chunk []byte
+				if err := cbor.NewDecoder(messageBody).Decode(&chunk); err != nil {
+					return err
+				}
+				current.Write(chunk)
+				return nil
+			case "done":
+				if current == nil {
+					return fmt.Errorf("done before start")
+				}
+				if _, err := io.Copy(io.Discard, messageBody); err != nil {
+					return err
+				}
+				received[name], current = current.String(), nil
+				return cbor.NewEncoder(respond("done")).Encode(name)
+			default:
+				return fmt.Errorf("unexpected message %q", messageName)
 			}
-			return false, true, nil
 		},
 	}
 
+	// Each owner module sends a file in chunks and is done once the device
+	// acknowledges it
+	acked := make(map[string]string)
+	newFileModule := func(name string, chunks ...string) serviceinfo.OwnerModule {
+		var round int
+		var done bool
+		return &fdotest.MockOwnerModule{
+			HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+				if messageName != "done" {
+					_, err := io.Copy(io.Discard, messageBody)
+					return err
+				}
+				var ack string
+				if err := cbor.NewDecoder(messageBody).Decode(&ack); err != nil {
+					return err
+				}
+				mu.Lock()
+				acked[name] = ack
+				mu.Unlock()
+				done = true
+				return nil
+			},
+			ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+				if done {
+					return false, true, nil
+				}
+				round++
+				var messageName string
+				var body any
+				switch {
+				case round == 1:
+					if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+						return false, false, err
+					}
+					messageName, body = "start", name
+				case round-2 < len(chunks):
+					messageName, body = "data", []byte(chunks[round-2])
+				case round-2 == len(chunks):
+					messageName, body = "done", true
+				default:
+					return false, false, nil
+				}
+				data, err := cbor.Marshal(body)
+				if err != nil {
+					return false, false, err
+				}
+				return false, false, producer.WriteChunk(messageName, data)
+			},
+		}
+	}
+	var otherRounds int
+
 	fdotest.RunClientTestSuite(t, fdotest.Config{
+		InterleaveModules: true,
 		DeviceModules: map[string]serviceinfo.DeviceModule{
-			mockModuleName: deviceModule,
+			fileModuleName: fileDevice,
+			otherModuleName: &fdotest.MockDeviceModule{
+				ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+					_, err := io.Copy(io.Discard, messageBody)
+					return err
+				},
+			},
 		},
 		OwnerModules: func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, supportedMods []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+			mu.Lock()
+			current = nil
+			clear(received)
+			clear(acked)
+			mu.Unlock()
+			otherRounds = 0
 			return func(yield func(string, serviceinfo.OwnerModule) bool) {
-				yield(mockModuleName, ownerModule)
-			}
-		},
-		CustomExpect: func(t *testing.T, err error) {
-			if err == nil {
-				t.Error("expected err to occur when not handling all message chunks")
-			}
-			if !strings.Contains(err.Error(), "device module did not read full body") {
-				t.Error("expected err to refer to device module not reading full message body")
+				if !yield(fileModuleName, newFileModule("a.bin", "aa", "AA")) {
+					return
+				}
+				if !yield(otherModuleName, &fdotest.MockOwnerModule{
+					HandleInfoFunc: func(ctx context.Context, messageName string, messageBody io.Reader) error {
+						_, err := io.Copy(io.Discard, messageBody)
+						return err
+					},
+					ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+						otherRounds++
+						if otherRounds == 1 {
+							return false, false, producer.WriteChunk("active", []byte{0xf5})
+						}
+						return false, otherRounds > 6, nil
+					},
+				}) {
+					return
+				}
+				yield(fileModuleName, newFileModule("b.bin", "bb", "BB"))
 			}
 		},
 	})
 
-	if !deviceModule.ActiveState {
-		t.Error("device module should be active")
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[string]string{"a.bin": "aaAA", "b.bin": "bbBB"}; !maps.Equal(received, want) {
+		t.Errorf("expected device to receive %v, got %v", want, received)
+	}
+	if want := map[string]string{"a.bin": "a.bin", "b.bin": "b.bin"}; !maps.Equal(acked, want) {
+		t.Errorf("expected each owner module to receive its own acknowledgement, got %v", acked)
 	}
 }
 
@@ -272,6 +1240,532 @@ func TestClientWithPluginModule(t *testing.T) {
 	}
 }
 
+func TestServer(t *testing.T) {
+	for _, keyType := range []protocol.KeyType{
+		protocol.Secp256r1KeyType,
+		protocol.Secp384r1KeyType,
+		protocol.Rsa2048RestrKeyType,
+		protocol.RsaPkcsKeyType,
+		protocol.RsaPssKeyType,
+	} {
+		t.Run(keyType.String(), func(t *testing.T) {
+			server := fdotest.NewServer(t)
+			server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+				return func(yield func(string, serviceinfo.OwnerModule) bool) {
+					yield(mockModuleName, &fdotest.MockOwnerModule{
+						ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+							if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+								return false, false, err
+							}
+							if err := producer.WriteChunk("message", []byte{0xf4}); err != nil {
+								return false, false, err
+							}
+							return false, true, nil
+						},
+					})
+				}
+			}
+
+			dev := server.NewDevice(t, keyType)
+			guid := dev.Cred.GUID
+			if ov := server.Voucher(t, guid); ov.Header.Val.GUID != guid {
+				t.Fatalf("expected voucher for %x, got %x", guid, ov.Header.Val.GUID)
+			}
+			server.RegisterBlob(t, guid)
+
+			deviceModule := &fdotest.MockDeviceModule{
+				ReceiveFunc: func(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+					_, _ = io.Copy(io.Discard, messageBody)
+					return nil
+				},
+			}
+			if err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{mockModuleName: deviceModule}); err != nil {
+				t.Fatal(err)
+			}
+			if dev.Cred.GUID == guid {
+				t.Error("expected credential to be replaced")
+			}
+			if !deviceModule.ActiveState {
+				t.Error("device module should be active")
+			}
+
+			server.AssertExchanged(t,
+				protocol.DIAppStartMsgType, protocol.DISetHmacMsgType,
+				protocol.TO0HelloMsgType, protocol.TO0OwnerSignMsgType,
+				protocol.TO1HelloRVMsgType, protocol.TO1ProveToRVMsgType,
+				protocol.TO2HelloDeviceMsgType, protocol.TO2DeviceServiceInfoMsgType, protocol.TO2DoneMsgType,
+			)
+			var sentModule bool
+			for _, ex := range server.Exchanges(t) {
+				if ex.RespType == protocol.TO2OwnerServiceInfoMsgType && bytes.Contains(ex.Response, []byte(mockModuleName+":message")) {
+					sentModule = true
+				}
+			}
+			if !sentModule {
+				t.Error("expected owner module service info to be recorded unencrypted")
+			}
+		})
+	}
+}
+
+func TestTO2RejectsForeignTo1d(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	guid := dev.Cred.GUID
+	server.RegisterBlob(t, guid)
+
+	// Replace the rendezvous blob with one signed by a key other than the owner
+	ctx := context.Background()
+	to1d, ov, err := server.State.RVBlob(ctx, guid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := cose.Sign1[protocol.To1d, []byte]{Payload: to1d.Payload}
+	if err := forged.Sign(key, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.State.SetRVBlob(ctx, ov, &forged, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Onboard(t, dev, nil)
+	var mismatch fdo.ErrTo1dOwnerMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected to1d owner mismatch error, got %v", err)
+	}
+	if !errors.Is(err, fdo.ErrCryptoVerifyFailed) {
+		t.Errorf("expected crypto verification failure, got %v", err)
+	}
+	if dev.Cred.GUID != guid {
+		t.Error("expected credential not to be replaced")
+	}
+	server.AssertExchanged(t, protocol.TO2HelloDeviceMsgType, protocol.ErrorMsgType)
+}
+
+func TestTO2OwnerRoots(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.RsaPkcsKeyType)
+
+	_, ownerChain, err := server.State.OwnerKey(protocol.RsaPkcsKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := x509.NewCertPool()
+	trusted.AddCert(ownerChain[len(ownerChain)-1])
+	_, otherChain, err := server.State.OwnerKey(protocol.Secp384r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted := x509.NewCertPool()
+	untrusted.AddCert(otherChain[len(otherChain)-1])
+
+	onboard := func(roots *x509.CertPool) error {
+		t.Helper()
+		server.RegisterBlob(t, dev.Cred.GUID)
+		ctx := context.Background()
+		to1d, err := fdo.TO1(ctx, server.Transport(), dev.Cred, dev.Key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cred, err := fdo.TO2(ctx, server.Transport(), to1d, fdo.TO2Config{
+			Cred:       dev.Cred,
+			HmacSha256: dev.HmacSha256,
+			HmacSha384: dev.HmacSha384,
+			Key:        dev.Key,
+			Devmod: serviceinfo.Devmod{
+				Os:      runtime.GOOS,
+				Arch:    runtime.GOARCH,
+				Version: "go-fdo test",
+				Device:  "go-validation",
+				FileSep: ";",
+				Bin:     runtime.GOARCH,
+			},
+			KeyExchange: kex.DHKEXid15Suite,
+			CipherSuite: kex.A128GcmCipher,
+			OwnerRoots:  roots,
+		})
+		if err != nil {
+			return err
+		}
+		dev.Cred = *cred
+		return nil
+	}
+
+	// The voucher has an X509 manufacturer key, so the owner key is not sent
+	// as a chain by default
+	if err := onboard(trusted); !errors.Is(err, fdo.ErrCryptoVerifyFailed) {
+		t.Fatalf("expected owner key without chain to fail verification, got %v", err)
+	}
+
+	server.TO2.X5ChainOwnerKey = true
+	if err := onboard(untrusted); !errors.Is(err, fdo.ErrCryptoVerifyFailed) {
+		t.Fatalf("expected owner chain to untrusted root to fail verification, got %v", err)
+	}
+	if err := onboard(trusted); err != nil {
+		t.Fatal(err)
+	}
+	if enc := server.Voucher(t, dev.Cred.GUID).Header.Val.ManufacturerKey.Encoding; enc != protocol.X5ChainKeyEnc {
+		t.Fatalf("expected replacement voucher to be owned by an X5Chain, got %s", enc)
+	}
+}
+
+func TestTO2RecordsHelloDevice(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.HelloDevices = server.State
+	ctx := context.Background()
+
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	guid := dev.Cred.GUID
+	server.RegisterBlob(t, guid)
+
+	// Messages failing negotiation are recorded with their error
+	server.TO2.CryptoProfile = &fdo.CryptoProfile{Name: "test", CipherSuites: []kex.CipherSuiteID{kex.A256GcmCipher}}
+	if err := server.Onboard(t, dev, nil); err == nil {
+		t.Fatal("expected onboarding with a disallowed cipher suite to fail")
+	}
+	server.TO2.CryptoProfile = nil
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := server.State.HelloDevices(ctx, guid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 HelloDevice records, got %d", len(records))
+	}
+	failed, accepted := records[0], records[1]
+	if !strings.Contains(failed.Err, "cipher suite") || failed.Hash.Algorithm != protocol.Sha256Hash {
+		t.Fatalf("unexpected record of failed session: %+v", failed)
+	}
+	params, err := failed.Params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.KexSuiteName != kex.ECDH256Suite || params.CipherSuite != kex.A128GcmCipher {
+		t.Fatalf("unexpected negotiated parameters: %+v", params)
+	}
+
+	// The hash of accepted messages is the HelloDeviceHash of ProveOVHdr
+	if accepted.Err != "" {
+		t.Fatalf("unexpected error recorded: %s", accepted.Err)
+	}
+	h := accepted.Hash.Algorithm.HashFunc().New()
+	_, _ = h.Write(accepted.Raw)
+	if !bytes.Equal(h.Sum(nil), accepted.Hash.Value) {
+		t.Fatal("recorded hash does not match recorded message")
+	}
+}
+
+func TestTO2SessionLimit(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.MaxSessions = 1
+
+	// Block TO2.HelloDevice of the first device until released
+	started, blocked := make(chan struct{}), make(chan struct{})
+	release := sync.OnceFunc(func() { close(blocked) })
+	defer release()
+	var first atomic.Bool
+	server.TO2.VerifyVoucher = func(context.Context, fdo.Voucher) error {
+		if first.CompareAndSwap(false, true) {
+			close(started)
+			<-blocked
+		}
+		return nil
+	}
+
+	inFlight := server.NewDevice(t, protocol.Secp256r1KeyType)
+	second := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, inFlight.Cred.GUID)
+	server.RegisterBlob(t, second.Cred.GUID)
+
+	onboarded := make(chan error, 1)
+	go func() { onboarded <- server.Onboard(t, inFlight, nil) }()
+	<-started
+
+	// The slot of a session is taken as soon as its TO2.HelloDevice is
+	// accepted, before the message has been handled
+	if err := server.Onboard(t, second, nil); err == nil || !strings.Contains(err.Error(), fdo.ErrTooManySessions.Error()) {
+		t.Fatalf("expected concurrent TO2 session to be rejected, got %v", err)
+	}
+	if sessions := server.TO2.ActiveSessions(); sessions != 1 {
+		t.Fatalf("expected rejected session to not be tracked, got %d sessions", sessions)
+	}
+
+	// Once the first session ends, its slot is released
+	release()
+	if err := <-onboarded; err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Onboard(t, second, nil); err != nil {
+		t.Fatalf("expected TO2 session to be accepted after the first ended, got %v", err)
+	}
+	if sessions := server.TO2.ActiveSessions(); sessions != 0 {
+		t.Fatalf("expected no sessions to be tracked, got %d", sessions)
+	}
+}
+
+func TestTO2ServerShutdown(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.SessionTimeout = time.Minute
+
+	// Block service info of the first device until released
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			yield(mockModuleName, &fdotest.MockOwnerModule{
+				ProduceInfoFunc: func(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+					once.Do(func() { close(started) })
+					<-release
+					if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+						return false, false, err
+					}
+					return false, true, nil
+				},
+			})
+		}
+	}
+	deviceModules := map[string]serviceinfo.DeviceModule{mockModuleName: &fdotest.MockDeviceModule{}}
+
+	inFlight := server.NewDevice(t, protocol.Secp256r1KeyType)
+	rejected := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, inFlight.Cred.GUID)
+	server.RegisterBlob(t, rejected.Cred.GUID)
+
+	onboarded := make(chan error, 1)
+	go func() { onboarded <- server.Onboard(t, inFlight, deviceModules) }()
+	<-started
+
+	// Shutdown times out while a session is in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.TO2.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected shutdown to time out, got %v", err)
+	}
+
+	// New sessions are rejected
+	if err := server.Onboard(t, rejected, deviceModules); err == nil || !strings.Contains(err.Error(), fdo.ErrShuttingDown.Error()) {
+		t.Fatalf("expected new TO2 session to be rejected, got %v", err)
+	}
+
+	// The in-flight session completes and then shutdown does
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.TO2.Shutdown(context.Background()) }()
+	close(release)
+	if err := <-onboarded; err != nil {
+		t.Fatalf("expected in-flight session to complete, got %v", err)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not complete after sessions ended")
+	}
+}
+
+func TestOwnerKeyRotation(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.SessionTimeout = time.Minute
+	dnsAddr := "owner.fidoalliance.org"
+	to0 := *server.TO0Client
+	to0.NewTransport = func(string) fdo.Transport { return server.Transport() }
+	to0.TO2Addrs = []protocol.RvTO2Addr{
+		{
+			DNSAddress:        &dnsAddr,
+			Port:              8080,
+			TransportProtocol: protocol.HTTPTransport,
+		},
+	}
+	rotator := &fdo.OwnerKeyRotator{
+		Keys:     server.State,
+		Vouchers: server.State,
+		KeyTypes: []protocol.KeyType{protocol.Secp256r1KeyType},
+		NewKey: func(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+			key, err := fdotest.NewKey(keyType)
+			return key, nil, err
+		},
+		TO0:    &to0,
+		RvURLs: []string{"http://rv.fidoalliance.org"},
+	}
+	ctx := context.Background()
+
+	// A device whose voucher is still owned by the previous key onboards
+	stale := server.NewDevice(t, protocol.Secp256r1KeyType)
+	extended := server.NewDevice(t, protocol.Secp256r1KeyType)
+	busy := server.NewDevice(t, protocol.Secp256r1KeyType)
+	previous, _, err := server.State.OwnerKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.State.RotateOwnerKey(ctx, protocol.Secp256r1KeyType, next, nil); err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterBlob(t, stale.Cred.GUID)
+	if err := server.Onboard(t, stale, nil); err != nil {
+		t.Fatalf("expected device with voucher owned by previous key to onboard: %v", err)
+	}
+
+	// Rotating again while a device is onboarding extends the remaining
+	// vouchers to the current key and registers them again, except for the
+	// voucher of the onboarding device
+	server.RegisterBlob(t, extended.Cred.GUID)
+	server.RegisterBlob(t, busy.Cred.GUID)
+	busyGUID := busy.Cred.GUID
+	var busyOwner crypto.PublicKey
+	server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			yield("rotate", &fdotest.MockOwnerModule{
+				ProduceInfoFunc: func(ctx context.Context, _ *serviceinfo.Producer) (bool, bool, error) {
+					if err := rotator.Rotate(ctx, protocol.Secp256r1KeyType); err != nil {
+						return false, false, err
+					}
+					ov, err := server.State.Voucher(ctx, busyGUID)
+					if err != nil {
+						return false, false, err
+					}
+					busyOwner, err = ov.OwnerPublicKey()
+					return false, true, err
+				},
+			})
+		}
+	}
+	if err := server.Onboard(t, busy, nil); err != nil {
+		t.Fatalf("expected device to onboard while owner key is rotated: %v", err)
+	}
+	server.TO2.OwnerModules = func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {}
+	}
+	if !previous.Public().(*ecdsa.PublicKey).Equal(busyOwner) {
+		t.Fatal("expected voucher of device in TO2 to not be extended")
+	}
+	current, _, err := server.State.OwnerKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := server.Voucher(t, extended.Cred.GUID).OwnerPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !current.Public().(*ecdsa.PublicKey).Equal(owner) {
+		t.Fatal("expected voucher to be extended to the current owner key")
+	}
+	if n, err := rotator.ExtendVouchers(ctx); err != nil || n != 0 {
+		t.Fatalf("expected no vouchers left to extend, got %d: %v", n, err)
+	}
+
+	// Previous keys are only retired once they no longer own any voucher
+	rotator.RetireAfter = time.Nanosecond
+	last, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.State.RotateOwnerKey(ctx, protocol.Secp256r1KeyType, last, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotator.Retire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fdo.OwnerKeyFor(ctx, server.State, protocol.Secp256r1KeyType, previous.Public()); !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("expected retired owner key to not be found, got %v", err)
+	}
+	if _, _, err := fdo.OwnerKeyFor(ctx, server.State, protocol.Secp256r1KeyType, current.Public()); err != nil {
+		t.Fatalf("expected owner key of stored vouchers to not be retired: %v", err)
+	}
+	if n, err := rotator.ExtendVouchers(ctx); err != nil || n == 0 {
+		t.Fatalf("expected vouchers to be extended, got %d: %v", n, err)
+	}
+	if err := rotator.Retire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fdo.OwnerKeyFor(ctx, server.State, protocol.Secp256r1KeyType, current.Public()); !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("expected owner key to be retired once its vouchers are extended, got %v", err)
+	}
+
+	// Once previous keys are retired, the extended voucher still onboards
+	if err := server.Onboard(t, extended, nil); err != nil {
+		t.Fatalf("expected device with extended voucher to onboard: %v", err)
+	}
+}
+
+// countingNonceStore counts nonces added and consumed, optionally forgetting
+// nonces as if they were added to the store of another replica.
+type countingNonceStore struct {
+	fdo.NonceStore
+	forget          bool
+	added, consumed int
+	mu              sync.Mutex
+}
+
+func (s *countingNonceStore) AddNonce(ctx context.Context, nonce protocol.Nonce, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added++
+	if s.forget {
+		return nil
+	}
+	return s.NonceStore.AddNonce(ctx, nonce, expires)
+}
+
+func (s *countingNonceStore) ConsumeNonce(ctx context.Context, nonce protocol.Nonce) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.NonceStore.ConsumeNonce(ctx, nonce); err != nil {
+		return err
+	}
+	s.consumed++
+	return nil
+}
+
+func TestServerNonceStore(t *testing.T) {
+	server := fdotest.NewServer(t)
+	nonces := &countingNonceStore{NonceStore: server.State}
+	server.TO0.Nonces = nonces
+	server.TO1.Nonces = nonces
+	server.TO2.Nonces = nonces
+
+	// Each nonce of TO0, TO1, and TO2 is added and consumed once
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+	if nonces.added != 3 || nonces.consumed != 3 {
+		t.Fatalf("expected 3 nonces added and consumed, got %d added and %d consumed", nonces.added, nonces.consumed)
+	}
+
+	// Nonces unknown to the store are rejected
+	nonces.forget = true
+	dev = server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.TO0.Nonces = nil
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, nil); err == nil {
+		t.Fatal("expected onboarding to fail with nonces unknown to the store")
+	}
+}
+
 func TestServerState(t *testing.T) {
 	fdotest.RunServerStateSuite(t, nil)
 }
+
+// closingOwnerModule is an owner module which implements io.Closer.
+type closingOwnerModule struct {
+	fdotest.MockOwnerModule
+	close func()
+}
+
+func (m *closingOwnerModule) Close() error {
+	m.close()
+	return nil
+}
@@ -6,11 +6,8 @@ package fdotest
 import (
 	"context"
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
@@ -59,9 +56,40 @@ type Config struct {
 	// Use the Credential Reuse Protocol
 	Reuse bool
 
+	// Limit the number of concurrent TO2 sessions of the owner service. A
+	// limit of one checks that each session is released when it ends.
+	MaxTO2Sessions int
+
+	// ServiceInfoLimits are applied to both the device and owner service.
+	ServiceInfoLimits fdo.ServiceInfoLimits
+
+	// Compression algorithms supported by both the device and owner service.
+	Compression []fdo.ServiceInfoCompression
+
+	// Interleave service info of owner modules.
+	InterleaveModules bool
+
+	// ModuleTimeouts are applied to both the device and owner service.
+	ModuleTimeouts fdo.ModuleTimeouts
+
+	// Encrypt messages and pass them to servers with a loopback.Transport
+	// rather than directly calling responders.
+	Encrypt bool
+
+	// Hooks are passed to the device for TO1 and TO2.
+	Hooks fdo.ClientHooks
+
+	// If Rand is non-nil, then it will be used as the source of randomness for
+	// nonces, GUIDs, and key exchange parameters by both client and servers.
+	Rand io.Reader
+
 	DeviceModules map[string]serviceinfo.DeviceModule
 	OwnerModules  OwnerModulesFunc
 
+	// DeviceModuleInfo optionally describes the capabilities of
+	// DeviceModules, by module name.
+	DeviceModuleInfo map[string]serviceinfo.ModuleInfo
+
 	CustomExpect func(*testing.T, error)
 }
 
@@ -70,6 +98,8 @@ type Config struct {
 //
 //nolint:gocyclo
 func RunClientTestSuite(t *testing.T, conf Config) {
+	prevLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prevLogger) })
 	slog.SetDefault(slog.New(slog.NewTextHandler(TestingLog(t), &slog.HandlerOptions{Level: slog.LevelDebug})))
 
 	if conf.State == nil {
@@ -90,51 +120,14 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 	}
 
 	transport := &Transport{
-		Tokens: conf.State,
+		Tokens:  conf.State,
+		Encrypt: conf.Encrypt,
 		DIResponder: &fdo.DIServer[custom.DeviceMfgInfo]{
-			Session:  conf.State,
-			Vouchers: conf.State,
-			SignDeviceCertificate: func(info *custom.DeviceMfgInfo) ([]*x509.Certificate, error) {
-				// Validate device info
-				csr := x509.CertificateRequest(info.CertInfo)
-				if err := csr.CheckSignature(); err != nil {
-					return nil, fmt.Errorf("invalid CSR: %w", err)
-				}
-
-				// Sign CSR
-				key, chain, err := conf.State.ManufacturerKey(info.KeyType)
-				if err != nil {
-					var unsupportedErr fdo.ErrUnsupportedKeyType
-					if errors.As(err, &unsupportedErr) {
-						return nil, unsupportedErr
-					}
-					return nil, fmt.Errorf("error retrieving manufacturer key [type=%s]: %w", info.KeyType, err)
-				}
-				serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-				serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-				if err != nil {
-					return nil, fmt.Errorf("error generating certificate serial number: %w", err)
-				}
-				template := &x509.Certificate{
-					SerialNumber: serialNumber,
-					Issuer:       chain[0].Subject,
-					Subject:      csr.Subject,
-					NotBefore:    time.Now(),
-					NotAfter:     time.Now().Add(30 * 360 * 24 * time.Hour), // Matches Java impl
-					KeyUsage:     x509.KeyUsageDigitalSignature,
-				}
-				der, err := x509.CreateCertificate(rand.Reader, template, chain[0], csr.PublicKey, key)
-				if err != nil {
-					return nil, fmt.Errorf("error signing CSR: %w", err)
-				}
-				cert, err := x509.ParseCertificate(der)
-				if err != nil {
-					return nil, fmt.Errorf("error parsing signed device cert: %w", err)
-				}
-				chain = append([]*x509.Certificate{cert}, chain...)
-				return chain, nil
-			},
-			AutoExtend: conf.State,
+			Session:               conf.State,
+			Vouchers:              conf.State,
+			Rand:                  conf.Rand,
+			SignDeviceCertificate: signDeviceCertificate(conf.State),
+			AutoExtend:            conf.State,
 			RvInfo: func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) {
 				return [][]protocol.RvInstruction{}, nil
 			},
@@ -142,15 +135,24 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 		TO0Responder: &fdo.TO0Server{
 			Session: conf.State,
 			RVBlobs: conf.State,
+			Rand:    conf.Rand,
 		},
 		TO1Responder: &fdo.TO1Server{
 			Session: conf.State,
 			RVBlobs: conf.State,
+			Rand:    conf.Rand,
 		},
 		TO2Responder: &fdo.TO2Server{
-			Session:   conf.State,
-			Vouchers:  conf.State,
-			OwnerKeys: conf.State,
+			Session:           conf.State,
+			Vouchers:          conf.State,
+			OwnerKeys:         conf.State,
+			ModuleState:       conf.State,
+			Rand:              conf.Rand,
+			MaxSessions:       conf.MaxTO2Sessions,
+			InterleaveModules: conf.InterleaveModules,
+			ServiceInfoLimits: conf.ServiceInfoLimits,
+			ModuleTimeouts:    conf.ModuleTimeouts,
+			Compression:       conf.Compression,
 			RvInfo: func(context.Context, fdo.Voucher) ([][]protocol.RvInstruction, error) {
 				return [][]protocol.RvInstruction{}, nil
 			},
@@ -205,12 +207,36 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 			keyExchange: kex.ASYMKEX2048Suite,
 			cipherSuite: kex.A128GcmCipher,
 		},
+		{
+			keyType:     protocol.Rsa2048RestrKeyType,
+			keyEncoding: protocol.X5ChainKeyEnc,
+			keyExchange: kex.DHKEXid14Suite,
+			cipherSuite: kex.A128GcmCipher,
+		},
+		{
+			keyType:     protocol.RsaPkcsKeyType,
+			keyEncoding: protocol.X5ChainKeyEnc,
+			keyExchange: kex.ASYMKEX3072Suite,
+			cipherSuite: kex.A256GcmCipher,
+		},
 		{
 			keyType:     protocol.RsaPssKeyType,
 			keyEncoding: protocol.X509KeyEnc,
 			keyExchange: kex.DHKEXid15Suite,
 			cipherSuite: kex.A128GcmCipher,
 		},
+		{
+			keyType:     protocol.RsaPssKeyType,
+			keyEncoding: protocol.CoseKeyEnc,
+			keyExchange: kex.ASYMKEX3072Suite,
+			cipherSuite: kex.A256GcmCipher,
+		},
+		{
+			keyType:     protocol.Secp384r1KeyType,
+			keyEncoding: protocol.CryptoKeyEnc,
+			keyExchange: kex.ECDH384Suite,
+			cipherSuite: kex.A256GcmCipher,
+		},
 	} {
 		t.Run(fmt.Sprintf("Key %q Encoding %q Exchange %q Cipher %q", table.keyType, table.keyEncoding, table.keyExchange, table.cipherSuite), func(t *testing.T) {
 			transport.DIResponder.DeviceInfo = func(context.Context, *custom.DeviceMfgInfo, []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error) {
@@ -226,20 +252,7 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 				hmacSha384 = hmac.New(sha512.New384, secret)
 
 				var err error
-				switch table.keyType {
-				case protocol.Secp256r1KeyType:
-					key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-				case protocol.Secp384r1KeyType:
-					key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
-				case protocol.Rsa2048RestrKeyType:
-					key, err = rsa.GenerateKey(rand.Reader, 2048)
-				case protocol.RsaPkcsKeyType:
-					key, err = rsa.GenerateKey(rand.Reader, 3072)
-				case protocol.RsaPssKeyType:
-					key, err = rsa.GenerateKey(rand.Reader, 3072)
-				default:
-					t.Fatalf("unsupported key type: %s", table.keyType)
-				}
+				key, err = NewKey(table.keyType)
 				if err != nil {
 					t.Fatalf("error generating device key: %v", err)
 				}
@@ -323,7 +336,8 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 				ctx, cancel := context.WithTimeout(context.Background(), timeout)
 				defer cancel()
 				if _, err := fdo.TO1(ctx, transport, *cred, key, &fdo.TO1Options{
-					PSS: table.keyType == protocol.RsaPssKeyType,
+					PSS:   table.keyType == protocol.RsaPssKeyType,
+					Hooks: conf.Hooks,
 				}); !strings.HasSuffix(err.Error(), fdo.ErrNotFound.Error()) {
 					t.Fatalf("expected TO1 to fail with no resource found, got %v", err)
 				}
@@ -349,7 +363,8 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 				ctx, cancel := context.WithTimeout(context.Background(), timeout)
 				defer cancel()
 				to1d, err := fdo.TO1(ctx, transport, *cred, key, &fdo.TO1Options{
-					PSS: table.keyType == protocol.RsaPssKeyType,
+					PSS:   table.keyType == protocol.RsaPssKeyType,
+					Hooks: conf.Hooks,
 				})
 				if err != nil {
 					t.Fatal(err)
@@ -373,6 +388,11 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 					KeyExchange:          table.keyExchange,
 					CipherSuite:          table.cipherSuite,
 					AllowCredentialReuse: conf.Reuse,
+					Rand:                 conf.Rand,
+					Hooks:                conf.Hooks,
+					ServiceInfoLimits:    conf.ServiceInfoLimits,
+					ModuleTimeouts:       conf.ModuleTimeouts,
+					Compression:          conf.Compression,
 				})
 				if err != nil {
 					t.Fatal(err)
@@ -380,6 +400,36 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 				t.Logf("New credential: %s", toDeviceCred(*cred))
 			})
 
+			t.Run("Transfer Ownership 2 Verify Only", func(t *testing.T) {
+				if cred == nil {
+					t.Fatal("cred not set due to previous failure")
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				newCred, err := fdo.TO2(ctx, transport, nil, fdo.TO2Config{
+					Cred:              *cred,
+					HmacSha256:        hmacSha256,
+					HmacSha384:        hmacSha384,
+					Key:               key,
+					PSS:               table.keyType == protocol.RsaPssKeyType,
+					KeyExchange:       table.keyExchange,
+					CipherSuite:       table.cipherSuite,
+					VerifyOnly:        true,
+					Rand:              conf.Rand,
+					Hooks:             conf.Hooks,
+					ServiceInfoLimits: conf.ServiceInfoLimits,
+					ModuleTimeouts:    conf.ModuleTimeouts,
+					Compression:       conf.Compression,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if newCred != nil {
+					t.Fatal("expected no replacement credential in verify only mode")
+				}
+			})
+
 			t.Run("Transfer Ownership 2 Only", func(t *testing.T) {
 				if cred == nil {
 					t.Fatal("cred not set due to previous failure")
@@ -405,6 +455,11 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 					KeyExchange:          table.keyExchange,
 					CipherSuite:          table.cipherSuite,
 					AllowCredentialReuse: conf.Reuse,
+					Rand:                 conf.Rand,
+					Hooks:                conf.Hooks,
+					ServiceInfoLimits:    conf.ServiceInfoLimits,
+					ModuleTimeouts:       conf.ModuleTimeouts,
+					Compression:          conf.Compression,
 				})
 				if err != nil {
 					t.Fatal(err)
@@ -433,10 +488,15 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 						FileSep: ";",
 						Bin:     runtime.GOARCH,
 					},
-					DeviceModules:        conf.DeviceModules,
+					DeviceModules:        conf.deviceModules(t),
 					KeyExchange:          table.keyExchange,
 					CipherSuite:          table.cipherSuite,
 					AllowCredentialReuse: conf.Reuse,
+					Rand:                 conf.Rand,
+					Hooks:                conf.Hooks,
+					ServiceInfoLimits:    conf.ServiceInfoLimits,
+					ModuleTimeouts:       conf.ModuleTimeouts,
+					Compression:          conf.Compression,
 				})
 				if conf.CustomExpect != nil {
 					conf.CustomExpect(t, err)
@@ -452,3 +512,62 @@ func RunClientTestSuite(t *testing.T, conf Config) {
 		})
 	}
 }
+
+// signDeviceCertificate returns a DIServer.SignDeviceCertificate function which
+// signs device CSRs with the manufacturer key of the state.
+func signDeviceCertificate(state AllServerState) func(*custom.DeviceMfgInfo) ([]*x509.Certificate, error) {
+	return func(info *custom.DeviceMfgInfo) ([]*x509.Certificate, error) {
+		// Validate device info
+		if info == nil {
+			return nil, fmt.Errorf("missing device info")
+		}
+		csr := x509.CertificateRequest(info.CertInfo)
+		if err := csr.CheckSignature(); err != nil {
+			return nil, fmt.Errorf("invalid CSR: %w", err)
+		}
+
+		// Sign CSR
+		key, chain, err := state.ManufacturerKey(info.KeyType)
+		if err != nil {
+			var unsupportedErr fdo.ErrUnsupportedKeyType
+			if errors.As(err, &unsupportedErr) {
+				return nil, unsupportedErr
+			}
+			return nil, fmt.Errorf("error retrieving manufacturer key [type=%s]: %w", info.KeyType, err)
+		}
+		serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+		serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+		if err != nil {
+			return nil, fmt.Errorf("error generating certificate serial number: %w", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: serialNumber,
+			Issuer:       chain[0].Subject,
+			Subject:      csr.Subject,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(30 * 360 * 24 * time.Hour), // Matches Java impl
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, chain[0], csr.PublicKey, key)
+		if err != nil {
+			return nil, fmt.Errorf("error signing CSR: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing signed device cert: %w", err)
+		}
+		chain = append([]*x509.Certificate{cert}, chain...)
+		return chain, nil
+	}
+}
+
+// deviceModules returns a registry of the device modules of the config.
+func (conf Config) deviceModules(t *testing.T) *serviceinfo.Registry {
+	registry := new(serviceinfo.Registry)
+	for name, module := range conf.DeviceModules {
+		if err := registry.Register(name, module, conf.DeviceModuleInfo[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return registry
+}
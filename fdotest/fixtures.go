@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdotest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"hash"
+	"math/big"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// NewKey generates a device, manufacturer, or owner key of the given type.
+func NewKey(keyType protocol.KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case protocol.Secp256r1KeyType:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case protocol.Secp384r1KeyType:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case protocol.Rsa2048RestrKeyType:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// FixtureOptions configures NewFixture.
+type FixtureOptions struct {
+	// KeyType of the device, manufacturer, and owner keys. It defaults to
+	// Secp256r1KeyType.
+	KeyType protocol.KeyType
+
+	// KeyEncoding of the manufacturer and owner public keys. It defaults to
+	// X509KeyEnc.
+	KeyEncoding protocol.KeyEncoding
+
+	// Entries is the number of entries in the voucher, each transferring
+	// ownership to a newly generated owner key.
+	Entries int
+
+	DeviceInfo string
+	RvInfo     [][]protocol.RvInstruction
+}
+
+// Fixture is a device credential, its ownership voucher, and all matching
+// keys, created without running DI.
+type Fixture struct {
+	Cred       fdo.DeviceCredential
+	HmacSecret []byte
+	DeviceKey  crypto.Signer
+
+	Voucher         *fdo.Voucher
+	ManufacturerKey crypto.Signer
+
+	// OwnerKeys are the keys of each voucher entry, in order. The last key is
+	// the current owner key, or the manufacturer key if there are no entries.
+	OwnerKeys []crypto.Signer
+}
+
+// HmacSha256 returns an HMAC-SHA256 using the device secret.
+func (f *Fixture) HmacSha256() hash.Hash { return hmac.New(sha256.New, f.HmacSecret) }
+
+// HmacSha384 returns an HMAC-SHA384 using the device secret.
+func (f *Fixture) HmacSha384() hash.Hash { return hmac.New(sha512.New384, f.HmacSecret) }
+
+// OwnerKey returns the current owner key.
+func (f *Fixture) OwnerKey() crypto.Signer {
+	if len(f.OwnerKeys) == 0 {
+		return f.ManufacturerKey
+	}
+	return f.OwnerKeys[len(f.OwnerKeys)-1]
+}
+
+// NewFixture creates a valid device credential and ownership voucher with a
+// new device key, manufacturer key, and owner key for each voucher entry.
+//
+//nolint:gocyclo
+func NewFixture(opts FixtureOptions) (*Fixture, error) {
+	if opts.KeyType == 0 {
+		opts.KeyType = protocol.Secp256r1KeyType
+	}
+	if opts.KeyEncoding == 0 {
+		opts.KeyEncoding = protocol.X509KeyEnc
+	}
+	if opts.RvInfo == nil {
+		opts.RvInfo = [][]protocol.RvInstruction{}
+	}
+
+	// Generate keys and device certificate chain
+	mfgKey, err := NewKey(opts.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("error generating manufacturer key: %w", err)
+	}
+	deviceKey, err := NewKey(opts.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("error generating device key: %w", err)
+	}
+	mfgCert, err := newFixtureCert(nil, mfgKey, mfgKey.Public(), "Manufacturer CA")
+	if err != nil {
+		return nil, fmt.Errorf("error creating manufacturer certificate: %w", err)
+	}
+	deviceCert, err := newFixtureCert(mfgCert, mfgKey, deviceKey.Public(), "device.go-fdo")
+	if err != nil {
+		return nil, fmt.Errorf("error creating device certificate: %w", err)
+	}
+	chain := []*x509.Certificate{deviceCert, mfgCert}
+
+	// Select hash algorithms by key size
+	alg, hmacAlg, newHmac := protocol.Sha256Hash, protocol.HmacSha256Hash, sha256.New
+	switch opts.KeyType {
+	case protocol.Secp384r1KeyType, protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+		alg, hmacAlg, newHmac = protocol.Sha384Hash, protocol.HmacSha384Hash, sha512.New384
+	}
+
+	// Create voucher header
+	var mfgPubKey *protocol.PublicKey
+	switch opts.KeyEncoding {
+	case protocol.X5ChainKeyEnc:
+		mfgPubKey, err = protocol.NewPublicKey(opts.KeyType, chain[1:], false)
+	case protocol.X509KeyEnc, protocol.CoseKeyEnc:
+		mfgPubKey, err = newPublicKey(opts.KeyType, mfgKey.Public(), opts.KeyEncoding == protocol.CoseKeyEnc)
+	default:
+		err = fmt.Errorf("unsupported key encoding: %s", opts.KeyEncoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manufacturer public key: %w", err)
+	}
+	certChain := make([]*cbor.X509Certificate, len(chain))
+	certChainHash := alg.HashFunc().New()
+	for i, cert := range chain {
+		certChain[i] = (*cbor.X509Certificate)(cert)
+		_, _ = certChainHash.Write(cert.Raw)
+	}
+	var guid protocol.GUID
+	if _, err := rand.Read(guid[:]); err != nil {
+		return nil, fmt.Errorf("error generating device GUID: %w", err)
+	}
+	ovh := fdo.VoucherHeader{
+		Version:         101,
+		GUID:            guid,
+		RvInfo:          opts.RvInfo,
+		DeviceInfo:      opts.DeviceInfo,
+		ManufacturerKey: *mfgPubKey,
+		CertChainHash:   &protocol.Hash{Algorithm: alg, Value: certChainHash.Sum(nil)},
+	}
+
+	// Compute header HMAC with a new device secret
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating device secret: %w", err)
+	}
+	mac := hmac.New(newHmac, secret)
+	if err := cbor.NewEncoder(mac).Encode(&ovh); err != nil {
+		return nil, fmt.Errorf("error computing voucher header HMAC: %w", err)
+	}
+	ov := &fdo.Voucher{
+		Version:   101,
+		Header:    *cbor.NewBstr(ovh),
+		Hmac:      protocol.Hmac{Algorithm: hmacAlg, Value: mac.Sum(nil)},
+		CertChain: &certChain,
+	}
+
+	// Extend voucher to new owners
+	var ownerKeys []crypto.Signer
+	owner := mfgKey
+	for range opts.Entries {
+		nextOwner, err := NewKey(opts.KeyType)
+		if err != nil {
+			return nil, fmt.Errorf("error generating owner key: %w", err)
+		}
+		switch pub := nextOwner.Public().(type) {
+		case *ecdsa.PublicKey:
+			ov, err = fdo.ExtendVoucher(ov, owner, pub, nil)
+		case *rsa.PublicKey:
+			ov, err = fdo.ExtendVoucher(ov, owner, pub, nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error extending voucher to entry %d: %w", len(ownerKeys), err)
+		}
+		ownerKeys = append(ownerKeys, nextOwner)
+		owner = nextOwner
+	}
+
+	// Hash manufacturer public key for the credential
+	pkDigest := alg.HashFunc().New()
+	if err := cbor.NewEncoder(pkDigest).Encode(mfgPubKey); err != nil {
+		return nil, fmt.Errorf("error computing hash of manufacturer public key: %w", err)
+	}
+
+	return &Fixture{
+		Cred: fdo.DeviceCredential{
+			Version:       ovh.Version,
+			DeviceInfo:    ovh.DeviceInfo,
+			GUID:          ovh.GUID,
+			RvInfo:        ovh.RvInfo,
+			PublicKeyHash: protocol.Hash{Algorithm: alg, Value: pkDigest.Sum(nil)},
+		},
+		HmacSecret:      secret,
+		DeviceKey:       deviceKey,
+		Voucher:         ov,
+		ManufacturerKey: mfgKey,
+		OwnerKeys:       ownerKeys,
+	}, nil
+}
+
+func newPublicKey(keyType protocol.KeyType, pub crypto.PublicKey, asCOSE bool) (*protocol.PublicKey, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return protocol.NewPublicKey(keyType, pub, asCOSE)
+	case *rsa.PublicKey:
+		return protocol.NewPublicKey(keyType, pub, asCOSE)
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+}
+
+// newFixtureCert creates a certificate for the public key, self-signed if
+// issuer is nil.
+func newFixtureCert(issuer *x509.Certificate, issuerKey crypto.Signer, pub crypto.PublicKey, commonName string) (*x509.Certificate, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(30 * 360 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: issuer == nil,
+		IsCA:                  issuer == nil,
+	}
+	if issuer == nil {
+		template.KeyUsage |= x509.KeyUsageCertSign
+		issuer = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, pub, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
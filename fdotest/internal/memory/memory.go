@@ -7,6 +7,7 @@
 package memory
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
@@ -15,10 +16,15 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
+	"maps"
 	"math/big"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
@@ -27,25 +33,81 @@ import (
 // protocol sessions, but not between server processes.
 type State struct {
 	RVBlobs   map[protocol.GUID]*cose.Sign1[protocol.To1d, []byte]
+	RVBlobExp map[protocol.GUID]time.Time
+	RVBlobOVs map[protocol.GUID]*fdo.Voucher
 	Vouchers  map[protocol.GUID]*fdo.Voucher
 	OwnerKeys map[protocol.KeyType]struct {
 		Key   crypto.Signer
 		Chain []*x509.Certificate
 	}
+	ModuleStates map[protocol.GUID]map[string][]byte
+
+	// All maps are guarded by a mutex, because vouchers and module states
+	// are used by concurrent TO2 sessions, TO0Regs may be set
+	// concurrently by TO0Client.RegisterAll, History by concurrent TO2
+	// sessions, and owner keys by OwnerKeyRotator. Nonces are added and
+	// consumed by concurrent sessions, and device statuses and traces are
+	// updated by every server. HelloDeviceRecords are added by concurrent TO2
+	// sessions. Voucher leases are taken by TO2 sessions while vouchers are
+	// replaced by OwnerKeyRotator.
+	RotatedOwnerKeys        map[protocol.KeyType][]fdo.PreviousOwnerKey
+	NamedManufacturerKeys   map[protocol.KeyType][]fdo.ManufacturerKey
+	VoucherManufacturerKeys map[protocol.GUID]string
+	VoucherMetadatas        map[protocol.GUID]map[string]string
+	TO0Regs                 map[protocol.GUID]map[string]fdo.TO0Registration
+	History                 map[protocol.GUID][]fdo.OnboardingEvent
+	Denied                  map[protocol.GUID]bool
+	Devmods                 map[protocol.GUID]fdo.DeviceDevmod
+	Nonces                  map[protocol.Nonce]time.Time
+	DeviceStatuses          map[protocol.GUID]fdo.DeviceStatus
+	Traces                  []protocol.TraceEvent
+	HelloDeviceRecords      []fdo.HelloDeviceRecord
+	VoucherLeases           map[protocol.GUID]time.Time
+	mu                      sync.Mutex
 }
 
 var _ fdo.RendezvousBlobPersistentState = (*State)(nil)
+var _ fdo.RendezvousBlobAdminState = (*State)(nil)
 var _ fdo.ManufacturerVoucherPersistentState = (*State)(nil)
 var _ fdo.OwnerVoucherPersistentState = (*State)(nil)
 var _ fdo.OwnerKeyPersistentState = (*State)(nil)
+var _ fdo.ModuleStatePersistentState = (*State)(nil)
+var _ fdo.TO0RegistrationPersistentState = (*State)(nil)
+var _ fdo.OnboardingHistoryPersistentState = (*State)(nil)
+var _ fdo.DeviceDenyListPersistentState = (*State)(nil)
+var _ fdo.DevmodPersistentState = (*State)(nil)
+var _ fdo.DeviceStatusPersistentState = (*State)(nil)
+var _ fdo.ProtocolTracePersistentState = (*State)(nil)
+var _ fdo.HelloDevicePersistentState = (*State)(nil)
+var _ fdo.OwnerKeyRotationPersistentState = (*State)(nil)
+var _ fdo.OwnerVoucherListPersistentState = (*State)(nil)
+var _ fdo.ManufacturerKeysPersistentState = (*State)(nil)
+var _ fdo.VoucherManufacturerKeyPersistentState = (*State)(nil)
+var _ fdo.VoucherMetadataPersistentState = (*State)(nil)
+var _ fdo.NonceStore = (*State)(nil)
+var _ fdo.OwnerVoucherLeasePersistentState = (*State)(nil)
+
+// sharedRSA3072Key is shared by all states, because generating 3072-bit RSA
+// keys is slow enough to noticeably affect tests.
+var sharedRSA3072Key = sync.OnceValues(func() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 3072)
+})
 
 // NewState initializes the in-memory state.
 func NewState() (*State, error) {
-	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	rsa2048Key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	rsa2048Cert, err := newCA(rsa2048Key)
 	if err != nil {
 		return nil, err
 	}
-	rsaCert, err := newCA(rsaKey)
+	rsa3072Key, err := sharedRSA3072Key()
+	if err != nil {
+		return nil, err
+	}
+	rsa3072Cert, err := newCA(rsa3072Key)
 	if err != nil {
 		return nil, err
 	}
@@ -66,15 +128,30 @@ func NewState() (*State, error) {
 		return nil, err
 	}
 	return &State{
-		RVBlobs:  make(map[protocol.GUID]*cose.Sign1[protocol.To1d, []byte]),
-		Vouchers: make(map[protocol.GUID]*fdo.Voucher),
+		RVBlobs:      make(map[protocol.GUID]*cose.Sign1[protocol.To1d, []byte]),
+		RVBlobExp:    make(map[protocol.GUID]time.Time),
+		RVBlobOVs:    make(map[protocol.GUID]*fdo.Voucher),
+		Vouchers:     make(map[protocol.GUID]*fdo.Voucher),
+		ModuleStates: make(map[protocol.GUID]map[string][]byte),
+
+		RotatedOwnerKeys:        make(map[protocol.KeyType][]fdo.PreviousOwnerKey),
+		NamedManufacturerKeys:   make(map[protocol.KeyType][]fdo.ManufacturerKey),
+		VoucherManufacturerKeys: make(map[protocol.GUID]string),
+		VoucherMetadatas:        make(map[protocol.GUID]map[string]string),
+		TO0Regs:                 make(map[protocol.GUID]map[string]fdo.TO0Registration),
+		History:                 make(map[protocol.GUID][]fdo.OnboardingEvent),
+		Denied:                  make(map[protocol.GUID]bool),
+		Devmods:                 make(map[protocol.GUID]fdo.DeviceDevmod),
+		Nonces:                  make(map[protocol.Nonce]time.Time),
+		DeviceStatuses:          make(map[protocol.GUID]fdo.DeviceStatus),
+		VoucherLeases:           make(map[protocol.GUID]time.Time),
 		OwnerKeys: map[protocol.KeyType]struct {
 			Key   crypto.Signer
 			Chain []*x509.Certificate
 		}{
-			protocol.Rsa2048RestrKeyType: {Key: rsaKey, Chain: []*x509.Certificate{rsaCert}},
-			protocol.RsaPkcsKeyType:      {Key: rsaKey, Chain: []*x509.Certificate{rsaCert}},
-			protocol.RsaPssKeyType:       {Key: rsaKey, Chain: []*x509.Certificate{rsaCert}},
+			protocol.Rsa2048RestrKeyType: {Key: rsa2048Key, Chain: []*x509.Certificate{rsa2048Cert}},
+			protocol.RsaPkcsKeyType:      {Key: rsa3072Key, Chain: []*x509.Certificate{rsa3072Cert}},
+			protocol.RsaPssKeyType:       {Key: rsa3072Key, Chain: []*x509.Certificate{rsa3072Cert}},
 			protocol.Secp256r1KeyType:    {Key: ec256Key, Chain: []*x509.Certificate{ec256Cert}},
 			protocol.Secp384r1KeyType:    {Key: ec384Key, Chain: []*x509.Certificate{ec384Cert}},
 		},
@@ -85,12 +162,16 @@ func NewState() (*State, error) {
 // Note that the voucher may have entries if the server was configured for
 // auto voucher extension.
 func (s *State) NewVoucher(_ context.Context, ov *fdo.Voucher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Vouchers[ov.Header.Val.GUID] = ov
 	return nil
 }
 
 // AddVoucher stores the voucher of a device owned by the service.
 func (s *State) AddVoucher(_ context.Context, ov *fdo.Voucher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Vouchers[ov.Header.Val.GUID] = ov
 	return nil
 }
@@ -98,6 +179,8 @@ func (s *State) AddVoucher(_ context.Context, ov *fdo.Voucher) error {
 // ReplaceVoucher stores a new voucher, possibly deleting or marking the
 // previous voucher as replaced.
 func (s *State) ReplaceVoucher(_ context.Context, oldGUID protocol.GUID, ov *fdo.Voucher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.Vouchers, oldGUID)
 	s.Vouchers[ov.Header.Val.GUID] = ov
 	return nil
@@ -106,6 +189,8 @@ func (s *State) ReplaceVoucher(_ context.Context, oldGUID protocol.GUID, ov *fdo
 // RemoveVoucher untracks a voucher, possibly by deleting it or marking it
 // as removed, and returns it for extension.
 func (s *State) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	ov, ok := s.Vouchers[guid]
 	if !ok {
 		return nil, fdo.ErrNotFound
@@ -116,6 +201,8 @@ func (s *State) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Vou
 
 // Voucher retrieves a voucher by GUID.
 func (s *State) Voucher(_ context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	ov, ok := s.Vouchers[guid]
 	if !ok {
 		return nil, fdo.ErrNotFound
@@ -126,6 +213,8 @@ func (s *State) Voucher(_ context.Context, guid protocol.GUID) (*fdo.Voucher, er
 // OwnerKey returns the private key matching a given key type and optionally
 // its certificate chain.
 func (s *State) OwnerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	key, ok := s.OwnerKeys[keyType]
 	if !ok {
 		return nil, nil, fdo.ErrNotFound
@@ -133,6 +222,314 @@ func (s *State) OwnerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certi
 	return key.Key, key.Chain, nil
 }
 
+// RotateOwnerKey makes a key the current key of its type, keeping any
+// previous current key as a previous key.
+func (s *State) RotateOwnerKey(_ context.Context, keyType protocol.KeyType, key crypto.Signer, chain []*x509.Certificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.OwnerKeys[keyType]; ok {
+		s.RotatedOwnerKeys[keyType] = slices.Insert(s.RotatedOwnerKeys[keyType], 0, fdo.PreviousOwnerKey{
+			Key:     current.Key,
+			Chain:   current.Chain,
+			Rotated: time.Now(),
+		})
+	}
+	s.OwnerKeys[keyType] = struct {
+		Key   crypto.Signer
+		Chain []*x509.Certificate
+	}{Key: key, Chain: chain}
+	return nil
+}
+
+// PreviousOwnerKeys returns the previous keys of a type, most recently
+// rotated first.
+func (s *State) PreviousOwnerKeys(_ context.Context, keyType protocol.KeyType) ([]fdo.PreviousOwnerKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.RotatedOwnerKeys[keyType]), nil
+}
+
+// RemovePreviousOwnerKeys deletes the previous keys of a type which were
+// rotated before the given time.
+func (s *State) RemovePreviousOwnerKeys(_ context.Context, keyType protocol.KeyType, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RotatedOwnerKeys[keyType] = slices.DeleteFunc(s.RotatedOwnerKeys[keyType], func(key fdo.PreviousOwnerKey) bool {
+		return key.Rotated.Before(before)
+	})
+	return nil
+}
+
+// VoucherGUIDs returns the GUIDs of all vouchers which have been extended.
+func (s *State) VoucherGUIDs(context.Context) ([]protocol.GUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var guids []protocol.GUID
+	for guid, ov := range s.Vouchers {
+		if len(ov.Entries) > 0 {
+			guids = append(guids, guid)
+		}
+	}
+	return guids, nil
+}
+
+// LeaseVoucher marks the voucher of a device in use until the given time,
+// replacing any previous lease.
+func (s *State) LeaseVoucher(_ context.Context, guid protocol.GUID, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.VoucherLeases[guid] = until
+	return nil
+}
+
+// ReleaseVoucher ends the lease of the voucher of a device, if any.
+func (s *State) ReleaseVoucher(_ context.Context, guid protocol.GUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.VoucherLeases, guid)
+	return nil
+}
+
+// ReplaceUnleasedVoucher atomically replaces the voucher of a device with
+// next, only if it has no unexpired lease and is still equal to prev.
+func (s *State) ReplaceUnleasedVoucher(_ context.Context, guid protocol.GUID, prev, next *fdo.Voucher) error {
+	want, err := cbor.Marshal(prev)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until, ok := s.VoucherLeases[guid]; ok && time.Now().Before(until) {
+		return fdo.ErrVoucherInUse
+	}
+	ov, ok := s.Vouchers[guid]
+	if !ok {
+		return fdo.ErrVoucherInUse
+	}
+	got, err := cbor.Marshal(ov)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fdo.ErrVoucherInUse
+	}
+	delete(s.Vouchers, guid)
+	s.Vouchers[next.Header.Val.GUID] = next
+	return nil
+}
+
+// SetModuleState stores the state of an owner service info module for the
+// device with the given (current voucher) GUID.
+func (s *State) SetModuleState(_ context.Context, guid protocol.GUID, module string, state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ModuleStates[guid] == nil {
+		s.ModuleStates[guid] = make(map[string][]byte)
+	}
+	s.ModuleStates[guid][module] = slices.Clone(state)
+	return nil
+}
+
+// ModuleState returns the state of an owner service info module for a
+// device. If no state has been stored, ErrNotFound is returned.
+func (s *State) ModuleState(_ context.Context, guid protocol.GUID, module string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.ModuleStates[guid][module]
+	if !ok {
+		return nil, fdo.ErrNotFound
+	}
+	return slices.Clone(state), nil
+}
+
+// RemoveModuleStates removes the state of all owner service info modules
+// for a device. It is called when TO2 completes.
+func (s *State) RemoveModuleStates(_ context.Context, guid protocol.GUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ModuleStates, guid)
+	return nil
+}
+
+// SetTO0Registration stores the most recent rendezvous blob registration of
+// a device with a Rendezvous Server, replacing any previous one.
+func (s *State) SetTO0Registration(_ context.Context, reg fdo.TO0Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.TO0Regs[reg.GUID]; !ok {
+		s.TO0Regs[reg.GUID] = make(map[string]fdo.TO0Registration)
+	}
+	s.TO0Regs[reg.GUID][reg.RvURL] = reg
+	return nil
+}
+
+// TO0Registrations returns the most recent rendezvous blob registration of a
+// device with each Rendezvous Server, ordered by RefreshAt.
+func (s *State) TO0Registrations(_ context.Context, guid protocol.GUID) ([]fdo.TO0Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var regs []fdo.TO0Registration
+	for _, reg := range s.TO0Regs[guid] {
+		regs = append(regs, reg)
+	}
+	slices.SortFunc(regs, func(a, b fdo.TO0Registration) int { return a.RefreshAt.Compare(b.RefreshAt) })
+	return regs, nil
+}
+
+// LapsingTO0Registrations returns the registrations of owned vouchers which
+// should be refreshed before the given time, ordered by RefreshAt.
+func (s *State) LapsingTO0Registrations(_ context.Context, before time.Time) ([]fdo.TO0Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var regs []fdo.TO0Registration
+	for guid, byURL := range s.TO0Regs {
+		if _, owned := s.Vouchers[guid]; !owned {
+			continue
+		}
+		for _, reg := range byURL {
+			if reg.RefreshAt.Before(before) {
+				regs = append(regs, reg)
+			}
+		}
+	}
+	slices.SortFunc(regs, func(a, b fdo.TO0Registration) int { return a.RefreshAt.Compare(b.RefreshAt) })
+	return regs, nil
+}
+
+// AddOnboardingEvent appends an event to the history of a device.
+func (s *State) AddOnboardingEvent(_ context.Context, event fdo.OnboardingEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.History[event.GUID] = append(s.History[event.GUID], event)
+	return nil
+}
+
+// OnboardingHistory returns the events of a device, oldest first. If none
+// have been recorded, the result is empty.
+func (s *State) OnboardingHistory(_ context.Context, guid protocol.GUID) ([]fdo.OnboardingEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.History[guid]), nil
+}
+
+// LatestOnboardingEvents returns the most recent event of each device whose
+// most recent event has the given state, oldest first.
+func (s *State) LatestOnboardingEvents(_ context.Context, state fdo.OnboardingState) ([]fdo.OnboardingEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest []fdo.OnboardingEvent
+	for _, events := range s.History {
+		if event := events[len(events)-1]; event.State == state {
+			latest = append(latest, event)
+		}
+	}
+	slices.SortFunc(latest, func(a, b fdo.OnboardingEvent) int { return a.Time.Compare(b.Time) })
+	return latest, nil
+}
+
+// DenyDevice adds a device to the deny list.
+func (s *State) DenyDevice(_ context.Context, guid protocol.GUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Denied[guid] = true
+	return nil
+}
+
+// AllowDevice removes a device from the deny list. If the device is not
+// denied, ErrNotFound is returned.
+func (s *State) AllowDevice(_ context.Context, guid protocol.GUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.Denied[guid] {
+		return fdo.ErrNotFound
+	}
+	delete(s.Denied, guid)
+	return nil
+}
+
+// DeviceDenied returns whether a device is on the deny list.
+func (s *State) DeviceDenied(_ context.Context, guid protocol.GUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Denied[guid], nil
+}
+
+// AddNonce records an issued nonce until it expires.
+func (s *State) AddNonce(_ context.Context, nonce protocol.Nonce, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, exp := range s.Nonces {
+		if time.Now().After(exp) {
+			delete(s.Nonces, n)
+		}
+	}
+	s.Nonces[nonce] = expires
+	return nil
+}
+
+// ConsumeNonce removes a nonce. If it is not found or has expired,
+// ErrNotFound is returned.
+func (s *State) ConsumeNonce(_ context.Context, nonce protocol.Nonce) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.Nonces[nonce]
+	if !ok {
+		return fdo.ErrNotFound
+	}
+	delete(s.Nonces, nonce)
+	if time.Now().After(exp) {
+		return fdo.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceStatus moves a device to a lifecycle state at the given time.
+func (s *State) UpdateDeviceStatus(_ context.Context, guid protocol.GUID, state fdo.DeviceLifecycle, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.DeviceStatuses[guid]
+	if !status.State.CanTransition(state) {
+		return fmt.Errorf("%w: %s to %s", fdo.ErrInvalidStatusTransition, status.State, state)
+	}
+	if !ok {
+		status = fdo.DeviceStatus{GUID: guid, Times: make(map[fdo.DeviceLifecycle]time.Time)}
+	}
+	status.State, status.Updated = state, at
+	status.Times = maps.Clone(status.Times)
+	status.Times[state] = at
+	s.DeviceStatuses[guid] = status
+	return nil
+}
+
+// DeviceStatus returns the lifecycle state of a device.
+func (s *State) DeviceStatus(_ context.Context, guid protocol.GUID) (*fdo.DeviceStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.DeviceStatuses[guid]
+	if !ok {
+		return nil, fdo.ErrNotFound
+	}
+	status.Times = maps.Clone(status.Times)
+	return &status, nil
+}
+
+// DevicesByStatus returns the status of each device currently in the given
+// state, ordered by the time it entered the state.
+func (s *State) DevicesByStatus(_ context.Context, state fdo.DeviceLifecycle) ([]fdo.DeviceStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var statuses []fdo.DeviceStatus
+	for _, status := range s.DeviceStatuses {
+		if status.State == state {
+			status.Times = maps.Clone(status.Times)
+			statuses = append(statuses, status)
+		}
+	}
+	slices.SortFunc(statuses, func(a, b fdo.DeviceStatus) int { return a.Updated.Compare(b.Updated) })
+	return statuses, nil
+}
+
 func newCA(priv crypto.Signer) (*x509.Certificate, error) {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -163,23 +560,241 @@ func (s *State) ManufacturerKey(keyType protocol.KeyType) (crypto.Signer, []*x50
 	return s.OwnerKey(keyType)
 }
 
+// AddNamedManufacturerKey adds a manufacturer key, replacing any key of the
+// same name and type.
+func (s *State) AddNamedManufacturerKey(_ context.Context, name string, keyType protocol.KeyType, key crypto.Signer, chain []*x509.Certificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := slices.DeleteFunc(s.NamedManufacturerKeys[keyType], func(key fdo.ManufacturerKey) bool {
+		return key.Name == name
+	})
+	s.NamedManufacturerKeys[keyType] = slices.Insert(keys, 0, fdo.ManufacturerKey{
+		Name:  name,
+		Type:  keyType,
+		Key:   key,
+		Chain: chain,
+	})
+	return nil
+}
+
+// ManufacturerKeys returns all named manufacturer keys of a type, most
+// recently added first, followed by the unnamed key returned by
+// ManufacturerKey.
+func (s *State) ManufacturerKeys(_ context.Context, keyType protocol.KeyType) ([]fdo.ManufacturerKey, error) {
+	s.mu.Lock()
+	keys := slices.Clone(s.NamedManufacturerKeys[keyType])
+	s.mu.Unlock()
+
+	key, chain, err := s.ManufacturerKey(keyType)
+	if err == nil {
+		keys = append(keys, fdo.ManufacturerKey{Type: keyType, Key: key, Chain: chain})
+	}
+	return keys, nil
+}
+
+// SetVoucherManufacturerKey records the name of the manufacturer key which
+// signed a voucher.
+func (s *State) SetVoucherManufacturerKey(_ context.Context, guid protocol.GUID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.VoucherManufacturerKeys[guid] = name
+	return nil
+}
+
+// VoucherManufacturerKey returns the name of the manufacturer key which
+// signed a voucher.
+func (s *State) VoucherManufacturerKey(_ context.Context, guid protocol.GUID) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.VoucherManufacturerKeys[guid]
+	if !ok {
+		return "", fdo.ErrNotFound
+	}
+	return name, nil
+}
+
+// SetVoucherMetadata stores the metadata of a voucher, replacing any
+// previously stored.
+func (s *State) SetVoucherMetadata(_ context.Context, guid protocol.GUID, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.VoucherMetadatas[guid] = maps.Clone(metadata)
+	return nil
+}
+
+// VoucherMetadata returns the metadata of a voucher.
+func (s *State) VoucherMetadata(_ context.Context, guid protocol.GUID) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metadata, ok := s.VoucherMetadatas[guid]
+	if !ok {
+		return nil, fdo.ErrNotFound
+	}
+	return maps.Clone(metadata), nil
+}
+
 // SetRVBlob sets the owner rendezvous blob for a device.
 func (s *State) SetRVBlob(ctx context.Context, ov *fdo.Voucher, to1d *cose.Sign1[protocol.To1d, []byte], exp time.Time) error {
-	// TODO: Handle expiration
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.RVBlobs[ov.Header.Val.GUID] = to1d
+	s.RVBlobExp[ov.Header.Val.GUID] = exp
+	s.RVBlobOVs[ov.Header.Val.GUID] = ov
 	s.Vouchers[ov.Header.Val.GUID] = ov
 	return nil
 }
 
 // RVBlob returns the owner rendezvous blob for a device.
 func (s *State) RVBlob(ctx context.Context, guid protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *fdo.Voucher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	to1d, ok := s.RVBlobs[guid]
-	if !ok {
+	if !ok || time.Now().After(s.RVBlobExp[guid]) {
 		return nil, nil, fdo.ErrNotFound
 	}
-	ov, ok := s.Vouchers[guid]
+	return to1d, s.RVBlobOVs[guid], nil
+}
+
+// ListRVBlobs returns all unexpired rendezvous blob registrations, ordered by
+// GUID.
+func (s *State) ListRVBlobs(ctx context.Context) ([]fdo.RVBlobInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var infos []fdo.RVBlobInfo
+	now := time.Now()
+	for guid := range s.RVBlobs {
+		exp := s.RVBlobExp[guid]
+		if now.After(exp) {
+			continue
+		}
+		infos = append(infos, fdo.RVBlobInfo{GUID: guid, Voucher: s.RVBlobOVs[guid], Expires: exp})
+	}
+	slices.SortFunc(infos, func(a, b fdo.RVBlobInfo) int { return bytes.Compare(a.GUID[:], b.GUID[:]) })
+	return infos, nil
+}
+
+// RVBlobRegistration returns the unexpired rendezvous blob registration of a
+// device.
+func (s *State) RVBlobRegistration(ctx context.Context, guid protocol.GUID) (*fdo.RVBlobInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.RVBlobExp[guid]
+	if !ok || time.Now().After(exp) {
+		return nil, fdo.ErrNotFound
+	}
+	return &fdo.RVBlobInfo{GUID: guid, Voucher: s.RVBlobOVs[guid], Expires: exp}, nil
+}
+
+// RemoveRVBlob deletes the rendezvous blob registration of a device. If none
+// exists, ErrNotFound is returned.
+func (s *State) RemoveRVBlob(ctx context.Context, guid protocol.GUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.RVBlobs[guid]; !ok {
+		return fdo.ErrNotFound
+	}
+	delete(s.RVBlobs, guid)
+	delete(s.RVBlobExp, guid)
+	delete(s.RVBlobOVs, guid)
+	return nil
+}
+
+// SetDevmod stores the devmod service info of a device, replacing any
+// previous.
+func (s *State) SetDevmod(_ context.Context, guid protocol.GUID, devmod fdo.DeviceDevmod) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devmod.Modules = slices.Clone(devmod.Modules)
+	s.Devmods[guid] = devmod
+	return nil
+}
+
+// Devmod returns the devmod service info of a device. If none has been
+// stored, ErrNotFound is returned.
+func (s *State) Devmod(_ context.Context, guid protocol.GUID) (*fdo.DeviceDevmod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devmod, ok := s.Devmods[guid]
 	if !ok {
-		return nil, nil, fdo.ErrNotFound
+		return nil, fdo.ErrNotFound
+	}
+	devmod.Modules = slices.Clone(devmod.Modules)
+	return &devmod, nil
+}
+
+// Trace records a traced message.
+func (s *State) Trace(_ context.Context, event protocol.TraceEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Traces = append(s.Traces, event)
+	return nil
+}
+
+// SessionTrace returns the traced messages with a correlation ID, oldest
+// first.
+func (s *State) SessionTrace(_ context.Context, correlationID uint) ([]protocol.TraceEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []protocol.TraceEvent
+	for _, event := range s.Traces {
+		if event.CorrelationID == correlationID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// DeviceTrace returns the traced messages of every session in which a
+// message was associated with the device GUID, oldest first.
+func (s *State) DeviceTrace(_ context.Context, guid protocol.GUID) ([]protocol.TraceEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make(map[uint]bool)
+	for _, event := range s.Traces {
+		if event.GUID != nil && *event.GUID == guid {
+			sessions[event.CorrelationID] = true
+		}
+	}
+	var events []protocol.TraceEvent
+	for _, event := range s.Traces {
+		if sessions[event.CorrelationID] {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// AddHelloDevice records the HelloDevice message of a session.
+func (s *State) AddHelloDevice(_ context.Context, record fdo.HelloDeviceRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record.Raw = slices.Clone(record.Raw)
+	s.HelloDeviceRecords = append(s.HelloDeviceRecords, record)
+	return nil
+}
+
+// HelloDevices returns the recorded messages of a device, oldest first.
+func (s *State) HelloDevices(_ context.Context, guid protocol.GUID) ([]fdo.HelloDeviceRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []fdo.HelloDeviceRecord
+	for _, record := range s.HelloDeviceRecords {
+		if record.GUID == guid {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// SessionHelloDevice returns the recorded message of the session with a
+// correlation ID.
+func (s *State) SessionHelloDevice(_ context.Context, correlationID uint) (*fdo.HelloDeviceRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.HelloDeviceRecords) - 1; i >= 0; i-- {
+		if record := s.HelloDeviceRecords[i]; record.CorrelationID == correlationID {
+			return &record, nil
+		}
 	}
-	return to1d, ov, nil
+	return nil, fdo.ErrNotFound
 }
@@ -52,6 +52,18 @@ func toToken[T state](v T, secret []byte) (string, error) {
 }
 
 func fromToken[T state](s string, secret []byte) (*T, error) {
+	payload, err := verifyToken(s, secret)
+	if err != nil {
+		return nil, err
+	}
+	v := new(T)
+	if err := cbor.Unmarshal(payload, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func verifyToken(s string, secret []byte) ([]byte, error) {
 	macAndPayload, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
 		return nil, err
@@ -67,12 +79,28 @@ func fromToken[T state](s string, secret []byte) (*T, error) {
 	if !hmac.Equal(mac1, mac2) {
 		return nil, fdo.ErrInvalidSession
 	}
+	return payload, nil
+}
 
-	v := new(T)
-	if err := cbor.Unmarshal(payload, v); err != nil {
+// sessionID decodes the Unique value, which is the first field of every
+// state, from a token.
+func sessionID(s string, secret []byte) ([]byte, error) {
+	payload, err := verifyToken(s, secret)
+	if err != nil {
 		return nil, err
 	}
-	return v, nil
+	var fields []cbor.RawBytes
+	if err := cbor.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fdo.ErrInvalidSession
+	}
+	var u Unique
+	if err := cbor.Unmarshal(fields[0], &u.Random); err != nil {
+		return nil, err
+	}
+	return u.Random[:], nil
 }
 
 func fetch[S state, T any](ctx context.Context, s Service, f func(S) (T, error)) (T, error) {
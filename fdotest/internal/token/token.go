@@ -104,6 +104,7 @@ type Service struct {
 }
 
 var _ protocol.TokenService = (*Service)(nil)
+var _ protocol.SessionIdentifier = (*Service)(nil)
 var _ fdo.DISessionState = (*Service)(nil)
 var _ fdo.TO0SessionState = (*Service)(nil)
 var _ fdo.TO1SessionState = (*Service)(nil)
@@ -230,6 +231,16 @@ func (s Service) TokenFromContext(ctx context.Context) (string, bool) {
 	return *token, true
 }
 
+// SessionID returns the random value of the token in the context, which is
+// set when the token is created and never updated.
+func (s Service) SessionID(ctx context.Context) ([]byte, error) {
+	token, ok := s.TokenFromContext(ctx)
+	if !ok {
+		return nil, fdo.ErrInvalidSession
+	}
+	return sessionID(token, s.HmacSecret)
+}
+
 // InvalidateToken destroys the state associated with a given token.
 func (s Service) InvalidateToken(ctx context.Context) error {
 	if token, ok := ctx.Value(key).(*string); ok && token != nil {
@@ -0,0 +1,365 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdotest
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fdotest/internal/memory"
+	"github.com/fido-device-onboard/go-fdo/fdotest/internal/token"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/loopback"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/record"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// Server is an in-memory manufacturer, rendezvous, and owner service for
+// writing integration tests of applications which use this library, without
+// sqlite or network listeners. All exchanged messages are recorded so that
+// tests may make assertions on them.
+//
+// The responders may be configured before use, i.e. by setting
+// TO2.OwnerModules.
+type Server struct {
+	State AllServerState
+
+	DI  *fdo.DIServer[custom.DeviceMfgInfo]
+	TO0 *fdo.TO0Server
+	TO1 *fdo.TO1Server
+	TO2 *fdo.TO2Server
+
+	// TO0Client registers rendezvous blobs on behalf of the owner service.
+	TO0Client *fdo.TO0Client
+
+	// Intercept, if set, may replace each message before it is sent to the
+	// server, i.e. to inject malformed messages. TO2 messages are replaced
+	// before they are encrypted.
+	Intercept func(msgType uint8, msg any) any
+
+	transport fdo.Transport
+
+	mu        sync.Mutex
+	recording bytes.Buffer
+}
+
+// Device is a device credential minted by Server.NewDevice, along with its
+// secrets.
+type Device struct {
+	Cred       fdo.DeviceCredential
+	KeyType    protocol.KeyType
+	HmacSha256 hash.Hash
+	HmacSha384 hash.Hash
+	Key        crypto.Signer
+
+	// HmacSecret is the secret of HmacSha256 and HmacSha384, so that the
+	// credential may be persisted.
+	HmacSecret []byte
+}
+
+// NewServer creates a Server with in-memory state.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	stateless, err := token.NewService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inMemory, err := memory.NewState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := struct {
+		*token.Service
+		*memory.State
+	}{stateless, inMemory}
+
+	s := &Server{
+		State: state,
+		DI: &fdo.DIServer[custom.DeviceMfgInfo]{
+			Session:               state,
+			Vouchers:              state,
+			SignDeviceCertificate: signDeviceCertificate(state),
+			DeviceInfo: func(_ context.Context, info *custom.DeviceMfgInfo, _ []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error) {
+				return info.DeviceInfo, info.KeyType, info.KeyEncoding, nil
+			},
+			AutoExtend: state,
+			RvInfo: func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) {
+				return [][]protocol.RvInstruction{}, nil
+			},
+		},
+		TO0: &fdo.TO0Server{
+			Session: state,
+			RVBlobs: state,
+		},
+		TO1: &fdo.TO1Server{
+			Session: state,
+			RVBlobs: state,
+		},
+		TO2: &fdo.TO2Server{
+			Session:     state,
+			Vouchers:    state,
+			OwnerKeys:   state,
+			ModuleState: state,
+			Devmods:     state,
+			RvInfo: func(context.Context, fdo.Voucher) ([][]protocol.RvInstruction, error) {
+				return [][]protocol.RvInstruction{}, nil
+			},
+			OwnerModules: func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+				return func(yield func(string, serviceinfo.OwnerModule) bool) {}
+			},
+			ReuseCredential: func(context.Context, fdo.Voucher) bool { return false },
+			VerifyVoucher:   func(context.Context, fdo.Voucher) error { return nil },
+		},
+		TO0Client: &fdo.TO0Client{
+			Vouchers:      state,
+			OwnerKeys:     state,
+			Registrations: state,
+		},
+	}
+	s.transport = interceptTransport{s: s, next: &record.Transport{
+		Transport: &loopback.Transport{
+			Tokens:       state,
+			DIResponder:  s.DI,
+			TO0Responder: s.TO0,
+			TO1Responder: s.TO1,
+			TO2Responder: s.TO2,
+		},
+		W: (*lockedWriter)(s),
+	}}
+	return s
+}
+
+// Transport returns a transport connected to the server, which may be used
+// directly with fdo.DI, fdo.TO1, and fdo.TO2.
+func (s *Server) Transport() fdo.Transport { return s.transport }
+
+// NewDevice mints a device credential of the given key type by running DI.
+// The voucher is extended to the owner service and may be retrieved with
+// Voucher.
+func (s *Server) NewDevice(t testing.TB, keyType protocol.KeyType) *Device {
+	t.Helper()
+
+	key, err := NewKey(keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatalf("error generating device secret: %v", err)
+	}
+	dev := &Device{
+		KeyType:    keyType,
+		HmacSha256: hmac.New(sha256.New, secret),
+		HmacSha384: hmac.New(sha512.New384, secret),
+		Key:        key,
+		HmacSecret: secret,
+	}
+
+	var sigAlg x509.SignatureAlgorithm
+	if keyType == protocol.RsaPssKeyType {
+		sigAlg = x509.SHA256WithRSAPSS
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:            pkix.Name{CommonName: "device.go-fdo"},
+		SignatureAlgorithm: sigAlg,
+	}, key)
+	if err != nil {
+		t.Fatalf("error creating CSR for device certificate chain: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatalf("error parsing CSR for device certificate chain: %v", err)
+	}
+	serial := make([]byte, 10)
+	if _, err := rand.Read(serial); err != nil {
+		t.Fatalf("error generating serial: %v", err)
+	}
+
+	keyEncoding := protocol.X509KeyEnc
+	if keyType == protocol.Secp256r1KeyType || keyType == protocol.Secp384r1KeyType {
+		keyEncoding = protocol.X5ChainKeyEnc
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cred, err := fdo.DI(ctx, s.transport, custom.DeviceMfgInfo{
+		KeyType:      keyType,
+		KeyEncoding:  keyEncoding,
+		SerialNumber: hex.EncodeToString(serial),
+		DeviceInfo:   "gotest",
+		CertInfo:     cbor.X509CertificateRequest(*csr),
+	}, fdo.DIConfig{
+		HmacSha256: dev.HmacSha256,
+		HmacSha384: dev.HmacSha384,
+		Key:        key,
+		PSS:        keyType == protocol.RsaPssKeyType,
+	})
+	if err != nil {
+		t.Fatalf("error running DI: %v", err)
+	}
+	dev.Cred = *cred
+	return dev
+}
+
+// Voucher returns the current ownership voucher of a device.
+func (s *Server) Voucher(t testing.TB, guid protocol.GUID) *fdo.Voucher {
+	t.Helper()
+
+	ov, err := s.State.Voucher(context.Background(), guid)
+	if err != nil {
+		t.Fatalf("error retrieving voucher: %v", err)
+	}
+	return ov
+}
+
+// RegisterBlob runs TO0 for a device, so that it may find the owner service
+// with TO1.
+func (s *Server) RegisterBlob(t testing.TB, guid protocol.GUID) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dnsAddr := "owner.fidoalliance.org"
+	if _, err := s.TO0Client.RegisterBlob(ctx, s.transport, guid, []protocol.RvTO2Addr{
+		{
+			DNSAddress:        &dnsAddr,
+			Port:              8080,
+			TransportProtocol: protocol.HTTPTransport,
+		},
+	}); err != nil {
+		t.Fatalf("error running TO0: %v", err)
+	}
+}
+
+// Onboard runs TO1 and TO2 for a device, replacing its credential on success.
+// Device modules, if any, are used for service info. The error from TO1 or
+// TO2 is returned so that failures may be asserted.
+func (s *Server) Onboard(t testing.TB, dev *Device, modules map[string]serviceinfo.DeviceModule) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	pss := dev.KeyType == protocol.RsaPssKeyType
+	to1d, err := fdo.TO1(ctx, s.transport, dev.Cred, dev.Key, &fdo.TO1Options{PSS: pss})
+	if err != nil {
+		return err
+	}
+
+	keyExchange, cipherSuite := kex.ECDH256Suite, kex.A128GcmCipher
+	switch dev.KeyType {
+	case protocol.Secp384r1KeyType:
+		keyExchange, cipherSuite = kex.ECDH384Suite, kex.A256GcmCipher
+	case protocol.Rsa2048RestrKeyType, protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+		keyExchange = kex.DHKEXid15Suite
+	}
+	newCred, err := fdo.TO2(ctx, s.transport, to1d, fdo.TO2Config{
+		Cred:       dev.Cred,
+		HmacSha256: dev.HmacSha256,
+		HmacSha384: dev.HmacSha384,
+		Key:        dev.Key,
+		PSS:        pss,
+		Devmod: serviceinfo.Devmod{
+			Os:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Version: "go-fdo test",
+			Device:  "go-validation",
+			FileSep: ";",
+			Bin:     runtime.GOARCH,
+		},
+		DeviceModules: serviceinfo.NewRegistry(modules),
+		KeyExchange:   keyExchange,
+		CipherSuite:   cipherSuite,
+	})
+	if err != nil {
+		return err
+	}
+	dev.Cred = *newCred
+	return nil
+}
+
+// Exchanges returns all messages exchanged with the server so far, with TO2
+// messages unencrypted.
+func (s *Server) Exchanges(t testing.TB) []record.Exchange {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exchanges, err := record.ReadAll(bytes.NewReader(s.recording.Bytes()))
+	if err != nil {
+		t.Fatalf("error reading recorded exchanges: %v", err)
+	}
+	return exchanges
+}
+
+// AssertExchanged fails the test unless the given message types were sent to
+// the server in order, though not necessarily consecutively.
+func (s *Server) AssertExchanged(t testing.TB, msgTypes ...uint8) {
+	t.Helper()
+
+	var sent []uint8
+	for _, ex := range s.Exchanges(t) {
+		sent = append(sent, ex.MsgType)
+	}
+	remaining := sent
+	for _, msgType := range msgTypes {
+		i := slices.Index(remaining, msgType)
+		if i == -1 {
+			t.Fatalf("expected message types %v to be sent in order, got %v", msgTypes, sent)
+		}
+		remaining = remaining[i+1:]
+	}
+}
+
+// ResetExchanges discards recorded exchanges.
+func (s *Server) ResetExchanges() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recording.Reset()
+}
+
+// lockedWriter writes to the recording of a Server.
+type lockedWriter Server
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.recording.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("error recording exchange: %w", err)
+	}
+	return n, nil
+}
+
+// interceptTransport applies Server.Intercept to each message.
+type interceptTransport struct {
+	s    *Server
+	next fdo.Transport
+}
+
+func (t interceptTransport) Send(ctx context.Context, msgType uint8, msg any, sess kex.Session) (uint8, io.ReadCloser, error) {
+	if t.s.Intercept != nil {
+		msg = t.s.Intercept(msgType, msg)
+	}
+	return t.next.Send(ctx, msgType, msg, sess)
+}
@@ -17,9 +17,11 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
+	"maps"
 	"math/big"
 	"net"
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 
@@ -30,6 +32,7 @@ import (
 	"github.com/fido-device-onboard/go-fdo/fdotest/internal/token"
 	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 	"github.com/fido-device-onboard/go-fdo/testdata"
 )
 
@@ -42,10 +45,27 @@ type AllServerState interface {
 	fdo.TO1SessionState
 	fdo.TO2SessionState
 	fdo.RendezvousBlobPersistentState
+	fdo.RendezvousBlobAdminState
 	fdo.ManufacturerVoucherPersistentState
 	fdo.OwnerVoucherPersistentState
 	fdo.OwnerKeyPersistentState
+	fdo.ModuleStatePersistentState
+	fdo.TO0RegistrationPersistentState
+	fdo.OnboardingHistoryPersistentState
+	fdo.DeviceDenyListPersistentState
+	fdo.DevmodPersistentState
+	fdo.OwnerKeyRotationPersistentState
+	fdo.OwnerVoucherListPersistentState
+	fdo.OwnerVoucherLeasePersistentState
+	fdo.ManufacturerKeysPersistentState
+	fdo.VoucherManufacturerKeyPersistentState
+	fdo.VoucherMetadataPersistentState
+	fdo.NonceStore
+	fdo.DeviceStatusPersistentState
+	fdo.ProtocolTracePersistentState
+	fdo.HelloDevicePersistentState
 	ManufacturerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)
+	AddNamedManufacturerKey(ctx context.Context, name string, keyType protocol.KeyType, key crypto.Signer, chain []*x509.Certificate) error
 }
 
 // RunServerStateSuite is used to test different implementations of all server
@@ -539,6 +559,66 @@ func RunServerStateSuite(t *testing.T, state AllServerState) { //nolint:gocyclo
 		}
 	})
 
+	t.Run("RendezvousBlobAdminState", func(t *testing.T) {
+		fixture, err := NewFixture(FixtureOptions{Entries: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		guid := fixture.Voucher.Header.Val.GUID
+		blob := cose.Sign1[protocol.To1d, []byte]{
+			Payload: cbor.NewByteWrap(protocol.To1d{
+				To0dHash: protocol.Hash{Algorithm: protocol.Sha256Hash, Value: make([]byte, 32)},
+			}),
+		}
+		if err := blob.Sign(fixture.OwnerKey(), nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		exp := time.Now().Add(time.Hour).Truncate(time.Second)
+		if err := state.SetRVBlob(context.TODO(), fixture.Voucher, &blob, exp); err != nil {
+			t.Fatal(err)
+		}
+
+		// Shadow state to limit testable functions
+		var state fdo.RendezvousBlobAdminState = state
+
+		infos, err := state.ListRVBlobs(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := slices.IndexFunc(infos, func(info fdo.RVBlobInfo) bool { return info.GUID == guid })
+		if i < 0 {
+			t.Fatalf("expected registration of %x in %+v", guid, infos)
+		}
+		if !infos[i].Expires.Equal(exp) || infos[i].Voucher.Header.Val.GUID != guid {
+			t.Fatalf("expected registration of %x until %s, got %+v", guid, exp, infos[i])
+		}
+		if !slices.IsSortedFunc(infos, func(a, b fdo.RVBlobInfo) int { return bytes.Compare(a.GUID[:], b.GUID[:]) }) {
+			t.Fatal("expected registrations ordered by GUID")
+		}
+		info, err := state.RVBlobRegistration(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.GUID != guid || !info.Expires.Equal(exp) || info.Voucher.Header.Val.GUID != guid {
+			t.Fatalf("expected registration of %x until %s, got %+v", guid, exp, info)
+		}
+
+		if err := state.RemoveRVBlob(context.TODO(), guid); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.RemoveRVBlob(context.TODO(), guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound removing twice, got %v", err)
+		}
+		if infos, err = state.ListRVBlobs(context.TODO()); err != nil {
+			t.Fatal(err)
+		} else if slices.ContainsFunc(infos, func(info fdo.RVBlobInfo) bool { return info.GUID == guid }) {
+			t.Fatal("expected registration to be removed")
+		}
+		if _, err := state.RVBlobRegistration(context.TODO(), guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for removed registration, got %v", err)
+		}
+	})
+
 	t.Run("OwnerVoucherPersistentState", func(t *testing.T) {
 		// Parse ownership voucher from testdata
 		b, err := testdata.Files.ReadFile("ov.pem")
@@ -590,6 +670,474 @@ func RunServerStateSuite(t *testing.T, state AllServerState) { //nolint:gocyclo
 		}
 	})
 
+	t.Run("OwnerVoucherLeasePersistentState", func(t *testing.T) {
+		b, err := testdata.Files.ReadFile("ov.pem")
+		if err != nil {
+			t.Fatalf("error opening voucher test data: %v", err)
+		}
+		blk, _ := pem.Decode(b)
+		if blk == nil {
+			t.Fatal("voucher contained invalid PEM data")
+		}
+		ov := new(fdo.Voucher)
+		if err := cbor.Unmarshal(blk.Bytes, ov); err != nil {
+			t.Fatalf("error parsing voucher test data: %v", err)
+		}
+		guid := ov.Header.Val.GUID
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		ov.Header.Val.GUID = guid
+		if err := state.AddVoucher(context.TODO(), ov); err != nil {
+			t.Fatal(err)
+		}
+		prev, err := state.Voucher(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := *prev
+		next.Hmac.Value = bytes.Repeat([]byte{0x01}, len(prev.Hmac.Value))
+
+		// Shadow state to limit testable functions
+		var state fdo.OwnerVoucherLeasePersistentState = state
+
+		// Leased vouchers are not replaced
+		if err := state.LeaseVoucher(context.TODO(), guid, time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.ReplaceUnleasedVoucher(context.TODO(), guid, prev, &next); !errors.Is(err, fdo.ErrVoucherInUse) {
+			t.Fatalf("expected ErrVoucherInUse replacing leased voucher, got %v", err)
+		}
+
+		// Vouchers which changed since they were read are not replaced
+		if err := state.ReleaseVoucher(context.TODO(), guid); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.ReplaceUnleasedVoucher(context.TODO(), guid, &next, prev); !errors.Is(err, fdo.ErrVoucherInUse) {
+			t.Fatalf("expected ErrVoucherInUse replacing changed voucher, got %v", err)
+		}
+
+		// Vouchers with released or expired leases are replaced
+		if err := state.ReplaceUnleasedVoucher(context.TODO(), guid, prev, &next); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.LeaseVoucher(context.TODO(), guid, time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.ReplaceUnleasedVoucher(context.TODO(), guid, &next, prev); err != nil {
+			t.Fatalf("expected voucher with expired lease to be replaced, got %v", err)
+		}
+	})
+
+	t.Run("ModuleStatePersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.ModuleStatePersistentState = state
+
+		var guid protocol.GUID
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.ModuleState(context.TODO(), guid, "fdo.download"); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		// Store and overwrite state of two modules
+		for _, module := range []string{"fdo.download", "fdo.upload"} {
+			if err := state.SetModuleState(context.TODO(), guid, module, []byte("initial")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := state.SetModuleState(context.TODO(), guid, "fdo.download", []byte("updated")); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := state.ModuleState(context.TODO(), guid, "fdo.download"); err != nil {
+			t.Fatal(err)
+		} else if string(got) != "updated" {
+			t.Fatalf("expected updated module state, got %q", got)
+		}
+		if got, err := state.ModuleState(context.TODO(), guid, "fdo.upload"); err != nil {
+			t.Fatal(err)
+		} else if string(got) != "initial" {
+			t.Fatalf("expected initial module state, got %q", got)
+		}
+
+		// Remove all module state for the device
+		if err := state.RemoveModuleStates(context.TODO(), guid); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.ModuleState(context.TODO(), guid, "fdo.upload"); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after removal, got %v", err)
+		}
+	})
+
+	t.Run("TO0RegistrationPersistentState", func(t *testing.T) {
+		// Register two owned vouchers and one which is not owned
+		var guids []protocol.GUID
+		for range 2 {
+			fixture, err := NewFixture(FixtureOptions{Entries: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := state.AddVoucher(context.TODO(), fixture.Voucher); err != nil {
+				t.Fatal(err)
+			}
+			guids = append(guids, fixture.Voucher.Header.Val.GUID)
+		}
+		var unowned protocol.GUID
+		if _, err := rand.Read(unowned[:]); err != nil {
+			t.Fatal(err)
+		}
+		if regs, err := state.TO0Registrations(context.TODO(), unowned); err != nil || len(regs) != 0 {
+			t.Fatalf("expected no registrations, got %+v: %v", regs, err)
+		}
+
+		// Registrations of a device with different rendezvous servers are
+		// kept separately
+		now := time.Now().Truncate(time.Second)
+		regs := []fdo.TO0Registration{
+			{GUID: guids[1], RvURL: "https://rv1.example.com", WaitSeconds: 3600, RefreshAt: now.Add(2 * time.Hour), Expires: now.Add(3 * time.Hour)},
+			{GUID: guids[0], RvURL: "https://rv1.example.com", WaitSeconds: 60, RefreshAt: now.Add(-time.Hour), Expires: now},
+			{GUID: unowned, RvURL: "https://rv1.example.com", WaitSeconds: 60, RefreshAt: now.Add(-time.Hour), Expires: now},
+			{GUID: guids[1], RvURL: "https://rv2.example.com", WaitSeconds: 60, RefreshAt: now.Add(-time.Hour), Expires: now},
+		}
+		for _, reg := range regs {
+			if err := state.SetTO0Registration(context.TODO(), reg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := state.TO0Registrations(context.TODO(), guids[1]); err != nil {
+			t.Fatal(err)
+		} else if len(got) != 2 || !to0RegistrationEqual(got[0], regs[3]) || !to0RegistrationEqual(got[1], regs[0]) {
+			t.Fatalf("expected %+v, got %+v", []fdo.TO0Registration{regs[3], regs[0]}, got)
+		}
+
+		// Refreshing a registration replaces only the registration with the
+		// same rendezvous server
+		regs[1].RefreshAt, regs[1].Expires = now.Add(time.Hour), now.Add(90*time.Minute)
+		regs[3].RefreshAt, regs[3].Expires = now.Add(4*time.Hour), now.Add(5*time.Hour)
+		for _, reg := range []fdo.TO0Registration{regs[1], regs[3]} {
+			if err := state.SetTO0Registration(context.TODO(), reg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		lapsing, err := state.LapsingTO0Registrations(context.TODO(), now.Add(90*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lapsing) != 1 || !to0RegistrationEqual(lapsing[0], regs[1]) {
+			t.Fatalf("expected only the refreshed registration to lapse, got %+v", lapsing)
+		}
+		lapsing, err = state.LapsingTO0Registrations(context.TODO(), now.Add(3*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lapsing) != 2 || !to0RegistrationEqual(lapsing[0], regs[1]) || !to0RegistrationEqual(lapsing[1], regs[0]) {
+			t.Fatalf("expected both owned registrations in order, got %+v", lapsing)
+		}
+	})
+
+	t.Run("OnboardingHistoryPersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.OnboardingHistoryPersistentState = state
+
+		var guid, other, replacement protocol.GUID
+		for _, g := range []*protocol.GUID{&guid, &other, &replacement} {
+			if _, err := rand.Read(g[:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if events, err := state.OnboardingHistory(context.TODO(), guid); err != nil {
+			t.Fatal(err)
+		} else if len(events) != 0 {
+			t.Fatalf("expected no history, got %+v", events)
+		}
+
+		now := time.Now().Truncate(time.Second)
+		events := []fdo.OnboardingEvent{
+			{GUID: guid, Time: now, State: fdo.OnboardingImported},
+			{GUID: other, Time: now, State: fdo.OnboardingImported},
+			{GUID: guid, Time: now.Add(time.Second), State: fdo.OnboardingFailed, Err: "boom"},
+			{GUID: guid, Time: now.Add(2 * time.Second), State: fdo.OnboardingCompleted, ReplacementGUID: &replacement, OwnerKeyHash: []byte{1, 2, 3}},
+		}
+		for _, event := range events {
+			if err := state.AddOnboardingEvent(context.TODO(), event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		history, err := state.OnboardingHistory(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 3 {
+			t.Fatalf("expected 3 events, got %+v", history)
+		}
+		if history[1].State != fdo.OnboardingFailed || history[1].Err != "boom" || !history[1].Time.Equal(events[2].Time) {
+			t.Fatalf("expected failed event, got %+v", history[1])
+		}
+		if history[2].ReplacementGUID == nil || *history[2].ReplacementGUID != replacement {
+			t.Fatalf("expected replacement GUID %x, got %+v", replacement, history[2])
+		}
+		if !bytes.Equal(history[2].OwnerKeyHash, []byte{1, 2, 3}) {
+			t.Fatalf("expected owner key hash, got %+v", history[2])
+		}
+
+		// Only the latest event of each device is matched
+		completed, err := state.LatestOnboardingEvents(context.TODO(), fdo.OnboardingCompleted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.ContainsFunc(completed, func(e fdo.OnboardingEvent) bool { return e.GUID == guid }) {
+			t.Fatalf("expected %x to be completed, got %+v", guid, completed)
+		}
+		imported, err := state.LatestOnboardingEvents(context.TODO(), fdo.OnboardingImported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.ContainsFunc(imported, func(e fdo.OnboardingEvent) bool { return e.GUID == guid }) ||
+			!slices.ContainsFunc(imported, func(e fdo.OnboardingEvent) bool { return e.GUID == other }) {
+			t.Fatalf("expected only %x to be imported, got %+v", other, imported)
+		}
+	})
+
+	t.Run("DeviceStatusPersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.DeviceStatusPersistentState = state
+
+		var guid, other protocol.GUID
+		for _, g := range []*protocol.GUID{&guid, &other} {
+			if _, err := rand.Read(g[:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := state.DeviceStatus(context.TODO(), guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		now := time.Now().Truncate(time.Second)
+		for i, update := range []struct {
+			guid  protocol.GUID
+			state fdo.DeviceLifecycle
+		}{
+			{guid, fdo.DeviceInitialized},
+			{guid, fdo.DeviceRegistered},
+			{other, fdo.DeviceContacted}, // first seen by the rendezvous server
+			{guid, fdo.DeviceContacted},
+			{guid, fdo.DeviceOnboarding},
+		} {
+			if err := state.UpdateDeviceStatus(context.TODO(), update.guid, update.state, now.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatalf("error updating %x to %s: %v", update.guid, update.state, err)
+			}
+		}
+
+		// Invalid transitions do not change the status
+		if err := state.UpdateDeviceStatus(context.TODO(), guid, fdo.DeviceInitialized, now.Add(time.Minute)); !errors.Is(err, fdo.ErrInvalidStatusTransition) {
+			t.Fatalf("expected ErrInvalidStatusTransition, got %v", err)
+		}
+		status, err := state.DeviceStatus(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.GUID != guid || status.State != fdo.DeviceOnboarding || !status.Updated.Equal(now.Add(4*time.Second)) {
+			t.Fatalf("expected device to be onboarding, got %+v", status)
+		}
+		if len(status.Times) != 4 || !status.Times[fdo.DeviceInitialized].Equal(now) || !status.Times[fdo.DeviceContacted].Equal(now.Add(3*time.Second)) {
+			t.Fatalf("unexpected state times: %+v", status.Times)
+		}
+
+		// Only devices currently in a state are listed
+		contacted, err := state.DevicesByStatus(context.TODO(), fdo.DeviceContacted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.ContainsFunc(contacted, func(s fdo.DeviceStatus) bool { return s.GUID == guid }) ||
+			!slices.ContainsFunc(contacted, func(s fdo.DeviceStatus) bool { return s.GUID == other }) {
+			t.Fatalf("expected only %x to be contacted, got %+v", other, contacted)
+		}
+	})
+
+	t.Run("ProtocolTracePersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.ProtocolTracePersistentState = state
+
+		var guid protocol.GUID
+		var ids [3]byte
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rand.Read(ids[:]); err != nil {
+			t.Fatal(err)
+		}
+		session, other := uint(ids[0])<<16|uint(ids[1])<<8|1, uint(ids[2])<<16|2
+
+		now := time.Now().Truncate(time.Microsecond)
+		for _, event := range []protocol.TraceEvent{
+			{CorrelationID: session, GUID: &guid, Time: now, Duration: time.Millisecond, MsgType: protocol.TO2HelloDeviceMsgType, RequestSize: 100, RespType: protocol.TO2ProveOVHdrMsgType, ResponseSize: 200, Summary: "[...]"},
+			{CorrelationID: other, Time: now, MsgType: protocol.TO1HelloRVMsgType, RespType: protocol.ErrorMsgType, Err: "[code=100] error"},
+			{CorrelationID: session, Time: now.Add(time.Second), MsgType: protocol.TO2GetOVNextEntryMsgType, RespType: protocol.TO2OVNextEntryMsgType},
+		} {
+			if err := state.Trace(context.TODO(), event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		events, err := state.SessionTrace(context.TODO(), session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 || events[0].MsgType != protocol.TO2HelloDeviceMsgType || events[1].MsgType != protocol.TO2GetOVNextEntryMsgType {
+			t.Fatalf("expected 2 events of session, got %+v", events)
+		}
+		first := events[0]
+		if first.GUID == nil || *first.GUID != guid || !first.Time.Equal(now) || first.Duration != time.Millisecond ||
+			first.RequestSize != 100 || first.ResponseSize != 200 || first.Summary != "[...]" {
+			t.Fatalf("unexpected event: %+v", first)
+		}
+
+		// Messages of the session without the GUID are included
+		events, err = state.DeviceTrace(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 || events[1].GUID != nil || events[1].CorrelationID != session {
+			t.Fatalf("expected 2 events of device, got %+v", events)
+		}
+
+		events, err = state.SessionTrace(context.TODO(), other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].Err != "[code=100] error" {
+			t.Fatalf("expected error event, got %+v", events)
+		}
+	})
+
+	t.Run("HelloDevicePersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.HelloDevicePersistentState = state
+
+		var guid protocol.GUID
+		var ids [2]byte
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rand.Read(ids[:]); err != nil {
+			t.Fatal(err)
+		}
+		first, second := uint(ids[0])<<8|1, uint(ids[1])<<8|2
+		if _, err := state.SessionHelloDevice(context.TODO(), first); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		now := time.Now().Truncate(time.Microsecond)
+		for _, record := range []fdo.HelloDeviceRecord{
+			{GUID: guid, CorrelationID: first, Time: now, Raw: []byte{0x86}, Hash: protocol.Hash{Algorithm: protocol.Sha256Hash, Value: []byte{1}}, Err: "unsupported cipher suite"},
+			{GUID: guid, CorrelationID: second, Time: now.Add(time.Second), Raw: []byte{0x86, 0x00}, Hash: protocol.Hash{Algorithm: protocol.Sha384Hash, Value: []byte{2}}},
+		} {
+			if err := state.AddHelloDevice(context.TODO(), record); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		records, err := state.HelloDevices(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || records[0].CorrelationID != first || records[1].CorrelationID != second {
+			t.Fatalf("expected 2 records of device, got %+v", records)
+		}
+		if r := records[0]; r.GUID != guid || !r.Time.Equal(now) || !bytes.Equal(r.Raw, []byte{0x86}) ||
+			r.Hash.Algorithm != protocol.Sha256Hash || !bytes.Equal(r.Hash.Value, []byte{1}) || r.Err != "unsupported cipher suite" {
+			t.Fatalf("unexpected record: %+v", r)
+		}
+
+		record, err := state.SessionHelloDevice(context.TODO(), second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.GUID != guid || record.Hash.Algorithm != protocol.Sha384Hash || record.Err != "" {
+			t.Fatalf("unexpected record of session: %+v", record)
+		}
+	})
+
+	t.Run("DeviceDenyListPersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.DeviceDenyListPersistentState = state
+
+		var guid protocol.GUID
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if denied, err := state.DeviceDenied(context.TODO(), guid); err != nil || denied {
+			t.Fatalf("expected device not to be denied, got %t, %v", denied, err)
+		}
+		if err := state.AllowDevice(context.TODO(), guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		// Denying twice is not an error
+		for range 2 {
+			if err := state.DenyDevice(context.TODO(), guid); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if denied, err := state.DeviceDenied(context.TODO(), guid); err != nil || !denied {
+			t.Fatalf("expected device to be denied, got %t, %v", denied, err)
+		}
+		if err := state.AllowDevice(context.TODO(), guid); err != nil {
+			t.Fatal(err)
+		}
+		if denied, err := state.DeviceDenied(context.TODO(), guid); err != nil || denied {
+			t.Fatalf("expected device to be allowed, got %t, %v", denied, err)
+		}
+	})
+
+	t.Run("NonceStore", func(t *testing.T) { RunNonceStoreSuite(t, state) })
+
+	t.Run("DevmodPersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.DevmodPersistentState = state
+
+		var guid protocol.GUID
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.Devmod(context.TODO(), guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		devmod := fdo.DeviceDevmod{
+			Devmod: serviceinfo.Devmod{
+				Os:      "linux",
+				Arch:    "amd64",
+				Version: "1",
+				Device:  "test",
+				Serial:  []byte{0x01, 0x02},
+				FileSep: ";",
+				Bin:     "x86_64",
+			},
+			Modules: []string{"devmod", "fdo.download"},
+			Time:    time.Now().Truncate(time.Second),
+		}
+		if err := state.SetDevmod(context.TODO(), guid, devmod); err != nil {
+			t.Fatal(err)
+		}
+
+		// Storing again replaces the devmod
+		devmod.Devmod.Arch = "arm64"
+		if err := state.SetDevmod(context.TODO(), guid, devmod); err != nil {
+			t.Fatal(err)
+		}
+		got, err := state.Devmod(context.TODO(), guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Devmod, devmod.Devmod) || !slices.Equal(got.Modules, devmod.Modules) || !got.Time.Equal(devmod.Time) {
+			t.Fatalf("expected %+v, got %+v", devmod, *got)
+		}
+	})
+
 	t.Run("OwnerKeyPersistentState", func(t *testing.T) {
 		// Shadow state to limit testable functions
 		var state fdo.OwnerKeyPersistentState = state
@@ -612,6 +1160,189 @@ func RunServerStateSuite(t *testing.T, state AllServerState) { //nolint:gocyclo
 			t.Fatalf("EC owner key is an incorrect type: %T", rsaKey)
 		}
 	})
+
+	t.Run("ManufacturerKeysPersistentState", func(t *testing.T) {
+		ctx := context.TODO()
+		unnamed, _, err := state.ManufacturerKey(protocol.RsaPkcsKeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Add two named keys, the most recent of which is listed first
+		for _, name := range []string{"line-a", "line-b"} {
+			cert, key, err := newCert(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := state.AddNamedManufacturerKey(ctx, name, protocol.RsaPkcsKeyType, key, []*x509.Certificate{cert}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys, err := state.ManufacturerKeys(ctx, protocol.RsaPkcsKeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, key := range keys {
+			names = append(names, key.Name)
+			if key.Type != protocol.RsaPkcsKeyType || key.Key == nil || len(key.Chain) == 0 {
+				t.Fatalf("incomplete manufacturer key %q", key.Name)
+			}
+		}
+		if !slices.Equal(names, []string{"line-b", "line-a", ""}) {
+			t.Fatalf("expected keys line-b, line-a, and unnamed, got %q", names)
+		}
+		if !keys[2].Key.Public().(*rsa.PublicKey).Equal(unnamed.Public()) {
+			t.Fatal("expected unnamed key to be the manufacturer key")
+		}
+
+		// Record the key which signed a voucher
+		var guid protocol.GUID
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.VoucherManufacturerKey(ctx, guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		for _, name := range []string{"line-a", "line-b"} {
+			if err := state.SetVoucherManufacturerKey(ctx, guid, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if name, err := state.VoucherManufacturerKey(ctx, guid); err != nil {
+			t.Fatal(err)
+		} else if name != "line-b" {
+			t.Fatalf("expected voucher manufacturer key line-b, got %q", name)
+		}
+	})
+
+	t.Run("VoucherMetadataPersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state fdo.VoucherMetadataPersistentState = state
+		ctx := context.TODO()
+
+		var guid protocol.GUID
+		if _, err := rand.Read(guid[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.VoucherMetadata(ctx, guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		for _, metadata := range []map[string]string{
+			{"plant": "P1", "serial": "1"},
+			{"plant": "P1", "serial": "2"},
+		} {
+			if err := state.SetVoucherMetadata(ctx, guid, metadata); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if metadata, err := state.VoucherMetadata(ctx, guid); err != nil {
+			t.Fatal(err)
+		} else if !maps.Equal(metadata, map[string]string{"plant": "P1", "serial": "2"}) {
+			t.Fatalf("expected replaced metadata, got %v", metadata)
+		}
+	})
+
+	t.Run("OwnerKeyRotationPersistentState", func(t *testing.T) {
+		// Shadow state to limit testable functions
+		var state interface {
+			fdo.OwnerKeyPersistentState
+			fdo.OwnerKeyRotationPersistentState
+		} = state
+
+		original, originalChain, err := state.OwnerKey(protocol.Secp384r1KeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Rotate to a new key
+		if err := state.RotateOwnerKey(context.TODO(), protocol.Secp384r1KeyType, next, nil); err != nil {
+			t.Fatal(err)
+		}
+		current, _, err := state.OwnerKey(protocol.Secp384r1KeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !next.PublicKey.Equal(current.Public()) {
+			t.Fatal("current owner key was not rotated")
+		}
+		previous, err := state.PreviousOwnerKeys(context.TODO(), protocol.Secp384r1KeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(previous) != 1 || !original.Public().(*ecdsa.PublicKey).Equal(previous[0].Key.Public()) {
+			t.Fatalf("expected original key to be the only previous key, got %d keys", len(previous))
+		}
+		if len(previous[0].Chain) != len(originalChain) {
+			t.Fatalf("expected previous key chain of length %d, got %d", len(originalChain), len(previous[0].Chain))
+		}
+
+		// Lookup by public key finds both current and previous keys
+		if _, _, err := fdo.OwnerKeyFor(context.TODO(), state, protocol.Secp384r1KeyType, original.Public()); err != nil {
+			t.Fatalf("previous key not found by public key: %v", err)
+		}
+		if _, _, err := fdo.OwnerKeyFor(context.TODO(), state, protocol.Secp384r1KeyType, next.Public()); err != nil {
+			t.Fatalf("current key not found by public key: %v", err)
+		}
+
+		// Rotate back to the original key and retire previous keys
+		if err := state.RotateOwnerKey(context.TODO(), protocol.Secp384r1KeyType, original, originalChain); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.RemovePreviousOwnerKeys(context.TODO(), protocol.Secp384r1KeyType, time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if previous, err := state.PreviousOwnerKeys(context.TODO(), protocol.Secp384r1KeyType); err != nil {
+			t.Fatal(err)
+		} else if len(previous) != 0 {
+			t.Fatalf("expected previous keys to be removed, got %d", len(previous))
+		}
+		if _, _, err := fdo.OwnerKeyFor(context.TODO(), state, protocol.Secp384r1KeyType, next.Public()); !errors.Is(err, fdo.ErrNotFound) {
+			t.Fatalf("expected retired key to not be found, got %v", err)
+		}
+	})
+}
+
+// RunNonceStoreSuite is used to test implementations of fdo.NonceStore,
+// including those which are not part of a complete server state.
+func RunNonceStoreSuite(t *testing.T, store fdo.NonceStore) {
+	newNonce := func() protocol.Nonce {
+		var nonce protocol.Nonce
+		if _, err := rand.Read(nonce[:]); err != nil {
+			t.Fatal(err)
+		}
+		return nonce
+	}
+
+	// Unknown nonces are not found
+	if err := store.ConsumeNonce(context.TODO(), newNonce()); !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unknown nonce, got %v", err)
+	}
+
+	// Nonces may only be consumed once
+	nonce := newNonce()
+	if err := store.AddNonce(context.TODO(), nonce, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ConsumeNonce(context.TODO(), nonce); err != nil {
+		t.Fatalf("error consuming nonce: %v", err)
+	}
+	if err := store.ConsumeNonce(context.TODO(), nonce); !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound consuming nonce twice, got %v", err)
+	}
+
+	// Expired nonces are not found
+	expired := newNonce()
+	if err := store.AddNonce(context.TODO(), expired, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ConsumeNonce(context.TODO(), expired); !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for expired nonce, got %v", err)
+	}
 }
 
 func mustMarshal(t *testing.T, v any) []byte {
@@ -657,3 +1388,8 @@ func newCert(issuer *x509.Certificate, issuerKey crypto.Signer) (*x509.Certifica
 	}
 	return cert, key, nil
 }
+
+func to0RegistrationEqual(a, b fdo.TO0Registration) bool {
+	return a.GUID == b.GUID && a.RvURL == b.RvURL && a.WaitSeconds == b.WaitSeconds &&
+		a.RefreshAt.Equal(b.RefreshAt) && a.Expires.Equal(b.Expires)
+}
@@ -15,11 +15,12 @@ import (
 	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
 	"github.com/fido-device-onboard/go-fdo/custom"
 	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/loopback"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
 // Transport for tests, directly calling the server's responder. No encryption
-// is used, but key exchange is still performed.
+// is used, but key exchange is still performed, unless Encrypt is set.
 type Transport struct {
 	T *testing.T
 
@@ -30,8 +31,14 @@ type Transport struct {
 	TO1Responder *fdo.TO1Server
 	TO2Responder *fdo.TO2Server
 
+	// If Encrypt is true, then messages are encrypted and passed to the
+	// responders with a loopback.Transport.
+	Encrypt bool
+
 	// internal state
 
+	loopback *loopback.Transport
+
 	token   string
 	prevMsg uint8
 }
@@ -44,11 +51,20 @@ func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.S
 	default:
 	}
 
+	if t.Encrypt {
+		return t.dispatch(ctx, msgType, msg, sess)
+	}
+
 	var msgBody bytes.Buffer
 	if err := cbor.NewEncoder(&msgBody).Encode(msg); err != nil {
 		return 0, nil, err
 	}
 
+	// Release the TO2 session, if any, when the device sends an error
+	if msgType == protocol.ErrorMsgType && t.token != "" && protocol.Of(t.prevMsg) == protocol.TO2Protocol {
+		t.TO2Responder.EndSession(t.Tokens.TokenContext(ctx, t.token))
+	}
+
 	if msgType < t.prevMsg || protocol.Of(t.prevMsg) != protocol.Of(msgType) {
 		t.token = ""
 	}
@@ -99,6 +115,34 @@ func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.S
 	return respType, io.NopCloser(&respBody), nil
 }
 
+// dispatch sends a message using a loopback.Transport, which encrypts and
+// decrypts messages when a key exchange session is provided.
+func (t *Transport) dispatch(ctx context.Context, msgType uint8, msg any, sess kex.Session) (uint8, io.ReadCloser, error) {
+	if t.loopback == nil {
+		t.loopback = &loopback.Transport{
+			Tokens:       t.Tokens,
+			DIResponder:  t.DIResponder,
+			TO0Responder: t.TO0Responder,
+			TO1Responder: t.TO1Responder,
+			TO2Responder: t.TO2Responder,
+		}
+	}
+
+	t.T.Logf("Request %d: %v", msgType, tryDebugNotation(msg))
+	respType, rc, err := t.loopback.Send(ctx, msgType, msg, sess)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	resp, err := io.ReadAll(rc)
+	if err != nil {
+		return 0, nil, err
+	}
+	t.T.Logf("Response %d: %v", respType, tryDebugNotation(cbor.RawBytes(resp)))
+
+	return respType, io.NopCloser(bytes.NewReader(resp)), nil
+}
+
 func tryDebugNotation(v any) any {
 	b, err := cbor.Marshal(v)
 	if err != nil {
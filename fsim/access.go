@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// AccessModule is the name of the access provisioning module. It is not
+// defined by the FIDO Alliance.
+//
+// The owner sends an active message, followed by an accounts message
+// containing a CBOR-encoded array of UserAccount, which may be chunked. The
+// device provisions each account it accepts and responds with an ack message
+// containing a CBOR-encoded AccessAck.
+const AccessModule = "go-fdo.access"
+
+// UserAccount is a local account and the SSH keys authorized to log in to it.
+// If the account does not exist, it is created.
+type UserAccount struct {
+	Name string
+
+	// Comment, Shell, and Groups are set when the account is created. Groups
+	// are also added to existing accounts.
+	Comment string
+	Shell   string
+	Groups  []string
+
+	// AuthorizedKeys are lines of an authorized_keys file, i.e.
+	// "ssh-ed25519 AAAA... admin@example.com", which are added to the
+	// account's file if not already present.
+	AuthorizedKeys []string
+}
+
+// AccessAck is the result of provisioning each account.
+type AccessAck struct {
+	Accounts []AccountResult
+}
+
+// AccountResult is the result of provisioning one account.
+type AccountResult struct {
+	Name    string
+	Applied bool
+	Error   string
+}
+
+// AccessProvisioner creates or updates a local account and its authorized
+// SSH keys.
+type AccessProvisioner interface {
+	ProvisionAccount(ctx context.Context, account *UserAccount) error
+}
+
+// Access implements the device side of AccessModule.
+type Access struct {
+	Provisioner AccessProvisioner
+
+	// Accept, if set, is called before provisioning each account and rejects
+	// it with an error, i.e. to refuse root or keys without a from= option.
+	// Other accounts are still provisioned.
+	Accept func(*UserAccount) error
+
+	// ErrorLog is optional and any rejected or failed account will have a
+	// corresponding message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*Access)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (a *Access) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (a *Access) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "accounts" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var accounts []UserAccount
+	if err := cbor.NewDecoder(messageBody).Decode(&accounts); err != nil {
+		return fmt.Errorf("error decoding accounts: %w", err)
+	}
+
+	var ack AccessAck
+	for i := range accounts {
+		result := AccountResult{Name: accounts[i].Name, Applied: true}
+		if err := a.provision(ctx, &accounts[i]); err != nil {
+			if a.ErrorLog != nil {
+				_, _ = fmt.Fprintf(a.ErrorLog, "[%s] account %q: %v\n", AccessModule, accounts[i].Name, err)
+			}
+			result.Applied, result.Error = false, err.Error()
+		}
+		ack.Accounts = append(ack.Accounts, result)
+	}
+	return cbor.NewEncoder(respond("ack")).Encode(ack)
+}
+
+func (a *Access) provision(ctx context.Context, account *UserAccount) error {
+	if err := validateAccount(account); err != nil {
+		return err
+	}
+	if a.Accept != nil {
+		if err := a.Accept(account); err != nil {
+			return fmt.Errorf("account rejected: %w", err)
+		}
+	}
+	if a.Provisioner == nil {
+		return errors.New("no access provisioner")
+	}
+	if err := a.Provisioner.ProvisionAccount(ctx, account); err != nil {
+		return fmt.Errorf("error provisioning account: %w", err)
+	}
+	return nil
+}
+
+// validateAccount rejects values which could not be safely passed to account
+// tools or written to an authorized_keys file.
+func validateAccount(account *UserAccount) error {
+	if account.Name == "" || strings.HasPrefix(account.Name, "-") || strings.ContainsAny(account.Name, ":/\n ") {
+		return fmt.Errorf("invalid account name %q", account.Name)
+	}
+	for _, s := range append([]string{account.Comment, account.Shell}, account.Groups...) {
+		if strings.ContainsAny(s, ":\n") {
+			return fmt.Errorf("invalid account field %q", s)
+		}
+	}
+	for _, key := range account.AuthorizedKeys {
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, "\r\n") {
+			return errors.New("authorized keys must be single, non-empty lines")
+		}
+	}
+	return nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (a *Access) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// ProvisionAccess implements the owner side of AccessModule.
+type ProvisionAccess struct {
+	Accounts []UserAccount
+
+	// MustApply fails TO2 if the device does not provision every account.
+	MustApply bool
+
+	// If set, the acknowledgment will be sent on this channel. It should be
+	// buffered with a size of 1.
+	AckChan chan<- AccessAck
+
+	// Internal state
+	started bool
+	body    []byte
+	done    bool
+}
+
+var _ serviceinfo.OwnerModule = (*ProvisionAccess)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (p *ProvisionAccess) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+
+	case "ack":
+		var ack AccessAck
+		if err := cbor.NewDecoder(messageBody).Decode(&ack); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if p.AckChan != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case p.AckChan <- ack:
+			}
+		}
+		if p.MustApply {
+			if len(ack.Accounts) != len(p.Accounts) {
+				return fmt.Errorf("device acknowledged %d of %d accounts", len(ack.Accounts), len(p.Accounts))
+			}
+			for _, result := range ack.Accounts {
+				if !result.Applied {
+					return fmt.Errorf("device did not provision account %q: %s", result.Name, result.Error)
+				}
+			}
+		}
+		p.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (p *ProvisionAccess) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if p.done {
+		return false, true, nil
+	}
+
+	if !p.started {
+		accounts := p.Accounts
+		if accounts == nil {
+			accounts = []UserAccount{}
+		}
+		body, err := cbor.Marshal(accounts)
+		if err != nil {
+			return false, false, fmt.Errorf("error marshaling accounts: %w", err)
+		}
+		if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+			return false, false, err
+		}
+		p.body = body
+		p.started = true
+	}
+
+	// Authorized keys may be long and require chunking
+	blockPeer, err := writeChunks(producer, "accounts", &p.body)
+	return blockPeer, false, err
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LocalAccounts provisions accounts with the useradd and usermod commands of
+// shadow-utils and writes authorized SSH keys to each account's
+// ~/.ssh/authorized_keys.
+type LocalAccounts struct {
+	// AuthorizedKeysPath, if set, returns the authorized_keys file of an
+	// account, i.e. for an sshd AuthorizedKeysFile outside of home
+	// directories.
+	AuthorizedKeysPath func(*user.User) string
+}
+
+var _ AccessProvisioner = (*LocalAccounts)(nil)
+
+// ProvisionAccount implements AccessProvisioner.
+func (l *LocalAccounts) ProvisionAccount(ctx context.Context, account *UserAccount) error {
+	u, err := user.Lookup(account.Name)
+	var unknown user.UnknownUserError
+	switch {
+	case errors.As(err, &unknown):
+		if err := l.create(ctx, account); err != nil {
+			return err
+		}
+		if u, err = user.Lookup(account.Name); err != nil {
+			return err
+		}
+
+	case err != nil:
+		return err
+
+	case len(account.Groups) > 0:
+		if _, err := runCommand(ctx, nil, "usermod", "--append", "--groups", strings.Join(account.Groups, ","), account.Name); err != nil {
+			return err
+		}
+	}
+
+	if len(account.AuthorizedKeys) == 0 {
+		return nil
+	}
+	path := filepath.Join(u.HomeDir, ".ssh", "authorized_keys")
+	if l.AuthorizedKeysPath != nil {
+		path = l.AuthorizedKeysPath(u)
+	}
+	return addAuthorizedKeys(u, path, account.AuthorizedKeys)
+}
+
+func (l *LocalAccounts) create(ctx context.Context, account *UserAccount) error {
+	args := []string{"--create-home"}
+	if account.Comment != "" {
+		args = append(args, "--comment", account.Comment)
+	}
+	if account.Shell != "" {
+		args = append(args, "--shell", account.Shell)
+	}
+	if len(account.Groups) > 0 {
+		args = append(args, "--groups", strings.Join(account.Groups, ","))
+	}
+	_, err := runCommand(ctx, nil, "useradd", append(args, account.Name)...)
+	return err
+}
+
+// addAuthorizedKeys appends the keys not already in an authorized_keys file
+// and makes the file and its directory owned by the account, as sshd requires
+// with StrictModes.
+func addAuthorizedKeys(u *user.User, path string, keys []string) error {
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q: %w", u.Uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q: %w", u.Gid, err)
+	}
+
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	existing, err := os.ReadFile(path) //nolint:gosec // Path is of an authorized_keys file
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	present := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		present[strings.TrimSpace(scanner.Text())] = true
+	}
+
+	var add strings.Builder
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		add.WriteString("\n")
+	}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if present[key] {
+			continue
+		}
+		present[key] = true
+		add.WriteString(key + "\n")
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) //nolint:gosec // Path is of an authorized_keys file
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(add.String()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := f.Chown(uid, gid); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
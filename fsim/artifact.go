@@ -0,0 +1,472 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ArtifactInfo describes the contents of an artifact.
+type ArtifactInfo struct {
+	// Length of the contents in bytes
+	Length int64
+
+	// ETag identifies the version of the contents. If empty, the digest of
+	// the artifact is not cached.
+	ETag string
+
+	// SHA384 is the digest of the contents, if the provider knows it.
+	SHA384 []byte
+}
+
+// ArtifactProvider provides the contents of files sent with fdo.download, so
+// that large files, such as firmware images, can be streamed from where they
+// are stored rather than staged on the local disk of the owner service.
+type ArtifactProvider interface {
+	// Stat returns the length and version of an artifact. If it does not
+	// exist, an error wrapping fs.ErrNotExist is returned.
+	Stat(ctx context.Context, name string) (*ArtifactInfo, error)
+
+	// Open returns a reader of the contents of an artifact. The reader is
+	// read sequentially and may be used after ctx is done, so long as ctx is
+	// not canceled.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// DirArtifacts provides artifacts from files in a local directory. Names
+// are slash-separated paths relative to the directory, as for [fs.FS].
+type DirArtifacts string
+
+var _ ArtifactProvider = DirArtifacts("")
+
+// Stat implements ArtifactProvider.
+func (dir DirArtifacts) Stat(_ context.Context, name string) (*ArtifactInfo, error) {
+	info, err := fs.Stat(os.DirFS(string(dir)), name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("artifact %q is not a regular file", name)
+	}
+	return &ArtifactInfo{
+		Length: info.Size(),
+		ETag:   strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36),
+	}, nil
+}
+
+// Open implements ArtifactProvider.
+func (dir DirArtifacts) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.DirFS(string(dir)).Open(name)
+}
+
+// HTTPArtifacts provides artifacts from an HTTP server. Names are resolved
+// as paths relative to BaseURL.
+//
+// If the server responds to HEAD requests with a Repr-Digest header with a
+// sha-384 digest (RFC 9530), the contents are not read to compute it.
+type HTTPArtifacts struct {
+	BaseURL *url.URL
+
+	// Header is added to every request, i.e. for authorization.
+	Header http.Header
+
+	// Client is used for requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+var _ ArtifactProvider = (*HTTPArtifacts)(nil)
+
+// Stat implements ArtifactProvider.
+func (h *HTTPArtifacts) Stat(ctx context.Context, name string) (*ArtifactInfo, error) {
+	req, err := h.newRequest(ctx, http.MethodHead, name)
+	if err != nil {
+		return nil, err
+	}
+	return statArtifact(h.Client, req, name)
+}
+
+// Open implements ArtifactProvider.
+func (h *HTTPArtifacts) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := h.newRequest(ctx, http.MethodGet, name)
+	if err != nil {
+		return nil, err
+	}
+	return openArtifact(h.Client, req, name)
+}
+
+func (h *HTTPArtifacts) newRequest(ctx context.Context, method, name string) (*http.Request, error) {
+	if h.BaseURL == nil {
+		return nil, errors.New("artifact base URL not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.BaseURL.JoinPath(name).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, vals := range h.Header {
+		req.Header[key] = vals
+	}
+	return req, nil
+}
+
+func statArtifact(client *http.Client, req *http.Request, name string) (*ArtifactInfo, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting artifact %q: %w", name, err)
+	}
+	_ = resp.Body.Close()
+	if err := artifactStatus(resp, name); err != nil {
+		return nil, err
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("artifact %q has unknown length", name)
+	}
+	return &ArtifactInfo{
+		Length: resp.ContentLength,
+		ETag:   resp.Header.Get("ETag"),
+		SHA384: reprDigest(resp.Header.Get("Repr-Digest")),
+	}, nil
+}
+
+func openArtifact(client *http.Client, req *http.Request, name string) (io.ReadCloser, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting artifact %q: %w", name, err)
+	}
+	if err := artifactStatus(resp, name); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func artifactStatus(resp *http.Response, name string) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("artifact %q: %w", name, fs.ErrNotExist)
+	default:
+		return fmt.Errorf("error requesting artifact %q: %s", name, resp.Status)
+	}
+}
+
+// reprDigest returns the sha-384 digest of a Repr-Digest header, or nil.
+func reprDigest(header string) []byte {
+	for _, field := range strings.Split(header, ",") {
+		alg, val, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || alg != "sha-384" || len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+		if err != nil || len(sum) != sha512.Size384 {
+			return nil
+		}
+		return sum
+	}
+	return nil
+}
+
+// ArtifactDigests caches the SHA-384 digests of artifacts by name and ETag,
+// so that an unchanged artifact is read once to compute its digest rather
+// than once for every device. A cache should only be used with a single
+// provider. It is safe for concurrent use.
+type ArtifactDigests struct {
+	mu      sync.Mutex
+	digests map[artifactVersion][]byte
+}
+
+type artifactVersion struct {
+	name string
+	etag string
+}
+
+// Digest returns the SHA-384 digest of an artifact, reading its contents
+// only if the digest is neither known by the provider nor cached.
+func (c *ArtifactDigests) Digest(ctx context.Context, provider ArtifactProvider, name string, info *ArtifactInfo) ([]byte, error) {
+	if info.SHA384 != nil {
+		return info.SHA384, nil
+	}
+
+	key := artifactVersion{name: name, etag: info.ETag}
+	if c != nil && info.ETag != "" {
+		c.mu.Lock()
+		sum, ok := c.digests[key]
+		c.mu.Unlock()
+		if ok {
+			return sum, nil
+		}
+	}
+
+	r, err := provider.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	hash := sha512.New384()
+	if n, err := io.Copy(hash, r); err != nil {
+		return nil, fmt.Errorf("error reading contents of %q: %w", name, err)
+	} else if n != info.Length {
+		return nil, fmt.Errorf("artifact %q changed while computing digest", name)
+	}
+	sum := hash.Sum(nil)
+
+	if c != nil && info.ETag != "" {
+		c.mu.Lock()
+		if c.digests == nil {
+			c.digests = make(map[artifactVersion][]byte)
+		}
+		c.digests[key] = sum
+		c.mu.Unlock()
+	}
+	return sum, nil
+}
+
+// DownloadArtifact implements an owner module for fdo.download which streams
+// the contents of an artifact from a provider. Unlike DownloadContents, the
+// contents are read sequentially, so they need not be seekable, but an
+// interrupted download is sent again from the start.
+type DownloadArtifact struct {
+	// Name of the file on the device
+	Name string
+
+	// Artifact is the name of the artifact in Provider. If empty, Name is
+	// used.
+	Artifact string
+	Provider ArtifactProvider
+
+	// Digests, if set, caches the digests of artifacts between downloads.
+	Digests *ArtifactDigests
+
+	MustDownload bool
+	// Defaults to 1014, by spec
+	ChunkSize int
+
+	// internal state
+	started  bool
+	contents io.ReadCloser
+	chunk    []byte
+	pending  []byte
+	index    int64
+	length   int64
+	done     bool
+	closed   bool
+}
+
+var _ serviceinfo.ResumableOwnerModule = (*DownloadArtifact)(nil)
+
+// Checkpoint implements serviceinfo.ResumableOwnerModule.
+func (d *DownloadArtifact) Checkpoint() ([]byte, error) {
+	return cbor.Marshal(downloadState{Done: d.done})
+}
+
+// Resume implements serviceinfo.ResumableOwnerModule.
+func (d *DownloadArtifact) Resume(state []byte) error {
+	var s downloadState
+	if err := cbor.Unmarshal(state, &s); err != nil {
+		return fmt.Errorf("error decoding download state: %w", err)
+	}
+	d.done = s.Done
+	return nil
+}
+
+func (d *DownloadArtifact) artifact() string {
+	if d.Artifact != "" {
+		return d.Artifact
+	}
+	return d.Name
+}
+
+// Close closes the contents of the artifact, if it is being read. It is
+// called once the device has received the contents and by TO2 servers when
+// the session ends. The artifact is not read again after it is closed.
+func (d *DownloadArtifact) Close() error {
+	d.closed = true
+	if d.contents == nil {
+		return nil
+	}
+	err := d.contents.Close()
+	d.contents = nil
+	return err
+}
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (d *DownloadArtifact) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+
+	case "done":
+		_ = d.Close()
+		var errCode int64
+		if err := cbor.NewDecoder(messageBody).Decode(&errCode); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if errCode == -1 && d.MustDownload {
+			return fmt.Errorf("device failed to download %q", d.Name)
+		}
+		if errCode != -1 && errCode != d.length {
+			return fmt.Errorf("device downloaded %d bytes, expected %d", errCode, d.length)
+		}
+		d.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (d *DownloadArtifact) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if d.done {
+		return false, true, nil
+	}
+	if d.Provider == nil {
+		return false, false, fmt.Errorf("no artifact provider for %q", d.Name)
+	}
+
+	// Messages are produced over many requests, so the contents must not be
+	// closed when the context of the first one is done
+	ctx = context.WithoutCancel(ctx)
+
+	if d.started {
+		return d.sendData(ctx, producer)
+	}
+
+	info, err := d.Provider.Stat(ctx, d.artifact())
+	if err != nil {
+		return false, false, fmt.Errorf("error getting artifact for %q: %w", d.Name, err)
+	}
+	sum, err := d.Digests.Digest(ctx, d.Provider, d.artifact(), info)
+	if err != nil {
+		return false, false, fmt.Errorf("error computing digest of %q: %w", d.Name, err)
+	}
+	if err := sendDownloadHeader(producer, d.Name, info.Length, sum); err != nil {
+		return false, false, err
+	}
+
+	d.chunk = make([]byte, downloadChunkSize(d.ChunkSize))
+	d.length = info.Length
+	d.started = true
+	return false, false, nil
+}
+
+func (d *DownloadArtifact) sendData(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	const messageName = "data"
+
+	if d.pending == nil {
+		if d.index >= d.length {
+			return false, false, nil
+		}
+		if d.contents == nil {
+			// Contents are only opened once, since reopening would restart
+			// them while the device continues at index
+			if d.closed {
+				return false, false, fmt.Errorf("contents of %q were closed after %d of %d bytes", d.Name, d.index, d.length)
+			}
+			r, err := d.Provider.Open(ctx, d.artifact())
+			if err != nil {
+				return false, false, fmt.Errorf("error opening contents of %q: %w", d.Name, err)
+			}
+			d.contents = r
+		}
+
+		available := producer.Available(messageName) - 6 // 3 for each byte array (double-encoded)
+		if available < 1 {
+			return false, false, fmt.Errorf("not enough buffer space to send data chunk service info")
+		}
+		n, err := d.contents.Read(d.chunk[:min(int64(available), int64(len(d.chunk)), d.length-d.index)])
+		if err != nil && err != io.EOF {
+			return false, false, fmt.Errorf("error reading chunk of %q contents: %w", d.Name, err)
+		} else if n == 0 && err == io.EOF {
+			return false, false, fmt.Errorf("contents of %q ended after %d bytes, expected %d", d.Name, d.index, d.length)
+		} else if n == 0 {
+			return false, false, nil
+		}
+		d.index += int64(n)
+		d.pending = d.chunk[:n]
+	}
+
+	// Marshal chunk
+	messageBody, err := cbor.Marshal(d.pending)
+	if err != nil {
+		return false, false, err
+	}
+
+	// Check that there's enough space to send the message, otherwise send it
+	// in the next message
+	if len(messageBody) > producer.Available(messageName) {
+		return false, false, nil
+	}
+
+	// Write the message
+	d.pending = nil
+	return false, false, producer.WriteChunk(messageName, messageBody)
+}
+
+// sendDownloadHeader writes the fdo.download messages preceding data.
+func sendDownloadHeader(producer *serviceinfo.Producer, name string, length int64, sha384 []byte) error {
+	messageVal := map[string]any{
+		"active":  true,
+		"name":    name,
+		"length":  length,
+		"sha-384": sha384,
+	}
+	for _, messageName := range []string{"active", "name", "length", "sha-384"} {
+		messageBody, err := cbor.Marshal(messageVal[messageName])
+		if err != nil {
+			return err
+		}
+
+		// Check that there's enough space to send the message
+		if len(messageBody) > producer.Available(messageName) {
+			return fmt.Errorf("not enough buffer space to send non-data service info")
+		}
+
+		// Write the message
+		if err := producer.WriteChunk(messageName, messageBody); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadChunkSize returns the maximum size of data messages.
+func downloadChunkSize(chunkSize int) int {
+	switch {
+	case chunkSize > 0:
+		return chunkSize
+	case chunkSize < 0:
+		return (1 << 16) - 1
+	default:
+		return 1014
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Artifacts provides artifacts from objects in a bucket of an
+// S3-compatible object store. Requests are signed with AWS Signature
+// Version 4 and use path-style URLs.
+//
+// Google Cloud Storage may be used with an Endpoint of
+// https://storage.googleapis.com, a Region of "auto", and HMAC keys.
+type S3Artifacts struct {
+	// Endpoint of the object store. If nil, the AWS endpoint of Region is
+	// used.
+	Endpoint *url.URL
+	Region   string
+	Bucket   string
+
+	// Prefix is prepended to artifact names to form object keys.
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client is used for requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+var _ ArtifactProvider = (*S3Artifacts)(nil)
+
+// Stat implements ArtifactProvider.
+func (s *S3Artifacts) Stat(ctx context.Context, name string) (*ArtifactInfo, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, name)
+	if err != nil {
+		return nil, err
+	}
+	return statArtifact(s.Client, req, name)
+}
+
+// Open implements ArtifactProvider.
+func (s *S3Artifacts) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, name)
+	if err != nil {
+		return nil, err
+	}
+	return openArtifact(s.Client, req, name)
+}
+
+func (s *S3Artifacts) newRequest(ctx context.Context, method, name string) (*http.Request, error) {
+	if s.Bucket == "" || s.Region == "" {
+		return nil, errors.New("S3 bucket and region are required")
+	}
+	endpoint := s.Endpoint
+	if endpoint == nil {
+		endpoint = &url.URL{Scheme: "https", Host: "s3." + s.Region + ".amazonaws.com"}
+	}
+	objectPath := "/" + s.Bucket + "/" + s.Prefix + name
+	u := *endpoint
+	u.Path = strings.TrimSuffix(endpoint.Path, "/") + objectPath
+	u.RawPath = strings.TrimSuffix(endpoint.EscapedPath(), "/") + awsEscapePath(objectPath)
+	u.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now())
+	return req, nil
+}
+
+// emptySHA256 is the hex-encoded SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 authorization to a request without a
+// body or query.
+func (s *S3Artifacts) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + s.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	// Headers are signed in sorted order
+	headers := [][2]string{
+		{"host", req.URL.Host},
+		{"x-amz-content-sha256", emptySHA256},
+		{"x-amz-date", amzDate},
+	}
+	if s.SessionToken != "" {
+		headers = append(headers, [2]string{"x-amz-security-token", s.SessionToken})
+	}
+	var canonicalHeaders, signedHeaders strings.Builder
+	for i, h := range headers {
+		canonicalHeaders.WriteString(h[0] + ":" + strings.TrimSpace(h[1]) + "\n")
+		if i > 0 {
+			signedHeaders.WriteByte(';')
+		}
+		signedHeaders.WriteString(h[0])
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // query
+		canonicalHeaders.String(),
+		signedHeaders.String(),
+		emptySHA256,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], s.Region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders.String(), hex.EncodeToString(key)))
+}
+
+// awsEscapePath percent-encodes all bytes of a path except unreserved
+// characters and slashes, as required for canonical requests.
+func awsEscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// AttemptLogModule is the name of the onboarding attempt log module. It is
+// not defined by the FIDO Alliance.
+//
+// The owner sends an active message, followed by a request message containing
+// true. The device responds with an attempts message containing a
+// CBOR-encoded array of fdo.OnboardingAttempt, which may be split across
+// several rounds, followed by a done message containing true. If the device
+// cannot read its log, it responds with an error message containing a
+// CBOR-encoded tstr instead.
+const AttemptLogModule = "go-fdo.attempts"
+
+// AttemptLog implements the device side of AttemptLogModule.
+type AttemptLog struct {
+	// Attempts returns the logged onboarding attempts, such as with the
+	// Attempts method of an fdo.AttemptLogStore.
+	Attempts func() ([]fdo.OnboardingAttempt, error)
+
+	// ErrorLog is optional and any failure to read the log will have a
+	// corresponding message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*AttemptLog)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (a *AttemptLog) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (a *AttemptLog) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "request" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var request bool
+	if err := cbor.NewDecoder(messageBody).Decode(&request); err != nil {
+		return fmt.Errorf("error decoding request: %w", err)
+	}
+	if !request {
+		return nil
+	}
+
+	attempts, err := a.attempts()
+	if err != nil {
+		if a.ErrorLog != nil {
+			_, _ = fmt.Fprintf(a.ErrorLog, "[%s] %v\n", AttemptLogModule, err)
+		}
+		return serviceinfo.RespondError(respond, err)
+	}
+	if err := cbor.NewEncoder(respond("attempts")).Encode(attempts); err != nil {
+		return err
+	}
+	return cbor.NewEncoder(respond("done")).Encode(true)
+}
+
+func (a *AttemptLog) attempts() ([]fdo.OnboardingAttempt, error) {
+	if a.Attempts == nil {
+		return nil, errors.New("no attempt log")
+	}
+	attempts, err := a.Attempts()
+	if err != nil {
+		return nil, fmt.Errorf("error reading attempt log: %w", err)
+	}
+	if attempts == nil {
+		attempts = []fdo.OnboardingAttempt{}
+	}
+	return attempts, nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (a *AttemptLog) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// CollectAttemptLog implements the owner side of AttemptLogModule. The log is
+// diagnostic, so a device which cannot send it does not fail TO2.
+type CollectAttemptLog struct {
+	// Handle is called with the attempts logged by the device, oldest first,
+	// such as to store them with the onboarding history of the device. An
+	// error fails TO2.
+	Handle func(ctx context.Context, attempts []fdo.OnboardingAttempt) error
+
+	// ErrorLog is optional and any error reported by the device will have a
+	// corresponding message written.
+	ErrorLog io.Writer
+
+	// Internal state
+	requested bool
+	attempts  bytes.Buffer
+	done      bool
+}
+
+var _ serviceinfo.OwnerModule = (*CollectAttemptLog)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (c *CollectAttemptLog) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			// Devices without the module have no log to send
+			c.done = true
+		}
+		return nil
+
+	case "attempts":
+		// The log may exceed the MTU and be received over several rounds
+		if !c.requested {
+			return errors.New("attempts received before they were requested")
+		}
+		if _, err := c.attempts.ReadFrom(messageBody); err != nil {
+			return fmt.Errorf("error reading message %s: %w", messageName, err)
+		}
+		return nil
+
+	case "done":
+		var done bool
+		if err := cbor.NewDecoder(messageBody).Decode(&done); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		var attempts []fdo.OnboardingAttempt
+		if err := cbor.Unmarshal(c.attempts.Bytes(), &attempts); err != nil {
+			return fmt.Errorf("error decoding attempts: %w", err)
+		}
+		c.done = true
+		if c.Handle == nil {
+			return nil
+		}
+		return c.Handle(ctx, attempts)
+
+	case serviceinfo.ErrorMessageName:
+		modErr, err := serviceinfo.DecodeError(AttemptLogModule, messageBody)
+		if err != nil {
+			return err
+		}
+		if c.ErrorLog != nil {
+			_, _ = fmt.Fprintf(c.ErrorLog, "[%s] device could not send attempt log: %v\n", AttemptLogModule, modErr)
+		}
+		c.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (c *CollectAttemptLog) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if c.done {
+		return false, true, nil
+	}
+	if c.requested {
+		return false, false, nil
+	}
+	if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+		return false, false, err
+	}
+	if err := producer.WriteChunk("request", []byte{0xf5}); err != nil {
+		return false, false, err
+	}
+	c.requested = true
+	return false, false, nil
+}
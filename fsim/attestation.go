@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// AttestationModule is the name of the platform attestation module. It is not
+// defined by the FIDO Alliance.
+//
+// The owner sends an active message, followed by a nonce message containing
+// a CBOR-encoded bstr. The device responds with an evidence message
+// containing a CBOR-encoded AttestationEvidence, which may be split across
+// several rounds, followed by a done message containing true. If the device
+// cannot produce evidence, it responds with an error message containing a
+// CBOR-encoded tstr instead.
+const AttestationModule = "go-fdo.attestation"
+
+// AttestationNonceSize is the size of the nonce the owner sends.
+const AttestationNonceSize = 32
+
+// AttestationEvidence is platform integrity evidence, such as a TPM quote
+// created with the nonce as its qualifying data. The fields match tpm.Quote,
+// so that evidence may be created with tpm.QuotePCRs and verified with
+// tpm.VerifyQuote.
+type AttestationEvidence struct {
+	// Quote is the marshaled TPMS_ATTEST of a TPM2_Quote.
+	Quote []byte
+
+	// Signature is the marshaled TPMT_SIGNATURE of Quote.
+	Signature []byte
+
+	// PCRs are the values of the quoted SHA-256 PCRs.
+	PCRs map[int][]byte
+
+	// AttestationKey is the PKIX, ASN.1 DER public key which signed Quote.
+	AttestationKey []byte
+
+	// EventLog is the binary TCG event log of measured boot, if any.
+	EventLog []byte
+}
+
+// Attester produces attestation evidence for a nonce.
+type Attester interface {
+	Attest(ctx context.Context, nonce []byte) (*AttestationEvidence, error)
+}
+
+// AttesterFunc adapts a function to an Attester.
+type AttesterFunc func(ctx context.Context, nonce []byte) (*AttestationEvidence, error)
+
+// Attest implements Attester.
+func (f AttesterFunc) Attest(ctx context.Context, nonce []byte) (*AttestationEvidence, error) {
+	return f(ctx, nonce)
+}
+
+// Attestation implements the device side of AttestationModule.
+type Attestation struct {
+	Attester Attester
+
+	// ErrorLog is optional and any failure to produce evidence will have a
+	// corresponding message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*Attestation)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (a *Attestation) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (a *Attestation) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "nonce" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var nonce []byte
+	if err := cbor.NewDecoder(messageBody).Decode(&nonce); err != nil {
+		return fmt.Errorf("error decoding nonce: %w", err)
+	}
+
+	evidence, err := a.attest(ctx, nonce)
+	if err != nil {
+		if a.ErrorLog != nil {
+			_, _ = fmt.Fprintf(a.ErrorLog, "[%s] %v\n", AttestationModule, err)
+		}
+		return serviceinfo.RespondError(respond, err)
+	}
+	if err := cbor.NewEncoder(respond("evidence")).Encode(evidence); err != nil {
+		return err
+	}
+	return cbor.NewEncoder(respond("done")).Encode(true)
+}
+
+func (a *Attestation) attest(ctx context.Context, nonce []byte) (*AttestationEvidence, error) {
+	if len(nonce) != AttestationNonceSize {
+		return nil, fmt.Errorf("invalid nonce size: %d", len(nonce))
+	}
+	if a.Attester == nil {
+		return nil, errors.New("no attester")
+	}
+	evidence, err := a.Attester.Attest(ctx, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error producing evidence: %w", err)
+	}
+	return evidence, nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (a *Attestation) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// VerifyAttestation implements the owner side of AttestationModule. Any
+// failure fails TO2, so owner modules after it only deliver their payloads to
+// devices with verified platform integrity.
+type VerifyAttestation struct {
+	// Verify checks that evidence is fresh, signed by a key trusted for the
+	// device, and describes an acceptable platform, i.e. with
+	// tpm.VerifyQuote, tpm.ReplayEventLog, and golden PCR values.
+	Verify func(ctx context.Context, nonce []byte, evidence *AttestationEvidence) error
+
+	// Internal state
+	nonce    []byte
+	evidence bytes.Buffer
+	done     bool
+}
+
+var _ serviceinfo.OwnerModule = (*VerifyAttestation)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (v *VerifyAttestation) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+
+	case "evidence":
+		// Evidence may exceed the MTU and be received over several rounds
+		if v.nonce == nil {
+			return errors.New("evidence received before nonce was sent")
+		}
+		if _, err := v.evidence.ReadFrom(messageBody); err != nil {
+			return fmt.Errorf("error reading message %s: %w", messageName, err)
+		}
+		return nil
+
+	case "done":
+		var done bool
+		if err := cbor.NewDecoder(messageBody).Decode(&done); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		var evidence AttestationEvidence
+		if err := cbor.Unmarshal(v.evidence.Bytes(), &evidence); err != nil {
+			return fmt.Errorf("error decoding evidence: %w", err)
+		}
+		if v.Verify == nil {
+			return errors.New("no attestation verifier")
+		}
+		if err := v.Verify(ctx, v.nonce, &evidence); err != nil {
+			return fmt.Errorf("platform attestation failed: %w", err)
+		}
+		v.done = true
+		return nil
+
+	case serviceinfo.ErrorMessageName:
+		modErr, err := serviceinfo.DecodeError(AttestationModule, messageBody)
+		if err != nil {
+			return err
+		}
+		// Attestation is required, so the error is fatal even if the device
+		// reported it as recoverable
+		return fmt.Errorf("device could not produce attestation evidence: %v", modErr)
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (v *VerifyAttestation) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if v.done {
+		return false, true, nil
+	}
+	if v.nonce != nil {
+		return false, false, nil
+	}
+
+	nonce := make([]byte, AttestationNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return false, false, fmt.Errorf("error generating nonce: %w", err)
+	}
+	body, err := cbor.Marshal(nonce)
+	if err != nil {
+		return false, false, err
+	}
+	if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+		return false, false, err
+	}
+	if err := producer.WriteChunk("nonce", body); err != nil {
+		return false, false, err
+	}
+	v.nonce = nonce
+	return false, false, nil
+}
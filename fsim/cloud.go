@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// CloudModule is the name of the cloud hand-off module. It is not defined by
+// the FIDO Alliance.
+//
+// The owner sends an active message, followed by a credentials message
+// containing a CBOR-encoded CloudCredentials, which may be chunked. The
+// device hands the credentials to the agent of the cloud provider and
+// responds with a status message containing a CBOR-encoded CloudStatus.
+const CloudModule = "go-fdo.cloud"
+
+// Cloud providers of CloudCredentials
+const (
+	CloudAzureDPS = "azure-dps"
+	CloudAWSIoT   = "aws-iot"
+	CloudToken    = "token"
+)
+
+// CloudCredentials are the bootstrap credentials of a cloud provider. Only
+// the field matching the provider is set.
+type CloudCredentials struct {
+	Provider string
+
+	Azure *AzureDPSCredentials
+	AWS   *AWSIoTCredentials
+	Token *CloudTokenCredentials
+}
+
+// AzureDPSCredentials are for Azure IoT Hub Device Provisioning Service
+// symmetric key attestation.
+type AzureDPSCredentials struct {
+	// GlobalEndpoint defaults to the public cloud's global endpoint.
+	GlobalEndpoint string
+	IDScope        string
+	RegistrationID string
+
+	// SymmetricKey is the device key, i.e. derived from an enrollment group
+	// key.
+	SymmetricKey []byte
+}
+
+// AWSIoTCredentials are for AWS IoT fleet provisioning by claim.
+type AWSIoTCredentials struct {
+	// Endpoint is the account's IoT data endpoint.
+	Endpoint string
+
+	// Template is the name of the fleet provisioning template.
+	Template string
+
+	// ClaimCert, ClaimKey, and RootCA are PEM-encoded.
+	ClaimCert []byte
+	ClaimKey  []byte
+	RootCA    []byte
+}
+
+// CloudTokenCredentials are for clouds which register devices with a device
+// ID and secret token, such as the Arduino IoT Cloud.
+type CloudTokenCredentials struct {
+	Endpoint string
+	DeviceID string
+	Secret   string
+}
+
+// CloudStatus is the result of handing off cloud credentials.
+type CloudStatus struct {
+	// Stored is true when the agent saved the credentials.
+	Stored bool
+
+	// Registered is true when the agent registered the device with the
+	// cloud. It is false if the agent does not register devices itself.
+	Registered bool
+
+	Error string
+}
+
+// CloudAgent stores the bootstrap credentials of a cloud provider and
+// optionally registers the device. An error is reported in the status, so an
+// agent should only return an error when the credentials could not be
+// stored.
+type CloudAgent interface {
+	Bootstrap(ctx context.Context, creds *CloudCredentials) (*CloudStatus, error)
+}
+
+// Cloud implements the device side of CloudModule.
+type Cloud struct {
+	// Agents maps cloud providers to their agents. Credentials of other
+	// providers are rejected.
+	Agents map[string]CloudAgent
+
+	// ErrorLog is optional and any failed hand-off will have a corresponding
+	// message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*Cloud)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (c *Cloud) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (c *Cloud) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "credentials" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var creds CloudCredentials
+	if err := cbor.NewDecoder(messageBody).Decode(&creds); err != nil {
+		return fmt.Errorf("error decoding cloud credentials: %w", err)
+	}
+
+	status, err := c.bootstrap(ctx, &creds)
+	if err != nil {
+		status = &CloudStatus{Error: err.Error()}
+	}
+	if status.Error != "" && c.ErrorLog != nil {
+		_, _ = fmt.Fprintf(c.ErrorLog, "[%s] %s: %s\n", CloudModule, creds.Provider, status.Error)
+	}
+	return cbor.NewEncoder(respond("status")).Encode(status)
+}
+
+func (c *Cloud) bootstrap(ctx context.Context, creds *CloudCredentials) (*CloudStatus, error) {
+	agent, ok := c.Agents[creds.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cloud provider %q", creds.Provider)
+	}
+	var missing bool
+	switch creds.Provider {
+	case CloudAzureDPS:
+		missing = creds.Azure == nil
+	case CloudAWSIoT:
+		missing = creds.AWS == nil
+	case CloudToken:
+		missing = creds.Token == nil
+	}
+	if missing {
+		return nil, errors.New("credentials missing for provider")
+	}
+	status, err := agent.Bootstrap(ctx, creds)
+	if err != nil {
+		return nil, fmt.Errorf("error handing off credentials: %w", err)
+	}
+	return status, nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (c *Cloud) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// ProvisionCloud implements the owner side of CloudModule.
+type ProvisionCloud struct {
+	Credentials CloudCredentials
+
+	// MustApply fails TO2 if the device reports an error storing the
+	// credentials or registering.
+	MustApply bool
+
+	// If set, the status will be sent on this channel. It should be buffered
+	// with a size of 1.
+	StatusChan chan<- CloudStatus
+
+	// Internal state
+	started bool
+	body    []byte
+	done    bool
+}
+
+var _ serviceinfo.OwnerModule = (*ProvisionCloud)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (p *ProvisionCloud) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+
+	case "status":
+		var status CloudStatus
+		if err := cbor.NewDecoder(messageBody).Decode(&status); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if p.StatusChan != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case p.StatusChan <- status:
+			}
+		}
+		if p.MustApply && (!status.Stored || status.Error != "") {
+			return fmt.Errorf("device did not complete cloud hand-off: %s", status.Error)
+		}
+		p.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (p *ProvisionCloud) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if p.done {
+		return false, true, nil
+	}
+
+	if !p.started {
+		body, err := cbor.Marshal(p.Credentials)
+		if err != nil {
+			return false, false, fmt.Errorf("error marshaling cloud credentials: %w", err)
+		}
+		if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+			return false, false, err
+		}
+		p.body = body
+		p.started = true
+	}
+
+	// Certificates may be long and require chunking
+	blockPeer, err := writeChunks(producer, "credentials", &p.body)
+	return blockPeer, false, err
+}
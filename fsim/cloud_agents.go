@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AzureDPSAgent configures the Azure IoT Identity Service to provision the
+// device with DPS.
+type AzureDPSAgent struct {
+	// ConfigPath defaults to /etc/aziot/config.toml.
+	ConfigPath string
+
+	// Command, if set, registers the device after the configuration is
+	// written, i.e. "aziotctl config apply".
+	Command []string
+}
+
+var _ CloudAgent = (*AzureDPSAgent)(nil)
+
+// Bootstrap implements CloudAgent.
+func (a *AzureDPSAgent) Bootstrap(ctx context.Context, creds *CloudCredentials) (*CloudStatus, error) {
+	azure := creds.Azure
+	if azure == nil || azure.IDScope == "" || azure.RegistrationID == "" || len(azure.SymmetricKey) == 0 {
+		return nil, errors.New("azure DPS credentials require an ID scope, registration ID, and symmetric key")
+	}
+	endpoint := azure.GlobalEndpoint
+	if endpoint == "" {
+		endpoint = "https://global.azure-devices-provisioning.net"
+	}
+
+	// Quoted strings are valid TOML basic strings
+	config := fmt.Sprintf(`[provisioning]
+source = "dps"
+global_endpoint = %s
+id_scope = %s
+
+[provisioning.attestation]
+method = "symmetric_key"
+registration_id = %s
+symmetric_key = { value = %s }
+`, strconv.Quote(endpoint), strconv.Quote(azure.IDScope), strconv.Quote(azure.RegistrationID),
+		strconv.Quote(base64.StdEncoding.EncodeToString(azure.SymmetricKey)))
+
+	path := a.ConfigPath
+	if path == "" {
+		path = "/etc/aziot/config.toml"
+	}
+	if err := writeSecret(path, []byte(config)); err != nil {
+		return nil, err
+	}
+	return register(ctx, a.Command, nil), nil
+}
+
+// AWSIoTAgent stores AWS IoT claim credentials for fleet provisioning, such
+// as by the Greengrass fleet provisioning plugin or the AWS IoT Device
+// Client.
+type AWSIoTAgent struct {
+	// Dir is where claim.pem.crt, claim.pem.key, and root.ca.pem are
+	// written. It defaults to /etc/aws-iot.
+	Dir string
+
+	// Command, if set, registers the device after the credentials are
+	// written. The environment contains AWS_IOT_ENDPOINT, AWS_IOT_TEMPLATE,
+	// AWS_IOT_CLAIM_CERT, AWS_IOT_CLAIM_KEY, and AWS_IOT_ROOT_CA, the last
+	// three being paths.
+	Command []string
+}
+
+var _ CloudAgent = (*AWSIoTAgent)(nil)
+
+// Bootstrap implements CloudAgent.
+func (a *AWSIoTAgent) Bootstrap(ctx context.Context, creds *CloudCredentials) (*CloudStatus, error) {
+	aws := creds.AWS
+	if aws == nil || aws.Endpoint == "" || len(aws.ClaimCert) == 0 || len(aws.ClaimKey) == 0 {
+		return nil, errors.New("aws IoT credentials require an endpoint, claim certificate, and claim key")
+	}
+
+	dir := a.Dir
+	if dir == "" {
+		dir = "/etc/aws-iot"
+	}
+	certPath := filepath.Join(dir, "claim.pem.crt")
+	keyPath := filepath.Join(dir, "claim.pem.key")
+	caPath := filepath.Join(dir, "root.ca.pem")
+	for path, contents := range map[string][]byte{certPath: aws.ClaimCert, keyPath: aws.ClaimKey, caPath: aws.RootCA} {
+		if len(contents) == 0 {
+			continue
+		}
+		if err := writeSecret(path, contents); err != nil {
+			return nil, err
+		}
+	}
+
+	env := []string{
+		"AWS_IOT_ENDPOINT=" + aws.Endpoint,
+		"AWS_IOT_TEMPLATE=" + aws.Template,
+		"AWS_IOT_CLAIM_CERT=" + certPath,
+		"AWS_IOT_CLAIM_KEY=" + keyPath,
+	}
+	if len(aws.RootCA) > 0 {
+		env = append(env, "AWS_IOT_ROOT_CA="+caPath)
+	}
+	return register(ctx, a.Command, env), nil
+}
+
+// TokenAgent stores device ID and secret token credentials as an environment
+// file with CLOUD_ENDPOINT, CLOUD_DEVICE_ID, and CLOUD_SECRET variables.
+type TokenAgent struct {
+	Path string
+
+	// Command, if set, registers the device after the credentials are
+	// written. The environment contains the variables of the file.
+	Command []string
+}
+
+var _ CloudAgent = (*TokenAgent)(nil)
+
+// Bootstrap implements CloudAgent.
+func (a *TokenAgent) Bootstrap(ctx context.Context, creds *CloudCredentials) (*CloudStatus, error) {
+	token := creds.Token
+	if token == nil || token.DeviceID == "" || token.Secret == "" {
+		return nil, errors.New("token credentials require a device ID and secret")
+	}
+	if a.Path == "" {
+		return nil, errors.New("token credentials path not set")
+	}
+
+	env := []string{
+		"CLOUD_ENDPOINT=" + token.Endpoint,
+		"CLOUD_DEVICE_ID=" + token.DeviceID,
+		"CLOUD_SECRET=" + token.Secret,
+	}
+	var file strings.Builder
+	for _, v := range env {
+		if strings.ContainsAny(v, "\n\"") {
+			return nil, errors.New("token credentials must not contain quotes or newlines")
+		}
+		name, value, _ := strings.Cut(v, "=")
+		fmt.Fprintf(&file, "%s=\"%s\"\n", name, value)
+	}
+	if err := writeSecret(a.Path, []byte(file.String())); err != nil {
+		return nil, err
+	}
+	return register(ctx, a.Command, env), nil
+}
+
+// writeSecret writes a file readable only by its owner, creating its
+// directory if needed.
+func writeSecret(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, contents, 0o600); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// register runs the registration command of an agent, if any, after the
+// credentials are stored.
+func register(ctx context.Context, command, env []string) *CloudStatus {
+	if len(command) == 0 {
+		return &CloudStatus{Stored: true}
+	}
+	if _, err := runCommandEnv(ctx, nil, env, command[0], command[1:]...); err != nil {
+		return &CloudStatus{Stored: true, Error: err.Error()}
+	}
+	return &CloudStatus{Stored: true, Registered: true}
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ContainerModule is the name of the container bootstrap module. It is not
+// defined by the FIDO Alliance.
+//
+// The owner sends an active message, followed by a bootstrap message
+// containing a CBOR-encoded ContainerBootstrap, which may be chunked. The
+// device runs its container runtime hook and responds with a status message
+// containing a CBOR-encoded ContainerStatus.
+const ContainerModule = "go-fdo.container"
+
+// ContainerBootstrap is either a compose application to run or a cluster for
+// the device to join as a node.
+type ContainerBootstrap struct {
+	// Project names the compose application.
+	Project string
+
+	// Compose is the contents of a compose file, i.e. compose.yaml.
+	Compose []byte
+
+	// Join is set to join a Kubernetes cluster rather than run a compose
+	// application.
+	Join *ClusterJoin
+}
+
+// ClusterJoin is the information needed for a device to join a Kubernetes
+// cluster, such as with k3s or kubeadm.
+type ClusterJoin struct {
+	// Server is the URL of the cluster's API server.
+	Server string
+
+	// Token is the secret join token of the cluster.
+	Token string
+
+	// Labels are added to the node, i.e. "site=plant-1".
+	Labels []string
+}
+
+// ContainerStatus is the result of bootstrapping containers.
+type ContainerStatus struct {
+	// Ready is true when the application is running or the node has
+	// joined.
+	Ready bool
+
+	// Services lists the running services of a compose application.
+	Services []string
+
+	// Node is the name of the joined node.
+	Node string
+
+	Error string
+}
+
+// ContainerRuntime bootstraps containers on a device and reports their
+// status. An error is reported in the status, so a runtime should only
+// return an error when it could not determine the status.
+type ContainerRuntime interface {
+	Bootstrap(ctx context.Context, bootstrap *ContainerBootstrap) (*ContainerStatus, error)
+}
+
+// Container implements the device side of ContainerModule.
+type Container struct {
+	Runtime ContainerRuntime
+
+	// ErrorLog is optional and any failed bootstrap will have a corresponding
+	// message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*Container)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (c *Container) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (c *Container) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "bootstrap" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var bootstrap ContainerBootstrap
+	if err := cbor.NewDecoder(messageBody).Decode(&bootstrap); err != nil {
+		return fmt.Errorf("error decoding container bootstrap: %w", err)
+	}
+
+	status, err := c.bootstrap(ctx, &bootstrap)
+	if err != nil {
+		status = &ContainerStatus{Error: err.Error()}
+	}
+	if !status.Ready && c.ErrorLog != nil {
+		_, _ = fmt.Fprintf(c.ErrorLog, "[%s] containers not ready: %s\n", ContainerModule, status.Error)
+	}
+	return cbor.NewEncoder(respond("status")).Encode(status)
+}
+
+func (c *Container) bootstrap(ctx context.Context, bootstrap *ContainerBootstrap) (*ContainerStatus, error) {
+	if (len(bootstrap.Compose) == 0) == (bootstrap.Join == nil) {
+		return nil, errors.New("bootstrap must have exactly one of a compose file or cluster join")
+	}
+	if c.Runtime == nil {
+		return nil, errors.New("no container runtime")
+	}
+	status, err := c.Runtime.Bootstrap(ctx, bootstrap)
+	if err != nil {
+		return nil, fmt.Errorf("error bootstrapping containers: %w", err)
+	}
+	return status, nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (c *Container) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// BootstrapContainers implements the owner side of ContainerModule.
+type BootstrapContainers struct {
+	Bootstrap ContainerBootstrap
+
+	// MustBeReady fails TO2 if the device does not report that its containers
+	// are ready.
+	MustBeReady bool
+
+	// If set, the status will be sent on this channel. It should be buffered
+	// with a size of 1.
+	StatusChan chan<- ContainerStatus
+
+	// Internal state
+	started bool
+	body    []byte
+	done    bool
+}
+
+var _ serviceinfo.OwnerModule = (*BootstrapContainers)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (b *BootstrapContainers) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+
+	case "status":
+		var status ContainerStatus
+		if err := cbor.NewDecoder(messageBody).Decode(&status); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if b.StatusChan != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case b.StatusChan <- status:
+			}
+		}
+		if !status.Ready && b.MustBeReady {
+			return fmt.Errorf("device containers are not ready: %s", status.Error)
+		}
+		b.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (b *BootstrapContainers) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if b.done {
+		return false, true, nil
+	}
+
+	if !b.started {
+		body, err := cbor.Marshal(b.Bootstrap)
+		if err != nil {
+			return false, false, fmt.Errorf("error marshaling container bootstrap: %w", err)
+		}
+		if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+			return false, false, err
+		}
+		b.body = body
+		b.started = true
+	}
+
+	// Compose files may be long and require chunking
+	blockPeer, err := writeChunks(producer, "bootstrap", &b.body)
+	return blockPeer, false, err
+}
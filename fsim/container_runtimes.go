@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// composeProject matches valid compose project names.
+var composeProject = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ComposeRuntime runs compose applications with Docker Compose or a
+// compatible command, such as podman-compose.
+type ComposeRuntime struct {
+	// Dir is where a directory containing the compose file of each project
+	// is created. It defaults to /var/lib/fdo/compose.
+	Dir string
+
+	// Command defaults to "docker compose".
+	Command []string
+}
+
+var _ ContainerRuntime = (*ComposeRuntime)(nil)
+
+// Bootstrap implements ContainerRuntime. The project name defaults to "fdo".
+func (c *ComposeRuntime) Bootstrap(ctx context.Context, bootstrap *ContainerBootstrap) (*ContainerStatus, error) {
+	if len(bootstrap.Compose) == 0 {
+		return nil, errors.New("compose runtime cannot join a cluster")
+	}
+	project := bootstrap.Project
+	if project == "" {
+		project = "fdo"
+	}
+	if !composeProject.MatchString(project) {
+		return nil, fmt.Errorf("invalid compose project name %q", project)
+	}
+
+	dir := c.Dir
+	if dir == "" {
+		dir = "/var/lib/fdo/compose"
+	}
+	dir = filepath.Join(dir, project)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	file := filepath.Join(dir, "compose.yaml")
+	if err := os.WriteFile(file, bootstrap.Compose, 0o600); err != nil {
+		return nil, fmt.Errorf("error writing compose file: %w", err)
+	}
+
+	if _, err := c.compose(ctx, project, file, "up", "--detach", "--wait"); err != nil {
+		return &ContainerStatus{Error: err.Error()}, nil
+	}
+	out, err := c.compose(ctx, project, file, "ps", "--services", "--status", "running")
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerStatus{Ready: true, Services: strings.Fields(string(out))}, nil
+}
+
+func (c *ComposeRuntime) compose(ctx context.Context, project, file string, args ...string) ([]byte, error) {
+	command := c.Command
+	if len(command) == 0 {
+		command = []string{"docker", "compose"}
+	}
+	args = append(append(command[1:len(command):len(command)], "--project-name", project, "--file", file), args...)
+	return runCommand(ctx, nil, command[0], args...)
+}
+
+// K3sRuntime joins k3s clusters as an agent by writing the k3s configuration
+// file and starting the agent's systemd service.
+type K3sRuntime struct {
+	// ConfigPath defaults to /etc/rancher/k3s/config.yaml.
+	ConfigPath string
+
+	// Service defaults to "k3s-agent".
+	Service string
+
+	// NodeName is the name of the node. It defaults to the hostname.
+	NodeName string
+}
+
+var _ ContainerRuntime = (*K3sRuntime)(nil)
+
+// Bootstrap implements ContainerRuntime.
+func (k *K3sRuntime) Bootstrap(ctx context.Context, bootstrap *ContainerBootstrap) (*ContainerStatus, error) {
+	join := bootstrap.Join
+	if join == nil {
+		return nil, errors.New("k3s runtime cannot run compose applications")
+	}
+	if join.Server == "" || join.Token == "" {
+		return nil, errors.New("cluster join requires a server and token")
+	}
+
+	node := k.NodeName
+	if node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		node = hostname
+	}
+
+	// Quoted strings are valid YAML
+	var config strings.Builder
+	fmt.Fprintf(&config, "server: %s\ntoken: %s\nnode-name: %s\n",
+		strconv.Quote(join.Server), strconv.Quote(join.Token), strconv.Quote(node))
+	if len(join.Labels) > 0 {
+		config.WriteString("node-label:\n")
+		for _, label := range join.Labels {
+			fmt.Fprintf(&config, "  - %s\n", strconv.Quote(label))
+		}
+	}
+
+	path := k.ConfigPath
+	if path == "" {
+		path = "/etc/rancher/k3s/config.yaml"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gosec // Configuration directory is not secret
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(config.String()), 0o600); err != nil {
+		return nil, fmt.Errorf("error writing k3s configuration: %w", err)
+	}
+
+	service := k.Service
+	if service == "" {
+		service = "k3s-agent"
+	}
+	if _, err := runCommand(ctx, nil, "systemctl", "enable", "--now", service); err != nil {
+		return &ContainerStatus{Node: node, Error: err.Error()}, nil
+	}
+	if _, err := runCommand(ctx, nil, "systemctl", "is-active", "--quiet", service); err != nil {
+		return &ContainerStatus{Node: node, Error: fmt.Sprintf("%s is not active", service)}, nil
+	}
+	return &ContainerStatus{Ready: true, Node: node}, nil
+}
@@ -44,12 +44,41 @@ type Download struct {
 	temp    *os.File
 	hash    hash.Hash
 	written int
+
+	// Download interrupted by the end of a TO2 session, which the owner may
+	// resume in the next session with the "offset" message
+	partial *partialDownload
+}
+
+// partialDownload is the state of an interrupted download.
+type partialDownload struct {
+	name    string
+	length  int
+	sha384  []byte
+	temp    *os.File
+	hash    hash.Hash
+	written int
 }
 
 var _ serviceinfo.DeviceModule = (*Download)(nil)
 
 // Transition implements serviceinfo.DeviceModule.
+//
+// A download in progress is kept, rather than removed, so that it may be
+// resumed in the next TO2 session of the same process.
 func (d *Download) Transition(active bool) error {
+	if d.temp != nil && d.written < d.length {
+		d.discardPartial()
+		d.partial = &partialDownload{
+			name:    d.name,
+			length:  d.length,
+			sha384:  d.sha384,
+			temp:    d.temp,
+			hash:    d.hash,
+			written: d.written,
+		}
+		d.temp, d.hash = nil, nil
+	}
 	d.reset()
 	return nil
 }
@@ -74,7 +103,11 @@ func (d *Download) receive(messageName string, messageBody io.Reader, respond fu
 	case "name":
 		return cbor.NewDecoder(messageBody).Decode(&d.name)
 
+	case "offset":
+		return d.resume(messageBody, respond)
+
 	case "data":
+		d.discardPartial()
 		if err := d.createTemp(); err != nil {
 			return err
 		}
@@ -112,6 +145,40 @@ func (d *Download) receive(messageName string, messageBody io.Reader, respond fu
 	}
 }
 
+// resume continues an interrupted download of the same file, if it was kept,
+// and responds with the number of bytes kept, which is zero if it was not.
+func (d *Download) resume(messageBody io.Reader, respond func(string) io.Writer) error {
+	// The number of bytes sent by the owner is only informative, since the
+	// device may not have received all of them
+	var offset int
+	if err := cbor.NewDecoder(messageBody).Decode(&offset); err != nil {
+		return err
+	}
+	if d.temp != nil {
+		return fmt.Errorf("offset received after data")
+	}
+
+	p := d.partial
+	d.partial = nil
+	if p != nil && p.name == d.name && p.length == d.length && bytes.Equal(p.sha384, d.sha384) {
+		d.temp, d.hash, d.written = p.temp, p.hash, p.written
+	} else if p != nil {
+		d.partial = p
+		d.discardPartial()
+	}
+	return cbor.NewEncoder(respond("offset")).Encode(d.written)
+}
+
+// discardPartial removes an interrupted download which was not resumed.
+func (d *Download) discardPartial() {
+	if d.partial == nil {
+		return
+	}
+	_ = d.partial.temp.Close()
+	_ = os.Remove(d.partial.temp.Name())
+	d.partial = nil
+}
+
 func (d *Download) createTemp() error {
 	if d.temp != nil {
 		return nil
@@ -4,6 +4,7 @@
 package fsim
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha512"
 	"fmt"
@@ -26,13 +27,67 @@ type DownloadContents[T io.ReadSeeker] struct {
 	ChunkSize int
 
 	// internal state
-	started bool
-	chunk   []byte
-	index   int64
-	done    bool
+	started  bool
+	resuming bool
+	chunk    []byte
+	index    int64
+	length   int64
+	sha384   []byte
+	done     bool
+	closed   bool
 }
 
-var _ serviceinfo.OwnerModule = (*DownloadContents[io.ReadSeekCloser])(nil)
+var _ serviceinfo.ResumableOwnerModule = (*DownloadContents[io.ReadSeekCloser])(nil)
+
+// downloadState is the persisted progress of a download. A completed download
+// is not repeated. An interrupted one records the number of bytes sent and the
+// digest of the contents, so that it may resume if the contents are unchanged.
+type downloadState struct {
+	Done   bool
+	Offset int64
+	SHA384 []byte
+}
+
+// Checkpoint implements serviceinfo.ResumableOwnerModule.
+//
+// While waiting for the device to confirm a resumed offset, the download is
+// recorded as starting from the beginning, so that a device which cannot
+// resume is not asked to again.
+func (d *DownloadContents[T]) Checkpoint() ([]byte, error) {
+	return cbor.Marshal(downloadState{Done: d.done, Offset: d.index, SHA384: d.sha384})
+}
+
+// Resume implements serviceinfo.ResumableOwnerModule.
+//
+// fdo.download has no message to start from an offset, so an interrupted
+// download resumes with the "offset" message, which is an extension
+// supported by [Download]. After sending the file information again, the
+// owner sends the number of bytes previously sent and waits for the device to
+// respond with the number of bytes it kept, which may be zero.
+func (d *DownloadContents[T]) Resume(state []byte) error {
+	var s downloadState
+	if err := cbor.Unmarshal(state, &s); err != nil {
+		return fmt.Errorf("error decoding download state: %w", err)
+	}
+	d.done = s.Done
+	if s.Offset > 0 {
+		d.index, d.sha384 = s.Offset, s.SHA384
+	}
+	return nil
+}
+
+// Close closes Contents, if it is an io.Closer. It is called once the device
+// has received the contents and by TO2 servers when the session ends.
+func (d *DownloadContents[T]) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if closer, ok := any(d.Contents).(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
 
 // HandleInfo implements serviceinfo.OwnerModule.
 func (d *DownloadContents[T]) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
@@ -48,11 +103,7 @@ func (d *DownloadContents[T]) HandleInfo(ctx context.Context, messageName string
 		return nil
 
 	case "done":
-		defer func() {
-			if closer, ok := any(d.Contents).(io.Closer); ok {
-				_ = closer.Close()
-			}
-		}()
+		defer func() { _ = d.Close() }()
 		var errCode int64
 		if err := cbor.NewDecoder(messageBody).Decode(&errCode); err != nil {
 			return fmt.Errorf("error decoding message %s: %w", messageName, err)
@@ -66,19 +117,37 @@ func (d *DownloadContents[T]) HandleInfo(ctx context.Context, messageName string
 		d.done = true
 		return nil
 
+	case "offset":
+		var offset int64
+		if err := cbor.NewDecoder(messageBody).Decode(&offset); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !d.resuming {
+			return fmt.Errorf("unexpected message %s", messageName)
+		}
+		if offset < 0 || offset > d.length {
+			return fmt.Errorf("device kept %d bytes of %q, which is %d bytes long", offset, d.Name, d.length)
+		}
+		d.index, d.resuming = offset, false
+		return nil
+
 	default:
 		return fmt.Errorf("unsupported message %q", messageName)
 	}
 }
 
 // ProduceInfo implements serviceinfo.OwnerModule.
-//
-//nolint:gocyclo // Message dispatch has a high score, but is easy to understand
 func (d *DownloadContents[T]) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
 	if d.done {
 		return false, true, nil
 	}
 
+	// Wait for the device to report how much of an interrupted download it
+	// kept
+	if d.resuming {
+		return false, false, nil
+	}
+
 	if d.started {
 		return d.sendData(producer)
 	}
@@ -89,38 +158,33 @@ func (d *DownloadContents[T]) ProduceInfo(ctx context.Context, producer *service
 	if err != nil {
 		return false, false, fmt.Errorf("error reading contents of %q: %w", d.Name, err)
 	}
+	sum := sha384.Sum(nil)
 
-	messageVal := map[string]any{
-		"active":  true,
-		"name":    d.Name,
-		"length":  length,
-		"sha-384": sha384.Sum(nil)[:],
+	if err := sendDownloadHeader(producer, d.Name, length, sum); err != nil {
+		return false, false, err
 	}
-	for _, messageName := range []string{"active", "name", "length", "sha-384"} {
-		messageBody, err := cbor.Marshal(messageVal[messageName])
+
+	// Resume an interrupted download of the same contents. Until the device
+	// responds, the download is checkpointed as starting from the beginning.
+	offset := d.index
+	d.index = 0
+	if offset > 0 && offset <= length && bytes.Equal(sum, d.sha384) {
+		messageBody, err := cbor.Marshal(offset)
 		if err != nil {
 			return false, false, err
 		}
-
-		// Check that there's enough space to send the message
-		if len(messageBody) > producer.Available(messageName) {
+		if len(messageBody) > producer.Available("offset") {
 			return false, false, fmt.Errorf("not enough buffer space to send non-data service info")
 		}
-
-		// Write the message
-		if err := producer.WriteChunk(messageName, messageBody); err != nil {
+		if err := producer.WriteChunk("offset", messageBody); err != nil {
 			return false, false, err
 		}
+		d.resuming = true
 	}
 
 	// Prepare for data to be sent on the next ProduceInfo
-	maxChunkSize := 1014
-	if d.ChunkSize > 0 {
-		maxChunkSize = d.ChunkSize
-	} else if d.ChunkSize < 0 {
-		maxChunkSize = (1 << 16) - 1
-	}
-	d.chunk = make([]byte, maxChunkSize)
+	d.chunk = make([]byte, downloadChunkSize(d.ChunkSize))
+	d.length, d.sha384 = length, sum
 	d.started = true
 	return false, false, nil
 }
@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// FirmwareSignatureSuffix is appended to the name of a firmware image to
+// name the file containing its signature.
+const FirmwareSignatureSuffix = ".sig"
+
+// FirmwareInstaller writes firmware images to one of two (A/B) slots and
+// sets the boot flags of the device's boot loader.
+type FirmwareInstaller interface {
+	// InactiveSlot returns the slot which was not booted and may be written.
+	InactiveSlot(ctx context.Context) (string, error)
+
+	// Install writes the image file at path to a slot.
+	Install(ctx context.Context, slot, path string) error
+
+	// Activate sets the boot flags which cause a slot to be booted next.
+	Activate(ctx context.Context, slot string) error
+}
+
+// FirmwareUpdate implements a device module for fdo.download which installs
+// firmware images to the inactive slot of an A/B update scheme. It should be
+// registered to the "fdo.download" module in place of Download.
+//
+// Each firmware image must be preceded by its signature, created by
+// SignFirmware and downloaded with the name of the image and the
+// FirmwareSignatureSuffix. Once an image is downloaded, its signature is
+// verified, it is installed to the inactive slot, and the slot is activated,
+// all before the done message is sent, so that the owner learns whether the
+// update succeeded before TO2 completes.
+type FirmwareUpdate struct {
+	// IsFirmware reports whether a file is a firmware image. If nil, every
+	// file is a firmware image. Other files are saved as by Download.
+	IsFirmware func(name string) bool
+
+	// Keys which may sign firmware images
+	Keys []crypto.PublicKey
+
+	Installer FirmwareInstaller
+
+	// CreateTemp, NameToPath, and ErrorLog are used as by Download.
+	CreateTemp func() (*os.File, error)
+	NameToPath func(name string) string
+	ErrorLog   io.Writer
+
+	// Internal state
+	download   *Download
+	signatures map[string][]byte
+	staged     string
+	stagedName string
+}
+
+var _ serviceinfo.DeviceModule = (*FirmwareUpdate)(nil)
+
+// SignFirmware creates the signature of a firmware image as a COSE_Sign1
+// over its SHA-384 digest. For RSA keys, opts must be given as for
+// cose.Sign1.Sign.
+func SignFirmware(key crypto.Signer, image io.Reader, opts crypto.SignerOpts) ([]byte, error) {
+	hash := sha512.New384()
+	if _, err := io.Copy(hash, image); err != nil {
+		return nil, fmt.Errorf("error reading firmware image: %w", err)
+	}
+	digest := hash.Sum(nil)
+
+	var s1 cose.Sign1[[]byte, []byte]
+	if err := s1.Sign(key, &digest, nil, opts); err != nil {
+		return nil, fmt.Errorf("error signing firmware image: %w", err)
+	}
+	return cbor.Marshal(s1.Tag())
+}
+
+func (f *FirmwareUpdate) init() {
+	if f.download != nil {
+		return
+	}
+	f.download = &Download{
+		CreateTemp: f.CreateTemp,
+		NameToPath: f.nameToPath,
+		ErrorLog:   f.ErrorLog,
+	}
+	f.download.reset()
+}
+
+// Transition implements serviceinfo.DeviceModule.
+func (f *FirmwareUpdate) Transition(active bool) error {
+	f.init()
+	f.discard()
+	f.signatures = nil
+	return f.download.Transition(active)
+}
+
+// Receive implements serviceinfo.DeviceModule.
+func (f *FirmwareUpdate) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	f.init()
+	defer f.discard()
+
+	// Hold the done message until the image is installed
+	var done bytes.Buffer
+	if err := f.download.Receive(ctx, messageName, messageBody, func(messageName string) io.Writer {
+		if messageName == "done" {
+			return &done
+		}
+		return respond(messageName)
+	}, yield); err != nil {
+		return err
+	}
+	if done.Len() == 0 {
+		return nil
+	}
+
+	var written int64
+	if err := cbor.Unmarshal(done.Bytes(), &written); err != nil {
+		return fmt.Errorf("error decoding done message: %w", err)
+	}
+	if written >= 0 && f.staged != "" {
+		if err := f.install(ctx, f.stagedName, f.staged); err != nil {
+			if f.ErrorLog != nil {
+				_, _ = fmt.Fprintf(f.ErrorLog, "[file=%s] %v\n", f.stagedName, err)
+			}
+			written = -1
+		}
+	}
+	return cbor.NewEncoder(respond("done")).Encode(written)
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (f *FirmwareUpdate) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+func (f *FirmwareUpdate) isFirmware(name string) bool {
+	return f.IsFirmware == nil || f.IsFirmware(name)
+}
+
+// nameToPath stages firmware images and signatures next to the temporary file
+// of the download rather than saving them.
+func (f *FirmwareUpdate) nameToPath(name string) string {
+	if !f.isFirmware(strings.TrimSuffix(name, FirmwareSignatureSuffix)) {
+		if f.NameToPath != nil {
+			return f.NameToPath(name)
+		}
+		return name
+	}
+	f.staged, f.stagedName = f.download.temp.Name()+".staged", name
+	return f.staged
+}
+
+// discard removes a staged file.
+func (f *FirmwareUpdate) discard() {
+	if f.staged != "" {
+		_ = os.Remove(f.staged)
+	}
+	f.staged, f.stagedName = "", ""
+}
+
+// install stores the signature of an image or verifies and installs an image.
+func (f *FirmwareUpdate) install(ctx context.Context, name, path string) error {
+	if image, ok := strings.CutSuffix(name, FirmwareSignatureSuffix); ok {
+		sig, err := os.ReadFile(path) //nolint:gosec // Path is of a temporary file
+		if err != nil {
+			return fmt.Errorf("error reading signature: %w", err)
+		}
+		if f.signatures == nil {
+			f.signatures = make(map[string][]byte)
+		}
+		f.signatures[image] = sig
+		return nil
+	}
+
+	sig, ok := f.signatures[name]
+	if !ok {
+		return errors.New("firmware image was not preceded by its signature")
+	}
+	delete(f.signatures, name)
+	if err := f.verify(path, sig); err != nil {
+		return err
+	}
+
+	if f.Installer == nil {
+		return errors.New("no firmware installer")
+	}
+	slot, err := f.Installer.InactiveSlot(ctx)
+	if err != nil {
+		return fmt.Errorf("error finding inactive slot: %w", err)
+	}
+	if err := f.Installer.Install(ctx, slot, path); err != nil {
+		return fmt.Errorf("error installing firmware to slot %q: %w", slot, err)
+	}
+	if err := f.Installer.Activate(ctx, slot); err != nil {
+		return fmt.Errorf("error activating slot %q: %w", slot, err)
+	}
+	return nil
+}
+
+func (f *FirmwareUpdate) verify(path string, sig []byte) error {
+	var s1 cose.Sign1Tag[[]byte, []byte]
+	if err := cbor.Unmarshal(sig, &s1); err != nil {
+		return fmt.Errorf("error decoding firmware signature: %w", err)
+	}
+
+	image, err := os.Open(path) //nolint:gosec // Path is of a temporary file
+	if err != nil {
+		return err
+	}
+	defer func() { _ = image.Close() }()
+	hash := sha512.New384()
+	if _, err := io.Copy(hash, image); err != nil {
+		return fmt.Errorf("error reading firmware image: %w", err)
+	}
+	digest := hash.Sum(nil)
+
+	for _, key := range f.Keys {
+		if ok, err := s1.Untag().Verify(key, &digest, nil); err == nil && ok {
+			return nil
+		}
+	}
+	return errors.New("firmware signature verification failed")
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// UBootInstaller installs firmware images for U-Boot, which selects the
+// booted slot with an environment variable and falls back to the other slot
+// when bootcount exceeds bootlimit while upgrade_available is set. The
+// environment is read and written with fw_printenv and fw_setenv.
+type UBootInstaller struct {
+	// Slots maps the two slot names, i.e. "A" and "B", to the block devices
+	// (or files) written by Install.
+	Slots map[string]string
+
+	// SlotVar is the environment variable naming the booted slot. It
+	// defaults to "boot_slot".
+	SlotVar string
+}
+
+var _ FirmwareInstaller = (*UBootInstaller)(nil)
+
+func (u *UBootInstaller) slotVar() string {
+	if u.SlotVar != "" {
+		return u.SlotVar
+	}
+	return "boot_slot"
+}
+
+// InactiveSlot implements FirmwareInstaller.
+func (u *UBootInstaller) InactiveSlot(ctx context.Context) (string, error) {
+	out, err := runCommand(ctx, nil, "fw_printenv", "-n", u.slotVar())
+	if err != nil {
+		return "", err
+	}
+	return otherSlot(u.Slots, strings.TrimSpace(string(out)))
+}
+
+// Install implements FirmwareInstaller.
+func (u *UBootInstaller) Install(_ context.Context, slot, path string) error {
+	return writeSlot(u.Slots, slot, path)
+}
+
+// Activate implements FirmwareInstaller.
+func (u *UBootInstaller) Activate(ctx context.Context, slot string) error {
+	// Set all variables at once, so that a power loss cannot leave the
+	// environment partially updated
+	script := fmt.Sprintf("%s %s\nupgrade_available 1\nbootcount 0\n", u.slotVar(), slot)
+	_, err := runCommand(ctx, strings.NewReader(script), "fw_setenv", "-s", "-")
+	return err
+}
+
+// SystemdBootInstaller installs firmware images for systemd-boot, with a boot
+// loader entry for each slot. The new slot is booted once, so that the
+// device falls back to the default entry unless the booted system makes it
+// the default, i.e. with `bootctl set-default`.
+type SystemdBootInstaller struct {
+	// Slots maps the two slot names to the partitions (or files) written by
+	// Install.
+	Slots map[string]string
+
+	// Entries maps slot names to boot loader entry IDs, i.e. "a.conf".
+	Entries map[string]string
+
+	// EFIVarsDir is where EFI variables are read. It defaults to
+	// /sys/firmware/efi/efivars.
+	EFIVarsDir string
+}
+
+var _ FirmwareInstaller = (*SystemdBootInstaller)(nil)
+
+// loaderEntrySelected is the EFI variable with the ID of the booted entry.
+const loaderEntrySelected = "LoaderEntrySelected-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+
+// InactiveSlot implements FirmwareInstaller.
+func (s *SystemdBootInstaller) InactiveSlot(context.Context) (string, error) {
+	dir := s.EFIVarsDir
+	if dir == "" {
+		dir = "/sys/firmware/efi/efivars"
+	}
+	data, err := os.ReadFile(filepath.Join(dir, loaderEntrySelected))
+	if err != nil {
+		return "", fmt.Errorf("error reading booted entry: %w", err)
+	}
+	if len(data) < 4 || len(data)%2 != 0 {
+		return "", errors.New("invalid booted entry variable")
+	}
+
+	// Skip attributes and decode NUL-terminated UTF-16LE
+	u16 := make([]uint16, (len(data)-4)/2)
+	if err := binary.Read(bytes.NewReader(data[4:]), binary.LittleEndian, u16); err != nil {
+		return "", err
+	}
+	if i := slices.Index(u16, 0); i >= 0 {
+		u16 = u16[:i]
+	}
+	booted := string(utf16.Decode(u16))
+
+	for slot, entry := range s.Entries {
+		if entry == booted {
+			return otherSlot(s.Slots, slot)
+		}
+	}
+	return "", fmt.Errorf("booted entry %q is not a slot", booted)
+}
+
+// Install implements FirmwareInstaller.
+func (s *SystemdBootInstaller) Install(_ context.Context, slot, path string) error {
+	return writeSlot(s.Slots, slot, path)
+}
+
+// Activate implements FirmwareInstaller.
+func (s *SystemdBootInstaller) Activate(ctx context.Context, slot string) error {
+	entry, ok := s.Entries[slot]
+	if !ok {
+		return fmt.Errorf("no boot loader entry for slot %q", slot)
+	}
+	_, err := runCommand(ctx, nil, "bootctl", "set-oneshot", entry)
+	return err
+}
+
+// RaucInstaller installs RAUC bundles. RAUC chooses the inactive slot group
+// and marks it active itself, so the slot is always empty and Activate does
+// nothing.
+type RaucInstaller struct{}
+
+var _ FirmwareInstaller = RaucInstaller{}
+
+// InactiveSlot implements FirmwareInstaller.
+func (RaucInstaller) InactiveSlot(context.Context) (string, error) { return "", nil }
+
+// Install implements FirmwareInstaller.
+func (RaucInstaller) Install(ctx context.Context, _, path string) error {
+	_, err := runCommand(ctx, nil, "rauc", "install", path)
+	return err
+}
+
+// Activate implements FirmwareInstaller.
+func (RaucInstaller) Activate(context.Context, string) error { return nil }
+
+// otherSlot returns the slot which is not the booted one.
+func otherSlot(slots map[string]string, booted string) (string, error) {
+	if len(slots) != 2 {
+		return "", fmt.Errorf("expected 2 slots, got %d", len(slots))
+	}
+	if _, ok := slots[booted]; !ok {
+		return "", fmt.Errorf("booted slot %q is unknown", booted)
+	}
+	for slot := range slots {
+		if slot != booted {
+			return slot, nil
+		}
+	}
+	panic("unreachable")
+}
+
+// writeSlot copies an image to the device of a slot and waits for it to be
+// written to storage.
+func writeSlot(slots map[string]string, slot, path string) error {
+	device, ok := slots[slot]
+	if !ok {
+		return fmt.Errorf("unknown slot %q", slot)
+	}
+	image, err := os.Open(path) //nolint:gosec // Path is of a temporary file
+	if err != nil {
+		return err
+	}
+	defer func() { _ = image.Close() }()
+
+	f, err := os.OpenFile(device, os.O_WRONLY, 0) //nolint:gosec // Device is configured
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, image); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing %s: %w", device, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error syncing %s: %w", device, err)
+	}
+	return f.Close()
+}
+
+func runCommand(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	return runCommandEnv(ctx, stdin, nil, name, args...)
+}
+
+// runCommandEnv runs a command with variables added to the environment.
+func runCommandEnv(ctx context.Context, stdin io.Reader, env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
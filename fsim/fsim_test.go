@@ -6,24 +6,34 @@ package fsim_test
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
 	"crypto/sha512"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/user"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
 	"time"
 
+	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
 	"github.com/fido-device-onboard/go-fdo/fdotest"
@@ -297,6 +307,160 @@ func TestClientWithCommandModule(t *testing.T) {
 	}
 }
 
+func TestDownloadContentsResume(t *testing.T) {
+	data := []byte("Hello World!\n")
+	done, err := cbor.Marshal(int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Download all contents and checkpoint after the device reports done
+	download := &fsim.DownloadContents[*bytes.Reader]{Name: "resume.test", Contents: bytes.NewReader(data)}
+	producer := serviceinfo.NewProducer("fdo.download", serviceinfo.DefaultMTU)
+	for range 2 {
+		if _, _, err := download.ProduceInfo(context.TODO(), producer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := download.HandleInfo(context.TODO(), "done", bytes.NewReader(done)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := download.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A resumed module does not send the file again
+	resumed := &fsim.DownloadContents[*bytes.Reader]{Name: "resume.test", Contents: bytes.NewReader(data)}
+	if err := resumed.Resume(state); err != nil {
+		t.Fatal(err)
+	}
+	producer = serviceinfo.NewProducer("fdo.download", serviceinfo.DefaultMTU)
+	_, moduleDone, err := resumed.ProduceInfo(context.TODO(), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moduleDone || len(producer.ServiceInfo()) > 0 {
+		t.Fatalf("expected resumed download to be done without sending service info, got %d KVs", len(producer.ServiceInfo()))
+	}
+}
+
+func TestDownloadContentsResumeOffset(t *testing.T) {
+	data := []byte("Hello World!\n")
+	dir := t.TempDir()
+	device := &fsim.Download{
+		CreateTemp: func() (*os.File, error) { return os.CreateTemp(dir, "fdo.download_*") },
+		NameToPath: func(name string) string { return filepath.Join(dir, name) },
+	}
+	if err := device.Transition(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// exchange produces service info from the owner and returns the
+	// responses of the device
+	exchange := func(owner serviceinfo.OwnerModule) (moduleDone bool, responses map[string]*bytes.Buffer) {
+		producer := serviceinfo.NewProducer("fdo.download", serviceinfo.DefaultMTU)
+		_, moduleDone, err := owner.ProduceInfo(context.TODO(), producer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = make(map[string]*bytes.Buffer)
+		for _, kv := range producer.ServiceInfo() {
+			_, messageName, _ := strings.Cut(kv.Key, ":")
+			if messageName == "active" {
+				continue
+			}
+			if err := device.Receive(context.TODO(), messageName, bytes.NewReader(kv.Val), func(messageName string) io.Writer {
+				if responses[messageName] == nil {
+					responses[messageName] = new(bytes.Buffer)
+				}
+				return responses[messageName]
+			}, func() {}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return moduleDone, responses
+	}
+
+	// Send the header and the first chunk, then end the session
+	owner := &fsim.DownloadContents[*bytes.Reader]{Name: "resume.test", Contents: bytes.NewReader(data), ChunkSize: 5}
+	for range 2 {
+		exchange(owner)
+	}
+	state, err := owner.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := device.Transition(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resumed owner asks the device for its offset and sends the rest
+	resumed := &fsim.DownloadContents[*bytes.Reader]{Name: "resume.test", Contents: bytes.NewReader(data), ChunkSize: 5}
+	if err := resumed.Resume(state); err != nil {
+		t.Fatal(err)
+	}
+	_, responses := exchange(resumed)
+	if responses["offset"] == nil {
+		t.Fatal("expected device to respond with its offset")
+	}
+	var offset int64
+	if err := cbor.Unmarshal(responses["offset"].Bytes(), &offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.HandleInfo(context.TODO(), "offset", responses["offset"]); err != nil {
+		t.Fatal(err)
+	}
+	if offset != 5 {
+		t.Fatalf("expected device to keep 5 bytes, got %d", offset)
+	}
+	for {
+		moduleDone, responses := exchange(resumed)
+		if moduleDone {
+			break
+		}
+		if done := responses["done"]; done != nil {
+			if err := resumed.HandleInfo(context.TODO(), "done", done); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "resume.test")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, data) {
+		t.Fatalf("expected downloaded file to contain %q, got %q", data, got)
+	}
+}
+
+func TestDownloadArtifactClosed(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "image.bin"), make([]byte, 8<<10), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Send the header and the first chunk, then close the artifact, as when
+	// the session ends
+	download := &fsim.DownloadArtifact{Name: "image.bin", Provider: fsim.DirArtifacts(dir), ChunkSize: 1024}
+	for range 2 {
+		producer := serviceinfo.NewProducer("fdo.download", serviceinfo.DefaultMTU)
+		if _, _, err := download.ProduceInfo(context.TODO(), producer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := download.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The artifact is not reopened from the start
+	producer := serviceinfo.NewProducer("fdo.download", serviceinfo.DefaultMTU)
+	if _, _, err := download.ProduceInfo(context.TODO(), producer); err == nil {
+		t.Fatal("expected closed artifact not to be read again")
+	}
+	if len(producer.ServiceInfo()) > 0 {
+		t.Fatalf("expected no data to be sent after close, got %d KVs", len(producer.ServiceInfo()))
+	}
+}
+
 func tryDebugNotation(b []byte) string {
 	d, err := cdn.FromCBOR(b)
 	if err != nil {
@@ -304,3 +468,886 @@ func tryDebugNotation(b []byte) string {
 	}
 	return d
 }
+
+func TestServiceInfoProfiles(t *testing.T) {
+	server := fdotest.NewServer(t)
+	special := server.NewDevice(t, protocol.Secp256r1KeyType)
+	other := server.NewDevice(t, protocol.Secp256r1KeyType)
+	for _, dev := range []*fdotest.Device{special, other} {
+		server.RegisterBlob(t, dev.Cred.GUID)
+	}
+
+	server.TO2.ServiceInfo = fsim.ServiceInfoProfiles{
+		{
+			Name:       "common",
+			DeviceInfo: "go*",
+			Downloads:  []fsim.ProfileDownload{{Name: "common.cfg", Contents: []byte("common"), MustDownload: true}},
+		},
+		{
+			Name:      "special",
+			GUIDs:     []protocol.GUID{special.Cred.GUID},
+			Downloads: []fsim.ProfileDownload{{Name: "special.cfg", Contents: []byte("special"), MustDownload: true}},
+		},
+		{
+			Name:     "other os",
+			OS:       "not-" + runtime.GOOS,
+			Commands: []fsim.ProfileCommand{{Command: "false"}},
+		},
+	}
+	onboard := func(dev *fdotest.Device) (string, error) {
+		dir := t.TempDir()
+		return dir, server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			"fdo.download": &fsim.Download{
+				CreateTemp: func() (*os.File, error) { return os.CreateTemp(dir, "fdo.download_*") },
+				NameToPath: func(name string) string { return filepath.Join(dir, name) },
+			},
+		})
+	}
+
+	// Each device receives the files of the profiles it matches
+	for dev, want := range map[*fdotest.Device][]string{
+		special: {"common.cfg", "special.cfg"},
+		other:   {"common.cfg"},
+	} {
+		dir, err := onboard(dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Name())
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected downloads %v, got %v", want, got)
+		}
+	}
+
+	// Onboarding fails if a matching profile uses an unsupported module
+	server.TO2.ServiceInfo = append(server.TO2.ServiceInfo.(fsim.ServiceInfoProfiles), fsim.ServiceInfoProfile{
+		Name:     "all",
+		Commands: []fsim.ProfileCommand{{Command: "true"}},
+	})
+	unsupported := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, unsupported.Cred.GUID)
+	if _, err := onboard(unsupported); err == nil {
+		t.Fatal("expected onboarding to fail without fdo.command support")
+	}
+}
+
+func TestServiceInfoProfileFilesInterleaved(t *testing.T) {
+	src := t.TempDir()
+	files := map[string][]byte{"a.bin": make([]byte, 8<<10), "b.bin": make([]byte, 8<<10)}
+	for name, data := range files {
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files must remain open after the module iterator has yielded every
+	// module
+	server := fdotest.NewServer(t)
+	server.TO2.InterleaveModules = true
+	server.TO2.ServiceInfo = fsim.ServiceInfoProfiles{{
+		Name: "files",
+		Downloads: []fsim.ProfileDownload{
+			{Name: "a.bin", Path: filepath.Join(src, "a.bin"), MustDownload: true},
+			{Name: "b.bin", Path: filepath.Join(src, "b.bin"), MustDownload: true},
+		},
+	}}
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	out := t.TempDir()
+	if err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+		"fdo.download": &fsim.Download{
+			CreateTemp: func() (*os.File, error) { return os.CreateTemp(out, "fdo.download_*") },
+			NameToPath: func(name string) string { return filepath.Join(out, name) },
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s contents did not match expected", name)
+		}
+	}
+}
+
+func TestDownloadArtifact(t *testing.T) {
+	data := make([]byte, 64<<10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum384(data)
+
+	var httpGets, s3Gets atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/artifacts/image.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			httpGets.Add(1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "image.bin", time.Time{}, bytes.NewReader(data))
+	})
+	mux.HandleFunc("/bucket/firmware/image.bin", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") ||
+			r.Header.Get("X-Amz-Date") == "" {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodGet {
+			s3Gets.Add(1)
+		}
+		w.Header().Set("Repr-Digest", "sha-384=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		http.ServeContent(w, r, "image.bin", time.Time{}, bytes.NewReader(data))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "image.bin"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := fdotest.NewServer(t)
+	digests := new(fsim.ArtifactDigests)
+	server.TO2.ServiceInfo = fsim.ServiceInfoProfiles{{
+		Name: "firmware",
+		Downloads: []fsim.ProfileDownload{
+			{Name: "dir.bin", Path: "image.bin", Provider: fsim.DirArtifacts(dir), MustDownload: true},
+			{Name: "http.bin", Path: "image.bin", Provider: &fsim.HTTPArtifacts{BaseURL: srvURL.JoinPath("artifacts")}, Digests: digests, MustDownload: true},
+			{Name: "s3.bin", Path: "image.bin", Provider: &fsim.S3Artifacts{
+				Endpoint:        srvURL,
+				Region:          "us-east-1",
+				Bucket:          "bucket",
+				Prefix:          "firmware/",
+				AccessKeyID:     "AKID",
+				SecretAccessKey: "secret",
+			}, MustDownload: true},
+		},
+	}}
+
+	for range 2 {
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		out := t.TempDir()
+		if err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			"fdo.download": &fsim.Download{
+				CreateTemp: func() (*os.File, error) { return os.CreateTemp(out, "fdo.download_*") },
+				NameToPath: func(name string) string { return filepath.Join(out, name) },
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"dir.bin", "http.bin", "s3.bin"} {
+			got, err := os.ReadFile(filepath.Join(out, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s contents did not match expected", name)
+			}
+		}
+	}
+
+	// The digest of the HTTP artifact is computed once and the S3 artifact's
+	// digest is provided by the server
+	if got := httpGets.Load(); got != 3 {
+		t.Errorf("expected 3 HTTP artifact reads, got %d", got)
+	}
+	if got := s3Gets.Load(); got != 2 {
+		t.Errorf("expected 2 S3 artifact reads, got %d", got)
+	}
+
+	// Missing artifacts fail before onboarding starts
+	if _, err := (&fsim.HTTPArtifacts{BaseURL: srvURL}).Stat(context.Background(), "missing.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected missing artifact to not exist, got %v", err)
+	}
+}
+
+func TestFirmwareUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake U-Boot tools are shell scripts")
+	}
+
+	// Fake U-Boot environment tools with slot A booted
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	for name, script := range map[string]string{
+		"fw_printenv": "#!/bin/sh\necho A\n",
+		"fw_setenv":   "#!/bin/sh\ncat > " + envFile + "\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o700); err != nil { //nolint:gosec // Executable test script
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	slots := map[string]string{"A": filepath.Join(dir, "slot-a"), "B": filepath.Join(dir, "slot-b")}
+	for _, slot := range slots {
+		if err := os.WriteFile(slot, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := fdotest.NewKey(protocol.Secp384r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := bytes.Repeat([]byte("firmware"), 1024)
+	sig, err := fsim.SignFirmware(key, bytes.NewReader(image), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := fdotest.NewServer(t)
+	server.TO2.ServiceInfo = fsim.ServiceInfoProfiles{{
+		Name: "firmware",
+		Downloads: []fsim.ProfileDownload{
+			{Name: "fw.img" + fsim.FirmwareSignatureSuffix, Contents: sig, MustDownload: true},
+			{Name: "fw.img", Contents: image, MustDownload: true},
+			{Name: "notes.txt", Contents: []byte("release notes"), MustDownload: true},
+		},
+	}}
+	onboard := func(keys ...crypto.PublicKey) error {
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		return server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			"fdo.download": &fsim.FirmwareUpdate{
+				IsFirmware: func(name string) bool { return name == "fw.img" },
+				Keys:       keys,
+				Installer:  &fsim.UBootInstaller{Slots: slots},
+				CreateTemp: func() (*os.File, error) { return os.CreateTemp(dir, "fdo.download_*") },
+				NameToPath: func(name string) string { return filepath.Join(dir, name) },
+				ErrorLog:   fdotest.TestingLog(t),
+			},
+		})
+	}
+
+	// The image is written to the inactive slot, which is booted next
+	if err := onboard(key.Public()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(slots["B"]); err != nil || !bytes.Equal(got, image) {
+		t.Fatalf("expected image in inactive slot, got %d bytes, %v", len(got), err)
+	}
+	if got, err := os.ReadFile(slots["A"]); err != nil || len(got) != 0 {
+		t.Fatalf("expected booted slot to be untouched, got %d bytes, %v", len(got), err)
+	}
+	if env, err := os.ReadFile(envFile); err != nil || string(env) != "boot_slot B\nupgrade_available 1\nbootcount 0\n" {
+		t.Fatalf("unexpected boot environment %q, %v", env, err)
+	}
+	if notes, err := os.ReadFile(filepath.Join(dir, "notes.txt")); err != nil || string(notes) != "release notes" {
+		t.Fatalf("expected other files to be saved, got %q, %v", notes, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "fw.img")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected firmware image not to be saved, got %v", err)
+	}
+
+	// Images with untrusted signatures are not installed and fail onboarding
+	if err := os.WriteFile(slots["B"], nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	other, err := fdotest.NewKey(protocol.Secp384r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := onboard(other.Public()); err == nil {
+		t.Fatal("expected onboarding to fail with untrusted firmware")
+	}
+	if got, _ := os.ReadFile(slots["B"]); len(got) != 0 {
+		t.Fatal("expected untrusted image not to be installed")
+	}
+}
+
+type recordNetwork struct {
+	configs []fsim.NetworkConfig
+	err     error
+}
+
+func (r *recordNetwork) ApplyNetwork(_ context.Context, config *fsim.NetworkConfig) error {
+	r.configs = append(r.configs, *config)
+	return r.err
+}
+
+func TestNetworkProvision(t *testing.T) {
+	config := fsim.NetworkConfig{
+		WiFi: []fsim.WiFiNetwork{
+			{SSID: "factory", PSK: "correct horse battery"},
+			// Long enough to require chunking
+			{SSID: "corp", Hidden: true, EAP: bytes.Repeat([]byte("key_mgmt=WPA-EAP\n"), 256)},
+		},
+		Static: &fsim.StaticIP{Interface: "eth0", Addresses: []string{"192.0.2.10/24"}, Gateway: "192.0.2.1", DNS: []string{"192.0.2.53"}},
+		Proxy:  &fsim.ProxyConfig{HTTPS: "http://proxy.example:3128", NoProxy: "localhost"},
+	}
+
+	server := fdotest.NewServer(t)
+	onboard := func(mustApply bool, network *fsim.Network) (fsim.NetworkAck, error) {
+		ackChan := make(chan fsim.NetworkAck, 1)
+		server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(fsim.NetworkModule, &fsim.ProvisionNetwork{Config: config, MustApply: mustApply, AckChan: ackChan})
+			}, nil
+		})
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{fsim.NetworkModule: network})
+		select {
+		case ack := <-ackChan:
+			return ack, err
+		default:
+			t.Fatal("expected acknowledgment")
+			panic("unreachable")
+		}
+	}
+
+	// The configuration is applied and acknowledged
+	applier := new(recordNetwork)
+	ack, err := onboard(true, &fsim.Network{Applier: applier, ErrorLog: fdotest.TestingLog(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ack.Applied {
+		t.Fatalf("expected configuration to be applied, got %+v", ack)
+	}
+	if len(applier.configs) != 1 {
+		t.Fatalf("expected configuration to be applied once, got %d", len(applier.configs))
+	}
+	got := applier.configs[0]
+	if len(got.WiFi) != 2 || got.WiFi[0].PSK != config.WiFi[0].PSK ||
+		!bytes.Equal(got.WiFi[1].EAP, config.WiFi[1].EAP) || !got.WiFi[1].Hidden ||
+		got.Static.Gateway != config.Static.Gateway || *got.Proxy != *config.Proxy {
+		t.Fatalf("unexpected configuration %+v", got)
+	}
+
+	// Rejected configuration is acknowledged with an error and fails
+	// onboarding only when it must be applied
+	reject := &fsim.Network{
+		Applier: new(recordNetwork),
+		Accept:  func(*fsim.NetworkConfig) error { return errors.New("no proxies") },
+	}
+	if ack, err := onboard(false, reject); err != nil || ack.Applied || !strings.Contains(ack.Error, "no proxies") {
+		t.Fatalf("expected rejection to be acknowledged, got %+v, %v", ack, err)
+	}
+	if ack, err := onboard(true, reject); err == nil || ack.Applied {
+		t.Fatalf("expected rejection to fail onboarding, got %+v, %v", ack, err)
+	}
+	if ack, err := onboard(true, &fsim.Network{Applier: &recordNetwork{err: errors.New("no wlan0")}}); err == nil || ack.Applied {
+		t.Fatalf("expected failure to fail onboarding, got %+v, %v", ack, err)
+	}
+}
+
+func TestWPASupplicantApplier(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake wpa_cli is a shell script")
+	}
+
+	dir := t.TempDir()
+	reconfigured := filepath.Join(dir, "reconfigured")
+	script := "#!/bin/sh\necho \"$@\" > " + reconfigured + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "wpa_cli"), []byte(script), 0o700); err != nil { //nolint:gosec // Executable test script
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	confPath := filepath.Join(dir, "wpa_supplicant.conf")
+	if err := os.WriteFile(confPath, []byte("ctrl_interface=/run/wpa_supplicant\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	applier := &fsim.WPASupplicantApplier{ConfigPath: confPath, Interface: "wlan0"}
+	if err := applier.ApplyNetwork(context.TODO(), &fsim.NetworkConfig{WiFi: []fsim.WiFiNetwork{
+		{SSID: "factory", PSK: "correct horse battery"},
+		{SSID: "corp", Hidden: true, EAP: []byte("key_mgmt=WPA-EAP\neap=TLS\n")},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = "ctrl_interface=/run/wpa_supplicant\n" +
+		"\nnetwork={\n\tssid=666163746f7279\n\tpsk=\"correct horse battery\"\n}\n" +
+		"\nnetwork={\n\tssid=636f7270\n\tscan_ssid=1\n\tkey_mgmt=WPA-EAP\n\teap=TLS\n}\n"
+	if string(conf) != expect {
+		t.Fatalf("unexpected configuration:\n%s", conf)
+	}
+	if args, err := os.ReadFile(reconfigured); err != nil || string(args) != "-i wlan0 reconfigure\n" {
+		t.Fatalf("expected wpa_supplicant to be reconfigured, got %q, %v", args, err)
+	}
+
+	// Passphrases which cannot be quoted are rejected
+	if err := applier.ApplyNetwork(context.TODO(), &fsim.NetworkConfig{WiFi: []fsim.WiFiNetwork{
+		{SSID: "bad", PSK: "quote\"in passphrase"},
+	}}); err == nil {
+		t.Fatal("expected invalid passphrase to be rejected")
+	}
+}
+
+func TestAccessProvision(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake useradd is a shell script")
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// Fake useradd which records its arguments but creates no account
+	dir := t.TempDir()
+	useradd := filepath.Join(dir, "useradd.args")
+	script := "#!/bin/sh\necho \"$@\" > " + useradd + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "useradd"), []byte(script), 0o700); err != nil { //nolint:gosec // Executable test script
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	keysPath := filepath.Join(dir, "keys", current.Username)
+	if err := os.MkdirAll(filepath.Dir(keysPath), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keysPath, []byte("ssh-ed25519 AAAAexisting old@example.com"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := []fsim.UserAccount{
+		{
+			Name: current.Username,
+			AuthorizedKeys: []string{
+				"ssh-ed25519 AAAAexisting old@example.com",
+				"ssh-ed25519 AAAAnew admin@example.com",
+			},
+		},
+		{Name: "guest", AuthorizedKeys: []string{"ssh-ed25519 AAAAguest guest@example.com"}},
+		{Name: "fdo-no-such-user", Comment: "Operator", Shell: "/bin/sh", Groups: []string{"wheel", "adm"}},
+	}
+
+	server := fdotest.NewServer(t)
+	onboard := func(mustApply bool) (fsim.AccessAck, error) {
+		ackChan := make(chan fsim.AccessAck, 1)
+		server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(fsim.AccessModule, &fsim.ProvisionAccess{Accounts: accounts, MustApply: mustApply, AckChan: ackChan})
+			}, nil
+		})
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			fsim.AccessModule: &fsim.Access{
+				Provisioner: &fsim.LocalAccounts{
+					AuthorizedKeysPath: func(u *user.User) string { return filepath.Join(dir, "keys", u.Username) },
+				},
+				Accept: func(account *fsim.UserAccount) error {
+					if account.Name == "guest" {
+						return errors.New("guest login is not allowed")
+					}
+					return nil
+				},
+				ErrorLog: fdotest.TestingLog(t),
+			},
+		})
+		select {
+		case ack := <-ackChan:
+			return ack, err
+		default:
+			t.Fatal("expected acknowledgment")
+			panic("unreachable")
+		}
+	}
+
+	// Accepted accounts are provisioned and each result is acknowledged
+	ack, err := onboard(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ack.Accounts) != 3 {
+		t.Fatalf("expected 3 results, got %+v", ack)
+	}
+	if !ack.Accounts[0].Applied {
+		t.Fatalf("expected existing account to be provisioned, got %+v", ack.Accounts[0])
+	}
+	if ack.Accounts[1].Applied || !strings.Contains(ack.Accounts[1].Error, "guest login is not allowed") {
+		t.Fatalf("expected guest to be rejected, got %+v", ack.Accounts[1])
+	}
+	if ack.Accounts[2].Applied {
+		t.Fatalf("expected account not created by fake useradd to fail, got %+v", ack.Accounts[2])
+	}
+	if keys, err := os.ReadFile(keysPath); err != nil || string(keys) != "ssh-ed25519 AAAAexisting old@example.com\nssh-ed25519 AAAAnew admin@example.com\n" {
+		t.Fatalf("unexpected authorized keys %q, %v", keys, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keys", "guest")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected rejected keys not to be written, got %v", err)
+	}
+	if args, err := os.ReadFile(useradd); err != nil || string(args) != "--create-home --comment Operator --shell /bin/sh --groups wheel,adm fdo-no-such-user\n" {
+		t.Fatalf("unexpected useradd arguments %q, %v", args, err)
+	}
+
+	// Rejected accounts fail onboarding when every account must be applied
+	if _, err := onboard(true); err == nil {
+		t.Fatal("expected rejected account to fail onboarding")
+	}
+}
+
+func TestContainerBootstrap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake container tools are shell scripts")
+	}
+
+	// Fake docker and systemctl, which fail when the fail file exists
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	fail := filepath.Join(dir, "fail")
+	for name, script := range map[string]string{
+		"docker":    "#!/bin/sh\necho \"docker $*\" >> " + calls + "\n[ -e " + fail + " ] && exit 1\ncase \"$*\" in *' ps '*) printf 'web\\ndb\\n';; esac\n",
+		"systemctl": "#!/bin/sh\necho \"systemctl $*\" >> " + calls + "\n[ ! -e " + fail + " ]\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o700); err != nil { //nolint:gosec // Executable test script
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	server := fdotest.NewServer(t)
+	onboard := func(bootstrap fsim.ContainerBootstrap, mustBeReady bool, rt fsim.ContainerRuntime) (fsim.ContainerStatus, error) {
+		statusChan := make(chan fsim.ContainerStatus, 1)
+		server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(fsim.ContainerModule, &fsim.BootstrapContainers{Bootstrap: bootstrap, MustBeReady: mustBeReady, StatusChan: statusChan})
+			}, nil
+		})
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			fsim.ContainerModule: &fsim.Container{Runtime: rt, ErrorLog: fdotest.TestingLog(t)},
+		})
+		select {
+		case status := <-statusChan:
+			return status, err
+		default:
+			t.Fatal("expected status")
+			panic("unreachable")
+		}
+	}
+
+	// Compose applications are written and started
+	compose := []byte("services:\n  web:\n    image: nginx\n" + strings.Repeat("# padding\n", 512))
+	composeRuntime := &fsim.ComposeRuntime{Dir: dir}
+	status, err := onboard(fsim.ContainerBootstrap{Project: "edge", Compose: compose}, true, composeRuntime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Ready || !slices.Equal(status.Services, []string{"web", "db"}) {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "edge", "compose.yaml")); err != nil || !bytes.Equal(got, compose) {
+		t.Fatalf("expected compose file to be written, got %d bytes, %v", len(got), err)
+	}
+	composeFile := filepath.Join(dir, "edge", "compose.yaml")
+	expectCalls := "docker compose --project-name edge --file " + composeFile + " up --detach --wait\n" +
+		"docker compose --project-name edge --file " + composeFile + " ps --services --status running\n"
+	if got, err := os.ReadFile(calls); err != nil || string(got) != expectCalls {
+		t.Fatalf("unexpected calls %q, %v", got, err)
+	}
+
+	// Clusters are joined
+	if err := os.Remove(calls); err != nil {
+		t.Fatal(err)
+	}
+	k3sConfig := filepath.Join(dir, "k3s", "config.yaml")
+	join := fsim.ContainerBootstrap{Join: &fsim.ClusterJoin{Server: "https://k3s.example:6443", Token: "K10secret", Labels: []string{"site=plant-1"}}}
+	status, err = onboard(join, true, &fsim.K3sRuntime{ConfigPath: k3sConfig, NodeName: "edge-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Ready || status.Node != "edge-1" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	const expectConfig = "server: \"https://k3s.example:6443\"\ntoken: \"K10secret\"\nnode-name: \"edge-1\"\nnode-label:\n  - \"site=plant-1\"\n"
+	if got, err := os.ReadFile(k3sConfig); err != nil || string(got) != expectConfig {
+		t.Fatalf("unexpected k3s configuration %q, %v", got, err)
+	}
+	if got, err := os.ReadFile(calls); err != nil || string(got) != "systemctl enable --now k3s-agent\nsystemctl is-active --quiet k3s-agent\n" {
+		t.Fatalf("unexpected calls %q, %v", got, err)
+	}
+
+	// Failures are reported and fail onboarding only when containers must be
+	// ready
+	if err := os.WriteFile(fail, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := onboard(fsim.ContainerBootstrap{Compose: compose}, false, composeRuntime); err != nil || status.Ready || status.Error == "" {
+		t.Fatalf("expected failure to be reported, got %+v, %v", status, err)
+	}
+	if status, err := onboard(join, true, &fsim.K3sRuntime{ConfigPath: k3sConfig, NodeName: "edge-1"}); err == nil || status.Ready {
+		t.Fatalf("expected failure to fail onboarding, got %+v, %v", status, err)
+	}
+	if status, err := onboard(join, false, composeRuntime); err != nil || status.Ready {
+		t.Fatalf("expected compose runtime to reject cluster join, got %+v, %v", status, err)
+	}
+}
+
+func TestCloudHandOff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("registration commands are shell scripts")
+	}
+	dir := t.TempDir()
+	registered := filepath.Join(dir, "registered")
+
+	server := fdotest.NewServer(t)
+	onboard := func(creds fsim.CloudCredentials, mustApply bool) (fsim.CloudStatus, error) {
+		statusChan := make(chan fsim.CloudStatus, 1)
+		server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(fsim.CloudModule, &fsim.ProvisionCloud{Credentials: creds, MustApply: mustApply, StatusChan: statusChan})
+			}, nil
+		})
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			fsim.CloudModule: &fsim.Cloud{
+				Agents: map[string]fsim.CloudAgent{
+					fsim.CloudAzureDPS: &fsim.AzureDPSAgent{ConfigPath: filepath.Join(dir, "aziot", "config.toml")},
+					fsim.CloudAWSIoT: &fsim.AWSIoTAgent{
+						Dir:     filepath.Join(dir, "aws"),
+						Command: []string{"sh", "-c", `echo "$AWS_IOT_ENDPOINT $AWS_IOT_TEMPLATE $AWS_IOT_CLAIM_KEY" > ` + registered + ` && [ "$AWS_IOT_TEMPLATE" != fail ]`},
+					},
+				},
+				ErrorLog: fdotest.TestingLog(t),
+			},
+		})
+		select {
+		case status := <-statusChan:
+			return status, err
+		default:
+			t.Fatal("expected status")
+			panic("unreachable")
+		}
+	}
+
+	// Azure DPS credentials are written to the identity service configuration
+	status, err := onboard(fsim.CloudCredentials{
+		Provider: fsim.CloudAzureDPS,
+		Azure:    &fsim.AzureDPSCredentials{IDScope: "0ne00000000", RegistrationID: "edge-1", SymmetricKey: []byte("secret")},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Stored || status.Registered {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	config, err := os.ReadFile(filepath.Join(dir, "aziot", "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`id_scope = "0ne00000000"`, `registration_id = "edge-1"`, `symmetric_key = { value = "c2VjcmV0" }`} {
+		if !strings.Contains(string(config), line) {
+			t.Fatalf("expected %q in configuration:\n%s", line, config)
+		}
+	}
+
+	// AWS IoT claim credentials are written and the registration command is
+	// run with their paths
+	claim := bytes.Repeat([]byte("-----BEGIN CERTIFICATE-----\n"), 128)
+	aws := fsim.CloudCredentials{
+		Provider: fsim.CloudAWSIoT,
+		AWS:      &fsim.AWSIoTCredentials{Endpoint: "abc-ats.iot.us-east-1.amazonaws.com", Template: "fleet", ClaimCert: claim, ClaimKey: []byte("key")},
+	}
+	status, err = onboard(aws, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Stored || !status.Registered {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	keyPath := filepath.Join(dir, "aws", "claim.pem.key")
+	if got, err := os.ReadFile(registered); err != nil || string(got) != "abc-ats.iot.us-east-1.amazonaws.com fleet "+keyPath+"\n" {
+		t.Fatalf("unexpected registration %q, %v", got, err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "aws", "claim.pem.crt")); err != nil || !bytes.Equal(got, claim) {
+		t.Fatalf("expected claim certificate to be written, got %d bytes, %v", len(got), err)
+	}
+
+	// Failed registration and unsupported providers are reported
+	aws.AWS.Template = "fail"
+	if status, err := onboard(aws, false); err != nil || !status.Stored || status.Registered || status.Error == "" {
+		t.Fatalf("expected failed registration to be reported, got %+v, %v", status, err)
+	}
+	if _, err := onboard(aws, true); err == nil {
+		t.Fatal("expected failed registration to fail onboarding")
+	}
+	token := fsim.CloudCredentials{Provider: fsim.CloudToken, Token: &fsim.CloudTokenCredentials{DeviceID: "id", Secret: "secret"}}
+	if status, err := onboard(token, false); err != nil || status.Stored || !strings.Contains(status.Error, "unsupported") {
+		t.Fatalf("expected unsupported provider to be reported, got %+v, %v", status, err)
+	}
+}
+
+func TestAttestationGatesDelivery(t *testing.T) {
+	// Fake attester which "quotes" by echoing the nonce, with an event log
+	// long enough to be split across rounds
+	eventLog := bytes.Repeat([]byte("measured boot event\n"), 2048)
+	attester := fsim.AttesterFunc(func(_ context.Context, nonce []byte) (*fsim.AttestationEvidence, error) {
+		return &fsim.AttestationEvidence{
+			Quote:    append([]byte("quote:"), nonce...),
+			PCRs:     map[int][]byte{0: bytes.Repeat([]byte{0xaa}, 32), 7: bytes.Repeat([]byte{0xbb}, 32)},
+			EventLog: eventLog,
+		}, nil
+	})
+
+	server := fdotest.NewServer(t)
+	onboard := func(verify func(context.Context, []byte, *fsim.AttestationEvidence) error, attester fsim.Attester) (*recordNetwork, error) {
+		server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				if !yield(fsim.AttestationModule, &fsim.VerifyAttestation{Verify: verify}) {
+					return
+				}
+				yield(fsim.NetworkModule, &fsim.ProvisionNetwork{Config: fsim.NetworkConfig{WiFi: []fsim.WiFiNetwork{{SSID: "production"}}}})
+			}, nil
+		})
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		applier := new(recordNetwork)
+		return applier, server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			fsim.AttestationModule: &fsim.Attestation{Attester: attester, ErrorLog: fdotest.TestingLog(t)},
+			fsim.NetworkModule:     &fsim.Network{Applier: applier},
+		})
+	}
+
+	// Fresh, acceptable evidence allows later modules to run
+	applier, err := onboard(func(_ context.Context, nonce []byte, evidence *fsim.AttestationEvidence) error {
+		if len(nonce) != fsim.AttestationNonceSize || !bytes.Equal(evidence.Quote, append([]byte("quote:"), nonce...)) {
+			return errors.New("stale quote")
+		}
+		if !bytes.Equal(evidence.EventLog, eventLog) || len(evidence.PCRs[7]) != 32 {
+			return errors.New("incomplete evidence")
+		}
+		return nil
+	}, attester)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applier.configs) != 1 {
+		t.Fatal("expected payload to be delivered after attestation")
+	}
+
+	// Rejected evidence fails TO2 before later modules run
+	applier, err = onboard(func(context.Context, []byte, *fsim.AttestationEvidence) error {
+		return errors.New("PCR 7 does not match golden value")
+	}, attester)
+	if err == nil {
+		t.Fatal("expected rejected evidence to fail onboarding")
+	}
+	if len(applier.configs) != 0 {
+		t.Fatal("expected payload not to be delivered after failed attestation")
+	}
+
+	// Devices which cannot attest fail TO2
+	_, err = onboard(func(context.Context, []byte, *fsim.AttestationEvidence) error { return nil },
+		fsim.AttesterFunc(func(context.Context, []byte) (*fsim.AttestationEvidence, error) {
+			return nil, errors.New("no TPM")
+		}))
+	if err == nil {
+		t.Fatal("expected device without evidence to fail onboarding")
+	}
+}
+
+func TestAttemptLogUpload(t *testing.T) {
+	// Enough attempts with long errors to be split across rounds
+	var logged []fdo.OnboardingAttempt
+	for i := range 16 {
+		logged = append(logged, fdo.OnboardingAttempt{
+			Timestamp: int64(1700000000 + i),
+			Protocol:  protocol.TO2Protocol,
+			BaseURL:   "https://owner.example.com",
+			MsgType:   protocol.TO2ProveOVHdrMsgType,
+			ErrCode:   protocol.InvalidMessageErrCode,
+			Err:       strings.Repeat("voucher verification failed ", 20),
+		})
+	}
+
+	server := fdotest.NewServer(t)
+	onboard := func(attempts func() ([]fdo.OnboardingAttempt, error)) ([]fdo.OnboardingAttempt, error) {
+		var received []fdo.OnboardingAttempt
+		server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+			return func(yield func(string, serviceinfo.OwnerModule) bool) {
+				yield(fsim.AttemptLogModule, &fsim.CollectAttemptLog{
+					Handle: func(_ context.Context, attempts []fdo.OnboardingAttempt) error {
+						received = attempts
+						return nil
+					},
+					ErrorLog: fdotest.TestingLog(t),
+				})
+			}, nil
+		})
+		dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+		server.RegisterBlob(t, dev.Cred.GUID)
+		return received, server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+			fsim.AttemptLogModule: &fsim.AttemptLog{Attempts: attempts, ErrorLog: fdotest.TestingLog(t)},
+		})
+	}
+
+	received, err := onboard(func() ([]fdo.OnboardingAttempt, error) { return logged, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(received, logged) {
+		t.Fatalf("expected %d attempts to be uploaded, got %+v", len(logged), received)
+	}
+
+	// A device which cannot read its log still onboards
+	if _, err := onboard(func() ([]fdo.OnboardingAttempt, error) { return nil, errors.New("storage unavailable") }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeDelivery(t *testing.T) {
+	trusted := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	server := fdotest.NewServer(t)
+	server.TO2.ServiceInfo = fdo.ServiceInfoResolverFunc(func(context.Context, fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			yield(fsim.TimeModule, &fsim.SendTime{
+				Clock:    fdo.ClockFunc(func() time.Time { return trusted }),
+				ErrorLog: fdotest.TestingLog(t),
+			})
+		}, nil
+	})
+
+	// The device clock is set to the time of the owner
+	var clock fdo.TrustedClock
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+		fsim.TimeModule: &fsim.Time{Clock: &clock, ErrorLog: fdotest.TestingLog(t)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !clock.Synced() {
+		t.Fatal("expected device clock to be set")
+	}
+	if skew := clock.Now().Sub(trusted); skew < 0 || skew > time.Minute {
+		t.Fatalf("expected device clock to follow the time of the owner, got skew of %s", skew)
+	}
+
+	// Devices which cannot set their clock still onboard
+	dev = server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := server.Onboard(t, dev, map[string]serviceinfo.DeviceModule{
+		fsim.TimeModule: &fsim.Time{ErrorLog: fdotest.TestingLog(t)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,247 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// NetworkModule is the name of the network provisioning module. It is not
+// defined by the FIDO Alliance.
+//
+// The owner sends an active message, followed by a network message
+// containing a CBOR-encoded NetworkConfig, which may be chunked. The device
+// applies the configuration and responds with an ack message containing a
+// CBOR-encoded NetworkAck.
+const NetworkModule = "go-fdo.network"
+
+// NetworkConfig is the network configuration provisioned to a device.
+type NetworkConfig struct {
+	WiFi []WiFiNetwork
+
+	// Static configures addresses, if set, rather than using DHCP.
+	Static *StaticIP
+
+	// Proxy configures the proxy environment of the device, if set.
+	Proxy *ProxyConfig
+}
+
+// WiFiNetwork is a wireless network to add to the device.
+type WiFiNetwork struct {
+	SSID   string
+	Hidden bool
+
+	// PSK is the passphrase of a WPA personal network. It is empty for open
+	// and 802.1X networks.
+	PSK string
+
+	// EAP is the 802.1X configuration of a WPA enterprise network, in the
+	// native format of the device's applier, such as a wpa_supplicant network
+	// block or a NetworkManager keyfile. Certificates must be embedded.
+	EAP []byte
+}
+
+// StaticIP is a static address configuration of an interface.
+type StaticIP struct {
+	Interface string
+
+	// Addresses in CIDR notation, i.e. "192.0.2.10/24"
+	Addresses []string
+
+	Gateway string
+	DNS     []string
+}
+
+// ProxyConfig is the proxy environment of a device.
+type ProxyConfig struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy string
+}
+
+// NetworkAck is the result of applying a NetworkConfig.
+type NetworkAck struct {
+	Applied bool
+	Error   string
+}
+
+// NetworkApplier applies network configuration to a device.
+type NetworkApplier interface {
+	ApplyNetwork(ctx context.Context, config *NetworkConfig) error
+}
+
+// NetworkAppliers applies network configuration with each applier in order,
+// i.e. Wi-Fi networks with one and static addresses with another. Each
+// applier ignores the parts of the configuration it does not apply.
+type NetworkAppliers []NetworkApplier
+
+var _ NetworkApplier = NetworkAppliers(nil)
+
+// ApplyNetwork implements NetworkApplier.
+func (appliers NetworkAppliers) ApplyNetwork(ctx context.Context, config *NetworkConfig) error {
+	for _, applier := range appliers {
+		if err := applier.ApplyNetwork(ctx, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Network implements the device side of NetworkModule.
+type Network struct {
+	Applier NetworkApplier
+
+	// Accept, if set, is called before applying a configuration and rejects
+	// it with an error.
+	Accept func(*NetworkConfig) error
+
+	// ErrorLog is optional and any rejected or failed configuration will
+	// have a corresponding message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*Network)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (n *Network) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (n *Network) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "network" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var config NetworkConfig
+	if err := cbor.NewDecoder(messageBody).Decode(&config); err != nil {
+		return fmt.Errorf("error decoding network configuration: %w", err)
+	}
+
+	var ack NetworkAck
+	if err := n.apply(ctx, &config); err != nil {
+		if n.ErrorLog != nil {
+			_, _ = fmt.Fprintf(n.ErrorLog, "[%s] %v\n", NetworkModule, err)
+		}
+		ack.Error = err.Error()
+	} else {
+		ack.Applied = true
+	}
+	return cbor.NewEncoder(respond("ack")).Encode(ack)
+}
+
+func (n *Network) apply(ctx context.Context, config *NetworkConfig) error {
+	if n.Accept != nil {
+		if err := n.Accept(config); err != nil {
+			return fmt.Errorf("configuration rejected: %w", err)
+		}
+	}
+	if n.Applier == nil {
+		return errors.New("no network applier")
+	}
+	if err := n.Applier.ApplyNetwork(ctx, config); err != nil {
+		return fmt.Errorf("error applying configuration: %w", err)
+	}
+	return nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (n *Network) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// ProvisionNetwork implements the owner side of NetworkModule.
+type ProvisionNetwork struct {
+	Config NetworkConfig
+
+	// MustApply fails TO2 if the device does not apply the configuration.
+	MustApply bool
+
+	// If set, the acknowledgment will be sent on this channel. It should be
+	// buffered with a size of 1.
+	AckChan chan<- NetworkAck
+
+	// Internal state
+	started bool
+	body    []byte
+	done    bool
+}
+
+var _ serviceinfo.OwnerModule = (*ProvisionNetwork)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (p *ProvisionNetwork) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+
+	case "ack":
+		var ack NetworkAck
+		if err := cbor.NewDecoder(messageBody).Decode(&ack); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if p.AckChan != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case p.AckChan <- ack:
+			}
+		}
+		if !ack.Applied && p.MustApply {
+			return fmt.Errorf("device did not apply network configuration: %s", ack.Error)
+		}
+		p.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (p *ProvisionNetwork) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if p.done {
+		return false, true, nil
+	}
+
+	if !p.started {
+		body, err := cbor.Marshal(p.Config)
+		if err != nil {
+			return false, false, fmt.Errorf("error marshaling network configuration: %w", err)
+		}
+		if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+			return false, false, err
+		}
+		p.body = body
+		p.started = true
+	}
+
+	// Configuration may be long and require chunking
+	blockPeer, err := writeChunks(producer, "network", &p.body)
+	return blockPeer, false, err
+}
+
+// writeChunks writes as much of a message body as fits and removes it from
+// the body. The peer should be blocked until the body is empty.
+func writeChunks(producer *serviceinfo.Producer, messageName string, body *[]byte) (blockPeer bool, _ error) {
+	if len(*body) == 0 {
+		return false, nil
+	}
+	rest, err := producer.WriteSplit(messageName, *body)
+	if err != nil {
+		return false, err
+	}
+	*body = rest
+	return len(rest) > 0, nil
+}
@@ -0,0 +1,268 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NetworkManagerApplier applies Wi-Fi networks and static addresses with
+// nmcli. The 802.1X configuration of a Wi-Fi network must be a
+// NetworkManager keyfile.
+type NetworkManagerApplier struct {
+	// ConnectionsDir is where the keyfiles of 802.1X networks are written.
+	// It defaults to /etc/NetworkManager/system-connections.
+	ConnectionsDir string
+}
+
+var _ NetworkApplier = (*NetworkManagerApplier)(nil)
+
+// ApplyNetwork implements NetworkApplier. The Interface of a static address
+// configuration is the name of the NetworkManager connection to modify.
+func (nm *NetworkManagerApplier) ApplyNetwork(ctx context.Context, config *NetworkConfig) error {
+	var reload bool
+	for _, wifi := range config.WiFi {
+		if wifi.EAP != nil {
+			if err := nm.writeKeyfile(wifi); err != nil {
+				return err
+			}
+			reload = true
+			continue
+		}
+		args := []string{"connection", "add", "type", "wifi", "con-name", wifi.SSID, "ssid", wifi.SSID}
+		if wifi.Hidden {
+			args = append(args, "802-11-wireless.hidden", "yes")
+		}
+		if wifi.PSK != "" {
+			args = append(args, "wifi-sec.key-mgmt", "wpa-psk", "wifi-sec.psk", wifi.PSK)
+		}
+		if _, err := runCommand(ctx, nil, "nmcli", args...); err != nil {
+			return err
+		}
+	}
+	if reload {
+		if _, err := runCommand(ctx, nil, "nmcli", "connection", "reload"); err != nil {
+			return err
+		}
+	}
+
+	if static := config.Static; static != nil {
+		args := []string{"connection", "modify", static.Interface}
+		var v4, v6 []string
+		for _, addr := range static.Addresses {
+			if strings.Contains(addr, ":") {
+				v6 = append(v6, addr)
+			} else {
+				v4 = append(v4, addr)
+			}
+		}
+		for _, family := range []struct {
+			prefix string
+			addrs  []string
+		}{{"ipv4", v4}, {"ipv6", v6}} {
+			if len(family.addrs) == 0 {
+				continue
+			}
+			args = append(args, family.prefix+".method", "manual", family.prefix+".addresses", strings.Join(family.addrs, ","))
+			if static.Gateway != "" && strings.Contains(static.Gateway, ":") == (family.prefix == "ipv6") {
+				args = append(args, family.prefix+".gateway", static.Gateway)
+			}
+		}
+		if len(static.DNS) > 0 {
+			args = append(args, "ipv4.dns", strings.Join(static.DNS, ","))
+		}
+		if _, err := runCommand(ctx, nil, "nmcli", args...); err != nil {
+			return err
+		}
+		if _, err := runCommand(ctx, nil, "nmcli", "connection", "up", static.Interface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nm *NetworkManagerApplier) writeKeyfile(wifi WiFiNetwork) error {
+	dir := nm.ConnectionsDir
+	if dir == "" {
+		dir = "/etc/NetworkManager/system-connections"
+	}
+	name := hex.EncodeToString([]byte(wifi.SSID)) + ".nmconnection"
+	if err := os.WriteFile(filepath.Join(dir, name), wifi.EAP, 0o600); err != nil {
+		return fmt.Errorf("error writing keyfile of %q: %w", wifi.SSID, err)
+	}
+	return nil
+}
+
+// WPASupplicantApplier applies Wi-Fi networks by appending network blocks to
+// a wpa_supplicant configuration file and reconfiguring wpa_supplicant with
+// wpa_cli. The 802.1X configuration of a Wi-Fi network must be the contents
+// of a network block without the ssid, i.e. key_mgmt=WPA-EAP and eap=TLS
+// lines.
+type WPASupplicantApplier struct {
+	// ConfigPath defaults to /etc/wpa_supplicant/wpa_supplicant.conf.
+	ConfigPath string
+
+	// Interface, if set, is the interface controlled with wpa_cli.
+	Interface string
+}
+
+var _ NetworkApplier = (*WPASupplicantApplier)(nil)
+
+// ApplyNetwork implements NetworkApplier.
+func (w *WPASupplicantApplier) ApplyNetwork(ctx context.Context, config *NetworkConfig) error {
+	if len(config.WiFi) == 0 {
+		return nil
+	}
+
+	var blocks bytes.Buffer
+	for _, wifi := range config.WiFi {
+		if err := writeNetworkBlock(&blocks, wifi); err != nil {
+			return fmt.Errorf("network %q: %w", wifi.SSID, err)
+		}
+	}
+
+	path := w.ConfigPath
+	if path == "" {
+		path = "/etc/wpa_supplicant/wpa_supplicant.conf"
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) //nolint:gosec // Path is configured
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(blocks.Bytes()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	var args []string
+	if w.Interface != "" {
+		args = append(args, "-i", w.Interface)
+	}
+	_, err = runCommand(ctx, nil, "wpa_cli", append(args, "reconfigure")...)
+	return err
+}
+
+func writeNetworkBlock(b *bytes.Buffer, wifi WiFiNetwork) error {
+	// The SSID is hex-encoded, so that it needs no quoting
+	fmt.Fprintf(b, "\nnetwork={\n\tssid=%x\n", wifi.SSID)
+	if wifi.Hidden {
+		b.WriteString("\tscan_ssid=1\n")
+	}
+	switch {
+	case wifi.EAP != nil:
+		if bytes.ContainsAny(wifi.EAP, "{}") {
+			return errors.New("802.1X configuration must be the contents of a single network block")
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(wifi.EAP)), "\n") {
+			b.WriteString("\t" + strings.TrimSpace(line) + "\n")
+		}
+	case wifi.PSK != "":
+		if len(wifi.PSK) < 8 || len(wifi.PSK) > 63 || strings.ContainsAny(wifi.PSK, "\"\n") {
+			return errors.New("passphrase must be 8 to 63 characters without quotes or newlines")
+		}
+		fmt.Fprintf(b, "\tpsk=\"%s\"\n", wifi.PSK)
+	default:
+		b.WriteString("\tkey_mgmt=NONE\n")
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+// NetlinkApplier applies static addresses with the ip command of iproute2,
+// which configures interfaces over netlink, and writes DNS servers to
+// resolv.conf.
+type NetlinkApplier struct {
+	// ResolvConf defaults to /etc/resolv.conf.
+	ResolvConf string
+}
+
+var _ NetworkApplier = (*NetlinkApplier)(nil)
+
+// ApplyNetwork implements NetworkApplier.
+func (nl *NetlinkApplier) ApplyNetwork(ctx context.Context, config *NetworkConfig) error {
+	static := config.Static
+	if static == nil {
+		return nil
+	}
+	if static.Interface == "" {
+		return errors.New("static address configuration requires an interface")
+	}
+
+	for _, addr := range static.Addresses {
+		if _, err := runCommand(ctx, nil, "ip", "address", "replace", addr, "dev", static.Interface); err != nil {
+			return err
+		}
+	}
+	if _, err := runCommand(ctx, nil, "ip", "link", "set", static.Interface, "up"); err != nil {
+		return err
+	}
+	if static.Gateway != "" {
+		if _, err := runCommand(ctx, nil, "ip", "route", "replace", "default", "via", static.Gateway, "dev", static.Interface); err != nil {
+			return err
+		}
+	}
+
+	if len(static.DNS) > 0 {
+		path := nl.ResolvConf
+		if path == "" {
+			path = "/etc/resolv.conf"
+		}
+		var conf strings.Builder
+		for _, server := range static.DNS {
+			conf.WriteString("nameserver " + server + "\n")
+		}
+		if err := os.WriteFile(path, []byte(conf.String()), 0o644); err != nil { //nolint:gosec // resolv.conf is world-readable
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// ProxyEnvironmentApplier applies proxy settings by writing an environment
+// file, such as one read by systemd's EnvironmentFile or environment.d.
+type ProxyEnvironmentApplier struct {
+	Path string
+}
+
+var _ NetworkApplier = (*ProxyEnvironmentApplier)(nil)
+
+// ApplyNetwork implements NetworkApplier.
+func (p *ProxyEnvironmentApplier) ApplyNetwork(_ context.Context, config *NetworkConfig) error {
+	proxy := config.Proxy
+	if proxy == nil {
+		return nil
+	}
+	if p.Path == "" {
+		return errors.New("proxy environment file path not set")
+	}
+
+	var env strings.Builder
+	for _, v := range []struct{ name, value string }{
+		{"http_proxy", proxy.HTTP},
+		{"https_proxy", proxy.HTTPS},
+		{"no_proxy", proxy.NoProxy},
+	} {
+		if v.value == "" {
+			continue
+		}
+		if strings.ContainsAny(v.value, "\n\"") {
+			return fmt.Errorf("invalid %s", v.name)
+		}
+		fmt.Fprintf(&env, "%s=\"%s\"\n%s=\"%s\"\n", v.name, v.value, strings.ToUpper(v.name), v.value)
+	}
+	if err := os.WriteFile(p.Path, []byte(env.String()), 0o644); err != nil { //nolint:gosec // Proxy settings are not secret
+		return fmt.Errorf("error writing %s: %w", p.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ServiceInfoProfile configures the owner modules used for the devices it
+// matches. A device matches when it meets every criterion which is set.
+type ServiceInfoProfile struct {
+	// Name identifies the profile in errors.
+	Name string
+
+	// GUIDs, if not empty, must contain the GUID of the device's voucher.
+	GUIDs []protocol.GUID
+
+	// DeviceInfo, OS, and Arch are path.Match patterns for the DeviceInfo of
+	// the voucher and the os and arch devmod fields.
+	DeviceInfo string
+	OS         string
+	Arch       string
+
+	// Match, if set, is called for devices meeting all other criteria.
+	Match func(fdo.ServiceInfoDevice) bool
+
+	// Modules are run in the order: downloads, wgets, commands, uploads.
+	Downloads []ProfileDownload
+	Wgets     []ProfileWget
+	Commands  []ProfileCommand
+	Uploads   []ProfileUpload
+}
+
+// ProfileDownload configures fdo.download with the contents of a local file,
+// an artifact, or a configuration blob.
+type ProfileDownload struct {
+	// Name of the file on the device
+	Name string
+
+	// Path of the local file to send, if Contents is nil, or the name of the
+	// artifact in Provider, if set
+	Path string
+
+	// Contents to send
+	Contents []byte
+
+	// Provider, if set, streams the artifact named by Path
+	Provider ArtifactProvider
+
+	// Digests, if set, caches the digests of artifacts from Provider
+	Digests *ArtifactDigests
+
+	MustDownload bool
+}
+
+// ProfileWget configures fdo.wget.
+type ProfileWget struct {
+	Name     string
+	URL      *url.URL
+	Length   int64
+	Checksum []byte
+}
+
+// ProfileCommand configures fdo.command.
+type ProfileCommand struct {
+	Command string
+	Args    []string
+	MayFail bool
+}
+
+// ProfileUpload configures fdo.upload.
+type ProfileUpload struct {
+	Name   string
+	Dir    string
+	Rename string
+}
+
+// matches reports whether a device meets all criteria of the profile.
+func (p *ServiceInfoProfile) matches(device fdo.ServiceInfoDevice) (bool, error) {
+	if len(p.GUIDs) > 0 && !slices.Contains(p.GUIDs, device.GUID) {
+		return false, nil
+	}
+	for _, pattern := range []struct{ pattern, value string }{
+		{p.DeviceInfo, device.Info},
+		{p.OS, device.Devmod.Os},
+		{p.Arch, device.Devmod.Arch},
+	} {
+		if pattern.pattern == "" {
+			continue
+		}
+		if ok, err := path.Match(pattern.pattern, pattern.value); err != nil {
+			return false, fmt.Errorf("profile %q: %w", p.Name, err)
+		} else if !ok {
+			return false, nil
+		}
+	}
+	return p.Match == nil || p.Match(device), nil
+}
+
+// requires returns the names of the modules the profile uses.
+func (p *ServiceInfoProfile) requires() []string {
+	var modules []string
+	if len(p.Downloads) > 0 {
+		modules = append(modules, "fdo.download")
+	}
+	if len(p.Wgets) > 0 {
+		modules = append(modules, "fdo.wget")
+	}
+	if len(p.Commands) > 0 {
+		modules = append(modules, "fdo.command")
+	}
+	if len(p.Uploads) > 0 {
+		modules = append(modules, "fdo.upload")
+	}
+	return modules
+}
+
+// ServiceInfoProfiles implements [fdo.ServiceInfoResolver] with a list of
+// profiles. The modules of every profile matching a device are used, in the
+// order of the profiles, so that common configuration may be combined with
+// configuration for specific devices.
+//
+// Resolution fails if a matching profile uses a module which the device does
+// not support, rather than onboarding the device without its configuration.
+type ServiceInfoProfiles []ServiceInfoProfile
+
+var _ fdo.ServiceInfoResolver = ServiceInfoProfiles(nil)
+
+// ResolveServiceInfo implements fdo.ServiceInfoResolver.
+func (profiles ServiceInfoProfiles) ResolveServiceInfo(ctx context.Context, device fdo.ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+	var matched []*ServiceInfoProfile
+	for i := range profiles {
+		p := &profiles[i]
+		ok, err := p.matches(device)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, module := range p.requires() {
+			if !device.Supports(module) {
+				return nil, fmt.Errorf("profile %q: device does not support %s", p.Name, module)
+			}
+		}
+		for _, dl := range p.Downloads {
+			if dl.Contents != nil {
+				continue
+			}
+			if dl.Provider != nil {
+				if _, err := dl.Provider.Stat(ctx, dl.Path); err != nil {
+					return nil, fmt.Errorf("profile %q: download %q: %w", p.Name, dl.Name, err)
+				}
+				continue
+			}
+			if _, err := os.Stat(filepath.Clean(dl.Path)); err != nil {
+				return nil, fmt.Errorf("profile %q: download %q: %w", p.Name, dl.Name, err)
+			}
+		}
+		matched = append(matched, p)
+	}
+
+	return func(yield func(string, serviceinfo.OwnerModule) bool) {
+		for _, p := range matched {
+			if !p.yieldModules(yield) {
+				return
+			}
+		}
+	}, nil
+}
+
+// yieldModules creates the modules of a profile, returning false if iteration
+// stopped. Files are opened as each download is yielded and closed by its
+// module, either once the device has received it or when the TO2 session
+// ends, since modules may be interleaved and outlive the iteration.
+func (p *ServiceInfoProfile) yieldModules(yield func(string, serviceinfo.OwnerModule) bool) bool {
+	for _, dl := range p.Downloads {
+		var mod serviceinfo.OwnerModule = &DownloadContents[*bytes.Reader]{
+			Name:         dl.Name,
+			Contents:     bytes.NewReader(dl.Contents),
+			MustDownload: dl.MustDownload,
+		}
+		switch {
+		case dl.Contents != nil:
+		case dl.Provider != nil:
+			artifact := &DownloadArtifact{
+				Name:         dl.Name,
+				Artifact:     dl.Path,
+				Provider:     dl.Provider,
+				Digests:      dl.Digests,
+				MustDownload: dl.MustDownload,
+			}
+			mod = artifact
+		default:
+			f, err := os.Open(filepath.Clean(dl.Path))
+			if err != nil {
+				mod = failedModule{fmt.Errorf("profile %q: download %q: %w", p.Name, dl.Name, err)}
+			} else {
+				mod = &DownloadContents[*os.File]{
+					Name:         dl.Name,
+					Contents:     f,
+					MustDownload: dl.MustDownload,
+				}
+			}
+		}
+		if !yield("fdo.download", mod) {
+			return false
+		}
+	}
+	for _, wget := range p.Wgets {
+		if !yield("fdo.wget", &WgetCommand{
+			Name:     wget.Name,
+			URL:      wget.URL,
+			Length:   wget.Length,
+			Checksum: wget.Checksum,
+		}) {
+			return false
+		}
+	}
+	for _, cmd := range p.Commands {
+		if !yield("fdo.command", &RunCommand{
+			Command: cmd.Command,
+			Args:    cmd.Args,
+			MayFail: cmd.MayFail,
+		}) {
+			return false
+		}
+	}
+	for _, up := range p.Uploads {
+		if !yield("fdo.upload", &UploadRequest{
+			Name:   up.Name,
+			Dir:    up.Dir,
+			Rename: up.Rename,
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// failedModule fails TO2 when it is first used.
+type failedModule struct{ err error }
+
+func (m failedModule) HandleInfo(context.Context, string, io.Reader) error { return m.err }
+
+func (m failedModule) ProduceInfo(context.Context, *serviceinfo.Producer) (bool, bool, error) {
+	return false, false, m.err
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// TimeModule is the name of the trusted time module. It is not defined by the
+// FIDO Alliance.
+//
+// The owner sends an active message, followed by a time message containing
+// the current time as a CBOR-encoded int of seconds since the Unix epoch. The
+// time is trusted, because TO2 service info is only exchanged after the owner
+// service has proven ownership of the device. If the device cannot set its
+// clock, it responds with an error message containing a CBOR-encoded tstr.
+//
+// Devices without a real-time clock should be sent the time before any
+// module whose checks depend on it, so owners should run this module first.
+const TimeModule = "go-fdo.time"
+
+// Time implements the device side of TimeModule.
+type Time struct {
+	// Clock is set to the time received from the owner service. It may also
+	// be used as the Clock of fdo.TO2Config, so that later timestamps are
+	// correct.
+	Clock *fdo.TrustedClock
+
+	// ErrorLog is optional and any failure to set the clock will have a
+	// corresponding message written.
+	ErrorLog io.Writer
+}
+
+var _ serviceinfo.DeviceModule = (*Time)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (t *Time) Transition(active bool) error { return nil }
+
+// Receive implements serviceinfo.DeviceModule.
+func (t *Time) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	if messageName != "time" {
+		return fmt.Errorf("unknown message %s", messageName)
+	}
+	var unix int64
+	if err := cbor.NewDecoder(messageBody).Decode(&unix); err != nil {
+		return fmt.Errorf("error decoding time: %w", err)
+	}
+	if t.Clock == nil {
+		err := errors.New("no clock")
+		if t.ErrorLog != nil {
+			_, _ = fmt.Fprintf(t.ErrorLog, "[%s] %v\n", TimeModule, err)
+		}
+		return serviceinfo.RespondError(respond, err)
+	}
+	t.Clock.Set(time.Unix(unix, 0))
+	return nil
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (t *Time) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	return nil
+}
+
+// SendTime implements the owner side of TimeModule. Devices which cannot set
+// their clock do not fail TO2.
+type SendTime struct {
+	// Clock, if not nil, is used in place of the system clock.
+	Clock fdo.Clock
+
+	// ErrorLog is optional and any error reported by the device will have a
+	// corresponding message written.
+	ErrorLog io.Writer
+
+	// Internal state
+	sent bool
+	done bool
+}
+
+var _ serviceinfo.OwnerModule = (*SendTime)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (s *SendTime) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		// The device has received the time in the same round
+		s.done = true
+		return nil
+
+	case serviceinfo.ErrorMessageName:
+		modErr, err := serviceinfo.DecodeError(TimeModule, messageBody)
+		if err != nil {
+			return err
+		}
+		if s.ErrorLog != nil {
+			_, _ = fmt.Fprintf(s.ErrorLog, "[%s] device could not set its clock: %v\n", TimeModule, modErr)
+		}
+		s.done = true
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (s *SendTime) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	if s.done {
+		return false, true, nil
+	}
+	if s.sent {
+		return false, false, nil
+	}
+	now := time.Now()
+	if s.Clock != nil {
+		now = s.Clock.Now()
+	}
+	body, err := cbor.Marshal(now.Unix())
+	if err != nil {
+		return false, false, err
+	}
+	if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+		return false, false, err
+	}
+	if err := producer.WriteChunk("time", body); err != nil {
+		return false, false, err
+	}
+	s.sent = true
+	return false, false, nil
+}
@@ -178,7 +178,7 @@ func (d *Wget) Yield(ctx context.Context, respond func(message string) io.Writer
 		defer d.reset()
 
 		if result.err != nil {
-			return cbor.NewEncoder(respond("error")).Encode(result.err.Error())
+			return serviceinfo.RespondError(respond, result.err)
 		}
 
 		return cbor.NewEncoder(respond("done")).Encode(result.len)
@@ -50,12 +50,12 @@ func (w *WgetCommand) HandleInfo(ctx context.Context, messageName string, messag
 		}
 		return nil
 
-	case "error":
-		var msg string
-		if err := cbor.NewDecoder(messageBody).Decode(&msg); err != nil {
-			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+	case serviceinfo.ErrorMessageName:
+		modErr, err := serviceinfo.DecodeError("fdo.wget", messageBody)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("device reported error: %s", msg)
+		return fmt.Errorf("device reported error: %v", modErr)
 
 	case "done":
 		var n int64
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func FuzzVoucher(f *testing.F) {
+	for _, name := range []string{"ov.pem", "ov_extended.pem"} {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		if blk, _ := pem.Decode(b); blk != nil {
+			f.Add(blk.Bytes)
+		}
+	}
+	fixture, err := fdotest.NewFixture(fdotest.FixtureOptions{Entries: 2})
+	if err != nil {
+		f.Fatal(err)
+	}
+	data, err := cbor.Marshal(fixture.Voucher)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(data, &ov); err != nil {
+			return
+		}
+		_ = ov.VerifyCertChainHash()
+		_ = ov.VerifyDeviceCertChain(nil)
+		_ = ov.VerifyManufacturerCertChain(nil)
+		_ = ov.VerifyEntries()
+		_, _ = ov.DevicePublicKey()
+		_, _ = ov.OwnerPublicKey()
+	})
+}
+
+func FuzzEAT(f *testing.F) {
+	for _, claims := range []map[int]any{
+		{10: make([]byte, 16), 256: append([]byte{1}, make([]byte, 16)...)},
+		{10: make([]byte, 16), 256: append([]byte{1}, make([]byte, 16)...), -257: []any{[]byte("xB")}},
+	} {
+		data, err := cbor.Marshal(claims)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = fdo.ParseEAT(data)
+	})
+}
+
+// Fuzz the first message handled by a fresh protocol session. Seeds are taken
+// from a successful onboarding.
+func FuzzServerRequest(f *testing.F) {
+	server := fdotest.NewServer(f)
+	dev := server.NewDevice(f, protocol.Secp256r1KeyType)
+	server.RegisterBlob(f, dev.Cred.GUID)
+	if err := server.Onboard(f, dev, nil); err != nil {
+		f.Fatal(err)
+	}
+	for _, ex := range server.Exchanges(f) {
+		f.Add(ex.MsgType, ex.Request)
+	}
+
+	f.Fuzz(func(t *testing.T, msgType uint8, data []byte) {
+		var resp protocol.Responder
+		prot := protocol.Of(msgType)
+		switch prot {
+		case protocol.DIProtocol:
+			resp = server.DI
+		case protocol.TO0Protocol:
+			resp = server.TO0
+		case protocol.TO1Protocol:
+			resp = server.TO1
+		case protocol.TO2Protocol:
+			resp = server.TO2
+		default:
+			return
+		}
+
+		ctx := context.Background()
+		token, err := server.State.NewToken(ctx, prot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx = server.State.TokenContext(ctx, token)
+		_, _ = resp.Respond(ctx, msgType, bytes.NewReader(data))
+		_ = server.State.InvalidateToken(ctx)
+	})
+}
+
+// Fuzz the first TO2.DeviceServiceInfo of an otherwise valid TO2 session, which
+// includes devmod.
+func FuzzDeviceServiceInfo(f *testing.F) {
+	for _, kvs := range [][]*serviceinfo.KV{
+		{},
+		{
+			{Key: "devmod:active", Val: []byte{0xf5}},
+			{Key: "devmod:nummodules", Val: []byte{0x01}},
+			{Key: "devmod:modules", Val: []byte{0x83, 0x00, 0x01, 0x66, 'd', 'e', 'v', 'm', 'o', 'd'}},
+		},
+	} {
+		data, err := cbor.Marshal(struct {
+			IsMoreServiceInfo bool
+			ServiceInfo       []*serviceinfo.KV
+		}{false, kvs})
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	server := fdotest.NewServer(f)
+	server.TO2.ReuseCredential = func(context.Context, fdo.Voucher) bool { return true }
+	dev := server.NewDevice(f, protocol.Secp256r1KeyType)
+	server.RegisterBlob(f, dev.Cred.GUID)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var replaced bool
+		server.Intercept = func(msgType uint8, msg any) any {
+			if msgType != protocol.TO2DeviceServiceInfoMsgType || replaced {
+				return msg
+			}
+			replaced = true
+			return cbor.RawBytes(data)
+		}
+		_ = server.Onboard(t, dev, nil)
+		server.ResetExchanges()
+	})
+}
@@ -4,9 +4,8 @@
 package fdo
 
 import (
+	"context"
 	"crypto/hmac"
-	"crypto/sha256"
-	"crypto/sha512"
 	"fmt"
 	"hash"
 
@@ -18,67 +17,113 @@ type fallibleHash interface {
 	Err() error
 }
 
-// Compute an hmac.
-func hmacHash(h hash.Hash, v any) (protocol.Hmac, error) {
-	var hmac protocol.Hmac
-	switch size := h.Size(); size {
-	case sha256.Size:
-		hmac.Algorithm = protocol.HmacSha256Hash
-		if h == nil {
-			panic("HMAC-SHA256 support is required")
-		}
-	case sha512.Size384:
-		hmac.Algorithm = protocol.HmacSha384Hash
-		if h == nil {
-			return protocol.Hmac{}, fmt.Errorf("unsupported hash algorithm: HMAC-SHA384")
-		}
-	default:
-		return protocol.Hmac{}, fmt.Errorf("unsupported hash size: %d", size)
+// DeviceHmac computes HMACs with the device secret, which does not change
+// when ownership is transferred. It generalizes a pair of hash.Hash values so
+// that the secret need not be held locally, i.e. a factory HSM service may
+// compute the voucher header HMAC during DI.
+//
+// Unlike hash.Hash, the complete message is given at once and computation may
+// be asynchronous, so implementations should abort when ctx is done and
+// return any error rather than holding it in the hash state.
+type DeviceHmac interface {
+	// Hmac returns the HMAC of data using alg, which is HmacSha256Hash or
+	// HmacSha384Hash. HMAC-SHA256 support is required by spec, while
+	// HMAC-SHA384 is only required for RSA 3072 and EC P-384 device keys.
+	Hmac(ctx context.Context, alg protocol.HashAlg, data []byte) ([]byte, error)
+}
+
+// DeviceHmacFunc is an adapter to allow the use of ordinary functions, such
+// as a client of a remote HMAC service, as a DeviceHmac.
+type DeviceHmacFunc func(ctx context.Context, alg protocol.HashAlg, data []byte) ([]byte, error)
+
+// Hmac implements DeviceHmac.
+func (f DeviceHmacFunc) Hmac(ctx context.Context, alg protocol.HashAlg, data []byte) ([]byte, error) {
+	return f(ctx, alg, data)
+}
+
+// LocalHmac returns a DeviceHmac using hashes keyed with the device secret.
+// hmacSha384 may be nil if HMAC-SHA384 is not supported.
+//
+// The hashes may optionally implement the following interface to return
+// errors from Reset/Write/Sum, noting that implementations of hash.Hash are
+// not supposed to return non-nil errors from Write.
+//
+//	type FallibleHash interface {
+//		Err() error
+//	}
+func LocalHmac(hmacSha256, hmacSha384 hash.Hash) DeviceHmac {
+	return localHmac{sha256: hmacSha256, sha384: hmacSha384}
+}
+
+type localHmac struct {
+	sha256, sha384 hash.Hash
+}
+
+func (l localHmac) Hmac(_ context.Context, alg protocol.HashAlg, data []byte) ([]byte, error) {
+	var h hash.Hash
+	switch alg {
+	case protocol.HmacSha256Hash:
+		h = l.sha256
+	case protocol.HmacSha384Hash:
+		h = l.sha384
+	}
+	if h == nil {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", alg)
 	}
 
 	h.Reset()
-	if err := cbor.NewEncoder(h).Encode(v); err != nil {
-		return protocol.Hmac{}, fmt.Errorf("error computing hmac: marshaling payload: %w", err)
-	}
-	hmac.Value = h.Sum(nil)
+	_, _ = h.Write(data)
+	mac := h.Sum(nil)
 	if fallible, ok := h.(fallibleHash); ok {
 		if err := fallible.Err(); err != nil {
-			return protocol.Hmac{}, fmt.Errorf("error computing hmac: %w", err)
+			return nil, err
 		}
 	}
-	return hmac, nil
+	return mac, nil
 }
 
-// hmacVerify encodes the given value to CBOR and verifies that the given HMAC
-// matches it. If the cryptographic portion of verification fails, then
-// ErrCryptoVerifyFailed is wrapped.
-func hmacVerify(h256, h384 hash.Hash, h1 protocol.Hmac, v any) error {
-	if h256 == nil {
+// deviceHmacOrLocal returns h, if set, or else a DeviceHmac using the local
+// hashes.
+func deviceHmacOrLocal(h DeviceHmac, hmacSha256, hmacSha384 hash.Hash) DeviceHmac {
+	if h != nil {
+		return h
+	}
+	if hmacSha256 == nil {
 		panic("HMAC-SHA256 support is required")
 	}
+	return LocalHmac(hmacSha256, hmacSha384)
+}
 
-	var h hash.Hash
-	switch h1.Algorithm {
-	case protocol.HmacSha256Hash:
-		h = h256
-	case protocol.HmacSha384Hash:
-		h = h384
+// Compute an hmac.
+func hmacHash(ctx context.Context, h DeviceHmac, alg protocol.HashAlg, v any) (protocol.Hmac, error) {
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		return protocol.Hmac{}, fmt.Errorf("error computing hmac: marshaling payload: %w", err)
 	}
-	if h == nil {
-		return fmt.Errorf("unsupported hash algorithm: %s", h1.Algorithm)
+	mac, err := h.Hmac(ctx, alg, data)
+	if err != nil {
+		return protocol.Hmac{}, fmt.Errorf("error computing hmac: %w", err)
+	}
+	if len(mac) != alg.HashFunc().Size() {
+		return protocol.Hmac{}, fmt.Errorf("error computing hmac: unexpected size %d for %s", len(mac), alg)
 	}
+	return protocol.Hmac{Algorithm: alg, Value: mac}, nil
+}
 
-	h.Reset()
-	if err := cbor.NewEncoder(h).Encode(v); err != nil {
-		return fmt.Errorf("error computing hmac: marshaling payload: %w", err)
+// hmacVerify encodes the given value to CBOR and verifies that the given HMAC
+// matches it. If the cryptographic portion of verification fails, then
+// ErrCryptoVerifyFailed is wrapped.
+func hmacVerify(ctx context.Context, h DeviceHmac, h1 protocol.Hmac, v any) error {
+	switch h1.Algorithm {
+	case protocol.HmacSha256Hash, protocol.HmacSha384Hash:
+	default:
+		return fmt.Errorf("unsupported hash algorithm: %s", h1.Algorithm)
 	}
-	mac2 := h.Sum(nil)
-	if fallible, ok := h.(fallibleHash); ok {
-		if err := fallible.Err(); err != nil {
-			return fmt.Errorf("error computing hmac: %w", err)
-		}
+	h2, err := hmacHash(ctx, h, h1.Algorithm, v)
+	if err != nil {
+		return err
 	}
-	if !hmac.Equal(h1.Value, mac2) {
+	if !hmac.Equal(h1.Value, h2.Value) {
 		return fmt.Errorf("%w: hmac did not match", ErrCryptoVerifyFailed)
 	}
 	return nil
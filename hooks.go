@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ClientHooks are optional callbacks invoked as a device progresses through
+// onboarding, such as to drive a progress UI or feed a watchdog. Any hook may
+// be nil.
+//
+// Hooks are called synchronously by the protocol, so they should return
+// quickly. Arguments must not be modified.
+type ClientHooks struct {
+	// OnTO1Complete is called with the signed rendezvous blob when TO1
+	// succeeds.
+	OnTO1Complete func(to1d *cose.Sign1[protocol.To1d, []byte])
+
+	// OnVoucherVerified is called during TO2 once the ownership voucher
+	// received from the owner service has been fully verified.
+	OnVoucherVerified func(ov *Voucher)
+
+	// OnServiceInfoProgress is called during TO2 after each service info
+	// message exchange for each module with service info in that exchange.
+	// Sent and received are the total sizes in bytes of all service info
+	// exchanged for the module so far.
+	OnServiceInfoProgress func(module string, sent, received int)
+
+	// OnModuleError is called during TO2 when a device module fails with a
+	// recoverable error. The error is reported to the owner service and TO2
+	// continues without the module.
+	OnModuleError func(module string, err error)
+
+	// OnError is called when TO1 or TO2 fails. PrevMsgType is the type of
+	// the last message received from the server, or zero if the failure
+	// occurred before any response was received.
+	OnError func(prot protocol.Protocol, prevMsgType uint8, err error)
+}
+
+func (h ClientHooks) to1Complete(to1d *cose.Sign1[protocol.To1d, []byte]) {
+	if h.OnTO1Complete != nil {
+		h.OnTO1Complete(to1d)
+	}
+}
+
+func (h ClientHooks) voucherVerified(ov *Voucher) {
+	if h.OnVoucherVerified != nil {
+		h.OnVoucherVerified(ov)
+	}
+}
+
+func (h ClientHooks) moduleError(module string, err error) {
+	if h.OnModuleError != nil {
+		h.OnModuleError(module, err)
+	}
+}
+
+func (h ClientHooks) error(ctx context.Context, prot protocol.Protocol, err error) {
+	if h.OnError != nil {
+		h.OnError(prot, errMsgFromContext(ctx).PrevMsgType, err)
+	}
+}
+
+// serviceInfoProgress tracks total service info sizes per module.
+type serviceInfoProgress struct {
+	hook     func(module string, sent, received int)
+	sent     map[string]int
+	received map[string]int
+}
+
+func newServiceInfoProgress(hooks ClientHooks) *serviceInfoProgress {
+	return &serviceInfoProgress{
+		hook:     hooks.OnServiceInfoProgress,
+		sent:     make(map[string]int),
+		received: make(map[string]int),
+	}
+}
+
+// exchanged records the service info of one message exchange and calls the
+// hook for each module involved.
+func (p *serviceInfoProgress) exchanged(sent, received []*serviceinfo.KV) {
+	if p == nil || p.hook == nil {
+		return
+	}
+	var modules []string
+	count := func(kvs []*serviceinfo.KV, totals map[string]int) {
+		for _, kv := range kvs {
+			module, _, _ := strings.Cut(kv.Key, ":")
+			if !slices.Contains(modules, module) {
+				modules = append(modules, module)
+			}
+			totals[module] += int(kv.Size())
+		}
+	}
+	count(sent, p.sent)
+	count(received, p.received)
+	for _, module := range modules {
+		p.hook(module, p.sent[module], p.received[module])
+	}
+}
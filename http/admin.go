@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Admin handlers respond with JSON and report errors as {"error": "..."}.
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("error writing admin response", "error", err)
+	}
+}
+
+func writeJSONErr(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// authorize runs an optional authorization func and writes a 403 response on
+// failure.
+func authorize(w http.ResponseWriter, r *http.Request, auth func(*http.Request) error) bool {
+	if auth == nil {
+		return true
+	}
+	if err := auth(r); err != nil {
+		writeJSONErr(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// CaptivePortalError is returned when a response appears to come from a
+// captive portal, such as the login page of a guest or hotel network, rather
+// than an FDO server. The device must be granted network access, usually by a
+// person accepting the terms of the portal, before onboarding can succeed.
+type CaptivePortalError struct {
+	// URL is the URL of the FDO request.
+	URL string
+
+	// Location is the URL of the portal, if the request was redirected.
+	Location string
+
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+}
+
+func (e *CaptivePortalError) Error() string {
+	if e.Location != "" {
+		return fmt.Sprintf("captive portal detected: request to %s was redirected to %s (HTTP %d)",
+			e.URL, e.Location, e.StatusCode)
+	}
+	return fmt.Sprintf("captive portal detected: request to %s received an HTML page (HTTP %d)",
+		e.URL, e.StatusCode)
+}
+
+// captivePortal checks whether a response to a request was intercepted. FDO
+// servers never redirect and always respond with CBOR, so a redirect or an
+// HTML page indicates an intercepting network.
+func captivePortal(reqURL *url.URL, resp *http.Response) *CaptivePortalError {
+	portal := &CaptivePortalError{URL: reqURL.Redacted(), StatusCode: resp.StatusCode}
+
+	// A redirect which was not followed by the client
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		if loc, err := resp.Location(); err == nil {
+			portal.Location = loc.Redacted()
+		}
+		return portal
+	}
+
+	// A redirect which was followed by the client
+	if final := resp.Request.URL; final.Host != reqURL.Host || final.Path != reqURL.Path {
+		portal.Location = final.Redacted()
+		return portal
+	}
+
+	// A page served in place of the FDO response
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode == http.StatusOK && resp.Header.Get("Message-Type") == "" &&
+		(mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		return portal
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ConnectionConfig tunes connection pooling, keep-alive, and HTTP/2 of the
+// client built by a Transport. Zero values use the defaults of
+// net/http.DefaultTransport.
+//
+// An FDO protocol is a sequence of requests to one server, so by default all
+// messages of a protocol are sent over one connection, which avoids a TCP and
+// TLS handshake per message on high-latency links.
+type ConnectionConfig struct {
+	// DialTimeout limits establishing a TCP connection.
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval of TCP keep-alive probes of open
+	// connections. Negative values disable probes.
+	KeepAlive time.Duration
+
+	// DisableKeepAlives opens a new connection for each request.
+	DisableKeepAlives bool
+
+	// MaxIdleConnsPerHost is the number of idle connections kept for reuse
+	// per host.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept for reuse.
+	// Longer timeouts allow reusing connections across protocols, such as
+	// after a delay between TO1 and TO2.
+	IdleConnTimeout time.Duration
+
+	// TLSHandshakeTimeout limits TLS handshakes.
+	TLSHandshakeTimeout time.Duration
+
+	// TLSClientConfig configures TLS connections.
+	TLSClientConfig *tls.Config
+
+	// DisableHTTP2 uses HTTP/1.1 for all requests. Otherwise, HTTP/2 is used
+	// for https URLs when the server supports it.
+	DisableHTTP2 bool
+}
+
+// Client returns a new HTTP client with its own connection pool. Proxy
+// selects the proxy of each request, as in net/http.Transport. If nil,
+// proxies are read from the environment.
+func (c ConnectionConfig) Client(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	rt := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		rt.Proxy = proxy
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if c.DialTimeout != 0 {
+		dialer.Timeout = c.DialTimeout
+	}
+	if c.KeepAlive != 0 {
+		dialer.KeepAlive = c.KeepAlive
+	}
+	rt.DialContext = dialer.DialContext
+
+	rt.DisableKeepAlives = c.DisableKeepAlives
+	if c.MaxIdleConnsPerHost != 0 {
+		rt.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout != 0 {
+		rt.IdleConnTimeout = c.IdleConnTimeout
+	}
+	if c.TLSHandshakeTimeout != 0 {
+		rt.TLSHandshakeTimeout = c.TLSHandshakeTimeout
+	}
+	if c.TLSClientConfig != nil {
+		rt.TLSClientConfig = c.TLSClientConfig.Clone()
+	}
+	if c.DisableHTTP2 {
+		// A non-nil, empty map disables HTTP/2
+		rt.ForceAttemptHTTP2 = false
+		rt.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return &http.Client{Transport: rt}
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight
+// response.
+const corsMaxAge = 600
+
+// CORSHandler allows FDO requests from web pages of other origins, such as a
+// browser acting as a demo device. Browsers only expose the Authorization,
+// Message-Type, and Retry-After response headers, which carry the session
+// token, message type, and back off delay, to pages which are explicitly
+// allowed, so a browser device cannot onboard without it.
+//
+// Requests without an Origin header, such as those of non-browser devices,
+// are passed to Handler unchanged.
+type CORSHandler struct {
+	Handler http.Handler
+
+	// AllowedOrigins are the origins, such as "https://demo.example.com",
+	// allowed to send FDO requests. "*" allows any origin, which should only
+	// be used for demos, because any page could then act as a device.
+	AllowedOrigins []string
+}
+
+func (h CORSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if !slices.Contains(h.AllowedOrigins, origin) && !slices.Contains(h.AllowedOrigins, "*") {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "Authorization, Message-Type, Retry-After")
+	h.Handler.ServeHTTP(w, r)
+}
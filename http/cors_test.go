@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	transport "github.com/fido-device-onboard/go-fdo/http"
+)
+
+func TestCORSHandler(t *testing.T) {
+	var handled int
+	handler := transport.CORSHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handled++
+			w.Header().Set("Message-Type", "61")
+		}),
+		AllowedOrigins: []string{"https://demo.example.com"},
+	}
+	do := func(method, origin string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/fdo/101/msg/60", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Preflight from an allowed origin is answered without the handler
+	rr := do(http.MethodOptions, "https://demo.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "authorization,content-type",
+	})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("preflight: expected 204, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://demo.example.com" {
+		t.Errorf("preflight: unexpected allowed origin %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, Content-Type" {
+		t.Errorf("preflight: unexpected allowed headers %q", got)
+	}
+	if handled != 0 {
+		t.Fatal("preflight was passed to handler")
+	}
+
+	// Requests from an allowed origin expose the FDO headers
+	rr = do(http.MethodPost, "https://demo.example.com", nil)
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "Authorization, Message-Type, Retry-After" {
+		t.Errorf("unexpected exposed headers %q", got)
+	}
+	if got := rr.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", got)
+	}
+
+	// Requests from other origins are handled without CORS headers, so the
+	// browser blocks the response
+	rr = do(http.MethodPost, "https://evil.example.com", nil)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin: unexpected allowed origin %q", got)
+	}
+
+	// Requests without an origin are unchanged
+	rr = do(http.MethodPost, "", nil)
+	if got := rr.Header().Get("Vary"); got != "" {
+		t.Errorf("no origin: unexpected Vary %q", got)
+	}
+	if handled != 3 {
+		t.Fatalf("expected 3 requests to be handled, got %d", handled)
+	}
+}
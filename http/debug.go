@@ -6,16 +6,32 @@ package http
 import (
 	"context"
 	"encoding/hex"
+	"io"
 	"log/slog"
 
 	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
+	"github.com/fido-device-onboard/go-fdo/internal/smallheap"
 )
 
 func debugEnabled() bool {
 	return slog.Default().Enabled(context.Background(), slog.LevelDebug)
 }
 
+// debugBody limits reading a message body for logging to one byte more than
+// the max content length, so that logging does not buffer oversized bodies.
+// The rest of the body is left to be read when handling the message, which
+// fails due to the size.
+func debugBody(body io.Reader, configured int64) io.Reader {
+	if limit := maxContentLength(configured); limit > 0 {
+		return io.LimitReader(body, limit+1)
+	}
+	return body
+}
+
 func tryDebugNotation(b []byte) string {
+	if smallheap.Enabled {
+		return hex.EncodeToString(b)
+	}
 	d, err := cdn.FromCBOR(b)
 	if err != nil {
 		return hex.EncodeToString(b)
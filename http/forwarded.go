@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// peer returns the client of a request. X-Forwarded-For and
+// X-Forwarded-Proto are only honored when set by a trusted proxy, so that
+// clients cannot spoof their address.
+func (h Handler) peer(r *http.Request) protocol.Peer {
+	peer := protocol.Peer{TLS: r.TLS != nil}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return peer
+	}
+	peer.Addr = addr.Unmap()
+	if !h.trusted(peer.Addr) {
+		return peer
+	}
+
+	// Use the protocol set by the nearest proxy, which is trusted
+	if proto := forwardedValues(r.Header, "X-Forwarded-Proto"); len(proto) > 0 {
+		peer.TLS = strings.EqualFold(proto[len(proto)-1], "https")
+	}
+
+	// Walk the chain of proxies from the nearest, stopping at the first
+	// address which is not a trusted proxy, since any addresses before it may
+	// have been set by the client
+	forwarded := forwardedValues(r.Header, "X-Forwarded-For")
+	for i := len(forwarded) - 1; i >= 0 && h.trusted(peer.Addr); i-- {
+		addr, err := netip.ParseAddr(forwarded[i])
+		if err != nil {
+			break
+		}
+		peer.Addr = addr.Unmap()
+	}
+	return peer
+}
+
+func (h Handler) trusted(addr netip.Addr) bool {
+	for _, prefix := range h.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedValues returns the comma-separated values of all instances of a
+// header in order.
+func forwardedValues(header http.Header, key string) []string {
+	var values []string
+	for _, line := range header.Values(key) {
+		for _, value := range strings.Split(line, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -6,27 +6,25 @@ package http
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
-	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
-	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
 const bearerPrefix = "Bearer "
 
-// Handler implements http.Handler and responds to all DI, TO1, and TO2 message
-// types.
+// Handler implements http.Handler and responds to all DI, TO0, TO1, and TO2
+// message types using a protocol.Dispatcher.
 type Handler struct {
 	Tokens protocol.TokenService
 
@@ -35,9 +33,44 @@ type Handler struct {
 	TO1Responder protocol.Responder
 	TO2Responder protocol.Responder
 
-	// MaxContentLength defaults to 65535. Negative values disable content
-	// length checking.
+	// MaxContentLength defaults to 65535. Message bodies are decoded as they
+	// are read, so bodies of unknown length, such as those using chunked
+	// transfer encoding, are accepted and fail once the max is exceeded.
+	// Negative values disable content length checking.
 	MaxContentLength int64
+
+	// ProtocolVersion is the FDO protocol version of devices and owner
+	// services using this handler. Vouchers created by DI have this version.
+	// If zero, protocol.CurrentVersion is used.
+	//
+	// To onboard both FDO 1.0 and 1.1 devices, mount a handler for each
+	// version at its path prefix:
+	//
+	//	mux.Handle("POST /fdo/100/msg/{msg}", transport.Handler{ProtocolVersion: 100, ...})
+	//	mux.Handle("POST /fdo/101/msg/{msg}", transport.Handler{ProtocolVersion: 101, ...})
+	ProtocolVersion uint16
+
+	// TrustedProxies are the networks of reverse proxies, such as those
+	// terminating TLS, whose X-Forwarded-For and X-Forwarded-Proto headers
+	// are trusted to identify the client. The client is logged, limited by
+	// RateLimit, and passed to responders with protocol.ContextWithPeer.
+	//
+	// If empty, forwarded headers are ignored and the client is the remote
+	// address of the connection.
+	//
+	// The client does not affect the RVTO2Addrs given to devices in TO1,
+	// which are signed by the owner in TO0 and so must be configured with
+	// the external address of the proxy.
+	TrustedProxies []netip.Prefix
+
+	// RateLimit, if not nil, limits the rate of requests from each client.
+	// Requests over the limit are answered with 503 Service Unavailable and
+	// a Retry-After header, so that devices back off and retry.
+	RateLimit *RateLimiter
+
+	// Tracer, if not nil, records a trace of each message handled. See
+	// protocol.Dispatcher.
+	Tracer protocol.Tracer
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -48,7 +81,10 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	msgType := uint8(typ)
-	proto := protocol.Of(msgType)
+	if h.ProtocolVersion != 0 && !protocol.SupportedVersion(h.ProtocolVersion) {
+		writeErr(w, msgType, fmt.Errorf("unsupported protocol version %d", h.ProtocolVersion))
+		return
+	}
 
 	// Parse request headers
 	token := r.Header.Get("Authorization")
@@ -57,76 +93,49 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	token = strings.TrimPrefix(token, bearerPrefix)
-	ctx := h.Tokens.TokenContext(r.Context(), token)
-
-	// Get responder for message
-	var resp protocol.Responder
-	var isProtocolStart bool
-	switch proto {
-	case protocol.DIProtocol:
-		resp = h.DIResponder
-		isProtocolStart = msgType == 10
-	case protocol.TO0Protocol:
-		resp = h.TO0Responder
-		isProtocolStart = msgType == 20
-	case protocol.TO1Protocol:
-		resp = h.TO1Responder
-		isProtocolStart = msgType == 30
-	case protocol.TO2Protocol:
-		resp = h.TO2Responder
-		isProtocolStart = msgType == 60
-	case protocol.AnyProtocol:
-		// Immediately respond to an error
-		if token == "" {
-			return
-		}
-		if err := h.Tokens.InvalidateToken(ctx); err != nil {
-			slog.Warn("invalidating token", "error", err)
-		}
-		return
-	}
-	if resp == nil {
-		writeErr(w, msgType, fmt.Errorf("unsupported message type"))
-		return
-	}
-
-	// Inject token state into context to keep method signatures clean while
-	// allowing some implementations to mutate tokens on every message.
-	if isProtocolStart {
-		initToken, err := h.Tokens.NewToken(ctx, proto)
-		if err != nil {
-			writeErr(w, msgType, protocol.ErrorMessage{
-				Code:          500,
-				PrevMsgType:   msgType,
-				ErrString:     err.Error(),
-				Timestamp:     time.Now().Unix(),
-				CorrelationID: nil,
-			})
-			return
-		}
-		ctx = h.Tokens.TokenContext(ctx, initToken)
-	}
 
 	if debugEnabled() {
-		h.debugRequest(ctx, w, r, msgType, resp)
+		h.debugRequest(w, r, token, msgType)
 		return
 	}
-	h.handleRequest(ctx, w, r, msgType, resp)
+	h.handleRequest(w, r, token, msgType)
 }
 
-func (h Handler) debugRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, msgType uint8, resp protocol.Responder) {
+// Shutdown gracefully shuts down the responders, such as *fdo.TO2Server, so
+// that new TO2 sessions are rejected while in-flight sessions are allowed to
+// end. It returns when they have ended or the context ends.
+//
+// Since devices must be able to complete in-flight sessions, Shutdown should
+// be called before http.Server.Shutdown, rather than registered with
+// http.Server.RegisterOnShutdown:
+//
+//	err := handler.Shutdown(ctx)
+//	err = errors.Join(err, server.Shutdown(ctx))
+func (h Handler) Shutdown(ctx context.Context) error {
+	dispatcher := protocol.Dispatcher{
+		DIResponder:  h.DIResponder,
+		TO0Responder: h.TO0Responder,
+		TO1Responder: h.TO1Responder,
+		TO2Responder: h.TO2Responder,
+	}
+	return dispatcher.Shutdown(ctx)
+}
+
+func (h Handler) debugRequest(w http.ResponseWriter, r *http.Request, token string, msgType uint8) {
 	// Dump request
 	debugReq, _ := httputil.DumpRequest(r, false)
 	var saveBody bytes.Buffer
-	if _, err := saveBody.ReadFrom(r.Body); err == nil {
-		r.Body = io.NopCloser(&saveBody)
+	if _, err := saveBody.ReadFrom(debugBody(r.Body, h.MaxContentLength)); err == nil {
+		r.Body = io.NopCloser(io.MultiReader(&saveBody, r.Body))
 	}
+	peer := h.peer(r)
 	slog.Debug("request", "dump", string(bytes.TrimSpace(debugReq)),
-		"body", tryDebugNotation(saveBody.Bytes()))
+		"body", tryDebugNotation(saveBody.Bytes()),
+		"client", peer.Addr, "tls", peer.TLS)
 
 	// Dump response
 	rr := httptest.NewRecorder()
-	h.handleRequest(ctx, rr, r, msgType, resp)
+	h.handleRequest(rr, r, token, msgType)
 	debugResp, _ := httputil.DumpResponse(rr.Result(), false)
 	slog.Debug("response", "dump", string(bytes.TrimSpace(debugResp)),
 		"body", tryDebugNotation(rr.Body.Bytes()))
@@ -141,146 +150,103 @@ func (h Handler) debugRequest(ctx context.Context, w http.ResponseWriter, r *htt
 	_, _ = w.Write(rr.Body.Bytes())
 }
 
-func (h Handler) handleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, msgType uint8, resp protocol.Responder) {
-	// Validate content length
-	maxSize := h.MaxContentLength
-	if maxSize == 0 {
-		maxSize = 65535
+func (h Handler) handleRequest(w http.ResponseWriter, r *http.Request, token string, msgType uint8) {
+	defer func() { _ = r.Body.Close() }()
+
+	// Limit the rate of requests from the client, as seen through trusted
+	// proxies
+	peer := h.peer(r)
+	if h.RateLimit != nil {
+		if wait, ok := h.RateLimit.allow(peer.Addr, time.Now()); !ok {
+			writeRetry(w, msgType, wait, fmt.Errorf("rate limit exceeded"))
+			return
+		}
 	}
+
+	// Reject content which is known to be too large
+	maxSize := maxContentLength(h.MaxContentLength)
 	if maxSize > 0 && r.ContentLength > maxSize {
-		_ = r.Body.Close()
 		writeErr(w, msgType, fmt.Errorf("content too large (%d bytes)", r.ContentLength))
 		return
 	}
-	if maxSize > 0 && r.ContentLength < 0 {
-		_ = r.Body.Close()
-		writeErr(w, msgType, errors.New("content length must be specified in request headers"))
-		return
-	}
-
-	// Allow reading up to expected msg length
-	msg := r.Body
-	if r.ContentLength > 0 {
-		msg = io.ReadCloser(struct {
-			io.Reader
-			io.Closer
-		}{
-			Reader: io.LimitReader(r.Body, r.ContentLength),
-			Closer: r.Body,
-		})
-	}
-
-	// Decrypt TO2 messages after 64
-	if protocol.TO2ProveDeviceMsgType < msgType && msgType < protocol.ErrorMsgType {
-		sess, err := resp.(interface {
-			CryptSession(ctx context.Context) (kex.Session, error)
-		}).CryptSession(ctx)
-		if err != nil {
-			writeErr(w, msgType, err)
-			return
-		}
-		defer sess.Destroy()
-		defer func() { _ = r.Body.Close() }()
-
-		decrypted, err := sess.Decrypt(rand.Reader, msg)
-		if err != nil {
-			writeErr(w, msgType, fmt.Errorf("error decrypting message %d: %w", msgType, err))
-			return
-		}
 
-		if debugEnabled() {
-			slog.Debug("decrypted request", "msg", msgType, "body", tryDebugNotation(decrypted))
-		}
-
-		msg = io.NopCloser(bytes.NewBuffer(decrypted))
+	// Stream the message body to the responder, failing once more than the
+	// max size has been read. The body is never buffered, so requests of
+	// unknown length, such as those using chunked transfer encoding, are
+	// allowed.
+	var msg io.Reader = r.Body
+	if maxSize > 0 {
+		msg = http.MaxBytesReader(w, r.Body, maxSize)
 	}
 
 	// Handle request message
-	h.writeResponse(ctx, w, msgType, msg, resp)
-}
-
-func (h Handler) writeResponse(ctx context.Context, w http.ResponseWriter, msgType uint8, msg io.Reader, resp protocol.Responder) {
-	// Perform business logic of message handling
-	respType, respData := resp.Respond(ctx, msgType, msg)
-	if respType == protocol.ErrorMsgType {
-		if err := h.Tokens.InvalidateToken(ctx); err != nil {
-			slog.Warn("error invalidating token", "error", err)
-		}
-	}
-
-	// Encrypt TO2 messages beginning with 64
-	if protocol.TO2ProveDeviceMsgType < respType && respType < protocol.ErrorMsgType {
-		sess, err := resp.(interface {
-			CryptSession(ctx context.Context) (kex.Session, error)
-		}).CryptSession(ctx)
-		if err != nil {
-			writeErr(w, msgType, err)
-			return
-		}
-		defer sess.Destroy()
-
-		if debugEnabled() {
-			body, _ := cbor.Marshal(respData)
-			slog.Debug("unencrypted response", "msg", respType, "body", tryDebugNotation(body))
-		}
-
-		respData, err = sess.Encrypt(rand.Reader, respData)
-		if err != nil {
-			writeErr(w, msgType, fmt.Errorf("error encrypting message %d: %w", respType, err))
-			return
-		}
+	dispatcher := protocol.Dispatcher{
+		Tokens:       h.Tokens,
+		DIResponder:  h.DIResponder,
+		TO0Responder: h.TO0Responder,
+		TO1Responder: h.TO1Responder,
+		TO2Responder: h.TO2Responder,
+		Tracer:       h.Tracer,
+	}
+	version := h.ProtocolVersion
+	if version == 0 {
+		version = protocol.CurrentVersion
+	}
+	ctx := protocol.ContextWithVersion(r.Context(), version)
+	ctx = protocol.ContextWithPeer(ctx, peer)
+	resp, err := dispatcher.Dispatch(ctx, token, msgType, msg)
+	if resp == nil {
+		return
 	}
-
-	// Invalidate token when finishing a protocol or erroring
-	newToken, _ := h.Tokens.TokenFromContext(ctx)
-	switch respType {
-	case 13, 32, 71, protocol.ErrorMsgType:
-		if newToken != "" {
-			ctx := h.Tokens.TokenContext(ctx, newToken)
-			if err := h.Tokens.InvalidateToken(ctx); err != nil {
-				slog.Warn("invalidating token", "error", err)
-			}
-		}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, resp)
+		return
 	}
-
-	// Marshal response to get size
-	var body bytes.Buffer
-	if err := cbor.NewEncoder(&body).Encode(respData); err != nil {
-		writeErr(w, msgType, fmt.Errorf("error marshaling response message %d: %w", respType, err))
+	w.Header().Add("Authorization", bearerPrefix+resp.Token)
+	if resp.RetryAfter > 0 {
+		setRetryAfter(w, resp.RetryAfter)
+		writeResponse(w, http.StatusServiceUnavailable, resp)
 		return
 	}
+	writeResponse(w, http.StatusOK, resp)
+}
 
-	// Add response headers
-	w.Header().Add("Authorization", bearerPrefix+newToken)
-	w.Header().Add("Content-Length", strconv.Itoa(body.Len()))
+func writeResponse(w http.ResponseWriter, status int, resp *protocol.Response) {
+	w.Header().Add("Content-Length", strconv.Itoa(len(resp.Body)))
 	w.Header().Add("Content-Type", "application/cbor")
-	w.Header().Add("Message-Type", strconv.Itoa(int(respType)))
-	w.WriteHeader(http.StatusOK)
-
-	if _, err := w.Write(body.Bytes()); err != nil {
-		writeErr(w, msgType, fmt.Errorf("error writing response message %d: %w", respType, err))
-		return
-	}
+	w.Header().Add("Message-Type", strconv.Itoa(int(resp.MsgType)))
+	w.WriteHeader(status)
+	_, _ = w.Write(resp.Body)
 }
 
 func writeErr(w http.ResponseWriter, prevMsgType uint8, err error) {
-	var msg protocol.ErrorMessage
-	if !errors.As(err, &msg) {
-		msg.Code = 500
-		msg.PrevMsgType = prevMsgType
-		msg.ErrString = err.Error()
-		msg.Timestamp = time.Now().Unix()
-	}
+	msg := protocol.NewErrorMessage(prevMsgType, err)
 
 	// TODO: Set correlation ID
 	msg.CorrelationID = nil
 
-	var body bytes.Buffer
-	_ = cbor.NewEncoder(&body).Encode(msg)
+	body, _ := cbor.Marshal(msg)
+	writeResponse(w, http.StatusInternalServerError, &protocol.Response{
+		MsgType: protocol.ErrorMsgType,
+		Body:    body,
+	})
+}
 
-	w.Header().Add("Content-Length", strconv.Itoa(body.Len()))
-	w.Header().Add("Content-Type", "application/cbor")
-	w.Header().Add("Message-Type", strconv.Itoa(int(protocol.ErrorMsgType)))
-	w.WriteHeader(http.StatusInternalServerError)
-	_, _ = w.Write(body.Bytes())
+// writeRetry writes an error message asking the device to back off and retry
+// after a delay.
+func writeRetry(w http.ResponseWriter, prevMsgType uint8, after time.Duration, err error) {
+	msg := protocol.NewErrorMessage(prevMsgType, err)
+	msg.CorrelationID = nil
+
+	body, _ := cbor.Marshal(msg)
+	setRetryAfter(w, after)
+	writeResponse(w, http.StatusServiceUnavailable, &protocol.Response{
+		MsgType: protocol.ErrorMsgType,
+		Body:    body,
+	})
+}
+
+// setRetryAfter asks the device to back off, rounding up to whole seconds.
+func setRetryAfter(w http.ResponseWriter, after time.Duration) {
+	w.Header().Add("Retry-After", strconv.FormatInt(int64((after+time.Second-1)/time.Second), 10))
 }
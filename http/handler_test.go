@@ -0,0 +1,355 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestHandlerProtocolVersion(t *testing.T) {
+	server := fdotest.NewServer(t)
+	mux := http.NewServeMux()
+	for _, version := range []uint16{protocol.Version100, protocol.Version101} {
+		mux.Handle(fmt.Sprintf("POST /fdo/%d/msg/{msg}", version), transport.Handler{
+			Tokens:          server.State,
+			DIResponder:     server.DI,
+			ProtocolVersion: version,
+		})
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, version := range []uint16{protocol.Version100, protocol.Version101} {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			key, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				Subject: pkix.Name{CommonName: "device.go-fdo"},
+			}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			csr, err := x509.ParseCertificateRequest(csrDER)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				t.Fatal(err)
+			}
+
+			// Vouchers and credentials created by DI have the version of the
+			// handler path
+			cred, err := fdo.DI(context.Background(), &transport.Transport{
+				BaseURL:         srv.URL,
+				ProtocolVersion: version,
+			}, custom.DeviceMfgInfo{
+				KeyType:      protocol.Secp256r1KeyType,
+				KeyEncoding:  protocol.X5ChainKeyEnc,
+				SerialNumber: "1234",
+				DeviceInfo:   "gotest",
+				CertInfo:     cbor.X509CertificateRequest(*csr),
+			}, fdo.DIConfig{
+				HmacSha256: hmac.New(sha256.New, secret),
+				HmacSha384: hmac.New(sha512.New384, secret),
+				Key:        key,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cred.Version != version {
+				t.Errorf("expected credential version %d, got %d", version, cred.Version)
+			}
+			ov := server.Voucher(t, cred.GUID)
+			if ov.Version != version || ov.Header.Val.Version != version {
+				t.Errorf("expected voucher version %d, got %d and header version %d", version, ov.Version, ov.Header.Val.Version)
+			}
+
+			// Both versions onboard, keeping their version
+			dev := &fdotest.Device{
+				Cred:       *cred,
+				KeyType:    protocol.Secp256r1KeyType,
+				HmacSha256: hmac.New(sha256.New, secret),
+				HmacSha384: hmac.New(sha512.New384, secret),
+				Key:        key,
+			}
+			server.RegisterBlob(t, cred.GUID)
+			if err := server.Onboard(t, dev, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Voucher(t, dev.Cred.GUID).Header.Val.Version; got != version {
+				t.Errorf("expected replacement voucher version %d, got %d", version, got)
+			}
+		})
+	}
+
+	// Unsupported versions are rejected
+	mux.Handle("POST /fdo/102/msg/{msg}", transport.Handler{Tokens: server.State, DIResponder: server.DI, ProtocolVersion: 102})
+	resp, err := http.Post(srv.URL+"/fdo/102/msg/10", "application/cbor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Message-Type") != "255" {
+		t.Errorf("expected error response for unsupported version, got %d", resp.StatusCode)
+	}
+}
+
+type responderFunc func(ctx context.Context, msgType uint8, msg io.Reader) (uint8, any)
+
+func (f responderFunc) Respond(ctx context.Context, msgType uint8, msg io.Reader) (uint8, any) {
+	return f(ctx, msgType, msg)
+}
+
+func TestHandlerTrustedProxies(t *testing.T) {
+	server := fdotest.NewServer(t)
+
+	var peer protocol.Peer
+	handler := transport.Handler{
+		Tokens: server.State,
+		TO1Responder: responderFunc(func(ctx context.Context, msgType uint8, msg io.Reader) (uint8, any) {
+			peer, _ = protocol.PeerFromContext(ctx)
+			return protocol.ErrorMsgType, protocol.ErrorMessage{Code: protocol.ResourceNotFound, PrevMsgType: msgType}
+		}),
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", handler)
+
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		proto      string
+		expectAddr string
+		expectTLS  bool
+	}{
+		{
+			name:       "untrusted client spoofing headers",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			proto:      "https",
+			expectAddr: "192.0.2.1",
+		},
+		{
+			name:       "trusted proxy terminating TLS",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			proto:      "https",
+			expectAddr: "198.51.100.1",
+			expectTLS:  true,
+		},
+		{
+			name:       "chain of proxies with spoofed client address",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"203.0.113.1, 198.51.100.1", "10.0.0.2"},
+			expectAddr: "198.51.100.1",
+		},
+		{
+			name:       "trusted proxy without headers",
+			remoteAddr: "10.0.0.1:1234",
+			expectAddr: "10.0.0.1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := cbor.Marshal(struct {
+				GUID    protocol.GUID
+				SigInfo []byte
+			}{SigInfo: []byte{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/fdo/101/msg/30", bytes.NewReader(body))
+			req.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if test.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", test.proto)
+			}
+
+			peer = protocol.Peer{}
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+			if peer.Addr.String() != test.expectAddr || peer.TLS != test.expectTLS {
+				t.Errorf("expected client %s (TLS %t), got %s (TLS %t)", test.expectAddr, test.expectTLS, peer.Addr, peer.TLS)
+			}
+		})
+	}
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	server := fdotest.NewServer(t)
+
+	var handled int
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", transport.Handler{
+		Tokens: server.State,
+		TO1Responder: responderFunc(func(ctx context.Context, msgType uint8, msg io.Reader) (uint8, any) {
+			handled++
+			return protocol.ErrorMsgType, protocol.ErrorMessage{Code: protocol.ResourceNotFound, PrevMsgType: msgType}
+		}),
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		RateLimit:      &transport.RateLimiter{Rate: 0.1, Burst: 2},
+	})
+
+	send := func(forwardedFor string) *http.Response {
+		body, err := cbor.Marshal(struct {
+			GUID    protocol.GUID
+			SigInfo []byte
+		}{SigInfo: []byte{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/fdo/101/msg/30", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	// Clients behind the same proxy are limited separately
+	for _, client := range []string{"198.51.100.1", "198.51.100.1", "198.51.100.2"} {
+		if resp := send(client); resp.StatusCode == http.StatusServiceUnavailable {
+			t.Fatalf("expected request from %s to be allowed", client)
+		}
+	}
+	resp := send("198.51.100.1")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected request over limit to be rejected, got %s", resp.Status)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "10" {
+		t.Errorf("expected retry after 10 seconds, got %q", retryAfter)
+	}
+	var errMsg protocol.ErrorMessage
+	if err := cbor.NewDecoder(resp.Body).Decode(&errMsg); err != nil {
+		t.Fatalf("expected error message body: %v", err)
+	}
+	if handled != 3 {
+		t.Errorf("expected 3 requests to be handled, got %d", handled)
+	}
+}
+
+func TestHandlerStreamingBody(t *testing.T) {
+	server := fdotest.NewServer(t)
+
+	var read int
+	var readErr error
+	handler := transport.Handler{
+		Tokens: server.State,
+		TO1Responder: responderFunc(func(ctx context.Context, msgType uint8, msg io.Reader) (uint8, any) {
+			var n int64
+			n, readErr = io.Copy(io.Discard, msg)
+			read = int(n)
+			return protocol.ErrorMsgType, protocol.ErrorMessage{Code: protocol.ResourceNotFound, PrevMsgType: msgType}
+		}),
+		MaxContentLength: 1000,
+	}
+
+	for _, test := range []struct {
+		name      string
+		size      int
+		expectErr bool
+	}{
+		{name: "within limit", size: 1000},
+		{name: "exceeding limit", size: 1001, expectErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Hide the length of the body, as with chunked transfer encoding
+			body := struct{ io.Reader }{bytes.NewReader(make([]byte, test.size))}
+			req := httptest.NewRequest(http.MethodPost, "/fdo/101/msg/30", body)
+			req.SetPathValue("msg", "30")
+			if req.ContentLength != -1 {
+				t.Fatalf("expected unknown content length, got %d", req.ContentLength)
+			}
+
+			read, readErr = 0, nil
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if test.expectErr {
+				if readErr == nil {
+					t.Errorf("expected error reading body exceeding limit, read %d bytes", read)
+				}
+				return
+			}
+			if readErr != nil || read != test.size {
+				t.Errorf("expected to read %d bytes, read %d: %v", test.size, read, readErr)
+			}
+		})
+	}
+}
+
+func TestHandlerRetryAfter(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	var stats fdo.TO1Stats
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", transport.Handler{
+		Tokens: server.State,
+		TO1Responder: &fdo.TO1Server{
+			Session: server.State,
+			RVBlobs: server.State,
+			Stats:   &stats,
+			Backoff: func(context.Context) time.Duration { return 1500 * time.Millisecond },
+		},
+	})
+	mux.HandleFunc("POST /lb/fdo/101/msg/{msg}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, test := range []struct {
+		name       string
+		path       string
+		retryAfter time.Duration
+		errString  string
+	}{
+		{name: "rendezvous server", retryAfter: 2 * time.Second, errString: protocol.ErrorDetail{ID: protocol.BusyErrID}.String()},
+		{name: "load balancer", path: "/lb", retryAfter: 5 * time.Second, errString: "503 Service Unavailable"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr := &transport.Transport{BaseURL: srv.URL + test.path}
+			_, err := fdo.TO1(context.Background(), tr, dev.Cred, dev.Key, nil)
+			var errMsg protocol.ErrorMessage
+			if !errors.As(err, &errMsg) {
+				t.Fatalf("expected error message, got %v", err)
+			}
+			if errMsg.RetryAfter != test.retryAfter {
+				t.Errorf("expected retry after %s, got %s", test.retryAfter, errMsg.RetryAfter)
+			}
+			if errMsg.ErrString != test.errString {
+				t.Errorf("expected error string %q, got %q", test.errString, errMsg.ErrString)
+			}
+		})
+	}
+
+	if counts := stats.Counts(); counts.Lookups != 1 || counts.Busy != 1 || counts.Failures != 0 {
+		t.Errorf("expected one busy lookup, got %+v", counts)
+	}
+}
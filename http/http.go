@@ -6,6 +6,9 @@ package http
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
 
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
@@ -38,3 +41,76 @@ func (j jar) GetToken(_ context.Context, prot protocol.Protocol) string {
 func (j jar) StoreToken(_ context.Context, prot protocol.Protocol, token string) {
 	j[prot] = token
 }
+
+type headerKey struct{}
+
+// ContextWithHeader returns a context which adds headers to FDO requests sent
+// with it, such as trace context headers. Headers are added to any set by a
+// parent context.
+func ContextWithHeader(parent context.Context, header http.Header) context.Context {
+	merged := headerFromContext(parent).Clone()
+	if merged == nil {
+		merged = make(http.Header)
+	}
+	addHeaders(merged, header)
+	return context.WithValue(parent, headerKey{}, merged)
+}
+
+func headerFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerKey{}).(http.Header)
+	return header
+}
+
+// addHeaders adds extra headers to a request, except for those which are set
+// by the transport.
+func addHeaders(dst, src http.Header) {
+	for name, values := range src {
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Content-Type", "Content-Length", "Host":
+			continue
+		}
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+}
+
+// defaultMaxContentLength is the maximum size of message bodies when
+// MaxContentLength is zero.
+const defaultMaxContentLength = 65535
+
+func maxContentLength(n int64) int64 {
+	if n == 0 {
+		return defaultMaxContentLength
+	}
+	return n
+}
+
+// limitedBody streams a message body, failing once more than limit bytes are
+// read, so that bodies of unknown length are bounded without buffering them.
+type limitedBody struct {
+	io.ReadCloser
+	remaining, limit int64
+}
+
+func newLimitedBody(body io.ReadCloser, limit int64) io.ReadCloser {
+	if limit <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, remaining: limit, limit: limit}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	// Read one byte more than remaining to detect exceeding the limit
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, fmt.Errorf("content too large (more than %d bytes)", b.limit)
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,637 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// maxVoucherSize limits the size of uploaded vouchers.
+const maxVoucherSize = 1 << 20
+
+// OwnerAdminHandler implements an HTTP API for owner service operators to
+// manage the lifecycle of ownership vouchers. Paths are relative to where the
+// handler is mounted, i.e. using http.StripPrefix:
+//
+//	POST   /vouchers                   Upload a voucher (PEM or CBOR)
+//	POST   /vouchers/validate          Validate a voucher without storing it
+//	GET    /devices?state=STATE        List devices by latest onboarding state
+//	GET    /devices/{guid}/history     Get the onboarding history of a device
+//	GET    /devices/{guid}/devmod      Get the devmod service info of a device
+//	GET    /devices/{guid}/status      Get the lifecycle status of a device
+//	GET    /devices/{guid}/trace       Get the protocol traces of a device
+//	GET    /devices/{guid}/hello       Get the TO2.HelloDevice messages of a device
+//	POST   /devices/{guid}/to0         Register the rendezvous blob of a device
+//	POST   /devices/{guid}/deny        Deny onboarding of a device
+//	DELETE /devices/{guid}/deny        Allow onboarding of a denied device
+//	GET    /traces/{correlation_id}    Get the protocol trace of a session
+//	GET    /traces/{correlation_id}/hello
+//	                                   Get the TO2.HelloDevice message of a session
+//
+// The correlation ID of a session is included in error messages sent to the
+// device, so a failed session can be found from device logs.
+//
+// The body of a TO0 request may be a JSON object with an "rv_urls" array of
+// rendezvous server URLs. Otherwise RVURLs or the voucher's rendezvous info is
+// used.
+//
+// The API is not authenticated unless Authorize is set, so it should
+// otherwise only be served to trusted networks.
+type OwnerAdminHandler struct {
+	Vouchers  fdo.OwnerVoucherPersistentState
+	OwnerKeys fdo.OwnerKeyPersistentState
+	History   fdo.OnboardingHistoryPersistentState
+	DenyList  fdo.DeviceDenyListPersistentState
+	Devmods   fdo.DevmodPersistentState
+	Statuses  fdo.DeviceStatusPersistentState
+	Traces    fdo.ProtocolTracePersistentState
+	Hellos    fdo.HelloDevicePersistentState
+
+	// DeviceCertPolicy, if set, validates the device certificate chain of
+	// uploaded vouchers. Vouchers which fail the policy are rejected with 422
+	// Unprocessable Entity, unless the policy is report-only.
+	DeviceCertPolicy *fdo.DeviceCertPolicy
+
+	// TO0, if set, is used to register rendezvous blobs. Its NewTransport and
+	// TO2Addrs must be set.
+	TO0 *fdo.TO0Client
+
+	// RVURLs are the default rendezvous servers to register with. If empty,
+	// the owner rendezvous info of the voucher is used.
+	RVURLs []string
+
+	// Authorize, if set, is called for each request. Requests are rejected
+	// with 403 Forbidden if it returns an error.
+	Authorize func(*http.Request) error
+}
+
+type onboardingEvent struct {
+	GUID            string    `json:"guid"`
+	Time            time.Time `json:"time"`
+	State           string    `json:"state"`
+	Error           string    `json:"error,omitempty"`
+	ReplacementGUID string    `json:"replacement_guid,omitempty"`
+	OwnerKeyHash    string    `json:"owner_key_hash,omitempty"`
+}
+
+func newOnboardingEvent(event fdo.OnboardingEvent) onboardingEvent {
+	e := onboardingEvent{
+		GUID:         hex.EncodeToString(event.GUID[:]),
+		Time:         event.Time,
+		State:        string(event.State),
+		Error:        event.Err,
+		OwnerKeyHash: hex.EncodeToString(event.OwnerKeyHash),
+	}
+	if event.ReplacementGUID != nil {
+		e.ReplacementGUID = hex.EncodeToString(event.ReplacementGUID[:])
+	}
+	return e
+}
+
+type devmodInfo struct {
+	OS       string    `json:"os"`
+	Arch     string    `json:"arch"`
+	Version  string    `json:"version"`
+	Device   string    `json:"device"`
+	Serial   string    `json:"serial,omitempty"`
+	PathSep  string    `json:"path_sep,omitempty"`
+	FileSep  string    `json:"file_sep"`
+	Newline  string    `json:"newline,omitempty"`
+	Temp     string    `json:"temp,omitempty"`
+	Dir      string    `json:"dir,omitempty"`
+	ProgEnv  string    `json:"prog_env,omitempty"`
+	Bin      string    `json:"bin"`
+	MudURL   string    `json:"mud_url,omitempty"`
+	Modules  []string  `json:"modules"`
+	Received time.Time `json:"received"`
+}
+
+type deviceStatus struct {
+	GUID    string               `json:"guid"`
+	State   string               `json:"state"`
+	Updated time.Time            `json:"updated"`
+	Times   map[string]time.Time `json:"times"`
+}
+
+type traceEvent struct {
+	CorrelationID uint          `json:"correlation_id"`
+	GUID          string        `json:"guid,omitempty"`
+	Time          time.Time     `json:"time"`
+	Duration      time.Duration `json:"duration_ns"`
+	MsgType       uint8         `json:"msg_type"`
+	RequestSize   int64         `json:"request_size"`
+	RespType      uint8         `json:"resp_type,omitempty"`
+	ResponseSize  int           `json:"response_size,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	Summary       string        `json:"summary,omitempty"`
+}
+
+func newTraceEvents(events []protocol.TraceEvent) []traceEvent {
+	out := make([]traceEvent, 0, len(events))
+	for _, event := range events {
+		e := traceEvent{
+			CorrelationID: event.CorrelationID,
+			Time:          event.Time,
+			Duration:      event.Duration,
+			MsgType:       event.MsgType,
+			RequestSize:   event.RequestSize,
+			RespType:      event.RespType,
+			ResponseSize:  event.ResponseSize,
+			Error:         event.Err,
+			Summary:       event.Summary,
+		}
+		if event.GUID != nil {
+			e.GUID = hex.EncodeToString(event.GUID[:])
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// helloDevice is a TO2.HelloDevice message as received. Raw is hex encoded
+// and Diagnostic is its CBOR diagnostic notation. The negotiated parameters
+// are omitted if the message could not be decoded.
+type helloDevice struct {
+	CorrelationID        uint      `json:"correlation_id"`
+	GUID                 string    `json:"guid"`
+	Time                 time.Time `json:"time"`
+	Raw                  string    `json:"raw"`
+	Diagnostic           string    `json:"diagnostic,omitempty"`
+	HashAlg              string    `json:"hash_alg"`
+	Hash                 string    `json:"hash"`
+	Error                string    `json:"error,omitempty"`
+	MaxDeviceMessageSize uint16    `json:"max_device_message_size,omitempty"`
+	KexSuiteName         string    `json:"kex_suite_name,omitempty"`
+	CipherSuite          string    `json:"cipher_suite,omitempty"`
+	SigInfoType          int64     `json:"sig_info_type,omitempty"`
+	SigInfo              string    `json:"sig_info,omitempty"`
+}
+
+func newHelloDevice(record fdo.HelloDeviceRecord) helloDevice {
+	h := helloDevice{
+		CorrelationID: record.CorrelationID,
+		GUID:          hex.EncodeToString(record.GUID[:]),
+		Time:          record.Time,
+		Raw:           hex.EncodeToString(record.Raw),
+		HashAlg:       record.Hash.Algorithm.String(),
+		Hash:          hex.EncodeToString(record.Hash.Value),
+		Error:         record.Err,
+	}
+	if diag, err := cdn.FromCBOR(record.Raw); err == nil {
+		h.Diagnostic = diag
+	}
+	if params, err := record.Params(); err == nil {
+		h.MaxDeviceMessageSize = params.MaxDeviceMessageSize
+		h.KexSuiteName = string(params.KexSuiteName)
+		h.CipherSuite = params.CipherSuite.String()
+		h.SigInfoType = int64(params.SigInfoType)
+		h.SigInfo = hex.EncodeToString(params.SigInfo)
+	}
+	return h
+}
+
+type to0Result struct {
+	RvURL       string     `json:"rv_url"`
+	WaitSeconds uint32     `json:"wait_seconds,omitempty"`
+	RefreshAt   *time.Time `json:"refresh_at,omitempty"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func (h OwnerAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorize) {
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/vouchers" && r.Method == http.MethodPost:
+		h.upload(w, r)
+	case path == "/vouchers/validate" && r.Method == http.MethodPost:
+		h.validate(w, r)
+	case path == "/devices" && r.Method == http.MethodGet:
+		h.list(w, r)
+	case strings.HasPrefix(path, "/traces/") && r.Method == http.MethodGet:
+		idParam, hello := strings.CutSuffix(strings.TrimPrefix(path, "/traces/"), "/hello")
+		id, err := strconv.ParseUint(idParam, 10, 32)
+		if err != nil {
+			writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("invalid correlation ID: %w", err))
+			return
+		}
+		if hello {
+			h.sessionHello(w, r, uint(id))
+			return
+		}
+		h.sessionTrace(w, r, uint(id))
+	case strings.HasPrefix(path, "/devices/"):
+		guidParam, action, _ := strings.Cut(strings.TrimPrefix(path, "/devices/"), "/")
+		guid, err := protocol.ParseGUID(guidParam)
+		if err != nil {
+			writeJSONErr(w, http.StatusBadRequest, err)
+			return
+		}
+		switch {
+		case action == "history" && r.Method == http.MethodGet:
+			h.history(w, r, guid)
+		case action == "devmod" && r.Method == http.MethodGet:
+			h.devmod(w, r, guid)
+		case action == "status" && r.Method == http.MethodGet:
+			h.status(w, r, guid)
+		case action == "trace" && r.Method == http.MethodGet:
+			h.deviceTrace(w, r, guid)
+		case action == "hello" && r.Method == http.MethodGet:
+			h.deviceHellos(w, r, guid)
+		case action == "to0" && r.Method == http.MethodPost:
+			h.register(w, r, guid)
+		case action == "deny" && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+			h.deny(w, r, guid)
+		default:
+			writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s %s", r.Method, r.URL.Path))
+		}
+	default:
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (h OwnerAdminHandler) upload(w http.ResponseWriter, r *http.Request) {
+	ov, ok := readVoucher(w, r)
+	if !ok {
+		return
+	}
+
+	// Check that the voucher is extended to this owner service
+	if err := ov.VerifyEntries(); err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("invalid voucher: %w", err))
+		return
+	}
+	if h.DeviceCertPolicy != nil {
+		if _, err := h.DeviceCertPolicy.CheckVoucher(r.Context(), ov); err != nil {
+			writeJSONErr(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+	expectedPubKey, err := ov.OwnerPublicKey()
+	if err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("error parsing owner public key from voucher: %w", err))
+		return
+	}
+	if _, _, err := fdo.OwnerKeyFor(r.Context(), h.OwnerKeys, ov.Header.Val.ManufacturerKey.Type, expectedPubKey); errors.Is(err, fdo.ErrNotFound) {
+		writeJSONErr(w, http.StatusUnprocessableEntity, errors.New("owner key does not match the owner of the voucher"))
+		return
+	} else if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, fmt.Errorf("error getting owner key: %w", err))
+		return
+	}
+
+	guid := ov.Header.Val.GUID
+	if err := h.Vouchers.AddVoucher(r.Context(), ov); err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, fmt.Errorf("error storing voucher: %w", err))
+		return
+	}
+	event := h.record(r, guid, fdo.OnboardingImported)
+	writeJSON(w, http.StatusCreated, event)
+}
+
+// validate runs every check of an uploaded voucher, including whether it is
+// owned by this service and whether its GUID is already stored, and responds
+// with the report. The status is 200 OK if the voucher is valid and 422
+// Unprocessable Entity otherwise.
+func (h OwnerAdminHandler) validate(w http.ResponseWriter, r *http.Request) {
+	ov, ok := readVoucher(w, r)
+	if !ok {
+		return
+	}
+	report := ov.Validate(r.Context(), fdo.VoucherValidateOptions{
+		DeviceCertPolicy: h.DeviceCertPolicy,
+		OwnerKeys:        h.OwnerKeys,
+		Vouchers:         h.Vouchers,
+	})
+	status := http.StatusOK
+	if !report.Valid() {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, report)
+}
+
+// readVoucher reads a PEM or CBOR encoded voucher from the request body. If it
+// cannot be read, a 400 Bad Request response is written and ok is false.
+func readVoucher(w http.ResponseWriter, r *http.Request) (_ *fdo.Voucher, ok bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxVoucherSize))
+	if err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("error reading voucher: %w", err))
+		return nil, false
+	}
+
+	// Accept a PEM block or raw CBOR
+	if blk, _ := pem.Decode(body); blk != nil {
+		if blk.Type != "OWNERSHIP VOUCHER" {
+			writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("expected PEM block of ownership voucher type, found %s", blk.Type))
+			return nil, false
+		}
+		body = blk.Bytes
+	}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(body, &ov); err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("error parsing voucher: %w", err))
+		return nil, false
+	}
+	return &ov, true
+}
+
+func (h OwnerAdminHandler) list(w http.ResponseWriter, r *http.Request) {
+	if h.History == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("onboarding history is not enabled"))
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		writeJSONErr(w, http.StatusBadRequest, errors.New("state query parameter is required"))
+		return
+	}
+
+	events, err := h.History.LatestOnboardingEvents(r.Context(), fdo.OnboardingState(state))
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	devices := []onboardingEvent{}
+	for _, event := range events {
+		devices = append(devices, newOnboardingEvent(event))
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (h OwnerAdminHandler) history(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.History == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("onboarding history is not enabled"))
+		return
+	}
+	events, err := h.History.OnboardingHistory(r.Context(), guid)
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(events) == 0 {
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no onboarding history for %x", guid))
+		return
+	}
+	history := []onboardingEvent{}
+	for _, event := range events {
+		history = append(history, newOnboardingEvent(event))
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (h OwnerAdminHandler) devmod(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.Devmods == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("devmod storage is not enabled"))
+		return
+	}
+	devmod, err := h.Devmods.Devmod(r.Context(), guid)
+	if errors.Is(err, fdo.ErrNotFound) {
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no devmod for %x", guid))
+		return
+	} else if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	modules := devmod.Modules
+	if modules == nil {
+		modules = []string{}
+	}
+	writeJSON(w, http.StatusOK, devmodInfo{
+		OS:       devmod.Devmod.Os,
+		Arch:     devmod.Devmod.Arch,
+		Version:  devmod.Devmod.Version,
+		Device:   devmod.Devmod.Device,
+		Serial:   hex.EncodeToString(devmod.Devmod.Serial),
+		PathSep:  devmod.Devmod.PathSep,
+		FileSep:  devmod.Devmod.FileSep,
+		Newline:  devmod.Devmod.Newline,
+		Temp:     devmod.Devmod.Temp,
+		Dir:      devmod.Devmod.Dir,
+		ProgEnv:  devmod.Devmod.ProgEnv,
+		Bin:      devmod.Devmod.Bin,
+		MudURL:   devmod.Devmod.MudURL,
+		Modules:  modules,
+		Received: devmod.Time,
+	})
+}
+
+func (h OwnerAdminHandler) status(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.Statuses == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("device status tracking is not enabled"))
+		return
+	}
+	status, err := h.Statuses.DeviceStatus(r.Context(), guid)
+	if errors.Is(err, fdo.ErrNotFound) {
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no status for %x", guid))
+		return
+	} else if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	times := make(map[string]time.Time, len(status.Times))
+	for state, t := range status.Times {
+		times[string(state)] = t
+	}
+	writeJSON(w, http.StatusOK, deviceStatus{
+		GUID:    hex.EncodeToString(status.GUID[:]),
+		State:   string(status.State),
+		Updated: status.Updated,
+		Times:   times,
+	})
+}
+
+func (h OwnerAdminHandler) deviceTrace(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.Traces == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("protocol tracing is not enabled"))
+		return
+	}
+	events, err := h.Traces.DeviceTrace(r.Context(), guid)
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTraceEvents(events))
+}
+
+func (h OwnerAdminHandler) sessionTrace(w http.ResponseWriter, r *http.Request, correlationID uint) {
+	if h.Traces == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("protocol tracing is not enabled"))
+		return
+	}
+	events, err := h.Traces.SessionTrace(r.Context(), correlationID)
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTraceEvents(events))
+}
+
+func (h OwnerAdminHandler) deviceHellos(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.Hellos == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("HelloDevice recording is not enabled"))
+		return
+	}
+	records, err := h.Hellos.HelloDevices(r.Context(), guid)
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := make([]helloDevice, 0, len(records))
+	for _, record := range records {
+		out = append(out, newHelloDevice(record))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h OwnerAdminHandler) sessionHello(w http.ResponseWriter, r *http.Request, correlationID uint) {
+	if h.Hellos == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("HelloDevice recording is not enabled"))
+		return
+	}
+	record, err := h.Hellos.SessionHelloDevice(r.Context(), correlationID)
+	if errors.Is(err, fdo.ErrNotFound) {
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no HelloDevice for session %d", correlationID))
+		return
+	} else if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newHelloDevice(*record))
+}
+
+func (h OwnerAdminHandler) register(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.TO0 == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("TO0 is not enabled"))
+		return
+	}
+	if h.DenyList != nil {
+		if denied, err := h.DenyList.DeviceDenied(r.Context(), guid); err != nil {
+			writeJSONErr(w, http.StatusInternalServerError, err)
+			return
+		} else if denied {
+			writeJSONErr(w, http.StatusConflict, fmt.Errorf("device %x is denied", guid))
+			return
+		}
+	}
+
+	var req struct {
+		RvURLs []string `json:"rv_urls"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("error parsing request: %w", err))
+			return
+		}
+	}
+	rvURLs := req.RvURLs
+	if len(rvURLs) == 0 {
+		rvURLs = h.RVURLs
+	}
+	if len(rvURLs) == 0 {
+		ov, err := h.Vouchers.Voucher(r.Context(), guid)
+		if errors.Is(err, fdo.ErrNotFound) {
+			writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no voucher for %x", guid))
+			return
+		} else if err != nil {
+			writeJSONErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, directive := range protocol.ParseOwnerRvInfo(ov.Header.Val.RvInfo) {
+			if directive.Bypass {
+				continue
+			}
+			for _, u := range directive.URLs {
+				rvURLs = append(rvURLs, u.String())
+			}
+		}
+	}
+	if len(rvURLs) == 0 {
+		writeJSONErr(w, http.StatusUnprocessableEntity, fmt.Errorf("no rendezvous servers to register %x with", guid))
+		return
+	}
+
+	report, err := h.TO0.RegisterAll(r.Context(), []protocol.GUID{guid}, rvURLs, len(rvURLs))
+	if report == nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	results := []to0Result{}
+	for _, result := range report.Results {
+		res := to0Result{
+			RvURL:       result.RvURL,
+			WaitSeconds: result.WaitSeconds,
+			Attempts:    result.Attempts,
+		}
+		if result.Err != nil {
+			res.Error = result.Err.Error()
+		} else {
+			res.RefreshAt = &result.RefreshAt
+		}
+		results = append(results, res)
+	}
+	if report.Succeeded > 0 {
+		h.record(r, guid, fdo.OnboardingRegistered)
+	}
+
+	status := http.StatusOK
+	if report.Failed > 0 {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, results)
+}
+
+func (h OwnerAdminHandler) deny(w http.ResponseWriter, r *http.Request, guid protocol.GUID) {
+	if h.DenyList == nil {
+		writeJSONErr(w, http.StatusNotFound, errors.New("deny list is not enabled"))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := h.DenyList.AllowDevice(r.Context(), guid); errors.Is(err, fdo.ErrNotFound) {
+			writeJSONErr(w, http.StatusNotFound, fmt.Errorf("device %x is not denied", guid))
+		} else if err != nil {
+			writeJSONErr(w, http.StatusInternalServerError, err)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if err := h.DenyList.DenyDevice(r.Context(), guid); err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.record(r, guid, fdo.OnboardingDenied)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// record adds an event to the history, if enabled. Failure to record does not
+// fail the request.
+func (h OwnerAdminHandler) record(r *http.Request, guid protocol.GUID, state fdo.OnboardingState) onboardingEvent {
+	event := fdo.OnboardingEvent{GUID: guid, Time: time.Now(), State: state}
+	if h.History != nil {
+		if err := h.History.AddOnboardingEvent(r.Context(), event); err != nil {
+			slog.Warn("error recording onboarding event", "guid", guid, "state", state, "error", err)
+		}
+	}
+	return newOnboardingEvent(event)
+}
@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestOwnerAdminHandler(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.History = server.State
+	server.TO2.DenyList = server.State
+	server.DI.DeviceStatus = server.State
+	server.TO0.DeviceStatus = server.State
+	server.TO1.DeviceStatus = server.State
+	server.TO2.DeviceStatus = server.State
+	server.TO2.HelloDevices = server.State
+	dnsAddr := "owner.fidoalliance.org"
+	server.TO0Client.NewTransport = func(string) fdo.Transport { return server.Transport() }
+	server.TO0Client.TO2Addrs = []protocol.RvTO2Addr{
+		{DNSAddress: &dnsAddr, Port: 8080, TransportProtocol: protocol.HTTPTransport},
+	}
+
+	handler := transport.OwnerAdminHandler{
+		Vouchers:  server.State,
+		OwnerKeys: server.State,
+		History:   server.State,
+		DenyList:  server.State,
+		Devmods:   server.State,
+		Statuses:  server.State,
+		Traces:    server.State,
+		Hellos:    server.State,
+		TO0:       server.TO0Client,
+		RVURLs:    []string{"http://rv.fidoalliance.org"},
+	}
+	do := func(method, path string, body []byte, into any) int {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		if into != nil {
+			if err := json.NewDecoder(rr.Body).Decode(into); err != nil {
+				t.Fatalf("%s %s: error decoding response: %v", method, path, err)
+			}
+		}
+		return rr.Code
+	}
+	type event struct {
+		GUID  string `json:"guid"`
+		State string `json:"state"`
+		Error string `json:"error"`
+	}
+
+	// Upload the voucher of a device as PEM
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	guid := dev.Cred.GUID
+	ovBytes, err := cbor.Marshal(server.Voucher(t, guid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemVoucher := pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: ovBytes})
+	var imported event
+	if code := do("POST", "/vouchers", pemVoucher, &imported); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if imported.GUID != hex.EncodeToString(guid[:]) || imported.State != "imported" {
+		t.Fatalf("unexpected import event: %+v", imported)
+	}
+	if code := do("POST", "/vouchers", []byte("not a voucher"), nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid voucher, got %d", code)
+	}
+
+	// Validating an imported voucher reports its GUID collision
+	var report struct {
+		GUID          string                  `json:"guid"`
+		Owner         struct{ Status string } `json:"owner"`
+		GUIDCollision struct{ Status string } `json:"guid_collision"`
+	}
+	if code := do("POST", "/vouchers/validate", pemVoucher, &report); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for validating an imported voucher, got %d", code)
+	}
+	if report.GUID != imported.GUID || report.Owner.Status != "passed" || report.GUIDCollision.Status != "failed" {
+		t.Fatalf("unexpected validation report: %+v", report)
+	}
+
+	// Vouchers failing the device certificate policy are rejected
+	handler.DeviceCertPolicy = &fdo.DeviceCertPolicy{MaxChainLength: 1}
+	if code := do("POST", "/vouchers", pemVoucher, nil); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for voucher failing device certificate policy, got %d", code)
+	}
+	handler.DeviceCertPolicy = nil
+
+	// Register and onboard
+	path := "/devices/" + hex.EncodeToString(guid[:])
+	var results []struct {
+		RvURL string `json:"rv_url"`
+		Error string `json:"error"`
+	}
+	if code := do("POST", path+"/to0", nil, &results); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", code, results)
+	}
+	if len(results) != 1 || results[0].RvURL != "http://rv.fidoalliance.org" || results[0].Error != "" {
+		t.Fatalf("unexpected TO0 results: %+v", results)
+	}
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var history []event
+	if code := do("GET", path+"/history", nil, &history); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	var states []string
+	for _, e := range history {
+		states = append(states, e.State)
+	}
+	if got := strings.Join(states, ","); got != "imported,registered,started,completed" {
+		t.Fatalf("unexpected onboarding history: %s", got)
+	}
+
+	// The device passed through each lifecycle state
+	var status struct {
+		State string               `json:"state"`
+		Times map[string]time.Time `json:"times"`
+	}
+	if code := do("GET", path+"/status", nil, &status); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if status.State != "onboarded" || len(status.Times) != 5 {
+		t.Fatalf("unexpected device status: %+v", status)
+	}
+	if code := do("GET", "/devices/"+strings.Repeat("00", 16)+"/status", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown device, got %d", code)
+	}
+
+	// Protocol traces are found by device or by session
+	if err := server.State.Trace(context.Background(), protocol.TraceEvent{
+		CorrelationID: 42,
+		GUID:          &guid,
+		Time:          time.Now(),
+		MsgType:       protocol.TO2HelloDeviceMsgType,
+		RespType:      protocol.ErrorMsgType,
+		Err:           "[code=101] invalid message",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var traces []struct {
+		CorrelationID uint   `json:"correlation_id"`
+		GUID          string `json:"guid"`
+		MsgType       uint8  `json:"msg_type"`
+		Error         string `json:"error"`
+	}
+	if code := do("GET", path+"/trace", nil, &traces); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(traces) != 1 || traces[0].CorrelationID != 42 || traces[0].GUID != hex.EncodeToString(guid[:]) {
+		t.Fatalf("unexpected device trace: %+v", traces)
+	}
+	if code := do("GET", "/traces/42", nil, &traces); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(traces) != 1 || traces[0].MsgType != protocol.TO2HelloDeviceMsgType || traces[0].Error != "[code=101] invalid message" {
+		t.Fatalf("unexpected session trace: %+v", traces)
+	}
+	if code := do("GET", "/traces/abc", nil, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid correlation ID, got %d", code)
+	}
+
+	// HelloDevice messages are found by device or by session
+	var hellos []struct {
+		GUID         string `json:"guid"`
+		Raw          string `json:"raw"`
+		HashAlg      string `json:"hash_alg"`
+		Hash         string `json:"hash"`
+		KexSuiteName string `json:"kex_suite_name"`
+		CipherSuite  string `json:"cipher_suite"`
+	}
+	if code := do("GET", path+"/hello", nil, &hellos); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(hellos) != 1 || hellos[0].GUID != hex.EncodeToString(guid[:]) || hellos[0].Raw == "" || hellos[0].Hash == "" ||
+		hellos[0].KexSuiteName == "" || hellos[0].CipherSuite == "" {
+		t.Fatalf("unexpected device HelloDevice messages: %+v", hellos)
+	}
+	records, err := server.State.HelloDevices(context.Background(), guid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := records[0]
+	record.CorrelationID = 42
+	if err := server.State.AddHelloDevice(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	if code := do("GET", "/traces/42/hello", nil, &hellos[0]); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if hellos[0].HashAlg != record.Hash.Algorithm.String() || hellos[0].Raw != hex.EncodeToString(record.Raw) {
+		t.Fatalf("unexpected session HelloDevice message: %+v", hellos[0])
+	}
+	if code := do("GET", "/traces/43/hello", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown session, got %d", code)
+	}
+
+	// The devmod of the device is available under its new GUID
+	var devmod struct {
+		OS      string   `json:"os"`
+		Arch    string   `json:"arch"`
+		Modules []string `json:"modules"`
+	}
+	newPath := "/devices/" + hex.EncodeToString(dev.Cred.GUID[:])
+	if code := do("GET", newPath+"/devmod", nil, &devmod); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if devmod.OS != runtime.GOOS || devmod.Arch != runtime.GOARCH || !slices.Contains(devmod.Modules, "devmod") {
+		t.Fatalf("unexpected devmod: %+v", devmod)
+	}
+	if code := do("GET", "/devices/"+strings.Repeat("00", 16)+"/devmod", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown device, got %d", code)
+	}
+
+	// Deny a device
+	denied := server.NewDevice(t, protocol.Secp256r1KeyType)
+	deniedPath := "/devices/" + hex.EncodeToString(denied.Cred.GUID[:])
+	server.RegisterBlob(t, denied.Cred.GUID)
+	if code := do("POST", deniedPath+"/deny", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code := do("POST", deniedPath+"/to0", nil, nil); code != http.StatusConflict {
+		t.Fatalf("expected 409 registering denied device, got %d", code)
+	}
+	if err := server.Onboard(t, denied, nil); err == nil {
+		t.Fatal("expected TO2 of denied device to fail")
+	}
+	var devices []event
+	if code := do("GET", "/devices?state=denied", nil, &devices); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(devices) != 1 || devices[0].GUID != hex.EncodeToString(denied.Cred.GUID[:]) {
+		t.Fatalf("expected only denied device, got %+v", devices)
+	}
+	if do("GET", "/devices?state=completed", nil, &devices); len(devices) != 1 || devices[0].GUID != hex.EncodeToString(guid[:]) {
+		t.Fatalf("expected only onboarded device, got %+v", devices)
+	}
+
+	// Allow the device again
+	if code := do("DELETE", deniedPath+"/deny", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code := do("DELETE", deniedPath+"/deny", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 allowing twice, got %d", code)
+	}
+	if err := server.Onboard(t, denied, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Authorization
+	handler.Authorize = func(*http.Request) error { return errors.New("no") }
+	if code := do("GET", "/devices?state=denied", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", code)
+	}
+}
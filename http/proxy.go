@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// ProxyConfig configures HTTP proxies manually, in place of the HTTP_PROXY,
+// HTTPS_PROXY, and NO_PROXY environment variables. Proxy auto-config (PAC)
+// files are not supported.
+type ProxyConfig struct {
+	// HTTPProxy is the proxy URL for http requests. If it has no scheme,
+	// http is assumed.
+	HTTPProxy string
+
+	// HTTPSProxy is the proxy URL for https requests. If it has no scheme,
+	// http is assumed.
+	HTTPSProxy string
+
+	// NoProxy lists hosts which are connected to directly. Entries are host
+	// names, which also match their subdomains, IP addresses, or CIDR
+	// prefixes. A single "*" disables proxying. Loopback addresses and
+	// localhost are always connected to directly.
+	NoProxy []string
+}
+
+// ProxyFunc returns a function to use as the Proxy of a Transport or
+// net/http.Transport.
+func (c ProxyConfig) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	httpProxy, err := parseProxy(c.HTTPProxy)
+	if err != nil {
+		return nil, err
+	}
+	httpsProxy, err := parseProxy(c.HTTPSProxy)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range c.NoProxy {
+		if strings.Contains(entry, "/") {
+			if _, err := netip.ParsePrefix(strings.TrimSpace(entry)); err != nil {
+				return nil, fmt.Errorf("invalid no proxy entry %q: %w", entry, err)
+			}
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		proxy := httpProxy
+		if req.URL.Scheme == "https" {
+			proxy = httpsProxy
+		}
+		if proxy == nil || c.bypass(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy, nil
+	}, nil
+}
+
+func parseProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", u.Redacted())
+	}
+	return u, nil
+}
+
+// bypass reports whether a host should be connected to directly.
+func (c ProxyConfig) bypass(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return true
+	}
+	addr, addrErr := netip.ParseAddr(host)
+	if addrErr == nil && addr.IsLoopback() {
+		return true
+	}
+
+	for _, entry := range c.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if addrErr == nil && prefix.Contains(addr.Unmap()) {
+				return true
+			}
+			continue
+		}
+		if entryHost, _, err := net.SplitHostPort(entry); err == nil {
+			entry = entryHost
+		}
+		if entryAddr, err := netip.ParseAddr(strings.Trim(entry, "[]")); err == nil {
+			if addrErr == nil && entryAddr == addr {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyFor returns the proxy which a client uses for a request, if known.
+func proxyFor(client *http.Client, req *http.Request) *url.URL {
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return nil
+	}
+	proxy, _ := t.Proxy(req)
+	return proxy
+}
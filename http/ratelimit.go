@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of requests from each client, identified by its
+// address as resolved through Handler.TrustedProxies, so that clients behind a
+// shared reverse proxy are limited separately. Each client has a token bucket
+// which holds up to Burst requests and refills at Rate requests per second.
+// Clients of unknown address share a bucket.
+//
+// A RateLimiter is safe for concurrent use and must not be copied after first
+// use.
+type RateLimiter struct {
+	// Rate is the number of requests per second allowed from each client
+	// after its burst is spent. It must be positive.
+	Rate float64
+
+	// Burst is the number of requests a client may make at once. If less
+	// than one, one is used.
+	Burst int
+
+	mu      sync.Mutex
+	clients map[netip.Addr]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket of a client and reports whether the
+// request is allowed. If not, it returns how long until a token is available.
+func (l *RateLimiter) allow(addr netip.Addr, now time.Time) (time.Duration, bool) {
+	burst := float64(max(l.Burst, 1))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.clients == nil {
+		l.clients = make(map[netip.Addr]*tokenBucket)
+	}
+	l.sweep(now, burst)
+
+	bucket, ok := l.clients[addr]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.clients[addr] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.Rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.Rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// sweep removes the buckets of clients which have been idle long enough to
+// refill, at most once per refill period, so that memory is bounded by the
+// number of recently active clients.
+func (l *RateLimiter) sweep(now time.Time, burst float64) {
+	refill := time.Duration(burst / l.Rate * float64(time.Second))
+	if now.Sub(l.swept) < refill {
+		return
+	}
+	l.swept = now
+	for addr, bucket := range l.clients {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.clients, addr)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// RVAdminHandler implements an HTTP API for rendezvous server operators to
+// inspect and manage registered rendezvous blobs. Paths are relative to where
+// the handler is mounted, i.e. using http.StripPrefix:
+//
+//	GET    /registrations                      List unexpired registrations
+//	GET    /registrations?owner_key_hash=HEX   ...of a given owner key
+//	GET    /registrations/{guid}               Get one registration
+//	DELETE /registrations/{guid}               Delete a registration
+//	GET    /stats/to1                          Get TO1 lookup statistics
+//
+// Owner key hashes are SHA-256 or SHA-384 digests of the CBOR-encoded owner
+// public key, as in a device credential's public key hash.
+//
+// The API is not authenticated unless Authorize is set, so it should
+// otherwise only be served to trusted networks.
+type RVAdminHandler struct {
+	RVBlobs fdo.RendezvousBlobAdminState
+
+	// Stats, if set, should be the same as the TO1Server's.
+	Stats *fdo.TO1Stats
+
+	// Cache, if set, should be the same as the TO1Server's, so that deleted
+	// registrations are not served from it.
+	Cache *fdo.RVBlobCache
+
+	// Authorize, if set, is called for each request. Requests are rejected
+	// with 403 Forbidden if it returns an error.
+	Authorize func(*http.Request) error
+}
+
+type rvRegistration struct {
+	GUID         string    `json:"guid"`
+	Expires      time.Time `json:"expires"`
+	OwnerKeyType string    `json:"owner_key_type"`
+	OwnerKeyHash string    `json:"owner_key_hash"`
+}
+
+type to1Stats struct {
+	Lookups   uint64 `json:"lookups"`
+	NotFound  uint64 `json:"not_found"`
+	Redirects uint64 `json:"redirects"`
+	Busy      uint64 `json:"busy"`
+	Failures  uint64 `json:"failures"`
+}
+
+func (h RVAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorize) {
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/registrations" && r.Method == http.MethodGet:
+		h.list(w, r)
+	case strings.HasPrefix(path, "/registrations/") && (r.Method == http.MethodGet || r.Method == http.MethodDelete):
+		h.registration(w, r, strings.TrimPrefix(path, "/registrations/"))
+	case path == "/stats/to1" && r.Method == http.MethodGet:
+		if h.Stats == nil {
+			writeJSONErr(w, http.StatusNotFound, errors.New("TO1 statistics are not enabled"))
+			return
+		}
+		counts := h.Stats.Counts()
+		writeJSON(w, http.StatusOK, to1Stats{
+			Lookups:   counts.Lookups,
+			NotFound:  counts.NotFound,
+			Redirects: counts.Redirects,
+			Busy:      counts.Busy,
+			Failures:  counts.Failures,
+		})
+	default:
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (h RVAdminHandler) list(w http.ResponseWriter, r *http.Request) {
+	var ownerKeyHash []byte
+	if s := r.URL.Query().Get("owner_key_hash"); s != "" {
+		var err error
+		ownerKeyHash, err = hex.DecodeString(s)
+		if err != nil || (len(ownerKeyHash) != sha256.Size && len(ownerKeyHash) != sha512.Size384) {
+			writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("owner_key_hash must be a hex-encoded SHA-256 or SHA-384 digest"))
+			return
+		}
+	}
+
+	infos, err := h.RVBlobs.ListRVBlobs(r.Context())
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	regs := []rvRegistration{}
+	for _, info := range infos {
+		reg, err := newRVRegistration(info, ownerKeyHash)
+		if err != nil {
+			writeJSONErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		if reg != nil {
+			regs = append(regs, *reg)
+		}
+	}
+	writeJSON(w, http.StatusOK, regs)
+}
+
+func (h RVAdminHandler) registration(w http.ResponseWriter, r *http.Request, guidParam string) {
+	guid, err := protocol.ParseGUID(guidParam)
+	if err != nil {
+		writeJSONErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err := h.RVBlobs.RemoveRVBlob(r.Context(), guid)
+		h.Cache.Invalidate(guid)
+		if errors.Is(err, fdo.ErrNotFound) {
+			writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no registration for %x", guid))
+		} else if err != nil {
+			writeJSONErr(w, http.StatusInternalServerError, err)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	info, err := h.RVBlobs.RVBlobRegistration(r.Context(), guid)
+	if errors.Is(err, fdo.ErrNotFound) {
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no registration for %x", guid))
+		return
+	} else if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	reg, err := newRVRegistration(*info, nil)
+	if err != nil {
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reg)
+}
+
+// newRVRegistration describes a registration, returning nil if a filter hash
+// is given and does not match the owner key.
+func newRVRegistration(info fdo.RVBlobInfo, ownerKeyHash []byte) (*rvRegistration, error) {
+	ov := info.Voucher
+	ownerKey := ov.Header.Val.ManufacturerKey
+	if len(ov.Entries) > 0 {
+		ownerKey = ov.Entries[len(ov.Entries)-1].Payload.Val.PublicKey
+	}
+	keyHash := func(h hash.Hash) ([]byte, error) {
+		if err := cbor.NewEncoder(h).Encode(&ownerKey); err != nil {
+			return nil, fmt.Errorf("error hashing owner key of %x: %w", info.GUID, err)
+		}
+		return h.Sum(nil), nil
+	}
+
+	sha256Hash, err := keyHash(sha256.New())
+	if err != nil {
+		return nil, err
+	}
+	switch len(ownerKeyHash) {
+	case sha256.Size:
+		if !bytes.Equal(sha256Hash, ownerKeyHash) {
+			return nil, nil
+		}
+	case sha512.Size384:
+		sha384Hash, err := keyHash(sha512.New384())
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(sha384Hash, ownerKeyHash) {
+			return nil, nil
+		}
+	}
+
+	return &rvRegistration{
+		GUID:         hex.EncodeToString(info.GUID[:]),
+		Expires:      info.Expires,
+		OwnerKeyType: ownerKey.Type.String(),
+		OwnerKeyHash: hex.EncodeToString(sha256Hash),
+	}, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http_test
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestRVAdminHandler(t *testing.T) {
+	server := fdotest.NewServer(t)
+	stats := new(fdo.TO1Stats)
+	server.TO1.Stats = stats
+
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	guid := dev.Cred.GUID
+	server.RegisterBlob(t, guid)
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+	unregistered := server.NewDevice(t, protocol.Secp256r1KeyType)
+	if err := server.Onboard(t, unregistered, nil); err == nil {
+		t.Fatal("expected TO1 of unregistered device to fail")
+	}
+
+	handler := transport.RVAdminHandler{RVBlobs: server.State, Stats: stats}
+	do := func(method, path string, into any) int {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		if into != nil {
+			if err := json.NewDecoder(rr.Body).Decode(into); err != nil {
+				t.Fatalf("%s %s: error decoding response: %v", method, path, err)
+			}
+		}
+		return rr.Code
+	}
+	type registration struct {
+		GUID         string `json:"guid"`
+		OwnerKeyHash string `json:"owner_key_hash"`
+	}
+
+	// List and filter by owner key
+	var regs []registration
+	if code := do("GET", "/registrations", &regs); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(regs) != 1 || regs[0].GUID != hex.EncodeToString(guid[:]) {
+		t.Fatalf("expected registration of %x, got %+v", guid, regs)
+	}
+	if do("GET", "/registrations?owner_key_hash="+regs[0].OwnerKeyHash, &regs); len(regs) != 1 {
+		t.Fatalf("expected registration matching SHA-256 owner key hash, got %+v", regs)
+	}
+	_, ov, err := server.State.RVBlob(context.Background(), guid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha384 := sha512.New384()
+	if err := cbor.NewEncoder(sha384).Encode(&ov.Entries[len(ov.Entries)-1].Payload.Val.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if do("GET", "/registrations?owner_key_hash="+hex.EncodeToString(sha384.Sum(nil)), &regs); len(regs) != 1 {
+		t.Fatalf("expected registration matching SHA-384 owner key hash, got %+v", regs)
+	}
+	if do("GET", "/registrations?owner_key_hash="+hex.EncodeToString(make([]byte, 32)), &regs); len(regs) != 0 {
+		t.Fatalf("expected no registrations for other owner, got %+v", regs)
+	}
+	if code := do("GET", "/registrations?owner_key_hash=abc", nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad hash, got %d", code)
+	}
+
+	// TO1 statistics
+	var counts struct {
+		Lookups   uint64 `json:"lookups"`
+		NotFound  uint64 `json:"not_found"`
+		Redirects uint64 `json:"redirects"`
+		Failures  uint64 `json:"failures"`
+	}
+	if code := do("GET", "/stats/to1", &counts); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if counts.Lookups != 2 || counts.NotFound != 1 || counts.Redirects != 1 || counts.Failures != 0 {
+		t.Fatalf("unexpected TO1 statistics: %+v", counts)
+	}
+
+	// Get and delete a single registration
+	path := "/registrations/" + hex.EncodeToString(guid[:])
+	var reg registration
+	if code := do("GET", path, &reg); code != http.StatusOK || reg.GUID != hex.EncodeToString(guid[:]) {
+		t.Fatalf("expected registration of %x, got %d: %+v", guid, code, reg)
+	}
+	if code := do("DELETE", path, nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code := do("DELETE", path, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 deleting twice, got %d", code)
+	}
+	if code := do("GET", path, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", code)
+	}
+	if code := do("GET", "/registrations/xyz", nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad GUID, got %d", code)
+	}
+
+	// Authorization
+	handler.Authorize = func(*http.Request) error { return errors.New("no") }
+	if code := do("GET", "/registrations", nil); code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", code)
+	}
+}
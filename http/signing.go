@@ -0,0 +1,380 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// maxSigningRequestSize limits the size of signing service request bodies.
+const maxSigningRequestSize = 4096
+
+// DefaultSigningMaxSkew is the age of signing requests accepted by a
+// SigningHandler which is not configured with a MaxSkew.
+const DefaultSigningMaxSkew = 5 * time.Minute
+
+// signingAuthScheme is the scheme of the Authorization header of signing
+// service requests. Its credentials are the base64-encoded HMAC-SHA256 of the
+// request body.
+const signingAuthScheme = "FDO-HMAC-SHA256"
+
+// signingNonceSize is the size of the random nonce of each signing service
+// request.
+const signingNonceSize = 16
+
+// SigningRole identifies whether a signing request is for a manufacturer or an
+// owner key.
+type SigningRole string
+
+// Signing roles
+const (
+	ManufacturerSigningRole SigningRole = "manufacturer"
+	OwnerSigningRole        SigningRole = "owner"
+)
+
+// signingKeyRequest is the body of a request for the public key and
+// certificate chain of a key held by the signing service.
+type signingKeyRequest struct {
+	Role      SigningRole
+	KeyType   protocol.KeyType
+	Timestamp int64
+	Nonce     []byte
+}
+
+type signingKeyResponse struct {
+	PublicKey []byte // PKIX DER
+	Chain     []*cbor.X509Certificate
+}
+
+// signRequest is the body of a request to sign a voucher entry. The service
+// computes the digest of the entry itself, so that it only signs voucher
+// entries and may audit what it signs.
+type signRequest struct {
+	Role       SigningRole
+	KeyType    protocol.KeyType
+	Timestamp  int64
+	Nonce      []byte
+	GUID       protocol.GUID
+	DeviceInfo string
+	Payload    fdo.VoucherEntryPayload
+}
+
+// SigningRecord describes a request handled by a SigningHandler, for audit.
+type SigningRecord struct {
+	Time       time.Time
+	RemoteAddr string
+	Role       SigningRole
+	KeyType    protocol.KeyType
+
+	// GUID and DeviceInfo identify the device of a signed voucher entry, as
+	// verified by the header hash of the entry, and PreviousHash and
+	// NextOwner are the fields of the entry. NextOwner is nil for requests of
+	// a public key.
+	GUID         protocol.GUID
+	DeviceInfo   string
+	PreviousHash protocol.Hash
+	NextOwner    *protocol.PublicKey
+
+	// Err describes why the request failed, if it did.
+	Err string
+}
+
+// SigningHandler implements a signing service, so that manufacturer and owner
+// keys may be held in a separate security domain from DI and owner services.
+// Those services use a SigningClient as their AutoExtend. Paths are relative to
+// where the handler is mounted, i.e. using http.StripPrefix:
+//
+//	POST /key     Get the public key and certificate chain of a key
+//	POST /sign    Sign a voucher entry
+//
+// Request and response bodies are CBOR. Each request is authenticated with an
+// HMAC-SHA256 of its body, keyed by a secret shared with the client, and must
+// be timestamped within MaxSkew of the service clock. Each request also has a
+// random nonce, which is rejected if used again within MaxSkew. Used nonces
+// are held in memory, so a request replayed to another replica of the service
+// is not detected.
+//
+// Only voucher entries are signed, never arbitrary digests, so a client with
+// the secret cannot use the keys for anything but extending vouchers, and
+// every signed entry is audited.
+type SigningHandler struct {
+	// Keys holds the manufacturer and owner keys of the service.
+	Keys fdo.AutoExtend
+
+	// Secret authenticates requests. It is required.
+	Secret []byte
+
+	// MaxSkew is the maximum difference between the timestamp of a request
+	// and the time it is received. If zero, DefaultSigningMaxSkew is used.
+	MaxSkew time.Duration
+
+	// Audit, if not nil, is called for every authenticated request, including
+	// those which fail. An error fails the request, so that no signature is
+	// made without being audited.
+	Audit func(*http.Request, SigningRecord) error
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // used nonce -> when it may be forgotten
+}
+
+func (h *SigningHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path != "/key" && path != "/sign" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSigningRequestSize))
+	if err != nil {
+		http.Error(w, "error reading request", http.StatusBadRequest)
+		return
+	}
+	if err := h.authenticate(r, body); err != nil {
+		slog.Warn("rejected signing service request", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if path == "/key" {
+		h.key(w, r, body)
+		return
+	}
+	h.sign(w, r, body)
+}
+
+func (h *SigningHandler) authenticate(r *http.Request, body []byte) error {
+	if len(h.Secret) == 0 {
+		return errors.New("signing service has no secret")
+	}
+	scheme, creds, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != signingAuthScheme {
+		return errors.New("missing authorization")
+	}
+	mac, err := base64.StdEncoding.DecodeString(creds)
+	if err != nil {
+		return errors.New("invalid authorization")
+	}
+	if !hmac.Equal(mac, signingMAC(h.Secret, body)) {
+		return errors.New("invalid authorization")
+	}
+	return nil
+}
+
+// checkFresh rejects a request which is not timestamped within MaxSkew or
+// whose nonce was already used.
+func (h *SigningHandler) checkFresh(timestamp int64, nonce []byte) error {
+	maxSkew := h.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = DefaultSigningMaxSkew
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)).Abs(); skew > maxSkew {
+		return fmt.Errorf("request timestamp is %s from service time", skew.Truncate(time.Second))
+	}
+	if len(nonce) != signingNonceSize {
+		return errors.New("invalid request nonce")
+	}
+
+	// Nonces are kept until their request would be rejected by its
+	// timestamp
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for n, forget := range h.nonces {
+		if now.After(forget) {
+			delete(h.nonces, n)
+		}
+	}
+	if _, used := h.nonces[string(nonce)]; used {
+		return errors.New("request nonce was already used")
+	}
+	if h.nonces == nil {
+		h.nonces = make(map[string]time.Time)
+	}
+	h.nonces[string(nonce)] = time.Unix(timestamp, 0).Add(maxSkew)
+	return nil
+}
+
+func (h *SigningHandler) signer(role SigningRole, keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	if h.Keys == nil {
+		return nil, nil, fdo.ErrNotFound
+	}
+	switch role {
+	case ManufacturerSigningRole:
+		return h.Keys.ManufacturerKey(keyType)
+	case OwnerSigningRole:
+		return h.Keys.OwnerKey(keyType)
+	default:
+		return nil, nil, fmt.Errorf("unknown signing role %q", role)
+	}
+}
+
+func (h *SigningHandler) key(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req signingKeyRequest
+	if err := cbor.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	record := SigningRecord{
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+		Role:       req.Role,
+		KeyType:    req.KeyType,
+	}
+
+	resp, status, err := func() (*signingKeyResponse, int, error) {
+		if err := h.checkFresh(req.Timestamp, req.Nonce); err != nil {
+			return nil, http.StatusUnauthorized, err
+		}
+		key, chain, err := h.signer(req.Role, req.KeyType)
+		if err != nil {
+			return nil, signingErrStatus(err), err
+		}
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		resp := &signingKeyResponse{PublicKey: der}
+		for _, cert := range chain {
+			resp.Chain = append(resp.Chain, (*cbor.X509Certificate)(cert))
+		}
+		return resp, http.StatusOK, nil
+	}()
+	if !h.audit(w, r, record, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeCBOR(w, resp)
+}
+
+func (h *SigningHandler) sign(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req signRequest
+	if err := cbor.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	record := SigningRecord{
+		Time:         time.Now(),
+		RemoteAddr:   r.RemoteAddr,
+		Role:         req.Role,
+		KeyType:      req.KeyType,
+		GUID:         req.GUID,
+		DeviceInfo:   req.DeviceInfo,
+		PreviousHash: req.Payload.PreviousHash,
+		NextOwner:    &req.Payload.PublicKey,
+	}
+
+	entry, status, err := func() (*cose.Sign1Tag[fdo.VoucherEntryPayload, []byte], int, error) {
+		if err := h.checkFresh(req.Timestamp, req.Nonce); err != nil {
+			return nil, http.StatusUnauthorized, err
+		}
+		if err := checkVoucherEntry(req); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		key, _, err := h.signer(req.Role, req.KeyType)
+		if err != nil {
+			return nil, signingErrStatus(err), err
+		}
+		entry, err := fdo.SignVoucherEntry(key, req.KeyType == protocol.RsaPssKeyType, req.Payload)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return entry, http.StatusOK, nil
+	}()
+	if !h.audit(w, r, record, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeCBOR(w, entry)
+}
+
+// checkVoucherEntry checks that a voucher entry is for the device of the
+// request and owned by a key of the same type as the signing key, as all keys
+// of a voucher must be.
+func checkVoucherEntry(req signRequest) error {
+	headerHash := req.Payload.HeaderHash
+	if req.Payload.PreviousHash.Algorithm != headerHash.Algorithm {
+		return errors.New("invalid voucher entry: hash algorithms differ")
+	}
+	switch headerHash.Algorithm {
+	case protocol.Sha256Hash, protocol.Sha384Hash:
+	default:
+		return fmt.Errorf("invalid voucher entry: unsupported hash algorithm %d", headerHash.Algorithm)
+	}
+	h := headerHash.Algorithm.HashFunc().New()
+	_, _ = h.Write(req.GUID[:])
+	_, _ = h.Write([]byte(req.DeviceInfo))
+	if !hmac.Equal(h.Sum(nil), headerHash.Value) {
+		return fmt.Errorf("invalid voucher entry: header hash does not match device %x", req.GUID)
+	}
+	if req.Payload.PublicKey.Type != req.KeyType {
+		return fmt.Errorf("invalid voucher entry: next owner key must be %s", req.KeyType)
+	}
+	return nil
+}
+
+// audit records a request and writes a 500 response if it cannot be recorded.
+func (h *SigningHandler) audit(w http.ResponseWriter, r *http.Request, record SigningRecord, err error) bool {
+	if err != nil {
+		record.Err = err.Error()
+	}
+	if h.Audit == nil {
+		return true
+	}
+	if err := h.Audit(r, record); err != nil {
+		slog.Error("error auditing signing service request", "error", err)
+		http.Error(w, "error auditing request", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func signingErrStatus(err error) int {
+	if errors.Is(err, fdo.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func signingMAC(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func writeCBOR(w http.ResponseWriter, v any) {
+	body, err := cbor.Marshal(v)
+	if err != nil {
+		http.Error(w, "error encoding response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/cbor")
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// maxSigningResponseSize limits the size of signing service response bodies,
+// which may contain certificate chains.
+const maxSigningResponseSize = 1 << 20
+
+// SigningClient requests voucher entry signatures from a remote signing
+// service, such as one served by SigningHandler. It implements fdo.AutoExtend,
+// so that a DI server may extend vouchers without holding the manufacturer or
+// owner keys.
+//
+// Its signers implement fdo.VoucherEntrySigner and only sign voucher entries.
+// Sign always fails, because the service does not sign arbitrary digests.
+type SigningClient struct {
+	// The http/https URL of the signing service, including the path prefix
+	// where it is mounted.
+	BaseURL string
+
+	// Secret authenticates requests and must match the secret of the service.
+	Secret []byte
+
+	// Client to use for HTTP requests. Nil indicates that the default client
+	// should be used.
+	Client *http.Client
+
+	// Timeout limits each request to the signing service. If zero, requests
+	// are only limited by Client.
+	Timeout time.Duration
+}
+
+var _ fdo.AutoExtend = (*SigningClient)(nil)
+
+// ManufacturerKey implements fdo.AutoExtend.
+func (c *SigningClient) ManufacturerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	return c.Signer(context.Background(), ManufacturerSigningRole, keyType)
+}
+
+// OwnerKey implements fdo.AutoExtend.
+func (c *SigningClient) OwnerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	return c.Signer(context.Background(), OwnerSigningRole, keyType)
+}
+
+// Signer returns a signer for a key held by the signing service and its
+// certificate chain. Each signature made by the signer is a request to the
+// service.
+func (c *SigningClient) Signer(ctx context.Context, role SigningRole, keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	var resp signingKeyResponse
+	nonce, err := newSigningNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.do(ctx, "/key", signingKeyRequest{
+		Role:      role,
+		KeyType:   keyType,
+		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+	}, &resp); err != nil {
+		return nil, nil, fmt.Errorf("error getting %s %s key: %w", keyType, role, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s %s key: %w", keyType, role, err)
+	}
+	chain := make([]*x509.Certificate, len(resp.Chain))
+	for i, cert := range resp.Chain {
+		chain[i] = (*x509.Certificate)(cert)
+	}
+	return &remoteSigner{client: c, role: role, keyType: keyType, public: pub}, chain, nil
+}
+
+func (c *SigningClient) do(ctx context.Context, path string, req, into any) error {
+	body, err := cbor.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/cbor")
+	httpReq.Header.Set("Authorization", signingAuthScheme+" "+base64.StdEncoding.EncodeToString(signingMAC(c.Secret, body)))
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxSigningResponseSize))
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signing service responded %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return cbor.Unmarshal(respBody, into)
+}
+
+func newSigningNonce() ([]byte, error) {
+	nonce := make([]byte, signingNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating request nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// remoteSigner is an fdo.VoucherEntrySigner for a key held by a signing
+// service.
+type remoteSigner struct {
+	client  *SigningClient
+	role    SigningRole
+	keyType protocol.KeyType
+	public  crypto.PublicKey
+}
+
+var _ fdo.VoucherEntrySigner = (*remoteSigner)(nil)
+
+func (s *remoteSigner) Public() crypto.PublicKey { return s.public }
+
+func (s *remoteSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, fmt.Errorf("%s %s key of signing service only signs voucher entries", s.keyType, s.role)
+}
+
+func (s *remoteSigner) SignVoucherEntry(guid protocol.GUID, deviceInfo string, usePSS bool, payload fdo.VoucherEntryPayload) (*cose.Sign1Tag[fdo.VoucherEntryPayload, []byte], error) {
+	if usePSS != (s.keyType == protocol.RsaPssKeyType) {
+		return nil, fmt.Errorf("%s key cannot sign voucher entries with PSS=%t", s.keyType, usePSS)
+	}
+	nonce, err := newSigningNonce()
+	if err != nil {
+		return nil, err
+	}
+	var entry cose.Sign1Tag[fdo.VoucherEntryPayload, []byte]
+	if err := s.client.do(context.Background(), "/sign", signRequest{
+		Role:       s.role,
+		KeyType:    s.keyType,
+		Timestamp:  time.Now().Unix(),
+		Nonce:      nonce,
+		GUID:       guid,
+		DeviceInfo: deviceInfo,
+		Payload:    payload,
+	}, &entry); err != nil {
+		return nil, fmt.Errorf("error signing voucher entry with %s %s key: %w", s.keyType, s.role, err)
+	}
+
+	// Check that the service signed the requested entry
+	if ok, err := entry.Untag().Verify(s.public, nil, nil); err != nil || !ok {
+		return nil, fmt.Errorf("signing service returned an invalid signature of voucher entry: %v", err)
+	}
+	want, err := cbor.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	got, err := cbor.Marshal(entry.Payload.Val)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(got, want) {
+		return nil, errors.New("signing service signed a different voucher entry than requested")
+	}
+	return &entry, nil
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestSigningService(t *testing.T) {
+	server := fdotest.NewServer(t)
+	secret := []byte("shared secret")
+
+	var mu sync.Mutex
+	var records []transport.SigningRecord
+	srv := httptest.NewServer(http.StripPrefix("/signing", &transport.SigningHandler{
+		Keys:   server.State,
+		Secret: secret,
+		Audit: func(_ *http.Request, record transport.SigningRecord) error {
+			mu.Lock()
+			defer mu.Unlock()
+			records = append(records, record)
+			return nil
+		},
+	}))
+	defer srv.Close()
+	server.DI.AutoExtend = &transport.SigningClient{BaseURL: srv.URL + "/signing", Secret: secret}
+
+	for _, keyType := range []protocol.KeyType{
+		protocol.Secp256r1KeyType,
+		protocol.Secp384r1KeyType,
+		protocol.RsaPssKeyType,
+	} {
+		t.Run(keyType.String(), func(t *testing.T) {
+			mu.Lock()
+			records = nil
+			mu.Unlock()
+
+			dev := server.NewDevice(t, keyType)
+			if entries := len(server.Voucher(t, dev.Cred.GUID).Entries); entries != 1 {
+				t.Fatalf("expected voucher to be extended remotely, got %d entries", entries)
+			}
+			guid := dev.Cred.GUID
+			server.RegisterBlob(t, guid)
+			if err := server.Onboard(t, dev, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			var signed int
+			for _, record := range records {
+				if record.Err != "" {
+					t.Errorf("unexpected failed request: %+v", record)
+				}
+				if record.NextOwner != nil {
+					signed++
+					if record.Role != transport.ManufacturerSigningRole || record.KeyType != keyType {
+						t.Errorf("expected %s manufacturer key to sign, got %+v", keyType, record)
+					}
+					if record.GUID != guid {
+						t.Errorf("expected signed entry for device %x, got %x", guid, record.GUID)
+					}
+				}
+			}
+			if signed != 1 {
+				t.Errorf("expected one audited signature, got %d", signed)
+			}
+		})
+	}
+
+	t.Run("wrong secret", func(t *testing.T) {
+		client := &transport.SigningClient{BaseURL: srv.URL + "/signing", Secret: []byte("wrong")}
+		_, _, err := client.Signer(context.Background(), transport.OwnerSigningRole, protocol.Secp256r1KeyType)
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Fatalf("expected unauthorized error, got %v", err)
+		}
+	})
+
+	t.Run("replayed request", func(t *testing.T) {
+		var sent *http.Request
+		var body []byte
+		client := &transport.SigningClient{
+			BaseURL: srv.URL + "/signing",
+			Secret:  secret,
+			Client: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				var err error
+				if body, err = io.ReadAll(req.Body); err != nil {
+					return nil, err
+				}
+				sent = req
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				return http.DefaultTransport.RoundTrip(req)
+			})},
+		}
+		if _, _, err := client.Signer(context.Background(), transport.OwnerSigningRole, protocol.Secp256r1KeyType); err != nil {
+			t.Fatal(err)
+		}
+
+		replay, err := http.NewRequest(http.MethodPost, sent.URL.String(), bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		replay.Header = sent.Header.Clone()
+		resp, err := http.DefaultClient.Do(replay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected replayed request to be unauthorized, got %s", resp.Status)
+		}
+	})
+
+	t.Run("invalid voucher entry", func(t *testing.T) {
+		client := &transport.SigningClient{BaseURL: srv.URL + "/signing", Secret: secret}
+		key, _, err := client.Signer(context.Background(), transport.OwnerSigningRole, protocol.Secp256r1KeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := key.Sign(rand.Reader, make([]byte, 32), crypto.SHA256); err == nil {
+			t.Fatal("expected signing service key to refuse signing a digest")
+		}
+
+		nextOwner, err := protocol.NewPublicKey(protocol.Secp256r1KeyType, key.Public().(*ecdsa.PublicKey), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = key.(fdo.VoucherEntrySigner).SignVoucherEntry(protocol.GUID{1}, "device", false, fdo.VoucherEntryPayload{
+			PreviousHash: protocol.Hash{Algorithm: protocol.Sha256Hash, Value: make([]byte, 32)},
+			HeaderHash:   protocol.Hash{Algorithm: protocol.Sha256Hash, Value: make([]byte, 32)},
+			PublicKey:    *nextOwner,
+		})
+		if err == nil || !strings.Contains(err.Error(), "400") {
+			t.Fatalf("expected bad request error for mismatched header hash, got %v", err)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		client := &transport.SigningClient{BaseURL: srv.URL + "/signing", Secret: secret}
+		_, _, err := client.Signer(context.Background(), transport.OwnerSigningRole, protocol.Rsa2048RestrKeyType+100)
+		if err == nil || !strings.Contains(err.Error(), "404") {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -7,7 +7,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
-	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -17,6 +16,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/kex"
@@ -30,10 +30,40 @@ type Transport struct {
 	// /fdo/101/msg.
 	BaseURL string
 
+	// ProtocolVersion is the FDO protocol version used in request paths, i.e.
+	// 100 for devices with FDO 1.0 clients. If zero, protocol.CurrentVersion
+	// is used.
+	ProtocolVersion uint16
+
 	// Client to use for HTTP requests. Nil indicates that the default client
 	// should be used.
 	Client *http.Client
 
+	// Proxy selects the proxy of each request when Client is nil, as in
+	// net/http.Transport. Use ProxyConfig to configure proxies manually. If
+	// nil, proxies are read from the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+	// environment variables.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Connection tunes the connections of the client when Client is nil. If
+	// both Connection and Proxy are nil, the default client is used.
+	Connection *ConnectionConfig
+
+	// UserAgent is sent as the User-Agent header of requests. If empty, the
+	// default of net/http is sent.
+	UserAgent string
+
+	// Header contains extra headers to send with every request, such as the
+	// device model or firmware version. Headers set by the transport, such as
+	// Authorization and Content-Type, are not overridden. Headers of a
+	// context from ContextWithHeader are added to these.
+	Header http.Header
+
+	// OnResponse, if set, is called with the headers of each response before
+	// its body is read, with the type of the message which was sent. It may
+	// be used to correlate responses with server logs.
+	OnResponse func(ctx context.Context, msgType uint8, header http.Header)
+
 	// Auth stores Authorization headers much like a CookieJar in a standard
 	// *http.Client stores cookie headers. As specified in Section 4.3, each
 	// protocol (TO1, TO2, etc.) generally starts with a message containing no
@@ -45,8 +75,10 @@ type Transport struct {
 	// message type.
 	Auth AuthorizationJar
 
-	// MaxContentLength defaults to 65535. Negative values disable content
-	// length checking.
+	// MaxContentLength defaults to 65535. Message bodies are decoded as they
+	// are read, so bodies of unknown length, such as those using chunked
+	// transfer encoding, are accepted and fail once the max is exceeded.
+	// Negative values disable content length checking.
 	MaxContentLength int64
 }
 
@@ -55,6 +87,13 @@ type Transport struct {
 //nolint:gocyclo
 func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.Session) (respType uint8, _ io.ReadCloser, _ error) {
 	// Initialize default values
+	if t.Client == nil && (t.Proxy != nil || t.Connection != nil) {
+		var conn ConnectionConfig
+		if t.Connection != nil {
+			conn = *t.Connection
+		}
+		t.Client = conn.Client(t.Proxy)
+	}
 	if t.Client == nil {
 		t.Client = http.DefaultClient
 	}
@@ -76,7 +115,11 @@ func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.S
 	}
 
 	// Create request with URL and body
-	uri, err := url.JoinPath(t.BaseURL, "fdo/101/msg", strconv.Itoa(int(msgType)))
+	version := t.ProtocolVersion
+	if version == 0 {
+		version = protocol.CurrentVersion
+	}
+	uri, err := url.JoinPath(t.BaseURL, "fdo", strconv.Itoa(int(version)), "msg", strconv.Itoa(int(msgType)))
 	if err != nil {
 		return 0, nil, fmt.Errorf("error parsing base URL: %w", err)
 	}
@@ -90,6 +133,11 @@ func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.S
 	}
 
 	// Add request headers
+	addHeaders(req.Header, t.Header)
+	addHeaders(req.Header, headerFromContext(ctx))
+	if t.UserAgent != "" {
+		req.Header.Set("User-Agent", t.UserAgent)
+	}
 	req.Header.Add("Content-Type", "application/cbor")
 	prot := protocol.Of(msgType)
 	if errMsg, ok := msg.(protocol.ErrorMessage); ok {
@@ -112,18 +160,37 @@ func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.S
 	}
 	resp, err := t.Client.Do(req)
 	if err != nil {
+		if proxy := proxyFor(t.Client, req); proxy != nil {
+			return 0, nil, fmt.Errorf("error making HTTP request for message %d via proxy %s: %w", msgType, proxy.Redacted(), err)
+		}
 		return 0, nil, fmt.Errorf("error making HTTP request for message %d: %w", msgType, err)
 	}
 	if debugEnabled() {
 		debugResp, _ := httputil.DumpResponse(resp, false)
 		var saveBody bytes.Buffer
-		if _, err := saveBody.ReadFrom(resp.Body); err == nil {
-			resp.Body = io.NopCloser(&saveBody)
+		if _, err := saveBody.ReadFrom(debugBody(resp.Body, t.MaxContentLength)); err == nil {
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{
+				Reader: io.MultiReader(&saveBody, resp.Body),
+				Closer: resp.Body,
+			}
 		}
 		slog.Debug("response", "dump", string(bytes.TrimSpace(debugResp)),
 			"body", tryDebugNotation(saveBody.Bytes()))
 	}
 
+	if t.OnResponse != nil {
+		t.OnResponse(ctx, msgType, resp.Header.Clone())
+	}
+
+	// Fail with a distinct error when the network intercepts requests
+	if portal := captivePortal(req.URL, resp); portal != nil {
+		_ = resp.Body.Close()
+		return 0, nil, portal
+	}
+
 	return t.handleResponse(resp, sess)
 }
 
@@ -150,36 +217,24 @@ func (t *Transport) handleResponse(resp *http.Response, sess kex.Session) (msgTy
 		msgType = uint8(typ)
 	case http.StatusInternalServerError:
 		msgType = 255
+	case http.StatusServiceUnavailable:
+		defer func() { _ = resp.Body.Close() }()
+		return 0, nil, retryError(resp, maxContentLength(t.MaxContentLength))
 	default:
 		_ = resp.Body.Close()
 		return 0, nil, fmt.Errorf("unexpected HTTP response code: %s", resp.Status)
 	}
 
-	// Validate content length
-	maxSize := t.MaxContentLength
-	if maxSize == 0 {
-		maxSize = 65535
-	}
+	// Reject content which is known to be too large
+	maxSize := maxContentLength(t.MaxContentLength)
 	if maxSize > 0 && resp.ContentLength > maxSize {
 		_ = resp.Body.Close()
 		return 0, nil, fmt.Errorf("content too large (%d bytes)", resp.ContentLength)
 	}
-	if maxSize > 0 && resp.ContentLength < 0 {
-		_ = resp.Body.Close()
-		return 0, nil, errors.New("content length must be specified in response headers")
-	}
 
-	// Allow reading up to expected content length
-	content := resp.Body
-	if resp.ContentLength > 0 {
-		content = io.ReadCloser(struct {
-			io.Reader
-			io.Closer
-		}{
-			Reader: io.LimitReader(resp.Body, resp.ContentLength),
-			Closer: resp.Body,
-		})
-	}
+	// Stream the content, failing once more than the max size has been read,
+	// so that responses of unknown length are allowed
+	content := newLimitedBody(resp.Body, maxSize)
 
 	// Decrypt if a key exchange session is provided for types other than error
 	if sess != nil && msgType != protocol.ErrorMsgType {
@@ -199,3 +254,35 @@ func (t *Transport) handleResponse(resp *http.Response, sess kex.Session) (msgTy
 
 	return msgType, content, nil
 }
+
+// retryError returns the error message of a response asking the device to
+// back off, with the delay of its Retry-After header. Responses without an
+// error message body, such as those of a load balancer, are also converted.
+func retryError(resp *http.Response, maxSize int64) error {
+	var errMsg protocol.ErrorMessage
+	if err := cbor.NewDecoder(newLimitedBody(resp.Body, maxSize)).Decode(&errMsg); err != nil {
+		errMsg = protocol.ErrorMessage{
+			Code:      protocol.InternalServerErrCode,
+			ErrString: resp.Status,
+			Timestamp: time.Now().Unix(),
+		}
+	}
+	errMsg.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	return errMsg
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date. Zero is returned if it is invalid.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
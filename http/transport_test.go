@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestTransportCaptivePortal(t *testing.T) {
+	portal := http.NewServeMux()
+	portal.HandleFunc("GET /login", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html><body>Accept the terms to continue</body></html>"))
+	})
+	portal.HandleFunc("POST /redirect/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+	portal.HandleFunc("POST /page/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	})
+	srv := httptest.NewServer(portal)
+	defer srv.Close()
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for _, test := range []struct {
+		name     string
+		path     string
+		client   *http.Client
+		location string
+	}{
+		{name: "followed redirect", path: "/redirect", location: srv.URL + "/login"},
+		{name: "unfollowed redirect", path: "/redirect", client: noRedirects, location: srv.URL + "/login"},
+		{name: "html page", path: "/page"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr := &transport.Transport{BaseURL: srv.URL + test.path, Client: test.client}
+			_, _, err := tr.Send(context.Background(), protocol.TO1HelloRVMsgType, protocol.Nonce{}, nil)
+
+			var portalErr *transport.CaptivePortalError
+			if !errors.As(err, &portalErr) {
+				t.Fatalf("expected captive portal error, got %v", err)
+			}
+			if portalErr.Location != test.location {
+				t.Errorf("expected location %q, got %q", test.location, portalErr.Location)
+			}
+		})
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	proxy, err := transport.ProxyConfig{
+		HTTPProxy:  "proxy.example.com:3128",
+		HTTPSProxy: "https://secure-proxy.example.com",
+		NoProxy:    []string{".internal.example.com", "10.0.0.0/8", "192.168.1.1"},
+	}.ProxyFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expect := range map[string]string{
+		"http://rv.example.com/fdo":         "http://proxy.example.com:3128",
+		"https://rv.example.com/fdo":        "https://secure-proxy.example.com",
+		"http://owner.internal.example.com": "",
+		"http://internal.example.com":       "",
+		"http://notinternal.example.com":    "http://proxy.example.com:3128",
+		"http://10.1.2.3:8080":              "",
+		"http://192.168.1.1":                "",
+		"http://192.168.1.2":                "http://proxy.example.com:3128",
+		"http://localhost:8080":             "",
+		"http://[::1]:8080":                 "",
+	} {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := proxy(&http.Request{URL: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil && expect != "") || (got != nil && got.String() != expect) {
+			t.Errorf("%s: expected proxy %q, got %v", target, expect, got)
+		}
+	}
+
+	if _, err := (transport.ProxyConfig{HTTPProxy: "http://"}).ProxyFunc(); err == nil {
+		t.Error("expected error for proxy URL without host")
+	}
+	if _, err := (transport.ProxyConfig{NoProxy: []string{"10.0.0.0/33"}}).ProxyFunc(); err == nil {
+		t.Error("expected error for invalid CIDR prefix")
+	}
+}
+
+func TestTransportConnectionReuse(t *testing.T) {
+	server := fdotest.NewServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", transport.Handler{
+		Tokens: server.State,
+		TO1Responder: responderFunc(func(_ context.Context, msgType uint8, _ io.Reader) (uint8, any) {
+			return protocol.ErrorMsgType, protocol.ErrorMessage{Code: protocol.ResourceNotFound, PrevMsgType: msgType}
+		}),
+	})
+	srv := httptest.NewUnstartedServer(mux)
+	var conns atomic.Int32
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	for _, test := range []struct {
+		name   string
+		config transport.ConnectionConfig
+		expect int32
+	}{
+		{name: "keep-alive", config: transport.ConnectionConfig{IdleConnTimeout: time.Minute}, expect: 1},
+		{name: "no keep-alive", config: transport.ConnectionConfig{DisableKeepAlives: true}, expect: 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conns.Store(0)
+			tr := &transport.Transport{BaseURL: srv.URL, Connection: &test.config}
+			for range 3 {
+				_, body, err := tr.Send(context.Background(), protocol.TO1HelloRVMsgType, protocol.Nonce{}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Closing an unread body must not prevent reuse
+				_ = body.Close()
+			}
+			if got := conns.Load(); got != test.expect {
+				t.Errorf("expected %d connections, got %d", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestTransportHeaders(t *testing.T) {
+	var received http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("X-Request-Id", "abc123")
+		w.Header().Set("Message-Type", "255")
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var respType uint8
+	var respHeader http.Header
+	tr := &transport.Transport{
+		BaseURL:   srv.URL,
+		UserAgent: "gotest-device/1.2.3",
+		Header: http.Header{
+			"X-Device-Model": {"model-a"},
+			"Content-Type":   {"text/plain"},
+		},
+		OnResponse: func(_ context.Context, msgType uint8, header http.Header) {
+			respType, respHeader = msgType, header
+		},
+	}
+	ctx := transport.ContextWithHeader(context.Background(), http.Header{"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}})
+	_, body, err := tr.Send(ctx, protocol.TO1HelloRVMsgType, protocol.Nonce{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = body.Close()
+
+	if got := received.Get("User-Agent"); got != "gotest-device/1.2.3" {
+		t.Errorf("expected user agent, got %q", got)
+	}
+	if got := received.Get("X-Device-Model"); got != "model-a" {
+		t.Errorf("expected device model header, got %q", got)
+	}
+	if got := received.Get("Traceparent"); got == "" {
+		t.Error("expected trace header from context")
+	}
+	if got := received.Values("Content-Type"); len(got) != 1 || got[0] != "application/cbor" {
+		t.Errorf("expected content type to not be overridden, got %q", got)
+	}
+	if respType != protocol.TO1HelloRVMsgType || respHeader.Get("X-Request-Id") != "abc123" {
+		t.Errorf("expected response headers of message %d, got %v for message %d", protocol.TO1HelloRVMsgType, respHeader, respType)
+	}
+}
+
+func TestTransportStreamingResponse(t *testing.T) {
+	body, err := cbor.Marshal(make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flush before writing the body, so that it is sent with chunked
+		// transfer encoding and no content length
+		w.Header().Set("Message-Type", "31")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		name      string
+		max       int64
+		expectErr bool
+	}{
+		{name: "within limit", max: int64(len(body))},
+		{name: "exceeding limit", max: int64(len(body)) - 1, expectErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr := &transport.Transport{BaseURL: srv.URL, MaxContentLength: test.max}
+			_, resp, err := tr.Send(context.Background(), protocol.TO1HelloRVMsgType, protocol.Nonce{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Close() }()
+
+			var got []byte
+			err = cbor.NewDecoder(resp).Decode(&got)
+			if test.expectErr {
+				if err == nil {
+					t.Error("expected error decoding response exceeding limit")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1000 {
+				t.Errorf("expected 1000 bytes, got %d", len(got))
+			}
+		})
+	}
+}
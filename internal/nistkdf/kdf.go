@@ -7,13 +7,46 @@ package nistkdf
 import (
 	"crypto"
 	"crypto/hmac"
-	"crypto/sha512"
+	_ "crypto/sha256" // Register SHA-256 PRF
+	_ "crypto/sha512" // Register SHA-384 PRF
 	"encoding/binary"
+	"fmt"
 	"math"
 )
 
-// KDF implements NIST 800-108 using the parameters defined in FDO.
-func KDF(hash crypto.Hash, shSe, contextRand []byte, bits uint16) []byte {
+// FDO parameters for SEK/SVK derivation.
+const (
+	Label         = "FIDO-KDF"
+	ContextPrefix = "AutomaticOnboardTunnel"
+)
+
+// KDF implements NIST 800-108 using the parameters defined in FDO. The PRF is
+// HMAC using hash, which must be SHA-256 or SHA-384, depending on the cipher
+// suite.
+func KDF(hash crypto.Hash, shSe, contextRand []byte, bits uint16) ([]byte, error) {
+	if hash != crypto.SHA256 && hash != crypto.SHA384 {
+		return nil, fmt.Errorf("unsupported PRF hash: %s", hash)
+	}
+	context := append([]byte(ContextPrefix), contextRand...)
+	return CounterMode(hash, shSe, []byte(Label), context, bits)
+}
+
+// CounterMode implements the NIST SP 800-108 KDF in counter mode with HMAC as
+// the PRF. The counter is 8 bits (r = 8) and precedes the fixed input data,
+// which is Label || 0x00 || Context || [L]_2 with L encoded as a 16-bit big
+// endian integer. The number of bits must be a non-zero multiple of 8.
+func CounterMode(hash crypto.Hash, kIn, label, context []byte, bits uint16) ([]byte, error) {
+	fixedInput := append([]byte{}, label...)
+	fixedInput = append(fixedInput, 0x00)
+	fixedInput = append(fixedInput, context...)
+	fixedInput = binary.BigEndian.AppendUint16(fixedInput, bits)
+	return CounterModeFixedInput(hash, kIn, fixedInput, bits)
+}
+
+// CounterModeFixedInput implements the NIST SP 800-108 KDF in counter mode
+// with HMAC as the PRF, an 8-bit counter preceding the fixed input data, and
+// the fixed input data given in full, as in the NIST CAVP test vectors.
+func CounterModeFixedInput(hash crypto.Hash, kIn, fixedInput []byte, bits uint16) ([]byte, error) {
 	// NIST SP 800-108 KDF in Counter Mode
 	//
 	// Parameters:
@@ -42,63 +75,46 @@ func KDF(hash crypto.Hash, shSe, contextRand []byte, bits uint16) []byte {
 	//     • PRF = HMAC-SHA256 or HMAC-SHA384, depending on CipherSuite
 
 	// Parameters
-	var h uint16
-	switch hash.Size() {
-	case sha512.Size256:
-		h = sha512.Size256
-	case sha512.Size384:
-		h = sha512.Size384
-	default:
-		panic("unsupported hash size")
+	if !hash.Available() {
+		return nil, fmt.Errorf("PRF hash not available: %s", hash)
 	}
+	h := hash.Size() * 8
 
 	// Input
-	kIn := shSe
-	label := []byte("FIDO-KDF")
-	context := append([]byte("AutomaticOnboardTunnel"), contextRand...)
 	L := bits
+	if L == 0 || L%8 != 0 {
+		return nil, fmt.Errorf("output length must be a non-zero multiple of 8 bits, got %d", L)
+	}
 
 	// Process
 	// 1.
-	n := L / h
-	if L%h != 0 {
-		n++
-	}
+	n := (int(L) + h - 1) / h
 
 	// 2.
 	if n > math.MaxUint8 {
-		panic("n too large")
+		return nil, fmt.Errorf("output length too large: %d bits", L)
 	}
-	// Equivalent to
-	//
-	// r := uint16(8)
-	// if float64(n) > math.Pow(2, float64(r))-1 {
-	// 	return nil, fmt.Errorf("n too large")
-	// }
 
 	// 3.
-	var result []byte
+	result := make([]byte, 0, n*h/8)
 
 	// 4.
 	input := []byte{0x00} // iteration-dependent
-	input = append(input, label...)
-	input = append(input, 0x00)
-	input = append(input, context...)
-	input = binary.BigEndian.AppendUint16(input, L)
+	input = append(input, fixedInput...)
 	digest := hmac.New(hash.New, kIn)
-	for i := uint8(0); i < uint8(n); i++ {
+	for i := 1; i <= n; i++ {
 		// a.
 		digest.Reset()
-		input[0] = i + 1
+		input[0] = uint8(i)
 		_, _ = digest.Write(input)
 
 		// b.
-		result = append(result, digest.Sum(nil)...)
+		result = digest.Sum(result)
 	}
 
 	// 5.
 	kOut := result[:L/8]
 
 	// Output
-	return kOut
+	return kOut, nil
 }
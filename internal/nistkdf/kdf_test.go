@@ -6,7 +6,9 @@ package nistkdf_test
 import (
 	"bytes"
 	"crypto"
+	_ "crypto/sha1" // Register SHA-1 PRF for CAVP vectors
 	"encoding/hex"
+	"fmt"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo/internal/nistkdf"
@@ -25,8 +27,126 @@ func TestKnownResult(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := nistkdf.KDF(crypto.SHA256, shSe, nil, 256)
+	got, err := nistkdf.KDF(crypto.SHA256, shSe, nil, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !bytes.Equal(expect, got) {
 		t.Fatalf("expected %x, got %x", expect, got)
 	}
 }
+
+// Expected values were computed independently by building each PRF input by
+// hand and using `openssl mac -digest <hash> -macopt hexkey:<K_IN> HMAC`.
+func TestVectors(t *testing.T) {
+	for _, test := range []struct {
+		Name        string
+		Hash        crypto.Hash
+		KIn         string
+		ContextRand string
+		Bits        uint16
+		Expect      string
+	}{
+		{
+			Name:        "HMAC-SHA384 with context rand, two blocks",
+			Hash:        crypto.SHA384,
+			KIn:         "8bda6a7c7bf29024f3745bdb8893391645ef87fcc5636e012b467adf56c476c1e33e586794c7f0793042bb150aa4d5cb",
+			ContextRand: "b442cf2a2b1e8b19015e97fac160bce0",
+			Bits:        512,
+			Expect:      "1429d5872d0984acd66b3e3e396b7ad5d7fcbf4dc25347964750fc7e30692378afebf7a2b24cc8765b85f888c768a08833169fbbca2d77303af7a40ca945e7e0",
+		},
+		{
+			Name:   "HMAC-SHA256 without context rand, two blocks",
+			Hash:   crypto.SHA256,
+			KIn:    "fb5fe13fd7e0344307d3de8a2890eb471d037434c9375ecab2434dfed40f05e4",
+			Bits:   384,
+			Expect: "f0047f1fa4a9967ad67d21c89160fde022bed7969182899f2e58039dfe13c282cceb5688232d1ec3f18aebba55094762",
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			kIn, _ := hex.DecodeString(test.KIn)
+			contextRand, _ := hex.DecodeString(test.ContextRand)
+			expect, _ := hex.DecodeString(test.Expect)
+			got, err := nistkdf.KDF(test.Hash, kIn, contextRand, test.Bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expect, got) {
+				t.Fatalf("expected %x, got %x", expect, got)
+			}
+		})
+	}
+
+	t.Run("custom label and context", func(t *testing.T) {
+		kIn, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+		expect, _ := hex.DecodeString("535c77bea7b675aa6e4ae4653f4fa10b")
+		got, err := nistkdf.CounterMode(crypto.SHA256, kIn, []byte("label"), []byte("context"), 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expect, got) {
+			t.Fatalf("expected %x, got %x", expect, got)
+		}
+	})
+}
+
+// Vectors are from the NIST CAVP KBKDF (SP 800-108) counter mode test file
+// KDFCTR_gen.rsp (CAVS 14.4), [PRF=HMAC_SHA1] [CTRLOCATION=BEFORE_FIXED]
+// [RLEN=8_BITS], which uses the counter size and location of FDO.
+func TestCAVPCounterModeVectors(t *testing.T) {
+	for i, test := range []struct {
+		Hash           crypto.Hash
+		Bits           uint16
+		KI             string
+		FixedInputData string
+		KO             string
+	}{
+		{
+			Hash:           crypto.SHA1,
+			Bits:           128,
+			KI:             "00a39bd547fb88b2d98727cf64c195c61e1cad6c",
+			FixedInputData: "98132c1ffaf59ae5cbc0a3133d84c551bb97e0c75ecaddfc30056f6876f59803009bffc7d75c4ed46f40b8f80426750d15bc1ddb14ac5dcb69a68242",
+			KO:             "0611e1903609b47ad7a5fc2c82e47702",
+		},
+		{
+			Hash:           crypto.SHA1,
+			Bits:           128,
+			KI:             "a39bdf744ed7e33fdec060c8736e9725179885a8",
+			FixedInputData: "af71b44940acff98949ad17f1ca20e8fdb3957cacdcd41e9c591e18235019f90b9f8ee6e75700bcab2f8407525a104799b3e9725e27d738a9045e832",
+			KO:             "51dc4668947e3685099bc3b5f8527468",
+		},
+	} {
+		t.Run(fmt.Sprintf("COUNT=%d", i), func(t *testing.T) {
+			kIn, _ := hex.DecodeString(test.KI)
+			fixedInput, _ := hex.DecodeString(test.FixedInputData)
+			expect, _ := hex.DecodeString(test.KO)
+			got, err := nistkdf.CounterModeFixedInput(test.Hash, kIn, fixedInput, test.Bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expect, got) {
+				t.Fatalf("expected %x, got %x", expect, got)
+			}
+		})
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	kIn := make([]byte, 32)
+	for _, test := range []struct {
+		Name string
+		Hash crypto.Hash
+		Bits uint16
+	}{
+		{Name: "unsupported hash", Hash: crypto.SHA512, Bits: 256},
+		{Name: "zero length", Hash: crypto.SHA256, Bits: 0},
+		{Name: "partial byte", Hash: crypto.SHA256, Bits: 129},
+		{Name: "too many blocks", Hash: crypto.SHA256, Bits: 256*255 + 8},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			if _, err := nistkdf.KDF(test.Hash, kIn, nil, test.Bits); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
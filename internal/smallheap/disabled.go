@@ -0,0 +1,11 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build !tinygo && !smallheap
+
+package smallheap
+
+// Enabled is true when built for constrained devices.
+const Enabled = false
+
+const serviceInfoBuffers = 1000
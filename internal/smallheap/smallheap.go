@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package smallheap reports whether the library is built for constrained
+// devices, such as microcontroller-class gateways.
+//
+// Small-heap mode is enabled by building with TinyGo or with the smallheap
+// build tag. In this mode, default buffer sizes are reduced and debug logging
+// paths which rely heavily on reflection are replaced, so that the client fits
+// within the heap budget documented in docs/decisions/0006-small-heap-mode.md.
+// Messages are still encoded with reflection in every mode.
+package smallheap
+
+// ServiceInfoBuffers is the default number of service info messages buffered
+// in each direction during a round of the TO2 service info exchange.
+const ServiceInfoBuffers = serviceInfoBuffers
+
+// HeapBudget is the maximum growth of the live heap of the client, in bytes,
+// while a device onboards with TO2 in small-heap mode, not counting one-time
+// initialization. It is enforced by tests built with the smallheap tag.
+const HeapBudget = 64 << 10
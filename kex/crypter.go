@@ -4,7 +4,8 @@
 package kex
 
 import (
-	"bytes"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -21,29 +22,116 @@ type SessionCrypter struct {
 
 	SEK []byte
 	SVK []byte
+
+	// Usage of the session keys, including limits
+	Usage KeyUsage
+}
+
+// Default limits on session key usage.
+//
+// Every supported cipher uses a randomly generated IV per message, so per NIST
+// SP 800-38D section 8.3 a key must not be used for more than 2^32
+// invocations. Device and owner share SEK, so messages in both directions
+// count toward the limit. The byte limit bounds the total data protected by a
+// single key, similar to the AES-GCM data limits of RFC 8446 section 5.5.
+const (
+	DefaultMaxMessages uint64 = 1 << 32
+	DefaultMaxBytes    uint64 = 1 << 38
+)
+
+// ErrKeyUsageLimit is returned by Encrypt and Decrypt when the session keys
+// have been used for the maximum number of messages or bytes. The protocol
+// must fail rather than continue with the same keys.
+var ErrKeyUsageLimit = errors.New("session key usage limit reached")
+
+// KeyUsage tracks the number of messages and ciphertext bytes encrypted or
+// decrypted with the session keys. Zero limits use DefaultMaxMessages and
+// DefaultMaxBytes.
+type KeyUsage struct {
+	Messages uint64
+	Bytes    uint64
+
+	MaxMessages uint64
+	MaxBytes    uint64
 }
 
+// use records a message of n ciphertext bytes, returning ErrKeyUsageLimit if
+// either limit would be exceeded.
+func (u *KeyUsage) use(n int) error {
+	maxMessages, maxBytes := u.MaxMessages, u.MaxBytes
+	if maxMessages == 0 {
+		maxMessages = DefaultMaxMessages
+	}
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if u.Messages >= maxMessages {
+		return fmt.Errorf("%w: %d messages", ErrKeyUsageLimit, u.Messages)
+	}
+	if uint64(n) > maxBytes-min(u.Bytes, maxBytes) {
+		return fmt.Errorf("%w: %d bytes", ErrKeyUsageLimit, u.Bytes)
+	}
+	u.Messages++
+	u.Bytes += uint64(n)
+	return nil
+}
+
+func (u KeyUsage) persist() *KeyUsage {
+	if u == (KeyUsage{}) {
+		return nil
+	}
+	return &u
+}
+
+// String implements fmt.Stringer. Session keys are redacted.
 func (s SessionCrypter) String() string {
 	return fmt.Sprintf(`SessionCrypter[
   ID   %d
   %s
-  SEK  %x
-  SVK  %x
+  SEK  %s
+  SVK  %s
 ]`,
 		s.ID,
 		strings.ReplaceAll(s.Cipher.String(), "\n", "\n  "),
-		s.SEK,
-		s.SVK,
+		redact(s.SEK),
+		redact(s.SVK),
 	)
 }
 
+// GoString implements fmt.GoStringer, so that session keys are not printed
+// when formatting with %#v.
+func (s SessionCrypter) GoString() string { return s.String() }
+
+// Equal compares two session crypters, using constant time comparisons for
+// session keys.
+func (s SessionCrypter) Equal(other SessionCrypter) bool {
+	// Evaluate both key comparisons unconditionally
+	sekEq := subtle.ConstantTimeCompare(s.SEK, other.SEK)
+	svkEq := subtle.ConstantTimeCompare(s.SVK, other.SVK)
+	return sekEq&svkEq == 1 && s.ID == other.ID && s.Cipher == other.Cipher && s.Usage == other.Usage
+}
+
+// redact formats secret key material for debug output without revealing it.
+func redact(secret []byte) string {
+	if len(secret) == 0 {
+		return "<empty>"
+	}
+	return fmt.Sprintf("<redacted %d bytes>", len(secret))
+}
+
 // Encrypt uses a session key to encrypt a payload. Depending on the suite,
 // the result may be a plain COSE_Encrypt0 or one wrapped by COSE_Mac0.
-func (s SessionCrypter) Encrypt(rand io.Reader, payload any) (any, error) {
+//
+// ErrKeyUsageLimit is returned if the session keys have reached their usage
+// limits.
+func (s *SessionCrypter) Encrypt(rand io.Reader, payload any) (any, error) {
 	var enc0 cose.Encrypt0[any, []byte]
 	if err := enc0.Encrypt(s.Cipher.EncryptAlg, s.SEK, payload, nil); err != nil {
 		return nil, err
 	}
+	if err := s.Usage.use(len(*enc0.Ciphertext)); err != nil {
+		return nil, err
+	}
 	if s.Cipher.MacAlg == 0 {
 		return enc0.Tag(), nil
 	}
@@ -58,7 +146,10 @@ func (s SessionCrypter) Encrypt(rand io.Reader, payload any) (any, error) {
 }
 
 // Decrypt a tagged COSE Encrypt0 or Mac0 object.
-func (s SessionCrypter) Decrypt(rand io.Reader, r io.Reader) ([]byte, error) {
+//
+// ErrKeyUsageLimit is returned if the session keys have reached their usage
+// limits.
+func (s *SessionCrypter) Decrypt(rand io.Reader, r io.Reader) ([]byte, error) {
 	// Unmarshal a raw CBOR tag
 	var tag cbor.Tag[cbor.RawBytes]
 	if err := cbor.NewDecoder(r).Decode(&tag); err != nil {
@@ -78,11 +169,9 @@ func (s SessionCrypter) Decrypt(rand io.Reader, r io.Reader) ([]byte, error) {
 		if err := cbor.Unmarshal([]byte(tag.Val), &mac0); err != nil {
 			return nil, fmt.Errorf("error decoding COSE_Mac0: %w", err)
 		}
-		expectedDigest := mac0.Value
-		if err := mac0.Digest(s.Cipher.MacAlg, s.SVK, nil, nil); err != nil {
-			return nil, fmt.Errorf("error computing COSE_Mac0 tag for comparison: %w", err)
-		}
-		if !bytes.Equal(mac0.Value, expectedDigest) {
+		if ok, err := mac0.Verify(s.Cipher.MacAlg, s.SVK, nil, nil); err != nil {
+			return nil, fmt.Errorf("error verifying COSE_Mac0 tag: %w", err)
+		} else if !ok {
 			return nil, fmt.Errorf("value of COSE_Mac0 tag did not match expected")
 		}
 		enc0 = mac0.Payload.Val
@@ -91,6 +180,14 @@ func (s SessionCrypter) Decrypt(rand io.Reader, r io.Reader) ([]byte, error) {
 		return nil, fmt.Errorf("decrypted value must be a COSE_Encrypt0 or COSE_Mac0")
 	}
 
+	// Count usage before decrypting
+	if enc0.Ciphertext == nil {
+		return nil, fmt.Errorf("COSE_Encrypt0 ciphertext is missing")
+	}
+	if err := s.Usage.use(len(*enc0.Ciphertext)); err != nil {
+		return nil, err
+	}
+
 	// Decrypt contents
 	raw, err := enc0.Decrypt(s.Cipher.EncryptAlg, s.SEK, nil)
 	if err != nil {
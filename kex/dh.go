@@ -5,6 +5,7 @@ package kex
 
 import (
 	"crypto/rsa"
+	"crypto/subtle"
 	"encoding"
 	"fmt"
 	"io"
@@ -94,28 +95,70 @@ type DHSession struct {
 	SessionCrypter
 }
 
+// String implements fmt.Stringer. Private exponents and session keys are
+// redacted.
 func (s DHSession) String() string {
 	return fmt.Sprintf(`DH[
   p     %x
   g     %d
   size  %d
-  a     %x
+  a     %s
   xA    %x
-  b     %x
+  b     %s
   xB    %x
   %s
 ]`,
 		bigIntBytes(s.p),
 		s.g,
 		s.paramSize,
-		bigIntBytes(s.a),
+		redact(bigIntBytes(s.a)),
 		bigIntBytes(s.xA),
-		bigIntBytes(s.b),
+		redact(bigIntBytes(s.b)),
 		bigIntBytes(s.xB),
 		strings.ReplaceAll(s.SessionCrypter.String(), "\n", "\n  "),
 	)
 }
 
+// GoString implements fmt.GoStringer, so that secrets are not printed when
+// formatting with %#v.
+func (s DHSession) GoString() string { return s.String() }
+
+// Equal compares two key exchange sessions. Private exponents and session keys
+// are compared in constant time.
+func (s *DHSession) Equal(other Session) bool {
+	s1, ok := other.(*DHSession)
+	if !ok || s1 == nil {
+		return false
+	}
+
+	// Evaluate all secret comparisons unconditionally
+	aEq := secretIntEqual(s.a, s1.a)
+	bEq := secretIntEqual(s.b, s1.b)
+	crypterEq := s.SessionCrypter.Equal(s1.SessionCrypter)
+	if !aEq || !bEq || !crypterEq {
+		return false
+	}
+
+	return s.g == s1.g && s.paramSize == s1.paramSize &&
+		intEqual(s.p, s1.p) && intEqual(s.xA, s1.xA) && intEqual(s.xB, s1.xB)
+}
+
+func intEqual(x, y *big.Int) bool {
+	if x == nil || y == nil {
+		return x == y
+	}
+	return x.Cmp(y) == 0
+}
+
+// secretIntEqual compares secret integers in constant time, revealing only
+// whether each is set and their lengths.
+func secretIntEqual(x, y *big.Int) bool {
+	if x == nil || y == nil {
+		return x == y
+	}
+	return subtle.ConstantTimeCompare(x.Bytes(), y.Bytes()) == 1
+}
+
 func bigIntBytes(i *big.Int) []byte {
 	if i == nil {
 		return nil
@@ -201,7 +244,10 @@ func dhSymmetricKey(other, own, p *big.Int, cipher CipherSuite) (sek, svk []byte
 	if cipher.MacAlg != 0 {
 		svkSize = cipher.MacAlg.KeySize()
 	}
-	symKey := nistkdf.KDF(cipher.PRFHash, shSe, []byte{}, (sekSize+svkSize)*8)
+	symKey, err := nistkdf.KDF(cipher.PRFHash, shSe, []byte{}, (sekSize+svkSize)*8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving session keys: %w", err)
+	}
 
 	return symKey[:sekSize], symKey[sekSize:], nil
 }
@@ -219,6 +265,10 @@ type dhPersist struct {
 	Cipher CipherSuiteID
 	SEK    []byte
 	SVK    []byte
+
+	// Optional for compatibility with sessions persisted before key usage
+	// was tracked
+	Usage *KeyUsage `cbor:",omitempty"`
 }
 
 // MarshalCBOR implements [cbor.Marshaler].
@@ -231,6 +281,7 @@ func (s *DHSession) MarshalCBOR() ([]byte, error) {
 		Cipher: s.ID,
 		SEK:    s.SEK,
 		SVK:    s.SVK,
+		Usage:  s.Usage.persist(),
 	}
 	if s.a != nil {
 		persist.ParamA = s.a.Bytes()
@@ -266,6 +317,9 @@ func (s *DHSession) UnmarshalCBOR(data []byte) error {
 			SVK:    persist.SVK,
 		},
 	}
+	if persist.Usage != nil {
+		s.Usage = *persist.Usage
+	}
 	if len(persist.ParamA) > 0 {
 		s.a = new(big.Int).SetBytes(persist.ParamA)
 	}
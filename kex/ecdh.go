@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"crypto/ecdh"
 	"crypto/rsa"
+	"crypto/subtle"
 	"encoding"
 	"encoding/binary"
 	"fmt"
@@ -70,10 +71,16 @@ type ECDHSession struct {
 	xB   []byte
 	priv *ecdh.PrivateKey
 
+	// Optional hardware-backed key
+	provider    KeyProvider
+	providerKey *providerKey
+
 	// Session encrypt/decrypt data
 	SessionCrypter
 }
 
+// String implements fmt.Stringer. The private key and session keys are
+// redacted.
 func (s ECDHSession) String() string {
 	var keyBytes []byte
 	if s.priv != nil {
@@ -83,17 +90,21 @@ func (s ECDHSession) String() string {
   randSize  %d
   xA        %x
   xB        %x
-  key       %x
+  key       %s
   %s
 ]`,
 		s.randSize,
 		s.xA,
 		s.xB,
-		keyBytes,
+		redact(keyBytes),
 		strings.ReplaceAll(s.SessionCrypter.String(), "\n", "\n  "),
 	)
 }
 
+// GoString implements fmt.GoStringer, so that secrets are not printed when
+// formatting with %#v.
+func (s ECDHSession) GoString() string { return s.String() }
+
 // Equal compares two key exchange sessions. If an Equal method is not
 // implemented, the sessions can be compared with reflect.DeepEqual.
 func (s *ECDHSession) Equal(other Session) bool {
@@ -108,7 +119,7 @@ func (s *ECDHSession) Equal(other Session) bool {
 	switch {
 	case sCopy.priv != nil && s1Copy.priv != nil:
 		// Both have private keys, so compare
-		if !bytes.Equal(sCopy.priv.Bytes(), s1Copy.priv.Bytes()) {
+		if subtle.ConstantTimeCompare(sCopy.priv.Bytes(), s1Copy.priv.Bytes()) != 1 {
 			return false
 		}
 	case sCopy.priv == nil && s1Copy.priv == nil:
@@ -120,9 +131,22 @@ func (s *ECDHSession) Equal(other Session) bool {
 	sCopy.priv = nil
 	s1Copy.priv = nil
 
+	// Compare session keys in constant time
+	if !sCopy.SessionCrypter.Equal(s1Copy.SessionCrypter) {
+		return false
+	}
+	sCopy.SessionCrypter = SessionCrypter{}
+	s1Copy.SessionCrypter = SessionCrypter{}
+
 	return reflect.DeepEqual(sCopy, s1Copy)
 }
 
+// SetKeyProvider causes the session to generate its ephemeral key and compute
+// the shared secret with a hardware-backed KeyProvider. It must be called
+// before Parameter. Sessions using a KeyProvider cannot be persisted after
+// Parameter is called until the key exchange completes.
+func (s *ECDHSession) SetKeyProvider(p KeyProvider) { s.provider = p }
+
 // Parameter generates the exchange parameter to send to its peer. This
 // function will generate a new parameter every time it is called. This
 // method is used by both the client and server.
@@ -135,11 +159,25 @@ func (s *ECDHSession) Parameter(rand io.Reader, _ *rsa.PublicKey) ([]byte, error
 	case 48:
 		curve = ecdh.P384()
 	}
-	ecKey, err := curve.GenerateKey(rand)
-	if err != nil {
-		return nil, err
+	var ecKey ecdhKey
+	if s.provider != nil {
+		pub, err := s.provider.GenerateKey(rand, curve)
+		if err != nil {
+			return nil, fmt.Errorf("error generating key with key provider: %w", err)
+		}
+		if pub.Curve() != curve {
+			return nil, fmt.Errorf("key provider generated a key on the wrong curve")
+		}
+		s.providerKey = &providerKey{KeyProvider: s.provider, pub: pub}
+		ecKey = s.providerKey
+	} else {
+		priv, err := curve.GenerateKey(rand)
+		if err != nil {
+			return nil, err
+		}
+		s.priv = priv
+		ecKey = priv
 	}
-	s.priv = ecKey
 
 	// Generate random bytes for a length that is curve-dependent
 	r := make([]byte, s.randSize)
@@ -164,7 +202,7 @@ func (s *ECDHSession) Parameter(rand io.Reader, _ *rsa.PublicKey) ([]byte, error
 	// Compute session key
 	defer clear(s.xB)
 	defer clear(s.xA)
-	defer func() { s.priv = nil }() // No API to zero private component
+	defer func() { s.priv, s.providerKey = nil, nil }() // No API to zero private component
 	sek, svk, err := ecdhSymmetricKey(ecKey, s.xA, s.xB, s.Cipher)
 	if err != nil {
 		return nil, fmt.Errorf("error computing symmetric keys: %w", err)
@@ -179,11 +217,21 @@ func (s *ECDHSession) Parameter(rand io.Reader, _ *rsa.PublicKey) ([]byte, error
 func (s *ECDHSession) SetParameter(xB []byte, _ *rsa.PrivateKey) error {
 	s.xB = xB
 
+	var key ecdhKey
+	switch {
+	case s.providerKey != nil:
+		key = s.providerKey
+	case s.priv != nil:
+		key = s.priv
+	default:
+		return fmt.Errorf("parameter must be generated before setting the peer parameter")
+	}
+
 	// Compute session key
 	defer clear(s.xB)
 	defer clear(s.xA)
-	defer func() { s.priv = nil }() // No API to zero private component
-	sek, svk, err := ecdhSymmetricKey(s.priv, s.xA, s.xB, s.Cipher)
+	defer func() { s.priv, s.providerKey = nil, nil }() // No API to zero private component
+	sek, svk, err := ecdhSymmetricKey(key, s.xA, s.xB, s.Cipher)
 	if err != nil {
 		return fmt.Errorf("error computing symmetric keys: %w", err)
 	}
@@ -244,7 +292,7 @@ func (p *ecdhParam) UnmarshalBinary(b []byte) error {
 	return nil
 }
 
-func ecdhSymmetricKey(ecKey *ecdh.PrivateKey, xA, xB []byte, cipher CipherSuite) (sek, svk []byte, err error) {
+func ecdhSymmetricKey(key ecdhKey, xA, xB []byte, cipher CipherSuite) (sek, svk []byte, err error) {
 	// Decode parameters
 	var paramA, paramB ecdhParam
 	if err := paramA.UnmarshalBinary(xA); err != nil {
@@ -254,26 +302,51 @@ func ecdhSymmetricKey(ecKey *ecdh.PrivateKey, xA, xB []byte, cipher CipherSuite)
 		return nil, nil, fmt.Errorf("error parsing xB param: %w", err)
 	}
 
-	// Compute shared secret
-	shSe, err := ecdhSharedSecret(ecKey, paramA, paramB)
+	// Determine the peer public key
+	peer, err := ecdhPeer(key, paramA, paramB)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error computing shared secret: %w", err)
 	}
-	defer clear(shSe)
+
+	// ShSe is the ECDH shared secret combined with rand from parameters
+	suffix := append(bytes.Clone(paramB.Rand), paramA.Rand...)
+	defer clear(suffix)
 
 	// Derive a symmetric key
 	sekSize, svkSize := cipher.EncryptAlg.KeySize(), uint16(0)
 	if cipher.MacAlg != 0 {
 		svkSize = cipher.MacAlg.KeySize()
 	}
-	symKey := nistkdf.KDF(cipher.PRFHash, shSe, []byte{}, (sekSize+svkSize)*8)
+	bits := (sekSize + svkSize) * 8
+	var symKey []byte
+	if pk, ok := key.(*providerKey); ok {
+		if deriver, ok := pk.KeyProvider.(SessionKeyDeriver); ok {
+			if symKey, err = deriver.DeriveSessionKey(peer, suffix, cipher.PRFHash, bits); err != nil {
+				return nil, nil, fmt.Errorf("error deriving session keys with key provider: %w", err)
+			}
+			if len(symKey) != int(bits/8) {
+				return nil, nil, fmt.Errorf("key provider derived %d bytes, expected %d", len(symKey), bits/8)
+			}
+			return symKey[:sekSize], symKey[sekSize:], nil
+		}
+	}
+
+	shx, err := key.ECDH(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error computing shared secret: %w", err)
+	}
+	shSe := append(shx, suffix...)
+	defer clear(shSe)
+	symKey, err = nistkdf.KDF(cipher.PRFHash, shSe, []byte{}, bits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving session keys: %w", err)
+	}
 
 	return symKey[:sekSize], symKey[sekSize:], nil
 }
 
-// Compute the ECDH shared secret
-func ecdhSharedSecret(key *ecdh.PrivateKey, paramA, paramB ecdhParam) ([]byte, error) {
-	// Determine which param is "other"
+// Determine which param is "other" and parse its public key
+func ecdhPeer(key ecdhKey, paramA, paramB ecdhParam) (*ecdh.PublicKey, error) {
 	var other ecdhParam
 	switch {
 	case bytes.Equal(paramA.Pub, key.PublicKey().Bytes()):
@@ -285,19 +358,11 @@ func ecdhSharedSecret(key *ecdh.PrivateKey, paramA, paramB ecdhParam) ([]byte, e
 	}
 
 	// Create ECDH public key from parameter
-	ecdhPub, err := key.Curve().NewPublicKey(other.Pub)
+	ecdhPub, err := key.PublicKey().Curve().NewPublicKey(other.Pub)
 	if err != nil {
 		return nil, fmt.Errorf("error converting public key from param to ECDH (mismatched curves?): %w", err)
 	}
-
-	// Perform ECDH to get shared secret
-	shx, err := key.ECDH(ecdhPub)
-	if err != nil {
-		return nil, err
-	}
-
-	// Combine ECDH shared secret with rand from parameters
-	return append(append(shx, paramB.Rand...), paramA.Rand...), nil
+	return ecdhPub, nil
 }
 
 type ecdhPersist struct {
@@ -309,6 +374,10 @@ type ecdhPersist struct {
 	Cipher CipherSuiteID
 	SEK    []byte
 	SVK    []byte
+
+	// Optional for compatibility with sessions persisted before key usage
+	// was tracked
+	Usage *KeyUsage `cbor:",omitempty"`
 }
 
 // MarshalCBOR implements [cbor.Marshaler].
@@ -325,6 +394,7 @@ func (s *ECDHSession) MarshalCBOR() ([]byte, error) {
 		Cipher:   s.ID,
 		SEK:      s.SEK,
 		SVK:      s.SVK,
+		Usage:    s.Usage.persist(),
 	})
 }
 
@@ -360,6 +430,9 @@ func (s *ECDHSession) UnmarshalCBOR(data []byte) error {
 			SVK:    persist.SVK,
 		},
 	}
+	if persist.Usage != nil {
+		s.Usage = *persist.Usage
+	}
 	return nil
 }
 
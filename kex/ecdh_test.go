@@ -4,7 +4,11 @@
 package kex_test
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
 	"crypto/rand"
+	"io"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo/kex"
@@ -28,3 +32,74 @@ func TestBadECDH256Exchange(t *testing.T) {
 		t.Fatal("expected error creating parameter with wrong curve")
 	}
 }
+
+// softwareKeyProvider simulates a hardware key provider.
+type softwareKeyProvider struct {
+	priv *ecdh.PrivateKey
+}
+
+func (p *softwareKeyProvider) GenerateKey(rand io.Reader, curve ecdh.Curve) (*ecdh.PublicKey, error) {
+	priv, err := curve.GenerateKey(rand)
+	if err != nil {
+		return nil, err
+	}
+	p.priv = priv
+	return priv.PublicKey(), nil
+}
+
+func (p *softwareKeyProvider) ECDH(peer *ecdh.PublicKey) ([]byte, error) { return p.priv.ECDH(peer) }
+
+// softwareKeyDeriver simulates a hardware key provider which also performs
+// key derivation.
+type softwareKeyDeriver struct {
+	softwareKeyProvider
+	derived bool
+}
+
+func (p *softwareKeyDeriver) DeriveSessionKey(peer *ecdh.PublicKey, suffix []byte, prf crypto.Hash, bits uint16) ([]byte, error) {
+	p.derived = true
+	shx, err := p.priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	return kex.KDF(prf, append(shx, suffix...), []byte(kex.KDFLabel), []byte(kex.KDFContextPrefix), bits)
+}
+
+func TestECDHKeyProvider(t *testing.T) {
+	for _, test := range []struct {
+		Name     string
+		Provider kex.KeyProvider
+	}{
+		{Name: "ECDH only", Provider: new(softwareKeyProvider)},
+		{Name: "ECDH and KDF", Provider: new(softwareKeyDeriver)},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			serverSess := kex.ECDH384Suite.New(nil, kex.CoseAes256CbcCipher)
+			xA, err := serverSess.Parameter(rand.Reader, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientSess := kex.ECDH384Suite.New(xA, kex.CoseAes256CbcCipher)
+			clientSess.(*kex.ECDHSession).SetKeyProvider(test.Provider)
+			xB, err := clientSess.Parameter(rand.Reader, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := serverSess.SetParameter(xB, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			client, server := clientSess.(*kex.ECDHSession), serverSess.(*kex.ECDHSession)
+			if len(client.SEK) == 0 || len(client.SVK) == 0 {
+				t.Fatal("expected SEK and SVK to be derived")
+			}
+			if !bytes.Equal(client.SEK, server.SEK) || !bytes.Equal(client.SVK, server.SVK) {
+				t.Fatal("expected client and server sessions to have matching symmetric keys")
+			}
+			if deriver, ok := test.Provider.(*softwareKeyDeriver); ok && !deriver.derived {
+				t.Fatal("expected key provider to derive session keys")
+			}
+		})
+	}
+}
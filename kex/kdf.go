@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package kex
+
+import (
+	"crypto"
+
+	"github.com/fido-device-onboard/go-fdo/internal/nistkdf"
+)
+
+// Label and context prefix of the FDO key derivation. All suites in this
+// package derive SEK and SVK (or SEVK) using KDF with Label = KDFLabel and
+// Context = KDFContextPrefix || contextRand, where contextRand is empty except
+// for the ASYMKEX suites.
+const (
+	KDFLabel         = nistkdf.Label
+	KDFContextPrefix = nistkdf.ContextPrefix
+)
+
+// KDF derives bits of key material from a key exchange shared secret using the
+// NIST SP 800-108 counter mode KDF with:
+//
+//   - PRF = HMAC using prf, which is SHA-256 or SHA-384 for FDO cipher suites
+//   - r = 8
+//   - Fixed input = label || 0x00 || context || [L]_2
+//   - L = bits, encoded as a 16-bit big endian integer
+func KDF(prf crypto.Hash, shSe, label, context []byte, bits uint16) ([]byte, error) {
+	return nistkdf.CounterMode(prf, shSe, label, context, bits)
+}
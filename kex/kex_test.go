@@ -8,7 +8,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
@@ -38,6 +42,10 @@ func testSuite(suite kex.Suite) func(t *testing.T) {
 
 		testEncodeDecode(t, suite, clientSess)
 
+		if serverSess.(interface{ Equal(kex.Session) bool }).Equal(clientSess) {
+			t.Fatal("expected client and server sessions not to be equal before key exchange")
+		}
+
 		if err := serverSess.SetParameter(xB, ownerKey); err != nil {
 			t.Fatal(err)
 		}
@@ -56,6 +64,15 @@ func testSuite(suite kex.Suite) func(t *testing.T) {
 			t.Fatal("expected client and server sessions to have no SVK")
 		}
 
+		sek := hex.EncodeToString(reflect.ValueOf(serverSess).Elem().FieldByName("SEK").Bytes())
+		for _, sess := range []kex.Session{serverSess, clientSess} {
+			for _, verb := range []string{"%s", "%v", "%+v", "%#v"} {
+				if out := fmt.Sprintf(verb, sess); strings.Contains(out, sek) {
+					t.Fatalf("formatting session with %s leaked SEK: %s", verb, out)
+				}
+			}
+		}
+
 		type example struct {
 			A int
 			B []byte
@@ -113,11 +130,73 @@ func testEncodeDecode(t *testing.T, suite kex.Suite, sess kex.Session) {
 		t.Fatal(err)
 	}
 
-	if equaler, ok := sess.(interface{ Equal(kex.Session) bool }); ok {
-		if !equaler.Equal(load) {
-			t.Fatalf("session encode/decode:\nexpected %s\ngot %s", sess, load)
-		}
-	} else if !reflect.DeepEqual(sess, load) {
+	equaler, ok := sess.(interface{ Equal(kex.Session) bool })
+	if !ok {
+		t.Fatalf("%T does not implement Equal", sess)
+	}
+	if !equaler.Equal(load) {
 		t.Fatalf("session encode/decode:\nexpected %s\ngot %s", sess, load)
 	}
 }
+
+func TestKeyUsageLimits(t *testing.T) {
+	serverSess := kex.ECDH256Suite.New(nil, kex.A128GcmCipher)
+	xA, err := serverSess.Parameter(rand.Reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSess := kex.ECDH256Suite.New(xA, kex.A128GcmCipher)
+	xB, err := clientSess.Parameter(rand.Reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serverSess.SetParameter(xB, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Limit keys to three messages in total
+	serverSess.(*kex.ECDHSession).Usage.MaxMessages = 3
+	clientSess.(*kex.ECDHSession).Usage.MaxMessages = 3
+
+	exchange := func() error {
+		encrypted, err := clientSess.Encrypt(rand.Reader, "ping")
+		if err != nil {
+			return err
+		}
+		data, err := cbor.Marshal(encrypted)
+		if err != nil {
+			return err
+		}
+		_, err = serverSess.Decrypt(rand.Reader, bytes.NewReader(data))
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := exchange(); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	// Usage must survive persistence
+	testEncodeDecode(t, kex.ECDH256Suite, serverSess)
+	data, err := serverSess.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := kex.ECDH256Suite.New(nil, kex.A128GcmCipher)
+	if err := load.(encoding.BinaryUnmarshaler).UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if usage := load.(*kex.ECDHSession).Usage; usage.Messages != 3 || usage.MaxMessages != 3 {
+		t.Fatalf("unexpected usage after decoding: %+v", usage)
+	}
+
+	if _, err := clientSess.Encrypt(rand.Reader, "ping"); !errors.Is(err, kex.ErrKeyUsageLimit) {
+		t.Fatalf("expected ErrKeyUsageLimit, got %v", err)
+	}
+	serverSess.(*kex.ECDHSession).Usage.MaxMessages = 4
+	clientSess.(*kex.ECDHSession).Usage.MaxMessages = 4
+	serverSess.(*kex.ECDHSession).Usage.MaxBytes = serverSess.(*kex.ECDHSession).Usage.Bytes
+	if err := exchange(); !errors.Is(err, kex.ErrKeyUsageLimit) {
+		t.Fatalf("expected ErrKeyUsageLimit, got %v", err)
+	}
+}
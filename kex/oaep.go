@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding"
 	"fmt"
 	"io"
@@ -69,17 +70,41 @@ type OAEPSession struct {
 	SessionCrypter
 }
 
+// String implements fmt.Stringer. The device-generated random and session
+// keys are redacted.
 func (s OAEPSession) String() string {
 	return fmt.Sprintf(`OAEP[
   size  %d
   xA    %x
-  xB    %x
+  xB    %s
   %s
-]`, s.paramSize, s.xA, s.xB,
+]`, s.paramSize, s.xA, redact(s.xB),
 		strings.ReplaceAll(s.SessionCrypter.String(), "\n", "\n  "),
 	)
 }
 
+// GoString implements fmt.GoStringer, so that secrets are not printed when
+// formatting with %#v.
+func (s OAEPSession) GoString() string { return s.String() }
+
+// Equal compares two key exchange sessions. The device-generated random and
+// session keys are compared in constant time.
+func (s *OAEPSession) Equal(other Session) bool {
+	s1, ok := other.(*OAEPSession)
+	if !ok || s1 == nil {
+		return false
+	}
+
+	// Evaluate all secret comparisons unconditionally
+	xBEq := subtle.ConstantTimeCompare(s.xB, s1.xB) == 1
+	crypterEq := s.SessionCrypter.Equal(s1.SessionCrypter)
+	if !xBEq || !crypterEq {
+		return false
+	}
+
+	return s.paramSize == s1.paramSize && bytes.Equal(s.xA, s1.xA)
+}
+
 // Parameter generates the exchange parameter to send to its peer. This
 // function will generate a new parameter every time it is called. This
 // method is used by both the client and server.
@@ -147,7 +172,10 @@ func oaepSymmetricKey(deviceRandom, ownerRandom []byte, cipher CipherSuite) (sek
 	if cipher.MacAlg != 0 {
 		svkSize = cipher.MacAlg.KeySize()
 	}
-	symKey := nistkdf.KDF(cipher.PRFHash, shSe, contextRand, (sekSize+svkSize)*8)
+	symKey, err := nistkdf.KDF(cipher.PRFHash, shSe, contextRand, (sekSize+svkSize)*8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving session keys: %w", err)
+	}
 
 	return symKey[:sekSize], symKey[sekSize:], nil
 }
@@ -160,6 +188,10 @@ type oaepPersist struct {
 	Cipher CipherSuiteID
 	SEK    []byte
 	SVK    []byte
+
+	// Optional for compatibility with sessions persisted before key usage
+	// was tracked
+	Usage *KeyUsage `cbor:",omitempty"`
 }
 
 // MarshalCBOR implements [cbor.Marshaler].
@@ -172,6 +204,7 @@ func (s *OAEPSession) MarshalCBOR() ([]byte, error) {
 		Cipher: s.ID,
 		SEK:    s.SEK,
 		SVK:    s.SVK,
+		Usage:  s.Usage.persist(),
 	})
 }
 
@@ -194,6 +227,9 @@ func (s *OAEPSession) UnmarshalCBOR(data []byte) error {
 			SVK:    persist.SVK,
 		},
 	}
+	if persist.Usage != nil {
+		s.Usage = *persist.Usage
+	}
 
 	return nil
 }
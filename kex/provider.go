@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package kex
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"io"
+)
+
+// KeyProvider performs the ephemeral key operations of ECDH key exchange using
+// a key resident in hardware, such as a TPM or secure element, so that the
+// private key never exists in process memory.
+//
+// A KeyProvider is used for at most one key exchange. GenerateKey is called
+// exactly once before ECDH.
+type KeyProvider interface {
+	// GenerateKey creates a new ephemeral key on the given curve, retaining
+	// the private key in hardware, and returns its public key.
+	GenerateKey(rand io.Reader, curve ecdh.Curve) (*ecdh.PublicKey, error)
+
+	// ECDH computes the shared secret Z between the generated key and the
+	// peer's public key.
+	ECDH(peer *ecdh.PublicKey) ([]byte, error)
+}
+
+// SessionKeyDeriver may optionally be implemented by a KeyProvider to also
+// perform key derivation in hardware, so that neither Z nor ShSe exist in
+// process memory.
+//
+// DeriveSessionKey must compute the shared secret Z with the peer, form ShSe
+// as Z || suffix, and return bits of key material using [KDF] with the given
+// PRF hash, Label = [KDFLabel], and Context = [KDFContextPrefix].
+type SessionKeyDeriver interface {
+	DeriveSessionKey(peer *ecdh.PublicKey, suffix []byte, prf crypto.Hash, bits uint16) ([]byte, error)
+}
+
+// ecdhKey is implemented by both *ecdh.PrivateKey and providerKey.
+type ecdhKey interface {
+	PublicKey() *ecdh.PublicKey
+	ECDH(peer *ecdh.PublicKey) ([]byte, error)
+}
+
+// providerKey adapts a KeyProvider with a generated key to ecdhKey.
+type providerKey struct {
+	KeyProvider
+	pub *ecdh.PublicKey
+}
+
+func (k providerKey) PublicKey() *ecdh.PublicKey { return k.pub }
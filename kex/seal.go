@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package kex
+
+import (
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+)
+
+// Sealer provides envelope encryption of marshaled session state before it is
+// persisted, so that a dump of the session store does not reveal live session
+// keys. The additional data binds the sealed state to its context, such as the
+// session identifier, and must be identical when opening.
+//
+// Implementations may wrap a key management service.
+type Sealer interface {
+	Seal(state, additionalData []byte) ([]byte, error)
+	Open(sealed, additionalData []byte) ([]byte, error)
+}
+
+// AEADSealer implements Sealer using a caller-provided key and an AEAD COSE
+// encryption algorithm. Sealed state is encoded as a tagged COSE_Encrypt0.
+type AEADSealer struct {
+	Alg cose.EncryptAlgorithm
+	Key []byte
+}
+
+var _ Sealer = AEADSealer{}
+
+// Seal encrypts marshaled session state.
+func (s AEADSealer) Seal(state, additionalData []byte) ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	var enc0 cose.Encrypt0[[]byte, []byte]
+	if err := enc0.Encrypt(s.Alg, s.Key, state, &additionalData); err != nil {
+		return nil, fmt.Errorf("error sealing session state: %w", err)
+	}
+	return cbor.Marshal(enc0.Tag())
+}
+
+// Open decrypts sealed session state.
+func (s AEADSealer) Open(sealed, additionalData []byte) ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	var enc0 cose.Encrypt0Tag[[]byte, []byte]
+	if err := cbor.Unmarshal(sealed, &enc0); err != nil {
+		return nil, fmt.Errorf("error decoding sealed session state: %w", err)
+	}
+	state, err := enc0.Untag().Decrypt(s.Alg, s.Key, &additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("error opening session state: %w", err)
+	}
+	return *state, nil
+}
+
+func (s AEADSealer) check() error {
+	if !s.Alg.SupportsAD() {
+		return fmt.Errorf("session sealing algorithm %d must be an AEAD", s.Alg)
+	}
+	return nil
+}
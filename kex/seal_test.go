@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package kex_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+)
+
+func TestAEADSealer(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	sealer := kex.AEADSealer{Alg: cose.A256GCM, Key: key}
+
+	sess := kex.ECDH256Suite.New(nil, kex.A128GcmCipher)
+	if _, err := sess.Parameter(rand.Reader, nil); err != nil {
+		t.Fatal(err)
+	}
+	state, err := sess.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aad := []byte("session-1")
+	sealed, err := sealer.Seal(state, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, state) {
+		t.Fatal("sealed state contains plaintext state")
+	}
+
+	opened, err := sealer.Open(sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := kex.ECDH256Suite.New(nil, kex.A128GcmCipher)
+	if err := load.(encoding.BinaryUnmarshaler).UnmarshalBinary(opened); err != nil {
+		t.Fatal(err)
+	}
+	if !sess.(interface{ Equal(kex.Session) bool }).Equal(load) {
+		t.Fatalf("session seal/open:\nexpected %s\ngot %s", sess, load)
+	}
+
+	if _, err := sealer.Open(sealed, []byte("session-2")); err == nil {
+		t.Fatal("expected opening with different additional data to fail")
+	}
+	if _, err := (kex.AEADSealer{Alg: cose.A256GCM, Key: make([]byte, 32)}).Open(sealed, aad); err == nil {
+		t.Fatal("expected opening with a different key to fail")
+	}
+	if _, err := (kex.AEADSealer{Alg: cose.A256CBC, Key: make([]byte, 32)}).Seal(state, aad); err == nil {
+		t.Fatal("expected non-AEAD algorithm to be rejected")
+	}
+}
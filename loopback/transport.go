@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package loopback implements a transport which connects a client directly to
+// server responders in the same process.
+package loopback
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// Transport implements FDO message sending by passing messages directly to
+// DI, TO0, TO1, and TO2 responders in the same process, without HTTP or
+// sockets. It is intended for tests and for embedded all-in-one deployments
+// where a device onboards itself.
+//
+// Messages are still CBOR-encoded and TO2 messages are still encrypted, so
+// that responders behave exactly as they would over a network transport.
+type Transport struct {
+	// Tokens is the token service shared by all responders.
+	Tokens protocol.TokenService
+
+	// Responders for each protocol. A nil responder causes messages of its
+	// protocol to receive an error message in response.
+	DIResponder  protocol.Responder
+	TO0Responder protocol.Responder
+	TO1Responder protocol.Responder
+	TO2Responder protocol.Responder
+
+	// Tracer, if not nil, records a trace of each message handled. See
+	// protocol.Dispatcher.
+	Tracer protocol.Tracer
+
+	// Tokens returned to the client, by protocol
+	mu     sync.Mutex
+	tokens map[protocol.Protocol]string
+}
+
+// Send sends a single message and receives a single response message.
+func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.Session) (uint8, io.ReadCloser, error) {
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	default:
+	}
+
+	// Error messages use the token of the protocol where failure occurred
+	prot := protocol.Of(msgType)
+	switch errMsg := msg.(type) {
+	case protocol.ErrorMessage:
+		prot = protocol.Of(errMsg.PrevMsgType)
+	case *protocol.ErrorMessage:
+		prot = protocol.Of(errMsg.PrevMsgType)
+	}
+	if prot == protocol.UnknownProtocol || prot == protocol.AnyProtocol {
+		return 0, nil, fmt.Errorf("invalid message type: unknown protocol or error message not using protocol.ErrorMessage type")
+	}
+
+	// Encrypt if a key exchange session is provided
+	if sess != nil {
+		var err error
+		msg, err = sess.Encrypt(rand.Reader, msg)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error encrypting message %d: %w", msgType, err)
+		}
+	}
+	body, err := cbor.Marshal(msg)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error encoding message %d: %w", msgType, err)
+	}
+
+	// Pass message to responder
+	dispatcher := protocol.Dispatcher{
+		Tokens:       t.Tokens,
+		DIResponder:  t.DIResponder,
+		TO0Responder: t.TO0Responder,
+		TO1Responder: t.TO1Responder,
+		TO2Responder: t.TO2Responder,
+		Tracer:       t.Tracer,
+	}
+	resp, err := dispatcher.Dispatch(ctx, t.token(prot), msgType, bytes.NewReader(body))
+	if err != nil {
+		slog.Debug("error handling message", "msg", msgType, "error", err)
+	}
+	if resp == nil {
+		// Error messages sent by the client have no response
+		return protocol.ErrorMsgType, io.NopCloser(new(bytes.Buffer)), nil
+	}
+	if resp.Token != "" {
+		t.storeToken(prot, resp.Token)
+	}
+
+	// Return requests to back off as errors, as the HTTP transport does
+	if resp.RetryAfter > 0 {
+		var errMsg protocol.ErrorMessage
+		if err := cbor.Unmarshal(resp.Body, &errMsg); err != nil {
+			return 0, nil, fmt.Errorf("error decoding error message: %w", err)
+		}
+		errMsg.RetryAfter = resp.RetryAfter
+		return 0, nil, errMsg
+	}
+
+	// Decrypt if a key exchange session is provided for types other than error
+	respBody := resp.Body
+	if sess != nil && resp.MsgType != protocol.ErrorMsgType {
+		if respBody, err = sess.Decrypt(rand.Reader, bytes.NewReader(resp.Body)); err != nil {
+			return 0, nil, fmt.Errorf("error decrypting message %d: %w", resp.MsgType, err)
+		}
+	}
+
+	return resp.MsgType, io.NopCloser(bytes.NewReader(respBody)), nil
+}
+
+func (t *Transport) token(prot protocol.Protocol) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tokens[prot]
+}
+
+func (t *Transport) storeToken(prot protocol.Protocol, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens == nil {
+		t.tokens = make(map[protocol.Protocol]string)
+	}
+	t.tokens[prot] = token
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import "container/list"
+
+// lru is a map which evicts its least recently used entries once it grows
+// past a given size. Its zero value is empty and ready to use. It is not safe
+// for concurrent use.
+type lru[K comparable, V any] struct {
+	entries map[K]*list.Element
+	order   list.List // of *lruEntry[K, V], most recently used first
+}
+
+type lruEntry[K comparable, V any] struct {
+	key K
+	val V
+}
+
+// get returns the value of key, marking it as most recently used.
+func (c *lru[K, V]) get(key K) (val V, ok bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return val, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).val, true
+}
+
+// add sets the value of key, marking it as most recently used, and evicts
+// entries until no more than size remain.
+func (c *lru[K, V]) add(key K, val V, size int) {
+	if c.entries == nil {
+		c.entries = make(map[K]*list.Element)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry[K, V]).val = val
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, val: val})
+	}
+	for c.order.Len() > size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove deletes key, if present.
+func (c *lru[K, V]) remove(key K) {
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *lru[K, V]) len() int { return c.order.Len() }
+
+func (c *lru[K, V]) removeElement(elem *list.Element) {
+	delete(c.entries, elem.Value.(*lruEntry[K, V]).key)
+	c.order.Remove(elem)
+}
@@ -0,0 +1,406 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package mdns advertises and discovers FDO services with DNS-SD over
+// multicast DNS (RFC 6762 and 6763), so that devices on an isolated factory
+// or home network can find the manufacturer or owner service without static
+// configuration.
+//
+// Only IPv4 multicast and the records needed by DNS-SD are supported.
+// Discovered addresses are not authenticated: TO2 still verifies the owner
+// with the ownership voucher, but a DI service found by discovery should only
+// be trusted on a controlled network.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS-SD service types of FDO services
+const (
+	ServiceDI         = "_fdo-di._tcp"
+	ServiceRendezvous = "_fdo-rv._tcp"
+	ServiceOwner      = "_fdo-owner._tcp"
+)
+
+const (
+	domain = "local"
+
+	// TXT key of the transport protocol, "http" or "https"
+	protoKey = "proto="
+
+	// TTLs recommended by RFC 6762 for host and other records
+	hostTTL  = 120
+	otherTTL = 4500
+
+	// Maximum TTL of legacy unicast responses
+	legacyTTL = 10
+)
+
+// GroupAddr is the IPv4 mDNS multicast group.
+var GroupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is an FDO service to advertise.
+type Service struct {
+	// Instance is a user-friendly name of the service, unique on the
+	// network. It must not contain dots.
+	Instance string
+
+	// Type is the service type, such as ServiceOwner.
+	Type string
+
+	// Port is the TCP port of the service.
+	Port uint16
+
+	// TLS is true if the service uses HTTPS.
+	TLS bool
+
+	// Host is the host name of the service, without the .local domain. If
+	// empty, the first label of the system host name is used.
+	Host string
+
+	// Addrs are the IP addresses of the host. If empty, the addresses of all
+	// non-loopback interfaces are used.
+	Addrs []netip.Addr
+}
+
+func (s *Service) instanceName() string { return s.Instance + "." + s.Type + "." + domain }
+func (s *Service) typeName() string     { return s.Type + "." + domain }
+func (s *Service) hostName() string     { return s.Host + "." + domain }
+
+// complete validates the service and sets defaults.
+func (s *Service) complete() error {
+	if s.Instance == "" || strings.Contains(s.Instance, ".") {
+		return fmt.Errorf("invalid service instance name %q", s.Instance)
+	}
+	if s.Type == "" || s.Port == 0 {
+		return fmt.Errorf("service %q: type and port are required", s.Instance)
+	}
+	if s.Host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("error getting host name: %w", err)
+		}
+		s.Host, _, _ = strings.Cut(hostname, ".")
+	}
+	if len(s.Addrs) == 0 {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return fmt.Errorf("error getting interface addresses: %w", err)
+		}
+		for _, addr := range addrs {
+			if prefix, err := netip.ParsePrefix(addr.String()); err == nil && !prefix.Addr().IsLoopback() {
+				s.Addrs = append(s.Addrs, prefix.Addr())
+			}
+		}
+	}
+	return nil
+}
+
+// Responder answers mDNS queries for services.
+type Responder struct {
+	Services []Service
+}
+
+// Advertise announces services on the mDNS multicast group and answers
+// queries for them until the context is done.
+func Advertise(ctx context.Context, services ...Service) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, GroupAddr)
+	if err != nil {
+		return fmt.Errorf("error joining mDNS multicast group: %w", err)
+	}
+	r := &Responder{Services: slices.Clone(services)}
+	if err := r.complete(); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// Announce services, so that browsers which are already running find
+	// them
+	announcement := &message{Response: true}
+	for _, svc := range r.Services {
+		announcement.Answers = append(announcement.Answers, svc.answer(false)...)
+	}
+	if b, err := announcement.marshal(); err == nil {
+		_, _ = conn.WriteTo(b, GroupAddr)
+	}
+
+	return r.Serve(ctx, conn)
+}
+
+func (r *Responder) complete() error {
+	for i := range r.Services {
+		if err := r.Services[i].complete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// answer returns all records of the service: the PTR record, the SRV and TXT
+// records, and then the address records.
+func (s *Service) answer(legacy bool) []resource {
+	ttl := func(ttl uint32) uint32 {
+		if legacy {
+			return min(ttl, legacyTTL)
+		}
+		return ttl
+	}
+	proto := "http"
+	if s.TLS {
+		proto = "https"
+	}
+	records := []resource{
+		{Name: s.typeName(), Type: typePTR, TTL: ttl(otherTTL), Target: s.instanceName()},
+		{Name: s.instanceName(), Type: typeSRV, TTL: ttl(hostTTL), Port: s.Port, Target: s.hostName()},
+		{Name: s.instanceName(), Type: typeTXT, TTL: ttl(otherTTL), Text: []string{protoKey + proto}},
+	}
+	for _, addr := range s.Addrs {
+		typ := typeAAAA
+		if addr.Is4() {
+			typ = typeA
+		}
+		records = append(records, resource{Name: s.hostName(), Type: typ, TTL: ttl(hostTTL), Addr: addr})
+	}
+	return records
+}
+
+// Serve answers queries received on conn until the context is done, then
+// closes conn. Queries from port 5353 are answered to the multicast group and
+// others, such as from Browse, are answered directly to the sender.
+func (r *Responder) Serve(ctx context.Context, conn net.PacketConn) error {
+	if err := r.complete(); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("error reading mDNS query: %w", err)
+		}
+		var query message
+		if err := query.unmarshal(buf[:n]); err != nil || query.Response {
+			continue
+		}
+
+		udpFrom, _ := from.(*net.UDPAddr)
+		legacy := udpFrom == nil || udpFrom.Port != GroupAddr.Port
+		resp := r.respond(&query, legacy)
+		if resp == nil {
+			continue
+		}
+		b, err := resp.marshal()
+		if err != nil {
+			return err
+		}
+		to := net.Addr(GroupAddr)
+		if legacy {
+			to = from
+		}
+		_, _ = conn.WriteTo(b, to)
+	}
+}
+
+// respond returns the response to a query or nil if no service matches.
+func (r *Responder) respond(query *message, legacy bool) *message {
+	resp := &message{Response: true}
+	if legacy {
+		// Legacy unicast responses repeat the query ID and questions
+		resp.ID, resp.Questions = query.ID, query.Questions
+	}
+	for _, q := range query.Questions {
+		for i := range r.Services {
+			svc := &r.Services[i]
+			records := svc.answer(legacy)
+			var match bool
+			switch {
+			case strings.EqualFold(q.Name, svc.typeName()):
+				match = q.Type == typePTR || q.Type == typeANY
+			case strings.EqualFold(q.Name, svc.instanceName()):
+				match = q.Type == typeSRV || q.Type == typeTXT || q.Type == typeANY
+				records = records[1:]
+			case strings.EqualFold(q.Name, svc.hostName()):
+				match = q.Type == typeA || q.Type == typeAAAA || q.Type == typeANY
+				records = records[3:]
+			}
+			if !match {
+				continue
+			}
+			for _, rr := range records {
+				if !slices.ContainsFunc(resp.Answers, func(other resource) bool { return equalResource(rr, other) }) {
+					resp.Answers = append(resp.Answers, rr)
+				}
+			}
+		}
+	}
+	if len(resp.Answers) == 0 {
+		return nil
+	}
+	return resp
+}
+
+func equalResource(a, b resource) bool {
+	return a.Type == b.Type && strings.EqualFold(a.Name, b.Name) &&
+		a.Target == b.Target && a.Port == b.Port && a.Addr == b.Addr
+}
+
+// Endpoint is a discovered FDO service.
+type Endpoint struct {
+	Instance string
+	Type     string
+	Host     string
+	Port     uint16
+	TLS      bool
+	Addrs    []netip.Addr
+}
+
+// BaseURL returns the HTTP base URL of the service, preferring an IPv4
+// address, then any address, then the .local host name.
+func (e Endpoint) BaseURL() string {
+	scheme := "http://"
+	if e.TLS {
+		scheme = "https://"
+	}
+	host := e.Host
+	if i := slices.IndexFunc(e.Addrs, netip.Addr.Is4); i >= 0 {
+		host = e.Addrs[i].String()
+	} else if len(e.Addrs) > 0 {
+		host = e.Addrs[0].String()
+	}
+	return scheme + net.JoinHostPort(host, strconv.Itoa(int(e.Port)))
+}
+
+// DefaultBrowseTimeout is the time Browse waits for responses if the context
+// has no deadline.
+const DefaultBrowseTimeout = 2 * time.Second
+
+// Browser discovers services with mDNS queries.
+type Browser struct {
+	// Addr is the address to query. If nil, GroupAddr is used.
+	Addr *net.UDPAddr
+}
+
+// Browse discovers services of a type on the local network. See
+// Browser.Browse.
+func Browse(ctx context.Context, serviceType string) ([]Endpoint, error) {
+	return new(Browser).Browse(ctx, serviceType)
+}
+
+// Browse discovers services of a type, such as ServiceOwner, collecting
+// responses until the context is done or, if it has no deadline, for
+// DefaultBrowseTimeout. Endpoints are returned in order of instance name.
+func (b *Browser) Browse(ctx context.Context, serviceType string) ([]Endpoint, error) {
+	addr := b.Addr
+	if addr == nil {
+		addr = GroupAddr
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultBrowseTimeout)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mDNS socket: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	query, err := (&message{Questions: []question{{Name: serviceType + "." + domain, Type: typePTR}}}).marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(query, addr); err != nil {
+		return nil, fmt.Errorf("error sending mDNS query: %w", err)
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var records []resource
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading mDNS response: %w", err)
+		}
+		var resp message
+		if err := resp.unmarshal(buf[:n]); err != nil || !resp.Response {
+			continue
+		}
+		records = append(records, resp.Answers...)
+	}
+	return endpoints(serviceType, records), nil
+}
+
+// endpoints assembles the endpoints of a service type from records.
+func endpoints(serviceType string, records []resource) []Endpoint {
+	typeName := serviceType + "." + domain
+	var found []Endpoint
+	for _, ptr := range records {
+		if ptr.Type != typePTR || !strings.EqualFold(ptr.Name, typeName) {
+			continue
+		}
+		instance, ok := strings.CutSuffix(ptr.Target, "."+typeName)
+		if !ok || slices.ContainsFunc(found, func(e Endpoint) bool { return e.Instance == instance }) {
+			continue
+		}
+		e := Endpoint{Instance: instance, Type: serviceType}
+		for _, rr := range records {
+			if !strings.EqualFold(rr.Name, ptr.Target) {
+				continue
+			}
+			switch rr.Type {
+			case typeSRV:
+				e.Host, e.Port = rr.Target, rr.Port
+			case typeTXT:
+				e.TLS = slices.Contains(rr.Text, protoKey+"https")
+			}
+		}
+		if e.Port == 0 {
+			continue
+		}
+		for _, rr := range records {
+			if (rr.Type == typeA || rr.Type == typeAAAA) && strings.EqualFold(rr.Name, e.Host) && !slices.Contains(e.Addrs, rr.Addr) {
+				e.Addrs = append(e.Addrs, rr.Addr)
+			}
+		}
+		found = append(found, e)
+	}
+	slices.SortFunc(found, func(a, b Endpoint) int { return strings.Compare(a.Instance, b.Instance) })
+	return found
+}
+
+// DiscoverOwners returns the base URLs of owner services on the local
+// network. It may be used as fdo.OnboardOptions.DiscoverOwners.
+func DiscoverOwners(ctx context.Context) ([]string, error) {
+	found, err := Browse(ctx, ServiceOwner)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(found))
+	for i, e := range found {
+		urls[i] = e.BaseURL()
+	}
+	return urls, nil
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package mdns_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/mdns"
+)
+
+func TestBrowse(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := &mdns.Responder{Services: []mdns.Service{
+		{
+			Instance: "Factory DI",
+			Type:     mdns.ServiceDI,
+			Port:     8038,
+			Host:     "factory",
+			Addrs:    []netip.Addr{netip.MustParseAddr("192.0.2.10")},
+		},
+		{
+			Instance: "Owner B",
+			Type:     mdns.ServiceOwner,
+			Port:     8043,
+			TLS:      true,
+			Host:     "owner-b",
+			Addrs:    []netip.Addr{netip.MustParseAddr("2001:db8::2"), netip.MustParseAddr("192.0.2.12")},
+		},
+		{
+			Instance: "Owner A",
+			Type:     mdns.ServiceOwner,
+			Port:     8080,
+			Host:     "owner-a",
+			Addrs:    []netip.Addr{netip.MustParseAddr("2001:db8::1")},
+		},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = responder.Serve(ctx, conn) }()
+
+	browseCtx, browseCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer browseCancel()
+	browser := &mdns.Browser{Addr: conn.LocalAddr().(*net.UDPAddr)}
+	found, err := browser.Browse(browseCtx, mdns.ServiceOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("expected 2 owner services, got %+v", found)
+	}
+	for i, expect := range []struct {
+		instance string
+		baseURL  string
+	}{
+		{"Owner A", "http://[2001:db8::1]:8080"},
+		{"Owner B", "https://192.0.2.12:8043"},
+	} {
+		if found[i].Instance != expect.instance || found[i].BaseURL() != expect.baseURL {
+			t.Errorf("expected %s at %s, got %s at %s", expect.instance, expect.baseURL, found[i].Instance, found[i].BaseURL())
+		}
+	}
+}
+
+func TestAdvertiseInvalidService(t *testing.T) {
+	responder := &mdns.Responder{Services: []mdns.Service{{Instance: "a.b", Type: mdns.ServiceOwner, Port: 1}}}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Serve(context.Background(), conn); err == nil {
+		t.Error("expected instance name with a dot to fail")
+	}
+}
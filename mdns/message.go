@@ -0,0 +1,263 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// DNS record types used by DNS-SD
+const (
+	typeA    uint16 = 1
+	typePTR  uint16 = 12
+	typeTXT  uint16 = 16
+	typeAAAA uint16 = 28
+	typeSRV  uint16 = 33
+	typeANY  uint16 = 255
+
+	classIN uint16 = 1
+
+	// The top bit of the class is the unicast-response bit of questions and
+	// the cache-flush bit of records
+	classMask uint16 = 0x7fff
+
+	flagResponse uint16 = 0x8400 // QR and AA
+)
+
+var errTruncated = errors.New("truncated DNS message")
+
+type question struct {
+	Name string
+	Type uint16
+}
+
+// resource is a DNS resource record. Only the fields of its type are used.
+type resource struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	Target string     // PTR and SRV
+	Port   uint16     // SRV
+	Text   []string   // TXT
+	Addr   netip.Addr // A and AAAA
+}
+
+// message is a DNS message. When decoding, records of the answer, authority,
+// and additional sections are all in Answers.
+type message struct {
+	ID        uint16
+	Response  bool
+	Questions []question
+	Answers   []resource
+}
+
+func (m *message) marshal() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	if m.Response {
+		binary.BigEndian.PutUint16(b[2:], flagResponse)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, classIN)
+	}
+	for _, rr := range m.Answers {
+		if b, err = appendName(b, rr.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, rr.Type)
+		b = binary.BigEndian.AppendUint16(b, classIN)
+		b = binary.BigEndian.AppendUint32(b, rr.TTL)
+
+		// Reserve the data length
+		lenOff := len(b)
+		b = append(b, 0, 0)
+		switch rr.Type {
+		case typePTR:
+			b, err = appendName(b, rr.Target)
+		case typeSRV:
+			b = append(b, 0, 0, 0, 0) // priority and weight
+			b = binary.BigEndian.AppendUint16(b, rr.Port)
+			b, err = appendName(b, rr.Target)
+		case typeTXT:
+			b, err = appendText(b, rr.Text)
+		case typeA, typeAAAA:
+			b = append(b, rr.Addr.AsSlice()...)
+		default:
+			err = fmt.Errorf("unsupported record type %d", rr.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint16(b[lenOff:], uint16(len(b)-lenOff-2))
+	}
+	return b, nil
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func appendText(b []byte, text []string) ([]byte, error) {
+	if len(text) == 0 {
+		return append(b, 0), nil
+	}
+	for _, s := range text {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("TXT string too long: %q", s)
+		}
+		b = append(b, byte(len(s)))
+		b = append(b, s...)
+	}
+	return b, nil
+}
+
+func (m *message) unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return errTruncated
+	}
+	m.ID = binary.BigEndian.Uint16(b[0:])
+	m.Response = binary.BigEndian.Uint16(b[2:])&0x8000 != 0
+	numQuestions := int(binary.BigEndian.Uint16(b[4:]))
+	numRecords := int(binary.BigEndian.Uint16(b[6:])) +
+		int(binary.BigEndian.Uint16(b[8:])) +
+		int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	for range numQuestions {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return err
+		}
+		if off = n + 4; off > len(b) {
+			return errTruncated
+		}
+		m.Questions = append(m.Questions, question{
+			Name: name,
+			Type: binary.BigEndian.Uint16(b[n:]),
+		})
+	}
+	for range numRecords {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return err
+		}
+		if n+10 > len(b) {
+			return errTruncated
+		}
+		rr := resource{
+			Name: name,
+			Type: binary.BigEndian.Uint16(b[n:]),
+			TTL:  binary.BigEndian.Uint32(b[n+4:]),
+		}
+		class := binary.BigEndian.Uint16(b[n+2:]) & classMask
+		start, end := n+10, n+10+int(binary.BigEndian.Uint16(b[n+8:]))
+		if end > len(b) {
+			return errTruncated
+		}
+		off = end
+		if class != classIN {
+			continue
+		}
+
+		data := b[start:end]
+		switch rr.Type {
+		case typePTR:
+			rr.Target, _, err = readName(b, start)
+		case typeSRV:
+			if len(data) < 7 {
+				return errTruncated
+			}
+			rr.Port = binary.BigEndian.Uint16(data[4:])
+			rr.Target, _, err = readName(b, start+6)
+		case typeTXT:
+			rr.Text, err = readText(data)
+		case typeA, typeAAAA:
+			var ok bool
+			if rr.Addr, ok = netip.AddrFromSlice(data); !ok {
+				err = fmt.Errorf("invalid address record of %d bytes", len(data))
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.Answers = append(m.Answers, rr)
+	}
+	return nil
+}
+
+// readName reads a possibly compressed name and returns it with the offset
+// after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errTruncated
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errTruncated
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("too many DNS name compression pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case n > 63:
+			return "", 0, fmt.Errorf("invalid DNS label length %d", n)
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errTruncated
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func readText(data []byte) ([]string, error) {
+	var text []string
+	for len(data) > 0 {
+		n := int(data[0])
+		if 1+n > len(data) {
+			return nil, errTruncated
+		}
+		if n > 0 {
+			text = append(text, string(data[1:1+n]))
+		}
+		data = data[1+n:]
+	}
+	return text, nil
+}
@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package netsim simulates poor network conditions on FDO transports, for
+// testing retries, timeouts, and MTU handling of clients against the
+// conditions of edge networks, such as high latency satellite links and
+// lossy cellular connections.
+//
+// Message sizes are measured on the unencrypted CBOR encoding, so they are
+// slightly smaller than the encrypted messages sent on the wire.
+package netsim
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+)
+
+// ErrDropped is returned when a simulated failure drops a request or its
+// response.
+var ErrDropped = errors.New("netsim: simulated network failure")
+
+// ErrTruncated is returned when a request exceeds the maximum message size.
+var ErrTruncated = errors.New("netsim: message truncated")
+
+// Conditions of a simulated network. The zero value simulates a perfect
+// network.
+type Conditions struct {
+	// Latency is added to each exchange, before the request is sent.
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to Latency.
+	Jitter time.Duration
+
+	// Bandwidth limits the combined size of each request and its response,
+	// in bytes per second. Zero is unlimited.
+	Bandwidth int
+
+	// MaxMessageSize is the largest message which is passed intact, in
+	// bytes. Larger requests fail with ErrTruncated without being sent and
+	// larger responses are truncated, so that they fail to decode. Zero is
+	// unlimited.
+	MaxMessageSize int
+
+	// DropRequestRate is the probability, from 0 to 1, that a request is
+	// lost and the exchange fails with ErrDropped without reaching the
+	// server.
+	DropRequestRate float64
+
+	// DropResponseRate is the probability, from 0 to 1, that a response is
+	// lost after the server handled the request, so the exchange fails with
+	// ErrDropped even though the server state changed.
+	DropResponseRate float64
+}
+
+// Transport wraps another transport and applies simulated network conditions
+// to each exchange. It is safe for concurrent use if the wrapped transport
+// is.
+type Transport struct {
+	Transport fdo.Transport
+	Conditions
+
+	// Rand is the source of randomness for jitter and failures. If nil, a
+	// randomly seeded source is used. Set a seeded source to make failures
+	// reproducible.
+	Rand *rand.Rand
+
+	mu sync.Mutex
+}
+
+var _ fdo.Transport = (*Transport)(nil)
+
+// Send sends a message using the wrapped transport under the simulated
+// network conditions.
+func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.Session) (uint8, io.ReadCloser, error) {
+	req, err := cbor.Marshal(msg)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error encoding message %d: %w", msgType, err)
+	}
+
+	// Delay by the latency and the time to send the request
+	delay := t.Latency + t.transferTime(len(req))
+	if t.Jitter > 0 {
+		delay += time.Duration(t.float64() * float64(t.Jitter))
+	}
+	if err := sleep(ctx, delay); err != nil {
+		return 0, nil, err
+	}
+
+	if t.chance(t.DropRequestRate) {
+		return 0, nil, fmt.Errorf("%w: request %d dropped", ErrDropped, msgType)
+	}
+	if t.MaxMessageSize > 0 && len(req) > t.MaxMessageSize {
+		return 0, nil, fmt.Errorf("%w: request %d of %d bytes exceeds %d bytes",
+			ErrTruncated, msgType, len(req), t.MaxMessageSize)
+	}
+
+	respType, rc, err := t.Transport.Send(ctx, msgType, msg, sess)
+	if err != nil {
+		return 0, nil, err
+	}
+	if rc == nil {
+		return respType, rc, nil
+	}
+	defer func() { _ = rc.Close() }()
+	resp, err := io.ReadAll(rc)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error reading response to message %d: %w", msgType, err)
+	}
+
+	// Delay by the time to receive the response
+	if err := sleep(ctx, t.transferTime(len(resp))); err != nil {
+		return 0, nil, err
+	}
+
+	if t.chance(t.DropResponseRate) {
+		return 0, nil, fmt.Errorf("%w: response to message %d dropped", ErrDropped, msgType)
+	}
+	if t.MaxMessageSize > 0 && len(resp) > t.MaxMessageSize {
+		resp = resp[:t.MaxMessageSize]
+	}
+
+	return respType, io.NopCloser(bytes.NewReader(resp)), nil
+}
+
+func (t *Transport) transferTime(size int) time.Duration {
+	if t.Bandwidth <= 0 {
+		return 0
+	}
+	return time.Duration(size) * time.Second / time.Duration(t.Bandwidth)
+}
+
+func (t *Transport) chance(p float64) bool {
+	return p > 0 && t.float64() < p
+}
+
+// float64 returns a random number in [0, 1). The source of randomness is not
+// safe for concurrent use, so it is locked.
+func (t *Transport) float64() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Rand == nil {
+		t.Rand = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())) //nolint:gosec // Not used for security
+	}
+	return t.Rand.Float64()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package netsim_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/netsim"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// echoTransport responds to each message with its type incremented and its
+// body unchanged, unless a response body is set, counting the messages it
+// receives.
+type echoTransport struct {
+	received int
+	response any
+}
+
+func (t *echoTransport) Send(_ context.Context, msgType uint8, msg any, _ kex.Session) (uint8, io.ReadCloser, error) {
+	t.received++
+	if t.response != nil {
+		msg = t.response
+	}
+	body, err := cbor.Marshal(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	return msgType + 1, io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func TestTransport(t *testing.T) {
+	ctx := context.Background()
+	msg := bytes.Repeat([]byte{0x42}, 100)
+
+	t.Run("latency and bandwidth", func(t *testing.T) {
+		tr := &netsim.Transport{Transport: new(echoTransport), Conditions: netsim.Conditions{
+			Latency:   20 * time.Millisecond,
+			Bandwidth: 10_000, // 102 bytes each way takes ~20ms
+		}}
+		start := time.Now()
+		respType, rc, err := tr.Send(ctx, protocol.TO1HelloRVMsgType, msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rc.Close()
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+			t.Errorf("expected at least 40ms delay, got %s", elapsed)
+		}
+		if respType != protocol.TO1HelloRVMsgType+1 {
+			t.Errorf("unexpected response type %d", respType)
+		}
+	})
+
+	t.Run("context canceled during latency", func(t *testing.T) {
+		tr := &netsim.Transport{Transport: new(echoTransport), Conditions: netsim.Conditions{Latency: time.Hour}}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, _, err := tr.Send(ctx, protocol.TO1HelloRVMsgType, msg, nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got %v", err)
+		}
+	})
+
+	t.Run("truncation", func(t *testing.T) {
+		echo := new(echoTransport)
+		tr := &netsim.Transport{Transport: echo, Conditions: netsim.Conditions{MaxMessageSize: 50}}
+		if _, _, err := tr.Send(ctx, protocol.TO1HelloRVMsgType, msg, nil); !errors.Is(err, netsim.ErrTruncated) {
+			t.Errorf("expected truncated request error, got %v", err)
+		}
+		if echo.received != 0 {
+			t.Error("expected truncated request to not be sent")
+		}
+
+		// Responses are truncated, so they fail to decode
+		echo.response = bytes.Repeat(msg, 2)
+		_, rc, err := tr.Send(ctx, protocol.TO1HelloRVMsgType, msg[:10], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = rc.Close() }()
+		resp, _ := io.ReadAll(rc)
+		if len(resp) != 50 {
+			t.Errorf("expected response truncated to 50 bytes, got %d", len(resp))
+		}
+		var body []byte
+		if err := cbor.Unmarshal(resp, &body); err == nil {
+			t.Error("expected truncated response to fail to decode")
+		}
+	})
+
+	t.Run("dropped messages", func(t *testing.T) {
+		echo := new(echoTransport)
+		tr := &netsim.Transport{
+			Transport:  echo,
+			Conditions: netsim.Conditions{DropRequestRate: 0.3, DropResponseRate: 0.3},
+			Rand:       rand.New(rand.NewPCG(1, 2)),
+		}
+		var droppedReq, droppedResp, ok int
+		for range 1000 {
+			before := echo.received
+			_, rc, err := tr.Send(ctx, protocol.TO1HelloRVMsgType, msg, nil)
+			switch {
+			case err == nil:
+				ok++
+				_ = rc.Close()
+			case !errors.Is(err, netsim.ErrDropped):
+				t.Fatalf("unexpected error: %v", err)
+			case echo.received == before:
+				droppedReq++
+			default:
+				droppedResp++
+			}
+		}
+		// Expect about 300 dropped requests, 210 dropped responses, and 490
+		// successful exchanges
+		if droppedReq < 200 || droppedReq > 400 || droppedResp < 130 || droppedResp > 290 || ok < 390 || ok > 590 {
+			t.Errorf("unexpected distribution: %d dropped requests, %d dropped responses, %d succeeded",
+				droppedReq, droppedResp, ok)
+		}
+	})
+}
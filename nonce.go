@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultNonceTTL is how long a nonce added to a NonceStore remains valid
+// when the server does not set NonceTTL.
+const DefaultNonceTTL = 5 * time.Minute
+
+// addNonce records an issued nonce in the store, if one is set.
+func addNonce(ctx context.Context, store NonceStore, ttl time.Duration, nonce protocol.Nonce) error {
+	if store == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = DefaultNonceTTL
+	}
+	return store.AddNonce(ctx, nonce, time.Now().Add(ttl))
+}
+
+// consumeNonce validates a nonce returned by a client against the store, if
+// one is set, so that it may not be used again.
+func consumeNonce(ctx context.Context, store NonceStore, nonce protocol.Nonce) error {
+	if store == nil {
+		return nil
+	}
+	if err := store.ConsumeNonce(ctx, nonce); errors.Is(err, ErrNotFound) {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return fmt.Errorf("nonce is unknown, expired, or already used")
+	} else if err != nil {
+		return fmt.Errorf("error consuming nonce: %w", err)
+	}
+	return nil
+}
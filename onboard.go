@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultMaxRetryAfter is the longest delay a rendezvous server may ask a
+// device to back off for when OnboardOptions.MaxRetryAfter is zero.
+const DefaultMaxRetryAfter = time.Hour
+
+// OnboardOptions configures [Onboard].
+type OnboardOptions struct {
+	// NewTransport creates a transport for a rendezvous or owner service base
+	// URL, such as "https://owner.example.com:8443". It is required.
+	NewTransport func(baseURL string) Transport
+
+	// If Store is non-nil, then the replacement device credential is staged
+	// and committed to it before Onboard returns. If it is an
+	// [ActivityStore], then onboarding only runs when the credential is
+	// active, and the credential is marked inactive on success. If it is an
+	// [AttemptLogStore], then each TO1 and TO2 attempt is logged to it.
+	Store CredentialStore
+
+	// TO2Attempts is the number of times TO2 is attempted with each owner
+	// address before moving on to the next. If zero, each address is tried
+	// once.
+	TO2Attempts int
+
+	// RetryDelay is the time to wait between TO2 attempts with the same
+	// owner address.
+	RetryDelay time.Duration
+
+	// MaxRetryAfter limits how long a rendezvous server may ask the device to
+	// back off for after a failed TO1. If zero, DefaultMaxRetryAfter is used.
+	MaxRetryAfter time.Duration
+
+	// DiscoverOwners, if not nil, finds owner service base URLs without
+	// rendezvous, such as with mdns.DiscoverOwners on an isolated network.
+	// Discovered owners are tried after those from RvInfo and To1d. An error
+	// is recorded but does not stop onboarding.
+	DiscoverOwners func(context.Context) ([]string, error)
+
+	// TO2Config contains the device secrets and TO2 options. The credential
+	// passed to Onboard is used in place of Cred. HmacSha256, HmacSha384,
+	// Key, PSS, and Hooks are also used for TO1.
+	TO2Config
+}
+
+// OnboardResult describes a completed onboarding.
+type OnboardResult struct {
+	// RVBaseURL is the rendezvous server which returned To1d. It is empty if
+	// TO1 was bypassed.
+	RVBaseURL string
+
+	// To1d is the signed rendezvous blob from TO1. It is nil if TO1 was
+	// bypassed.
+	To1d *cose.Sign1[protocol.To1d, []byte]
+
+	// OwnerBaseURL is the owner service which completed TO2.
+	OwnerBaseURL string
+
+	// Credential is the replacement device credential. It is nil if the
+	// Credential Reuse Protocol was used.
+	Credential *DeviceCredential
+
+	// Reused is true if the owner service used the Credential Reuse Protocol,
+	// leaving the existing device credential in place.
+	Reused bool
+
+	// TO1Attempts and TO2Attempts count the protocol runs, including the
+	// successful ones.
+	TO1Attempts, TO2Attempts int
+
+	// Errors contains the errors of failed attempts, in order.
+	Errors []error
+}
+
+// Onboard runs all device-side steps of onboarding, starting from a device
+// credential:
+//
+//  1. The RvInfo of the credential is interpreted into directives.
+//  2. TO1 is attempted once with each rendezvous server, in order, waiting
+//     for the delay of each directive that does not succeed, or longer if a
+//     rendezvous server asked the device to back off.
+//  3. TO2 is attempted with each owner address from bypass directives, the
+//     To1d, and DiscoverOwners, up to TO2Attempts times each.
+//  4. The replacement credential is persisted to the Store, if provided, and
+//     marked inactive. If the owner reuses the credential, then it is marked
+//     inactive.
+//
+// If the Store reports that the credential is inactive, then [ErrNotActive]
+// is returned without running any protocol.
+//
+// If no attempt succeeds, the returned error joins the errors of all attempts
+// and the partial result is also returned.
+func Onboard(ctx context.Context, cred DeviceCredential, opts OnboardOptions) (*OnboardResult, error) {
+	if opts.NewTransport == nil {
+		return nil, errors.New("onboard: transport factory is required")
+	}
+	opts.Cred = cred
+	if store, ok := opts.Store.(ActivityStore); ok {
+		active, err := store.Active()
+		if err != nil {
+			return nil, fmt.Errorf("onboard: error reading device credential state: %w", err)
+		}
+		if !active {
+			return nil, ErrNotActive
+		}
+	}
+
+	var result OnboardResult
+	var to2URLs []string
+	directives := protocol.ParseDeviceRvInfo(cred.RvInfo)
+	for _, directive := range directives {
+		if !directive.Bypass {
+			continue
+		}
+		for _, url := range directive.URLs {
+			to2URLs = append(to2URLs, url.String())
+		}
+	}
+
+	// Log attempts to the store, if supported
+	attempts := newAttemptLogger(opts.Store)
+	opts.Hooks = attempts.hooks(opts.Hooks)
+
+	// Try TO1 on each address only once
+	to1Opts := &TO1Options{PSS: opts.PSS, Hooks: opts.Hooks, Clock: opts.Clock}
+	maxRetryAfter := opts.MaxRetryAfter
+	if maxRetryAfter == 0 {
+		maxRetryAfter = DefaultMaxRetryAfter
+	}
+TO1:
+	for _, directive := range directives {
+		if directive.Bypass {
+			continue
+		}
+
+		var retryAfter time.Duration
+		for _, url := range directive.URLs {
+			result.TO1Attempts++
+			start := clockNow(opts.Clock)
+			to1d, err := TO1(ctx, opts.NewTransport(url.String()), cred, opts.Key, to1Opts)
+			attempts.record(protocol.TO1Protocol, url.String(), start, err)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("TO1 with %s: %w", url, err))
+				var errMsg protocol.ErrorMessage
+				if errors.As(err, &errMsg) {
+					retryAfter = max(retryAfter, min(errMsg.RetryAfter, maxRetryAfter))
+				}
+				continue
+			}
+			result.RVBaseURL, result.To1d = url.String(), to1d
+			break TO1
+		}
+
+		if delay := max(directive.Delay, retryAfter); delay != 0 {
+			if err := sleep(ctx, delay); err != nil {
+				return &result, err
+			}
+		}
+	}
+	if result.To1d != nil {
+		for _, addr := range result.To1d.Payload.Val.RV {
+			if baseURL, ok := to2BaseURL(addr); ok {
+				to2URLs = append(to2URLs, baseURL)
+			}
+		}
+	}
+	if opts.DiscoverOwners != nil {
+		discovered, err := opts.DiscoverOwners(ctx)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("discovering owners: %w", err))
+		}
+		for _, baseURL := range discovered {
+			if !slices.Contains(to2URLs, baseURL) {
+				to2URLs = append(to2URLs, baseURL)
+			}
+		}
+	}
+	if len(to2URLs) == 0 {
+		return &result, fmt.Errorf("onboard: no owner address found: %w", errors.Join(result.Errors...))
+	}
+
+	// Try TO2 on each address, retrying up to the configured attempts
+	to2Attempts := max(opts.TO2Attempts, 1)
+	for _, baseURL := range to2URLs {
+		for i := range to2Attempts {
+			if i > 0 && opts.RetryDelay > 0 {
+				if err := sleep(ctx, opts.RetryDelay); err != nil {
+					return &result, err
+				}
+			}
+			result.TO2Attempts++
+			start := clockNow(opts.Clock)
+			newCred, err := TO2(ctx, opts.NewTransport(baseURL), result.To1d, opts.TO2Config)
+			attempts.record(protocol.TO2Protocol, baseURL, start, err)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("TO2 with %s: %w", baseURL, err))
+				if ctx.Err() != nil {
+					return &result, ctx.Err()
+				}
+				continue
+			}
+			result.OwnerBaseURL, result.Credential, result.Reused = baseURL, newCred, newCred == nil && !opts.VerifyOnly
+			if opts.VerifyOnly {
+				return &result, nil
+			}
+			return &result, persist(opts.Store, newCred)
+		}
+	}
+
+	return &result, fmt.Errorf("onboard: TO2 failed: %w", errors.Join(result.Errors...))
+}
+
+// persist stages and commits a replacement credential, if any, which marks it
+// inactive in an ActivityStore. A reused credential is marked inactive.
+func persist(store CredentialStore, cred *DeviceCredential) error {
+	if store == nil {
+		return nil
+	}
+	if cred != nil {
+		if err := store.Stage(*cred); err != nil {
+			return fmt.Errorf("onboard: error staging replacement credential: %w", err)
+		}
+		if err := store.Commit(); err != nil {
+			return fmt.Errorf("onboard: error committing replacement credential: %w", err)
+		}
+		return nil
+	}
+	if store, ok := store.(ActivityStore); ok {
+		if err := store.SetActive(false); err != nil {
+			return fmt.Errorf("onboard: error marking device credential inactive: %w", err)
+		}
+	}
+	return nil
+}
+
+// to2BaseURL converts an owner address from To1d to a base URL. Addresses
+// without a host or with a transport other than HTTP(S) are skipped.
+func to2BaseURL(addr protocol.RvTO2Addr) (string, bool) {
+	var host string
+	switch {
+	case addr.DNSAddress != nil:
+		host = *addr.DNSAddress
+	case addr.IPAddress != nil:
+		host = addr.IPAddress.String()
+	default:
+		// invalid to1d: cannot have addr with null DNS and IP addresses
+		return "", false
+	}
+
+	var scheme, port string
+	switch addr.TransportProtocol {
+	case protocol.HTTPTransport:
+		scheme, port = "http://", "80"
+	case protocol.HTTPSTransport:
+		scheme, port = "https://", "443"
+	default:
+		return "", false
+	}
+	if addr.Port != 0 {
+		port = strconv.Itoa(int(addr.Port))
+	}
+
+	return scheme + net.JoinHostPort(host, port), true
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,268 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/blob"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/loopback"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestOnboardFallback(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvVar := func(v protocol.RvVar, val any) protocol.RvInstruction {
+		data, err := cbor.Marshal(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return protocol.RvInstruction{Variable: v, Value: data}
+	}
+	cred := fdo.DeviceCredential{
+		Version: 101,
+		GUID:    protocol.GUID{1},
+		RvInfo: [][]protocol.RvInstruction{
+			{
+				rvVar(protocol.RVProtocol, uint8(protocol.RVProtHTTP)),
+				rvVar(protocol.RVDns, "rv.example.com"),
+				rvVar(protocol.RVIPAddress, net.IP{127, 0, 0, 1}),
+			},
+			{
+				{Variable: protocol.RVBypass},
+				rvVar(protocol.RVProtocol, uint8(protocol.RVProtHTTPS)),
+				rvVar(protocol.RVDns, "owner.example.com"),
+			},
+		},
+	}
+
+	var dialed []string
+	result, err := fdo.Onboard(context.Background(), cred, fdo.OnboardOptions{
+		NewTransport: func(baseURL string) fdo.Transport {
+			dialed = append(dialed, baseURL)
+			return nopTransport{}
+		},
+		TO2Attempts: 2,
+		DiscoverOwners: func(context.Context) ([]string, error) {
+			return []string{"https://owner.example.com:443", "http://192.0.2.1:8080"}, nil
+		},
+		TO2Config: fdo.TO2Config{
+			HmacSha256: hmac.New(sha256.New, []byte("secret")),
+			Key:        key,
+		},
+	})
+	if err == nil {
+		t.Fatal("expected onboarding to fail")
+	}
+
+	expectDialed := []string{
+		"http://rv.example.com:80",
+		"http://127.0.0.1:80",
+		"https://owner.example.com:443",
+		"https://owner.example.com:443",
+		"http://192.0.2.1:8080",
+		"http://192.0.2.1:8080",
+	}
+	if !slices.Equal(dialed, expectDialed) {
+		t.Errorf("expected transports for %v, got %v", expectDialed, dialed)
+	}
+	if result.TO1Attempts != 2 || result.TO2Attempts != 4 {
+		t.Errorf("expected 2 TO1 and 4 TO2 attempts, got %d and %d", result.TO1Attempts, result.TO2Attempts)
+	}
+	if len(result.Errors) != 6 {
+		t.Errorf("expected an error for each attempt, got %v", result.Errors)
+	}
+	if result.To1d != nil || result.OwnerBaseURL != "" {
+		t.Errorf("expected no successful protocol runs, got %+v", result)
+	}
+}
+
+func TestOnboardInactive(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := blob.NewFileStore(filepath.Join(t.TempDir(), "cred.bin"))
+	if err := store.Save(&blob.DeviceCredential{
+		Active:           false,
+		DeviceCredential: fdo.DeviceCredential{Version: 101, GUID: protocol.GUID{1}},
+		HmacSecret:       []byte("secret"),
+		PrivateKey:       blob.Pkcs8Key{Signer: key},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := fdo.NewClient(fdo.WithTransport(nopTransport{}), fdo.WithCredentialStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fdo.Onboard(context.Background(), client.Cred, fdo.OnboardOptions{
+		NewTransport: func(string) fdo.Transport {
+			t.Error("expected no protocol to run for inactive credential")
+			return nopTransport{}
+		},
+		Store:     store,
+		TO2Config: client.TO2Config,
+	})
+	if !errors.Is(err, fdo.ErrNotActive) {
+		t.Fatalf("expected ErrNotActive, got %v", err)
+	}
+
+	// Reactivate for onboarding on next boot
+	if err := client.SetActive(true); err != nil {
+		t.Fatal(err)
+	}
+	if active, err := client.Active(); err != nil {
+		t.Fatal(err)
+	} else if !active {
+		t.Fatal("expected credential to be active")
+	}
+}
+
+func TestOnboardRetryAfter(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO1.Backoff = func(context.Context) time.Duration { return time.Hour }
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+
+	rvHTTP, err := cbor.Marshal(uint8(protocol.RVProtHTTP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvDNS, err := cbor.Marshal("rv.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := dev.Cred
+	cred.RvInfo = [][]protocol.RvInstruction{{
+		{Variable: protocol.RVProtocol, Value: rvHTTP},
+		{Variable: protocol.RVDns, Value: rvDNS},
+	}}
+
+	// The requested delay is limited by MaxRetryAfter
+	start := time.Now()
+	result, err := fdo.Onboard(context.Background(), cred, fdo.OnboardOptions{
+		NewTransport: func(string) fdo.Transport {
+			return &loopback.Transport{Tokens: server.State, TO1Responder: server.TO1}
+		},
+		MaxRetryAfter: 100 * time.Millisecond,
+		TO2Config: fdo.TO2Config{
+			HmacSha256: dev.HmacSha256,
+			HmacSha384: dev.HmacSha384,
+			Key:        dev.Key,
+		},
+	})
+	if err == nil {
+		t.Fatal("expected onboarding to fail")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Minute {
+		t.Errorf("expected to back off for 100ms, took %s", elapsed)
+	}
+	var errMsg protocol.ErrorMessage
+	if result.TO1Attempts != 1 || !errors.As(result.Errors[0], &errMsg) || errMsg.RetryAfter != time.Hour {
+		t.Errorf("expected one TO1 attempt asked to retry after 1h, got %+v", result)
+	}
+}
+
+func TestOnboardAttemptLog(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+
+	rvHTTP, err := cbor.Marshal(uint8(protocol.RVProtHTTP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvDNS, err := cbor.Marshal("rv.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := dev.Cred
+	cred.RvInfo = [][]protocol.RvInstruction{{
+		{Variable: protocol.RVProtocol, Value: rvHTTP},
+		{Variable: protocol.RVDns, Value: rvDNS},
+	}}
+	store := blob.NewFileStore(filepath.Join(t.TempDir(), "cred.bin"))
+	if err := store.Save(&blob.DeviceCredential{
+		Active:           true,
+		DeviceCredential: cred,
+		HmacSecret:       []byte("secret"),
+		PrivateKey:       blob.Pkcs8Key{Signer: dev.Key},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	onboard := func() error {
+		_, err := fdo.Onboard(context.Background(), cred, fdo.OnboardOptions{
+			NewTransport: func(string) fdo.Transport {
+				return &loopback.Transport{
+					Tokens:       server.State,
+					TO1Responder: server.TO1,
+					TO2Responder: server.TO2,
+				}
+			},
+			Store: store,
+			TO2Config: fdo.TO2Config{
+				HmacSha256: dev.HmacSha256,
+				HmacSha384: dev.HmacSha384,
+				Key:        dev.Key,
+				Devmod: serviceinfo.Devmod{
+					Os:      runtime.GOOS,
+					Arch:    runtime.GOARCH,
+					Version: "go-fdo test",
+					Device:  "go-validation",
+					FileSep: ";",
+					Bin:     runtime.GOARCH,
+				},
+				KeyExchange: kex.ECDH256Suite,
+				CipherSuite: kex.A128GcmCipher,
+			},
+		})
+		return err
+	}
+
+	// Fail TO1 before the device is registered, then onboard
+	if err := onboard(); err == nil {
+		t.Fatal("expected onboarding of unregistered device to fail")
+	}
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if err := onboard(); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := store.Attempts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %+v", attempts)
+	}
+	if failed := attempts[0]; failed.Protocol != protocol.TO1Protocol || failed.BaseURL != "http://rv.example.com:80" ||
+		failed.ErrCode != protocol.ResourceNotFound || failed.Err == "" || failed.Timestamp == 0 {
+		t.Errorf("unexpected failed TO1 attempt: %+v", failed)
+	}
+	if to1 := attempts[1]; to1.Protocol != protocol.TO1Protocol || to1.MsgType != protocol.TO1RVRedirectMsgType || to1.Err != "" {
+		t.Errorf("unexpected TO1 attempt: %+v", to1)
+	}
+	if to2 := attempts[2]; to2.Protocol != protocol.TO2Protocol || to2.MsgType != protocol.TO2Done2MsgType || to2.Err != "" {
+		t.Errorf("unexpected TO2 attempt: %+v", to2)
+	}
+}
@@ -0,0 +1,391 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// OwnerKeyFor returns the owner key of a type with the given public key and
+// its certificate chain. If keys implements OwnerKeyRotationPersistentState,
+// then previous keys are also considered. If no key matches, an error
+// wrapping ErrNotFound is returned.
+func OwnerKeyFor(ctx context.Context, keys OwnerKeyPersistentState, keyType protocol.KeyType, pub crypto.PublicKey) (crypto.Signer, []*x509.Certificate, error) {
+	key, chain, err := keys.OwnerKey(keyType)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, nil, fmt.Errorf("error getting owner key [type=%s]: %w", keyType, err)
+	}
+	if err == nil && publicKeyEqual(key.Public(), pub) {
+		return key, chain, nil
+	}
+
+	if rotation, ok := keys.(OwnerKeyRotationPersistentState); ok {
+		previous, err := rotation.PreviousOwnerKeys(ctx, keyType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting previous owner keys [type=%s]: %w", keyType, err)
+		}
+		for _, prev := range previous {
+			if publicKeyEqual(prev.Key.Public(), pub) {
+				return prev.Key, prev.Chain, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: no owner key [type=%s] matches public key", ErrNotFound, keyType)
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// voucherOwnerKeyType returns the type of the owner key of a voucher, which
+// is the same for every entry.
+func voucherOwnerKeyType(ov *Voucher) protocol.KeyType {
+	return ov.Header.Val.ManufacturerKey.Type
+}
+
+// errTO0Required is returned by OwnerKeyRotator when it has no TO0 client.
+var errTO0Required = errors.New("TO0 client is required to register extended vouchers")
+
+// OwnerKeyRotator rotates owner keys and extends owned vouchers from previous
+// keys to the current key, so that previous keys may be retired.
+type OwnerKeyRotator struct {
+	Keys interface {
+		OwnerKeyPersistentState
+		OwnerKeyRotationPersistentState
+	}
+	Vouchers interface {
+		OwnerVoucherPersistentState
+		OwnerVoucherListPersistentState
+		OwnerVoucherLeasePersistentState
+	}
+
+	// KeyTypes are the types of keys rotated by Run.
+	KeyTypes []protocol.KeyType
+
+	// NewKey generates the next owner key of a type and optionally its
+	// certificate chain.
+	NewKey func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)
+
+	// Interval is the time between rotations by Run. Keys which have never
+	// been rotated are first rotated one interval after Run is started.
+	Interval time.Duration
+
+	// RetireAfter is how long previous keys are kept after they are rotated,
+	// allowing time for in-flight TO2 sessions to end. Keys which still own a
+	// stored voucher, such as one which failed to be extended, are kept
+	// longer. If zero, previous keys are never removed.
+	RetireAfter time.Duration
+
+	// TO0 registers the rendezvous blob of each extended voucher again, since
+	// the blob is signed by the owner key and a blob signed by a previous key
+	// fails TO2 once the voucher is extended. Its NewTransport and TO2Addrs
+	// must be set. It is required.
+	TO0 *TO0Client
+
+	// RvURLs are the rendezvous servers with which extended vouchers are
+	// registered. If empty, the rendezvous servers of the owner RV info of
+	// each voucher are used.
+	RvURLs []string
+}
+
+// Rotate makes a newly generated key the current key of a type and extends
+// all vouchers owned by previous keys to it.
+func (r *OwnerKeyRotator) Rotate(ctx context.Context, keyType protocol.KeyType) error {
+	if r.TO0 == nil {
+		return errTO0Required
+	}
+	if err := r.rotateKey(ctx, keyType); err != nil {
+		return err
+	}
+	_, err := r.ExtendVouchers(ctx)
+	return err
+}
+
+// rotateKey makes a newly generated key the current key of a type.
+func (r *OwnerKeyRotator) rotateKey(ctx context.Context, keyType protocol.KeyType) error {
+	key, chain, err := r.NewKey(keyType)
+	if err != nil {
+		return fmt.Errorf("error generating owner key [type=%s]: %w", keyType, err)
+	}
+	if err := r.Keys.RotateOwnerKey(ctx, keyType, key, chain); err != nil {
+		return fmt.Errorf("error rotating owner key [type=%s]: %w", keyType, err)
+	}
+	return nil
+}
+
+// ExtendVouchers extends every owned voucher whose owner is not the current key
+// of its type to the current key and registers its rendezvous blob again,
+// returning the number extended.
+//
+// Vouchers leased by a TO2 session are skipped, since the session relies on
+// the voucher being replaced, and are extended by the next call, which Run
+// makes every tick until none are skipped. Vouchers which fail to be extended
+// or registered, including those whose owner is neither a current nor a
+// previous key, are logged and counted in the returned error, but do not stop
+// the others from being extended.
+func (r *OwnerKeyRotator) ExtendVouchers(ctx context.Context) (int, error) {
+	extended, _, err := r.extendVouchers(ctx)
+	return extended, err
+}
+
+// extendVouchers is ExtendVouchers, also returning the number of vouchers
+// skipped because they are leased by a TO2 session.
+func (r *OwnerKeyRotator) extendVouchers(ctx context.Context) (extended, skipped int, _ error) {
+	if r.TO0 == nil {
+		return 0, 0, errTO0Required
+	}
+	guids, err := r.Vouchers.VoucherGUIDs(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error listing owned vouchers: %w", err)
+	}
+
+	var failed int
+	for _, guid := range guids {
+		if err := ctx.Err(); err != nil {
+			return extended, skipped, err
+		}
+		next, err := r.extendVoucher(ctx, guid)
+		if errors.Is(err, ErrVoucherInUse) {
+			slog.Debug("not extending voucher in use by TO2 session", "guid", guid)
+			skipped++
+			continue
+		}
+		if err != nil {
+			slog.Warn("error extending voucher to current owner key", "guid", guid, "error", err)
+			failed++
+			continue
+		}
+		if next == nil {
+			continue
+		}
+		extended++
+		if err := r.register(ctx, next); err != nil {
+			slog.Warn("error registering extended voucher", "guid", guid, "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return extended, skipped, fmt.Errorf("%d vouchers could not be extended to the current owner key and registered", failed)
+	}
+	return extended, skipped, nil
+}
+
+// register registers the rendezvous blob of an extended voucher with each
+// rendezvous server.
+func (r *OwnerKeyRotator) register(ctx context.Context, ov *Voucher) error {
+	rvURLs := r.RvURLs
+	if len(rvURLs) == 0 {
+		for _, directive := range protocol.ParseOwnerRvInfo(ov.Header.Val.RvInfo) {
+			if directive.Bypass {
+				continue
+			}
+			for _, u := range directive.URLs {
+				rvURLs = append(rvURLs, u.String())
+			}
+		}
+	}
+	if len(rvURLs) == 0 {
+		return errors.New("no rendezvous servers to register with")
+	}
+	_, err := r.TO0.RegisterAll(ctx, []protocol.GUID{ov.Header.Val.GUID}, rvURLs, len(rvURLs))
+	return err
+}
+
+// extendVoucher extends a voucher to the current owner key of its type and
+// returns the extended voucher, unless it is already owned by the current key,
+// in which case nil is returned. The voucher is only replaced if it is not
+// leased by a TO2 session and has not changed since it was read, otherwise
+// ErrVoucherInUse is returned.
+func (r *OwnerKeyRotator) extendVoucher(ctx context.Context, guid protocol.GUID) (*Voucher, error) {
+	ov, err := r.Vouchers.Voucher(ctx, guid)
+	if err != nil {
+		return nil, err
+	}
+	keyType := voucherOwnerKeyType(ov)
+	owner, err := ov.OwnerPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing owner public key: %w", err)
+	}
+
+	current, chain, err := r.Keys.OwnerKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("error getting current owner key [type=%s]: %w", keyType, err)
+	}
+	if publicKeyEqual(current.Public(), owner) {
+		return nil, nil
+	}
+	previous, _, err := OwnerKeyFor(ctx, r.Keys, keyType, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var next *Voucher
+	switch {
+	case ov.Header.Val.ManufacturerKey.Encoding == protocol.X5ChainKeyEnc && len(chain) > 0:
+		next, err = ExtendVoucher(ov, previous, chain, nil)
+	default:
+		switch pub := current.Public().(type) {
+		case *ecdsa.PublicKey:
+			next, err = ExtendVoucher(ov, previous, pub, nil)
+		case *rsa.PublicKey:
+			next, err = ExtendVoucher(ov, previous, pub, nil)
+		default:
+			err = fmt.Errorf("unsupported key type: %T", pub)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Vouchers.ReplaceUnleasedVoucher(ctx, guid, ov, next); err != nil {
+		return nil, fmt.Errorf("error storing extended voucher: %w", err)
+	}
+	return next, nil
+}
+
+// Retire removes previous keys which were rotated more than RetireAfter ago
+// and no longer own any stored voucher. A key which still owns a voucher is
+// kept, as are the keys rotated after it.
+func (r *OwnerKeyRotator) Retire(ctx context.Context) error {
+	if r.RetireAfter <= 0 {
+		return nil
+	}
+	before := time.Now().Add(-r.RetireAfter)
+
+	var owners []crypto.PublicKey
+	var listed bool
+	for _, keyType := range r.KeyTypes {
+		previous, err := r.Keys.PreviousOwnerKeys(ctx, keyType)
+		if err != nil {
+			return fmt.Errorf("error getting previous owner keys [type=%s]: %w", keyType, err)
+		}
+		if !slices.ContainsFunc(previous, func(key PreviousOwnerKey) bool { return key.Rotated.Before(before) }) {
+			continue
+		}
+
+		// List the owners of stored vouchers once, only if a key is due
+		if !listed {
+			if owners, err = r.voucherOwners(ctx); err != nil {
+				return err
+			}
+			listed = true
+		}
+
+		// Previous keys are most recently rotated first, so keep the oldest
+		// key which owns a voucher and every key after it
+		cutoff := before
+		for _, key := range previous {
+			if slices.ContainsFunc(owners, func(owner crypto.PublicKey) bool { return publicKeyEqual(key.Key.Public(), owner) }) && key.Rotated.Before(cutoff) {
+				cutoff = key.Rotated
+			}
+		}
+		if err := r.Keys.RemovePreviousOwnerKeys(ctx, keyType, cutoff); err != nil {
+			return fmt.Errorf("error removing previous owner keys [type=%s]: %w", keyType, err)
+		}
+	}
+	return nil
+}
+
+// voucherOwners returns the owner public keys of all stored vouchers.
+func (r *OwnerKeyRotator) voucherOwners(ctx context.Context) ([]crypto.PublicKey, error) {
+	guids, err := r.Vouchers.VoucherGUIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing owned vouchers: %w", err)
+	}
+	owners := make([]crypto.PublicKey, 0, len(guids))
+	for _, guid := range guids {
+		ov, err := r.Vouchers.Voucher(ctx, guid)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error getting voucher %x: %w", guid, err)
+		}
+		owner, err := ov.OwnerPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing owner public key of voucher %x: %w", guid, err)
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// Run rotates each of KeyTypes every Interval, extends vouchers to the new
+// keys, and retires previous keys until the context is done. While vouchers
+// are skipped because they are leased by TO2 sessions or fail to be extended,
+// they are extended again every tick. Errors are logged and do not stop
+// rotation.
+func (r *OwnerKeyRotator) Run(ctx context.Context) error {
+	if r.Interval <= 0 {
+		return errors.New("owner key rotation interval must be positive")
+	}
+	if r.TO0 == nil {
+		return errTO0Required
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(min(r.Interval, time.Minute))
+	defer ticker.Stop()
+	var pending bool // whether vouchers were skipped or failed and must be extended
+	for {
+		var rotated bool
+		for _, keyType := range r.KeyTypes {
+			due, err := r.rotationDue(ctx, keyType, started)
+			if err != nil {
+				slog.Warn("error checking owner key rotation", "type", keyType, "error", err)
+				continue
+			}
+			if !due {
+				continue
+			}
+			if err := r.rotateKey(ctx, keyType); err != nil {
+				slog.Warn("error rotating owner key", "type", keyType, "error", err)
+				continue
+			}
+			rotated = true
+		}
+		if rotated || pending {
+			_, skipped, err := r.extendVouchers(ctx)
+			if err != nil {
+				slog.Warn("error extending vouchers to current owner keys", "error", err)
+			}
+			pending = skipped > 0 || err != nil
+		}
+		if err := r.Retire(ctx); err != nil {
+			slog.Warn("error retiring owner keys", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// rotationDue reports whether the current key of a type is older than
+// Interval, using the time the most recent previous key was rotated, or the
+// time Run started if the key has never been rotated.
+func (r *OwnerKeyRotator) rotationDue(ctx context.Context, keyType protocol.KeyType, started time.Time) (bool, error) {
+	previous, err := r.Keys.PreviousOwnerKeys(ctx, keyType)
+	if err != nil {
+		return false, err
+	}
+	since := started
+	if len(previous) > 0 {
+		since = previous[0].Rotated
+	}
+	return time.Since(since) >= r.Interval, nil
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ManifestExt is the extension of the optional manifest file of a plugin
+// executable, which is named by appending it to the name of the executable.
+const ManifestExt = ".json"
+
+// Manifest describes how to run a plugin executable. All fields are optional.
+type Manifest struct {
+	// Name is the service info module name of the plugin. If empty, the
+	// plugin is started and asked for its name with the Module Name command.
+	Name string `json:"name"`
+
+	// Args are passed to the executable.
+	Args []string `json:"args"`
+
+	// Env is added to the environment of the executable, in "key=value"
+	// form.
+	Env []string `json:"env"`
+}
+
+// Plugin is a plugin executable found by Discover.
+type Plugin struct {
+	// Path is the path of the executable.
+	Path string
+
+	// Sandbox, if not nil, restricts how the executable is run.
+	Sandbox *Sandbox
+
+	Manifest
+}
+
+// Command returns a new command to run the plugin.
+func (p *Plugin) Command() *exec.Cmd {
+	return p.Sandbox.Command(p.Path, p.Args, p.Env)
+}
+
+// DeviceModule returns a device module which runs the plugin.
+func (p *Plugin) DeviceModule() *DeviceModule {
+	return &DeviceModule{Module: NewCommandPluginModule(p.Command())}
+}
+
+// OwnerModule returns an owner module which runs the plugin. Owner modules
+// hold the state of a TO2 session, so a new one should be created for each
+// session.
+func (p *Plugin) OwnerModule() *OwnerModule {
+	return &OwnerModule{Module: NewCommandPluginModule(p.Command())}
+}
+
+// Discover finds all plugin executables in a directory, so that adding a
+// service info module to a deployment only requires adding a file. Hidden
+// files, subdirectories, manifests, and files which are not executable are
+// skipped. Plugins without a manifest naming their module are started once to
+// get their module name.
+//
+// Plugins are returned in the order of their file names and module names
+// must be unique. If sandbox is not nil, plugins are run in it, including to
+// get their module names.
+func Discover(dir string, sandbox *Sandbox) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plugin directory: %w", err)
+	}
+
+	var plugins []*Plugin
+	paths := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ManifestExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error reading plugin %q: %w", name, err)
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		p, err := loadPlugin(filepath.Join(dir, name), sandbox)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := paths[p.Name]; ok {
+			return nil, fmt.Errorf("plugins %q and %q both implement module %q", other, p.Path, p.Name)
+		}
+		paths[p.Name] = p.Path
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+func loadPlugin(path string, sandbox *Sandbox) (*Plugin, error) {
+	p := &Plugin{Path: path, Sandbox: sandbox}
+	manifest, err := os.ReadFile(path + ManifestExt)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("error reading manifest of plugin %q: %w", path, err)
+	default:
+		if err := json.Unmarshal(manifest, &p.Manifest); err != nil {
+			return nil, fmt.Errorf("error parsing manifest of plugin %q: %w", path, err)
+		}
+	}
+
+	if p.Name == "" {
+		name, err := ModuleName(NewCommandPluginModule(p.Command()))
+		if err != nil {
+			return nil, fmt.Errorf("error getting module name of plugin %q: %w", path, err)
+		}
+		p.Name = name
+	}
+	if p.Name == "" || strings.Contains(p.Name, ":") {
+		return nil, fmt.Errorf("plugin %q has invalid module name %q", path, p.Name)
+	}
+	return p, nil
+}
+
+// DeviceModules returns device modules for plugins, keyed by module name, to
+// be registered in fdo.TO2Config.DeviceModules.
+func DeviceModules(plugins []*Plugin) map[string]serviceinfo.DeviceModule {
+	modules := make(map[string]serviceinfo.DeviceModule, len(plugins))
+	for _, p := range plugins {
+		modules[p.Name] = p.DeviceModule()
+	}
+	return modules
+}
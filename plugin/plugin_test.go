@@ -4,7 +4,12 @@
 package plugin_test
 
 import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/fido-device-onboard/go-fdo/fdotest"
 	"github.com/fido-device-onboard/go-fdo/plugin"
@@ -24,3 +29,85 @@ func TestPluginModuleName(t *testing.T) {
 		t.Fatalf("expected %q, got %q", expectedModuleName, name)
 	}
 }
+
+func TestDiscover(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts require a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	write := func(name, contents string, perm os.FileMode) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Plugin which responds to the module name command
+	write("b-handshake", "#!/bin/sh\nread cmd\nprintf 'M%s\\n' \"$(printf mock.handshake | base64)\"\n", 0o755)
+
+	// Plugin which is named by its manifest and is never started
+	write("a-manifest", "#!/bin/sh\nexit 1\n", 0o755)
+	write("a-manifest"+plugin.ManifestExt, `{"name": "mock.manifest", "args": ["-v"], "env": ["MOCK=1"]}`, 0o644)
+
+	// Files which are not plugins
+	write("README", "not executable", 0o644)
+	write(".hidden", "#!/bin/sh\nexit 1\n", 0o755)
+
+	plugins, err := plugin.Discover(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range plugins {
+		names = append(names, p.Name)
+	}
+	if !slices.Equal(names, []string{"mock.manifest", "mock.handshake"}) {
+		t.Fatalf("unexpected plugins: %v", names)
+	}
+	if cmd := plugins[0].Command(); !slices.Equal(cmd.Args[1:], []string{"-v"}) || !slices.Contains(cmd.Env, "MOCK=1") {
+		t.Errorf("manifest not applied to command: %v %v", cmd.Args, cmd.Env)
+	}
+	if modules := plugin.DeviceModules(plugins); len(modules) != 2 || modules["mock.handshake"] == nil {
+		t.Errorf("unexpected device modules: %v", modules)
+	}
+
+	// Module names must be unique
+	write("c-duplicate", "#!/bin/sh\nexit 1\n", 0o755)
+	write("c-duplicate"+plugin.ManifestExt, `{"name": "mock.manifest"}`, 0o644)
+	if _, err := plugin.Discover(dir, nil); err == nil {
+		t.Error("expected duplicate module names to fail")
+	}
+}
+
+func TestSandboxCommand(t *testing.T) {
+	t.Setenv("PLUGIN_SECRET", "secret")
+	t.Setenv("PLUGIN_KEEP", "keep")
+
+	var nilSandbox *plugin.Sandbox
+	cmd := nilSandbox.Command("/bin/plugin", []string{"-v"}, nil)
+	if !slices.Equal(cmd.Args, []string{"/bin/plugin", "-v"}) || cmd.Env != nil {
+		t.Errorf("nil sandbox restricted command: %v %v", cmd.Args, cmd.Env)
+	}
+
+	sandbox := &plugin.Sandbox{
+		Dir:      "/var/empty",
+		ScrubEnv: true,
+		KeepEnv:  []string{"PLUGIN_KEEP"},
+		Limits:   plugin.ResourceLimits{AddressSpace: 1 << 30, CPUTime: 1500 * time.Millisecond, OpenFiles: 64},
+		Wrapper:  []string{"nsjail", "--config", "plugin.cfg", "--"},
+	}
+	cmd = sandbox.Command("/bin/plugin", []string{"-v"}, []string{"MOCK=1"})
+	if want := []string{
+		"nsjail", "--config", "plugin.cfg", "--",
+		"prlimit", "--as=1073741824", "--cpu=2", "--nofile=64", "--",
+		"/bin/plugin", "-v",
+	}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("expected args %v, got %v", want, cmd.Args)
+	}
+	if want := []string{"PLUGIN_KEEP=keep", "MOCK=1"}; !slices.Equal(cmd.Env, want) {
+		t.Errorf("expected env %v, got %v", want, cmd.Env)
+	}
+	if cmd.Dir != "/var/empty" {
+		t.Errorf("expected dir /var/empty, got %q", cmd.Dir)
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sandbox restricts how plugin executables are run. Owner services run
+// semi-trusted plugins on service info sent by devices, so a plugin should
+// run with no more access than it needs. The zero value runs plugins
+// unrestricted.
+type Sandbox struct {
+	// Dir is the working directory of the plugin. If Chroot is set, it is
+	// relative to the new root.
+	Dir string
+
+	// Chroot changes the root directory of the plugin, so that the plugin
+	// executable path is resolved within it. It requires privileges and is
+	// only supported on Unix.
+	Chroot string
+
+	// ScrubEnv runs the plugin with only the environment variables named in
+	// KeepEnv, in addition to those of its manifest, rather than inheriting
+	// the whole environment, which may contain credentials.
+	ScrubEnv bool
+	KeepEnv  []string
+
+	// Limits are applied to the plugin process with prlimit(1).
+	Limits ResourceLimits
+
+	// Wrapper is a command which is passed the plugin command as trailing
+	// arguments, such as
+	//
+	// 	systemd-run --pipe --wait --quiet -p DynamicUser=yes -p SystemCallFilter=@system-service --
+	// 	nsjail --config plugin.cfg --
+	//
+	// Wrappers apply seccomp filters, namespaces, cgroups, and credentials,
+	// which cannot be applied portably by the owner service itself.
+	Wrapper []string
+}
+
+// ResourceLimits are rlimits of a plugin process. Zero values are unlimited.
+type ResourceLimits struct {
+	// AddressSpace is the maximum size of virtual memory in bytes.
+	AddressSpace uint64
+
+	// CPUTime is the maximum processor time.
+	CPUTime time.Duration
+
+	// FileSize is the maximum size of files written in bytes.
+	FileSize uint64
+
+	// OpenFiles is the maximum number of open file descriptors.
+	OpenFiles uint64
+
+	// Processes is the maximum number of processes of the user.
+	Processes uint64
+}
+
+// args returns prlimit(1) arguments to apply the limits, if any.
+func (l ResourceLimits) args() []string {
+	if l == (ResourceLimits{}) {
+		return nil
+	}
+	args := []string{"prlimit"}
+	for _, limit := range []struct {
+		flag  string
+		value uint64
+	}{
+		{"--as", l.AddressSpace},
+		{"--cpu", uint64((l.CPUTime + time.Second - 1) / time.Second)},
+		{"--fsize", l.FileSize},
+		{"--nofile", l.OpenFiles},
+		{"--nproc", l.Processes},
+	} {
+		if limit.value > 0 {
+			args = append(args, limit.flag+"="+strconv.FormatUint(limit.value, 10))
+		}
+	}
+	return append(args, "--")
+}
+
+// Command returns a command which runs an executable in the sandbox. Env is
+// added to the environment of the executable, in "key=value" form. A nil
+// sandbox runs the executable unrestricted.
+func (s *Sandbox) Command(path string, args, env []string) *exec.Cmd {
+	if s == nil {
+		s = new(Sandbox)
+	}
+
+	argv := append(append(append(append([]string{}, s.Wrapper...), s.Limits.args()...), path), args...)
+	cmd := exec.Command(argv[0], argv[1:]...) //nolint:gosec // Plugins are trusted by the deployment
+	cmd.Dir = s.Dir
+
+	switch {
+	case s.ScrubEnv:
+		cmd.Env = append(keepEnv(s.KeepEnv), env...)
+	case len(env) > 0:
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	if s.Chroot != "" {
+		if err := chroot(cmd, s.Chroot); err != nil {
+			cmd.Err = err
+		}
+	}
+	return cmd
+}
+
+// keepEnv returns the environment variables with the given names.
+func keepEnv(names []string) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, keep := range names {
+			if name == keep {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return env
+}
@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build !unix
+
+package plugin
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func chroot(*exec.Cmd, string) error {
+	return errors.New("chroot is only supported on Unix")
+}
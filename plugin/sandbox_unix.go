@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build unix
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func chroot(cmd *exec.Cmd, root string) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Chroot = root
+	return nil
+}
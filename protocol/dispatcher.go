@@ -0,0 +1,355 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
+	"github.com/fido-device-onboard/go-fdo/kex"
+)
+
+// CryptResponder is implemented by responders whose messages are encrypted
+// after key exchange, such as *fdo.TO2Server.
+type CryptResponder interface {
+	Responder
+
+	// CryptSession returns the encryption session of the protocol session
+	// identified by the token in the context.
+	CryptSession(context.Context) (kex.Session, error)
+}
+
+// CryptSessionSaver is optionally implemented by a CryptResponder to persist
+// key usage of the encryption session after each message is encrypted or
+// decrypted.
+type CryptSessionSaver interface {
+	SaveCryptSession(context.Context, kex.Session) error
+}
+
+// SessionEnder is optionally implemented by responders which track in-flight
+// sessions, such as *fdo.TO2Server. EndSession is called when a device ends a
+// session by sending an error message, since such messages are not passed to
+// Respond.
+type SessionEnder interface {
+	EndSession(context.Context)
+}
+
+// Shutdowner is optionally implemented by responders which can stop accepting
+// new sessions and wait for in-flight sessions to end, such as
+// *fdo.TO2Server.
+type Shutdowner interface {
+	Shutdown(context.Context) error
+}
+
+// Response is an encoded response message to be sent by a transport.
+type Response struct {
+	// Token to return to the device for use with its next message. It is
+	// empty if the protocol session could not be established.
+	Token string
+
+	// MsgType is the type of the response message.
+	MsgType uint8
+
+	// Body is the CBOR-encoded response message, encrypted if required by
+	// the protocol.
+	Body []byte
+
+	// RetryAfter is the delay requested by the responder before the device
+	// tries the protocol again, taken from the error message. Transports
+	// should send it alongside the body, since it is not encoded.
+	RetryAfter time.Duration
+}
+
+// Dispatcher implements the transport-independent parts of an FDO server for
+// all DI, TO0, TO1, and TO2 message types. It routes each request to the
+// Responder of its protocol and produces an encoded response, handling
+// tokens, TO2 message encryption, and error messages. This allows transports
+// other than HTTP, such as MQTT or a serial link, to be implemented by only
+// carrying a token, message type, and message body in each direction.
+type Dispatcher struct {
+	Tokens TokenService
+
+	DIResponder  Responder
+	TO0Responder Responder
+	TO1Responder Responder
+	TO2Responder Responder
+
+	// Tracer, if not nil, records each message handled, and error messages
+	// sent to devices include a correlation ID to find the trace of their
+	// session.
+	Tracer Tracer
+}
+
+// Dispatch handles a request message. The token is the value returned in the
+// previous response of the protocol session, or empty for the first message.
+//
+// If the request could not be handled by a responder, then the returned
+// response is an error message and the error describes the failure. Error
+// messages created by responders are returned with a nil error. A nil
+// response and nil error are returned for error messages sent by the device,
+// which must not be responded to.
+func (d *Dispatcher) Dispatch(ctx context.Context, token string, msgType uint8, msg io.Reader) (*Response, error) {
+	if d.Tracer == nil {
+		return d.dispatch(ctx, token, msgType, msg)
+	}
+
+	start := time.Now()
+	t := new(trace)
+	req := &countingReader{r: msg}
+	resp, err := d.dispatch(context.WithValue(ctx, traceKey{}, t), token, msgType, req)
+
+	event := TraceEvent{
+		CorrelationID: t.correlationID(),
+		GUID:          t.guid,
+		Time:          start,
+		Duration:      time.Since(start),
+		MsgType:       msgType,
+		RequestSize:   req.n,
+		Err:           t.err,
+		Summary:       t.summary,
+	}
+	if resp != nil {
+		event.RespType, event.ResponseSize = resp.MsgType, len(resp.Body)
+	}
+	if event.Err == "" && err != nil {
+		event.Err = err.Error()
+	}
+	if err := d.Tracer.Trace(ctx, event); err != nil {
+		slog.Warn("error recording protocol trace", "msg", msgType, "error", err)
+	}
+	return resp, err
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, token string, msgType uint8, msg io.Reader) (*Response, error) {
+	ctx = d.Tokens.TokenContext(ctx, token)
+	if token != "" {
+		traceSession(ctx, d.Tokens)
+	}
+
+	// Get responder for message
+	var resp Responder
+	var isProtocolStart bool
+	proto := Of(msgType)
+	switch proto {
+	case DIProtocol:
+		resp = d.DIResponder
+		isProtocolStart = msgType == DIAppStartMsgType
+	case TO0Protocol:
+		resp = d.TO0Responder
+		isProtocolStart = msgType == TO0HelloMsgType
+	case TO1Protocol:
+		resp = d.TO1Responder
+		isProtocolStart = msgType == TO1HelloRVMsgType
+	case TO2Protocol:
+		resp = d.TO2Responder
+		isProtocolStart = msgType == TO2HelloDeviceMsgType
+	case AnyProtocol:
+		// Release session state for an error sent by the device
+		traceDeviceError(ctx, msg)
+		if token == "" {
+			return nil, nil
+		}
+		if ender, ok := d.TO2Responder.(SessionEnder); ok {
+			ender.EndSession(ctx)
+		}
+		if err := d.Tokens.InvalidateToken(ctx); err != nil {
+			slog.Warn("invalidating token", "error", err)
+		}
+		return nil, nil
+	}
+	if resp == nil {
+		return d.errorResponse(ctx, msgType, errors.New("unsupported message type"))
+	}
+
+	// Serialize handling of messages continuing a session across replicas
+	if locker, ok := d.Tokens.(SessionLocker); ok && token != "" && !isProtocolStart {
+		unlock, err := locker.LockSession(ctx)
+		if err != nil {
+			return d.errorResponse(ctx, msgType, fmt.Errorf("error locking session: %w", err))
+		}
+		defer unlock()
+	}
+
+	// Inject token state into context to keep method signatures clean while
+	// allowing some implementations to mutate tokens on every message.
+	if isProtocolStart {
+		initToken, err := d.Tokens.NewToken(ctx, proto)
+		if err != nil {
+			return d.errorResponse(ctx, msgType, err)
+		}
+		ctx = d.Tokens.TokenContext(ctx, initToken)
+		traceSession(ctx, d.Tokens)
+	}
+
+	// Decrypt TO2 messages after 64
+	if TO2ProveDeviceMsgType < msgType && msgType < ErrorMsgType {
+		decrypted, err := decrypt(ctx, resp, msgType, msg)
+		if err != nil {
+			return d.errorResponse(ctx, msgType, err)
+		}
+		if debugEnabled() {
+			slog.Debug("decrypted request", "msg", msgType, "body", tryDebugNotation(decrypted))
+		}
+		msg = bytes.NewReader(decrypted)
+	}
+
+	// Perform business logic of message handling
+	respType, respData := resp.Respond(ctx, msgType, msg)
+	respData = traceResponse(ctx, respType, respData)
+	if respType == ErrorMsgType {
+		if err := d.Tokens.InvalidateToken(ctx); err != nil {
+			slog.Warn("error invalidating token", "error", err)
+		}
+	}
+
+	// Encrypt TO2 messages beginning with 64
+	if TO2ProveDeviceMsgType < respType && respType < ErrorMsgType {
+		if debugEnabled() {
+			body, _ := cbor.Marshal(respData)
+			slog.Debug("unencrypted response", "msg", respType, "body", tryDebugNotation(body))
+		}
+		encrypted, err := encrypt(ctx, resp, respType, respData)
+		if err != nil {
+			return d.errorResponse(ctx, msgType, err)
+		}
+		respData = encrypted
+	}
+
+	// Invalidate token when finishing a protocol or erroring
+	newToken, _ := d.Tokens.TokenFromContext(ctx)
+	switch respType {
+	case DIDoneMsgType, TO1RVRedirectMsgType, TO2Done2MsgType, ErrorMsgType:
+		if newToken != "" {
+			ctx := d.Tokens.TokenContext(ctx, newToken)
+			if err := d.Tokens.InvalidateToken(ctx); err != nil {
+				slog.Warn("invalidating token", "error", err)
+			}
+		}
+	}
+
+	body, err := cbor.Marshal(respData)
+	if err != nil {
+		return d.errorResponse(ctx, msgType, fmt.Errorf("error marshaling response message %d: %w", respType, err))
+	}
+	return &Response{Token: newToken, MsgType: respType, Body: body, RetryAfter: retryAfter(respData)}, nil
+}
+
+// retryAfter returns the delay requested by an error message response.
+func retryAfter(respData any) time.Duration {
+	switch msg := respData.(type) {
+	case ErrorMessage:
+		return msg.RetryAfter
+	case *ErrorMessage:
+		return msg.RetryAfter
+	default:
+		return 0
+	}
+}
+
+// Shutdown gracefully shuts down each responder which implements Shutdowner,
+// waiting for all of them to return. Messages must continue to be dispatched
+// until Shutdown returns, so that in-flight sessions can end.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	var shutdowners []Shutdowner
+	for _, resp := range []Responder{d.DIResponder, d.TO0Responder, d.TO1Responder, d.TO2Responder} {
+		if s, ok := resp.(Shutdowner); ok {
+			shutdowners = append(shutdowners, s)
+		}
+	}
+
+	errs := make([]error, len(shutdowners))
+	var wg sync.WaitGroup
+	for i, s := range shutdowners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// errorResponse creates an encoded error message response.
+func (d *Dispatcher) errorResponse(ctx context.Context, prevMsgType uint8, err error) (*Response, error) {
+	body, _ := cbor.Marshal(traceResponse(ctx, ErrorMsgType, NewErrorMessage(prevMsgType, err)))
+	return &Response{MsgType: ErrorMsgType, Body: body}, err
+}
+
+func cryptSession(ctx context.Context, resp Responder) (kex.Session, error) {
+	crypt, ok := resp.(CryptResponder)
+	if !ok {
+		return nil, errors.New("responder does not support encrypted messages")
+	}
+	return crypt.CryptSession(ctx)
+}
+
+func decrypt(ctx context.Context, resp Responder, msgType uint8, msg io.Reader) ([]byte, error) {
+	sess, err := cryptSession(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Destroy()
+
+	decrypted, err := sess.Decrypt(rand.Reader, msg)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting message %d: %w", msgType, err)
+	}
+	if err := saveCryptSession(ctx, resp, sess); err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+func encrypt(ctx context.Context, resp Responder, respType uint8, respData any) (any, error) {
+	sess, err := cryptSession(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Destroy()
+
+	encrypted, err := sess.Encrypt(rand.Reader, respData)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting message %d: %w", respType, err)
+	}
+	if err := saveCryptSession(ctx, resp, sess); err != nil {
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// saveCryptSession persists key usage of the encryption session, if supported
+// by the responder.
+func saveCryptSession(ctx context.Context, resp Responder, sess kex.Session) error {
+	saver, ok := resp.(CryptSessionSaver)
+	if !ok {
+		return nil
+	}
+	if err := saver.SaveCryptSession(ctx, sess); err != nil {
+		return fmt.Errorf("error saving encryption session: %w", err)
+	}
+	return nil
+}
+
+func debugEnabled() bool {
+	return slog.Default().Enabled(context.Background(), slog.LevelDebug)
+}
+
+func tryDebugNotation(b []byte) string {
+	d, err := cdn.FromCBOR(b)
+	if err != nil {
+		return hex.EncodeToString(b)
+	}
+	return d
+}
@@ -4,6 +4,7 @@
 package protocol
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
@@ -139,15 +140,40 @@ type ErrorMessage struct {
 	ErrString     string
 	Timestamp     int64 // Timestamp once the Java implementation is fixed
 	CorrelationID *uint
+
+	// RetryAfter, if positive, is a delay requested by the server before the
+	// device tries the protocol again. It is not part of the encoded message,
+	// but is carried by the transport, such as in the Retry-After header of
+	// an HTTP 503 response.
+	RetryAfter time.Duration `cbor:"-"`
 }
 
 // String implements Stringer.
 func (e ErrorMessage) String() string {
+	var id uint
+	if e.CorrelationID != nil {
+		id = *e.CorrelationID
+	}
 	return fmt.Sprintf("%s [code=%d,prevMsgType=%d,id=%d] %s",
 		time.Unix(e.Timestamp, 0),
-		e.Code, e.PrevMsgType, e.CorrelationID, e.ErrString,
+		e.Code, e.PrevMsgType, id, e.ErrString,
 	)
 }
 
 // Error implements the standard error interface.
 func (e ErrorMessage) Error() string { return e.String() }
+
+// NewErrorMessage creates an error message in response to a message of
+// prevMsgType which could not be processed. If err is or wraps an
+// ErrorMessage, then it is used. Otherwise, InternalServerErrCode is used
+// with a structured error string describing err.
+func NewErrorMessage(prevMsgType uint8, err error) ErrorMessage {
+	var msg ErrorMessage
+	if !errors.As(err, &msg) {
+		msg.Code = InternalServerErrCode
+		msg.PrevMsgType = prevMsgType
+		msg.ErrString = NewErrorDetail(msg.Code, prevMsgType, err).String()
+		msg.Timestamp = time.Now().Unix()
+	}
+	return msg
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrorID identifies an entry of the error catalog. IDs are stable, so that
+// receivers of error messages may aggregate errors and localize them with
+// their own templates.
+type ErrorID string
+
+// Error IDs of the catalog. Each error code has a corresponding ID, which is
+// used when an error is not otherwise identified.
+const (
+	InvalidJwtTokenErrID         ErrorID = "invalid-token"
+	InvalidOwnershipVoucherErrID ErrorID = "invalid-voucher"
+	InvalidOwnerSignBodyErrID    ErrorID = "invalid-owner-sign"
+	InvalidIPAddrErrID           ErrorID = "invalid-ip-addr"
+	InvalidGUIDErrID             ErrorID = "invalid-guid"
+	ResourceNotFoundErrID        ErrorID = "resource-not-found"
+	MessageBodyErrID             ErrorID = "message-body"
+	InvalidMessageErrID          ErrorID = "invalid-message"
+	CredReuseErrID               ErrorID = "cred-reuse"
+	InternalServerErrID          ErrorID = "internal"
+
+	// BusyErrID is used when a server sheds load and asks the device to try
+	// again later.
+	BusyErrID ErrorID = "busy"
+)
+
+// MsgTypeParam is the parameter of an ErrorDetail holding the decimal message
+// type which could not be processed.
+const MsgTypeParam = "msgType"
+
+// errorTemplates are the English templates of the catalog. Parameters are
+// written as {name}.
+var errorTemplates = map[ErrorID]string{
+	InvalidJwtTokenErrID:         "session token of message {msgType} is missing or invalid",
+	InvalidOwnershipVoucherErrID: "ownership voucher is invalid",
+	InvalidOwnerSignBodyErrID:    "owner signature did not verify",
+	InvalidIPAddrErrID:           "IP address is invalid",
+	InvalidGUIDErrID:             "GUID is invalid",
+	ResourceNotFoundErrID:        "resource for message {msgType} was not found",
+	MessageBodyErrID:             "message {msgType} could not be decoded",
+	InvalidMessageErrID:          "message {msgType} failed validation",
+	CredReuseErrID:               "credential reuse rejected",
+	InternalServerErrID:          "internal error processing message {msgType}",
+	BusyErrID:                    "server is busy, try again later",
+}
+
+var codeErrorIDs = map[uint16]ErrorID{
+	InvalidJwtTokenCode:         InvalidJwtTokenErrID,
+	InvalidOwnershipVoucherCode: InvalidOwnershipVoucherErrID,
+	InvalidOwnerSignBodyCode:    InvalidOwnerSignBodyErrID,
+	InvalidIPAddrCode:           InvalidIPAddrErrID,
+	InvalidGUID:                 InvalidGUIDErrID,
+	ResourceNotFound:            ResourceNotFoundErrID,
+	MessageBodyErrCode:          MessageBodyErrID,
+	InvalidMessageErrCode:       InvalidMessageErrID,
+	CredReuseErrCode:            CredReuseErrID,
+	InternalServerErrCode:       InternalServerErrID,
+}
+
+// ErrorTemplate returns the English template of an error ID. Unknown IDs
+// return the ID itself.
+func ErrorTemplate(id ErrorID) string {
+	if tmpl, ok := errorTemplates[id]; ok {
+		return tmpl
+	}
+	return string(id)
+}
+
+// CodeErrorID returns the catalog ID of an error code. Unknown codes return
+// InternalServerErrID.
+func CodeErrorID(code uint16) ErrorID {
+	if id, ok := codeErrorIDs[code]; ok {
+		return id
+	}
+	return InternalServerErrID
+}
+
+// ErrorDetail is a structured error string. It is encoded as the EMErrorStr of
+// an ErrorMessage so that receivers do not need to match free text:
+//
+//	[message-body msgType="60"] error decoding TO2.HelloDevice: unexpected EOF
+//
+// The bracketed part holds the catalog ID and its parameters. The remainder,
+// if any, is the cause, which is not localized.
+type ErrorDetail struct {
+	ID     ErrorID
+	Params map[string]string
+	Cause  string
+}
+
+// NewErrorDetail creates the detail of an error with the given code in
+// response to a message of prevMsgType.
+func NewErrorDetail(code uint16, prevMsgType uint8, err error) ErrorDetail {
+	detail := ErrorDetail{
+		ID:     CodeErrorID(code),
+		Params: map[string]string{MsgTypeParam: strconv.Itoa(int(prevMsgType))},
+	}
+	if err != nil {
+		detail.Cause = err.Error()
+	}
+	return detail
+}
+
+// Format renders the detail with a template, such as a translation of
+// ErrorTemplate. Parameters missing from the template are ignored and
+// placeholders without a parameter are left as is. The cause, if any, is
+// appended.
+func (d ErrorDetail) Format(template string) string {
+	var oldnew []string
+	for _, name := range slices.Sorted(maps.Keys(d.Params)) {
+		oldnew = append(oldnew, "{"+name+"}", d.Params[name])
+	}
+	text := strings.NewReplacer(oldnew...).Replace(template)
+	if d.Cause != "" {
+		text += ": " + d.Cause
+	}
+	return text
+}
+
+// Text renders the detail with its English template.
+func (d ErrorDetail) Text() string { return d.Format(ErrorTemplate(d.ID)) }
+
+// String encodes the detail as an error string.
+func (d ErrorDetail) String() string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	sb.WriteString(string(d.ID))
+	for _, name := range slices.Sorted(maps.Keys(d.Params)) {
+		sb.WriteString(" ")
+		sb.WriteString(name)
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(d.Params[name]))
+	}
+	sb.WriteString("]")
+	if d.Cause != "" {
+		sb.WriteString(" ")
+		sb.WriteString(d.Cause)
+	}
+	return sb.String()
+}
+
+var errNotDetail = errors.New("not a structured error string")
+
+// ParseErrorDetail decodes an error string encoded by ErrorDetail.String. It
+// fails for free text error strings, such as those sent by other
+// implementations.
+func ParseErrorDetail(s string) (ErrorDetail, error) {
+	rest, ok := strings.CutPrefix(s, "[")
+	if !ok {
+		return ErrorDetail{}, errNotDetail
+	}
+	end := strings.IndexAny(rest, " ]")
+	if end < 1 {
+		return ErrorDetail{}, errNotDetail
+	}
+	detail := ErrorDetail{ID: ErrorID(rest[:end])}
+	rest = rest[end:]
+
+	for strings.HasPrefix(rest, " ") {
+		name, value, ok := strings.Cut(rest[1:], "=")
+		if !ok || !validParamName(name) {
+			return ErrorDetail{}, fmt.Errorf("invalid parameter in error string %q", s)
+		}
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return ErrorDetail{}, fmt.Errorf("invalid value of parameter %q: %w", name, err)
+		}
+		if detail.Params == nil {
+			detail.Params = make(map[string]string)
+		}
+		detail.Params[name], _ = strconv.Unquote(quoted)
+		rest = value[len(quoted):]
+	}
+
+	rest, ok = strings.CutPrefix(rest, "]")
+	if !ok {
+		return ErrorDetail{}, fmt.Errorf("unterminated error string %q", s)
+	}
+	detail.Cause = strings.TrimPrefix(rest, " ")
+	return detail, nil
+}
+
+func validParamName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " =[]\"")
+}
+
+// Detail decodes the structured error string of the message, if it has one.
+func (e ErrorMessage) Detail() (ErrorDetail, error) { return ParseErrorDetail(e.ErrString) }
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestErrorDetail(t *testing.T) {
+	msg := protocol.NewErrorMessage(protocol.TO2HelloDeviceMsgType, errors.New(`bad "nonce"] here`))
+	const expect = `[internal msgType="60"] bad "nonce"] here`
+	if msg.ErrString != expect {
+		t.Fatalf("expected error string %q, got %q", expect, msg.ErrString)
+	}
+
+	detail, err := msg.Detail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := protocol.ErrorDetail{
+		ID:     protocol.InternalServerErrID,
+		Params: map[string]string{protocol.MsgTypeParam: "60"},
+		Cause:  `bad "nonce"] here`,
+	}
+	if !reflect.DeepEqual(detail, want) {
+		t.Fatalf("expected %+v, got %+v", want, detail)
+	}
+	if got := detail.Text(); got != `internal error processing message 60: bad "nonce"] here` {
+		t.Errorf("unexpected text %q", got)
+	}
+	if got := detail.Format("Interner Fehler bei Nachricht {msgType}"); got != `Interner Fehler bei Nachricht 60: bad "nonce"] here` {
+		t.Errorf("unexpected localized text %q", got)
+	}
+
+	for _, s := range []string{
+		"invalid signature",
+		"[busy",
+		`[busy msgType=60]`,
+		`[busy msgType="60"`,
+	} {
+		if _, err := protocol.ParseErrorDetail(s); err == nil {
+			t.Errorf("expected %q not to parse", s)
+		}
+	}
+
+	detail, err = protocol.ParseErrorDetail(protocol.ErrorDetail{ID: protocol.BusyErrID}.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.ID != protocol.BusyErrID || detail.Params != nil || detail.Cause != "" {
+		t.Errorf("unexpected busy detail %+v", detail)
+	}
+}
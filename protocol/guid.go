@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// String returns the GUID in the canonical RFC 4122 text form, i.e.
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx in lowercase hex.
+func (g GUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], g[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], g[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], g[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], g[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], g[10:])
+	return string(buf[:])
+}
+
+// Format implements fmt.Formatter so that the %x and %X verbs continue to
+// format the GUID as unseparated hex, while %s and %v use the canonical text
+// form.
+func (g GUID) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'x', 'X':
+		_, _ = fmt.Fprintf(f, fmt.FormatString(f, verb), g[:])
+	case 'v':
+		if f.Flag('#') {
+			_, _ = fmt.Fprintf(f, "protocol.GUID(%#v)", [16]byte(g))
+			return
+		}
+		_, _ = fmt.Fprintf(f, fmt.FormatString(f, verb), g.String())
+	case 's', 'q':
+		_, _ = fmt.Fprintf(f, fmt.FormatString(f, verb), g.String())
+	default:
+		_, _ = fmt.Fprintf(f, fmt.FormatString(f, verb), [16]byte(g))
+	}
+}
+
+// ParseGUID parses a GUID in the canonical RFC 4122 text form or as 32 hex
+// digits without separators. Hex digits may be upper or lowercase.
+func ParseGUID(s string) (GUID, error) {
+	var guid GUID
+	switch len(s) {
+	case 32:
+		if _, err := hex.Decode(guid[:], []byte(s)); err != nil {
+			return GUID{}, fmt.Errorf("invalid GUID %q: %w", s, err)
+		}
+		return guid, nil
+
+	case 36:
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return GUID{}, fmt.Errorf("invalid GUID %q: misplaced separator", s)
+		}
+		digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+		if _, err := hex.Decode(guid[:], []byte(digits)); err != nil {
+			return GUID{}, fmt.Errorf("invalid GUID %q: %w", s, err)
+		}
+		return guid, nil
+
+	default:
+		return GUID{}, fmt.Errorf("invalid GUID %q: must be 16 bytes", s)
+	}
+}
+
+// CorrelationID returns a short identifier derived from the GUID for
+// correlating log lines of a device without logging the GUID itself. The same
+// GUID always results in the same identifier.
+func (g GUID) CorrelationID() string {
+	sum := sha256.Sum256(append([]byte("FDO-GUID-Correlation"), g[:]...))
+	return hex.EncodeToString(sum[:8])
+}
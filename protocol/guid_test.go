@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol_test
+
+import (
+	"fmt"
+	"testing"
+	"testing/quick"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestGUIDString(t *testing.T) {
+	guid := protocol.GUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	const canonical = "123e4567-e89b-12d3-a456-426614174000"
+
+	if got := guid.String(); got != canonical {
+		t.Errorf("expected %s, got %s", canonical, got)
+	}
+	if got := fmt.Sprintf("%v", guid); got != canonical {
+		t.Errorf("expected %%v to format as %s, got %s", canonical, got)
+	}
+	if got, want := fmt.Sprintf("%x", guid), "123e4567e89b12d3a456426614174000"; got != want {
+		t.Errorf("expected %%x to format as %s, got %s", want, got)
+	}
+
+	for _, s := range []string{
+		canonical,
+		"123E4567-E89B-12D3-A456-426614174000",
+		"123e4567e89b12d3a456426614174000",
+	} {
+		parsed, err := protocol.ParseGUID(s)
+		if err != nil {
+			t.Errorf("error parsing %q: %v", s, err)
+		} else if parsed != guid {
+			t.Errorf("parsing %q: expected %x, got %x", s, guid, parsed)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"1234",
+		"123e4567-e89b-12d3-a456-4266141740",
+		"123e4567e-89b-12d3-a456-426614174000",
+		"123e4567-e89b-12d3-a456-42661417400g",
+		"{123e4567-e89b-12d3-a456-426614174000}",
+	} {
+		if _, err := protocol.ParseGUID(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestGUIDRoundTrip(t *testing.T) {
+	if err := quick.Check(func(guid protocol.GUID) bool {
+		parsed, err := protocol.ParseGUID(guid.String())
+		return err == nil && parsed == guid
+	}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGUIDCorrelationID(t *testing.T) {
+	a, b := protocol.GUID{1}, protocol.GUID{2}
+	if a.CorrelationID() != a.CorrelationID() {
+		t.Error("expected correlation ID to be stable")
+	}
+	if a.CorrelationID() == b.CorrelationID() {
+		t.Error("expected different GUIDs to have different correlation IDs")
+	}
+	if len(a.CorrelationID()) != 16 {
+		t.Errorf("expected 16 character correlation ID, got %q", a.CorrelationID())
+	}
+}
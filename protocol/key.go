@@ -5,11 +5,15 @@ package protocol
 
 import (
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"strings"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
@@ -206,7 +210,120 @@ func NewPublicKey[T PublicKeyOrChain](typ KeyType, pub T, asCOSE bool) (*PublicK
 	}
 }
 
-// Public returns the public key parsed from the X509 or X5CHAIN encoding.
+// PublicKeyFromCrypto creates a public key structure of the given type and
+// encoding from an *ecdsa.PublicKey or *rsa.PublicKey. X5CHAIN encoding
+// requires a certificate chain, so PublicKeyFromChain must be used instead.
+//
+// The Crypto encoding of RSA keys is an array of the big-endian modulus and
+// exponent and of EC keys is the uncompressed SEC 1 point.
+func PublicKeyFromCrypto(typ KeyType, enc KeyEncoding, pub crypto.PublicKey) (*PublicKey, error) {
+	if err := checkKeyType(typ, pub); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	var err error
+	switch enc {
+	case CryptoKeyEnc:
+		body, err = marshalCryptoKey(pub)
+	case X509KeyEnc:
+		var der []byte
+		if der, err = x509.MarshalPKIXPublicKey(pub); err == nil {
+			body, err = cbor.Marshal(der)
+		}
+	case CoseKeyEnc:
+		var coseKey cose.Key
+		if coseKey, err = cose.NewKey(pub); err == nil {
+			body, err = cbor.Marshal(coseKey)
+		}
+	case X5ChainKeyEnc:
+		return nil, errors.New("X5CHAIN encoding requires a certificate chain")
+	default:
+		return nil, fmt.Errorf("unsupported key encoding: %s", enc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s encoding: %w", enc, err)
+	}
+	return &PublicKey{Type: typ, Encoding: enc, Body: body, key: pub}, nil
+}
+
+// PublicKeyFromChain creates a public key structure of the given type and
+// encoding from a certificate chain. Encodings other than X5CHAIN only encode
+// the public key of the first certificate.
+func PublicKeyFromChain(typ KeyType, enc KeyEncoding, chain []*x509.Certificate) (*PublicKey, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("certificate chain cannot be empty")
+	}
+	if enc != X5ChainKeyEnc {
+		return PublicKeyFromCrypto(typ, enc, chain[0].PublicKey)
+	}
+	if err := checkKeyType(typ, chain[0].PublicKey); err != nil {
+		return nil, err
+	}
+	certs := make([]*cbor.X509Certificate, len(chain))
+	for i, cert := range chain {
+		certs[i] = (*cbor.X509Certificate)(cert)
+	}
+	body, err := cbor.Marshal(certs)
+	if err != nil {
+		return nil, fmt.Errorf("X5Chain encoding: %w", err)
+	}
+	return &PublicKey{Type: typ, Encoding: enc, Body: body, key: chain[0].PublicKey, chain: chain}, nil
+}
+
+// checkKeyType validates that a public key is of the given type.
+func checkKeyType(typ KeyType, pub crypto.PublicKey) error {
+	switch typ {
+	case Secp256r1KeyType, Secp384r1KeyType:
+		eckey, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s public key must be an ECDSA public key, got %T", typ, pub)
+		}
+		if curve := eckey.Curve; (typ == Secp256r1KeyType && curve != elliptic.P256()) ||
+			(typ == Secp384r1KeyType && curve != elliptic.P384()) {
+			return fmt.Errorf("%s public key has wrong curve %s", typ, curve.Params().Name)
+		}
+		return nil
+	case Rsa2048RestrKeyType, RsaPkcsKeyType, RsaPssKeyType:
+		rsakey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s public key must be an RSA public key, got %T", typ, pub)
+		}
+		if typ == Rsa2048RestrKeyType && (rsakey.Size() != 256 || rsakey.E != 65537) {
+			return fmt.Errorf("%s public key must have a 2048 bit modulus and exponent of 65537", typ)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type: %s", typ)
+	}
+}
+
+// cryptoRSAKey is the Crypto encoding of an RSA public key.
+type cryptoRSAKey struct {
+	Modulus  []byte
+	Exponent []byte
+}
+
+func marshalCryptoKey(pub crypto.PublicKey) ([]byte, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		point, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return cbor.Marshal(point.Bytes())
+	case *rsa.PublicKey:
+		return cbor.Marshal(cryptoRSAKey{
+			Modulus:  pub.N.Bytes(),
+			Exponent: big.NewInt(int64(pub.E)).Bytes(),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+}
+
+// Public returns the public key parsed from the Crypto, X509, X5CHAIN, or
+// COSEKEY encoding.
 func (pub *PublicKey) Public() (crypto.PublicKey, error) {
 	if pub.key == nil && pub.err == nil {
 		pub.err = pub.parse()
@@ -225,6 +342,9 @@ func (pub *PublicKey) Chain() ([]*x509.Certificate, error) {
 
 func (pub *PublicKey) parse() error {
 	switch pub.Encoding {
+	case CryptoKeyEnc:
+		return pub.parseCrypto()
+
 	case X509KeyEnc:
 		return pub.parseX509()
 
@@ -312,6 +432,64 @@ func (pub *PublicKey) parseCose() error {
 	if err != nil {
 		return err
 	}
-	pub.key = pubkey
-	return nil
+
+	switch pub.Type {
+	case Secp256r1KeyType, Secp384r1KeyType:
+		eckey, ok := pubkey.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("public key must be an ECDSA public key")
+		}
+		pub.key = eckey
+		return nil
+	case RsaPssKeyType, RsaPkcsKeyType, Rsa2048RestrKeyType:
+		rsakey, ok := pubkey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("public key must be an RSA public key")
+		}
+		pub.key = rsakey
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type: %s", pub.Type)
+	}
+}
+
+func (pub *PublicKey) parseCrypto() error {
+	switch pub.Type {
+	case Secp256r1KeyType, Secp384r1KeyType:
+		var point []byte
+		if err := cbor.Unmarshal([]byte(pub.Body), &point); err != nil {
+			return err
+		}
+		curve, ecdhCurve := elliptic.P256(), ecdh.P256()
+		if pub.Type == Secp384r1KeyType {
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		}
+		// Validate that the point is uncompressed and on the curve
+		if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+			return err
+		}
+		size := (len(point) - 1) / 2
+		pub.key = &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(point[1 : 1+size]),
+			Y:     new(big.Int).SetBytes(point[1+size:]),
+		}
+		return nil
+	case RsaPssKeyType, RsaPkcsKeyType, Rsa2048RestrKeyType:
+		var body cryptoRSAKey
+		if err := cbor.Unmarshal([]byte(pub.Body), &body); err != nil {
+			return err
+		}
+		exp := new(big.Int).SetBytes(body.Exponent)
+		if len(body.Modulus) == 0 || !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > math.MaxInt32 {
+			return errors.New("invalid RSA modulus or exponent")
+		}
+		pub.key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(body.Modulus),
+			E: int(exp.Int64()),
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type: %s", pub.Type)
+	}
 }
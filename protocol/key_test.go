@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+var encodings = []protocol.KeyEncoding{
+	protocol.CryptoKeyEnc,
+	protocol.X509KeyEnc,
+	protocol.X5ChainKeyEnc,
+	protocol.CoseKeyEnc,
+}
+
+type testKey struct {
+	typ   protocol.KeyType
+	key   crypto.Signer
+	chain []*x509.Certificate
+}
+
+func newTestKeys(t testing.TB) []testKey {
+	t.Helper()
+
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa3072, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []testKey{
+		{typ: protocol.Secp256r1KeyType, key: ec256},
+		{typ: protocol.Secp384r1KeyType, key: ec384},
+		{typ: protocol.Rsa2048RestrKeyType, key: rsa2048},
+		{typ: protocol.RsaPkcsKeyType, key: rsa3072},
+		{typ: protocol.RsaPssKeyType, key: rsa3072},
+	}
+	for i, k := range keys {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: k.typ.String()},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, k.key.Public(), k.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i].chain = []*x509.Certificate{cert}
+	}
+	return keys
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	keys := newTestKeys(t)
+
+	roundTrip := func(keyIndex, encIndex uint8) bool {
+		k := keys[int(keyIndex)%len(keys)]
+		enc := encodings[int(encIndex)%len(encodings)]
+
+		pub, err := protocol.PublicKeyFromChain(k.typ, enc, k.chain)
+		if err != nil {
+			t.Logf("%s/%s: %v", k.typ, enc, err)
+			return false
+		}
+		data, err := cbor.Marshal(pub)
+		if err != nil {
+			t.Logf("%s/%s: %v", k.typ, enc, err)
+			return false
+		}
+		var got protocol.PublicKey
+		if err := cbor.Unmarshal(data, &got); err != nil {
+			t.Logf("%s/%s: %v", k.typ, enc, err)
+			return false
+		}
+		if got.Type != k.typ || got.Encoding != enc {
+			t.Logf("%s/%s: decoded as %s/%s", k.typ, enc, got.Type, got.Encoding)
+			return false
+		}
+
+		parsed, err := got.Public()
+		if err != nil {
+			t.Logf("%s/%s: %v", k.typ, enc, err)
+			return false
+		}
+		if !parsed.(interface{ Equal(crypto.PublicKey) bool }).Equal(k.key.Public()) {
+			t.Logf("%s/%s: public key did not match", k.typ, enc)
+			return false
+		}
+		chain, err := got.Chain()
+		if err != nil || (enc == protocol.X5ChainKeyEnc) != (len(chain) == 1) {
+			t.Logf("%s/%s: unexpected chain of length %d: %v", k.typ, enc, len(chain), err)
+			return false
+		}
+
+		// Re-encoding the parsed key must produce identical bytes
+		var again *protocol.PublicKey
+		if enc == protocol.X5ChainKeyEnc {
+			again, err = protocol.PublicKeyFromChain(k.typ, enc, chain)
+		} else {
+			again, err = protocol.PublicKeyFromCrypto(k.typ, enc, parsed)
+		}
+		if err != nil {
+			t.Logf("%s/%s: %v", k.typ, enc, err)
+			return false
+		}
+		if !bytes.Equal(again.Body, pub.Body) {
+			t.Logf("%s/%s: re-encoded body differs", k.typ, enc)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublicKeyTypeMismatch(t *testing.T) {
+	keys := newTestKeys(t)
+	for _, test := range []struct {
+		typ protocol.KeyType
+		key crypto.PublicKey
+	}{
+		{protocol.Secp256r1KeyType, keys[1].key.Public()},    // P-384 key
+		{protocol.Secp384r1KeyType, keys[2].key.Public()},    // RSA key
+		{protocol.Rsa2048RestrKeyType, keys[3].key.Public()}, // RSA 3072 key
+		{protocol.RsaPkcsKeyType, keys[0].key.Public()},      // EC key
+	} {
+		for _, enc := range []protocol.KeyEncoding{protocol.CryptoKeyEnc, protocol.X509KeyEnc, protocol.CoseKeyEnc} {
+			if _, err := protocol.PublicKeyFromCrypto(test.typ, enc, test.key); err == nil {
+				t.Errorf("expected %T to be rejected as %s with %s encoding", test.key, test.typ, enc)
+			}
+		}
+	}
+	if _, err := protocol.PublicKeyFromCrypto(protocol.Secp256r1KeyType, protocol.X5ChainKeyEnc, keys[0].key.Public()); err == nil {
+		t.Error("expected X5CHAIN encoding without a chain to fail")
+	}
+}
+
+func FuzzPublicKey(f *testing.F) {
+	keys := newTestKeys(f)
+	for _, k := range keys {
+		for _, enc := range encodings {
+			pub, err := protocol.PublicKeyFromChain(k.typ, enc, k.chain)
+			if err != nil {
+				f.Fatal(err)
+			}
+			data, err := cbor.Marshal(pub)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var pub protocol.PublicKey
+		if err := cbor.Unmarshal(data, &pub); err != nil {
+			return
+		}
+		_, _ = pub.Public()
+		_, _ = pub.Chain()
+	})
+}
@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol
+
+import (
+	"context"
+	"net/netip"
+)
+
+// Peer is the client of a request, as seen by a transport. When requests pass
+// through trusted reverse proxies, it describes the original client rather
+// than the proxy.
+type Peer struct {
+	// Addr is the IP address of the client. It is invalid if unknown.
+	Addr netip.Addr
+
+	// TLS is true if the client connected with TLS, whether it was
+	// terminated by the server or a trusted proxy.
+	TLS bool
+}
+
+type peerKey struct{}
+
+// ContextWithPeer returns a context carrying the client of a request, so that
+// responders and their callbacks may use it for logging and access decisions.
+func ContextWithPeer(parent context.Context, peer Peer) context.Context {
+	return context.WithValue(parent, peerKey{}, peer)
+}
+
+// PeerFromContext returns the client of a request, if set by the transport.
+func PeerFromContext(ctx context.Context) (Peer, bool) {
+	peer, ok := ctx.Value(peerKey{}).(Peer)
+	return peer, ok
+}
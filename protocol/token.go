@@ -27,3 +27,28 @@ type TokenService interface {
 	// of token-encoded state (i.e. JWTs/CWTs).
 	TokenFromContext(context.Context) (string, bool)
 }
+
+// SessionLocker is optionally implemented by a TokenService whose state is
+// shared by multiple server replicas. The Dispatcher locks the session of each
+// message which continues a protocol, so that messages of a session are
+// handled one at a time, even if a device retries a message against another
+// replica.
+type SessionLocker interface {
+	// LockSession acquires a lease on the session identified by the token in
+	// the context, waiting until it is acquired or the context is done. The
+	// returned function releases the lease.
+	//
+	// Implementations should expire leases which are not released, so that a
+	// session is not locked forever by a replica which exits.
+	LockSession(context.Context) (unlock func(), err error)
+}
+
+// SessionIdentifier is optionally implemented by a TokenService whose tokens
+// change within a protocol session, such as those encoding state. The
+// Dispatcher uses it to correlate the traced messages of a session. Otherwise
+// the token itself identifies the session.
+type SessionIdentifier interface {
+	// SessionID returns a value which identifies the session of the token in
+	// the context and does not change for the life of the session.
+	SessionID(context.Context) ([]byte, error)
+}
@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
+)
+
+// maxTraceSummary is the maximum length of TraceEvent.Summary in bytes.
+const maxTraceSummary = 256
+
+// TraceEvent describes a message handled by a Dispatcher with a Tracer.
+type TraceEvent struct {
+	// CorrelationID identifies the protocol session of the message. It is
+	// derived from the session token or, if the TokenService implements
+	// SessionIdentifier, its session ID. It is also sent to the device in
+	// error messages. It is zero if the message was not part of a session.
+	CorrelationID uint
+
+	// GUID is the device GUID, if known when the message was handled. For
+	// most protocols, it is only known for the first message of a session.
+	GUID *GUID
+
+	// Time is when the message was received and Duration is how long it
+	// took to handle.
+	Time     time.Time
+	Duration time.Duration
+
+	// MsgType and RequestSize describe the received message.
+	MsgType     uint8
+	RequestSize int64
+
+	// RespType and ResponseSize describe the response message. RespType is
+	// zero if there was no response, i.e. for an error message sent by the
+	// device.
+	RespType     uint8
+	ResponseSize int
+
+	// Err describes the error message sent or received, if any.
+	Err string
+
+	// Summary is a truncated CBOR diagnostic notation of the response. It is
+	// empty for error messages and encrypted messages, so that service info
+	// is not traced.
+	Summary string
+}
+
+// Tracer records a trace of every message handled by a Dispatcher, such as to
+// debug devices which fail at a particular message.
+type Tracer interface {
+	// Trace is called after each message is handled. A returned error is
+	// logged, but does not fail the message.
+	Trace(context.Context, TraceEvent) error
+}
+
+// TraceGUID associates a device GUID with the message being handled, if it
+// is being traced. Responders call it once the GUID of a message is known.
+func TraceGUID(ctx context.Context, guid GUID) {
+	if t, ok := ctx.Value(traceKey{}).(*trace); ok {
+		t.guid = &guid
+	}
+}
+
+// TraceCorrelationID returns the correlation ID of the session of the message
+// being handled. It is zero if the message is not traced or not part of a
+// session.
+func TraceCorrelationID(ctx context.Context) uint {
+	if t, ok := traceFromContext(ctx); ok {
+		return t.correlationID()
+	}
+	return 0
+}
+
+type traceKey struct{}
+
+// trace collects the parts of a TraceEvent which are only known while a
+// message is dispatched.
+type trace struct {
+	session []byte
+	guid    *GUID
+	err     string
+	summary string
+}
+
+func traceFromContext(ctx context.Context) (*trace, bool) {
+	t, ok := ctx.Value(traceKey{}).(*trace)
+	return t, ok
+}
+
+// correlationID derives an identifier for a session without revealing its
+// token, which authorizes further messages. It is limited to 32 bits, because
+// the CorrelationID of an ErrorMessage is a platform-sized uint.
+func (t *trace) correlationID() uint {
+	if len(t.session) == 0 {
+		return 0
+	}
+	sum := sha256.Sum256(t.session)
+	return uint(binary.BigEndian.Uint32(sum[:4]))
+}
+
+// traceSession identifies the session of a traced message once the token in
+// the context is known.
+func traceSession(ctx context.Context, tokens TokenService) {
+	t, ok := traceFromContext(ctx)
+	if !ok {
+		return
+	}
+	if ids, ok := tokens.(SessionIdentifier); ok {
+		id, err := ids.SessionID(ctx)
+		if err == nil {
+			t.session = id
+		}
+		return
+	}
+	if token, ok := tokens.TokenFromContext(ctx); ok {
+		t.session = []byte(token)
+	}
+}
+
+// traceDeviceError records an error message sent by the device.
+func traceDeviceError(ctx context.Context, msg io.Reader) {
+	t, ok := traceFromContext(ctx)
+	if !ok {
+		return
+	}
+	var errMsg ErrorMessage
+	if err := cbor.NewDecoder(msg).Decode(&errMsg); err != nil {
+		t.err = "invalid error message from device: " + err.Error()
+		return
+	}
+	t.err = fmt.Sprintf("device error [code=%d,prevMsgType=%d] %s", errMsg.Code, errMsg.PrevMsgType, errMsg.ErrString)
+}
+
+// traceResponse records a response before it is encrypted and encoded. Error
+// messages are returned with the correlation ID of the session set.
+func traceResponse(ctx context.Context, respType uint8, respData any) any {
+	t, ok := traceFromContext(ctx)
+	if !ok {
+		return respData
+	}
+
+	switch msg := respData.(type) {
+	case ErrorMessage:
+		respData = t.errorMessage(&msg)
+	case *ErrorMessage:
+		respData = t.errorMessage(msg)
+	default:
+		if TO2ProveDeviceMsgType < respType && respType < ErrorMsgType {
+			return respData
+		}
+		body, err := cbor.Marshal(respData)
+		if err != nil {
+			return respData
+		}
+		if t.summary, err = cdn.FromCBOR(body); err != nil {
+			t.summary = ""
+		}
+		t.summary = truncate(t.summary, maxTraceSummary)
+	}
+	return respData
+}
+
+// errorMessage records an error message response and sets its correlation
+// ID.
+func (t *trace) errorMessage(msg *ErrorMessage) *ErrorMessage {
+	id := t.correlationID()
+	msg.CorrelationID = &id
+	t.err = fmt.Sprintf("[code=%d] %s", msg.Code, msg.ErrString)
+	return msg
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.TrimSpace(s[:n]) + "..."
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package protocol
+
+import (
+	"context"
+	"fmt"
+)
+
+// Protocol versions, as used in HTTP paths, voucher headers, and device
+// credentials.
+const (
+	Version100 uint16 = 100 // FDO 1.0
+	Version101 uint16 = 101 // FDO 1.1
+)
+
+// CurrentVersion is the protocol version used when none has been negotiated.
+const CurrentVersion = Version101
+
+// SupportedVersion reports whether a protocol version can be spoken. FDO 1.0
+// and 1.1 messages have the same structure, so supporting 1.0 only requires
+// that version numbers be accepted and emitted.
+func SupportedVersion(version uint16) bool {
+	return version == Version100 || version == Version101
+}
+
+// ParseVersion parses a protocol version as it appears in an HTTP path, i.e.
+// "101".
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "100":
+		return Version100, nil
+	case "101":
+		return Version101, nil
+	default:
+		return 0, fmt.Errorf("unsupported protocol version %q", s)
+	}
+}
+
+type versionKey struct{}
+
+// ContextWithVersion returns a context carrying the protocol version of the
+// peer, so that servers emit messages and vouchers of the same version.
+func ContextWithVersion(parent context.Context, version uint16) context.Context {
+	return context.WithValue(parent, versionKey{}, version)
+}
+
+// VersionFromContext returns the protocol version of the peer, or
+// CurrentVersion if it is not set.
+func VersionFromContext(ctx context.Context) uint16 {
+	if version, ok := ctx.Value(versionKey{}).(uint16); ok {
+		return version
+	}
+	return CurrentVersion
+}
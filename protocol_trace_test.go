@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/loopback"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestProtocolTrace(t *testing.T) {
+	server := fdotest.NewServer(t)
+	traced := &loopback.Transport{
+		Tokens:       server.State,
+		TO1Responder: server.TO1,
+		Tracer:       server.State,
+	}
+
+	// Both messages of a session are traced under the GUID of the device
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	if _, err := fdo.TO1(context.Background(), traced, dev.Cred, dev.Key, nil); err != nil {
+		t.Fatal(err)
+	}
+	events, err := server.State.DeviceTrace(context.Background(), dev.Cred.GUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 traced messages, got %+v", events)
+	}
+	hello, prove := events[0], events[1]
+	if hello.MsgType != protocol.TO1HelloRVMsgType || hello.RespType != protocol.TO1HelloRVAckMsgType ||
+		hello.GUID == nil || *hello.GUID != dev.Cred.GUID || hello.RequestSize == 0 || hello.Summary == "" {
+		t.Errorf("unexpected trace of hello: %+v", hello)
+	}
+	if prove.MsgType != protocol.TO1ProveToRVMsgType || prove.RespType != protocol.TO1RVRedirectMsgType ||
+		prove.CorrelationID == 0 || prove.CorrelationID != hello.CorrelationID {
+		t.Errorf("unexpected trace of prove: %+v", prove)
+	}
+
+	// Error messages sent to the device include the correlation ID
+	unregistered := server.NewDevice(t, protocol.Secp256r1KeyType)
+	_, err = fdo.TO1(context.Background(), traced, unregistered.Cred, unregistered.Key, nil)
+	var errMsg protocol.ErrorMessage
+	if !errors.As(err, &errMsg) || errMsg.CorrelationID == nil {
+		t.Fatalf("expected error message with correlation ID, got %v", err)
+	}
+	events, err = server.State.SessionTrace(context.Background(), *errMsg.CorrelationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].RespType != protocol.ErrorMsgType || events[0].Err == "" ||
+		events[0].GUID == nil || *events[0].GUID != unregistered.Cred.GUID {
+		t.Fatalf("expected traced error message, got %+v", events)
+	}
+}
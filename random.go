@@ -0,0 +1,17 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// randOrDefault returns r, or crypto/rand.Reader if r is nil.
+func randOrDefault(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
+}
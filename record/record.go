@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package record implements recording and replaying of FDO message exchanges
+// for debugging and regression testing.
+//
+// Recordings are CBOR sequences (RFC 8742) of Exchange values. Messages are
+// recorded unencrypted, so recordings of TO2 may contain sensitive service
+// info and must be handled accordingly.
+package record
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+)
+
+// Exchange is a single recorded request and response.
+type Exchange struct {
+	MsgType  uint8
+	Request  []byte // CBOR-encoded, unencrypted request message
+	RespType uint8
+	Response []byte // CBOR-encoded, unencrypted response message
+}
+
+// Transport wraps another transport and records every exchange to W.
+type Transport struct {
+	Transport fdo.Transport
+	W         io.Writer
+
+	mu sync.Mutex
+}
+
+var _ fdo.Transport = (*Transport)(nil)
+
+// Send sends a message using the wrapped transport and records the exchange.
+// Exchanges which fail with an error are not recorded.
+func (t *Transport) Send(ctx context.Context, msgType uint8, msg any, sess kex.Session) (uint8, io.ReadCloser, error) {
+	req, err := cbor.Marshal(msg)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error encoding message %d: %w", msgType, err)
+	}
+
+	respType, rc, err := t.Transport.Send(ctx, msgType, msg, sess)
+	if err != nil {
+		return 0, nil, err
+	}
+	var resp []byte
+	if rc != nil {
+		defer func() { _ = rc.Close() }()
+		if resp, err = io.ReadAll(rc); err != nil {
+			return 0, nil, fmt.Errorf("error reading response to message %d: %w", msgType, err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := cbor.NewEncoder(t.W).Encode(Exchange{
+		MsgType:  msgType,
+		Request:  req,
+		RespType: respType,
+		Response: resp,
+	}); err != nil {
+		return 0, nil, fmt.Errorf("error recording message %d: %w", msgType, err)
+	}
+
+	return respType, io.NopCloser(bytes.NewReader(resp)), nil
+}
+
+// ErrEndOfRecording is returned by Replayer when a message is sent after all
+// recorded exchanges have been replayed.
+var ErrEndOfRecording = errors.New("end of recording")
+
+// Replayer implements fdo.Transport by responding to each message with the
+// next recorded response, in place of a server.
+//
+// Replaying the device side of TO2 requires that the device produce the same
+// nonces and key exchange parameters as in the recording, i.e. by using a
+// deterministic source of randomness.
+type Replayer struct {
+	R io.Reader
+
+	// Strict causes messages which differ from the recorded request to fail.
+	// By default, only the message type must match.
+	Strict bool
+
+	mu  sync.Mutex
+	dec *cbor.Decoder
+}
+
+var _ fdo.Transport = (*Replayer)(nil)
+
+// Send returns the next recorded response.
+func (r *Replayer) Send(ctx context.Context, msgType uint8, msg any, _ kex.Session) (uint8, io.ReadCloser, error) {
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dec == nil {
+		r.dec = cbor.NewDecoder(r.R)
+	}
+
+	var ex Exchange
+	if err := r.dec.Decode(&ex); errors.Is(err, io.EOF) {
+		return 0, nil, fmt.Errorf("%w: unexpected message %d", ErrEndOfRecording, msgType)
+	} else if err != nil {
+		return 0, nil, fmt.Errorf("error reading recorded exchange: %w", err)
+	}
+	if ex.MsgType != msgType {
+		return 0, nil, fmt.Errorf("expected message %d from recording, got %d", ex.MsgType, msgType)
+	}
+	if r.Strict {
+		req, err := cbor.Marshal(msg)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error encoding message %d: %w", msgType, err)
+		}
+		if !bytes.Equal(req, ex.Request) {
+			return 0, nil, fmt.Errorf("message %d differs from recording", msgType)
+		}
+	}
+
+	return ex.RespType, io.NopCloser(bytes.NewReader(ex.Response)), nil
+}
+
+// ReadAll reads all exchanges of a recording.
+func ReadAll(r io.Reader) ([]Exchange, error) {
+	dec := cbor.NewDecoder(r)
+	var exchanges []Exchange
+	for {
+		var ex Exchange
+		if err := dec.Decode(&ex); errors.Is(err, io.EOF) {
+			return exchanges, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading recorded exchange %d: %w", len(exchanges), err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package record_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/record"
+)
+
+// echoTransport responds to each message with its type incremented and its
+// body unchanged.
+type echoTransport struct{}
+
+func (echoTransport) Send(_ context.Context, msgType uint8, msg any, _ kex.Session) (uint8, io.ReadCloser, error) {
+	if msgType == protocol.ErrorMsgType {
+		return 0, nil, nil
+	}
+	body, err := cbor.Marshal(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	return msgType + 1, io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	msgs := []struct {
+		msgType uint8
+		msg     any
+	}{
+		{protocol.TO1HelloRVMsgType, []any{"hello"}},
+		{protocol.TO1ProveToRVMsgType, []any{1, 2, 3}},
+		{protocol.ErrorMsgType, protocol.ErrorMessage{Code: 500, PrevMsgType: 33, ErrString: "oops"}},
+	}
+
+	var recording bytes.Buffer
+	recorder := &record.Transport{Transport: echoTransport{}, W: &recording}
+	var responses [][]byte
+	for _, m := range msgs {
+		_, rc, err := recorder.Send(ctx, m.msgType, m.msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := io.ReadAll(rc)
+		responses = append(responses, resp)
+	}
+
+	exchanges, err := record.ReadAll(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != len(msgs) {
+		t.Fatalf("expected %d exchanges, got %d", len(msgs), len(exchanges))
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		replayer := &record.Replayer{R: bytes.NewReader(recording.Bytes()), Strict: true}
+		for i, m := range msgs {
+			respType, rc, err := replayer.Send(ctx, m.msgType, m.msg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if respType != exchanges[i].RespType {
+				t.Errorf("expected response type %d, got %d", exchanges[i].RespType, respType)
+			}
+			if resp, _ := io.ReadAll(rc); !bytes.Equal(resp, responses[i]) {
+				t.Errorf("expected response %x, got %x", responses[i], resp)
+			}
+		}
+		if _, _, err := replayer.Send(ctx, protocol.TO1HelloRVMsgType, msgs[0].msg, nil); !errors.Is(err, record.ErrEndOfRecording) {
+			t.Fatalf("expected end of recording, got %v", err)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		replayer := &record.Replayer{R: bytes.NewReader(recording.Bytes()), Strict: true}
+		if _, _, err := replayer.Send(ctx, protocol.TO1HelloRVMsgType, []any{"goodbye"}, nil); err == nil {
+			t.Fatal("expected strict replay to fail for a different request")
+		}
+		replayer = &record.Replayer{R: bytes.NewReader(recording.Bytes())}
+		if _, _, err := replayer.Send(ctx, protocol.TO1HelloRVMsgType, []any{"goodbye"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := replayer.Send(ctx, protocol.TO2HelloDeviceMsgType, nil, nil); err == nil {
+			t.Fatal("expected replay to fail for a different message type")
+		}
+	})
+}
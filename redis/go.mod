@@ -0,0 +1,17 @@
+module github.com/fido-device-onboard/go-fdo/redis
+
+go 1.23.0
+
+replace github.com/fido-device-onboard/go-fdo => ../
+
+require (
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/fido-device-onboard/go-fdo v0.0.0-00010101000000-000000000000
+	github.com/redis/go-redis/v9 v9.9.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+)
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package redis implements server state shared by replicas using Redis.
+package redis
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultKeyPrefix is the prefix of all keys when NonceStore.KeyPrefix is not
+// set.
+const DefaultKeyPrefix = "fdo:"
+
+// NonceStore implements fdo.NonceStore using keys which Redis expires.
+type NonceStore struct {
+	Client redis.UniversalClient
+
+	// KeyPrefix is prepended to the key of each nonce, so that a Redis
+	// database may be shared. If empty, DefaultKeyPrefix is used.
+	KeyPrefix string
+}
+
+var _ fdo.NonceStore = (*NonceStore)(nil)
+
+func (s *NonceStore) key(nonce protocol.Nonce) string {
+	prefix := s.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultKeyPrefix
+	}
+	return prefix + "nonce:" + hex.EncodeToString(nonce[:])
+}
+
+// AddNonce records an issued nonce until it expires.
+func (s *NonceStore) AddNonce(ctx context.Context, nonce protocol.Nonce, expires time.Time) error {
+	// A zero expiration would never expire, so do not store nonces which
+	// have already expired
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.Client.Set(ctx, s.key(nonce), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("error adding nonce: %w", err)
+	}
+	return nil
+}
+
+// ConsumeNonce removes a nonce. If it is not found or has expired,
+// ErrNotFound is returned.
+func (s *NonceStore) ConsumeNonce(ctx context.Context, nonce protocol.Nonce) error {
+	n, err := s.Client.Del(ctx, s.key(nonce)).Result()
+	if err != nil {
+		return fmt.Errorf("error consuming nonce: %w", err)
+	}
+	if n == 0 {
+		return fdo.ErrNotFound
+	}
+	return nil
+}
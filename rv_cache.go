@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultRVBlobCacheTTL is how long rendezvous blobs are cached when
+// RVBlobCache.TTL is zero.
+const DefaultRVBlobCacheTTL = time.Minute
+
+// RVBlobCache is an in-memory LRU cache of rendezvous blobs by device GUID.
+// It keeps TO1 lookup latency flat for devices which are looked up often,
+// such as when a large fleet is pointed at one rendezvous server. It is safe
+// for concurrent use and its zero value caches nothing.
+//
+// The cache should be shared by the TO0Server and TO1Server of a rendezvous
+// server, so that new registrations replace cached blobs. Registrations made
+// by other replicas and expired registrations may still be served from cache
+// until TTL elapses.
+type RVBlobCache struct {
+	// Size is the maximum number of cached blobs. The least recently used
+	// blob is evicted when it is exceeded.
+	Size int
+
+	// TTL is how long a blob is cached. If zero, DefaultRVBlobCacheTTL is
+	// used.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries lru[protocol.GUID, rvBlobCacheEntry]
+
+	// generation is incremented on each invalidation, so that blobs read from
+	// the store before a new registration are not cached
+	generation uint64
+}
+
+type rvBlobCacheEntry struct {
+	blob    *cose.Sign1[protocol.To1d, []byte]
+	ov      *Voucher
+	expires time.Time
+}
+
+// Len returns the number of cached blobs, including any which have expired
+// but not yet been evicted.
+func (c *RVBlobCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.len()
+}
+
+// Invalidate removes the cached blob of a device, if any. It must be called
+// whenever the registration of the device changes.
+func (c *RVBlobCache) Invalidate(guid protocol.GUID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries.remove(guid)
+}
+
+// get returns the cached blob of a device, if present and unexpired, and the
+// current generation to pass to add.
+func (c *RVBlobCache) get(guid protocol.GUID) (_ *cose.Sign1[protocol.To1d, []byte], _ *Voucher, generation uint64, ok bool) {
+	if c == nil || c.Size <= 0 {
+		return nil, nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries.get(guid)
+	if !ok {
+		return nil, nil, c.generation, false
+	}
+	if time.Now().After(entry.expires) {
+		c.entries.remove(guid)
+		return nil, nil, c.generation, false
+	}
+	return entry.blob, entry.ov, c.generation, true
+}
+
+// add caches the blob of a device, unless any blob has been invalidated since
+// generation was returned by get.
+func (c *RVBlobCache) add(guid protocol.GUID, blob *cose.Sign1[protocol.To1d, []byte], ov *Voucher, generation uint64) {
+	if c == nil || c.Size <= 0 {
+		return
+	}
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = DefaultRVBlobCacheTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.entries.add(guid, rvBlobCacheEntry{
+		blob:    blob,
+		ov:      ov,
+		expires: time.Now().Add(ttl),
+	}, c.Size)
+}
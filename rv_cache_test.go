@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+type countingRVBlobs struct {
+	fdo.RendezvousBlobPersistentState
+	lookups atomic.Int32
+}
+
+func (s *countingRVBlobs) RVBlob(ctx context.Context, guid protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *fdo.Voucher, error) {
+	s.lookups.Add(1)
+	return s.RendezvousBlobPersistentState.RVBlob(ctx, guid)
+}
+
+func TestRVBlobCache(t *testing.T) {
+	server := fdotest.NewServer(t)
+	cache := &fdo.RVBlobCache{Size: 1}
+	store := &countingRVBlobs{RendezvousBlobPersistentState: server.State}
+	server.TO0.Cache = cache
+	server.TO1.RVBlobs, server.TO1.Cache = store, cache
+
+	register := func(dev *fdotest.Device, port uint16) {
+		t.Helper()
+		dnsAddr := "owner.fidoalliance.org"
+		if _, err := server.TO0Client.Register(context.Background(), server.Transport(), dev.Cred.GUID, []protocol.RvTO2Addr{
+			{DNSAddress: &dnsAddr, Port: port, TransportProtocol: protocol.HTTPTransport},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookup := func(dev *fdotest.Device, expectPort uint16, expectLookups int32) {
+		t.Helper()
+		to1d, err := fdo.TO1(context.Background(), server.Transport(), dev.Cred, dev.Key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port := to1d.Payload.Val.RV[0].Port; port != expectPort {
+			t.Errorf("expected owner port %d, got %d", expectPort, port)
+		}
+		if n := store.lookups.Load(); n != expectLookups {
+			t.Errorf("expected %d store lookups, got %d", expectLookups, n)
+		}
+	}
+
+	a := server.NewDevice(t, protocol.Secp256r1KeyType)
+	b := server.NewDevice(t, protocol.Secp256r1KeyType)
+	register(a, 8080)
+	register(b, 8080)
+
+	// Both messages of each TO1 are served from one store lookup
+	lookup(a, 8080, 1)
+	lookup(a, 8080, 1)
+
+	// A new registration replaces the cached blob
+	register(a, 8081)
+	lookup(a, 8081, 2)
+
+	// The least recently used blob is evicted
+	lookup(b, 8080, 3)
+	if n := cache.Len(); n != 1 {
+		t.Errorf("expected 1 cached blob, got %d", n)
+	}
+	lookup(a, 8081, 4)
+}
@@ -9,15 +9,13 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
-	"errors"
 	"fmt"
 	"io"
 	"iter"
-	"log/slog"
 	"time"
 
+	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/kex"
-	"github.com/fido-device-onboard/go-fdo/plugin"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 )
@@ -29,21 +27,72 @@ type DIServer[T any] struct {
 
 	// SignDeviceCertChain creates a device certificate chain based on info
 	// provided in the DI.AppStart message.
+	//
+	// The device certificate may be issued by an intermediate CA, such as one
+	// created by custom.NewIntermediateCA, in which case the chain must include
+	// the certificate of the manufacturer key used for voucher extension. That
+	// key becomes the manufacturer key of the voucher, while the full chain is
+	// covered by its certificate chain hash.
 	SignDeviceCertificate func(*T) ([]*x509.Certificate, error)
 
 	// DeviceInfo returns the device info string to use for a given device,
 	// based on its self-reported info and certificate chain.
 	DeviceInfo func(context.Context, *T, []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error)
 
+	// ManufacturerKeys, if set, allows several manufacturer keys per key type
+	// to be in use at once. SelectManufacturerKey chooses the key for each
+	// device and SignDeviceCertificateWithKey signs its certificate with it,
+	// instead of SignDeviceCertificate being called. The key is used for voucher
+	// auto-extension and, if Vouchers implements
+	// VoucherManufacturerKeyPersistentState, its name is recorded.
+	ManufacturerKeys ManufacturerKeysPersistentState
+
+	// SelectManufacturerKey chooses the manufacturer key of a device based on
+	// info provided in the DI.AppStart message, i.e. by key type and product
+	// line. It is required when ManufacturerKeys is set.
+	SelectManufacturerKey func(context.Context, *T) (*ManufacturerKey, error)
+
+	// SignDeviceCertificateWithKey creates a device certificate chain issued
+	// by the selected manufacturer key. It is required when ManufacturerKeys
+	// is set.
+	SignDeviceCertificateWithKey func(*T, *ManufacturerKey) ([]*x509.Certificate, error)
+
+	// CryptoProfile restricts the device key types and hash algorithms of new
+	// vouchers. If nil, DefaultCryptoProfile is used.
+	CryptoProfile *CryptoProfile
+
 	// When set, new vouchers will be extended using the appropriate owner key.
 	AutoExtend AutoExtend
 
+	// Notary, if set, countersigns the entry added by AutoExtend, for
+	// deployments where an auditor must attest to each voucher extension.
+	Notary crypto.Signer
+
+	// DeviceStatus, if not nil, records each device as initialized once its
+	// voucher is stored.
+	DeviceStatus DeviceStatusPersistentState
+
 	// When set, new vouchers will be registered for rendezvous.
 	AutoTO0      AutoTO0
 	AutoTO0Addrs []protocol.RvTO2Addr
 
 	// Rendezvous directives
 	RvInfo func(context.Context, *Voucher) ([][]protocol.RvInstruction, error)
+
+	// Rand is the source of randomness for device GUIDs. If nil,
+	// crypto/rand.Reader is used.
+	//
+	// A deterministic source should only be used for testing.
+	Rand io.Reader
+
+	// GUIDs, if not nil, generates device GUIDs instead of reading them from
+	// Rand.
+	GUIDs GUIDGenerator
+
+	// VoucherMetadata, if not nil, returns metadata to persist alongside each
+	// new voucher, such as a monotonic voucher serial number. Vouchers must
+	// implement VoucherMetadataPersistentState.
+	VoucherMetadata func(context.Context, *Voucher) (map[string]string, error)
 }
 
 // Respond validates a request and returns the appropriate response message.
@@ -67,14 +116,14 @@ func (s *DIServer[T]) Respond(ctx context.Context, msgType uint8, msg io.Reader)
 		return respType, resp
 	}
 
-	// Default to error code 500, error message of err parameter, and timestamp
-	// of the current time
+	// Default to error code 500, structured error message of err parameter, and
+	// timestamp of the current time
 	errMsg := errMsgFromContext(ctx)
 	if errMsg.Code == 0 {
 		errMsg.Code = protocol.InternalServerErrCode
 	}
 	if errMsg.ErrString == "" {
-		errMsg.ErrString = err.Error()
+		errMsg.ErrString = protocol.NewErrorDetail(errMsg.Code, errMsg.PrevMsgType, err).String()
 	}
 	if errMsg.Timestamp == 0 {
 		errMsg.Timestamp = time.Now().Unix()
@@ -100,6 +149,41 @@ type TO0Server struct {
 	//
 	// If NegotiateTTL is not set, the requested TTL will be used.
 	NegotiateTTL func(requestedSeconds uint32, ov Voucher) (waitSeconds uint32)
+
+	// Cache, if not nil, is invalidated for each device registered. It should
+	// be the same as the TO1Server's.
+	Cache *RVBlobCache
+
+	// VerifyEntries, if not nil, verifies the entry signatures of each
+	// voucher in place of Voucher.VerifyEntries, such as to offload the work
+	// to a remote service.
+	VerifyEntries func(context.Context, *Voucher) error
+
+	// VerifyTo1d, if not nil, verifies the signature of each rendezvous blob
+	// with the owner key of its voucher, in place of the TO0Server, such as
+	// to offload the work to a remote service.
+	VerifyTo1d func(ctx context.Context, ownerKey crypto.PublicKey, to1d *cose.Sign1[protocol.To1d, []byte]) error
+
+	// VerifiedVouchers, if not nil, remembers vouchers whose entries have
+	// been verified, so that they are not verified again when an owner
+	// service renews a registration.
+	VerifiedVouchers *VerifiedVoucherCache
+
+	// DeviceStatus, if not nil, records each device as registered once its
+	// rendezvous blob is stored.
+	DeviceStatus DeviceStatusPersistentState
+
+	// Nonces, if not nil, records the nonce of each TO0.HelloAck, so that
+	// TO0.OwnerSign is accepted by any replica at most once and within
+	// NonceTTL. If NonceTTL is zero, DefaultNonceTTL is used.
+	Nonces   NonceStore
+	NonceTTL time.Duration
+
+	// Rand is the source of randomness for nonces. If nil, crypto/rand.Reader
+	// is used.
+	//
+	// A deterministic source should only be used for testing.
+	Rand io.Reader
 }
 
 // Respond validates a request and returns the appropriate response message.
@@ -123,14 +207,14 @@ func (s *TO0Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 		return respType, resp
 	}
 
-	// Default to error code 500, error message of err parameter, and timestamp
-	// of the current time
+	// Default to error code 500, structured error message of err parameter, and
+	// timestamp of the current time
 	errMsg := errMsgFromContext(ctx)
 	if errMsg.Code == 0 {
 		errMsg.Code = protocol.InternalServerErrCode
 	}
 	if errMsg.ErrString == "" {
-		errMsg.ErrString = err.Error()
+		errMsg.ErrString = protocol.NewErrorDetail(errMsg.Code, errMsg.PrevMsgType, err).String()
 	}
 	if errMsg.Timestamp == 0 {
 		errMsg.Timestamp = time.Now().Unix()
@@ -142,6 +226,40 @@ func (s *TO0Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 type TO1Server struct {
 	Session TO1SessionState
 	RVBlobs RendezvousBlobPersistentState
+
+	// If Stats is non-nil, then it counts the outcome of each TO1 message.
+	Stats *TO1Stats
+
+	// CryptoProfile restricts the device attestation signature algorithms of
+	// devices. If nil, DefaultCryptoProfile is used.
+	CryptoProfile *CryptoProfile
+
+	// DeviceStatus, if not nil, records each device as contacted once it
+	// proves itself and receives its rendezvous blob.
+	DeviceStatus DeviceStatusPersistentState
+
+	// Nonces, if not nil, validates the nonce of TO1.ProveToRV against a
+	// store shared by all replicas, so that it is used once and within
+	// NonceTTL. If NonceTTL is zero, DefaultNonceTTL is used.
+	Nonces   NonceStore
+	NonceTTL time.Duration
+
+	// Rand is the source of randomness for nonces. If nil, crypto/rand.Reader
+	// is used.
+	//
+	// A deterministic source should only be used for testing.
+	Rand io.Reader
+
+	// Cache, if not nil, serves rendezvous blobs from memory in front of
+	// RVBlobs. It should be the same as the TO0Server's, so that new
+	// registrations are not hidden by cached blobs.
+	Cache *RVBlobCache
+
+	// Backoff, if not nil, is called for each TO1.HelloRV before the device
+	// is looked up. If it returns a positive delay, then the message is
+	// rejected with an error message asking the device to retry TO1 after
+	// the delay, so that an overloaded rendezvous server can shed load.
+	Backoff func(context.Context) time.Duration
 }
 
 // Respond validates a request and returns the appropriate response message.
@@ -161,18 +279,19 @@ func (s *TO1Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 		respType = protocol.TO1RVRedirectMsgType
 		resp, err = s.rvRedirect(ctx, msg)
 	}
+	s.Stats.count(msgType, err)
 	if err == nil {
 		return respType, resp
 	}
 
-	// Default to error code 500, error message of err parameter, and timestamp
-	// of the current time
+	// Default to error code 500, structured error message of err parameter, and
+	// timestamp of the current time
 	errMsg := errMsgFromContext(ctx)
 	if errMsg.Code == 0 {
 		errMsg.Code = protocol.InternalServerErrCode
 	}
 	if errMsg.ErrString == "" {
-		errMsg.ErrString = err.Error()
+		errMsg.ErrString = protocol.NewErrorDetail(errMsg.Code, errMsg.PrevMsgType, err).String()
 	}
 	if errMsg.Timestamp == 0 {
 		errMsg.Timestamp = time.Now().Unix()
@@ -186,14 +305,32 @@ type TO2Server struct {
 	Vouchers  OwnerVoucherPersistentState
 	OwnerKeys OwnerKeyPersistentState
 
+	// X5ChainOwnerKey, when true, sends owner public keys as an X5Chain
+	// whenever the owner key has a certificate chain, regardless of the
+	// encoding of the voucher's manufacturer key. Replacement vouchers are
+	// owned by the X5Chain as well. Devices which only trust owners
+	// certified by a CA require it.
+	X5ChainOwnerKey bool
+
 	// Choose the replacement rendezvous directives based on the current
 	// voucher of the onboarding device.
 	RvInfo func(context.Context, Voucher) ([][]protocol.RvInstruction, error)
 
 	// Create an iterator of service info modules for a given device. The
 	// iterator returns the name of the module and its implementation.
+	//
+	// OwnerModules is not used if ServiceInfo is set.
 	OwnerModules func(ctx context.Context, replacementGUID protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, modules []string) iter.Seq2[string, serviceinfo.OwnerModule]
 
+	// ServiceInfo, if not nil, chooses the service info modules and their
+	// parameters for each device, in place of OwnerModules.
+	ServiceInfo ServiceInfoResolver
+
+	// Devmods, if not nil, stores the devmod service info of each device once
+	// received, under both its current and replacement GUID. Failing to store
+	// it fails TO2.
+	Devmods DevmodPersistentState
+
 	// ReuseCredential, if not nil, will be called to determine whether to
 	// apply the Credential Reuse Protocol based on the current voucher of an
 	// onboarding device.
@@ -207,13 +344,115 @@ type TO2Server struct {
 	// with zero extensions.
 	VerifyVoucher func(context.Context, Voucher) error
 
-	// Server affinity state
-	nextModule func() (string, serviceinfo.OwnerModule, bool)
-	stop       func()
-	plugins    map[string]plugin.Module
+	// DeviceCertPolicy, if not nil, validates the device certificate chain of
+	// the voucher before VerifyVoucher is called. Chains which fail the policy
+	// cause TO2 to fail with a not found status code, unless the policy is
+	// report-only. The result is available to VerifyVoucher with
+	// DeviceCertResultFromContext.
+	DeviceCertPolicy *DeviceCertPolicy
+
+	// CryptoProfile restricts the device attestation signature algorithms,
+	// key exchange and cipher suites, and voucher algorithms which devices
+	// may use to onboard. If nil, DefaultCryptoProfile is used.
+	CryptoProfile *CryptoProfile
+
+	// Notary, if set, countersigns the entry added to vouchers by Resell.
+	Notary crypto.Signer
+
+	// DenyList, if not nil, is checked by TO2.HelloDevice so that denied
+	// devices cannot onboard.
+	DenyList DeviceDenyListPersistentState
+
+	// History, if not nil, records the start, completion, and failure of TO2
+	// for each device, including attempts by denied devices.
+	History OnboardingHistoryPersistentState
+
+	// HelloDevices, if not nil, records the TO2.HelloDevice message of each
+	// session as received, including those which fail, so that negotiation
+	// failures may be debugged.
+	HelloDevices HelloDevicePersistentState
+
+	// Reonboarding determines whether TO2.HelloDevice accepts a device which
+	// already completed TO2 with the same GUID, according to the History,
+	// such as a device with a cloned credential. It has no effect if History
+	// is nil.
+	Reonboarding ReonboardPolicy
+
+	// DeviceStatus, if not nil, records each device as onboarding when TO2
+	// starts, as onboarded when it completes, and as resold by Resell. A
+	// replacement GUID is recorded as onboarded.
+	DeviceStatus DeviceStatusPersistentState
+
+	// ModuleState, if not nil, persists the progress of owner modules which
+	// implement serviceinfo.ResumableOwnerModule, so that they may resume if
+	// a device reconnects after TO2 is interrupted.
+	ModuleState ModuleStatePersistentState
+
+	// Compression algorithms offered to devices for service info, in order
+	// of preference. See CompressionModuleName.
+	Compression []ServiceInfoCompression
+
+	// InterleaveModules produces service info from each unfinished owner
+	// module in turn, rather than running each module to completion before
+	// starting the next, so that a slow module, such as one waiting on a
+	// long-running command, does not block unrelated modules. Device service
+	// info is routed to modules by name.
+	//
+	// Modules must not depend on the order in which other modules run. A
+	// module continues without interleaving while it blocks the peer, and
+	// devmod always completes first.
+	InterleaveModules bool
+
+	// ModuleTimeouts limits the time owner modules may take to handle and
+	// produce service info.
+	ModuleTimeouts ModuleTimeouts
+
+	// Service info state of each session
+	modules moduleSessions
 
 	// Optional configuration
 	MaxDeviceServiceInfoSize uint16
+
+	// MaxSessions limits the number of concurrent in-flight TO2 sessions.
+	// When the limit is reached, TO2.HelloDevice fails until a session ends
+	// or expires. If zero, the number of sessions is not limited.
+	//
+	// Session tracking requires that Session also implements
+	// protocol.TokenService, as is usual for server state implementations.
+	// Tracking is in memory, so each server instance enforces its own limit.
+	MaxSessions int
+
+	// SessionTimeout is the maximum time between messages of a TO2 session.
+	// When exceeded, the session is invalidated, releasing its state. If
+	// zero, sessions do not expire, unless MaxSessions is set, in which case
+	// DefaultTO2SessionTimeout is used.
+	//
+	// The owner modules of a device which stops sending service info are
+	// stopped once the timeout is exceeded, or DefaultTO2SessionTimeout if
+	// sessions do not expire, whether or not sessions are tracked.
+	SessionTimeout time.Duration
+	sessions       to2Sessions
+
+	// ServiceInfoLimits limits the rate and size of service info exchanged
+	// with devices. Rates apply to all sessions of the server instance
+	// combined, while quotas apply to each session.
+	ServiceInfoLimits ServiceInfoLimits
+	serviceInfo       serviceInfoLimiter
+
+	// Nonces, if not nil, validates the nonce of the TO2.ProveDevice EAT
+	// against a store shared by all replicas, so that a proof cannot be
+	// replayed to another replica. Nonces expire after NonceTTL, or
+	// DefaultNonceTTL if zero.
+	Nonces   NonceStore
+	NonceTTL time.Duration
+
+	// Rand is the source of randomness for nonces, replacement GUIDs, and key
+	// exchange parameters. If nil, crypto/rand.Reader is used.
+	//
+	// A deterministic source should only be used for testing, such as
+	// replaying test vectors. Signatures and message encryption always use
+	// crypto/rand.
+	Rand io.Reader
 }
 
 // Resell implements the FDO Resale Protocol by removing a voucher from
@@ -226,12 +465,13 @@ func (s *TO2Server) Resell(ctx context.Context, guid protocol.GUID, nextOwner cr
 		return nil, fmt.Errorf("error untracking voucher for resale: %w", err)
 	}
 
-	// Get current owner key
-	ownerPubKey := ov.Header.Val.ManufacturerKey
-	if len(ov.Entries) > 0 {
-		ownerPubKey = ov.Entries[len(ov.Entries)-1].Payload.Val.PublicKey
+	// Get the owner key of the voucher, which may be a previous owner key
+	ownerPubKey, err := ov.OwnerPublicKey()
+	if err != nil {
+		_ = s.Vouchers.AddVoucher(ctx, ov)
+		return nil, fmt.Errorf("error parsing owner public key from voucher: %w", err)
 	}
-	ownerKey, _, err := s.OwnerKeys.OwnerKey(ownerPubKey.Type)
+	ownerKey, _, err := OwnerKeyFor(ctx, s.OwnerKeys, ov.Header.Val.ManufacturerKey.Type, ownerPubKey)
 	if err != nil {
 		_ = s.Vouchers.AddVoucher(ctx, ov)
 		return nil, fmt.Errorf("error getting key used to sign voucher: %w", err)
@@ -253,6 +493,13 @@ func (s *TO2Server) Resell(ctx context.Context, guid protocol.GUID, nextOwner cr
 		_ = s.Vouchers.AddVoucher(ctx, ov)
 		return nil, fmt.Errorf("error extending voucher to new owner: %w", err)
 	}
+	if s.Notary != nil {
+		if extended, err = CounterSignVoucher(extended, s.Notary); err != nil {
+			_ = s.Vouchers.AddVoucher(ctx, ov)
+			return nil, err
+		}
+	}
+	updateDeviceStatus(ctx, s.DeviceStatus, guid, DeviceResold)
 
 	return extended, nil
 }
@@ -264,12 +511,26 @@ func (s *TO2Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 	ctx = contextWithErrMsg(ctx)
 	captureMsgType(ctx, msgType)
 
+	// Count the message as in flight for Shutdown
+	s.sessions.inflight.Add(1)
+	defer s.sessions.inflight.Add(-1)
+
+	// Expire inactive sessions and owner modules before handling the message
+	var token string
+	if s.tracksSessions() {
+		token = s.beginMessage(ctx)
+	} else {
+		s.expireIdle()
+	}
+
 	// Handle each message type
 	var err error
 	switch msgType {
 	case protocol.TO2HelloDeviceMsgType:
 		respType = protocol.TO2ProveOVHdrMsgType
-		resp, err = s.proveOVHdr(ctx, msg)
+		if err = s.checkSessionLimit(token); err == nil {
+			resp, err = s.proveOVHdr(ctx, msg)
+		}
 	case protocol.TO2GetOVNextEntryMsgType:
 		respType = protocol.TO2OVNextEntryMsgType
 		resp, err = s.ovNextEntry(ctx, msg)
@@ -286,35 +547,20 @@ func (s *TO2Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 		respType = protocol.TO2Done2MsgType
 		resp, err = s.to2Done2(ctx, msg)
 	}
+	s.recordOnboarding(ctx, msgType, err)
+	s.recordDeviceStatus(ctx, msgType, err)
+	if s.tracksSessions() {
+		s.endMessage(ctx, token, err != nil || msgType == protocol.TO2DoneMsgType)
+	}
 
-	// Stop any running plugins if TO2 ended (possibly by error)
+	// Stop owner modules and counting service info and release the voucher if
+	// TO2 ended (possibly by error)
 	if (msgType == protocol.TO2DeviceServiceInfoMsgType && err != nil) || msgType == protocol.TO2DoneMsgType {
-		// Close owner module iterator
-		s.stop()
-
-		// Start goroutines to gracefully/forcefully stop plugins. Stopping is
-		// given an absolute timeout not tied to the expiration of the request
-		// context.
-		pluginStopCtx, _ := context.WithTimeout(context.Background(), 5*time.Second) //nolint:govet
-		for name, p := range s.plugins {
-			pluginGracefulStopCtx, done := context.WithCancel(pluginStopCtx)
-
-			// Allow Graceful stop up to the original shared timeout
-			go func(p plugin.Module) {
-				defer done()
-				if err := p.GracefulStop(pluginGracefulStopCtx); err != nil && !errors.Is(err, context.Canceled) { //nolint:revive,staticcheck
-					slog.Warn("graceful stop failed", "module", name, "error", err)
-				}
-			}(p)
-
-			// Force stop after the shared timeout expires or graceful stop
-			// completes
-			go func(p plugin.Module) {
-				<-pluginGracefulStopCtx.Done()
-				_ = p.Stop()
-				// TODO: Track state for whether plugins are still stopping
-			}(p)
-		}
+		s.endModules(ctx)
+	}
+	if err != nil || msgType == protocol.TO2DoneMsgType {
+		s.endServiceInfo(ctx)
+		s.releaseVoucher(ctx)
 	}
 
 	// Return response on success
@@ -322,14 +568,14 @@ func (s *TO2Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 		return respType, resp
 	}
 
-	// Default to error code 500, error message of err parameter, and timestamp
-	// of the current time
+	// Default to error code 500, structured error message of err parameter, and
+	// timestamp of the current time
 	errMsg := errMsgFromContext(ctx)
 	if errMsg.Code == 0 {
 		errMsg.Code = protocol.InternalServerErrCode
 	}
 	if errMsg.ErrString == "" {
-		errMsg.ErrString = err.Error()
+		errMsg.ErrString = protocol.NewErrorDetail(errMsg.Code, errMsg.PrevMsgType, err).String()
 	}
 	if errMsg.Timestamp == 0 {
 		errMsg.Timestamp = time.Now().Unix()
@@ -337,8 +583,33 @@ func (s *TO2Server) Respond(ctx context.Context, msgType uint8, msg io.Reader) (
 	return protocol.ErrorMsgType, errMsg
 }
 
+var (
+	_ protocol.CryptResponder    = (*TO2Server)(nil)
+	_ protocol.CryptSessionSaver = (*TO2Server)(nil)
+	_ protocol.SessionEnder      = (*TO2Server)(nil)
+	_ protocol.Shutdowner        = (*TO2Server)(nil)
+)
+
 // CryptSession returns the current encryption session.
 func (s *TO2Server) CryptSession(ctx context.Context) (kex.Session, error) {
 	_, sess, err := s.Session.XSession(ctx)
 	return sess, err
 }
+
+// SaveCryptSession persists the encryption session after it has been used to
+// encrypt or decrypt a message, so that session key usage limits are enforced
+// across messages.
+func (s *TO2Server) SaveCryptSession(ctx context.Context, sess kex.Session) error {
+	suite, _, err := s.Session.XSession(ctx)
+	if err != nil {
+		return err
+	}
+	prevToken := s.token(ctx)
+	if err := s.Session.SetXSession(ctx, suite, sess); err != nil {
+		return err
+	}
+	if s.tracksSessions() {
+		s.renameSession(prevToken, s.token(ctx))
+	}
+	return nil
+}
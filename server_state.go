@@ -13,6 +13,7 @@ import (
 	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 )
 
 /*
@@ -146,6 +147,29 @@ type RendezvousBlobPersistentState interface {
 	RVBlob(context.Context, protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *Voucher, error)
 }
 
+// RVBlobInfo describes a registered rendezvous blob.
+type RVBlobInfo struct {
+	GUID    protocol.GUID
+	Voucher *Voucher
+	Expires time.Time
+}
+
+// RendezvousBlobAdminState is optionally implemented by rendezvous blob state
+// so that operators may inspect and manage registrations.
+type RendezvousBlobAdminState interface {
+	// ListRVBlobs returns all unexpired rendezvous blob registrations,
+	// ordered by GUID.
+	ListRVBlobs(context.Context) ([]RVBlobInfo, error)
+
+	// RVBlobRegistration returns the unexpired rendezvous blob registration
+	// of a device. If none exists, ErrNotFound is returned.
+	RVBlobRegistration(context.Context, protocol.GUID) (*RVBlobInfo, error)
+
+	// RemoveRVBlob deletes the rendezvous blob registration of a device. If
+	// none exists, ErrNotFound is returned.
+	RemoveRVBlob(context.Context, protocol.GUID) error
+}
+
 // OwnerKeyPersistentState maintains the owner service keys.
 type OwnerKeyPersistentState interface {
 	// OwnerKey returns the private key matching a given key type and optionally
@@ -153,6 +177,63 @@ type OwnerKeyPersistentState interface {
 	OwnerKey(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)
 }
 
+// OwnerKeyRotationPersistentState is optionally implemented by
+// OwnerKeyPersistentState to keep previous owner keys valid after a new key
+// becomes current, so that vouchers extended to a previous key may still be
+// used until they are extended to the current key.
+type OwnerKeyRotationPersistentState interface {
+	// RotateOwnerKey makes a key the current key of its type, returned by
+	// OwnerKey, keeping any previous current key as a previous key. chain may
+	// be nil.
+	RotateOwnerKey(context.Context, protocol.KeyType, crypto.Signer, []*x509.Certificate) error
+
+	// PreviousOwnerKeys returns the previous keys of a type, most recently
+	// rotated first.
+	PreviousOwnerKeys(context.Context, protocol.KeyType) ([]PreviousOwnerKey, error)
+
+	// RemovePreviousOwnerKeys deletes the previous keys of a type which were
+	// rotated before the given time.
+	RemovePreviousOwnerKeys(context.Context, protocol.KeyType, time.Time) error
+}
+
+// PreviousOwnerKey is an owner key which is no longer current.
+type PreviousOwnerKey struct {
+	Key     crypto.Signer
+	Chain   []*x509.Certificate
+	Rotated time.Time
+}
+
+// OwnerVoucherListPersistentState is optionally implemented by
+// OwnerVoucherPersistentState to list owned vouchers.
+type OwnerVoucherListPersistentState interface {
+	// VoucherGUIDs returns the GUIDs of all owned vouchers.
+	VoucherGUIDs(context.Context) ([]protocol.GUID, error)
+}
+
+// ErrVoucherInUse is used when an owned voucher is not replaced because it is
+// leased by a TO2 session or was replaced since it was read.
+var ErrVoucherInUse = fmt.Errorf("voucher is in use")
+
+// OwnerVoucherLeasePersistentState is optionally implemented by
+// OwnerVoucherPersistentState to record which vouchers are in use by TO2
+// sessions, so that a voucher is not extended while a session of its device,
+// possibly handled by another replica sharing the state, relies on it. Leases
+// expire, so that devices which stop sending messages do not hold their
+// voucher forever.
+type OwnerVoucherLeasePersistentState interface {
+	// LeaseVoucher marks the voucher of a device in use until the given
+	// time, replacing any previous lease.
+	LeaseVoucher(context.Context, protocol.GUID, time.Time) error
+
+	// ReleaseVoucher ends the lease of the voucher of a device, if any.
+	ReleaseVoucher(context.Context, protocol.GUID) error
+
+	// ReplaceUnleasedVoucher atomically replaces the voucher of a device
+	// with next, only if it has no unexpired lease and is still equal to
+	// prev. Otherwise, ErrVoucherInUse is returned.
+	ReplaceUnleasedVoucher(ctx context.Context, guid protocol.GUID, prev, next *Voucher) error
+}
+
 // ManufacturerVoucherPersistentState maintains vouchers created during DI
 // which have not yet been extended.
 type ManufacturerVoucherPersistentState interface {
@@ -162,6 +243,49 @@ type ManufacturerVoucherPersistentState interface {
 	NewVoucher(context.Context, *Voucher) error
 }
 
+// ManufacturerKeysPersistentState maintains several manufacturer keys per key
+// type, i.e. one per product line, so that keys may be added and rotated
+// without downtime.
+type ManufacturerKeysPersistentState interface {
+	// ManufacturerKeys returns all manufacturer keys of a type, most recently
+	// added first.
+	ManufacturerKeys(context.Context, protocol.KeyType) ([]ManufacturerKey, error)
+}
+
+// ManufacturerKey is a named manufacturer key and its certificate chain.
+type ManufacturerKey struct {
+	Name  string
+	Type  protocol.KeyType
+	Key   crypto.Signer
+	Chain []*x509.Certificate
+}
+
+// VoucherManufacturerKeyPersistentState is optionally implemented by
+// ManufacturerVoucherPersistentState to record which manufacturer key signed
+// each voucher.
+type VoucherManufacturerKeyPersistentState interface {
+	// SetVoucherManufacturerKey records the name of the manufacturer key which
+	// signed a voucher.
+	SetVoucherManufacturerKey(context.Context, protocol.GUID, string) error
+
+	// VoucherManufacturerKey returns the name of the manufacturer key which
+	// signed a voucher. If none was recorded, ErrNotFound is returned.
+	VoucherManufacturerKey(context.Context, protocol.GUID) (string, error)
+}
+
+// VoucherMetadataPersistentState is optionally implemented by
+// ManufacturerVoucherPersistentState to store metadata alongside each voucher,
+// such as serial numbers or plant identifiers.
+type VoucherMetadataPersistentState interface {
+	// SetVoucherMetadata stores the metadata of a voucher, replacing any
+	// previously stored.
+	SetVoucherMetadata(context.Context, protocol.GUID, map[string]string) error
+
+	// VoucherMetadata returns the metadata of a voucher. If none was stored,
+	// ErrNotFound is returned.
+	VoucherMetadata(context.Context, protocol.GUID) (map[string]string, error)
+}
+
 // OwnerVoucherPersistentState maintains vouchers owned by the service.
 type OwnerVoucherPersistentState interface {
 	// AddVoucher stores the voucher of a device owned by the service.
@@ -181,6 +305,160 @@ type OwnerVoucherPersistentState interface {
 
 // The following types are for optional server features.
 
+// ModuleStatePersistentState maintains the progress of owner service info
+// modules for each device, so that a device reconnecting after TO2 is
+// interrupted does not restart service info from the beginning.
+type ModuleStatePersistentState interface {
+	// SetModuleState stores the state of an owner service info module for
+	// the device with the given (current voucher) GUID.
+	SetModuleState(ctx context.Context, guid protocol.GUID, module string, state []byte) error
+
+	// ModuleState returns the state of an owner service info module for a
+	// device. If no state has been stored, ErrNotFound is returned.
+	ModuleState(ctx context.Context, guid protocol.GUID, module string) ([]byte, error)
+
+	// RemoveModuleStates removes the state of all owner service info modules
+	// for a device. It is called when TO2 completes.
+	RemoveModuleStates(ctx context.Context, guid protocol.GUID) error
+}
+
+// DeviceDevmod is the devmod service info sent by a device during TO2.
+type DeviceDevmod struct {
+	Devmod serviceinfo.Devmod
+
+	// Modules are the names of the service info modules the device supports.
+	Modules []string
+
+	// Time is when the devmod service info was received.
+	Time time.Time
+}
+
+// DevmodPersistentState stores the devmod service info of each device, so
+// that owner services may use it after TO2, i.e. to choose payloads by device
+// architecture.
+type DevmodPersistentState interface {
+	// SetDevmod stores the devmod service info of the device with the given
+	// GUID, replacing any previous.
+	SetDevmod(ctx context.Context, guid protocol.GUID, devmod DeviceDevmod) error
+
+	// Devmod returns the devmod service info of a device. If none has been
+	// stored, ErrNotFound is returned.
+	Devmod(ctx context.Context, guid protocol.GUID) (*DeviceDevmod, error)
+}
+
+// TO0RegistrationPersistentState tracks when the rendezvous blob of each
+// owned voucher lapses with each Rendezvous Server, so that it may be
+// registered again in time.
+type TO0RegistrationPersistentState interface {
+	// SetTO0Registration stores the most recent rendezvous blob registration
+	// of a device with a Rendezvous Server, replacing any previous one with
+	// the same GUID and RvURL.
+	SetTO0Registration(context.Context, TO0Registration) error
+
+	// TO0Registrations returns the most recent rendezvous blob registration
+	// of a device with each Rendezvous Server, ordered by RefreshAt. If none
+	// have been stored, the result is empty.
+	TO0Registrations(context.Context, protocol.GUID) ([]TO0Registration, error)
+
+	// LapsingTO0Registrations returns the registrations of owned vouchers
+	// which should be refreshed before the given time, ordered by RefreshAt.
+	LapsingTO0Registrations(ctx context.Context, before time.Time) ([]TO0Registration, error)
+}
+
+// OnboardingHistoryPersistentState records the onboarding events of devices
+// so that operators may follow their progress and errors.
+type OnboardingHistoryPersistentState interface {
+	// AddOnboardingEvent appends an event to the history of a device.
+	AddOnboardingEvent(context.Context, OnboardingEvent) error
+
+	// OnboardingHistory returns the events of a device, oldest first. If none
+	// have been recorded, the result is empty.
+	OnboardingHistory(context.Context, protocol.GUID) ([]OnboardingEvent, error)
+
+	// LatestOnboardingEvents returns the most recent event of each device
+	// whose most recent event has the given state, oldest first.
+	LatestOnboardingEvents(context.Context, OnboardingState) ([]OnboardingEvent, error)
+}
+
+// ProtocolTracePersistentState stores the trace of each message handled by a
+// protocol.Dispatcher, so that operators may find where the sessions of a
+// device failed.
+type ProtocolTracePersistentState interface {
+	protocol.Tracer
+
+	// SessionTrace returns the traced messages with a correlation ID, oldest
+	// first. If none have been recorded, the result is empty.
+	SessionTrace(ctx context.Context, correlationID uint) ([]protocol.TraceEvent, error)
+
+	// DeviceTrace returns the traced messages of every session in which a
+	// message was associated with the device GUID, oldest first. If none have
+	// been recorded, the result is empty.
+	DeviceTrace(context.Context, protocol.GUID) ([]protocol.TraceEvent, error)
+}
+
+// HelloDevicePersistentState stores the TO2.HelloDevice message of each TO2
+// session as received, so that operators may debug the negotiation of key
+// exchange, cipher suite, and signature info with devices.
+type HelloDevicePersistentState interface {
+	// AddHelloDevice records the HelloDevice message of a session.
+	AddHelloDevice(context.Context, HelloDeviceRecord) error
+
+	// HelloDevices returns the recorded messages of a device, oldest first.
+	// If none have been recorded, the result is empty.
+	HelloDevices(context.Context, protocol.GUID) ([]HelloDeviceRecord, error)
+
+	// SessionHelloDevice returns the recorded message of the session with a
+	// correlation ID. If none was recorded, ErrNotFound is returned.
+	SessionHelloDevice(ctx context.Context, correlationID uint) (*HelloDeviceRecord, error)
+}
+
+// DeviceStatusPersistentState tracks the lifecycle state of each device, from
+// DI through onboarding and resale, so that operators may query where each
+// device is. It may be shared by manufacturer, rendezvous, and owner services.
+type DeviceStatusPersistentState interface {
+	// UpdateDeviceStatus moves a device to a lifecycle state at the given
+	// time. If the current state of the device cannot transition to it, then
+	// ErrInvalidStatusTransition is returned and the status is unchanged.
+	UpdateDeviceStatus(ctx context.Context, guid protocol.GUID, state DeviceLifecycle, at time.Time) error
+
+	// DeviceStatus returns the lifecycle state of a device. If none has been
+	// recorded, ErrNotFound is returned.
+	DeviceStatus(context.Context, protocol.GUID) (*DeviceStatus, error)
+
+	// DevicesByStatus returns the status of each device currently in the
+	// given state, ordered by the time it entered the state.
+	DevicesByStatus(context.Context, DeviceLifecycle) ([]DeviceStatus, error)
+}
+
+// DeviceDenyListPersistentState tracks devices which are not allowed to
+// onboard, i.e. because they were revoked.
+type DeviceDenyListPersistentState interface {
+	// DenyDevice adds a device to the deny list. Denying a device twice is
+	// not an error.
+	DenyDevice(context.Context, protocol.GUID) error
+
+	// AllowDevice removes a device from the deny list. If the device is not
+	// denied, ErrNotFound is returned.
+	AllowDevice(context.Context, protocol.GUID) error
+
+	// DeviceDenied returns whether a device is on the deny list.
+	DeviceDenied(context.Context, protocol.GUID) (bool, error)
+}
+
+// NonceStore records the nonces issued by TO0, TO1, and TO2 servers, so that
+// a nonce may be validated by any replica and used at most once, even when
+// session state is not shared or is held by the client, i.e. in a token.
+type NonceStore interface {
+	// AddNonce records a newly issued nonce, which is valid until the given
+	// expiration time.
+	AddNonce(ctx context.Context, nonce protocol.Nonce, expires time.Time) error
+
+	// ConsumeNonce removes a nonce so that it cannot be used again. If the
+	// nonce was never added, was already consumed, or has expired,
+	// ErrNotFound is returned.
+	ConsumeNonce(context.Context, protocol.Nonce) error
+}
+
 // AutoExtend provides the necessary methods for automatically extending a
 // device voucher upon the completion of DI.
 type AutoExtend interface {
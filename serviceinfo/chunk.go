@@ -4,7 +4,6 @@
 package serviceinfo
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -27,6 +26,38 @@ type pipeWriter interface {
 	CloseWithError(error) error
 }
 
+// ownedWriter is implemented by pipes which can take ownership of written
+// bytes rather than copying them.
+type ownedWriter interface {
+	// writeOwned writes p, which must not be modified afterward.
+	writeOwned(p []byte) error
+}
+
+// chunkBufSize is the minimum capacity of pooled buffers, so that buffers may
+// be reused for chunks of any size up to the default MTU.
+const chunkBufSize = DefaultMTU
+
+// chunkBufPool holds buffers used for service info data in pipes and for the
+// values of KVs returned by ChunkReader.ReadChunk. Each buffer has exactly one
+// owner at a time, which either passes it on or returns it to the pool.
+var chunkBufPool sync.Pool
+
+// getChunkBuf returns an empty buffer with at least the given capacity. The
+// caller owns the buffer.
+func getChunkBuf(size int) []byte {
+	if buf, ok := chunkBufPool.Get().(*[]byte); ok && cap(*buf) >= size {
+		return (*buf)[:0]
+	}
+	return make([]byte, 0, max(size, chunkBufSize))
+}
+
+// putChunkBuf returns a buffer to the pool. The caller must not use the buffer
+// afterward.
+func putChunkBuf(buf []byte) {
+	buf = buf[:0]
+	chunkBufPool.Put(&buf)
+}
+
 func bufferedPipe() (pipeReader, pipeWriter) {
 	pipe := &bufPipe{
 		ch: make(chan struct{}, 1),
@@ -34,19 +65,30 @@ func bufferedPipe() (pipeReader, pipeWriter) {
 	return pipe, pipe
 }
 
+// bufPipe is an unbounded in-memory pipe. Written data is kept as a queue of
+// segments, which are either copied into pooled buffers owned by the pipe or,
+// when written with writeOwned, referenced directly.
 type bufPipe struct {
 	sync.Mutex
-	buf bytes.Buffer
+	segs []pipeSegment
 
 	err error
 	ch  chan struct{}
 }
 
+// pipeSegment is data written to a bufPipe. Pooled segments are returned to
+// chunkBufPool once read.
+type pipeSegment struct {
+	buf    []byte
+	off    int
+	pooled bool
+}
+
 func (b *bufPipe) Read(p []byte) (int, error) {
 	for {
 		b.Lock()
-		if b.buf.Len() > 0 {
-			n, _ := b.buf.Read(p)
+		if len(b.segs) > 0 {
+			n := b.read(p)
 			b.Unlock()
 			return n, nil
 		}
@@ -58,6 +100,26 @@ func (b *bufPipe) Read(p []byte) (int, error) {
 	}
 }
 
+// read copies as much of the queued data into p as fits, releasing fully read
+// segments. The lock must be held.
+func (b *bufPipe) read(p []byte) (n int) {
+	for n < len(p) && len(b.segs) > 0 {
+		seg := &b.segs[0]
+		copied := copy(p[n:], seg.buf[seg.off:])
+		n += copied
+		seg.off += copied
+		if seg.off < len(seg.buf) {
+			break
+		}
+		if seg.pooled {
+			putChunkBuf(seg.buf)
+		}
+		b.segs[0] = pipeSegment{}
+		b.segs = b.segs[1:]
+	}
+	return n
+}
+
 func (b *bufPipe) Write(p []byte) (int, error) {
 	b.Lock()
 	defer b.Unlock()
@@ -65,12 +127,47 @@ func (b *bufPipe) Write(p []byte) (int, error) {
 	if b.err != nil {
 		return 0, io.ErrClosedPipe
 	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b.signal()
+
+	// Append to the last segment if it is owned by the pipe and has room,
+	// so that many small writes do not each use a buffer
+	if n := len(b.segs); n > 0 {
+		if last := &b.segs[n-1]; last.pooled && cap(last.buf)-len(last.buf) >= len(p) {
+			last.buf = append(last.buf, p...)
+			return len(p), nil
+		}
+	}
 
+	// The writer retains ownership of p, so it must be copied
+	buf := append(getChunkBuf(len(p)), p...)
+	b.segs = append(b.segs, pipeSegment{buf: buf, pooled: true})
+	return len(p), nil
+}
+
+func (b *bufPipe) writeOwned(p []byte) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.err != nil {
+		return io.ErrClosedPipe
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	b.signal()
+	b.segs = append(b.segs, pipeSegment{buf: p})
+	return nil
+}
+
+// signal wakes a blocked reader. The lock must be held.
+func (b *bufPipe) signal() {
 	select {
 	case b.ch <- struct{}{}:
 	default:
 	}
-	return b.buf.Write(p)
 }
 
 func (b *bufPipe) Close() error { return b.CloseWithError(io.EOF) }
@@ -92,12 +189,14 @@ type ChunkReader struct {
 	r       pipeReader
 	rkey    cbor.RawBytes
 	key     string
-	buffer  []byte
 }
 
 // ReadChunk reads ServiceInfo chunked at some MTU. The values contain any
 // number of logical ServiceInfos. When no more ServiceInfo will be available,
 // an io.EOF error is returned.
+//
+// The value of the returned KV is a pooled buffer owned by the caller, which
+// may return it with Release once the KV is no longer used.
 func (r *ChunkReader) ReadChunk(size uint16) (*KV, error) {
 	if r.r == nil {
 		// Get the next reader, which will be chunked into zero or more KVs
@@ -150,31 +249,26 @@ func (r *ChunkReader) ReadChunk(size uint16) (*KV, error) {
 		return nil, ErrSizeTooSmall
 	}
 
-	// Grow buffer if not large enough
-	if len(r.buffer) < int(size)-maxOverhead {
-		r.buffer = make([]byte, int(size)-maxOverhead)
-	}
-
-	// Read data, ensuring ServiceInfo will not be larger than size once
-	// marshaled to CBOR
-	n, err := io.ReadFull(r.r, r.buffer[:int(size)-maxOverhead])
+	// Read data into a pooled buffer, ensuring ServiceInfo will not be larger
+	// than size once marshaled to CBOR
+	val := getChunkBuf(int(size) - maxOverhead)[:int(size)-maxOverhead]
+	n, err := io.ReadFull(r.r, val)
 	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		r.r = nil
 		if n == 0 {
+			putChunkBuf(val)
 			return r.ReadChunk(size)
 		}
 	} else if err != nil {
+		putChunkBuf(val)
 		_ = r.r.CloseWithError(err)
 		return nil, err
 	}
 
-	// Copy read bytes from the buffer into a new byte slice for the KV
-	val := make([]byte, n)
-	copy(val, r.buffer[:n])
-
+	// Ownership of the buffer passes to the caller
 	return &KV{
 		Key: r.key,
-		Val: val,
+		Val: val[:n],
 	}, nil
 }
 
@@ -197,9 +291,14 @@ type ChunkWriter struct {
 	pipe    func() (pipeReader, pipeWriter)
 }
 
-// WriteChunk is called with chunked ServiceInfos.
+// WriteChunk is called with chunked ServiceInfos. For buffered pipes, the
+// value is passed to the UnchunkReader by reference, so it must not be
+// modified after WriteChunk returns.
 func (w *ChunkWriter) WriteChunk(kv *KV) error {
-	if kv.Key != w.prevKey {
+	if kv == nil {
+		return errors.New("service info must not be null")
+	}
+	if w.w == nil || kv.Key != w.prevKey {
 		if w.w != nil {
 			if err := w.w.Close(); err != nil {
 				return err
@@ -216,6 +315,9 @@ func (w *ChunkWriter) WriteChunk(kv *KV) error {
 		}
 	}
 
+	if ow, ok := w.w.(ownedWriter); ok {
+		return ow.writeOwned(kv.Val)
+	}
 	_, err := w.w.Write(kv.Val)
 	return err
 }
@@ -6,8 +6,10 @@ package serviceinfo_test
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
@@ -1097,3 +1099,108 @@ func TestForceNewMessageBuffered(t *testing.T) {
 		t.Fatalf("expected EOF upon reading third chunk, got: %v", err)
 	}
 }
+
+func TestChunkRoundTrip(t *testing.T) {
+	payload := make([]byte, 64*1024)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	got, err := chunkRoundTrip(payload, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("round tripped payload [len=%d] did not match", len(got))
+	}
+}
+
+func TestChunkRelease(t *testing.T) {
+	read := func(payload []byte) []byte {
+		r := chunkOut(payload)
+		defer func() { _ = r.Close() }()
+		var got []byte
+		for {
+			kv, err := r.ReadChunk(1024)
+			if errors.Is(err, io.EOF) {
+				return got
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, kv.Val...)
+			kv.Release()
+			if kv.Val != nil {
+				t.Fatal("expected released value to be nil")
+			}
+		}
+	}
+
+	// Released buffers are reused, so reading a second payload must not
+	// corrupt data copied from the first
+	payload1 := bytes.Repeat([]byte{0x5a}, 64*1024)
+	payload2 := bytes.Repeat([]byte{0xa5}, 64*1024)
+	got1 := read(payload1)
+	got2 := read(payload2)
+	if !bytes.Equal(got1, payload1) {
+		t.Fatal("first payload did not match")
+	}
+	if !bytes.Equal(got2, payload2) {
+		t.Fatal("second payload did not match")
+	}
+}
+
+func BenchmarkChunkRoundTrip(b *testing.B) {
+	payload := bytes.Repeat([]byte("service info "), 1<<16)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := chunkRoundTrip(payload, serviceinfo.DefaultMTU); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// chunkOut writes a message through a buffered chunk out pipe in small
+// writes.
+func chunkOut(payload []byte) *serviceinfo.ChunkReader {
+	r, w := serviceinfo.NewChunkOutPipe(10)
+	_ = w.NextServiceInfo("moduleA", "messageB")
+	for chunk := range slices.Chunk(payload, 100) {
+		_, _ = w.Write(chunk)
+	}
+	_ = w.Close()
+	return r
+}
+
+// chunkRoundTrip passes each chunk of a message from a buffered chunk out pipe
+// to a buffered chunk in pipe and returns the unchunked message body.
+func chunkRoundTrip(payload []byte, mtu uint16) ([]byte, error) {
+	r := chunkOut(payload)
+	defer func() { _ = r.Close() }()
+
+	unchunked, unchunker := serviceinfo.NewChunkInPipe(10)
+	for {
+		kv, err := r.ReadChunk(mtu)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := unchunker.WriteChunk(kv); err != nil {
+			return nil, err
+		}
+	}
+	if err := unchunker.Close(); err != nil {
+		return nil, err
+	}
+
+	key, body, ok := unchunked.NextServiceInfo()
+	if !ok {
+		return nil, errors.New("expected a service info")
+	}
+	if key != "moduleA:messageB" {
+		return nil, fmt.Errorf("unexpected key %q", key)
+	}
+	return io.ReadAll(body)
+}
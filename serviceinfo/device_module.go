@@ -36,8 +36,10 @@ type MTUKey struct{}
 // messages. Sends only occur once the peer has stopped indicating
 // IsMoreServiceInfo.
 //
-// Any error returned will cause an ErrorMessage to be sent and TO2 will fail.
-// If a warning should be logged, this must be done within the handler.
+// Any error returned will cause an ErrorMessage to be sent and TO2 will fail,
+// unless it is a recoverable ModuleError, in which case the error is reported
+// to the owner module and TO2 continues without the module. If a warning
+// should be logged, this must be done within the handler.
 type DeviceModule interface {
 	// Transition sets the state of the module to active or inactive. Receive
 	// and Respond will not be called unless Transition has been called at
@@ -91,17 +91,13 @@ type Devmod struct {
 	MudURL  string `devmod:"mudurl"`
 }
 
-// Write the devmod messages.
-func (d *Devmod) Write(ctx context.Context, deviceModules map[string]DeviceModule, mtu uint16, w *UnchunkWriter) {
+// Write the devmod messages. The modules list contains each module of the
+// registry in sorted order.
+func (d *Devmod) Write(ctx context.Context, deviceModules *Registry, mtu uint16, w *UnchunkWriter) {
 	defer func() { _ = w.Close() }()
 
-	var modules []string
-	for key := range deviceModules {
-		module, _, _ := strings.Cut(key, ":")
-		modules = append(modules, module)
-	}
-
-	if custom, hasCustom := deviceModules[devmodModuleName]; hasCustom {
+	modules := deviceModules.Names()
+	if custom, _, hasCustom := deviceModules.Module(devmodModuleName); hasCustom {
 		if err := custom.Transition(true); err != nil {
 			_ = w.CloseWithError(err)
 			return
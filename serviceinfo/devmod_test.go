@@ -32,7 +32,7 @@ func TestDevmodRequired(t *testing.T) {
 			r, w := serviceinfo.NewChunkOutPipe(0)
 			defer func() { _ = w.Close() }()
 
-			go devmod.Write(context.Background(), map[string]serviceinfo.DeviceModule{}, mtu, w)
+			go devmod.Write(context.Background(), new(serviceinfo.Registry), mtu, w)
 
 			for {
 				_, err := r.ReadChunk(mtu)
@@ -66,11 +66,11 @@ func TestDevmod(t *testing.T) {
 	defer func() { _ = w.Close() }()
 
 	mtu := uint16(40)
-	go devmod.Write(context.Background(), map[string]serviceinfo.DeviceModule{
+	go devmod.Write(context.Background(), serviceinfo.NewRegistry(map[string]serviceinfo.DeviceModule{
 		"unit-test1": nil,
 		"unit-test2": nil,
 		"unit-test3": nil,
-	}, mtu, w)
+	}), mtu, w)
 	var chunks []*serviceinfo.KV
 	for {
 		chunk, err := r.ReadChunk(mtu)
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// ErrorMessageName is the name of the message which a module sends to report
+// an error to its peer, following the convention of FSIMs such as fdo.wget.
+const ErrorMessageName = "error"
+
+// ModuleError is an error of a service info module which is reported to the
+// peer module in an error message.
+//
+// A fatal error fails onboarding. A recoverable error fails only the module:
+// TO2 stops using the module, discards its further service info, and
+// continues with other modules. Returning a recoverable ModuleError from a
+// DeviceModule or OwnerModule method has this effect, and the device also
+// reports the error to the owner module.
+//
+// The message body of a fatal error is a CBOR tstr, as used by existing
+// FSIMs, and of a recoverable error the array [tstr, true].
+type ModuleError struct {
+	// Module is the name of the module. It is not encoded, but set by
+	// DecodeError and TO2.
+	Module string
+
+	Message     string
+	Recoverable bool
+
+	// Peer is set by DecodeError for an error reported by the peer module.
+	// It is not encoded. TO2 servers treat such errors as fatal even if
+	// Recoverable is set, so that a device cannot skip an owner module by
+	// reporting a recoverable error.
+	Peer bool
+}
+
+// Error implements error.
+func (e *ModuleError) Error() string {
+	kind := "failed"
+	if e.Recoverable {
+		kind = "failed recoverably"
+	}
+	if e.Module == "" {
+		return fmt.Sprintf("module %s: %s", kind, e.Message)
+	}
+	return fmt.Sprintf("module %q %s: %s", e.Module, kind, e.Message)
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (e *ModuleError) MarshalCBOR() ([]byte, error) {
+	if !e.Recoverable {
+		return cbor.Marshal(e.Message)
+	}
+	return cbor.Marshal([]any{e.Message, true})
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (e *ModuleError) UnmarshalCBOR(data []byte) error {
+	var msg string
+	if err := cbor.Unmarshal(data, &msg); err == nil {
+		e.Message, e.Recoverable = msg, false
+		return nil
+	}
+	var body struct {
+		Message     string
+		Recoverable bool
+	}
+	if err := cbor.Unmarshal(data, &body); err != nil {
+		return fmt.Errorf("invalid module error: %w", err)
+	}
+	e.Message, e.Recoverable = body.Message, body.Recoverable
+	return nil
+}
+
+// RecoverableError returns a recoverable ModuleError with the message of err.
+func RecoverableError(err error) error {
+	return &ModuleError{Message: err.Error(), Recoverable: true}
+}
+
+// IsRecoverable reports whether err is, or wraps, a recoverable ModuleError.
+func IsRecoverable(err error) bool {
+	var modErr *ModuleError
+	return errors.As(err, &modErr) && modErr.Recoverable
+}
+
+// moduleError returns err as a ModuleError. Errors which are not a
+// ModuleError are fatal.
+func moduleError(err error) *ModuleError {
+	var modErr *ModuleError
+	if !errors.As(err, &modErr) {
+		return &ModuleError{Message: err.Error()}
+	}
+	return modErr
+}
+
+// RespondError writes an error message from a device module, using the
+// respond callback of Receive or Yield. Errors which are not a ModuleError
+// are reported as fatal.
+func RespondError(respond func(message string) io.Writer, err error) error {
+	return cbor.NewEncoder(respond(ErrorMessageName)).Encode(moduleError(err))
+}
+
+// WriteError queues an error message from an owner module. Errors which are
+// not a ModuleError are reported as fatal.
+func (p *Producer) WriteError(err error) error {
+	body, err := cbor.Marshal(moduleError(err))
+	if err != nil {
+		return err
+	}
+	return p.WriteChunk(ErrorMessageName, body)
+}
+
+// DecodeError decodes the body of an error message received from the named
+// module. The result should usually be returned from HandleInfo or Receive.
+// On the device, a fatal error fails onboarding and a recoverable error fails
+// only the module. On the owner, both fail onboarding; an owner module which
+// may be skipped must instead return its own RecoverableError.
+func DecodeError(moduleName string, messageBody io.Reader) (*ModuleError, error) {
+	var modErr ModuleError
+	if err := cbor.NewDecoder(messageBody).Decode(&modErr); err != nil {
+		return nil, fmt.Errorf("error decoding message %s: %w", ErrorMessageName, err)
+	}
+	modErr.Module = moduleName
+	modErr.Peer = true
+	return &modErr, nil
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// Message is a complete service info message of a module.
+type Message struct {
+	Name string
+
+	// Body is CBOR-encoded.
+	Body []byte
+}
+
+// Turn is one turn of an InteractiveModule: the messages the device sent
+// since the previous turn and the requests to send in reply.
+type Turn struct {
+	received []Message
+	sends    []Message
+}
+
+// Received returns the messages the device sent since the previous turn, in
+// order. Consecutive messages with the same name, such as a response which
+// was split across rounds, are concatenated.
+func (t *Turn) Received() []Message { return t.received }
+
+// Send queues a message to the device. Bodies are split across rounds if they
+// do not fit the MTU, in which case the device receives them concatenated.
+func (t *Turn) Send(messageName string, body []byte) {
+	t.sends = append(t.sends, Message{Name: messageName, Body: body})
+}
+
+// InteractiveModule converses with a device module in turns of requests and
+// responses, such as a challenge and response, without tracking whether a
+// response is pending.
+type InteractiveModule interface {
+	// Converse is called once to send the first requests and then once the
+	// device has responded to the requests of the previous turn. If a turn
+	// sends no requests, the next turn is in the next round, whether or not
+	// the device sent anything.
+	//
+	// When done, the requests of the turn are still sent, but the device
+	// should not respond to them.
+	Converse(ctx context.Context, turn *Turn) (done bool, _ error)
+}
+
+// InteractiveFunc adapts a function to an InteractiveModule.
+type InteractiveFunc func(ctx context.Context, turn *Turn) (done bool, _ error)
+
+// Converse implements InteractiveModule.
+func (f InteractiveFunc) Converse(ctx context.Context, turn *Turn) (bool, error) {
+	return f(ctx, turn)
+}
+
+// Interactive adapts an InteractiveModule to an OwnerModule. The adapter
+// activates the device module, failing if it is not active, and blocks the
+// device while requests are split across rounds.
+func Interactive(m InteractiveModule) OwnerModule {
+	return &interactive{module: m}
+}
+
+type interactive struct {
+	module InteractiveModule
+
+	started  bool
+	awaiting bool
+	done     bool
+	received []Message
+	sends    []Message
+}
+
+func (i *interactive) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	if messageName == "active" {
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+	}
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(messageBody); err != nil {
+		return fmt.Errorf("error reading message %s: %w", messageName, err)
+	}
+	if i.done {
+		return nil
+	}
+	if n := len(i.received); n > 0 && i.received[n-1].Name == messageName {
+		i.received[n-1].Body = append(i.received[n-1].Body, body.Bytes()...)
+		return nil
+	}
+	i.received = append(i.received, Message{Name: messageName, Body: body.Bytes()})
+	return nil
+}
+
+func (i *interactive) ProduceInfo(ctx context.Context, producer *Producer) (blockPeer, moduleDone bool, _ error) {
+	if !i.started {
+		if err := producer.WriteChunk("active", []byte{0xf5}); err != nil {
+			return false, false, err
+		}
+		i.started = true
+	}
+
+	// Take a turn unless requests remain to be sent or a response is pending
+	if len(i.sends) == 0 && !i.done && (!i.awaiting || len(i.received) > 0) {
+		turn := &Turn{received: i.received}
+		done, err := i.module.Converse(ctx, turn)
+		if err != nil {
+			return false, false, err
+		}
+		i.received, i.sends = nil, turn.sends
+		i.awaiting, i.done = len(turn.sends) > 0, done
+	}
+
+	if err := i.flush(producer); err != nil {
+		return false, false, err
+	}
+	if len(i.sends) > 0 {
+		return true, false, nil
+	}
+	return false, i.done, nil
+}
+
+// flush writes as many queued requests as fit, splitting the last one if
+// needed.
+func (i *interactive) flush(producer *Producer) error {
+	for len(i.sends) > 0 {
+		msg := &i.sends[0]
+		rest, err := producer.WriteSplit(msg.Name, msg.Body)
+		if err != nil {
+			return err
+		}
+		if msg.Body = rest; len(rest) > 0 {
+			return nil
+		}
+		i.sends = i.sends[1:]
+	}
+	return nil
+}
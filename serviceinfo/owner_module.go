@@ -5,13 +5,25 @@ package serviceinfo
 
 import (
 	"context"
+	"fmt"
 	"io"
 )
 
 // OwnerModule implements a service info module.
+//
+// Any error returned will fail TO2, unless it is a recoverable ModuleError, in
+// which case TO2 continues without the module.
+//
+// An OwnerModule which also implements io.Closer is closed once it fails
+// recoverably or the service info exchange of its device ends, whether or not
+// it completed, so that it may release resources such as open files.
 type OwnerModule interface {
 	// HandleInfo is called once for each service info KV received from the
-	// device.
+	// device. Service info sent after the owner has moved on to another
+	// module, such as a late response, is still handled by this module.
+	//
+	// Modules which exchange requests and responses in turns should use
+	// Interactive.
 	HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error
 
 	// ProduceInfo is called once for each TO2.DeviceServiceInfo, after
@@ -26,8 +38,24 @@ type OwnerModule interface {
 	ProduceInfo(ctx context.Context, producer *Producer) (blockPeer, moduleDone bool, _ error)
 }
 
+// ResumableOwnerModule is an OwnerModule whose progress can be persisted and
+// restored, so that it may resume when a device reconnects after TO2 is
+// interrupted. The owner service persists progress only if it is configured
+// with module state storage.
+type ResumableOwnerModule interface {
+	OwnerModule
+
+	// Checkpoint returns an encoding of the progress of the module. It is
+	// called after each ProduceInfo, including when the module is done.
+	Checkpoint() ([]byte, error)
+
+	// Resume restores progress from a previous TO2 session. It is called
+	// before the module is first used, and only if progress was stored.
+	Resume(state []byte) error
+}
+
 // Producer allows an owner service info module to produce service info either
-// with auto-chunking (not yet implemented) or manually.
+// manually or, for data read from an io.Reader, with a StreamSource.
 type Producer struct {
 	moduleName string
 	mtu        uint16
@@ -47,18 +75,34 @@ func NewProducer(moduleName string, mtu uint16) *Producer {
 	}
 }
 
+// Remaining returns the number of bytes remaining in the current MTU window.
+// Each message also uses some of them for its key and the header of its body,
+// so use Available to size a message body.
+func (p *Producer) Remaining() int {
+	return int(p.mtu) - int(ArraySizeCBOR(p.info))
+}
+
 // Available returns the remaining space available for a message body in bytes.
 // If the next service info will not fit in the remaining bytes, then the
 // module should return and on the next ProduceInfo the full MTU will be
 // available.
 func (p *Producer) Available(messageName string) int {
-	return int(p.mtu) - int(ArraySizeCBOR(append(p.info, &KV{Key: p.moduleName + ":" + messageName}))) +
-		1 // 1 represents overcounting the size of the last KV, because the Val will be 1 byte
+	// The size of the next KV includes a 1 byte header for an empty body,
+	// which is replaced by the header of the actual body
+	size := int(p.mtu) - int(ArraySizeCBOR(append(p.info, &KV{Key: p.moduleName + ":" + messageName}))) + 1
+	if size < 1 {
+		return size - 1
+	}
+	return maxByteStringLen(size)
 }
 
 // WriteChunk queues a single service info. If messageBody is larger than the
-// bytes available, WriteChunk will fail and no service info will be queued.
+// bytes available, WriteChunk will fail with ErrSizeTooSmall and no service
+// info will be queued.
 func (p *Producer) WriteChunk(messageName string, messageBody []byte) error {
+	if len(messageBody) > p.Available(messageName) {
+		return fmt.Errorf("message %q of %d bytes: %w", messageName, len(messageBody), ErrSizeTooSmall)
+	}
 	p.info = append(p.info, &KV{
 		Key: p.moduleName + ":" + messageName,
 		Val: messageBody,
@@ -66,5 +110,30 @@ func (p *Producer) WriteChunk(messageName string, messageBody []byte) error {
 	return nil
 }
 
+// WriteSplit queues as much of messageBody as fits in the remaining MTU and
+// returns the rest, which should be written with WriteSplit on the next
+// ProduceInfo. Until the rest is empty, the module should block the peer, so
+// that the device receives all parts concatenated as a single message.
+//
+// If no part fits, then nothing is queued and messageBody is returned. If
+// nothing has been queued yet, i.e. no part would ever fit, then WriteSplit
+// fails with ErrSizeTooSmall.
+func (p *Producer) WriteSplit(messageName string, messageBody []byte) (rest []byte, _ error) {
+	if len(messageBody) == 0 {
+		return nil, fmt.Errorf("message %q has an empty body", messageName)
+	}
+	n := min(p.Available(messageName), len(messageBody))
+	if n < 1 {
+		if len(p.info) == 0 {
+			return messageBody, fmt.Errorf("message %q: %w", messageName, ErrSizeTooSmall)
+		}
+		return messageBody, nil
+	}
+	if err := p.WriteChunk(messageName, messageBody[:n]); err != nil {
+		return messageBody, err
+	}
+	return messageBody[n:], nil
+}
+
 // ServiceInfo returns all ServiceInfo, guaranteed to fit within the MTU.
 func (p *Producer) ServiceInfo() []*KV { return p.info }
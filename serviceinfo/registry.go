@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ModuleInfo describes the capabilities of a device module. All fields are
+// optional.
+type ModuleInfo struct {
+	// Version is the version of the module implementation. It is included in
+	// errors and logs, but not sent to the owner service.
+	Version string
+
+	// Messages are the names of the messages which the module accepts from
+	// the owner module, not including "active". If the owner module sends any
+	// other message, TO2 fails with an error naming the supported messages.
+	// If empty, all messages are passed to the module.
+	Messages []string
+}
+
+// Supports reports whether the module accepts a message from the owner
+// module.
+func (info ModuleInfo) Supports(messageName string) bool {
+	return len(info.Messages) == 0 || slices.Contains(info.Messages, messageName)
+}
+
+// Registry holds the device modules of a client, their capabilities, and,
+// during TO2, whether each has been activated by the owner service.
+//
+// The zero value is an empty registry ready to use.
+type Registry struct {
+	modules map[string]registeredModule
+	active  map[string]bool
+}
+
+type registeredModule struct {
+	module DeviceModule
+	info   ModuleInfo
+}
+
+// NewRegistry returns a registry of modules, keyed by module name, without
+// capability metadata.
+func NewRegistry(modules map[string]DeviceModule) *Registry {
+	r := new(Registry)
+	for name, module := range modules {
+		r.set(name, module, ModuleInfo{})
+	}
+	return r
+}
+
+// Register adds a module. Module names must be unique.
+func (r *Registry) Register(name string, module DeviceModule, info ModuleInfo) error {
+	if name == "" || module == nil {
+		return errors.New("device module name and implementation are required")
+	}
+	if strings.Contains(name, ":") {
+		return fmt.Errorf("invalid device module name %q", name)
+	}
+	if _, exists := r.modules[name]; exists {
+		return fmt.Errorf("duplicate device module %q", name)
+	}
+	r.set(name, module, info)
+	return nil
+}
+
+func (r *Registry) set(name string, module DeviceModule, info ModuleInfo) {
+	if r.modules == nil {
+		r.modules = make(map[string]registeredModule)
+	}
+	info.Messages = slices.Clone(info.Messages)
+	r.modules[name] = registeredModule{module: module, info: info}
+}
+
+// Module returns a registered module and its capabilities.
+func (r *Registry) Module(name string) (_ DeviceModule, _ ModuleInfo, ok bool) {
+	if r == nil {
+		return nil, ModuleInfo{}, false
+	}
+	m, ok := r.modules[name]
+	return m.module, m.info, ok
+}
+
+// Names returns the names of all registered modules in sorted order.
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(r.modules))
+}
+
+// Len returns the number of registered modules.
+func (r *Registry) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.modules)
+}
+
+// Active reports whether a module has been activated.
+func (r *Registry) Active(name string) bool {
+	return r != nil && r.active[name]
+}
+
+// SetActive records whether a module has been activated or deactivated.
+func (r *Registry) SetActive(name string, active bool) {
+	if r.active == nil {
+		r.active = make(map[string]bool)
+	}
+	r.active[name] = active
+}
+
+// Clone returns a copy of the registry with no modules activated, so that
+// each TO2 session tracks activation separately. Cloning a nil registry
+// returns an empty one.
+func (r *Registry) Clone() *Registry {
+	clone := new(Registry)
+	if r != nil {
+		clone.modules = maps.Clone(r.modules)
+	}
+	return clone
+}
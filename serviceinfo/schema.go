@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// MessageSchema decodes and validates the body of a service info message.
+type MessageSchema interface {
+	Decode(io.Reader) (any, error)
+}
+
+// Body is the MessageSchema of a message whose body is a single CBOR item
+// which decodes to T. The decoded body is a T.
+type Body[T any] struct {
+	// Validate, if not nil, checks the decoded body, i.e. for ranges of
+	// values or required fields.
+	Validate func(T) error
+}
+
+// Decode implements MessageSchema.
+func (b Body[T]) Decode(r io.Reader) (any, error) {
+	var body T
+	if err := cbor.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+	if n, _ := r.Read(make([]byte, 1)); n > 0 {
+		return nil, errors.New("unexpected data after body")
+	}
+	if b.Validate != nil {
+		if err := b.Validate(body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// Schema declares the messages which a module expects, by message name, so
+// that each module need not decode and validate message bodies by hand.
+type Schema map[string]MessageSchema
+
+// SchemaError is returned for a message which is not in a Schema or whose body
+// does not match it.
+type SchemaError struct {
+	MessageName string
+
+	// Err is nil for unknown messages.
+	Err error
+}
+
+// Error implements error.
+func (e *SchemaError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("unknown message %s", e.MessageName)
+	}
+	return fmt.Sprintf("invalid message %s: %v", e.MessageName, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error { return e.Err }
+
+// Decode reads the body of a message and validates it against its schema.
+// Errors are a *SchemaError.
+func (s Schema) Decode(messageName string, body io.Reader) (any, error) {
+	schema, ok := s[messageName]
+	if !ok {
+		return nil, &SchemaError{MessageName: messageName}
+	}
+	v, err := schema.Decode(body)
+	if err != nil {
+		return nil, &SchemaError{MessageName: messageName, Err: err}
+	}
+	return v, nil
+}
+
+// DecodeMessage is Schema.Decode for a message whose decoded body is a T, such
+// as one declared with Body[T].
+func DecodeMessage[T any](s Schema, messageName string, body io.Reader) (T, error) {
+	var zero T
+	v, err := s.Decode(messageName, body)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := v.(T)
+	if !ok {
+		return zero, &SchemaError{MessageName: messageName, Err: fmt.Errorf("decoded body is %T, not %T", v, zero)}
+	}
+	return typed, nil
+}
+
+// SchemaModule is a DeviceModule which decodes and validates each received
+// message with a Schema before passing it to Handle. Messages which are not in
+// the schema or are malformed fail the module with a recoverable ModuleError,
+// so that the error is reported to the owner module and TO2 continues without
+// the module.
+//
+// Owner modules may use Schema.Decode in HandleInfo and return the error with
+// RecoverableError to the same effect.
+type SchemaModule struct {
+	Schema Schema
+
+	// Handle is called with the decoded body of each valid message, as
+	// returned by the MessageSchema of the message. It is required.
+	Handle func(ctx context.Context, messageName string, body any, respond func(string) io.Writer, yield func()) error
+
+	// OnTransition, if not nil, implements DeviceModule.Transition.
+	OnTransition func(active bool) error
+
+	// OnYield, if not nil, implements DeviceModule.Yield.
+	OnYield func(ctx context.Context, respond func(string) io.Writer, yield func()) error
+}
+
+var _ DeviceModule = (*SchemaModule)(nil)
+
+// Transition implements DeviceModule.
+func (m *SchemaModule) Transition(active bool) error {
+	if m.OnTransition == nil {
+		return nil
+	}
+	return m.OnTransition(active)
+}
+
+// Receive implements DeviceModule.
+func (m *SchemaModule) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(string) io.Writer, yield func()) error {
+	body, err := m.Schema.Decode(messageName, messageBody)
+	if err != nil {
+		// Consume the rest of the body, so that the error may be sent
+		_, _ = io.Copy(io.Discard, messageBody)
+		return RecoverableError(err)
+	}
+	return m.Handle(ctx, messageName, body, respond, yield)
+}
+
+// Yield implements DeviceModule.
+func (m *SchemaModule) Yield(ctx context.Context, respond func(string) io.Writer, yield func()) error {
+	if m.OnYield == nil {
+		return nil
+	}
+	return m.OnYield(ctx, respond, yield)
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestSchemaModule(t *testing.T) {
+	type setPolicy struct {
+		Name    string
+		Retries uint8
+	}
+	schema := serviceinfo.Schema{
+		"reboot": serviceinfo.Body[bool]{},
+		"policy": serviceinfo.Body[setPolicy]{Validate: func(p setPolicy) error {
+			if p.Name == "" {
+				return errors.New("missing name")
+			}
+			return nil
+		}},
+	}
+
+	var received []any
+	module := &serviceinfo.SchemaModule{
+		Schema: schema,
+		Handle: func(_ context.Context, messageName string, body any, respond func(string) io.Writer, _ func()) error {
+			received = append(received, body)
+			if policy, ok := body.(setPolicy); ok {
+				return cbor.NewEncoder(respond("applied")).Encode(policy.Name)
+			}
+			return nil
+		},
+	}
+	var responses []string
+	respond := func(message string) io.Writer {
+		responses = append(responses, message)
+		return io.Discard
+	}
+	receive := func(messageName string, body []byte) error {
+		return module.Receive(context.Background(), messageName, bytes.NewReader(body), respond, func() {})
+	}
+	encode := func(v any) []byte {
+		data, err := cbor.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// Valid messages are passed to the handler decoded
+	if err := receive("reboot", encode(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := receive("policy", encode(setPolicy{Name: "strict", Retries: 3})); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[0] != true || received[1] != (setPolicy{Name: "strict", Retries: 3}) {
+		t.Fatalf("unexpected decoded bodies: %+v", received)
+	}
+	if len(responses) != 1 || responses[0] != "applied" {
+		t.Fatalf("unexpected responses: %v", responses)
+	}
+
+	// Unknown and malformed messages fail the module recoverably
+	for name, test := range map[string]struct {
+		messageName string
+		body        []byte
+	}{
+		"unknown message": {"shutdown", encode(true)},
+		"wrong type":      {"reboot", encode("yes")},
+		"trailing data":   {"reboot", append(encode(true), encode(false)...)},
+		"failed validate": {"policy", encode(setPolicy{Retries: 1})},
+	} {
+		if err := receive(test.messageName, test.body); !serviceinfo.IsRecoverable(err) || !strings.Contains(err.Error(), test.messageName) {
+			t.Errorf("%s: expected recoverable error for message %s, got %v", name, test.messageName, err)
+		}
+	}
+	if len(received) != 2 {
+		t.Fatalf("expected invalid messages not to be handled, got %+v", received)
+	}
+
+	// Owner modules may decode typed bodies directly
+	policy, err := serviceinfo.DecodeMessage[setPolicy](schema, "policy", bytes.NewReader(encode(setPolicy{Name: "lax"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Name != "lax" {
+		t.Fatalf("unexpected decoded body: %+v", policy)
+	}
+	var schemaErr *serviceinfo.SchemaError
+	if _, err := serviceinfo.DecodeMessage[string](schema, "reboot", bytes.NewReader(encode(true))); !errors.As(err, &schemaErr) {
+		t.Fatalf("expected decoding to the wrong type to fail with a schema error, got %v", err)
+	}
+}
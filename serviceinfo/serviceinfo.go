@@ -4,7 +4,10 @@
 // Package serviceinfo handles FDO Service Info and Service Info Modules.
 package serviceinfo
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 // DefaultMTU for service info when Max(Owner|Device)ServiceInfoSz is null.
 const DefaultMTU = 1300
@@ -19,6 +22,19 @@ func (kv *KV) String() string {
 	return fmt.Sprintf("[Key=%q,Val=% x]", kv.Key, kv.Val)
 }
 
+// Release returns the buffer of Val to be reused by ChunkReader.ReadChunk and
+// sets Val to nil. Ownership of the buffer is transferred, so neither Val nor
+// any other reference to it may be used afterward. Releasing is optional;
+// buffers which are not released are garbage collected.
+func (kv *KV) Release() {
+	if kv.Val == nil {
+		return
+	}
+	// Clip, because only the bytes of Val are owned
+	putChunkBuf(slices.Clip(kv.Val))
+	kv.Val = nil
+}
+
 // Size calculates the number of bytes once marshaled to CBOR.
 func (kv *KV) Size() uint16 {
 	size := uint16(1) // header for overall KV structure
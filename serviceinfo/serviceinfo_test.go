@@ -5,6 +5,9 @@ package serviceinfo_test
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
@@ -34,8 +37,7 @@ func TestProducerAvailable(t *testing.T) {
 	const moduleName, messageName = "module", "message"
 	mtu := uint16(1<<16 - 1)
 	producer := serviceinfo.NewProducer(moduleName, mtu)
-	available := producer.Available(messageName) - 3 // 3 for array size
-	messageBody := make([]byte, available)
+	messageBody := make([]byte, producer.Available(messageName))
 	if err := producer.WriteChunk(messageName, messageBody); err != nil {
 		t.Fatal(err)
 	}
@@ -46,3 +48,170 @@ func TestProducerAvailable(t *testing.T) {
 		t.Fatalf("expected available bytes < 0, got %d", available)
 	}
 }
+
+func TestProducerWriteSplit(t *testing.T) {
+	const moduleName, messageName = "module", "message"
+	messageBody := bytes.Repeat([]byte("0123456789"), 100)
+
+	// Messages are split at every size class of body headers
+	for _, mtu := range []uint16{32, 40, 300, 310, 1300} {
+		var received []byte
+		rest := messageBody
+		for len(rest) > 0 {
+			producer := serviceinfo.NewProducer(moduleName, mtu)
+			if remaining := producer.Remaining(); remaining != int(mtu-3-1) {
+				t.Fatalf("mtu %d: expected %d bytes remaining, got %d", mtu, mtu-3-1, remaining)
+			}
+			var err error
+			if rest, err = producer.WriteSplit(messageName, rest); err != nil {
+				t.Fatalf("mtu %d: %v", mtu, err)
+			}
+			if size := serviceinfo.ArraySizeCBOR(producer.ServiceInfo()); size > int64(mtu-3) {
+				t.Fatalf("mtu %d: service info of %d bytes exceeds MTU", mtu, size)
+			}
+			if len(rest) > 0 && producer.Remaining() > 3 {
+				t.Fatalf("mtu %d: split message left %d bytes unused", mtu, producer.Remaining())
+			}
+			for _, kv := range producer.ServiceInfo() {
+				received = append(received, kv.Val...)
+			}
+		}
+		if !bytes.Equal(received, messageBody) {
+			t.Fatalf("mtu %d: split message does not match", mtu)
+		}
+	}
+
+	// A message which never fits fails instead of being retried forever
+	producer := serviceinfo.NewProducer(moduleName, 16)
+	if _, err := producer.WriteSplit(messageName, messageBody); !errors.Is(err, serviceinfo.ErrSizeTooSmall) {
+		t.Fatalf("expected ErrSizeTooSmall, got %v", err)
+	}
+
+	// WriteChunk does not exceed the MTU
+	producer = serviceinfo.NewProducer(moduleName, 300)
+	if err := producer.WriteChunk(messageName, messageBody[:producer.Available(messageName)+1]); !errors.Is(err, serviceinfo.ErrSizeTooSmall) {
+		t.Fatalf("expected ErrSizeTooSmall, got %v", err)
+	}
+	if len(producer.ServiceInfo()) > 0 {
+		t.Fatal("expected no service info to be queued")
+	}
+}
+
+// Fuzz decoding and unchunking of service info KVs as received in TO2
+func FuzzServiceInfo(f *testing.F) {
+	for _, kvs := range [][]*serviceinfo.KV{
+		{},
+		{{Key: "devmod:active", Val: []byte{0xf5}}},
+		{{Key: "devmod:modules", Val: []byte{0x83, 0x00, 0x01, 0x66, 'd', 'e', 'v', 'm', 'o', 'd'}}},
+		{{Key: "mod:msg", Val: []byte{0x45, 'h', 'e'}}, {Key: "mod:msg", Val: []byte("llo")}},
+	} {
+		data, err := cbor.Marshal(kvs)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var kvs []*serviceinfo.KV
+		if err := cbor.Unmarshal(data, &kvs); err != nil {
+			return
+		}
+		r, w := serviceinfo.NewChunkInPipe(len(kvs))
+		for _, kv := range kvs {
+			if err := w.WriteChunk(kv); err != nil {
+				if kv != nil {
+					t.Fatal(err)
+				}
+				break
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, val, ok := r.NextServiceInfo()
+			if !ok {
+				break
+			}
+			var v any
+			_ = cbor.NewDecoder(val).Decode(&v)
+			_ = val.Close()
+		}
+
+		for _, kv := range kvs {
+			if kv == nil {
+				continue
+			}
+			var chunk serviceinfo.DevmodModulesChunk
+			_ = cbor.Unmarshal(kv.Val, &chunk)
+		}
+	})
+}
+
+func TestModuleErrorEncoding(t *testing.T) {
+	for _, err := range []error{
+		errors.New("plain errors are fatal"),
+		&serviceinfo.ModuleError{Message: "fatal"},
+		serviceinfo.RecoverableError(errors.New("recoverable")),
+	} {
+		var body bytes.Buffer
+		respond := func(messageName string) io.Writer {
+			if messageName != serviceinfo.ErrorMessageName {
+				t.Fatalf("unexpected message name %q", messageName)
+			}
+			return &body
+		}
+		if err := serviceinfo.RespondError(respond, err); err != nil {
+			t.Fatal(err)
+		}
+
+		// Fatal errors are a tstr, as used by existing FSIMs
+		var msg string
+		if isString := cbor.Unmarshal(body.Bytes(), &msg) == nil; isString == serviceinfo.IsRecoverable(err) {
+			t.Errorf("%v: unexpected encoding % x", err, body.Bytes())
+		}
+
+		modErr, decodeErr := serviceinfo.DecodeError("mod", &body)
+		if decodeErr != nil {
+			t.Fatal(decodeErr)
+		}
+		if modErr.Module != "mod" || modErr.Recoverable != serviceinfo.IsRecoverable(err) || !strings.Contains(err.Error(), modErr.Message) {
+			t.Errorf("%v: decoded %#v", err, modErr)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var r serviceinfo.Registry
+	if err := r.Register("b.mod", serviceinfo.UnknownModule{}, serviceinfo.ModuleInfo{Version: "1", Messages: []string{"ping"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("a.mod", serviceinfo.UnknownModule{}, serviceinfo.ModuleInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.mod", "", "c:mod"} {
+		if err := r.Register(name, serviceinfo.UnknownModule{}, serviceinfo.ModuleInfo{}); err == nil {
+			t.Errorf("expected registering %q to fail", name)
+		}
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != "a.mod" || names[1] != "b.mod" {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	_, info, ok := r.Module("b.mod")
+	if !ok || info.Version != "1" || !info.Supports("ping") || info.Supports("pong") {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if _, info, _ := r.Module("a.mod"); !info.Supports("anything") {
+		t.Error("expected module without messages to support all messages")
+	}
+
+	r.SetActive("a.mod", true)
+	if !r.Active("a.mod") || r.Active("b.mod") {
+		t.Error("unexpected activation state")
+	}
+	if clone := r.Clone(); clone.Len() != 2 || clone.Active("a.mod") {
+		t.Error("expected clone to have the same modules and none active")
+	}
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// StreamSource produces a message from an io.Reader for payloads which are
+// too large to materialize as service info, such as multi-gigabyte files. It
+// is used from the ProduceInfo method of an owner module and the message is
+// received on the device with a StreamSink.
+//
+// The message body is a sequence of CBOR byte strings, each sized to fill the
+// remaining MTU, and terminated by an empty byte string. The Reader is only
+// read when the device requests more service info, so the rate of the
+// transfer is limited by how fast the device consumes it.
+type StreamSource struct {
+	// MessageName is the name of the message used for all chunks.
+	MessageName string
+
+	// Reader is the source of the data. It is read until io.EOF.
+	Reader io.Reader
+
+	// Window is the number of consecutive messages sent with
+	// IsMoreServiceInfo before the device is allowed to respond. The device
+	// buffers all messages of a window in memory before passing them to its
+	// module, so larger windows trade device memory for fewer round trips. If
+	// Window is less than 2, then the device responds to every chunk.
+	Window int
+
+	sent int
+	done bool
+	buf  []byte
+}
+
+// Produce writes the next chunk of the stream to the producer. It should be
+// called once per ProduceInfo and its blockPeer result returned from
+// ProduceInfo. Once the end of the stream has been written, done is true.
+//
+// If the producer does not have room for any data, then nothing is written
+// and the chunk will be written on the next call.
+func (s *StreamSource) Produce(producer *Producer) (blockPeer, done bool, _ error) {
+	if s.done {
+		return false, true, nil
+	}
+
+	// The message body is a byte string of data, followed by the 1 byte
+	// terminator if the stream ends
+	size := maxByteStringLen(producer.Available(s.MessageName) - 1)
+	if size < 1 {
+		return false, false, nil
+	}
+	if len(s.buf) < size {
+		s.buf = make([]byte, size)
+	}
+
+	n, err := io.ReadFull(s.Reader, s.buf[:size])
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		s.done = true
+	case err != nil:
+		return false, false, fmt.Errorf("error reading stream for message %q: %w", s.MessageName, err)
+	}
+
+	var val []byte
+	if n > 0 {
+		chunk, err := cbor.Marshal(s.buf[:n])
+		if err != nil {
+			return false, false, err
+		}
+		val = chunk
+	}
+	if s.done {
+		// A short read leaves room for the 1 byte terminator
+		val = append(val, 0x40)
+	}
+	if err := producer.WriteChunk(s.MessageName, val); err != nil {
+		return false, false, err
+	}
+
+	if s.done {
+		return false, true, nil
+	}
+	s.sent++
+	if s.sent%max(s.Window, 1) == 0 {
+		return false, false, nil
+	}
+	return true, false, nil
+}
+
+// maxByteStringLen returns the largest byte string length which can be
+// encoded as CBOR in size bytes.
+func maxByteStringLen(size int) int {
+	switch {
+	case size <= 24:
+		return size - 1
+	case size <= 257:
+		return size - 2
+	default:
+		return size - 3
+	}
+}
+
+// StreamSink writes a message produced by an owner module with a
+// StreamSource to an io.Writer. It is used from the Receive method of a device
+// module. Each call to Receive blocks until the data has been written, which
+// in turn delays requesting more data from the owner service.
+type StreamSink struct {
+	// Writer is the destination of the data.
+	Writer io.Writer
+
+	done bool
+}
+
+// Receive writes the data contained in a message body to the Writer. The
+// message body must be fully read, so it is an error for the owner service to
+// send data after the end of the stream.
+func (s *StreamSink) Receive(messageBody io.Reader) (done bool, _ error) {
+	dec := cbor.NewDecoder(messageBody)
+	for {
+		var chunk []byte
+		if err := dec.Decode(&chunk); errors.Is(err, io.EOF) {
+			return s.done, nil
+		} else if err != nil {
+			return false, fmt.Errorf("error decoding stream chunk: %w", err)
+		}
+		if s.done {
+			return true, errors.New("stream data received after end of stream")
+		}
+		if len(chunk) == 0 {
+			s.done = true
+			continue
+		}
+		if _, err := s.Writer.Write(chunk); err != nil {
+			return false, fmt.Errorf("error writing stream data: %w", err)
+		}
+	}
+}
+
+// Done returns true once the end of the stream has been received.
+func (s *StreamSink) Done() bool { return s.done }
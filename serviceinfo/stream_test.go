@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestStream(t *testing.T) {
+	size := int64(2 << 30) // 2 GiB
+	if testing.Short() {
+		size = 64 << 20
+	}
+
+	for _, test := range []struct {
+		name   string
+		mtu    uint16
+		window int
+		size   int64
+	}{
+		{name: "empty", mtu: serviceinfo.DefaultMTU, size: 0},
+		{name: "default mtu", mtu: serviceinfo.DefaultMTU, window: 4, size: 1<<20 + 7},
+		{name: "small mtu", mtu: 64, size: 4096},
+		{name: "multi-gigabyte", mtu: 1<<16 - 1, window: 16, size: size},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srcHash, dstHash := sha256.New(), sha256.New()
+			src := &serviceinfo.StreamSource{
+				MessageName: "data",
+				Reader:      io.TeeReader(io.LimitReader(rand.NewChaCha8([32]byte{}), test.size), srcHash),
+				Window:      test.window,
+			}
+			dst := &serviceinfo.StreamSink{Writer: dstHash}
+
+			// Simulate TO2 rounds, where messages are concatenated until the
+			// peer is no longer blocked
+			var window bytes.Buffer
+			for done := false; !done; {
+				producer := serviceinfo.NewProducer("mod", test.mtu)
+				blockPeer, moduleDone, err := src.Produce(producer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if size := serviceinfo.ArraySizeCBOR(producer.ServiceInfo()); size > int64(test.mtu-3) {
+					t.Fatalf("service info exceeds MTU: %d", size)
+				}
+				for _, kv := range producer.ServiceInfo() {
+					window.Write(kv.Val)
+				}
+				if blockPeer {
+					if window.Len() > (test.window+1)*int(test.mtu) {
+						t.Fatalf("window exceeded: %d bytes buffered", window.Len())
+					}
+					continue
+				}
+				if done, err = dst.Receive(&window); err != nil {
+					t.Fatal(err)
+				}
+				if done != moduleDone {
+					t.Fatalf("expected sink done=%t, got %t", moduleDone, done)
+				}
+			}
+
+			if !bytes.Equal(srcHash.Sum(nil), dstHash.Sum(nil)) {
+				t.Fatal("received data does not match sent data")
+			}
+		})
+	}
+}
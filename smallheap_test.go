@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build tinygo || smallheap
+
+package fdo_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/blob"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/internal/smallheap"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// smallHeapServerEnv is set to run TestSmallHeapServer as the owner service
+// of TestSmallHeapBudget.
+const smallHeapServerEnv = "FDO_SMALLHEAP_SERVER"
+
+// TestSmallHeapBudget enforces the heap budget of the client in small-heap
+// mode. The owner service runs in another process, so that the live heap of
+// this process, measured after each message is exchanged, is only that of the
+// client and its HTTP transport.
+//
+// The device onboards twice and only the second onboarding is measured, so
+// that one-time initialization of the runtime and standard library, such as
+// the type cache of reflect and the idle connection of net/http, is not
+// counted.
+func TestSmallHeapBudget(t *testing.T) {
+	addr, cred := startSmallHeapServer(t)
+	hmacSha256, hmacSha384 := cred.HMACs()
+	key := cred.PrivateKey.Signer
+	onboard := func(transport fdo.Transport, cred fdo.DeviceCredential) *fdo.DeviceCredential {
+		newCred, err := fdo.TO2(context.Background(), transport, nil, fdo.TO2Config{
+			Cred:       cred,
+			HmacSha256: hmacSha256,
+			HmacSha384: hmacSha384,
+			Key:        key,
+			Devmod: serviceinfo.Devmod{
+				Os:      runtime.GOOS,
+				Arch:    runtime.GOARCH,
+				Version: "go-fdo small heap",
+				Device:  "go-validation",
+				FileSep: ";",
+				Bin:     runtime.GOARCH,
+			},
+			KeyExchange: kex.ECDH256Suite,
+			CipherSuite: kex.A128GcmCipher,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newCred == nil {
+			t.Fatal("expected replacement credential")
+		}
+		return newCred
+	}
+
+	client := &transport.Transport{BaseURL: "http://" + addr}
+	newCred := onboard(client, cred.DeviceCredential)
+
+	sampler := &heapSampler{Transport: client}
+	sampler.start()
+	onboard(sampler, *newCred)
+
+	t.Logf("peak live heap growth: %d bytes over %d messages (budget %d)", sampler.peak, sampler.messages, smallheap.HeapBudget)
+	if sampler.peak > smallheap.HeapBudget {
+		t.Errorf("peak live heap growth of %d bytes exceeds budget of %d bytes", sampler.peak, smallheap.HeapBudget)
+	}
+}
+
+// startSmallHeapServer starts TestSmallHeapServer in another process and
+// returns its address and the credential of a device which it owns. The
+// server exits when the test ends.
+func startSmallHeapServer(t *testing.T) (string, *blob.DeviceCredential) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSmallHeapServer$")
+	cmd.Env = append(os.Environ(), smallHeapServerEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("owner service process cannot be started: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	})
+
+	// Other output of the test binary may precede the server line
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "smallheap server ")
+		if !ok {
+			continue
+		}
+		addr, credHex, _ := strings.Cut(line, " ")
+		credCBOR, err := hex.DecodeString(credHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cred blob.DeviceCredential
+		if err := cbor.Unmarshal(credCBOR, &cred); err != nil {
+			t.Fatal(err)
+		}
+		go func() { _, _ = io.Copy(io.Discard, stdout) }()
+		return addr, &cred
+	}
+	t.Fatalf("owner service process exited without starting: %v", scanner.Err())
+	return "", nil
+}
+
+// TestSmallHeapServer is the owner service of TestSmallHeapBudget. It prints
+// its address and the credential of a device which it owns, then serves TO2
+// until stdin is closed.
+func TestSmallHeapServer(t *testing.T) {
+	if os.Getenv(smallHeapServerEnv) == "" {
+		t.Skip("run by TestSmallHeapBudget")
+	}
+
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	credCBOR, err := cbor.Marshal(blob.DeviceCredential{
+		Active:           true,
+		DeviceCredential: dev.Cred,
+		HmacSecret:       dev.HmacSecret,
+		PrivateKey:       blob.Pkcs8Key{Signer: dev.Key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", transport.Handler{
+		Tokens:       server.State,
+		TO2Responder: server.TO2,
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(lis) }()
+	defer func() { _ = srv.Close() }()
+
+	fmt.Printf("smallheap server %s %x\n", lis.Addr(), credCBOR)
+	_, _ = io.Copy(io.Discard, os.Stdin)
+}
+
+// heapSampler measures the live heap after each message is exchanged,
+// relative to the live heap when started.
+type heapSampler struct {
+	fdo.Transport
+
+	baseline uint64
+	peak     uint64
+	messages int
+}
+
+func (h *heapSampler) start() { h.baseline = liveHeap() }
+
+func (h *heapSampler) Send(ctx context.Context, msgType uint8, msg any, sess kex.Session) (uint8, io.ReadCloser, error) {
+	respType, resp, err := h.Transport.Send(ctx, msgType, msg, sess)
+	if heap := liveHeap(); heap > h.baseline {
+		h.peak = max(h.peak, heap-h.baseline)
+	}
+	h.messages++
+	return respType, resp, err
+}
+
+// liveHeap returns the live heap after collecting twice, so that buffers
+// cached by sync.Pool are released and not counted.
+func liveHeap() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
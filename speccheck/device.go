@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package speccheck
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/record"
+)
+
+// nextDeviceMsgs are the messages which a device may send after each message
+// it sends within a protocol. Messages starting a protocol may be sent at any
+// time, because failed exchanges are not recorded.
+var nextDeviceMsgs = map[uint8][]uint8{
+	protocol.DIAppStartMsgType:                {protocol.DISetHmacMsgType},
+	protocol.TO1HelloRVMsgType:                {protocol.TO1ProveToRVMsgType},
+	protocol.TO2HelloDeviceMsgType:            {protocol.TO2GetOVNextEntryMsgType},
+	protocol.TO2GetOVNextEntryMsgType:         {protocol.TO2GetOVNextEntryMsgType, protocol.TO2ProveDeviceMsgType},
+	protocol.TO2ProveDeviceMsgType:            {protocol.TO2DeviceServiceInfoReadyMsgType},
+	protocol.TO2DeviceServiceInfoReadyMsgType: {protocol.TO2DeviceServiceInfoMsgType},
+	protocol.TO2DeviceServiceInfoMsgType:      {protocol.TO2DeviceServiceInfoMsgType, protocol.TO2DoneMsgType},
+}
+
+var deviceStartMsgs = []uint8{protocol.DIAppStartMsgType, protocol.TO1HelloRVMsgType, protocol.TO2HelloDeviceMsgType}
+
+// to2NonceClaim is the unprotected header of TO2.ProveOVHdr holding the
+// nonce which the device must return in TO2.Done.
+var to2NonceClaim = cose.Label{Int64: 256}
+
+type done struct {
+	NonceTO2ProveDv protocol.Nonce
+}
+
+func deviceRequirements() []Requirement {
+	return []Requirement{
+		{
+			ID:          "DEVICE-MESSAGE-ORDER",
+			Role:        RoleDevice,
+			Description: "Messages of each protocol are sent in order",
+			check: withExchanges(func(exchanges []record.Exchange) error {
+				var prev uint8
+				for i, ex := range exchanges {
+					switch {
+					case ex.MsgType == protocol.ErrorMsgType:
+						prev = 0
+						continue
+					case slices.Contains(deviceStartMsgs, ex.MsgType):
+					case prev == 0:
+						return fmt.Errorf("exchange %d: message %d sent without starting a protocol", i, ex.MsgType)
+					case !slices.Contains(nextDeviceMsgs[prev], ex.MsgType):
+						return fmt.Errorf("exchange %d: message %d sent after message %d", i, ex.MsgType, prev)
+					}
+					prev = ex.MsgType
+					if ex.RespType == protocol.ErrorMsgType {
+						prev = 0
+					}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "DEVICE-ERROR-MESSAGE",
+			Role:        RoleDevice,
+			Description: "Error messages are well formed and refer to a message of the protocol in progress",
+			check: withExchanges(func(exchanges []record.Exchange) error {
+				for i, ex := range exchanges {
+					if ex.MsgType != protocol.ErrorMsgType {
+						continue
+					}
+					var errMsg protocol.ErrorMessage
+					if err := cbor.Unmarshal(ex.Request, &errMsg); err != nil {
+						return fmt.Errorf("exchange %d: error decoding error message: %w", i, err)
+					}
+					if i == 0 {
+						continue
+					}
+					if got, want := protocol.Of(errMsg.PrevMsgType), protocol.Of(exchanges[i-1].MsgType); got != want {
+						return fmt.Errorf("exchange %d: error message refers to message %d of %s, but %s was in progress",
+							i, errMsg.PrevMsgType, got, want)
+					}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "DEVICE-TO2-HELLO-DEVICE",
+			Role:        RoleDevice,
+			Description: "TO2.HelloDevice has a random nonce and a supported key exchange and cipher suite",
+			check: withExchanges(func(exchanges []record.Exchange) error {
+				var found bool
+				for i, ex := range exchanges {
+					if ex.MsgType != protocol.TO2HelloDeviceMsgType {
+						continue
+					}
+					found = true
+					var hello helloDevice
+					if err := cbor.Unmarshal(ex.Request, &hello); err != nil {
+						return fmt.Errorf("exchange %d: error decoding TO2.HelloDevice: %w", i, err)
+					}
+					if hello.NonceTO2ProveOV == (protocol.Nonce{}) {
+						return fmt.Errorf("exchange %d: nonce is all zeros", i)
+					}
+					if !kex.Available(hello.KexSuiteName, hello.CipherSuite) {
+						return fmt.Errorf("exchange %d: unsupported key exchange suite %q or cipher suite %s",
+							i, hello.KexSuiteName, hello.CipherSuite)
+					}
+				}
+				if !found {
+					return errSkip
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "DEVICE-TO2-DONE-NONCE",
+			Role:        RoleDevice,
+			Description: "TO2.Done returns the nonce of TO2.ProveOVHdr",
+			check: withExchanges(func(exchanges []record.Exchange) error {
+				var nonce *protocol.Nonce
+				var found bool
+				for i, ex := range exchanges {
+					switch {
+					case ex.MsgType == protocol.TO2HelloDeviceMsgType && ex.RespType == protocol.TO2ProveOVHdrMsgType:
+						var proveOVHdr cose.Sign1Tag[cbor.RawBytes, []byte]
+						if err := cbor.Unmarshal(ex.Response, &proveOVHdr); err != nil {
+							return fmt.Errorf("exchange %d: error decoding TO2.ProveOVHdr: %w", i, err)
+						}
+						nonce = new(protocol.Nonce)
+						if ok, err := proveOVHdr.Unprotected.Parse(to2NonceClaim, nonce); !ok || err != nil {
+							return fmt.Errorf("exchange %d: TO2.ProveOVHdr has no valid nonce header", i)
+						}
+					case ex.MsgType == protocol.TO2DoneMsgType:
+						found = true
+						if nonce == nil {
+							return fmt.Errorf("exchange %d: TO2.Done sent without TO2.ProveOVHdr", i)
+						}
+						var msg done
+						if err := cbor.Unmarshal(ex.Request, &msg); err != nil {
+							return fmt.Errorf("exchange %d: error decoding TO2.Done: %w", i, err)
+						}
+						if msg.NonceTO2ProveDv != *nonce {
+							return fmt.Errorf("exchange %d: TO2.Done nonce does not match TO2.ProveOVHdr", i)
+						}
+					}
+				}
+				if !found {
+					return errSkip
+				}
+				return nil
+			}),
+		},
+	}
+}
+
+func withExchanges(check func([]record.Exchange) error) func(context.Context, *Target) error {
+	return func(_ context.Context, t *Target) error {
+		if len(t.DeviceExchanges) == 0 {
+			return errSkip
+		}
+		return check(t.DeviceExchanges)
+	}
+}
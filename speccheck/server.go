@@ -0,0 +1,305 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package speccheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// HTTPClient sends HTTP requests, as *http.Client does.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+var errSkip = errors.New("skipped")
+
+// Message bodies are defined here rather than shared with the implementation,
+// so that a mistake in the implementation is not repeated in its checks.
+
+type sigInfo struct {
+	Type cose.SignatureAlgorithm
+	Info []byte
+}
+
+type helloRV struct {
+	GUID     protocol.GUID
+	ASigInfo sigInfo
+}
+
+type helloDevice struct {
+	MaxDeviceMessageSize uint16
+	GUID                 protocol.GUID
+	NonceTO2ProveOV      protocol.Nonce
+	KexSuiteName         kex.Suite
+	CipherSuite          kex.CipherSuiteID
+	SigInfoA             sigInfo
+}
+
+type helloAck struct {
+	NonceTO0Sign protocol.Nonce
+}
+
+// serverRole describes the protocol of a server role: the message which
+// starts it and the message which must follow.
+type serverRole struct {
+	id        string
+	name      string
+	baseURL   func(*Target) string
+	start     func() (uint8, any)
+	secondMsg uint8
+}
+
+func serverRoleOf(role Role) serverRole {
+	if role == RoleRendezvous {
+		return serverRole{
+			id:      "RV",
+			name:    "TO1.HelloRV",
+			baseURL: func(t *Target) string { return t.RendezvousURL },
+			start: func() (uint8, any) {
+				return protocol.TO1HelloRVMsgType, helloRV{
+					GUID:     randomGUID(),
+					ASigInfo: sigInfo{Type: cose.ES256Alg},
+				}
+			},
+			secondMsg: protocol.TO1ProveToRVMsgType,
+		}
+	}
+	return serverRole{
+		id:      "OWNER",
+		name:    "TO2.HelloDevice",
+		baseURL: func(t *Target) string { return t.OwnerURL },
+		start: func() (uint8, any) {
+			var nonce protocol.Nonce
+			_, _ = rand.Read(nonce[:])
+			return protocol.TO2HelloDeviceMsgType, helloDevice{
+				MaxDeviceMessageSize: 65535,
+				GUID:                 randomGUID(),
+				NonceTO2ProveOV:      nonce,
+				KexSuiteName:         kex.ECDH256Suite,
+				CipherSuite:          kex.A128GcmCipher,
+				SigInfoA:             sigInfo{Type: cose.ES256Alg},
+			}
+		},
+		secondMsg: protocol.TO2GetOVNextEntryMsgType,
+	}
+}
+
+// serverRequirements returns the requirements common to the owner and
+// rendezvous roles.
+func serverRequirements(role Role) []Requirement {
+	r := serverRoleOf(role)
+	return []Requirement{
+		{
+			ID:          r.id + "-HTTP-BINDING",
+			Role:        role,
+			Description: "Responses are CBOR with Content-Type, Content-Length, and Message-Type headers",
+			check: r.withURL(func(ctx context.Context, t *Target, baseURL string) error {
+				msgType, msg := r.start()
+				resp, err := post(ctx, t, baseURL, msgType, mustMarshal(msg), "")
+				if err != nil {
+					return err
+				}
+				if resp.status != http.StatusOK && resp.status != http.StatusInternalServerError {
+					return fmt.Errorf("expected HTTP status 200 or 500, got %d", resp.status)
+				}
+				if mediaType, _, _ := mime.ParseMediaType(resp.header.Get("Content-Type")); mediaType != "application/cbor" {
+					return fmt.Errorf("expected Content-Type application/cbor, got %q", resp.header.Get("Content-Type"))
+				}
+				if resp.header.Get("Content-Length") != strconv.Itoa(len(resp.body)) {
+					return fmt.Errorf("expected Content-Length %d, got %q", len(resp.body), resp.header.Get("Content-Length"))
+				}
+				if !resp.hasMsgType {
+					return errors.New("missing or invalid Message-Type header")
+				}
+				if resp.status == http.StatusInternalServerError && resp.msgType != protocol.ErrorMsgType {
+					return fmt.Errorf("expected Message-Type 255 with HTTP status 500, got %d", resp.msgType)
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          r.id + "-UNKNOWN-GUID",
+			Role:        role,
+			Description: r.name + " for an unknown device fails with resource not found",
+			check: r.withURL(func(ctx context.Context, t *Target, baseURL string) error {
+				msgType, msg := r.start()
+				resp, err := post(ctx, t, baseURL, msgType, mustMarshal(msg), "")
+				if err != nil {
+					return err
+				}
+				return resp.expectError(msgType, protocol.ResourceNotFound)
+			}),
+		},
+		{
+			ID:          r.id + "-MALFORMED-BODY",
+			Role:        role,
+			Description: r.name + " with a body which is not CBOR fails with a message body error",
+			check: r.withURL(func(ctx context.Context, t *Target, baseURL string) error {
+				msgType, _ := r.start()
+				resp, err := post(ctx, t, baseURL, msgType, []byte{0xff, 0xff, 0xff}, "")
+				if err != nil {
+					return err
+				}
+				return resp.expectError(msgType, protocol.MessageBodyErrCode)
+			}),
+		},
+		{
+			ID:          r.id + "-MISSING-TOKEN",
+			Role:        role,
+			Description: fmt.Sprintf("Message %d without an authorization token from %s fails", r.secondMsg, r.name),
+			check: r.withURL(func(ctx context.Context, t *Target, baseURL string) error {
+				resp, err := post(ctx, t, baseURL, r.secondMsg, mustMarshal([]any{}), "")
+				if err != nil {
+					return err
+				}
+				return resp.expectError(r.secondMsg, 0)
+			}),
+		},
+		{
+			ID:          r.id + "-INVALID-TOKEN",
+			Role:        role,
+			Description: fmt.Sprintf("Message %d with a forged authorization token fails", r.secondMsg),
+			check: r.withURL(func(ctx context.Context, t *Target, baseURL string) error {
+				resp, err := post(ctx, t, baseURL, r.secondMsg, mustMarshal([]any{}), "Bearer forged-token")
+				if err != nil {
+					return err
+				}
+				return resp.expectError(r.secondMsg, 0)
+			}),
+		},
+	}
+}
+
+// rendezvousRequirements returns the requirements of the TO0 protocol served
+// by rendezvous servers.
+func rendezvousRequirements() []Requirement {
+	r := serverRoleOf(RoleRendezvous)
+	return []Requirement{
+		{
+			ID:          "RV-TO0-HELLO",
+			Role:        RoleRendezvous,
+			Description: "TO0.Hello is answered by TO0.HelloAck with a nonce",
+			check: r.withURL(func(ctx context.Context, t *Target, baseURL string) error {
+				resp, err := post(ctx, t, baseURL, protocol.TO0HelloMsgType, mustMarshal([]any{}), "")
+				if err != nil {
+					return err
+				}
+				if resp.msgType != protocol.TO0HelloAckMsgType {
+					return fmt.Errorf("expected message %d, got %d", protocol.TO0HelloAckMsgType, resp.msgType)
+				}
+				var ack helloAck
+				if err := cbor.Unmarshal(resp.body, &ack); err != nil {
+					return fmt.Errorf("error decoding TO0.HelloAck: %w", err)
+				}
+				if ack.NonceTO0Sign == (protocol.Nonce{}) {
+					return errors.New("TO0.HelloAck nonce is all zeros")
+				}
+				if resp.header.Get("Authorization") == "" {
+					return errors.New("TO0.HelloAck is missing an authorization token for TO0.OwnerSign")
+				}
+				return nil
+			}),
+		},
+	}
+}
+
+func (r serverRole) withURL(check func(context.Context, *Target, string) error) func(context.Context, *Target) error {
+	return func(ctx context.Context, t *Target) error {
+		baseURL := r.baseURL(t)
+		if baseURL == "" {
+			return errSkip
+		}
+		return check(ctx, t, baseURL)
+	}
+}
+
+type response struct {
+	status     int
+	header     http.Header
+	msgType    uint8
+	hasMsgType bool
+	body       []byte
+}
+
+// expectError checks that the response is an error message for a message
+// type. If code is non-zero, the error code must match.
+func (resp *response) expectError(prevMsgType uint8, code uint16) error {
+	if resp.msgType != protocol.ErrorMsgType {
+		return fmt.Errorf("expected error message, got message %d", resp.msgType)
+	}
+	var errMsg protocol.ErrorMessage
+	if err := cbor.Unmarshal(resp.body, &errMsg); err != nil {
+		return fmt.Errorf("error decoding error message: %w", err)
+	}
+	if errMsg.PrevMsgType != prevMsgType {
+		return fmt.Errorf("expected error for message %d, got %d", prevMsgType, errMsg.PrevMsgType)
+	}
+	if code != 0 && errMsg.Code != code {
+		return fmt.Errorf("expected error code %d, got %d (%s)", code, errMsg.Code, errMsg.ErrString)
+	}
+	return nil
+}
+
+func post(ctx context.Context, t *Target, baseURL string, msgType uint8, body []byte, token string) (*response, error) {
+	uri, err := url.JoinPath(baseURL, "fdo", strconv.Itoa(int(protocol.CurrentVersion)), "msg", strconv.Itoa(int(msgType)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/cbor")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	var client HTTPClient = http.DefaultClient
+	if t.HTTPClient != nil {
+		client = t.HTTPClient
+	}
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending message %d: %w", msgType, err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	respBody, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response to message %d: %w", msgType, err)
+	}
+
+	resp := &response{status: httpResp.StatusCode, header: httpResp.Header, body: respBody}
+	if typ, err := strconv.ParseUint(strings.TrimSpace(httpResp.Header.Get("Message-Type")), 10, 8); err == nil {
+		resp.msgType, resp.hasMsgType = uint8(typ), true
+	}
+	return resp, nil
+}
+
+func randomGUID() (guid protocol.GUID) {
+	_, _ = rand.Read(guid[:])
+	return guid
+}
+
+func mustMarshal(v any) []byte {
+	b, err := cbor.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package speccheck checks FDO implementations against a subset of the
+// requirements of the FIDO Device Onboard specification and reports the result
+// of each requirement.
+//
+// The owner and rendezvous roles are checked by sending messages to a running
+// server over the HTTP binding, so any server may be checked, not only one
+// built with this module. The device role is checked from a recording of the
+// exchanges of a device, as made by record.Transport, so a device may be
+// onboarded against any server and then checked.
+//
+// Requirements are chosen to be verifiable from the outside of an
+// implementation and cover only a small part of the specification. This is
+// not a conformance test suite: requirement IDs are defined by this package,
+// not by the conformance test tools of the FIDO Alliance, and passing every
+// check does not mean that an implementation conforms.
+package speccheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/record"
+)
+
+// Role is the FDO role which a requirement applies to.
+type Role string
+
+// Roles of FDO implementations
+const (
+	RoleDevice     Role = "device"
+	RoleOwner      Role = "owner"
+	RoleRendezvous Role = "rv"
+)
+
+// Status is the result of checking a requirement.
+type Status string
+
+// Statuses of requirements
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	// Skip indicates that the target did not include what the requirement
+	// checks, such as the URL of a server.
+	Skip Status = "skip"
+)
+
+// Target is the implementation under test. Requirements of roles without a
+// target are skipped.
+type Target struct {
+	// OwnerURL is the base URL of the owner service, without /fdo/101/msg.
+	OwnerURL string
+
+	// RendezvousURL is the base URL of the rendezvous server, without
+	// /fdo/101/msg.
+	RendezvousURL string
+
+	// DeviceExchanges are the exchanges of a device onboarding, as recorded
+	// by record.Transport.
+	DeviceExchanges []record.Exchange
+
+	// HTTPClient is used to send messages to servers. If nil,
+	// net/http.DefaultClient is used.
+	HTTPClient HTTPClient
+}
+
+// Requirement is a verifiable requirement of the specification.
+type Requirement struct {
+	ID          string
+	Role        Role
+	Description string
+
+	check func(context.Context, *Target) error
+}
+
+// Result is the result of checking a requirement.
+type Result struct {
+	ID          string        `json:"id"`
+	Role        Role          `json:"role"`
+	Description string        `json:"description"`
+	Status      Status        `json:"status"`
+	Message     string        `json:"message,omitempty"`
+	Duration    time.Duration `json:"duration_ns"`
+}
+
+// Report is the result of checking all requirements.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Requirements returns all requirements, in the order they are checked.
+func Requirements() []Requirement {
+	return slices.Concat(serverRequirements(RoleRendezvous), rendezvousRequirements(),
+		serverRequirements(RoleOwner), deviceRequirements())
+}
+
+// Run checks the requirements of the given roles, or of all roles if none are
+// given, against the target.
+func Run(ctx context.Context, target *Target, roles ...Role) *Report {
+	report := new(Report)
+	for _, req := range Requirements() {
+		if len(roles) > 0 && !slices.Contains(roles, req.Role) {
+			continue
+		}
+		result := Result{ID: req.ID, Role: req.Role, Description: req.Description}
+		start := time.Now()
+		switch err := req.check(ctx, target); {
+		case errors.Is(err, errSkip):
+			result.Status = Skip
+		case err != nil:
+			result.Status, result.Message = Fail, err.Error()
+		default:
+			result.Status = Pass
+		}
+		result.Duration = time.Since(start)
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// Passed reports whether no requirement failed. Skipped requirements do not
+// fail the report.
+func (r *Report) Passed() bool {
+	return !slices.ContainsFunc(r.Results, func(result Result) bool { return result.Status == Fail })
+}
+
+// Count returns the number of requirements with a status.
+func (r *Report) Count(status Status) (n int) {
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText writes a line per requirement followed by a summary.
+func (r *Report) WriteText(w io.Writer) error {
+	for _, result := range r.Results {
+		line := fmt.Sprintf("%-4s %-32s %s", result.Status, result.ID, result.Description)
+		if result.Message != "" {
+			line += ": " + result.Message
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", r.Count(Pass), r.Count(Fail), r.Count(Skip))
+	return err
+}
+
+// WriteJSON writes the report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
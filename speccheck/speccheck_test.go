@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package speccheck_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/record"
+	"github.com/fido-device-onboard/go-fdo/speccheck"
+)
+
+func TestRun(t *testing.T) {
+	server := fdotest.NewServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", transport.Handler{
+		Tokens:       server.State,
+		DIResponder:  server.DI,
+		TO0Responder: server.TO0,
+		TO1Responder: server.TO1,
+		TO2Responder: server.TO2,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dev := server.NewDevice(t, protocol.Secp384r1KeyType)
+	server.RegisterBlob(t, dev.Cred.GUID)
+	server.ResetExchanges()
+	if err := server.Onboard(t, dev, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	target := &speccheck.Target{
+		OwnerURL:        srv.URL,
+		RendezvousURL:   srv.URL,
+		DeviceExchanges: server.Exchanges(t),
+	}
+	report := speccheck.Run(context.Background(), target)
+	var text strings.Builder
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + text.String())
+	if !report.Passed() || report.Count(speccheck.Skip) > 0 {
+		t.Fatal("expected all requirements to pass")
+	}
+	if len(report.Results) != len(speccheck.Requirements()) {
+		t.Errorf("expected %d results, got %d", len(speccheck.Requirements()), len(report.Results))
+	}
+
+	t.Run("roles", func(t *testing.T) {
+		report := speccheck.Run(context.Background(), &speccheck.Target{}, speccheck.RoleDevice)
+		for _, result := range report.Results {
+			if result.Role != speccheck.RoleDevice || result.Status != speccheck.Skip {
+				t.Errorf("expected only skipped device requirements, got %+v", result)
+			}
+		}
+	})
+
+	t.Run("device failure", func(t *testing.T) {
+		// TO2.Done without the preceding messages
+		exchanges := []record.Exchange{
+			{MsgType: protocol.TO2HelloDeviceMsgType, RespType: protocol.TO2ProveOVHdrMsgType},
+			{MsgType: protocol.TO2DoneMsgType, RespType: protocol.TO2Done2MsgType},
+		}
+		report := speccheck.Run(context.Background(), &speccheck.Target{DeviceExchanges: exchanges}, speccheck.RoleDevice)
+		if report.Passed() {
+			t.Fatal("expected failure")
+		}
+		if result := report.Results[0]; result.ID != "DEVICE-MESSAGE-ORDER" || result.Status != speccheck.Fail {
+			t.Errorf("expected message order to fail, got %+v", result)
+		}
+	})
+}
@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package sqlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ncruces/go-sqlite3"
+)
+
+// OperationStats are the cumulative metrics of one kind of SQL statement.
+type OperationStats struct {
+	// Count is the number of statements executed.
+	Count int64
+	// Errors is the number of statements which failed.
+	Errors int64
+	// Rows is the number of rows returned by queries or affected by other
+	// statements.
+	Rows int64
+	// Duration is the total time spent executing statements, including
+	// iterating query results.
+	Duration time.Duration
+	// MaxDuration is the longest time spent executing a single statement.
+	MaxDuration time.Duration
+}
+
+// SlowQuery describes a statement which took longer than
+// DB.SlowQueryThreshold.
+type SlowQuery struct {
+	// Operation is the statement verb and table, i.e. "SELECT sessions".
+	Operation string
+	Query     string
+	Duration  time.Duration
+	Rows      int64
+	Err       error
+}
+
+// Stats returns a snapshot of the metrics of all statements executed since
+// the database was opened, keyed by operation, i.e. "SELECT sessions" or
+// "INSERT owner_vouchers". Statements are only measured for databases
+// created with Open.
+func (db *DB) Stats() map[string]OperationStats {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	stats := make(map[string]OperationStats, len(db.stats))
+	for op, s := range db.stats {
+		stats[op] = *s
+	}
+	return stats
+}
+
+func (db *DB) observe(ctx context.Context, query string, d time.Duration, rows int64, err error) {
+	op := operation(query)
+
+	db.statsMu.Lock()
+	if db.stats == nil {
+		db.stats = make(map[string]*OperationStats)
+	}
+	s, ok := db.stats[op]
+	if !ok {
+		s = new(OperationStats)
+		db.stats[op] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.Rows += rows
+	s.Duration += d
+	s.MaxDuration = max(s.MaxDuration, d)
+	db.statsMu.Unlock()
+
+	if db.SlowQueryThreshold <= 0 || d < db.SlowQueryThreshold {
+		return
+	}
+	slow := SlowQuery{Operation: op, Query: query, Duration: d, Rows: rows, Err: err}
+	if db.SlowQueryLog != nil {
+		db.SlowQueryLog(ctx, slow)
+		return
+	}
+	slog.WarnContext(ctx, "sqlite: slow query", "operation", op, "duration", d, "rows", rows, "error", err)
+}
+
+// operation returns the verb and table of a statement to use as a metric
+// key. Only the forms of statements used by this package are recognized.
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	verb := strings.ToUpper(fields[0])
+
+	var after string
+	switch verb {
+	case "SELECT", "DELETE":
+		after = "FROM"
+	case "INSERT", "REPLACE":
+		after = "INTO"
+	case "UPDATE":
+		return verb + " " + tableName(fields, 1)
+	default:
+		return verb
+	}
+	for i, field := range fields {
+		if strings.EqualFold(field, after) {
+			return verb + " " + tableName(fields, i+1)
+		}
+	}
+	return verb
+}
+
+func tableName(fields []string, i int) string {
+	if i >= len(fields) {
+		return ""
+	}
+	return strings.TrimRight(fields[i], "(,;")
+}
+
+// instrumentedConnector wraps the SQLite driver so that the latency and row
+// count of every statement are recorded, regardless of whether it is run in
+// a transaction.
+type instrumentedConnector struct {
+	driver.Connector
+	db *DB
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{conn: conn, db: c.db}, nil
+}
+
+type instrumentedConn struct {
+	conn driver.Conn
+	db   *DB
+}
+
+var (
+	_ driver.ConnBeginTx        = (*instrumentedConn)(nil)
+	_ driver.ConnPrepareContext = (*instrumentedConn)(nil)
+	_ driver.ExecerContext      = (*instrumentedConn)(nil)
+	_ driver.NamedValueChecker  = (*instrumentedConn)(nil)
+)
+
+// Raw returns the underlying SQLite connection, as the driver's connections
+// do.
+func (c *instrumentedConn) Raw() *sqlite3.Conn {
+	raw, _ := c.conn.(interface{ Raw() *sqlite3.Conn })
+	if raw == nil {
+		return nil
+	}
+	return raw.Raw()
+}
+
+func (c *instrumentedConn) Close() error { return c.conn.Close() }
+
+func (c *instrumentedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if conn, ok := c.conn.(driver.ConnBeginTx); ok {
+		return conn.BeginTx(ctx, opts)
+	}
+	return c.conn.Begin() //nolint:staticcheck // fallback for drivers without BeginTx
+}
+
+func (c *instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if conn, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = conn.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		c.db.observe(ctx, query, 0, 0, err)
+		return nil, err
+	}
+	return &instrumentedStmt{stmt: stmt, query: query, db: c.db}, nil
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := conn.ExecContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	c.db.observe(ctx, query, time.Since(start), rowsAffected(result), err)
+	return result, err
+}
+
+func (c *instrumentedConn) CheckNamedValue(arg *driver.NamedValue) error {
+	if conn, ok := c.conn.(driver.NamedValueChecker); ok {
+		return conn.CheckNamedValue(arg)
+	}
+	return driver.ErrSkip
+}
+
+type instrumentedStmt struct {
+	stmt  driver.Stmt
+	query string
+	db    *DB
+}
+
+var (
+	_ driver.StmtExecContext   = (*instrumentedStmt)(nil)
+	_ driver.StmtQueryContext  = (*instrumentedStmt)(nil)
+	_ driver.NamedValueChecker = (*instrumentedStmt)(nil)
+)
+
+func (s *instrumentedStmt) Close() error  { return s.stmt.Close() }
+func (s *instrumentedStmt) NumInput() int { return s.stmt.NumInput() }
+
+func (s *instrumentedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.stmt.Exec(args) //nolint:staticcheck // required by driver.Stmt
+}
+
+func (s *instrumentedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.stmt.Query(args) //nolint:staticcheck // required by driver.Stmt
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	stmt, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, args)
+	s.db.observe(ctx, s.query, time.Since(start), rowsAffected(result), err)
+	return result, err
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	stmt, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, args)
+	if err != nil {
+		s.db.observe(ctx, s.query, time.Since(start), 0, err)
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, ctx: ctx, stmt: s, start: start}, nil
+}
+
+func (s *instrumentedStmt) CheckNamedValue(arg *driver.NamedValue) error {
+	if stmt, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return stmt.CheckNamedValue(arg)
+	}
+	return driver.ErrSkip
+}
+
+// instrumentedRows records its query when closed, so that the duration
+// includes stepping through the results.
+type instrumentedRows struct {
+	driver.Rows
+	ctx   context.Context
+	stmt  *instrumentedStmt
+	start time.Time
+	count int64
+	err   error
+}
+
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = (*instrumentedRows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*instrumentedRows)(nil)
+)
+
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.count++
+	case !errors.Is(err, io.EOF):
+		r.err = err
+	}
+	return err
+}
+
+func (r *instrumentedRows) Close() error {
+	err := r.Rows.Close()
+	r.stmt.db.observe(r.ctx, r.stmt.query, time.Since(r.start), r.count, r.err)
+	return err
+}
+
+func (r *instrumentedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rows.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *instrumentedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rows.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func rowsAffected(result driver.Result) int64 {
+	if result == nil {
+		return 0
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultBusyTimeout is how long a connection waits for a lock held by
+// another connection when WithBusyTimeout is not used.
+const DefaultBusyTimeout = time.Minute
+
+// Synchronous is a mode of the synchronous pragma, which trades durability
+// for write throughput.
+type Synchronous string
+
+// Synchronous modes. See https://www.sqlite.org/pragma.html#pragma_synchronous.
+const (
+	SynchronousOff    Synchronous = "off"
+	SynchronousNormal Synchronous = "normal"
+	SynchronousFull   Synchronous = "full"
+	SynchronousExtra  Synchronous = "extra"
+)
+
+// Option configures a database opened with Open.
+type Option func(*options)
+
+type options struct {
+	wal          bool
+	busyTimeout  time.Duration
+	synchronous  Synchronous
+	maxOpenConns int
+	readConns    int
+}
+
+// WithWAL enables write-ahead logging, so that readers do not block writers
+// and a writer does not block readers. This greatly improves throughput when
+// many sessions are served concurrently.
+func WithWAL() Option {
+	return func(o *options) { o.wal = true }
+}
+
+// WithBusyTimeout sets how long a connection waits for a lock held by
+// another connection before failing with SQLITE_BUSY. A zero duration fails
+// immediately.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) { o.busyTimeout = d }
+}
+
+// WithSynchronous sets the synchronous pragma. SynchronousNormal is safe
+// from corruption when used with WithWAL, but a power loss may roll back the
+// most recent transactions.
+func WithSynchronous(mode Synchronous) Option {
+	return func(o *options) { o.synchronous = mode }
+}
+
+// WithMaxOpenConns limits the number of pooled connections. If unset, the
+// number of connections is unlimited.
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) { o.maxOpenConns = n }
+}
+
+// WithReadPool opens a separate pool of at most n read-only connections for
+// voucher and GUID lookups, so that they do not wait on connections held by
+// session writes. It should be used with WithWAL, so that reads are not
+// blocked by writes. See NewReadWrite.
+func WithReadPool(n int) Option {
+	return func(o *options) { o.readConns = n }
+}
+
+// query returns the URI parameters of the database file. Pragmas are set on
+// every pooled connection.
+func (o options) query(password string) (string, error) {
+	var params []string
+	if password != "" {
+		// The key must be set before any other pragma reads the database
+		params = append(params, "vfs=xts", fmt.Sprintf("_pragma=textkey(%q)", password), "_pragma=temp_store(memory)")
+	}
+	if o.busyTimeout < 0 {
+		return "", fmt.Errorf("invalid busy timeout: %s", o.busyTimeout)
+	}
+	params = append(params,
+		"_pragma=foreign_keys(1)",
+		fmt.Sprintf("_pragma=busy_timeout(%d)", o.busyTimeout.Milliseconds()),
+	)
+	if o.wal {
+		params = append(params, "_pragma=journal_mode(wal)")
+	}
+	switch o.synchronous {
+	case "":
+	case SynchronousOff, SynchronousNormal, SynchronousFull, SynchronousExtra:
+		params = append(params, fmt.Sprintf("_pragma=synchronous(%s)", o.synchronous))
+	default:
+		return "", fmt.Errorf("invalid synchronous mode: %q", o.synchronous)
+	}
+	return "?" + strings.Join(params, "&"), nil
+}
@@ -5,6 +5,7 @@
 package sqlite
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/hmac"
@@ -18,10 +19,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"maps"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ncruces/go-sqlite3/driver"    // Load database/sql driver
@@ -41,32 +44,84 @@ type DB struct {
 	// Log all SQL queries to this optional writer.
 	DebugLog io.Writer
 
+	// Optionally encrypt key exchange sessions before storing them, so that
+	// a database dump does not reveal live session keys. Sessions stored
+	// without a sealer cannot be loaded once a sealer is set.
+	SessionSealer kex.Sealer
+
+	// SessionLockTTL is the duration of the lease taken by LockSession,
+	// after which the session may be locked by another replica even if the
+	// lease was not released. If zero, DefaultSessionLockTTL is used.
+	SessionLockTTL time.Duration
+
+	// SlowQueryThreshold, if non-zero, is the duration after which a
+	// statement is reported as slow. Statements are only measured for
+	// databases created with Open.
+	SlowQueryThreshold time.Duration
+
+	// SlowQueryLog is called for each statement taking longer than
+	// SlowQueryThreshold. If nil, slow statements are logged with slog.
+	SlowQueryLog func(context.Context, SlowQuery)
+
+	statsMu sync.Mutex
+	stats   map[string]*OperationStats
+
 	db *sql.DB
+	ro *sql.DB
 }
 
-// Open creates or opens a SQLite database file using a single non-pooled
-// connection. If a password is specified, then the xts VFS will be used
-// with a text key.
-func Open(filename, password string) (*DB, error) {
-	var query string
-	if password != "" {
-		query += fmt.Sprintf("?vfs=xts&_pragma=textkey(%q)&_pragma=temp_store(memory)", password)
+// Open creates or opens a SQLite database file. If a password is specified,
+// then the xts VFS will be used with a text key.
+//
+// Every statement executed by the returned DB is measured, see Stats and
+// SlowQueryThreshold.
+func Open(filename, password string, opts ...Option) (*DB, error) {
+	o := options{busyTimeout: DefaultBusyTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	query, err := o.query(password)
+	if err != nil {
+		return nil, err
 	}
 	connector, err := (&driver.SQLite{}).OpenConnector("file:" + filepath.Clean(filename) + query)
 	if err != nil {
 		return nil, fmt.Errorf("error creating sqlite connector: %w", err)
 	}
-	db := sql.OpenDB(connector)
-	if err := Init(db); err != nil {
+	db := new(DB)
+	db.db = sql.OpenDB(&instrumentedConnector{Connector: connector, db: db})
+	if o.maxOpenConns > 0 {
+		db.db.SetMaxOpenConns(o.maxOpenConns)
+	}
+	if err := Init(db.db); err != nil {
+		_ = db.db.Close()
 		return nil, err
 	}
-	return New(db), nil
+	if o.readConns > 0 {
+		connector, err := (&driver.SQLite{}).OpenConnector("file:" + filepath.Clean(filename) + query + "&_pragma=query_only(1)")
+		if err != nil {
+			_ = db.db.Close()
+			return nil, fmt.Errorf("error creating sqlite read connector: %w", err)
+		}
+		db.ro = sql.OpenDB(&instrumentedConnector{Connector: connector, db: db})
+		db.ro.SetMaxOpenConns(o.readConns)
+	}
+	return db, nil
 }
 
 // New creates a DB. The expected tables must already be created and pragmas
 // must already be set, including foreign_keys=ON.
 func New(db *sql.DB) *DB { return &DB{db: db} }
 
+// NewReadWrite creates a DB which uses the read pool for voucher and GUID
+// lookups and the write pool for everything else, including all session
+// state. The read pool may be a replica of the write pool's database, in
+// which case lookups may briefly return stale results.
+//
+// As with New, the expected tables must already be created in the write
+// pool's database.
+func NewReadWrite(write, read *sql.DB) *DB { return &DB{db: write, ro: read} }
+
 // Init ensures all tables are created and pragma are set. It does not
 // recognize if tables have been created with invalid schemas.
 //
@@ -85,11 +140,25 @@ func Init(db *sql.DB) error {
 			, pkcs8 BLOB NOT NULL
 			, x509_chain BLOB NOT NULL
 			)`,
+		`CREATE TABLE IF NOT EXISTS named_mfg_keys
+			( name TEXT NOT NULL
+			, type INTEGER NOT NULL
+			, pkcs8 BLOB NOT NULL
+			, x509_chain BLOB NOT NULL
+			, added INTEGER NOT NULL
+			, PRIMARY KEY (name, type)
+			)`,
 		`CREATE TABLE IF NOT EXISTS owner_keys
 			( type INTEGER UNIQUE NOT NULL
 			, pkcs8 BLOB NOT NULL
 			, x509_chain BLOB
 			)`,
+		`CREATE TABLE IF NOT EXISTS previous_owner_keys
+			( type INTEGER NOT NULL
+			, pkcs8 BLOB NOT NULL
+			, x509_chain BLOB
+			, rotated INTEGER NOT NULL
+			)`,
 		`CREATE TABLE IF NOT EXISTS rv_blobs
 			( guid BLOB PRIMARY KEY
 			, rv BLOB NOT NULL
@@ -139,6 +208,14 @@ func Init(db *sql.DB) error {
 			( guid BLOB PRIMARY KEY
 			, cbor BLOB NOT NULL
 			)`,
+		`CREATE TABLE IF NOT EXISTS voucher_mfg_keys
+			( guid BLOB PRIMARY KEY
+			, name TEXT NOT NULL
+			)`,
+		`CREATE TABLE IF NOT EXISTS voucher_metadata
+			( guid BLOB PRIMARY KEY
+			, cbor BLOB NOT NULL
+			)`,
 		`CREATE TABLE IF NOT EXISTS owner_vouchers
 			( guid BLOB PRIMARY KEY
 			, cbor BLOB NOT NULL
@@ -155,6 +232,97 @@ func Init(db *sql.DB) error {
 			, cbor BLOB NOT NULL
 			, FOREIGN KEY(session) REFERENCES sessions(id) ON DELETE CASCADE
 			)`,
+		`CREATE TABLE IF NOT EXISTS module_states
+			( guid BLOB NOT NULL
+			, module TEXT NOT NULL
+			, state BLOB NOT NULL
+			, PRIMARY KEY(guid, module)
+			)`,
+		`CREATE TABLE IF NOT EXISTS to0_registrations
+			( guid BLOB NOT NULL
+			, rv_url TEXT NOT NULL
+			, wait_seconds INTEGER NOT NULL
+			, expires INTEGER NOT NULL
+			, refresh_at INTEGER NOT NULL
+			, PRIMARY KEY(guid, rv_url)
+			)`,
+		`CREATE INDEX IF NOT EXISTS to0_registrations_refresh_at
+			ON to0_registrations(refresh_at)`,
+		`CREATE TABLE IF NOT EXISTS onboarding_events
+			( guid BLOB NOT NULL
+			, time INTEGER NOT NULL
+			, state TEXT NOT NULL
+			, error TEXT
+			, replacement_guid BLOB
+			, owner_key_hash BLOB
+			)`,
+		`CREATE INDEX IF NOT EXISTS onboarding_events_guid
+			ON onboarding_events(guid)`,
+		`CREATE TABLE IF NOT EXISTS denied_devices
+			( guid BLOB PRIMARY KEY
+			)`,
+		`CREATE TABLE IF NOT EXISTS session_locks
+			( session BLOB PRIMARY KEY
+			, holder BLOB NOT NULL
+			, expires INTEGER NOT NULL
+			, FOREIGN KEY(session) REFERENCES sessions(id) ON DELETE CASCADE
+			)`,
+		`CREATE TABLE IF NOT EXISTS voucher_leases
+			( guid BLOB PRIMARY KEY
+			, expires INTEGER NOT NULL
+			)`,
+		`CREATE TABLE IF NOT EXISTS nonces
+			( nonce BLOB PRIMARY KEY
+			, expires INTEGER NOT NULL
+			)`,
+		`CREATE TABLE IF NOT EXISTS devmods
+			( guid BLOB PRIMARY KEY
+			, devmod BLOB NOT NULL
+			, modules BLOB NOT NULL
+			, time INTEGER NOT NULL
+			)`,
+		`CREATE TABLE IF NOT EXISTS device_statuses
+			( guid BLOB PRIMARY KEY
+			, state TEXT NOT NULL
+			, time INTEGER NOT NULL
+			)`,
+		`CREATE INDEX IF NOT EXISTS device_statuses_state
+			ON device_statuses(state, time)`,
+		`CREATE TABLE IF NOT EXISTS device_status_times
+			( guid BLOB NOT NULL
+			, state TEXT NOT NULL
+			, time INTEGER NOT NULL
+			, PRIMARY KEY(guid, state)
+			)`,
+		`CREATE TABLE IF NOT EXISTS protocol_traces
+			( correlation_id INTEGER NOT NULL
+			, guid BLOB
+			, time INTEGER NOT NULL
+			, duration INTEGER NOT NULL
+			, msg_type INTEGER NOT NULL
+			, request_size INTEGER NOT NULL
+			, resp_type INTEGER NOT NULL
+			, response_size INTEGER NOT NULL
+			, error TEXT
+			, summary TEXT
+			)`,
+		`CREATE INDEX IF NOT EXISTS protocol_traces_correlation_id
+			ON protocol_traces(correlation_id)`,
+		`CREATE INDEX IF NOT EXISTS protocol_traces_guid
+			ON protocol_traces(guid)`,
+		`CREATE TABLE IF NOT EXISTS hello_devices
+			( guid BLOB NOT NULL
+			, correlation_id INTEGER NOT NULL
+			, time INTEGER NOT NULL
+			, raw BLOB NOT NULL
+			, hash_alg INTEGER NOT NULL
+			, hash BLOB NOT NULL
+			, error TEXT
+			)`,
+		`CREATE INDEX IF NOT EXISTS hello_devices_guid
+			ON hello_devices(guid)`,
+		`CREATE INDEX IF NOT EXISTS hello_devices_correlation_id
+			ON hello_devices(correlation_id)`,
 	}
 	for _, sql := range stmts {
 		if _, err := db.Exec(sql); err != nil {
@@ -173,11 +341,28 @@ func Init(db *sql.DB) error {
 // If the database connection is associated with unfinalized prepared
 // statements, open blob handles, and/or unfinished backup objects, Close will
 // leave the database connection open and return [sqlite3.BUSY].
-func (db *DB) Close() error { return db.db.Close() }
+func (db *DB) Close() error {
+	if db.ro != nil {
+		if err := db.ro.Close(); err != nil {
+			_ = db.db.Close()
+			return err
+		}
+	}
+	return db.db.Close()
+}
 
 // DB returns the underlying database/sql DB.
 func (db *DB) DB() *sql.DB { return db.db }
 
+// ReadDB returns the underlying database/sql DB used for voucher and GUID
+// lookups. Unless a read pool is used, it is the same as DB.
+func (db *DB) ReadDB() *sql.DB {
+	if db.ro != nil {
+		return db.ro
+	}
+	return db.db
+}
+
 type debugLogKey struct{}
 
 func (db *DB) debugCtx(parent context.Context) context.Context {
@@ -196,14 +381,30 @@ func debug(ctx context.Context, format string, a ...any) {
 // Compile-time check for interface implementation correctness
 var _ interface {
 	protocol.TokenService
+	protocol.SessionLocker
 	fdo.DISessionState
 	fdo.TO0SessionState
 	fdo.TO1SessionState
 	fdo.TO2SessionState
 	fdo.RendezvousBlobPersistentState
+	fdo.RendezvousBlobAdminState
 	fdo.ManufacturerVoucherPersistentState
 	fdo.OwnerVoucherPersistentState
 	fdo.OwnerKeyPersistentState
+	fdo.OwnerKeyRotationPersistentState
+	fdo.OwnerVoucherListPersistentState
+	fdo.ManufacturerKeysPersistentState
+	fdo.VoucherManufacturerKeyPersistentState
+	fdo.VoucherMetadataPersistentState
+	fdo.OwnerVoucherLeasePersistentState
+	fdo.ModuleStatePersistentState
+	fdo.TO0RegistrationPersistentState
+	fdo.OnboardingHistoryPersistentState
+	fdo.DeviceDenyListPersistentState
+	fdo.DevmodPersistentState
+	fdo.DeviceStatusPersistentState
+	fdo.ProtocolTracePersistentState
+	fdo.HelloDevicePersistentState
 	fdo.AutoExtend
 	fdo.AutoTO0
 } = (*DB)(nil)
@@ -299,6 +500,66 @@ func (db *DB) InvalidateToken(ctx context.Context) error {
 	return err
 }
 
+// DefaultSessionLockTTL is the default duration of session lock leases.
+const DefaultSessionLockTTL = time.Minute
+
+// sessionLockPollInterval is how often a locked session is checked while
+// waiting to acquire its lease.
+const sessionLockPollInterval = 50 * time.Millisecond
+
+// LockSession implements [protocol.SessionLocker] with a lease stored in the
+// database, so that replicas sharing the database handle messages of a
+// session one at a time. Lease expiration is stored with a precision of
+// milliseconds.
+func (db *DB) LockSession(ctx context.Context) (func(), error) {
+	sessID, ok := db.sessionID(ctx)
+	if !ok {
+		return nil, fdo.ErrInvalidSession
+	}
+	holder := make([]byte, 16)
+	if _, err := rand.Read(holder); err != nil {
+		return nil, err
+	}
+	ttl := db.SessionLockTTL
+	if ttl <= 0 {
+		ttl = DefaultSessionLockTTL
+	}
+
+	// Take the lease if there is none or it has expired
+	const query = `INSERT INTO session_locks (session, holder, expires) VALUES (?, ?, ?)
+		ON CONFLICT(session) DO UPDATE SET holder = excluded.holder, expires = excluded.expires
+		WHERE session_locks.expires < ?`
+	for {
+		now := time.Now()
+		debug(db.debugCtx(ctx), "sqlite: %s\n%x", query, sessID)
+		result, err := db.db.ExecContext(ctx, query, sessID, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring session lock: %w", err)
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("error acquiring session lock: %w", err)
+		} else if n > 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sessionLockPollInterval):
+		}
+	}
+
+	// Release the lease even if the context of the message was canceled
+	ctx = context.WithoutCancel(ctx)
+	return func() {
+		const query = `DELETE FROM session_locks WHERE session = ? AND holder = ?`
+		debug(db.debugCtx(ctx), "sqlite: %s\n%x", query, sessID)
+		if _, err := db.db.ExecContext(ctx, query, sessID, holder); err != nil {
+			slog.Warn("error releasing session lock", "error", err)
+		}
+	}, nil
+}
+
 func (db *DB) sessionID(ctx context.Context) ([]byte, bool) {
 	// Get HMAC secret
 	secret, err := db.loadOrStoreSecret(ctx)
@@ -312,7 +573,7 @@ func (db *DB) sessionID(ctx context.Context) ([]byte, bool) {
 		return nil, false
 	}
 	rawToken, err := base64.RawURLEncoding.DecodeString(token)
-	if err != nil {
+	if err != nil || len(rawToken) < sessionIDSize {
 		return nil, false
 	}
 	id, mac1 := rawToken[:sessionIDSize], rawToken[sessionIDSize:]
@@ -358,6 +619,11 @@ func (db *DB) query(ctx context.Context, table string, columns []string, where m
 	return query(db.debugCtx(ctx), db.db, table, columns, where, into...)
 }
 
+// lookup is like query, but uses the read pool.
+func (db *DB) lookup(ctx context.Context, table string, columns []string, where map[string]any, into ...any) error {
+	return query(db.debugCtx(ctx), db.ReadDB(), table, columns, where, into...)
+}
+
 type execer interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
@@ -530,6 +796,136 @@ func (db *DB) ManufacturerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.
 	return key.(crypto.Signer), chain, nil
 }
 
+// AddNamedManufacturerKey adds a manufacturer key, replacing any key of the
+// same name and type. The most recently added key is listed first by
+// [DB.ManufacturerKeys].
+func (db *DB) AddNamedManufacturerKey(ctx context.Context, name string, keyType protocol.KeyType, key crypto.Signer, chain []*x509.Certificate) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return db.insert(ctx, "named_mfg_keys", map[string]any{
+		"name":       name,
+		"type":       int(keyType),
+		"pkcs8":      der,
+		"x509_chain": derEncode(chain),
+		"added":      time.Now().UnixMilli(),
+	}, map[string]any{
+		"name": name,
+		"type": int(keyType),
+	})
+}
+
+// RemoveNamedManufacturerKey deletes a manufacturer key. If none exists,
+// ErrNotFound is returned.
+func (db *DB) RemoveNamedManufacturerKey(ctx context.Context, name string, keyType protocol.KeyType) error {
+	return remove(db.debugCtx(ctx), db.db, "named_mfg_keys", map[string]any{
+		"name": name,
+		"type": int(keyType),
+	})
+}
+
+// ManufacturerKeys returns all named manufacturer keys of a type, most
+// recently added first, followed by the unnamed key returned by
+// [DB.ManufacturerKey].
+func (db *DB) ManufacturerKeys(ctx context.Context, keyType protocol.KeyType) ([]fdo.ManufacturerKey, error) {
+	ctx = db.debugCtx(ctx)
+
+	const query = `SELECT name, pkcs8, x509_chain FROM named_mfg_keys
+		WHERE type = ? ORDER BY added DESC, rowid DESC`
+	debug(ctx, "sqlite: %s\n%d", query, keyType)
+	rows, err := db.db.QueryContext(ctx, query, int(keyType))
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var keys []fdo.ManufacturerKey
+	for rows.Next() {
+		var name string
+		var keyDer, certChainDer []byte
+		if err := rows.Scan(&name, &keyDer, &certChainDer); err != nil {
+			return nil, fmt.Errorf("error scanning manufacturer key: %w", err)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(keyDer)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing manufacturer key: %w", err)
+		}
+		chain, err := x509.ParseCertificates(certChainDer)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing manufacturer certificate chain: %w", err)
+		}
+		keys = append(keys, fdo.ManufacturerKey{
+			Name:  name,
+			Type:  keyType,
+			Key:   key.(crypto.Signer),
+			Chain: chain,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+
+	key, chain, err := db.ManufacturerKey(keyType)
+	if errors.Is(err, fdo.ErrNotFound) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return append(keys, fdo.ManufacturerKey{Type: keyType, Key: key, Chain: chain}), nil
+}
+
+// SetVoucherManufacturerKey records the name of the manufacturer key which
+// signed a voucher.
+func (db *DB) SetVoucherManufacturerKey(ctx context.Context, guid protocol.GUID, name string) error {
+	return db.insert(ctx, "voucher_mfg_keys", map[string]any{
+		"guid": guid[:],
+		"name": name,
+	}, map[string]any{"guid": guid[:]})
+}
+
+// VoucherManufacturerKey returns the name of the manufacturer key which
+// signed a voucher.
+func (db *DB) VoucherManufacturerKey(ctx context.Context, guid protocol.GUID) (string, error) {
+	var name string
+	if err := db.lookup(ctx, "voucher_mfg_keys", []string{"name"},
+		map[string]any{"guid": guid[:]},
+		&name,
+	); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// SetVoucherMetadata stores the metadata of a voucher, replacing any
+// previously stored.
+func (db *DB) SetVoucherMetadata(ctx context.Context, guid protocol.GUID, metadata map[string]string) error {
+	data, err := cbor.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshaling voucher metadata: %w", err)
+	}
+	return db.insert(ctx, "voucher_metadata", map[string]any{
+		"guid": guid[:],
+		"cbor": data,
+	}, map[string]any{"guid": guid[:]})
+}
+
+// VoucherMetadata returns the metadata of a voucher.
+func (db *DB) VoucherMetadata(ctx context.Context, guid protocol.GUID) (map[string]string, error) {
+	var data []byte
+	if err := db.lookup(ctx, "voucher_metadata", []string{"cbor"},
+		map[string]any{"guid": guid[:]},
+		&data,
+	); err != nil {
+		return nil, err
+	}
+	var metadata map[string]string
+	if err := cbor.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("error unmarshaling voucher metadata: %w", err)
+	}
+	return metadata, nil
+}
+
 // SetDeviceCertChain sets the device certificate chain generated from
 // DI.AppStart info.
 func (db *DB) SetDeviceCertChain(ctx context.Context, chain []*x509.Certificate) error {
@@ -889,7 +1285,7 @@ func (db *DB) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Vouch
 // Voucher retrieves a voucher by GUID.
 func (db *DB) Voucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
 	var data []byte
-	if err := db.query(ctx, "owner_vouchers", []string{"cbor"},
+	if err := db.lookup(ctx, "owner_vouchers", []string{"cbor"},
 		map[string]any{"guid": guid[:]},
 		&data,
 	); err != nil {
@@ -906,6 +1302,409 @@ func (db *DB) Voucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, er
 	return &ov, nil
 }
 
+// SetModuleState stores the state of an owner service info module for the
+// device with the given (current voucher) GUID.
+func (db *DB) SetModuleState(ctx context.Context, guid protocol.GUID, module string, state []byte) error {
+	return db.insert(ctx, "module_states",
+		map[string]any{
+			"guid":   guid[:],
+			"module": module,
+			"state":  state,
+		},
+		map[string]any{
+			"guid":   guid[:],
+			"module": module,
+		})
+}
+
+// ModuleState returns the state of an owner service info module for a
+// device. If no state has been stored, ErrNotFound is returned.
+func (db *DB) ModuleState(ctx context.Context, guid protocol.GUID, module string) ([]byte, error) {
+	var state []byte
+	if err := db.query(ctx, "module_states", []string{"state"},
+		map[string]any{
+			"guid":   guid[:],
+			"module": module,
+		},
+		&state,
+	); err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fdo.ErrNotFound
+	}
+	return state, nil
+}
+
+// RemoveModuleStates removes the state of all owner service info modules
+// for a device. It is called when TO2 completes.
+func (db *DB) RemoveModuleStates(ctx context.Context, guid protocol.GUID) error {
+	return remove(db.debugCtx(ctx), db.db, "module_states", map[string]any{"guid": guid[:]})
+}
+
+// SetTO0Registration stores the most recent rendezvous blob registration of
+// a device with a Rendezvous Server, replacing any previous one. Times are
+// stored with a precision of seconds.
+func (db *DB) SetTO0Registration(ctx context.Context, reg fdo.TO0Registration) error {
+	return db.insert(ctx, "to0_registrations",
+		map[string]any{
+			"guid":         reg.GUID[:],
+			"rv_url":       reg.RvURL,
+			"wait_seconds": reg.WaitSeconds,
+			"expires":      reg.Expires.Unix(),
+			"refresh_at":   reg.RefreshAt.Unix(),
+		},
+		map[string]any{
+			"guid":   reg.GUID[:],
+			"rv_url": reg.RvURL,
+		})
+}
+
+// TO0Registrations returns the most recent rendezvous blob registration of a
+// device with each Rendezvous Server, ordered by RefreshAt.
+func (db *DB) TO0Registrations(ctx context.Context, guid protocol.GUID) ([]fdo.TO0Registration, error) {
+	return db.to0Registrations(ctx, `SELECT guid, rv_url, wait_seconds, expires, refresh_at
+		FROM to0_registrations WHERE guid = ?
+		ORDER BY refresh_at`, guid[:])
+}
+
+// LapsingTO0Registrations returns the registrations of owned vouchers which
+// should be refreshed before the given time, ordered by RefreshAt.
+func (db *DB) LapsingTO0Registrations(ctx context.Context, before time.Time) ([]fdo.TO0Registration, error) {
+	return db.to0Registrations(ctx, `SELECT r.guid, r.rv_url, r.wait_seconds, r.expires, r.refresh_at
+		FROM to0_registrations r JOIN owner_vouchers v ON r.guid = v.guid
+		WHERE r.refresh_at < ?
+		ORDER BY r.refresh_at`, before.Unix())
+}
+
+func (db *DB) to0Registrations(ctx context.Context, query string, args ...any) ([]fdo.TO0Registration, error) {
+	ctx = db.debugCtx(ctx)
+
+	debug(ctx, "sqlite: %s\n%+v", query, args)
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var regs []fdo.TO0Registration
+	for rows.Next() {
+		var guid []byte
+		var rvURL string
+		var waitSeconds, expires, refreshAt int64
+		if err := rows.Scan(&guid, &rvURL, &waitSeconds, &expires, &refreshAt); err != nil {
+			return nil, fmt.Errorf("error scanning TO0 registration: %w", err)
+		}
+		reg := fdo.TO0Registration{
+			RvURL:       rvURL,
+			WaitSeconds: uint32(waitSeconds),
+			Expires:     time.Unix(expires, 0),
+			RefreshAt:   time.Unix(refreshAt, 0),
+		}
+		copy(reg.GUID[:], guid)
+		regs = append(regs, reg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return regs, nil
+}
+
+// AddOnboardingEvent appends an event to the history of a device. Times are
+// stored with a precision of seconds.
+func (db *DB) AddOnboardingEvent(ctx context.Context, event fdo.OnboardingEvent) error {
+	kvs := map[string]any{
+		"guid":  event.GUID[:],
+		"time":  event.Time.Unix(),
+		"state": string(event.State),
+	}
+	if event.Err != "" {
+		kvs["error"] = event.Err
+	}
+	if event.ReplacementGUID != nil {
+		kvs["replacement_guid"] = event.ReplacementGUID[:]
+	}
+	if event.OwnerKeyHash != nil {
+		kvs["owner_key_hash"] = event.OwnerKeyHash
+	}
+	return db.insert(ctx, "onboarding_events", kvs, nil)
+}
+
+// OnboardingHistory returns the events of a device, oldest first. If none
+// have been recorded, the result is empty.
+func (db *DB) OnboardingHistory(ctx context.Context, guid protocol.GUID) ([]fdo.OnboardingEvent, error) {
+	return db.onboardingEvents(ctx, `SELECT guid, time, state, error, replacement_guid, owner_key_hash
+		FROM onboarding_events WHERE guid = ? ORDER BY rowid`, guid[:])
+}
+
+// LatestOnboardingEvents returns the most recent event of each device whose
+// most recent event has the given state, oldest first.
+func (db *DB) LatestOnboardingEvents(ctx context.Context, state fdo.OnboardingState) ([]fdo.OnboardingEvent, error) {
+	return db.onboardingEvents(ctx, `SELECT e.guid, e.time, e.state, e.error, e.replacement_guid, e.owner_key_hash
+		FROM onboarding_events e
+		WHERE e.rowid = (SELECT MAX(rowid) FROM onboarding_events WHERE guid = e.guid)
+			AND e.state = ?
+		ORDER BY e.rowid`, string(state))
+}
+
+func (db *DB) onboardingEvents(ctx context.Context, query string, args ...any) ([]fdo.OnboardingEvent, error) {
+	ctx = db.debugCtx(ctx)
+
+	debug(ctx, "sqlite: %s\n%+v", query, args)
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var events []fdo.OnboardingEvent
+	for rows.Next() {
+		var guid, replacement, ownerKeyHash []byte
+		var unix int64
+		var state string
+		var errString sql.NullString
+		if err := rows.Scan(&guid, &unix, &state, &errString, &replacement, &ownerKeyHash); err != nil {
+			return nil, fmt.Errorf("error scanning onboarding event: %w", err)
+		}
+		event := fdo.OnboardingEvent{
+			Time:         time.Unix(unix, 0),
+			State:        fdo.OnboardingState(state),
+			Err:          errString.String,
+			OwnerKeyHash: ownerKeyHash,
+		}
+		copy(event.GUID[:], guid)
+		if replacement != nil {
+			event.ReplacementGUID = new(protocol.GUID)
+			copy(event.ReplacementGUID[:], replacement)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return events, nil
+}
+
+// DenyDevice adds a device to the deny list.
+func (db *DB) DenyDevice(ctx context.Context, guid protocol.GUID) error {
+	return db.insertOrIgnore(ctx, "denied_devices", map[string]any{"guid": guid[:]})
+}
+
+// AllowDevice removes a device from the deny list. If the device is not
+// denied, ErrNotFound is returned.
+func (db *DB) AllowDevice(ctx context.Context, guid protocol.GUID) error {
+	ctx = db.debugCtx(ctx)
+
+	const query = `DELETE FROM denied_devices WHERE guid = ?`
+	debug(ctx, "sqlite: %s\n%x", query, guid[:])
+	result, err := db.db.ExecContext(ctx, query, guid[:])
+	if err != nil {
+		return fmt.Errorf("error removing device from deny list: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error removing device from deny list: %w", err)
+	} else if n == 0 {
+		return fdo.ErrNotFound
+	}
+	return nil
+}
+
+// DeviceDenied returns whether a device is on the deny list.
+func (db *DB) DeviceDenied(ctx context.Context, guid protocol.GUID) (bool, error) {
+	var denied []byte
+	if err := db.query(ctx, "denied_devices", []string{"guid"},
+		map[string]any{"guid": guid[:]},
+		&denied,
+	); errors.Is(err, fdo.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddNonce records an issued nonce until it expires. Expired nonces are
+// removed at the same time.
+func (db *DB) AddNonce(ctx context.Context, nonce protocol.Nonce, expires time.Time) error {
+	ctx = db.debugCtx(ctx)
+
+	const cleanup = `DELETE FROM nonces WHERE expires < ?`
+	debug(ctx, "sqlite: %s", cleanup)
+	if _, err := db.db.ExecContext(ctx, cleanup, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("error removing expired nonces: %w", err)
+	}
+
+	const query = `INSERT INTO nonces (nonce, expires) VALUES (?, ?)`
+	debug(ctx, "sqlite: %s\n%x", query, nonce[:])
+	if _, err := db.db.ExecContext(ctx, query, nonce[:], expires.UnixMilli()); err != nil {
+		return fmt.Errorf("error adding nonce: %w", err)
+	}
+	return nil
+}
+
+// ConsumeNonce removes a nonce. If it is not found or has expired,
+// ErrNotFound is returned.
+func (db *DB) ConsumeNonce(ctx context.Context, nonce protocol.Nonce) error {
+	ctx = db.debugCtx(ctx)
+
+	const query = `DELETE FROM nonces WHERE nonce = ? AND expires >= ?`
+	debug(ctx, "sqlite: %s\n%x", query, nonce[:])
+	result, err := db.db.ExecContext(ctx, query, nonce[:], time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("error consuming nonce: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error consuming nonce: %w", err)
+	} else if n == 0 {
+		return fdo.ErrNotFound
+	}
+	return nil
+}
+
+// SetDevmod stores the devmod service info of a device, replacing any
+// previous. Times are stored with a precision of seconds.
+func (db *DB) SetDevmod(ctx context.Context, guid protocol.GUID, devmod fdo.DeviceDevmod) error {
+	devmodCBOR, err := cbor.Marshal(devmod.Devmod)
+	if err != nil {
+		return fmt.Errorf("error marshaling devmod: %w", err)
+	}
+	modulesCBOR, err := cbor.Marshal(devmod.Modules)
+	if err != nil {
+		return fmt.Errorf("error marshaling devmod modules: %w", err)
+	}
+	return db.insert(ctx, "devmods",
+		map[string]any{
+			"guid":    guid[:],
+			"devmod":  devmodCBOR,
+			"modules": modulesCBOR,
+			"time":    devmod.Time.Unix(),
+		},
+		map[string]any{
+			"guid": guid[:],
+		})
+}
+
+// Devmod returns the devmod service info of a device. If none has been
+// stored, ErrNotFound is returned.
+func (db *DB) Devmod(ctx context.Context, guid protocol.GUID) (*fdo.DeviceDevmod, error) {
+	var devmodCBOR, modulesCBOR []byte
+	var unix int64
+	if err := db.query(ctx, "devmods", []string{"devmod", "modules", "time"},
+		map[string]any{"guid": guid[:]},
+		&devmodCBOR, &modulesCBOR, &unix,
+	); err != nil {
+		return nil, err
+	}
+	devmod := fdo.DeviceDevmod{Time: time.Unix(unix, 0)}
+	if err := cbor.Unmarshal(devmodCBOR, &devmod.Devmod); err != nil {
+		return nil, fmt.Errorf("error unmarshaling devmod: %w", err)
+	}
+	if err := cbor.Unmarshal(modulesCBOR, &devmod.Modules); err != nil {
+		return nil, fmt.Errorf("error unmarshaling devmod modules: %w", err)
+	}
+	return &devmod, nil
+}
+
+// UpdateDeviceStatus moves a device to a lifecycle state at the given time.
+// Times are stored with a precision of seconds.
+func (db *DB) UpdateDeviceStatus(ctx context.Context, guid protocol.GUID, state fdo.DeviceLifecycle, at time.Time) error {
+	ctx = db.debugCtx(ctx)
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var current string
+	if err := query(ctx, tx, "device_statuses", []string{"state"},
+		map[string]any{"guid": guid[:]},
+		&current,
+	); err != nil && !errors.Is(err, fdo.ErrNotFound) {
+		return err
+	}
+	if !fdo.DeviceLifecycle(current).CanTransition(state) {
+		return fmt.Errorf("%w: %s to %s", fdo.ErrInvalidStatusTransition, current, state)
+	}
+
+	kvs := map[string]any{
+		"guid":  guid[:],
+		"state": string(state),
+		"time":  at.Unix(),
+	}
+	if err := insert(ctx, tx, "device_statuses", kvs, map[string]any{"guid": guid[:]}); err != nil {
+		return err
+	}
+	if err := insert(ctx, tx, "device_status_times", kvs, map[string]any{
+		"guid":  guid[:],
+		"state": string(state),
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeviceStatus returns the lifecycle state of a device. If none has been
+// recorded, ErrNotFound is returned.
+func (db *DB) DeviceStatus(ctx context.Context, guid protocol.GUID) (*fdo.DeviceStatus, error) {
+	statuses, err := db.deviceStatuses(ctx, `SELECT s.guid, s.state, s.time, t.state, t.time
+		FROM device_statuses s JOIN device_status_times t ON t.guid = s.guid
+		WHERE s.guid = ?`, guid[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, fdo.ErrNotFound
+	}
+	return &statuses[0], nil
+}
+
+// DevicesByStatus returns the status of each device currently in the given
+// state, ordered by the time it entered the state.
+func (db *DB) DevicesByStatus(ctx context.Context, state fdo.DeviceLifecycle) ([]fdo.DeviceStatus, error) {
+	return db.deviceStatuses(ctx, `SELECT s.guid, s.state, s.time, t.state, t.time
+		FROM device_statuses s JOIN device_status_times t ON t.guid = s.guid
+		WHERE s.state = ?
+		ORDER BY s.time, s.guid`, string(state))
+}
+
+// deviceStatuses queries rows of a device status joined with the time of each
+// state, which must be grouped by device.
+func (db *DB) deviceStatuses(ctx context.Context, query string, args ...any) ([]fdo.DeviceStatus, error) {
+	ctx = db.debugCtx(ctx)
+
+	debug(ctx, "sqlite: %s\n%+v", query, args)
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var statuses []fdo.DeviceStatus
+	for rows.Next() {
+		var guid []byte
+		var state, timeState string
+		var unix, timeUnix int64
+		if err := rows.Scan(&guid, &state, &unix, &timeState, &timeUnix); err != nil {
+			return nil, fmt.Errorf("error scanning device status: %w", err)
+		}
+		if n := len(statuses); n == 0 || !bytes.Equal(statuses[n-1].GUID[:], guid) {
+			status := fdo.DeviceStatus{
+				State:   fdo.DeviceLifecycle(state),
+				Updated: time.Unix(unix, 0),
+				Times:   make(map[fdo.DeviceLifecycle]time.Time),
+			}
+			copy(status.GUID[:], guid)
+			statuses = append(statuses, status)
+		}
+		statuses[len(statuses)-1].Times[fdo.DeviceLifecycle(timeState)] = time.Unix(timeUnix, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return statuses, nil
+}
+
 // SetReplacementGUID stores the device GUID to persist at the end of TO2.
 func (db *DB) SetReplacementGUID(ctx context.Context, guid protocol.GUID) error {
 	sessID, ok := db.sessionID(ctx)
@@ -1015,6 +1814,11 @@ func (db *DB) SetXSession(ctx context.Context, suite kex.Suite, sess kex.Session
 	if err != nil {
 		return fmt.Errorf("error marshaling key exchange key exchange state: %w", err)
 	}
+	if db.SessionSealer != nil {
+		if state, err = db.SessionSealer.Seal(state, xSessionAAD(sessID, suite)); err != nil {
+			return err
+		}
+	}
 
 	return db.insert(ctx, "key_exchanges",
 		map[string]any{
@@ -1046,6 +1850,12 @@ func (db *DB) XSession(ctx context.Context) (kex.Suite, kex.Session, error) {
 	if suite == "" || sessData == nil {
 		return "", nil, fdo.ErrNotFound
 	}
+	if db.SessionSealer != nil {
+		var err error
+		if sessData, err = db.SessionSealer.Open(sessData, xSessionAAD(sessID, kex.Suite(suite))); err != nil {
+			return "", nil, err
+		}
+	}
 
 	sess := kex.Suite(suite).New(nil, 1)
 	stateUnmarshaler, ok := sess.(encoding.BinaryUnmarshaler)
@@ -1059,6 +1869,12 @@ func (db *DB) XSession(ctx context.Context) (kex.Suite, kex.Session, error) {
 	return kex.Suite(suite), sess, nil
 }
 
+// xSessionAAD binds sealed key exchange state to its session and suite, so
+// that it cannot be swapped between rows.
+func xSessionAAD(sessID []byte, suite kex.Suite) []byte {
+	return append(append(append([]byte{}, sessID...), 0x00), suite...)
+}
+
 // SetProveDeviceNonce stores the Nonce used in TO2.ProveDevice for use in
 // TO2.Done.
 func (db *DB) SetProveDeviceNonce(ctx context.Context, nonce protocol.Nonce) error {
@@ -1191,6 +2007,169 @@ func (db *DB) OwnerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certifi
 	return key.(crypto.Signer), chain, nil
 }
 
+// RotateOwnerKey makes a key the current key of its type, keeping any
+// previous current key as a previous key. Rotation times are stored with a
+// precision of seconds.
+func (db *DB) RotateOwnerKey(ctx context.Context, keyType protocol.KeyType, key crypto.Signer, chain []*x509.Certificate) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	ctx = db.debugCtx(ctx)
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	const query = `INSERT INTO previous_owner_keys (type, pkcs8, x509_chain, rotated)
+		SELECT type, pkcs8, x509_chain, ? FROM owner_keys WHERE type = ?`
+	debug(ctx, "sqlite: %s\n%d", query, keyType)
+	if _, err := tx.ExecContext(ctx, query, time.Now().Unix(), int(keyType)); err != nil {
+		return fmt.Errorf("error keeping previous owner key: %w", err)
+	}
+	if err := remove(ctx, tx, "owner_keys", map[string]any{"type": int(keyType)}); err != nil && !errors.Is(err, fdo.ErrNotFound) {
+		return fmt.Errorf("error removing previous owner key: %w", err)
+	}
+	kvs := map[string]any{
+		"type":  int(keyType),
+		"pkcs8": der,
+	}
+	if chain != nil {
+		kvs["x509_chain"] = derEncode(chain)
+	}
+	if err := insert(ctx, tx, "owner_keys", kvs, nil); err != nil {
+		return fmt.Errorf("error storing owner key: %w", err)
+	}
+	return tx.Commit()
+}
+
+// PreviousOwnerKeys returns the previous keys of a type, most recently
+// rotated first.
+func (db *DB) PreviousOwnerKeys(ctx context.Context, keyType protocol.KeyType) ([]fdo.PreviousOwnerKey, error) {
+	ctx = db.debugCtx(ctx)
+
+	const query = `SELECT pkcs8, x509_chain, rotated FROM previous_owner_keys
+		WHERE type = ? ORDER BY rotated DESC, rowid DESC`
+	debug(ctx, "sqlite: %s\n%d", query, keyType)
+	rows, err := db.db.QueryContext(ctx, query, int(keyType))
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var keys []fdo.PreviousOwnerKey
+	for rows.Next() {
+		var keyDer, certChainDer []byte
+		var rotated int64
+		if err := rows.Scan(&keyDer, &certChainDer, &rotated); err != nil {
+			return nil, fmt.Errorf("error scanning previous owner key: %w", err)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(keyDer)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing previous owner key: %w", err)
+		}
+		chain, err := x509.ParseCertificates(certChainDer)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing previous owner certificate chain: %w", err)
+		}
+		keys = append(keys, fdo.PreviousOwnerKey{
+			Key:     key.(crypto.Signer),
+			Chain:   chain,
+			Rotated: time.Unix(rotated, 0),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return keys, nil
+}
+
+// RemovePreviousOwnerKeys deletes the previous keys of a type which were
+// rotated before the given time.
+func (db *DB) RemovePreviousOwnerKeys(ctx context.Context, keyType protocol.KeyType, before time.Time) error {
+	const query = `DELETE FROM previous_owner_keys WHERE type = ? AND rotated < ?`
+	debug(db.debugCtx(ctx), "sqlite: %s\n%d %d", query, keyType, before.Unix())
+	_, err := db.db.ExecContext(ctx, query, int(keyType), before.Unix())
+	return err
+}
+
+// VoucherGUIDs returns the GUIDs of all owned vouchers.
+func (db *DB) VoucherGUIDs(ctx context.Context) ([]protocol.GUID, error) {
+	ctx = db.debugCtx(ctx)
+
+	const query = `SELECT guid FROM owner_vouchers`
+	debug(ctx, "sqlite: %s", query)
+	rows, err := db.ReadDB().QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var guids []protocol.GUID
+	for rows.Next() {
+		var guid []byte
+		if err := rows.Scan(&guid); err != nil {
+			return nil, fmt.Errorf("error scanning voucher GUID: %w", err)
+		}
+		guids = append(guids, protocol.GUID(guid))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return guids, nil
+}
+
+// LeaseVoucher marks the voucher of a device in use until the given time,
+// replacing any previous lease.
+func (db *DB) LeaseVoucher(ctx context.Context, guid protocol.GUID, until time.Time) error {
+	return db.insert(ctx, "voucher_leases",
+		map[string]any{
+			"guid":    guid[:],
+			"expires": until.UnixMilli(),
+		},
+		map[string]any{
+			"guid": guid[:],
+		})
+}
+
+// ReleaseVoucher ends the lease of the voucher of a device, if any.
+func (db *DB) ReleaseVoucher(ctx context.Context, guid protocol.GUID) error {
+	return remove(db.debugCtx(ctx), db.db, "voucher_leases", map[string]any{"guid": guid[:]})
+}
+
+// ReplaceUnleasedVoucher atomically replaces the voucher of a device with
+// next, only if it has no unexpired lease and is still equal to prev.
+func (db *DB) ReplaceUnleasedVoucher(ctx context.Context, guid protocol.GUID, prev, next *fdo.Voucher) error {
+	ctx = db.debugCtx(ctx)
+
+	prevData, err := cbor.Marshal(prev)
+	if err != nil {
+		return fmt.Errorf("error marshaling ownership voucher: %w", err)
+	}
+	nextData, err := cbor.Marshal(next)
+	if err != nil {
+		return fmt.Errorf("error marshaling ownership voucher: %w", err)
+	}
+
+	const query = `UPDATE owner_vouchers SET guid = ?, cbor = ?
+		WHERE guid = ? AND cbor = ? AND NOT EXISTS
+			(SELECT 1 FROM voucher_leases WHERE guid = ? AND expires > ?)`
+	debug(ctx, "sqlite: %s\n%x", query, guid[:])
+	result, err := db.db.ExecContext(ctx, query,
+		next.Header.Val.GUID[:], nextData, guid[:], prevData, guid[:], time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("error replacing ownership voucher: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error replacing ownership voucher: %w", err)
+	} else if n == 0 {
+		return fdo.ErrVoucherInUse
+	}
+	return nil
+}
+
 // SetMTU sets the max service info size the device may receive.
 func (db *DB) SetMTU(ctx context.Context, mtu uint16) error {
 	sessID, ok := db.sessionID(ctx)
@@ -1256,7 +2235,7 @@ func (db *DB) SetRVBlob(ctx context.Context, ov *fdo.Voucher, to1d *cose.Sign1[p
 func (db *DB) RVBlob(ctx context.Context, guid protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *fdo.Voucher, error) {
 	var blob, voucher []byte
 	var exp sql.NullInt64
-	if err := db.query(ctx, "rv_blobs", []string{"rv", "voucher", "exp"}, map[string]any{
+	if err := db.lookup(ctx, "rv_blobs", []string{"rv", "voucher", "exp"}, map[string]any{
 		"guid": guid[:],
 	}, &blob, &voucher, &exp); err != nil {
 		return nil, nil, err
@@ -1279,3 +2258,231 @@ func (db *DB) RVBlob(ctx context.Context, guid protocol.GUID) (*cose.Sign1[proto
 
 	return &to1d, &ov, nil
 }
+
+// ListRVBlobs returns all unexpired rendezvous blob registrations, ordered by
+// GUID.
+func (db *DB) ListRVBlobs(ctx context.Context) ([]fdo.RVBlobInfo, error) {
+	ctx = db.debugCtx(ctx)
+
+	const query = `SELECT guid, voucher, exp FROM rv_blobs WHERE exp >= ? ORDER BY guid`
+	now := time.Now().Unix()
+	debug(ctx, "sqlite: %s\n%+v", query, now)
+	rows, err := db.ReadDB().QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var infos []fdo.RVBlobInfo
+	for rows.Next() {
+		var guid, voucher []byte
+		var exp int64
+		if err := rows.Scan(&guid, &voucher, &exp); err != nil {
+			return nil, fmt.Errorf("error scanning rendezvous blob: %w", err)
+		}
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(voucher, &ov); err != nil {
+			return nil, fmt.Errorf("error unmarshaling ownership voucher: %w", err)
+		}
+		info := fdo.RVBlobInfo{Voucher: &ov, Expires: time.Unix(exp, 0)}
+		copy(info.GUID[:], guid)
+		infos = append(infos, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return infos, nil
+}
+
+// RVBlobRegistration returns the unexpired rendezvous blob registration of a
+// device.
+func (db *DB) RVBlobRegistration(ctx context.Context, guid protocol.GUID) (*fdo.RVBlobInfo, error) {
+	var voucher []byte
+	var exp sql.NullInt64
+	if err := db.lookup(ctx, "rv_blobs", []string{"voucher", "exp"}, map[string]any{
+		"guid": guid[:],
+	}, &voucher, &exp); err != nil {
+		return nil, err
+	}
+	if voucher == nil || !exp.Valid || time.Now().After(time.Unix(exp.Int64, 0)) {
+		return nil, fdo.ErrNotFound
+	}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(voucher, &ov); err != nil {
+		return nil, fmt.Errorf("error unmarshaling ownership voucher: %w", err)
+	}
+	return &fdo.RVBlobInfo{GUID: guid, Voucher: &ov, Expires: time.Unix(exp.Int64, 0)}, nil
+}
+
+// RemoveRVBlob deletes the rendezvous blob registration of a device. If none
+// exists, ErrNotFound is returned.
+func (db *DB) RemoveRVBlob(ctx context.Context, guid protocol.GUID) error {
+	ctx = db.debugCtx(ctx)
+
+	const query = `DELETE FROM rv_blobs WHERE guid = ?`
+	debug(ctx, "sqlite: %s\n%x", query, guid[:])
+	result, err := db.db.ExecContext(ctx, query, guid[:])
+	if err != nil {
+		return fmt.Errorf("error deleting rendezvous blob: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error deleting rendezvous blob: %w", err)
+	} else if n == 0 {
+		return fdo.ErrNotFound
+	}
+	return nil
+}
+
+// Trace records a traced message. Times are stored with a precision of
+// microseconds.
+func (db *DB) Trace(ctx context.Context, event protocol.TraceEvent) error {
+	kvs := map[string]any{
+		"correlation_id": int64(event.CorrelationID),
+		"time":           event.Time.UnixMicro(),
+		"duration":       event.Duration.Microseconds(),
+		"msg_type":       event.MsgType,
+		"request_size":   event.RequestSize,
+		"resp_type":      event.RespType,
+		"response_size":  event.ResponseSize,
+	}
+	if event.GUID != nil {
+		kvs["guid"] = event.GUID[:]
+	}
+	if event.Err != "" {
+		kvs["error"] = event.Err
+	}
+	if event.Summary != "" {
+		kvs["summary"] = event.Summary
+	}
+	return db.insert(ctx, "protocol_traces", kvs, nil)
+}
+
+// SessionTrace returns the traced messages with a correlation ID, oldest
+// first.
+func (db *DB) SessionTrace(ctx context.Context, correlationID uint) ([]protocol.TraceEvent, error) {
+	return db.traceEvents(ctx, `SELECT correlation_id, guid, time, duration, msg_type, request_size, resp_type, response_size, error, summary
+		FROM protocol_traces WHERE correlation_id = ? ORDER BY rowid`, int64(correlationID))
+}
+
+// DeviceTrace returns the traced messages of every session in which a
+// message was associated with the device GUID, oldest first.
+func (db *DB) DeviceTrace(ctx context.Context, guid protocol.GUID) ([]protocol.TraceEvent, error) {
+	return db.traceEvents(ctx, `SELECT correlation_id, guid, time, duration, msg_type, request_size, resp_type, response_size, error, summary
+		FROM protocol_traces
+		WHERE correlation_id IN (SELECT correlation_id FROM protocol_traces WHERE guid = ?)
+		ORDER BY rowid`, guid[:])
+}
+
+func (db *DB) traceEvents(ctx context.Context, query string, args ...any) ([]protocol.TraceEvent, error) {
+	ctx = db.debugCtx(ctx)
+
+	debug(ctx, "sqlite: %s\n%+v", query, args)
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var events []protocol.TraceEvent
+	for rows.Next() {
+		var correlationID, unixMicro, durationMicro, requestSize int64
+		var msgType, respType uint8
+		var responseSize int
+		var guid []byte
+		var errString, summary sql.NullString
+		if err := rows.Scan(&correlationID, &guid, &unixMicro, &durationMicro, &msgType,
+			&requestSize, &respType, &responseSize, &errString, &summary); err != nil {
+			return nil, fmt.Errorf("error scanning protocol trace: %w", err)
+		}
+		event := protocol.TraceEvent{
+			CorrelationID: uint(correlationID),
+			Time:          time.UnixMicro(unixMicro),
+			Duration:      time.Duration(durationMicro) * time.Microsecond,
+			MsgType:       msgType,
+			RequestSize:   requestSize,
+			RespType:      respType,
+			ResponseSize:  responseSize,
+			Err:           errString.String,
+			Summary:       summary.String,
+		}
+		if guid != nil {
+			event.GUID = new(protocol.GUID)
+			copy(event.GUID[:], guid)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return events, nil
+}
+
+// AddHelloDevice records the HelloDevice message of a session.
+func (db *DB) AddHelloDevice(ctx context.Context, record fdo.HelloDeviceRecord) error {
+	kvs := map[string]any{
+		"guid":           record.GUID[:],
+		"correlation_id": int64(record.CorrelationID),
+		"time":           record.Time.UnixMicro(),
+		"raw":            record.Raw,
+		"hash_alg":       int64(record.Hash.Algorithm),
+		"hash":           record.Hash.Value,
+	}
+	if record.Err != "" {
+		kvs["error"] = record.Err
+	}
+	return db.insert(ctx, "hello_devices", kvs, nil)
+}
+
+// HelloDevices returns the recorded messages of a device, oldest first.
+func (db *DB) HelloDevices(ctx context.Context, guid protocol.GUID) ([]fdo.HelloDeviceRecord, error) {
+	return db.helloDevices(ctx, `SELECT guid, correlation_id, time, raw, hash_alg, hash, error
+		FROM hello_devices WHERE guid = ? ORDER BY rowid`, guid[:])
+}
+
+// SessionHelloDevice returns the recorded message of the session with a
+// correlation ID.
+func (db *DB) SessionHelloDevice(ctx context.Context, correlationID uint) (*fdo.HelloDeviceRecord, error) {
+	records, err := db.helloDevices(ctx, `SELECT guid, correlation_id, time, raw, hash_alg, hash, error
+		FROM hello_devices WHERE correlation_id = ? ORDER BY rowid DESC LIMIT 1`, int64(correlationID))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fdo.ErrNotFound
+	}
+	return &records[0], nil
+}
+
+func (db *DB) helloDevices(ctx context.Context, query string, args ...any) ([]fdo.HelloDeviceRecord, error) {
+	ctx = db.debugCtx(ctx)
+
+	debug(ctx, "sqlite: %s\n%+v", query, args)
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []fdo.HelloDeviceRecord
+	for rows.Next() {
+		var guid, raw, hash []byte
+		var correlationID, unixMicro, hashAlg int64
+		var errString sql.NullString
+		if err := rows.Scan(&guid, &correlationID, &unixMicro, &raw, &hashAlg, &hash, &errString); err != nil {
+			return nil, fmt.Errorf("error scanning HelloDevice record: %w", err)
+		}
+		record := fdo.HelloDeviceRecord{
+			CorrelationID: uint(correlationID),
+			Time:          time.UnixMicro(unixMicro),
+			Raw:           raw,
+			Hash:          protocol.Hash{Algorithm: protocol.HashAlg(hashAlg), Value: hash},
+			Err:           errString.String,
+		}
+		copy(record.GUID[:], guid)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying DB: %w", err)
+	}
+	return records, nil
+}
@@ -4,6 +4,7 @@
 package sqlite_test
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -11,12 +12,22 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"database/sql"
+	"errors"
 	"math/big"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ncruces/go-sqlite3/driver"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
 )
@@ -37,6 +48,182 @@ func TestServerState(t *testing.T) {
 	fdotest.RunServerStateSuite(t, state)
 }
 
+func TestServerStateSealedSessions(t *testing.T) {
+	state, cleanup := newDB(t)
+	defer func() { _ = cleanup() }()
+
+	state.SessionSealer = kex.AEADSealer{Alg: cose.A256GCM, Key: make([]byte, 32)}
+	fdotest.RunServerStateSuite(t, state)
+}
+
+func TestSessionLock(t *testing.T) {
+	state, cleanup := newDB(t)
+	defer func() { _ = cleanup() }()
+	state.SessionLockTTL = time.Second
+
+	token, err := state.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := state.TokenContext(context.Background(), token)
+
+	unlock, err := state.LockSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A locked session cannot be locked again until released
+	timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := state.LockSession(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded locking a locked session, got %v", err)
+	}
+	unlock()
+	unlock, err = state.LockSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An expired lease may be taken without being released and releasing it
+	// afterward does not release the new lease
+	time.Sleep(state.SessionLockTTL)
+	unlock2, err := state.LockSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	timeoutCtx, cancel = context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := state.LockSession(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected stale unlock not to release the lease, got %v", err)
+	}
+	unlock2()
+
+	// Invalid tokens cannot be locked
+	if _, err := state.LockSession(state.TokenContext(context.Background(), "invalid")); err == nil {
+		t.Fatal("expected error locking session of invalid token")
+	}
+}
+
+func TestStats(t *testing.T) {
+	state, err := sqlite.Open(filepath.Join(t.TempDir(), "db.test"), "test_password",
+		sqlite.WithWAL(),
+		sqlite.WithBusyTimeout(5*time.Second),
+		sqlite.WithSynchronous(sqlite.SynchronousNormal),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = state.Close() }()
+
+	var slow atomic.Int64
+	state.SlowQueryThreshold = time.Nanosecond
+	state.SlowQueryLog = func(_ context.Context, q sqlite.SlowQuery) {
+		if q.Operation == "" || q.Query == "" {
+			t.Errorf("expected slow query to have operation and query, got %+v", q)
+		}
+		slow.Add(1)
+	}
+
+	// Concurrent sessions use pooled connections without failing as busy
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := state.NewToken(context.Background(), protocol.TO2Protocol)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- state.InvalidateToken(state.TokenContext(context.Background(), token))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := state.Stats()
+	if got := stats["INSERT sessions"]; got.Count < 10 || got.Rows < 10 || got.Errors != 0 {
+		t.Errorf("expected at least 10 session inserts, got %+v", got)
+	}
+	if got := stats["DELETE sessions"]; got.Count != 10 || got.Rows != 10 || got.Duration <= 0 || got.MaxDuration > got.Duration {
+		t.Errorf("expected 10 session deletes, got %+v", got)
+	}
+	if got := stats["SELECT secrets"]; got.Count < 10 {
+		t.Errorf("expected secret queries to be counted, got %+v", got)
+	}
+	if slow.Load() == 0 {
+		t.Error("expected slow query log to be called")
+	}
+
+	if _, err := sqlite.Open(filepath.Join(t.TempDir(), "db.test"), "", sqlite.WithSynchronous("sometimes")); err == nil {
+		t.Error("expected invalid synchronous mode to fail")
+	}
+}
+
+func TestReadPool(t *testing.T) {
+	fixture, err := fdotest.NewFixture(fdotest.FixtureOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guid := fixture.Voucher.Header.Val.GUID
+
+	// Lookups see committed writes
+	state, err := sqlite.Open(filepath.Join(t.TempDir(), "db.test"), "test_password", sqlite.WithWAL(), sqlite.WithReadPool(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = state.Close() }()
+	if state.ReadDB() == state.DB() {
+		t.Fatal("expected a separate read pool")
+	}
+	if err := state.AddVoucher(context.Background(), fixture.Voucher); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.Voucher(context.Background(), guid); err != nil {
+		t.Fatal(err)
+	}
+	if guids, err := state.VoucherGUIDs(context.Background()); err != nil || len(guids) != 1 {
+		t.Fatalf("expected one voucher GUID, got %v, %v", guids, err)
+	}
+	if _, err := state.ReadDB().ExecContext(context.Background(), `DELETE FROM owner_vouchers`); err == nil {
+		t.Fatal("expected read pool to reject writes")
+	}
+
+	// Lookups use the read pool, which may be a separate replica
+	dir := t.TempDir()
+	write, err := driver.Open("file:" + filepath.Join(dir, "write.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := driver.Open("file:" + filepath.Join(dir, "read.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*sql.DB{write, read} {
+		if err := sqlite.Init(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	split := sqlite.NewReadWrite(write, read)
+	defer func() { _ = split.Close() }()
+	if err := split.AddVoucher(context.Background(), fixture.Voucher); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := split.Voucher(context.Background(), guid); !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("expected lookup from empty replica to fail with not found, got %v", err)
+	}
+	if _, err := split.RemoveVoucher(context.Background(), guid); err != nil {
+		t.Fatalf("expected voucher to be removed from write pool: %v", err)
+	}
+}
+
 func newDB(t *testing.T) (_ *sqlite.DB, cleanup func() error) {
 	cleanup = func() error { return os.Remove("db.test") }
 	_ = cleanup()
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tee
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Commands of the FDO trusted application invoked by [OPTEE]. A TA
+// implementing this protocol must accept the following parameters, where
+// unlisted parameters have type TEEC_NONE:
+//
+//	CmdHmac:             [0] value input  a = 256 or 384 (SHA-2 size)
+//	                     [1] memref input data
+//	                     [2] memref output HMAC
+//	CmdPublicKey:        [0] memref output PKIX, ASN.1 DER public key
+//	CmdSign:             [0] value input  a = 256 or 384 (SHA-2 size of digest)
+//	                                      b = 1 for RSA-PSS, otherwise 0
+//	                     [1] memref input digest
+//	                     [2] memref output signature (ECDSA signatures ASN.1 DER)
+//	CmdReadCredential:   [0] memref output encoded credential
+//	CmdStageCredential:  [0] memref input encoded credential
+//	CmdCommitCredential: none
+//
+// When an output buffer is too small, the TA must return
+// TEE_ERROR_SHORT_BUFFER and set the memref size to the required size.
+const (
+	CmdHmac             uint32 = 0
+	CmdPublicKey        uint32 = 1
+	CmdSign             uint32 = 2
+	CmdReadCredential   uint32 = 3
+	CmdStageCredential  uint32 = 4
+	CmdCommitCredential uint32 = 5
+)
+
+// Parameter types, matching TEEC_* of the GlobalPlatform TEE Client API.
+const (
+	paramNone         = 0
+	paramValueInput   = 1
+	paramMemrefInput  = 5
+	paramMemrefOutput = 6
+)
+
+// Return codes of the GlobalPlatform TEE Client API.
+const (
+	teeSuccess          uint32 = 0x00000000
+	teeErrorShortBuffer uint32 = 0xFFFF0010
+)
+
+// defaultOutputSize is the initial size of output buffers, which is
+// sufficient for HMACs, public keys, and signatures of supported key types.
+const defaultOutputSize = 1024
+
+// Error is returned when a TA command fails.
+type Error struct {
+	Code   uint32
+	Origin uint32
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("TEE error %#08x (origin %d)", e.Code, e.Origin)
+}
+
+// param is a command parameter. For memrefs, buf is the input data or output
+// buffer and size is updated with the size of the output.
+type param struct {
+	typ  uint64
+	a, b uint64
+	buf  []byte
+	size int
+}
+
+var _ TrustedApp = (*OPTEE)(nil)
+
+// HMAC implements TrustedApp.
+func (t *OPTEE) HMAC(h crypto.Hash, data []byte) ([]byte, error) {
+	size, err := shaSize(h)
+	if err != nil {
+		return nil, err
+	}
+	return t.invokeOutput(CmdHmac, []param{
+		{typ: paramValueInput, a: size},
+		{typ: paramMemrefInput, buf: data},
+	})
+}
+
+// PublicKey implements TrustedApp.
+func (t *OPTEE) PublicKey() (crypto.PublicKey, error) {
+	der, err := t.invokeOutput(CmdPublicKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// Sign implements TrustedApp.
+func (t *OPTEE) Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	size, err := shaSize(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+	var pss uint64
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		pss = 1
+	}
+	return t.invokeOutput(CmdSign, []param{
+		{typ: paramValueInput, a: size, b: pss},
+		{typ: paramMemrefInput, buf: digest},
+	})
+}
+
+// ReadCredential implements TrustedApp.
+func (t *OPTEE) ReadCredential() ([]byte, error) {
+	return t.invokeOutput(CmdReadCredential, nil)
+}
+
+// StageCredential implements TrustedApp.
+func (t *OPTEE) StageCredential(data []byte) error {
+	return t.invoke(CmdStageCredential, []param{{typ: paramMemrefInput, buf: data}})
+}
+
+// CommitCredential implements TrustedApp.
+func (t *OPTEE) CommitCredential() error {
+	return t.invoke(CmdCommitCredential, nil)
+}
+
+// invokeOutput invokes a command with an output memref appended to the given
+// parameters, retrying once with a larger buffer if the TA requires it.
+func (t *OPTEE) invokeOutput(cmd uint32, params []param) ([]byte, error) {
+	out := param{typ: paramMemrefOutput, buf: make([]byte, defaultOutputSize)}
+	for range 2 {
+		params := append(params, out)
+		err := t.invoke(cmd, params)
+		out = params[len(params)-1]
+		var teeErr Error
+		if errors.As(err, &teeErr) && teeErr.Code == teeErrorShortBuffer && out.size > len(out.buf) {
+			out.buf = make([]byte, out.size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if out.size > len(out.buf) {
+			return nil, fmt.Errorf("TA output size %d exceeds buffer", out.size)
+		}
+		return out.buf[:out.size], nil
+	}
+	return nil, fmt.Errorf("TA output buffer too small")
+}
+
+func shaSize(h crypto.Hash) (uint64, error) {
+	switch h {
+	case crypto.SHA256:
+		return 256, nil
+	case crypto.SHA384:
+		return 384, nil
+	default:
+		return 0, fmt.Errorf("unsupported hash: %s", h)
+	}
+}
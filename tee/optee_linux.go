@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tee
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Linux TEE subsystem ioctls, see include/uapi/linux/tee.h.
+const (
+	teeIocMagic = 0xa4
+
+	teeImplIDOPTEE = 1
+	teeGenCapGP    = 1 << 0
+
+	teeLoginPublic = 0
+
+	// All OP-TEE invocations pass exactly four parameters, as with libteec
+	teeNumParams = 4
+)
+
+func teeIOR(nr, size uintptr) uintptr  { return 2<<30 | size<<16 | teeIocMagic<<8 | nr }
+func teeIOWR(nr, size uintptr) uintptr { return 3<<30 | size<<16 | teeIocMagic<<8 | nr }
+
+var (
+	teeIocVersion      = teeIOR(0, unsafe.Sizeof(teeVersionData{}))
+	teeIocShmAlloc     = teeIOWR(1, unsafe.Sizeof(teeShmAllocData{}))
+	teeIocOpenSession  = teeIOR(2, unsafe.Sizeof(teeBufData{}))
+	teeIocInvoke       = teeIOR(3, unsafe.Sizeof(teeBufData{}))
+	teeIocCloseSession = teeIOR(5, unsafe.Sizeof(teeCloseSessionArg{}))
+)
+
+type teeVersionData struct {
+	ImplID   uint32
+	ImplCaps uint32
+	GenCaps  uint32
+}
+
+type teeShmAllocData struct {
+	Size  uint64
+	Flags uint32
+	ID    int32
+}
+
+type teeBufData struct {
+	BufPtr uint64
+	BufLen uint64
+}
+
+type teeParam struct {
+	Attr, A, B, C uint64
+}
+
+type teeOpenSessionArg struct {
+	UUID      [16]byte
+	ClntUUID  [16]byte
+	ClntLogin uint32
+	CancelID  uint32
+	Session   uint32
+	Ret       uint32
+	RetOrigin uint32
+	NumParams uint32
+	Params    [teeNumParams]teeParam
+}
+
+type teeInvokeArg struct {
+	Func      uint32
+	Session   uint32
+	CancelID  uint32
+	Ret       uint32
+	RetOrigin uint32
+	NumParams uint32
+	Params    [teeNumParams]teeParam
+}
+
+type teeCloseSessionArg struct {
+	Session uint32
+}
+
+// OPTEE implements [TrustedApp] by invoking an FDO TA through the Linux TEE
+// subsystem, i.e. /dev/tee0 with the OP-TEE driver. See [CmdHmac] for the
+// command protocol the TA must implement.
+type OPTEE struct {
+	dev     *os.File
+	session uint32
+}
+
+// OpenOPTEE opens a session with the TA identified by uuid using the TEE
+// device at the given path, usually /dev/tee0.
+func OpenOPTEE(path string, uuid [16]byte) (*OPTEE, error) {
+	dev, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var version teeVersionData
+	if err := ioctl(dev, teeIocVersion, unsafe.Pointer(&version)); err != nil {
+		_ = dev.Close()
+		return nil, fmt.Errorf("error reading TEE version: %w", err)
+	}
+	if version.ImplID != teeImplIDOPTEE || version.GenCaps&teeGenCapGP == 0 {
+		_ = dev.Close()
+		return nil, fmt.Errorf("TEE at %s is not OP-TEE with GlobalPlatform support", path)
+	}
+
+	arg := teeOpenSessionArg{
+		UUID:      uuid,
+		ClntLogin: teeLoginPublic,
+		NumParams: teeNumParams,
+	}
+	err = ioctlBuf(dev, teeIocOpenSession, unsafe.Pointer(&arg), unsafe.Sizeof(arg))
+	if err != nil {
+		_ = dev.Close()
+		return nil, fmt.Errorf("error opening TA session: %w", err)
+	}
+	if arg.Ret != teeSuccess {
+		_ = dev.Close()
+		return nil, fmt.Errorf("error opening TA session: %w", Error{Code: arg.Ret, Origin: arg.RetOrigin})
+	}
+
+	return &OPTEE{dev: dev, session: arg.Session}, nil
+}
+
+// Close closes the TA session and TEE device.
+func (t *OPTEE) Close() error {
+	arg := teeCloseSessionArg{Session: t.session}
+	if err := ioctl(t.dev, teeIocCloseSession, unsafe.Pointer(&arg)); err != nil {
+		_ = t.dev.Close()
+		return fmt.Errorf("error closing TA session: %w", err)
+	}
+	return t.dev.Close()
+}
+
+// invoke calls a TA command, copying memref parameters through shared memory.
+func (t *OPTEE) invoke(cmd uint32, params []param) error {
+	if len(params) > teeNumParams {
+		return fmt.Errorf("too many TA command parameters: %d", len(params))
+	}
+
+	arg := teeInvokeArg{
+		Func:      cmd,
+		Session:   t.session,
+		NumParams: teeNumParams,
+	}
+	var shms []*teeShm
+	defer func() {
+		for _, shm := range shms {
+			shm.free()
+		}
+	}()
+	for i, p := range params {
+		arg.Params[i] = teeParam{Attr: p.typ, A: p.a, B: p.b}
+		if p.typ != paramMemrefInput && p.typ != paramMemrefOutput {
+			continue
+		}
+		shm, err := t.allocShm(len(p.buf))
+		if err != nil {
+			return err
+		}
+		shms = append(shms, shm)
+		if p.typ == paramMemrefInput {
+			copy(shm.mem, p.buf)
+		}
+		arg.Params[i] = teeParam{Attr: p.typ, A: 0, B: uint64(len(p.buf)), C: uint64(shm.id)}
+	}
+
+	if err := ioctlBuf(t.dev, teeIocInvoke, unsafe.Pointer(&arg), unsafe.Sizeof(arg)); err != nil {
+		return fmt.Errorf("error invoking TA command %d: %w", cmd, err)
+	}
+
+	// Copy outputs, including required sizes for short buffers
+	var shmIdx int
+	for i := range params {
+		if params[i].typ != paramMemrefInput && params[i].typ != paramMemrefOutput {
+			continue
+		}
+		shm := shms[shmIdx]
+		shmIdx++
+		if params[i].typ != paramMemrefOutput {
+			continue
+		}
+		params[i].size = int(arg.Params[i].B)
+		copy(params[i].buf, shm.mem[:min(params[i].size, len(shm.mem))])
+	}
+
+	if arg.Ret != teeSuccess {
+		return fmt.Errorf("TA command %d failed: %w", cmd, Error{Code: arg.Ret, Origin: arg.RetOrigin})
+	}
+	return nil
+}
+
+// teeShm is driver-allocated memory shared with the TEE.
+type teeShm struct {
+	id  int32
+	fd  int
+	mem []byte
+}
+
+func (t *OPTEE) allocShm(size int) (*teeShm, error) {
+	// Zero-sized shared memory is not supported by all drivers
+	size = max(size, 1)
+	data := teeShmAllocData{Size: uint64(size)}
+	fd, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.dev.Fd(), teeIocShmAlloc, uintptr(unsafe.Pointer(&data)))
+	if errno != 0 {
+		return nil, fmt.Errorf("error allocating TEE shared memory: %w", errno)
+	}
+	mem, err := syscall.Mmap(int(fd), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		_ = syscall.Close(int(fd))
+		return nil, fmt.Errorf("error mapping TEE shared memory: %w", err)
+	}
+	return &teeShm{id: data.ID, fd: int(fd), mem: mem}, nil
+}
+
+func (shm *teeShm) free() {
+	clear(shm.mem)
+	_ = syscall.Munmap(shm.mem)
+	_ = syscall.Close(shm.fd)
+}
+
+// ioctlBuf calls an ioctl taking a struct tee_ioctl_buf_data pointing to arg.
+// The argument is pinned, because its address is passed as an integer.
+func ioctlBuf(f *os.File, req uintptr, arg unsafe.Pointer, size uintptr) error {
+	var pinner runtime.Pinner
+	pinner.Pin(arg)
+	defer pinner.Unpin()
+	buf := teeBufData{BufPtr: uint64(uintptr(arg)), BufLen: uint64(size)}
+	return ioctl(f, req, unsafe.Pointer(&buf))
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
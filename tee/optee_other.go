@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build !linux
+
+package tee
+
+import "errors"
+
+// OPTEE implements [TrustedApp] by invoking an FDO TA through the Linux TEE
+// subsystem. It is only supported on Linux.
+type OPTEE struct{}
+
+// OpenOPTEE is only supported on Linux.
+func OpenOPTEE(path string, uuid [16]byte) (*OPTEE, error) {
+	return nil, errors.New("OP-TEE is only supported on Linux")
+}
+
+// Close is a no-op.
+func (t *OPTEE) Close() error { return nil }
+
+func (t *OPTEE) invoke(cmd uint32, params []param) error {
+	return errors.New("OP-TEE is only supported on Linux")
+}
@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package tee implements device credentials whose HMAC secret and device key
+// are held by a trusted application (TA) in a Trusted Execution Environment,
+// such as an OP-TEE TA in Arm TrustZone.
+package tee
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256" // Register SHA256 for HMAC block size
+	_ "crypto/sha512" // Register SHA384 for HMAC block size
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// TrustedApp is the integration point for a trusted application that holds
+// the FDO device secret and device key. Secrets never leave the TA.
+type TrustedApp interface {
+	// HMAC computes an HMAC of data using the device secret and the given
+	// hash, which is either SHA256 or SHA384.
+	HMAC(h crypto.Hash, data []byte) ([]byte, error)
+
+	// PublicKey returns the public part of the device key.
+	PublicKey() (crypto.PublicKey, error)
+
+	// Sign signs a digest with the device key. For RSA keys, opts may be
+	// *rsa.PSSOptions to use RSA-PSS. For ECDSA keys, the signature must be
+	// ASN.1 DER encoded, as with crypto.Signer.
+	Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error)
+
+	// ReadCredential returns the active encoded device credential.
+	ReadCredential() ([]byte, error)
+
+	// StageCredential persists an encoded replacement device credential
+	// without making it active.
+	StageCredential([]byte) error
+
+	// CommitCredential makes the staged device credential active.
+	CommitCredential() error
+}
+
+// NewHmac returns an HMAC for either SHA256 or SHA384 computed by the TA.
+//
+// Data is buffered until Sum is called, because the TA computes the HMAC in a
+// single invocation. Errors are returned from Err, as documented on
+// [fdo.TO2Config].
+func NewHmac(ta TrustedApp, h crypto.Hash) hash.Hash {
+	return &hmac{ta: ta, alg: h}
+}
+
+type hmac struct {
+	ta  TrustedApp
+	alg crypto.Hash
+	buf bytes.Buffer
+	err error
+}
+
+func (h *hmac) Write(p []byte) (int, error) { return h.buf.Write(p) }
+
+func (h *hmac) Sum(b []byte) []byte {
+	mac, err := h.ta.HMAC(h.alg, h.buf.Bytes())
+	if err != nil {
+		h.err = fmt.Errorf("tee: hmac: %w", err)
+		return append(b, make([]byte, h.Size())...)
+	}
+	return append(b, mac...)
+}
+
+func (h *hmac) Reset() {
+	h.buf.Reset()
+	h.err = nil
+}
+
+func (h *hmac) Size() int      { return h.alg.Size() }
+func (h *hmac) BlockSize() int { return h.alg.New().BlockSize() }
+
+// Err returns the error from the last failed Sum, if any.
+func (h *hmac) Err() error { return h.err }
+
+// NewKey returns a signer using the device key of the TA.
+func NewKey(ta TrustedApp) (crypto.Signer, error) {
+	pub, err := ta.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("tee: error reading device public key: %w", err)
+	}
+	return &key{ta: ta, pub: pub}, nil
+}
+
+type key struct {
+	ta  TrustedApp
+	pub crypto.PublicKey
+}
+
+func (k *key) Public() crypto.PublicKey { return k.pub }
+
+func (k *key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	sig, err := k.ta.Sign(digest, opts)
+	if err != nil {
+		return nil, fmt.Errorf("tee: sign: %w", err)
+	}
+	return sig, nil
+}
+
+// Store implements [fdo.CredentialStore] with the device credential,
+// secret, and key all held by a TA.
+type Store struct {
+	TA TrustedApp
+}
+
+var _ fdo.CredentialStore = (*Store)(nil)
+
+// Save persists the device credential as the active credential, such as after
+// DI.
+func (s *Store) Save(cred fdo.DeviceCredential) error {
+	if err := s.Stage(cred); err != nil {
+		return err
+	}
+	return s.Commit()
+}
+
+// Read returns the active device credential.
+func (s *Store) Read() (*fdo.DeviceCredential, error) {
+	data, err := s.TA.ReadCredential()
+	if err != nil {
+		return nil, fmt.Errorf("tee: error reading device credential: %w", err)
+	}
+	var cred fdo.DeviceCredential
+	if err := cbor.Unmarshal(data, &cred); err != nil {
+		return nil, fmt.Errorf("tee: error decoding device credential: %w", err)
+	}
+	return &cred, nil
+}
+
+// HMACs returns HMAC-SHA256 and HMAC-SHA384 hashes computed by the TA.
+func (s *Store) HMACs() (hmacSha256, hmacSha384 hash.Hash, _ error) {
+	return NewHmac(s.TA, crypto.SHA256), NewHmac(s.TA, crypto.SHA384), nil
+}
+
+// Signer returns the device key.
+func (s *Store) Signer() (crypto.Signer, error) { return NewKey(s.TA) }
+
+// Stage persists a replacement device credential in the TA.
+func (s *Store) Stage(cred fdo.DeviceCredential) error {
+	data, err := cbor.Marshal(cred)
+	if err != nil {
+		return fmt.Errorf("tee: error encoding device credential: %w", err)
+	}
+	if err := s.TA.StageCredential(data); err != nil {
+		return fmt.Errorf("tee: error staging device credential: %w", err)
+	}
+	return nil
+}
+
+// Commit makes the staged credential active.
+func (s *Store) Commit() error {
+	if err := s.TA.CommitCredential(); err != nil {
+		return fmt.Errorf("tee: error committing device credential: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tee_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/tee"
+)
+
+// softwareTA simulates a trusted application in process memory.
+type softwareTA struct {
+	secret         []byte
+	key            *ecdsa.PrivateKey
+	active, staged []byte
+}
+
+func (ta *softwareTA) HMAC(h crypto.Hash, data []byte) ([]byte, error) {
+	mac := hmac.New(h.New, ta.secret)
+	_, _ = mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (ta *softwareTA) PublicKey() (crypto.PublicKey, error) { return ta.key.Public(), nil }
+
+func (ta *softwareTA) Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return ta.key.Sign(rand.Reader, digest, opts)
+}
+
+func (ta *softwareTA) ReadCredential() ([]byte, error) {
+	if ta.active == nil {
+		return nil, errors.New("no credential")
+	}
+	return ta.active, nil
+}
+
+func (ta *softwareTA) StageCredential(data []byte) error {
+	ta.staged = bytes.Clone(data)
+	return nil
+}
+
+func (ta *softwareTA) CommitCredential() error {
+	if ta.staged == nil {
+		return errors.New("no staged credential")
+	}
+	ta.active, ta.staged = ta.staged, nil
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := &softwareTA{secret: []byte("secret"), key: key}
+	store := &tee.Store{TA: ta}
+
+	if err := store.Save(fdo.DeviceCredential{Version: 101, GUID: protocol.GUID{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Stage(fdo.DeviceCredential{Version: 101, GUID: protocol.GUID{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if cred, err := store.Read(); err != nil {
+		t.Fatal(err)
+	} else if cred.GUID != (protocol.GUID{1}) {
+		t.Fatalf("expected initial credential before commit, got GUID %x", cred.GUID)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if cred, err := store.Read(); err != nil {
+		t.Fatal(err)
+	} else if cred.GUID != (protocol.GUID{2}) {
+		t.Fatalf("expected replacement credential after commit, got GUID %x", cred.GUID)
+	}
+
+	// HMAC is computed by the TA
+	h256, _, err := store.HMACs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = h256.Write([]byte("hello "))
+	_, _ = h256.Write([]byte("world"))
+	expected := hmac.New(sha256.New, ta.secret)
+	_, _ = expected.Write([]byte("hello world"))
+	if got := h256.Sum(nil); !hmac.Equal(got, expected.Sum(nil)) {
+		t.Fatalf("HMAC mismatch: %x", got)
+	}
+	if h256.Size() != sha256.Size || h256.BlockSize() != sha256.BlockSize {
+		t.Fatal("unexpected HMAC size or block size")
+	}
+
+	// Device key signs in the TA
+	signer, err := store.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("hello world"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig) {
+		t.Fatal("signature verification failed")
+	}
+}
@@ -0,0 +1,336 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package testvectors
+
+import (
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// Unprotected header labels of TO2 messages
+var (
+	cuphNonce       = cose.Label{Int64: 256}
+	cuphOwnerPubKey = cose.Label{Int64: 257}
+	euphNonce       = cose.Label{Int64: -259}
+)
+
+type sigInfo struct {
+	Type cose.SignatureAlgorithm
+	Info []byte
+}
+
+// DI messages
+
+type appStart struct {
+	DeviceMfgInfo *cbor.Bstr[custom.DeviceMfgInfo]
+}
+
+type setCredentials struct {
+	OVHeader cbor.Bstr[fdo.VoucherHeader]
+}
+
+type setHmac struct {
+	Hmac protocol.Hmac
+}
+
+// TO0 messages
+
+type to0Hello struct{}
+
+type to0HelloAck struct {
+	NonceTO0Sign protocol.Nonce
+}
+
+type to0d struct {
+	Voucher      fdo.Voucher
+	WaitSeconds  uint32
+	NonceTO0Sign protocol.Nonce
+}
+
+type ownerSign struct {
+	To0d cbor.Bstr[to0d]
+	To1d cose.Sign1Tag[protocol.To1d, []byte]
+}
+
+type acceptOwner struct {
+	WaitSeconds uint32
+}
+
+// TO1 messages
+
+type helloRV struct {
+	GUID     protocol.GUID
+	ASigInfo sigInfo
+}
+
+type helloRVAck struct {
+	NonceTO1Proof protocol.Nonce
+	BSigInfo      sigInfo
+}
+
+// TO2 messages
+
+type helloDevice struct {
+	MaxDeviceMessageSize uint16
+	GUID                 protocol.GUID
+	NonceTO2ProveOV      protocol.Nonce
+	KexSuiteName         kex.Suite
+	CipherSuite          kex.CipherSuiteID
+	SigInfoA             sigInfo
+}
+
+type ovhProof struct {
+	OVH                 cbor.Bstr[fdo.VoucherHeader]
+	NumOVEntries        uint8
+	OVHHmac             protocol.Hmac
+	NonceTO2ProveOV     protocol.Nonce
+	SigInfoB            sigInfo
+	KeyExchangeA        []byte
+	HelloDeviceHash     protocol.Hash
+	MaxOwnerMessageSize uint16
+}
+
+type getOVNextEntry struct {
+	OVEntryNum int
+}
+
+type ovNextEntry struct {
+	OVEntryNum int
+	OVEntry    cose.Sign1Tag[fdo.VoucherEntryPayload, []byte]
+}
+
+type deviceSetup struct {
+	RendezvousInfo  [][]protocol.RvInstruction
+	GUID            protocol.GUID
+	NonceTO2SetupDv protocol.Nonce
+	Owner2Key       protocol.PublicKey
+}
+
+type deviceServiceInfoReady struct {
+	Hmac                    *protocol.Hmac
+	MaxOwnerServiceInfoSize *uint16
+}
+
+type ownerServiceInfoReady struct {
+	MaxDeviceServiceInfoSize *uint16
+}
+
+type deviceServiceInfo struct {
+	IsMoreServiceInfo bool
+	ServiceInfo       []*serviceinfo.KV
+}
+
+type ownerServiceInfo struct {
+	IsMoreServiceInfo bool
+	IsDone            bool
+	ServiceInfo       []*serviceinfo.KV
+}
+
+type done struct {
+	NonceTO2ProveDv protocol.Nonce
+}
+
+type done2 struct {
+	NonceTO2SetupDv protocol.Nonce
+}
+
+type empty struct{}
+
+func messages() []Vector {
+	serviceInfoSize := uint16(1300)
+	return []Vector{
+		{
+			Name:    "DI.AppStart",
+			MsgType: protocol.DIAppStartMsgType,
+			Value: appStart{DeviceMfgInfo: cbor.NewBstr(custom.DeviceMfgInfo{
+				KeyType:      protocol.Secp256r1KeyType,
+				KeyEncoding:  protocol.X509KeyEnc,
+				SerialNumber: "SN-0001",
+				DeviceInfo:   "example device",
+				CertInfo:     cbor.X509CertificateRequest(*fixtures().csr),
+			})},
+		},
+		{
+			Name:    "DI.SetCredentials",
+			MsgType: protocol.DISetCredentialsMsgType,
+			Value:   setCredentials{OVHeader: *cbor.NewBstr(voucherHeader())},
+		},
+		{
+			Name:    "DI.SetHMAC",
+			MsgType: protocol.DISetHmacMsgType,
+			Value:   setHmac{Hmac: hmac()},
+		},
+		{
+			Name:    "DI.Done",
+			MsgType: protocol.DIDoneMsgType,
+			Value:   empty{},
+		},
+		{
+			Name:    "TO0.Hello",
+			MsgType: protocol.TO0HelloMsgType,
+			Value:   to0Hello{},
+		},
+		{
+			Name:    "TO0.HelloAck",
+			MsgType: protocol.TO0HelloAckMsgType,
+			Value:   to0HelloAck{NonceTO0Sign: nonce(0x20)},
+		},
+		{
+			Name:    "TO0.OwnerSign",
+			MsgType: protocol.TO0OwnerSignMsgType,
+			Value: ownerSign{
+				To0d: *cbor.NewBstr(to0d{
+					Voucher:      voucher(),
+					WaitSeconds:  3600,
+					NonceTO0Sign: nonce(0x20),
+				}),
+				To1d: *sign1(to1d()).Tag(),
+			},
+		},
+		{
+			Name:    "TO0.AcceptOwner",
+			MsgType: protocol.TO0AcceptOwnerMsgType,
+			Value:   acceptOwner{WaitSeconds: 3600},
+		},
+		{
+			Name:    "TO1.HelloRV",
+			MsgType: protocol.TO1HelloRVMsgType,
+			Value:   helloRV{GUID: guid(), ASigInfo: sigInfo{Type: cose.ES256Alg}},
+		},
+		{
+			Name:    "TO1.HelloRVAck",
+			MsgType: protocol.TO1HelloRVAckMsgType,
+			Value:   helloRVAck{NonceTO1Proof: nonce(0x30), BSigInfo: sigInfo{Type: cose.ES256Alg}},
+		},
+		{
+			Name:    "TO1.ProveToRV",
+			MsgType: protocol.TO1ProveToRVMsgType,
+			Value:   *sign1(eat(nonce(0x30), nil)).Tag(),
+		},
+		{
+			Name:    "TO1.RVRedirect",
+			MsgType: protocol.TO1RVRedirectMsgType,
+			Value:   *sign1(to1d()).Tag(),
+		},
+		{
+			Name:    "TO2.HelloDevice",
+			MsgType: protocol.TO2HelloDeviceMsgType,
+			Value: helloDevice{
+				MaxDeviceMessageSize: 65535,
+				GUID:                 guid(),
+				NonceTO2ProveOV:      nonce(0x60),
+				KexSuiteName:         kex.ECDH256Suite,
+				CipherSuite:          kex.A128GcmCipher,
+				SigInfoA:             sigInfo{Type: cose.ES256Alg},
+			},
+		},
+		{
+			Name:    "TO2.ProveOVHdr",
+			MsgType: protocol.TO2ProveOVHdrMsgType,
+			Value: func() cose.Sign1Tag[ovhProof, []byte] {
+				s1 := sign1(ovhProof{
+					OVH:                 *cbor.NewBstr(voucherHeader()),
+					NumOVEntries:        1,
+					OVHHmac:             hmac(),
+					NonceTO2ProveOV:     nonce(0x60),
+					SigInfoB:            sigInfo{Type: cose.ES256Alg},
+					KeyExchangeA:        fill(0xa0, 32),
+					HelloDeviceHash:     hash(0x61),
+					MaxOwnerMessageSize: 65535,
+				})
+				s1.Unprotected = cose.HeaderMap{
+					cuphNonce:       nonce(0x64),
+					cuphOwnerPubKey: publicKey(),
+				}
+				return *s1.Tag()
+			}(),
+		},
+		{
+			Name:    "TO2.GetOVNextEntry",
+			MsgType: protocol.TO2GetOVNextEntryMsgType,
+			Value:   getOVNextEntry{OVEntryNum: 0},
+		},
+		{
+			Name:    "TO2.OVNextEntry",
+			MsgType: protocol.TO2OVNextEntryMsgType,
+			Value:   ovNextEntry{OVEntryNum: 0, OVEntry: voucherEntry()},
+		},
+		{
+			Name:    "TO2.ProveDevice",
+			MsgType: protocol.TO2ProveDeviceMsgType,
+			Value: func() cose.Sign1Tag[map[cose.Label]any, []byte] {
+				s1 := sign1(eat(nonce(0x64), []any{fill(0xb0, 32)}))
+				s1.Unprotected = cose.HeaderMap{euphNonce: nonce(0x65)}
+				return *s1.Tag()
+			}(),
+		},
+		{
+			Name:    "TO2.SetupDevice",
+			MsgType: protocol.TO2SetupDeviceMsgType,
+			Value: *sign1(deviceSetup{
+				RendezvousInfo:  rvInfo(),
+				GUID:            guid(),
+				NonceTO2SetupDv: nonce(0x65),
+				Owner2Key:       publicKey(),
+			}).Tag(),
+		},
+		{
+			Name:    "TO2.DeviceServiceInfoReady",
+			MsgType: protocol.TO2DeviceServiceInfoReadyMsgType,
+			Value:   deviceServiceInfoReady{MaxOwnerServiceInfoSize: &serviceInfoSize},
+		},
+		{
+			Name:    "TO2.OwnerServiceInfoReady",
+			MsgType: protocol.TO2OwnerServiceInfoReadyMsgType,
+			Value:   ownerServiceInfoReady{MaxDeviceServiceInfoSize: &serviceInfoSize},
+		},
+		{
+			Name:    "TO2.DeviceServiceInfo",
+			MsgType: protocol.TO2DeviceServiceInfoMsgType,
+			Value: deviceServiceInfo{
+				IsMoreServiceInfo: false,
+				ServiceInfo: []*serviceinfo.KV{
+					{Key: "devmod:active", Val: []byte{0xf5}},
+					{Key: "devmod:os", Val: []byte{0x65, 'L', 'i', 'n', 'u', 'x'}},
+				},
+			},
+		},
+		{
+			Name:    "TO2.OwnerServiceInfo",
+			MsgType: protocol.TO2OwnerServiceInfoMsgType,
+			Value: ownerServiceInfo{
+				IsMoreServiceInfo: false,
+				IsDone:            true,
+				ServiceInfo: []*serviceinfo.KV{
+					{Key: "fdo.command:active", Val: []byte{0xf5}},
+				},
+			},
+		},
+		{
+			Name:    "TO2.Done",
+			MsgType: protocol.TO2DoneMsgType,
+			Value:   done{NonceTO2ProveDv: nonce(0x64)},
+		},
+		{
+			Name:    "TO2.Done2",
+			MsgType: protocol.TO2Done2MsgType,
+			Value:   done2{NonceTO2SetupDv: nonce(0x65)},
+		},
+		{
+			Name:    "Error",
+			MsgType: protocol.ErrorMsgType,
+			Value: protocol.ErrorMessage{
+				Code:        protocol.InvalidMessageErrCode,
+				PrevMsgType: protocol.TO2ProveDeviceMsgType,
+				ErrString:   "invalid signature",
+				Timestamp:   1704067200,
+			},
+		},
+	}
+}
@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package testvectors
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	_ "embed"
+	"encoding/pem"
+	"net"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// EAT claims
+var (
+	eatNonce = cose.Label{Int64: 10}
+	eatUeid  = cose.Label{Int64: 256}
+	eatFdo   = cose.Label{Int64: -257}
+)
+
+func structures() []Vector {
+	return []Vector{
+		{Name: "GUID", Value: guid()},
+		{Name: "Hash", Value: hash(0x01)},
+		{Name: "PublicKey", Value: publicKey()},
+		{Name: "RendezvousInfo", Value: rvInfo()},
+		{Name: "To1d", Value: to1d()},
+		{Name: "OVHeader", Value: voucherHeader()},
+		{Name: "OVEntryPayload", Value: voucherEntryPayload()},
+		{Name: "OwnershipVoucher", Value: voucher()},
+		{Name: "DeviceCredential", Value: fdo.DeviceCredential{
+			Version:       protocol.Version101,
+			DeviceInfo:    "example device",
+			GUID:          guid(),
+			RvInfo:        rvInfo(),
+			PublicKeyHash: hash(0x02),
+		}},
+		{Name: "EAT", Value: eat(nonce(0x30), nil)},
+		{Name: "COSE_Sign1", Value: *sign1([]byte("payload")).Tag()},
+		{Name: "COSE_Mac0", Value: *cose.Mac0[[]byte, []byte]{
+			Header: cose.Header{
+				Protected: cose.HeaderMap{cose.AlgLabel: cose.HMac256},
+			},
+			Payload: cbor.NewByteWrap([]byte("payload")),
+			Value:   fill(0xc0, 32),
+		}.Tag()},
+		{Name: "COSE_Encrypt0", Value: *cose.Encrypt0[[]byte, []byte]{
+			Header: cose.Header{
+				Protected:   cose.HeaderMap{cose.AlgLabel: cose.A128GCM},
+				Unprotected: cose.HeaderMap{cose.IvLabel: fill(0xd0, 12)},
+			},
+			Ciphertext: func() *[]byte { b := fill(0xe0, 23); return &b }(),
+		}.Tag()},
+	}
+}
+
+//go:embed testdata/device.pem
+var devicePEM []byte
+
+// fixtures are values which cannot be created deterministically, such as
+// signed certificates, so they are parsed from testdata.
+var fixtures = sync.OnceValue(func() (f struct {
+	csr  *x509.CertificateRequest
+	cert *x509.Certificate
+}) {
+	rest := devicePEM
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE REQUEST":
+			f.csr, err = x509.ParseCertificateRequest(block.Bytes)
+		case "CERTIFICATE":
+			f.cert, err = x509.ParseCertificate(block.Bytes)
+		}
+		if err != nil {
+			panic("testvectors: invalid device.pem: " + err.Error())
+		}
+	}
+	if f.csr == nil || f.cert == nil {
+		panic("testvectors: device.pem must contain a certificate request and certificate")
+	}
+	return f
+})
+
+func fill(b byte, n int) []byte { return bytes.Repeat([]byte{b}, n) }
+
+func guid() (g protocol.GUID) {
+	for i := range g {
+		g[i] = byte(i)
+	}
+	return g
+}
+
+// ueid is EAT-RAND followed by the GUID.
+func ueid() []byte {
+	g := guid()
+	return append([]byte{0x01}, g[:]...)
+}
+
+func nonce(b byte) (n protocol.Nonce) {
+	copy(n[:], fill(b, len(n)))
+	return n
+}
+
+func hash(b byte) protocol.Hash {
+	return protocol.Hash{Algorithm: protocol.Sha256Hash, Value: fill(b, 32)}
+}
+
+func hmac() protocol.Hmac {
+	return protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: fill(0x0b, 32)}
+}
+
+func publicKey() protocol.PublicKey {
+	pub, err := protocol.NewPublicKey(protocol.Secp256r1KeyType, fixtures().cert.PublicKey.(*ecdsa.PublicKey), false)
+	if err != nil {
+		panic("testvectors: " + err.Error())
+	}
+	return *pub
+}
+
+func rvInfo() [][]protocol.RvInstruction {
+	return [][]protocol.RvInstruction{
+		{
+			{Variable: protocol.RVDns, Value: mustMarshal("rv.example.com")},
+			{Variable: protocol.RVDevPort, Value: mustMarshal(8080)},
+			{Variable: protocol.RVProtocol, Value: mustMarshal(protocol.RVProtHTTP)},
+		},
+		{
+			{Variable: protocol.RVIPAddress, Value: mustMarshal(net.IPv4(192, 0, 2, 1).To4())},
+			{Variable: protocol.RVBypass},
+		},
+	}
+}
+
+func to1d() protocol.To1d {
+	dns, ip := "owner.example.com", net.IPv4(192, 0, 2, 2).To4()
+	return protocol.To1d{
+		RV: []protocol.RvTO2Addr{
+			{DNSAddress: &dns, Port: 8443, TransportProtocol: protocol.HTTPSTransport},
+			{IPAddress: &ip, Port: 8080, TransportProtocol: protocol.HTTPTransport},
+		},
+		To0dHash: hash(0x03),
+	}
+}
+
+func voucherHeader() fdo.VoucherHeader {
+	certChainHash := hash(0x04)
+	return fdo.VoucherHeader{
+		Version:         protocol.Version101,
+		GUID:            guid(),
+		RvInfo:          rvInfo(),
+		DeviceInfo:      "example device",
+		ManufacturerKey: publicKey(),
+		CertChainHash:   &certChainHash,
+	}
+}
+
+func voucherEntryPayload() fdo.VoucherEntryPayload {
+	return fdo.VoucherEntryPayload{
+		PreviousHash: hash(0x05),
+		HeaderHash:   hash(0x06),
+		Extra:        cbor.NewBstr(map[int][]byte{1: []byte("extra")}),
+		PublicKey:    publicKey(),
+	}
+}
+
+func voucherEntry() cose.Sign1Tag[fdo.VoucherEntryPayload, []byte] {
+	return *sign1(voucherEntryPayload()).Tag()
+}
+
+func voucher() fdo.Voucher {
+	certChain := []*cbor.X509Certificate{(*cbor.X509Certificate)(fixtures().cert)}
+	return fdo.Voucher{
+		Version:   protocol.Version101,
+		Header:    *cbor.NewBstr(voucherHeader()),
+		Hmac:      hmac(),
+		CertChain: &certChain,
+		Entries:   []cose.Sign1Tag[fdo.VoucherEntryPayload, []byte]{voucherEntry()},
+	}
+}
+
+// eat returns an entity attestation token with the claims required by FDO
+// and an optional EAT-FDO claim.
+func eat(n protocol.Nonce, fdoClaim any) map[cose.Label]any {
+	token := map[cose.Label]any{
+		eatNonce: n,
+		eatUeid:  ueid(),
+	}
+	if fdoClaim != nil {
+		token[eatFdo] = fdoClaim
+	}
+	return token
+}
+
+// sign1 returns a COSE_Sign1 with an ES256 algorithm header and a fixed
+// signature.
+func sign1[P any](payload P) cose.Sign1[P, []byte] {
+	return cose.Sign1[P, []byte]{
+		Header: cose.Header{
+			Protected: cose.HeaderMap{cose.AlgLabel: cose.ES256Alg},
+		},
+		Payload:   cbor.NewByteWrap(payload),
+		Signature: fill(0x5a, 64),
+	}
+}
+
+func mustMarshal(v any) []byte {
+	b, err := cbor.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package testvectors contains golden CBOR encodings of every FDO protocol
+// message and of the COSE and EAT structures they are built from.
+//
+// Each vector is a value of a fixed message and the bytes it must encode to.
+// Values are deterministic: signatures, MACs, and ciphertexts are fixed
+// bytes rather than computed, so the encodings depend only on the cbor and
+// cose packages and the message definitions. A change to the wire format,
+// whether intended or not, fails the round trip tests of this package.
+//
+// Message bodies are defined here rather than shared with the
+// implementation, following the CDDL of the specification, so that a change
+// to the implementation does not silently change its vectors. The tests of
+// package fdo decode the vectors into the message types of the implementation
+// and check that they encode the same, so that the two definitions cannot
+// drift apart. The vectors may also be used to test other implementations.
+//
+// To regenerate the golden encodings after an intended change to the wire
+// format, run:
+//
+//	go test ./testvectors -update
+package testvectors
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Vector is a value and its golden CBOR encoding.
+type Vector struct {
+	// Name identifies the vector, such as "TO2.HelloDevice" for a message or
+	// "COSE_Sign1" for a structure.
+	Name string
+
+	// MsgType is the message type of protocol messages and zero otherwise.
+	MsgType uint8
+
+	// Value encodes to CBOR. Values of the same type as Value decode from
+	// CBOR.
+	Value any
+
+	// CBOR is the golden encoding of Value. It is nil if the golden file has
+	// no encoding for the vector.
+	CBOR []byte
+}
+
+//go:embed testdata/vectors.txt
+var golden []byte
+
+// Vectors returns all test vectors, protocol messages first, in order of
+// message type.
+func Vectors() []Vector {
+	encodings, err := parseGolden(golden)
+	if err != nil {
+		panic("testvectors: " + err.Error())
+	}
+	vectors := append(messages(), structures()...)
+	for i := range vectors {
+		vectors[i].CBOR = encodings[vectors[i].Name]
+	}
+	return vectors
+}
+
+// parseGolden parses lines of a vector name and its hex encoded CBOR. Blank
+// lines and lines starting with # are ignored.
+func parseGolden(data []byte) (map[string][]byte, error) {
+	encodings := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, encoded, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("golden file line %d: expected name and hex", n)
+		}
+		b, err := hex.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("golden file line %d: %w", n, err)
+		}
+		encodings[name] = b
+	}
+	return encodings, scanner.Err()
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package testvectors_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/testvectors"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/vectors.txt with the current encodings")
+
+func TestVectors(t *testing.T) {
+	vectors := testvectors.Vectors()
+
+	if *update {
+		var golden strings.Builder
+		golden.WriteString("# Golden CBOR encodings of FDO test vectors: name hex\n")
+		for _, v := range vectors {
+			b, err := cbor.Marshal(v.Value)
+			if err != nil {
+				t.Fatalf("%s: %v", v.Name, err)
+			}
+			fmt.Fprintf(&golden, "%s %x\n", v.Name, b)
+		}
+		if err := os.WriteFile("testdata/vectors.txt", []byte(golden.String()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Skip("updated golden encodings")
+	}
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			if v.CBOR == nil {
+				t.Fatal("missing golden encoding")
+			}
+
+			// Encode
+			got, err := cbor.Marshal(v.Value)
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			if !bytes.Equal(got, v.CBOR) {
+				t.Fatalf("encoding does not match golden\ngot:  %x\nwant: %x", got, v.CBOR)
+			}
+
+			// Decode and encode again
+			decoded := reflect.New(reflect.TypeOf(v.Value))
+			if err := cbor.Unmarshal(v.CBOR, decoded.Interface()); err != nil {
+				t.Fatalf("error decoding: %v", err)
+			}
+			again, err := cbor.Marshal(decoded.Elem().Interface())
+			if err != nil {
+				t.Fatalf("error encoding decoded value: %v", err)
+			}
+			if !bytes.Equal(again, v.CBOR) {
+				t.Fatalf("round trip does not match golden\ngot:  %x\nwant: %x", again, v.CBOR)
+			}
+		})
+	}
+}
+
+func TestVectorsCoverMessageTypes(t *testing.T) {
+	covered := make(map[uint8]bool)
+	for _, v := range testvectors.Vectors() {
+		if v.MsgType != 0 {
+			covered[v.MsgType] = true
+		}
+	}
+	for _, msgType := range []uint8{
+		protocol.DIAppStartMsgType, protocol.DISetCredentialsMsgType, protocol.DISetHmacMsgType, protocol.DIDoneMsgType,
+		protocol.TO0HelloMsgType, protocol.TO0HelloAckMsgType, protocol.TO0OwnerSignMsgType, protocol.TO0AcceptOwnerMsgType,
+		protocol.TO1HelloRVMsgType, protocol.TO1HelloRVAckMsgType, protocol.TO1ProveToRVMsgType, protocol.TO1RVRedirectMsgType,
+		protocol.TO2HelloDeviceMsgType, protocol.TO2ProveOVHdrMsgType, protocol.TO2GetOVNextEntryMsgType,
+		protocol.TO2OVNextEntryMsgType, protocol.TO2ProveDeviceMsgType, protocol.TO2SetupDeviceMsgType,
+		protocol.TO2DeviceServiceInfoReadyMsgType, protocol.TO2OwnerServiceInfoReadyMsgType,
+		protocol.TO2DeviceServiceInfoMsgType, protocol.TO2OwnerServiceInfoMsgType, protocol.TO2DoneMsgType,
+		protocol.TO2Done2MsgType, protocol.ErrorMsgType,
+	} {
+		if !covered[msgType] {
+			t.Errorf("no vector for message type %d", msgType)
+		}
+	}
+}
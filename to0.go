@@ -6,7 +6,6 @@ package fdo
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
@@ -36,17 +35,101 @@ type TO0Client struct {
 	//
 	// If TTL is 0, [DefaultRVBlobTTL] will be used.
 	TTL uint32
+
+	// If Registrations is non-nil, then each accepted registration is stored
+	// so that lapsing registrations may be found and refreshed.
+	Registrations TO0RegistrationPersistentState
+
+	// NewTransport creates a transport for a rendezvous server base URL. It
+	// is required by [TO0Client.RegisterAll].
+	NewTransport func(baseURL string) Transport
+
+	// TO2Addrs are the owner service addresses registered by
+	// [TO0Client.RegisterAll].
+	TO2Addrs []protocol.RvTO2Addr
+
+	// Attempts is the number of times [TO0Client.RegisterAll] tries to
+	// register each voucher with each rendezvous server when the failure is
+	// transient. If zero, each registration is tried once.
+	Attempts int
+
+	// RetryDelay is the time [TO0Client.RegisterAll] waits between attempts of
+	// the same registration.
+	RetryDelay time.Duration
+
+	// DeviceCertPolicy, if not nil, validates the device certificate chain of
+	// each voucher before its rendezvous blob is registered, so that devices
+	// which the owner service would reject, such as those with expired
+	// chains, are not directed to it. Chains expiring soon are logged.
+	DeviceCertPolicy *DeviceCertPolicy
+}
+
+// TO0Registration describes a rendezvous blob accepted by a Rendezvous
+// Server.
+type TO0Registration struct {
+	GUID protocol.GUID
+
+	// RvURL is the base URL of the Rendezvous Server, as given to
+	// [TO0Client.RegisterAll]. It is empty for registrations made with
+	// [TO0Client.Register] or [TO0Client.RegisterBlob], which are not given
+	// the URL.
+	RvURL string
+
+	// WaitSeconds is the TTL accepted by the Rendezvous Server, which may be
+	// less than requested.
+	WaitSeconds uint32
+
+	// Expires is when the Rendezvous Server may stop directing the device to
+	// its owner.
+	Expires time.Time
+
+	// RefreshAt is when the rendezvous blob should be registered again so
+	// that it does not lapse. It leaves a tenth of the TTL to do so.
+	RefreshAt time.Time
+}
+
+// newTO0Registration computes the deadlines of a registration accepted at the
+// given time.
+func newTO0Registration(guid protocol.GUID, rvURL string, waitSeconds uint32, accepted time.Time) TO0Registration {
+	wait := time.Duration(waitSeconds) * time.Second
+	return TO0Registration{
+		GUID:        guid,
+		RvURL:       rvURL,
+		WaitSeconds: waitSeconds,
+		Expires:     accepted.Add(wait),
+		RefreshAt:   accepted.Add(wait - wait/10),
+	}
 }
 
 // RegisterBlob tells a Rendezvous Server where to direct a given device to its
 // owner service for onboarding. The returned uint32 is the number of seconds
 // before the rendezvous blob must be refreshed by calling [RegisterBlob] again.
+//
+// Use [TO0Client.Register] to also get the deadlines computed from it.
 func (c *TO0Client) RegisterBlob(ctx context.Context, transport Transport, guid protocol.GUID, addrs []protocol.RvTO2Addr) (uint32, error) {
+	reg, err := c.Register(ctx, transport, guid, addrs)
+	if err != nil {
+		return 0, err
+	}
+	return reg.WaitSeconds, nil
+}
+
+// Register is like [TO0Client.RegisterBlob], but returns the accepted TTL
+// along with when the registration lapses and should be refreshed. If
+// Registrations is set, then the registration is also stored, replacing the
+// previous registration of the device without a Rendezvous Server URL.
+func (c *TO0Client) Register(ctx context.Context, transport Transport, guid protocol.GUID, addrs []protocol.RvTO2Addr) (*TO0Registration, error) {
+	return c.registerWith(ctx, transport, "", guid, addrs)
+}
+
+// registerWith registers a rendezvous blob with the Rendezvous Server at a
+// base URL, which identifies the stored registration.
+func (c *TO0Client) registerWith(ctx context.Context, transport Transport, rvURL string, guid protocol.GUID, addrs []protocol.RvTO2Addr) (*TO0Registration, error) {
 	ctx = contextWithErrMsg(ctx)
 
 	nonce, err := c.hello(ctx, transport)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	ttl := c.TTL
@@ -54,7 +137,21 @@ func (c *TO0Client) RegisterBlob(ctx context.Context, transport Transport, guid
 		ttl = DefaultRVBlobTTL
 	}
 
-	return c.ownerSign(ctx, transport, guid, ttl, nonce, addrs)
+	start := time.Now()
+	waitSeconds, err := c.ownerSign(ctx, transport, guid, ttl, nonce, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the TTL from before TO0.OwnerSign was sent, so that a slow
+	// exchange cannot delay refreshing past the actual expiration
+	reg := newTO0Registration(guid, rvURL, waitSeconds, start)
+	if c.Registrations != nil {
+		if err := c.Registrations.SetTO0Registration(ctx, reg); err != nil {
+			return nil, fmt.Errorf("error storing TO0 registration: %w", err)
+		}
+	}
+	return &reg, nil
 }
 
 // Hello(20) -> HelloAck(21)
@@ -65,7 +162,7 @@ func (c *TO0Client) hello(ctx context.Context, transport Transport) (protocol.No
 	// Make request
 	typ, resp, err := transport.Send(ctx, protocol.TO0HelloMsgType, msg, nil)
 	if err != nil {
-		return protocol.Nonce{}, fmt.Errorf("error sending TO0.Hello: %w", err)
+		return protocol.Nonce{}, fmt.Errorf("error sending TO0.Hello: %w", sendError{err})
 	}
 	defer func() { _ = resp.Close() }()
 
@@ -101,17 +198,21 @@ type to0Ack struct {
 func (s *TO0Server) helloAck(ctx context.Context, msg io.Reader) (*to0Ack, error) {
 	var hello struct{}
 	if err := cbor.NewDecoder(msg).Decode(&hello); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO0.Hello request: %w", err)
 	}
 
 	// Generate and store nonce
 	var nonce protocol.Nonce
-	if _, err := rand.Read(nonce[:]); err != nil {
+	if _, err := io.ReadFull(randOrDefault(s.Rand), nonce[:]); err != nil {
 		return nil, fmt.Errorf("error generating nonce for TO0 sign: %w", err)
 	}
 	if err := s.Session.SetTO0SignNonce(ctx, nonce); err != nil {
 		return nil, fmt.Errorf("error storing nonce for TO0.OwnerSign: %w", err)
 	}
+	if err := addNonce(ctx, s.Nonces, s.NonceTTL, nonce); err != nil {
+		return nil, fmt.Errorf("error storing nonce for TO0.OwnerSign: %w", err)
+	}
 
 	return &to0Ack{
 		NonceTO0Sign: nonce,
@@ -139,6 +240,11 @@ func (c *TO0Client) ownerSign(ctx context.Context, transport Transport, guid pro
 	if len(ov.Entries) == 0 {
 		return 0, fmt.Errorf("ownership voucher has zero extensions")
 	}
+	if c.DeviceCertPolicy != nil {
+		if _, err := c.DeviceCertPolicy.CheckVoucher(ctx, ov); err != nil {
+			return 0, err
+		}
+	}
 	to0d := to0d{
 		Voucher:      *ov,
 		WaitSeconds:  ttl,
@@ -152,7 +258,11 @@ func (c *TO0Client) ownerSign(ctx context.Context, transport Transport, guid pro
 
 	// Sign to1d rendezvous blob
 	keyType := ov.Header.Val.ManufacturerKey.Type
-	ownerKey, _, err := c.OwnerKeys.OwnerKey(keyType)
+	ownerPubKey, err := ov.OwnerPublicKey()
+	if err != nil {
+		return 0, fmt.Errorf("error parsing owner public key from voucher: %w", err)
+	}
+	ownerKey, _, err := OwnerKeyFor(ctx, c.OwnerKeys, keyType, ownerPubKey)
 	if errors.Is(err, ErrNotFound) {
 		return 0, fmt.Errorf("no available owner key for TO0.OwnerSign [type=%s]", keyType)
 	} else if err != nil {
@@ -182,7 +292,7 @@ func (c *TO0Client) ownerSign(ctx context.Context, transport Transport, guid pro
 	// Make request
 	typ, resp, err := transport.Send(ctx, protocol.TO0OwnerSignMsgType, msg, nil)
 	if err != nil {
-		return 0, fmt.Errorf("error sending TO0.OwnerSign: %w", err)
+		return 0, fmt.Errorf("error sending TO0.OwnerSign: %w", sendError{err})
 	}
 	defer func() { _ = resp.Close() }()
 
@@ -232,17 +342,29 @@ func (s *TO0Server) acceptOwner(ctx context.Context, msg io.Reader) (*to0AcceptO
 		return nil, fmt.Errorf("to0d did not match hash in to1d")
 	}
 
+	// Check that the nonce was issued and not yet used
+	if err := consumeNonce(ctx, s.Nonces, sig.To0d.Val.NonceTO0Sign); err != nil {
+		return nil, fmt.Errorf("TO0.OwnerSign nonce: %w", err)
+	}
+
 	// Verify ownership voucher is valid
 	ov := sig.To0d.Val.Voucher
+	protocol.TraceGUID(ctx, ov.Header.Val.GUID)
 	if len(ov.Entries) == 0 {
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
 		return nil, fmt.Errorf("voucher has not been extended")
 	}
-	if err := ov.VerifyEntries(); err != nil {
+	if err := s.verifyEntries(ctx, &ov); err != nil {
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
 		return nil, fmt.Errorf("voucher is not valid: %w", err)
 	}
 
+	// Verify rendezvous blob is signed by the owner
+	if err := s.verifyTo1d(ctx, &ov, sig.To1d.Untag()); err != nil {
+		captureErr(ctx, protocol.InvalidOwnerSignBodyCode, "")
+		return nil, fmt.Errorf("to1d: %w", err)
+	}
+
 	// Use optional callback to decide whether to accept voucher
 	if s.AcceptVoucher != nil {
 		if accept, err := s.AcceptVoucher(ctx, ov); err != nil {
@@ -264,6 +386,8 @@ func (s *TO0Server) acceptOwner(ctx context.Context, msg io.Reader) (*to0AcceptO
 	if err := s.RVBlobs.SetRVBlob(ctx, &ov, sig.To1d.Untag(), expiration); err != nil {
 		return nil, fmt.Errorf("error storing rendezvous blob: %w", err)
 	}
+	s.Cache.Invalidate(ov.Header.Val.GUID)
+	updateDeviceStatus(ctx, s.DeviceStatus, ov.Header.Val.GUID, DeviceRegistered)
 
 	return &to0AcceptOwner{
 		WaitSeconds: negotiatedTTL,
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// TO0Result is the outcome of registering the rendezvous blob of one device
+// with one rendezvous server.
+type TO0Result struct {
+	GUID  protocol.GUID
+	RvURL string
+
+	// WaitSeconds is the TTL accepted by the rendezvous server and RefreshAt
+	// is when the registration should be refreshed. They are zero if
+	// registration failed.
+	WaitSeconds uint32
+	RefreshAt   time.Time
+
+	// Attempts is the number of TO0 runs, including the successful one. It is
+	// zero if the registration was never tried because the context ended.
+	Attempts int
+
+	// Err is the error of the last attempt, or nil on success.
+	Err error
+}
+
+// TO0Report aggregates the results of [TO0Client.RegisterAll].
+type TO0Report struct {
+	// Results contains one entry per GUID and rendezvous server, ordered by
+	// GUID and then by server, in the order given to RegisterAll.
+	Results []TO0Result
+
+	// Succeeded and Failed count the results with and without an error.
+	Succeeded, Failed int
+}
+
+// sendError marks a failure to exchange a TO0 message with the rendezvous
+// server. Unlike errors received from the server or local errors, these may
+// succeed when retried.
+type sendError struct{ error }
+
+func (e sendError) Unwrap() error { return e.error }
+
+// RegisterAll registers the rendezvous blob of each device with each
+// rendezvous server, using at most concurrency simultaneous TO0 runs. The
+// client's NewTransport and TO2Addrs are used for every registration.
+//
+// Registrations failing to reach the rendezvous server are retried up to
+// Attempts times. Errors returned by the server, such as a rejected voucher,
+// and local errors, such as a missing owner key, are not retried.
+//
+// A report is always returned. If any registration failed, the error reports
+// the number of failures and wraps the first one. If the context ends, the
+// remaining registrations fail with the context's error.
+func (c *TO0Client) RegisterAll(ctx context.Context, guids []protocol.GUID, rvURLs []string, concurrency int) (*TO0Report, error) {
+	if c.NewTransport == nil {
+		return nil, errors.New("TO0: transport factory is required")
+	}
+	concurrency = max(concurrency, 1)
+
+	report := &TO0Report{Results: make([]TO0Result, 0, len(guids)*len(rvURLs))}
+	for _, guid := range guids {
+		for _, rvURL := range rvURLs {
+			report.Results = append(report.Results, TO0Result{GUID: guid, RvURL: rvURL})
+		}
+	}
+
+	// Each worker writes only the results it receives the index of
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(report.Results)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c.register(ctx, &report.Results[i])
+			}
+		}()
+	}
+	for i := range report.Results {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var firstErr error
+	for _, result := range report.Results {
+		if result.Err == nil {
+			report.Succeeded++
+			continue
+		}
+		report.Failed++
+		if firstErr == nil {
+			firstErr = fmt.Errorf("device %x with %s: %w", result.GUID[:], result.RvURL, result.Err)
+		}
+	}
+	if firstErr != nil {
+		return report, fmt.Errorf("TO0: %d of %d registrations failed, first error: %w", report.Failed, len(report.Results), firstErr)
+	}
+	return report, nil
+}
+
+// register runs TO0 for a single result, retrying transient failures.
+func (c *TO0Client) register(ctx context.Context, result *TO0Result) {
+	attempts := max(c.Attempts, 1)
+	for i := range attempts {
+		if err := ctx.Err(); err != nil {
+			if result.Err == nil {
+				result.Err = err
+			}
+			return
+		}
+		if i > 0 && c.RetryDelay > 0 {
+			if err := sleep(ctx, c.RetryDelay); err != nil {
+				return
+			}
+		}
+
+		result.Attempts++
+		reg, err := c.registerWith(ctx, c.NewTransport(result.RvURL), result.RvURL, result.GUID, c.TO2Addrs)
+		if err == nil {
+			result.WaitSeconds, result.RefreshAt, result.Err = reg.WaitSeconds, reg.RefreshAt, nil
+			return
+		}
+		result.Err = err
+		if !errors.As(err, new(sendError)) {
+			return
+		}
+	}
+}
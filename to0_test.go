@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+type unreachableTransport struct{ sends *atomic.Int32 }
+
+func (t unreachableTransport) Send(context.Context, uint8, any, kex.Session) (uint8, io.ReadCloser, error) {
+	t.sends.Add(1)
+	return 0, nil, errors.New("connection refused")
+}
+
+func TestTO0RegisterAll(t *testing.T) {
+	server := fdotest.NewServer(t)
+	var guids []protocol.GUID
+	for range 8 {
+		guids = append(guids, server.NewDevice(t, protocol.Secp256r1KeyType).Cred.GUID)
+	}
+	unknown := protocol.GUID{0xff}
+	guids = append(guids, unknown)
+
+	var sends atomic.Int32
+	dnsAddr := "owner.fidoalliance.org"
+	client := *server.TO0Client
+	client.NewTransport = func(baseURL string) fdo.Transport {
+		if baseURL == "https://down.example.com" {
+			return unreachableTransport{&sends}
+		}
+		return server.Transport()
+	}
+	client.TO2Addrs = []protocol.RvTO2Addr{{DNSAddress: &dnsAddr, Port: 8080, TransportProtocol: protocol.HTTPTransport}}
+	client.Attempts = 3
+
+	rvURLs := []string{"https://rv.example.com", "https://down.example.com"}
+	report, err := client.RegisterAll(context.Background(), guids, rvURLs, 4)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(report.Results) != len(guids)*len(rvURLs) {
+		t.Fatalf("expected %d results, got %d", len(guids)*len(rvURLs), len(report.Results))
+	}
+	if report.Succeeded != 8 || report.Failed != 10 {
+		t.Fatalf("expected 8 succeeded and 10 failed, got %d and %d", report.Succeeded, report.Failed)
+	}
+	for i, result := range report.Results {
+		if result.GUID != guids[i/2] || result.RvURL != rvURLs[i%2] {
+			t.Fatalf("result %d out of order: %x %s", i, result.GUID, result.RvURL)
+		}
+		switch {
+		case result.RvURL == "https://down.example.com":
+			if result.Err == nil || result.Attempts != 3 {
+				t.Errorf("expected unreachable server to fail after 3 attempts, got %d: %v", result.Attempts, result.Err)
+			}
+		case result.GUID == unknown:
+			if result.Err == nil || result.Attempts != 1 {
+				t.Errorf("expected unknown device to fail without retrying, got %d attempts: %v", result.Attempts, result.Err)
+			}
+		default:
+			if result.Err != nil || result.Attempts != 1 || result.WaitSeconds == 0 {
+				t.Errorf("expected registration of %x to succeed, got %d attempts: %v", result.GUID, result.Attempts, result.Err)
+			}
+		}
+	}
+	if n := sends.Load(); n != 3*9 {
+		t.Errorf("expected %d sends to unreachable server, got %d", 3*9, n)
+	}
+
+	// Registrations with each rendezvous server are stored separately
+	if _, err := client.RegisterAll(context.Background(), guids[:1], []string{"https://rv2.example.com"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	regs, err := server.State.TO0Registrations(context.Background(), guids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != 2 || regs[0].RvURL != "https://rv.example.com" || regs[1].RvURL != "https://rv2.example.com" {
+		t.Fatalf("expected a registration with each rendezvous server, got %+v", regs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report, err = client.RegisterAll(ctx, guids[:1], rvURLs[:1], 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	if report.Results[0].Attempts != 0 {
+		t.Fatalf("expected no attempts after context ended, got %d", report.Results[0].Attempts)
+	}
+}
+
+func TestTO0Register(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO0.NegotiateTTL = func(requested uint32, _ fdo.Voucher) uint32 { return 1000 }
+	guid := server.NewDevice(t, protocol.Secp256r1KeyType).Cred.GUID
+
+	start := time.Now()
+	dnsAddr := "owner.fidoalliance.org"
+	reg, err := server.TO0Client.Register(context.Background(), server.Transport(), guid, []protocol.RvTO2Addr{
+		{DNSAddress: &dnsAddr, Port: 8080, TransportProtocol: protocol.HTTPTransport},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.GUID != guid || reg.WaitSeconds != 1000 {
+		t.Fatalf("expected registration of %x for 1000s, got %x for %ds", guid, reg.GUID, reg.WaitSeconds)
+	}
+	if reg.Expires.Sub(reg.RefreshAt) != 100*time.Second {
+		t.Errorf("expected refresh 100s before expiration, got %s", reg.Expires.Sub(reg.RefreshAt))
+	}
+	if reg.Expires.Before(start.Add(1000*time.Second)) || reg.Expires.After(time.Now().Add(1000*time.Second)) {
+		t.Errorf("expiration %s is not 1000s after registering", reg.Expires)
+	}
+
+	lapsing, err := server.State.LapsingTO0Registrations(context.Background(), reg.RefreshAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lapsing) != 1 || lapsing[0] != *reg {
+		t.Fatalf("expected stored registration %+v, got %+v", *reg, lapsing)
+	}
+	if lapsing, err = server.State.LapsingTO0Registrations(context.Background(), start); err != nil {
+		t.Fatal(err)
+	} else if len(lapsing) != 0 {
+		t.Fatalf("expected no lapsing registrations, got %+v", lapsing)
+	}
+}
+
+func TestTO0RegisterWithDeviceCertPolicy(t *testing.T) {
+	server := fdotest.NewServer(t)
+	guid := server.NewDevice(t, protocol.Secp256r1KeyType).Cred.GUID
+
+	// Device certificate chains of fdotest devices have a length of two
+	client := *server.TO0Client
+	client.DeviceCertPolicy = &fdo.DeviceCertPolicy{MaxChainLength: 1}
+	dnsAddr := "owner.fidoalliance.org"
+	addrs := []protocol.RvTO2Addr{{DNSAddress: &dnsAddr, Port: 8080, TransportProtocol: protocol.HTTPTransport}}
+	if _, err := client.Register(context.Background(), server.Transport(), guid, addrs); err == nil || !strings.Contains(err.Error(), "exceeds maximum") {
+		t.Fatalf("expected device certificate policy to prevent registration, got %v", err)
+	}
+
+	// The CA certificate of fdotest devices is not a valid issuer, so their
+	// chains only pass report-only policies
+	client.DeviceCertPolicy = &fdo.DeviceCertPolicy{ReportOnly: true}
+	if _, err := client.Register(context.Background(), server.Transport(), guid, addrs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTO0RegisterVerifyOffload(t *testing.T) {
+	server := fdotest.NewServer(t)
+	var entryVerifies, to1dVerifies atomic.Int32
+	var rejectTo1d atomic.Bool
+	server.TO0.VerifyEntries = func(_ context.Context, ov *fdo.Voucher) error {
+		entryVerifies.Add(1)
+		return ov.VerifyEntries()
+	}
+	server.TO0.VerifyTo1d = func(_ context.Context, ownerKey crypto.PublicKey, to1d *cose.Sign1[protocol.To1d, []byte]) error {
+		to1dVerifies.Add(1)
+		if rejectTo1d.Load() {
+			return errors.New("rejected by remote verifier")
+		}
+		if ok, err := to1d.Verify(ownerKey, nil, nil); err != nil || !ok {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+		return nil
+	}
+	server.TO0.VerifiedVouchers = &fdo.VerifiedVoucherCache{Size: 8}
+	guid := server.NewDevice(t, protocol.Secp256r1KeyType).Cred.GUID
+
+	register := func() error {
+		dnsAddr := "owner.fidoalliance.org"
+		_, err := server.TO0Client.Register(context.Background(), server.Transport(), guid, []protocol.RvTO2Addr{
+			{DNSAddress: &dnsAddr, Port: 8080, TransportProtocol: protocol.HTTPTransport},
+		})
+		return err
+	}
+
+	// Renewing a registration does not verify the same voucher again, but
+	// does verify the new rendezvous blob
+	for range 2 {
+		if err := register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := entryVerifies.Load(); n != 1 {
+		t.Errorf("expected voucher entries to be verified once, got %d", n)
+	}
+	if n := to1dVerifies.Load(); n != 2 {
+		t.Errorf("expected to1d to be verified twice, got %d", n)
+	}
+	if n := server.TO0.VerifiedVouchers.Len(); n != 1 {
+		t.Errorf("expected 1 verified voucher, got %d", n)
+	}
+
+	rejectTo1d.Store(true)
+	var errMsg protocol.ErrorMessage
+	if err := register(); !errors.As(err, &errMsg) || errMsg.Code != protocol.InvalidOwnerSignBodyCode {
+		t.Fatalf("expected invalid owner sign body error, got %v", err)
+	}
+}
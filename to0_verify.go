@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// VerifiedVoucherCache remembers, in memory, the vouchers whose entry
+// signatures have been verified by a TO0Server, so that an owner service
+// renewing a registration with the same voucher does not cost another
+// verification. It is safe for concurrent use and its zero value remembers
+// nothing.
+//
+// Vouchers are identified by a hash of their whole encoding rather than only
+// their header, because the header does not commit to the entries.
+type VerifiedVoucherCache struct {
+	// Size is the maximum number of vouchers remembered. The least recently
+	// used voucher is forgotten when it is exceeded.
+	Size int
+
+	mu     sync.Mutex
+	hashes lru[[sha256.Size]byte, struct{}]
+}
+
+// Len returns the number of vouchers remembered.
+func (c *VerifiedVoucherCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hashes.len()
+}
+
+func (c *VerifiedVoucherCache) verified(hash [sha256.Size]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.hashes.get(hash)
+	return ok
+}
+
+func (c *VerifiedVoucherCache) add(hash [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes.add(hash, struct{}{}, c.Size)
+}
+
+// verifyEntries verifies the entry signatures of a voucher, unless the same
+// voucher has already been verified.
+func (s *TO0Server) verifyEntries(ctx context.Context, ov *Voucher) error {
+	verify := s.VerifyEntries
+	if verify == nil {
+		verify = func(_ context.Context, ov *Voucher) error { return ov.VerifyEntries() }
+	}
+	if s.VerifiedVouchers == nil || s.VerifiedVouchers.Size <= 0 {
+		return verify(ctx, ov)
+	}
+
+	data, err := cbor.Marshal(ov)
+	if err != nil {
+		return fmt.Errorf("error hashing voucher: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	if s.VerifiedVouchers.verified(hash) {
+		return nil
+	}
+	if err := verify(ctx, ov); err != nil {
+		return err
+	}
+	s.VerifiedVouchers.add(hash)
+	return nil
+}
+
+// verifyTo1d verifies that the rendezvous blob is signed by the owner key of
+// the voucher.
+func (s *TO0Server) verifyTo1d(ctx context.Context, ov *Voucher, to1d *cose.Sign1[protocol.To1d, []byte]) error {
+	ownerKey, err := ov.OwnerPublicKey()
+	if err != nil {
+		return fmt.Errorf("error parsing owner public key: %w", err)
+	}
+	if s.VerifyTo1d != nil {
+		return s.VerifyTo1d(ctx, ownerKey, to1d)
+	}
+	if ok, err := to1d.Verify(ownerKey, nil, nil); err != nil {
+		return fmt.Errorf("error verifying signature: %w", err)
+	} else if !ok {
+		return fmt.Errorf("%w: signature verification failed", ErrCryptoVerifyFailed)
+	}
+	return nil
+}
@@ -4,25 +4,35 @@
 package fdo
 
 import (
-	"bytes"
 	"context"
 	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/cose"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
+// ErrBusy is used when TO1.HelloRV is rejected because TO1Server.Backoff
+// asked the device to retry after a delay.
+var ErrBusy = errors.New("rendezvous server is busy")
+
 // TO1Options contains optional configuration values.
 type TO1Options struct {
 	// When true and an RSA key is used as a crypto.Signer argument, RSA-SSAPSS
 	// will be used for signing.
 	PSS bool
+
+	// Optional callbacks for TO1 completion and errors.
+	Hooks ClientHooks
+
+	// Clock, if not nil, is used in place of the system clock for the
+	// timestamp of an error message sent to the rendezvous server.
+	Clock Clock
 }
 
 // TO1 runs the TO1 protocol and returns the owner service (TO2) addresses. It
@@ -32,9 +42,23 @@ func TO1(ctx context.Context, transport Transport, cred DeviceCredential, key cr
 	ctx = contextWithErrMsg(ctx)
 
 	var usePSS bool
+	var hooks ClientHooks
 	if opts != nil {
 		usePSS = opts.PSS
+		hooks = opts.Hooks
+		ctx = contextWithClock(ctx, opts.Clock)
+	}
+
+	blob, err := to1(ctx, transport, cred, key, usePSS)
+	if err != nil {
+		hooks.error(ctx, protocol.TO1Protocol, err)
+		return nil, err
 	}
+	hooks.to1Complete(blob)
+	return blob, nil
+}
+
+func to1(ctx context.Context, transport Transport, cred DeviceCredential, key crypto.Signer, usePSS bool) (*cose.Sign1[protocol.To1d, []byte], error) {
 	signOpts, err := signOptsFor(key, usePSS)
 	if err != nil {
 		return nil, fmt.Errorf("error determining signing options: %w", err)
@@ -115,13 +139,28 @@ type rvAck struct {
 
 // HelloRV(30) -> HelloRVAck(31)
 func (s *TO1Server) helloRVAck(ctx context.Context, msg io.Reader) (*rvAck, error) {
+	// Shed load before doing any work
+	if s.Backoff != nil {
+		if delay := s.Backoff(ctx); delay > 0 {
+			captureErr(ctx, protocol.InternalServerErrCode, protocol.ErrorDetail{ID: protocol.BusyErrID}.String())
+			captureRetryAfter(ctx, delay)
+			return nil, fmt.Errorf("%w: retry after %s", ErrBusy, delay)
+		}
+	}
+
 	var hello helloRV
 	if err := cbor.NewDecoder(msg).Decode(&hello); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO1.HelloRV request: %w", err)
 	}
+	protocol.TraceGUID(ctx, hello.GUID)
+	if err := cryptoProfileOrDefault(s.CryptoProfile).CheckSignature(hello.ASigInfo.Type); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return nil, fmt.Errorf("device attestation: %w", err)
+	}
 
 	// Check if device has been registered
-	if _, _, err := s.RVBlobs.RVBlob(ctx, hello.GUID); errors.Is(err, ErrNotFound) {
+	if _, _, err := s.rvBlob(ctx, hello.GUID); errors.Is(err, ErrNotFound) {
 		captureErr(ctx, protocol.ResourceNotFound, "")
 		return nil, ErrNotFound
 	} else if err != nil {
@@ -130,12 +169,15 @@ func (s *TO1Server) helloRVAck(ctx context.Context, msg io.Reader) (*rvAck, erro
 
 	// Generate and store nonce
 	var nonce protocol.Nonce
-	if _, err := rand.Read(nonce[:]); err != nil {
+	if _, err := io.ReadFull(randOrDefault(s.Rand), nonce[:]); err != nil {
 		return nil, fmt.Errorf("error generating nonce for TO1 proof: %w", err)
 	}
 	if err := s.Session.SetTO1ProofNonce(ctx, nonce); err != nil {
 		return nil, fmt.Errorf("error storing nonce for TO1.ProveToRV: %w", err)
 	}
+	if err := addNonce(ctx, s.Nonces, s.NonceTTL, nonce); err != nil {
+		return nil, fmt.Errorf("error storing nonce for TO1.ProveToRV: %w", err)
+	}
 
 	return &rvAck{
 		NonceTO1Proof: nonce,
@@ -192,10 +234,12 @@ func (s *TO1Server) rvRedirect(ctx context.Context, msg io.Reader) (*cose.Sign1T
 	// encoding of CBOR (even though FDO requires this).
 	var token cose.Sign1Tag[cbor.RawBytes, []byte]
 	if err := cbor.NewDecoder(msg).Decode(&token); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO1.ProveToRV request: %w", err)
 	}
-	var eat eatoken
-	if err := cbor.Unmarshal([]byte(token.Payload.Val), &eat); err != nil {
+	eat, err := ParseEAT([]byte(token.Payload.Val))
+	if err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
 		return nil, fmt.Errorf("error decoding TO1.ProveToRV request: %w", err)
 	}
 
@@ -204,35 +248,20 @@ func (s *TO1Server) rvRedirect(ctx context.Context, msg io.Reader) (*cose.Sign1T
 	if err != nil {
 		return nil, fmt.Errorf("error getting TO1 proof nonce: %w", err)
 	}
-	nonce, ok := eat[eatNonceClaim].([]byte)
-	if !ok {
-		captureErr(ctx, protocol.InvalidMessageErrCode, "")
-		return nil, fmt.Errorf("EAT missing nonce claim")
-	}
-	if !bytes.Equal(nonce, proofNonce[:]) {
+	if eat.Nonce != proofNonce {
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
 		return nil, fmt.Errorf("EAT nonce does not match")
 	}
+	if err := consumeNonce(ctx, s.Nonces, eat.Nonce); err != nil {
+		return nil, fmt.Errorf("EAT nonce: %w", err)
+	}
 
 	// Get GUID from EAT
-	ueid, ok := eat[eatUeidClaim].([]byte)
-	if !ok {
-		captureErr(ctx, protocol.InvalidMessageErrCode, "")
-		return nil, fmt.Errorf("EAT missing UEID claim")
-	}
-	if len(ueid) != 1+len(protocol.GUID{}) {
-		captureErr(ctx, protocol.InvalidMessageErrCode, "")
-		return nil, fmt.Errorf("EAT UEID claim is not a valid length")
-	}
-	if ueid[0] != eatRandUeid {
-		captureErr(ctx, protocol.InvalidMessageErrCode, "")
-		return nil, fmt.Errorf("EAT UEID type must be RAND")
-	}
-	var guid protocol.GUID
-	_ = copy(guid[:], ueid[1:])
+	guid := eat.GUID
+	protocol.TraceGUID(ctx, guid)
 
 	// Get device public key from ownership voucher
-	blob, ov, err := s.RVBlobs.RVBlob(ctx, guid)
+	blob, ov, err := s.rvBlob(ctx, guid)
 	if err != nil {
 		return nil, fmt.Errorf("error looking up rendezvous blob: %w", err)
 	}
@@ -251,5 +280,80 @@ func (s *TO1Server) rvRedirect(ctx context.Context, msg io.Reader) (*cose.Sign1T
 	}
 
 	// Return RV blob
+	updateDeviceStatus(ctx, s.DeviceStatus, guid, DeviceContacted)
 	return blob.Tag(), nil
 }
+
+// rvBlob looks up the rendezvous blob of a device, using the cache if
+// configured.
+func (s *TO1Server) rvBlob(ctx context.Context, guid protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *Voucher, error) {
+	blob, ov, generation, ok := s.Cache.get(guid)
+	if ok {
+		return blob, ov, nil
+	}
+	blob, ov, err := s.RVBlobs.RVBlob(ctx, guid)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.Cache.add(guid, blob, ov, generation)
+	return blob, ov, nil
+}
+
+// TO1Stats counts the outcomes of TO1 messages handled by a [TO1Server]. It
+// is safe for concurrent use and its zero value is ready to use.
+type TO1Stats struct {
+	lookups, notFound, redirects, busy, failures atomic.Uint64
+}
+
+// TO1Counts is a snapshot of [TO1Stats].
+type TO1Counts struct {
+	// Lookups is the number of TO1.HelloRV messages, including those for
+	// devices without a registered rendezvous blob.
+	Lookups uint64
+
+	// NotFound is the number of lookups for devices without a registered
+	// rendezvous blob.
+	NotFound uint64
+
+	// Redirects is the number of devices which proved possession of their
+	// key and received a rendezvous blob.
+	Redirects uint64
+
+	// Busy is the number of lookups which asked the device to retry later,
+	// because of TO1Server.Backoff.
+	Busy uint64
+
+	// Failures is the number of messages which failed for any reason other
+	// than the device not being registered or the server being busy.
+	Failures uint64
+}
+
+// Counts returns the current counts.
+func (s *TO1Stats) Counts() TO1Counts {
+	return TO1Counts{
+		Lookups:   s.lookups.Load(),
+		NotFound:  s.notFound.Load(),
+		Redirects: s.redirects.Load(),
+		Busy:      s.busy.Load(),
+		Failures:  s.failures.Load(),
+	}
+}
+
+func (s *TO1Stats) count(msgType uint8, err error) {
+	if s == nil {
+		return
+	}
+	if msgType == protocol.TO1HelloRVMsgType {
+		s.lookups.Add(1)
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		s.notFound.Add(1)
+	case errors.Is(err, ErrBusy):
+		s.busy.Add(1)
+	case err != nil:
+		s.failures.Add(1)
+	case msgType == protocol.TO1ProveToRVMsgType:
+		s.redirects.Add(1)
+	}
+}
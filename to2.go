@@ -7,8 +7,6 @@ import (
 	"bytes"
 	"context"
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/hex"
@@ -20,7 +18,7 @@ import (
 	"log/slog"
 	"math"
 	"reflect"
-	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +26,7 @@ import (
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/cbor/cdn"
 	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/internal/smallheap"
 	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/plugin"
 	"github.com/fido-device-onboard/go-fdo/protocol"
@@ -40,6 +39,21 @@ var (
 	to2OwnerPubKeyClaim = cose.Label{Int64: 257}
 )
 
+// ErrTo1dOwnerMismatch is returned by TO2 when the signed rendezvous blob
+// from TO1 does not verify with the owner key proven in TO2.ProveOVHdr and
+// confirmed by the ownership voucher. This means that the device was directed
+// to the owner service by a blob the owner did not sign, i.e. by a rogue
+// rendezvous server or a man in the middle.
+type ErrTo1dOwnerMismatch struct {
+	Err error
+}
+
+func (err ErrTo1dOwnerMismatch) Error() string {
+	return "to1d was not signed by the verified owner: " + err.Err.Error()
+}
+
+func (err ErrTo1dOwnerMismatch) Unwrap() error { return err.Err }
+
 // TO2Config contains the device credential, including secrets and keys,
 // optional configuration, and service info modules.
 type TO2Config struct {
@@ -48,7 +62,7 @@ type TO2Config struct {
 
 	// HMAC-SHA256 with a device secret that does not change when ownership is
 	// transferred. HMAC-SHA256 support is always required by spec, so this
-	// field must be non-nil.
+	// field must be non-nil unless DeviceHmac is set.
 	//
 	// This hash.Hash may optionally implement the following interface to
 	// return errors from Reset/Write/Sum, noting that implementations of
@@ -72,6 +86,11 @@ type TO2Config struct {
 	// 	}
 	HmacSha384 hash.Hash
 
+	// DeviceHmac, if set, computes HMACs with the device secret in place of
+	// HmacSha256 and HmacSha384, which may then be nil. It allows the secret
+	// to be held by an external service, such as an HSM.
+	DeviceHmac DeviceHmac
+
 	// An ECDSA or RSA private key that may or may not be implemented with the
 	// stdlib ecdsa and rsa packages.
 	Key crypto.Signer
@@ -91,11 +110,15 @@ type TO2Config struct {
 	Devmod serviceinfo.Devmod
 
 	// Each ServiceInfo module will be reported in devmod and potentially
-	// activated and used. If a devmod module is included in this list, it
-	// overrides the Devmod field in TO2Config. The custom devmod should not
-	// send nummodules or modules messages, as these will always be sent upon
-	// module completion.
-	DeviceModules map[string]serviceinfo.DeviceModule
+	// activated and used. If a devmod module is included in this registry,
+	// it overrides the Devmod field in TO2Config. The custom devmod should
+	// not send nummodules or modules messages, as these will always be sent
+	// upon module completion.
+	//
+	// Modules registered with the messages they support cause TO2 to fail
+	// with a descriptive error if the owner service sends any other message.
+	// Activation is tracked separately for each TO2 session.
+	DeviceModules *serviceinfo.Registry
 
 	// Selects the key exchange suite to use. If unset, it defaults to ECDH384.
 	KeyExchange kex.Suite
@@ -104,6 +127,11 @@ type TO2Config struct {
 	// A256GCM.
 	CipherSuite kex.CipherSuiteID
 
+	// Optionally generate the ephemeral ECDH key and compute the shared
+	// secret in hardware, such as a TPM or secure element. This is only
+	// supported by the ECDH256 and ECDH384 key exchange suites.
+	KeyProvider kex.KeyProvider
+
 	// Maximum transmission unit (MTU) to tell owner service to send with. If
 	// zero, the default of 1300 will be used. The value chosen can make a
 	// difference for performance when using service info to exchange large
@@ -115,6 +143,77 @@ type TO2Config struct {
 	// enabled, TO2 will fail with CredReuseErrCode (102) if reuse is
 	// attempted by the owner service.
 	AllowCredentialReuse bool
+
+	// Optional callbacks for onboarding progress and errors. OnTO1Complete is
+	// only used by [Client.TO1].
+	Hooks ClientHooks
+
+	// CryptoProfile restricts the device key, key exchange and cipher suites,
+	// and the algorithms of the voucher presented by the owner service. If
+	// nil, DefaultCryptoProfile is used.
+	CryptoProfile *CryptoProfile
+
+	// Clock, if not nil, is used in place of the system clock for the
+	// timestamp of an error message sent to the owner service and, by
+	// [Onboard], of logged attempts. Devices without a real-time clock may
+	// use a TrustedClock which is set by a service info module, such as the
+	// time FSIM, so that later timestamps are correct.
+	Clock Clock
+
+	// OwnerRoots, if not nil, requires the owner public keys of
+	// TO2.ProveOVHdr and TO2.SetupDevice to be X5Chain encoded with a
+	// certificate chain to one of these roots, so that only owners certified
+	// by a trusted CA may onboard the device.
+	OwnerRoots *x509.CertPool
+
+	// Stop TO2 after the owner service responds to ProveDevice, without
+	// replacing the device credential or exchanging service info. This
+	// validates connectivity, the ownership voucher, and owner attestation
+	// without consuming the onboarding. The owner service is sent an error
+	// message so that it discards the session, and TO2 returns a nil device
+	// credential and nil error.
+	VerifyOnly bool
+
+	// ServiceInfoLimits limits the rate and size of service info exchanged
+	// with the owner service.
+	ServiceInfoLimits ServiceInfoLimits
+
+	// Compression algorithms supported for service info. If the owner service
+	// offers any of them, the first one offered is used. See
+	// CompressionModuleName.
+	Compression []ServiceInfoCompression
+
+	// ModuleTimeouts limits the time device modules may take to handle
+	// service info from the owner service.
+	ModuleTimeouts ModuleTimeouts
+
+	// ServiceInfoBuffers is the number of service info messages buffered in
+	// each direction during a round of service info exchange. If the owner
+	// service sends more messages in a round without allowing the device to
+	// respond, TO2 deadlocks. If zero, 1000 is used, or 64 when built for
+	// constrained devices with TinyGo or the smallheap build tag.
+	ServiceInfoBuffers int
+
+	// Rand is the source of randomness for nonces and key exchange
+	// parameters. If nil, crypto/rand.Reader is used.
+	//
+	// A deterministic source should only be used for testing, such as
+	// replaying test vectors. Signatures and message encryption always use
+	// crypto/rand.
+	Rand io.Reader
+}
+
+func (c *TO2Config) serviceInfoBuffers() int {
+	if c.ServiceInfoBuffers > 0 {
+		return c.ServiceInfoBuffers
+	}
+	return smallheap.ServiceInfoBuffers
+}
+
+// deviceHmac returns DeviceHmac, if set, or else a DeviceHmac using the local
+// hashes.
+func (c *TO2Config) deviceHmac() DeviceHmac {
+	return deviceHmacOrLocal(c.DeviceHmac, c.HmacSha256, c.HmacSha384)
 }
 
 // TO2 runs the TO2 protocol and returns a DeviceCredential with replaced GUID,
@@ -127,10 +226,21 @@ type TO2Config struct {
 // It has the side effect of performing service info modules, which may include
 // actions such as downloading files.
 //
-// If the Credential Reuse protocol is allowed and occurs, then the returned
-// device credential will be nil.
+// If the Credential Reuse protocol is allowed and occurs or VerifyOnly is set,
+// then the returned device credential will be nil.
 func TO2(ctx context.Context, transport Transport, to1d *cose.Sign1[protocol.To1d, []byte], c TO2Config) (*DeviceCredential, error) {
 	ctx = contextWithErrMsg(ctx)
+	ctx = contextWithClock(ctx, c.Clock)
+
+	cred, err := to2(ctx, transport, to1d, c)
+	if err != nil {
+		c.Hooks.error(ctx, protocol.TO2Protocol, err)
+		return nil, err
+	}
+	return cred, nil
+}
+
+func to2(ctx context.Context, transport Transport, to1d *cose.Sign1[protocol.To1d, []byte], c TO2Config) (*DeviceCredential, error) {
 
 	// Configure defaults
 	if c.KeyExchange == "" {
@@ -142,8 +252,19 @@ func TO2(ctx context.Context, transport Transport, to1d *cose.Sign1[protocol.To1
 	if c.MaxServiceInfoSizeReceive == 0 {
 		c.MaxServiceInfoSizeReceive = serviceinfo.DefaultMTU
 	}
-	if c.DeviceModules == nil {
-		c.DeviceModules = make(map[string]serviceinfo.DeviceModule)
+	c.DeviceModules = c.DeviceModules.Clone()
+	profile := cryptoProfileOrDefault(c.CryptoProfile)
+	if err := profile.CheckKeyExchange(c.KeyExchange, c.CipherSuite); err != nil {
+		return nil, err
+	}
+	if err := profile.CheckPublicKey(c.Key.Public()); err != nil {
+		return nil, fmt.Errorf("device key: %w", err)
+	}
+	compressor := newServiceInfoCompressor(c.Compression)
+	if compressor != nil {
+		if err := c.DeviceModules.Register(CompressionModuleName, &compressionDeviceModule{compressor: compressor}, serviceinfo.ModuleInfo{}); err != nil {
+			return nil, err
+		}
 	}
 
 	// Mutually attest the device and owner service
@@ -161,6 +282,10 @@ func TO2(ctx context.Context, transport Transport, to1d *cose.Sign1[protocol.To1
 		errorMsg(ctx, transport, err)
 		return nil, err
 	}
+	if c.VerifyOnly {
+		errorMsg(ctx, transport, errors.New("device aborted TO2 after verification"))
+		return nil, nil
+	}
 
 	// Select the appropriate hash algorithm for HMAC and public key hash
 	alg := c.Cred.PublicKeyHash.Algorithm
@@ -201,7 +326,7 @@ func TO2(ctx context.Context, transport Transport, to1d *cose.Sign1[protocol.To1
 	go c.Devmod.Write(ctx, c.DeviceModules, sendMTU, serviceInfoWriter)
 
 	// Loop, sending and receiving service info until done
-	if err := exchangeServiceInfo(ctx, transport, proveDeviceNonce, setupDeviceNonce, sendMTU, serviceInfoReader, sess, &c); err != nil {
+	if err := exchangeServiceInfo(ctx, transport, proveDeviceNonce, setupDeviceNonce, sendMTU, serviceInfoReader, sess, compressor, &c); err != nil {
 		errorMsg(ctx, transport, err)
 		return nil, err
 	}
@@ -235,10 +360,11 @@ func stopPlugins(modules *deviceModuleMap) {
 	pluginStopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	var pluginStopWg sync.WaitGroup
-	for name, mod := range modules.modules {
-		if !modules.active[name] {
+	for _, name := range modules.Names() {
+		if !modules.Active(name) {
 			continue
 		}
+		mod, _, _ := modules.Module(name)
 		if p, ok := mod.(plugin.Module); ok {
 			pluginStopWg.Add(1)
 			pluginGracefulStopCtx, done := context.WithCancel(pluginStopCtx)
@@ -305,8 +431,13 @@ func verifyVoucher(ctx context.Context, transport Transport, to1d *cose.Sign1[pr
 		Entries: entries,
 	}
 
+	if err := cryptoProfileOrDefault(c.CryptoProfile).CheckVoucher(&ov); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return fmt.Errorf("bad ownership voucher from TO2.ProveOVHdr: %w", err)
+	}
+
 	// Verify ownership voucher header
-	if err := ov.VerifyHeader(c.HmacSha256, c.HmacSha384); err != nil {
+	if err := ov.VerifyHeaderHmac(ctx, c.deviceHmac()); err != nil {
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
 		return fmt.Errorf("bad ownership voucher header from TO2.ProveOVHdr: %w", err)
 	}
@@ -349,6 +480,7 @@ func verifyVoucher(ctx context.Context, transport Transport, to1d *cose.Sign1[pr
 	// If no to1d blob was given, then immmediately return. This will be the
 	// case when RV bypass was used.
 	if to1d == nil {
+		c.Hooks.voucherVerified(&ov)
 		return nil
 	}
 
@@ -357,12 +489,13 @@ func verifyVoucher(ctx context.Context, transport Transport, to1d *cose.Sign1[pr
 	// immediately with an error code message.
 	if ok, err := to1d.Verify(expectedOwnerPub, nil, nil); err != nil {
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
-		return fmt.Errorf("error verifying to1d signature: %w", err)
+		return ErrTo1dOwnerMismatch{Err: fmt.Errorf("error verifying to1d signature: %w", err)}
 	} else if !ok {
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
-		return fmt.Errorf("%w: to1d signature verification failed", ErrCryptoVerifyFailed)
+		return ErrTo1dOwnerMismatch{Err: fmt.Errorf("%w: to1d signature verification failed", ErrCryptoVerifyFailed)}
 	}
 
+	c.Hooks.voucherVerified(&ov)
 	return nil
 }
 
@@ -388,7 +521,7 @@ type ovhValidationContext struct {
 func sendHelloDevice(ctx context.Context, transport Transport, c *TO2Config) (protocol.Nonce, *ovhValidationContext, kex.Session, error) {
 	// Generate a new nonce
 	var proveOVNonce protocol.Nonce
-	if _, err := rand.Read(proveOVNonce[:]); err != nil {
+	if _, err := io.ReadFull(randOrDefault(c.Rand), proveOVNonce[:]); err != nil {
 		return protocol.Nonce{}, nil, nil, fmt.Errorf("error generating new nonce for TO2.HelloDevice request: %w", err)
 	}
 
@@ -456,6 +589,10 @@ func sendHelloDevice(ctx context.Context, transport Transport, c *TO2Config) (pr
 		captureErr(ctx, protocol.InvalidMessageErrCode, "")
 		return protocol.Nonce{}, nil, nil, fmt.Errorf("owner pubkey unprotected header from TO2.ProveOVHdr could not be unmarshaled: %w", err)
 	}
+	if err := verifyOwnerKeyChain(&ownerPubKey, c.OwnerRoots); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return protocol.Nonce{}, nil, nil, fmt.Errorf("owner pubkey unprotected header from TO2.ProveOVHdr: %w", err)
+	}
 
 	// Validate response signature and nonce. While the payload signature
 	// verification is performed using the untrusted owner public key from the
@@ -499,6 +636,15 @@ func sendHelloDevice(ctx context.Context, transport Transport, c *TO2Config) (pr
 		return protocol.Nonce{}, nil, nil, fmt.Errorf("nonce unprotected header from TO2.ProveOVHdr could not be unmarshaled: %w", err)
 	}
 
+	sess := c.KeyExchange.New(proveOVHdr.Payload.Val.KeyExchangeA, c.CipherSuite)
+	if c.KeyProvider != nil {
+		providerSess, ok := sess.(interface{ SetKeyProvider(kex.KeyProvider) })
+		if !ok {
+			return protocol.Nonce{}, nil, nil, fmt.Errorf("key exchange %s does not support a key provider", c.KeyExchange)
+		}
+		providerSess.SetKeyProvider(c.KeyProvider)
+	}
+
 	return cuphNonce,
 		&ovhValidationContext{
 			OVH:                 proveOVHdr.Payload.Val.OVH.Val,
@@ -506,7 +652,7 @@ func sendHelloDevice(ctx context.Context, transport Transport, c *TO2Config) (pr
 			NumVoucherEntries:   int(proveOVHdr.Payload.Val.NumOVEntries),
 			PublicKeyToValidate: key,
 		},
-		c.KeyExchange.New(proveOVHdr.Payload.Val.KeyExchangeA, c.CipherSuite),
+		sess,
 		nil
 
 }
@@ -525,26 +671,55 @@ type ovhProof struct {
 // HelloDevice(60) -> ProveOVHdr(61)
 //
 // TODO: Handle MaxDeviceMessageSize
-func (s *TO2Server) proveOVHdr(ctx context.Context, msg io.Reader) (*cose.Sign1Tag[ovhProof, []byte], error) { //nolint:gocyclo
+func (s *TO2Server) proveOVHdr(ctx context.Context, msg io.Reader) (_ *cose.Sign1Tag[ovhProof, []byte], err error) { //nolint:gocyclo
 	// Parse request
 	var rawHello cbor.RawBytes
 	if err := cbor.NewDecoder(msg).Decode(&rawHello); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.HelloDevice request: %w", err)
 	}
 	var hello helloDeviceMsg
 	if err := cbor.Unmarshal(rawHello, &hello); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.HelloDevice request: %w", err)
 	}
+	protocol.TraceGUID(ctx, hello.GUID)
+	var helloDeviceHash protocol.Hash
+	defer func() { s.recordHelloDevice(ctx, hello.GUID, rawHello, helloDeviceHash, err) }()
+
+	// Check algorithms against crypto profile
+	profile := cryptoProfileOrDefault(s.CryptoProfile)
+	if err := profile.CheckSignature(hello.SigInfoA.Type); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return nil, fmt.Errorf("device attestation: %w", err)
+	}
+	if err := profile.CheckKeyExchange(hello.KexSuiteName, hello.CipherSuite); err != nil {
+		captureErr(ctx, protocol.InvalidMessageErrCode, "")
+		return nil, err
+	}
 
 	// Retrieve voucher
 	if err := s.Session.SetGUID(ctx, hello.GUID); err != nil {
 		return nil, fmt.Errorf("error associating device GUID to proof session: %w", err)
 	}
+	if err := s.checkDenied(ctx, hello.GUID); err != nil {
+		return nil, err
+	}
+	if err := s.checkReonboarding(ctx, hello.GUID); err != nil {
+		return nil, err
+	}
+	if err := s.leaseVoucher(ctx, hello.GUID); err != nil {
+		return nil, err
+	}
 	ov, err := s.Vouchers.Voucher(ctx, hello.GUID)
 	if err != nil {
 		captureErr(ctx, protocol.ResourceNotFound, "")
 		return nil, fmt.Errorf("error retrieving voucher for device %x: %w", hello.GUID, err)
 	}
+	if err := profile.CheckVoucher(ov); err != nil {
+		captureErr(ctx, protocol.ResourceNotFound, "")
+		return nil, fmt.Errorf("voucher for device %x: %w", hello.GUID, err)
+	}
 	// It is legal for this tag to have a value of zero (0), but this is
 	// only useful in re-manufacturing situations, since the Rendezvous
 	// Server cannot verify (or accept) these Ownership Proxies.
@@ -555,20 +730,25 @@ func (s *TO2Server) proveOVHdr(ctx context.Context, msg io.Reader) (*cose.Sign1T
 
 	// Assert that owner key matches voucher, in case the key was replaced or
 	// the voucher was not extended before being stored
-	keyType, opts, err := keyTypeFor(hello.SigInfoA.Type)
-	if err != nil {
-		return nil, fmt.Errorf("error getting key type from device sig info: %w", err)
-	}
-	ownerKey, ownerPublicKey, err := s.ownerKey(keyType, ov.Header.Val.ManufacturerKey.Encoding)
+	keyType := voucherOwnerKeyType(ov)
+	ownerKey, ownerPublicKey, err := s.voucherOwnerKey(ctx, ov, keyType)
 	if err != nil {
 		return nil, err
 	}
-	expectedCUPHOwnerKey, err := ov.OwnerPublicKey()
+	opts, err := signOptsFor(ownerKey, keyType == protocol.RsaPssKeyType)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing owner public key from voucher: %w", err)
+		return nil, fmt.Errorf("error determining signing options for TO2.ProveOVHdr message: %w", err)
 	}
-	if !ownerKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(expectedCUPHOwnerKey) {
-		return nil, fmt.Errorf("owner key to be used for CUPHOwnerKey does not match voucher")
+	expectedCUPHOwnerKey := ownerKey.Public()
+
+	// Verify device certificate chain against policy
+	if s.DeviceCertPolicy != nil {
+		result, err := s.DeviceCertPolicy.CheckVoucher(ctx, ov)
+		if err != nil {
+			captureErr(ctx, protocol.ResourceNotFound, "")
+			return nil, err
+		}
+		ctx = contextWithDeviceCertResult(ctx, result)
 	}
 
 	// Verify voucher using custom configuration option.
@@ -583,19 +763,24 @@ func (s *TO2Server) proveOVHdr(ctx context.Context, msg io.Reader) (*cose.Sign1T
 	}
 
 	// Hash request
-	helloDeviceHash := protocol.Hash{Algorithm: ov.Header.Val.CertChainHash.Algorithm}
-	helloDeviceHasher := helloDeviceHash.Algorithm.HashFunc().New()
+	helloDeviceHasher := ov.Header.Val.CertChainHash.Algorithm.HashFunc().New()
 	_, _ = helloDeviceHasher.Write(rawHello)
-	helloDeviceHash.Value = helloDeviceHasher.Sum(nil)
+	helloDeviceHash = protocol.Hash{
+		Algorithm: ov.Header.Val.CertChainHash.Algorithm,
+		Value:     helloDeviceHasher.Sum(nil),
+	}
 
 	// Generate nonce for ProveDevice
 	var proveDeviceNonce protocol.Nonce
-	if _, err := rand.Read(proveDeviceNonce[:]); err != nil {
+	if _, err := io.ReadFull(randOrDefault(s.Rand), proveDeviceNonce[:]); err != nil {
 		return nil, fmt.Errorf("error generating new nonce for TO2.ProveOVHdr response: %w", err)
 	}
 	if err := s.Session.SetProveDeviceNonce(ctx, proveDeviceNonce); err != nil {
 		return nil, fmt.Errorf("error storing nonce for later use in TO2.Done: %w", err)
 	}
+	if err := addNonce(ctx, s.Nonces, s.NonceTTL, proveDeviceNonce); err != nil {
+		return nil, fmt.Errorf("error storing nonce for TO2.ProveDevice: %w", err)
+	}
 
 	// Begin key exchange
 	if !hello.KexSuiteName.Valid(hello.SigInfoA.Type, expectedCUPHOwnerKey) {
@@ -609,7 +794,7 @@ func (s *TO2Server) proveOVHdr(ctx context.Context, msg io.Reader) (*cose.Sign1T
 	}
 	sess := hello.KexSuiteName.New(nil, hello.CipherSuite)
 	rsaOwnerPublicKey, _ := expectedCUPHOwnerKey.(*rsa.PublicKey)
-	xA, err := sess.Parameter(rand.Reader, rsaOwnerPublicKey)
+	xA, err := sess.Parameter(randOrDefault(s.Rand), rsaOwnerPublicKey)
 	if err != nil {
 		return nil, fmt.Errorf("error generating client key exchange parameter: %w", err)
 	}
@@ -646,15 +831,14 @@ func (s *TO2Server) proveOVHdr(ctx context.Context, msg io.Reader) (*cose.Sign1T
 		return nil, fmt.Errorf("error signing TO2.ProveOVHdr payload: %w", err)
 	}
 
-	// The lifetime of xA is until the transport has marshaled and sent the proof. Therefore, the
-	// best option for clearing the secret is to set a finalizer (unfortunately).
-	proof := s1.Tag()
-	runtime.SetFinalizer(proof, func(proof *cose.Sign1Tag[ovhProof, []byte]) {
-		clear(proof.Payload.Val.KeyExchangeA)
-	})
-	return proof, nil
+	// xA is sent in the clear, so it is not cleared after sending. Clearing
+	// it with a finalizer on the proof is unsafe, because encoding copies the
+	// proof, which may then be finalized while its payload is being encoded.
+	return s1.Tag(), nil
 }
 
+// ownerKey returns the current owner key of a type, which new vouchers are
+// extended to.
 func (s *TO2Server) ownerKey(keyType protocol.KeyType, keyEncoding protocol.KeyEncoding) (crypto.Signer, *protocol.PublicKey, error) {
 	key, chain, err := s.OwnerKeys.OwnerKey(keyType)
 	if errors.Is(err, ErrNotFound) {
@@ -662,7 +846,36 @@ func (s *TO2Server) ownerKey(keyType protocol.KeyType, keyEncoding protocol.KeyE
 	} else if err != nil {
 		return nil, nil, fmt.Errorf("error getting owner key [type=%s]: %w", keyType, err)
 	}
+	return ownerPublicKey(key, chain, keyType, keyEncoding)
+}
+
+// voucherOwnerKey returns the owner key of a type which owns a voucher. It
+// may be a previous owner key.
+func (s *TO2Server) voucherOwnerKey(ctx context.Context, ov *Voucher, keyType protocol.KeyType) (crypto.Signer, *protocol.PublicKey, error) {
+	expected, err := ov.OwnerPublicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing owner public key from voucher: %w", err)
+	}
+	key, chain, err := OwnerKeyFor(ctx, s.OwnerKeys, keyType, expected)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, fmt.Errorf("owner key to be used for CUPHOwnerKey does not match voucher")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return ownerPublicKey(key, chain, keyType, s.ownerKeyEncoding(ov))
+}
+
+// ownerKeyEncoding returns the encoding of owner public keys sent for a
+// voucher.
+func (s *TO2Server) ownerKeyEncoding(ov *Voucher) protocol.KeyEncoding {
+	if s.X5ChainOwnerKey {
+		return protocol.X5ChainKeyEnc
+	}
+	return ov.Header.Val.ManufacturerKey.Encoding
+}
 
+// ownerPublicKey encodes the public key of an owner key.
+func ownerPublicKey(key crypto.Signer, chain []*x509.Certificate, keyType protocol.KeyType, keyEncoding protocol.KeyEncoding) (crypto.Signer, *protocol.PublicKey, error) {
 	// Default to X509 key encoding if owner key does not have a certificate
 	// chain
 	if keyEncoding == protocol.X5ChainKeyEnc && len(chain) == 0 {
@@ -670,22 +883,11 @@ func (s *TO2Server) ownerKey(keyType protocol.KeyType, keyEncoding protocol.KeyE
 	}
 
 	var pubkey *protocol.PublicKey
-	switch keyEncoding {
-	case protocol.X509KeyEnc, protocol.CoseKeyEnc:
-		switch keyType {
-		case protocol.Secp256r1KeyType, protocol.Secp384r1KeyType:
-			pubkey, err = protocol.NewPublicKey(keyType, key.Public().(*ecdsa.PublicKey), keyEncoding == protocol.CoseKeyEnc)
-		case protocol.Rsa2048RestrKeyType, protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
-			pubkey, err = protocol.NewPublicKey(keyType, key.Public().(*rsa.PublicKey), keyEncoding == protocol.CoseKeyEnc)
-		default:
-			return nil, nil, fmt.Errorf("unsupported key type: %s", keyType)
-		}
-
-	case protocol.X5ChainKeyEnc:
-		pubkey, err = protocol.NewPublicKey(keyType, chain, false)
-
-	default:
-		return nil, nil, fmt.Errorf("unsupported key encoding: %s", keyEncoding)
+	var err error
+	if keyEncoding == protocol.X5ChainKeyEnc {
+		pubkey, err = protocol.PublicKeyFromChain(keyType, keyEncoding, chain)
+	} else {
+		pubkey, err = protocol.PublicKeyFromCrypto(keyType, keyEncoding, key.Public())
 	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("error with owner public key: %w", err)
@@ -749,6 +951,7 @@ func (s *TO2Server) ovNextEntry(ctx context.Context, msg io.Reader) (*ovEntry, e
 		OVEntryNum int
 	}
 	if err := cbor.NewDecoder(msg).Decode(&nextEntry); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.GetOVNextEntry request: %w", err)
 	}
 
@@ -757,6 +960,9 @@ func (s *TO2Server) ovNextEntry(ctx context.Context, msg io.Reader) (*ovEntry, e
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving associated device GUID of proof session: %w", err)
 	}
+	if err := s.leaseVoucher(ctx, guid); err != nil {
+		return nil, err
+	}
 	ov, err := s.Vouchers.Voucher(ctx, guid)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving voucher for device %x: %w", guid, err)
@@ -776,13 +982,13 @@ func (s *TO2Server) ovNextEntry(ctx context.Context, msg io.Reader) (*ovEntry, e
 func proveDevice(ctx context.Context, transport Transport, proveDeviceNonce protocol.Nonce, ownerPublicKey crypto.PublicKey, sess kex.Session, c *TO2Config) (protocol.Nonce, *VoucherHeader, error) {
 	// Generate a new nonce
 	var setupDeviceNonce protocol.Nonce
-	if _, err := rand.Read(setupDeviceNonce[:]); err != nil {
+	if _, err := io.ReadFull(randOrDefault(c.Rand), setupDeviceNonce[:]); err != nil {
 		return protocol.Nonce{}, nil, fmt.Errorf("error generating new nonce for TO2.ProveDevice request: %w", err)
 	}
 
 	// Define request structure
 	rsaOwnerPublicKey, _ := ownerPublicKey.(*rsa.PublicKey)
-	xB, err := sess.Parameter(rand.Reader, rsaOwnerPublicKey)
+	xB, err := sess.Parameter(randOrDefault(c.Rand), rsaOwnerPublicKey)
 	if err != nil {
 		return protocol.Nonce{}, nil, fmt.Errorf("error generating key exchange session parameters: %w", err)
 	}
@@ -828,6 +1034,10 @@ func proveDevice(ctx context.Context, transport Transport, proveDeviceNonce prot
 			captureErr(ctx, protocol.InvalidMessageErrCode, "")
 			return protocol.Nonce{}, nil, fmt.Errorf("nonce in TO2.SetupDevice did not match nonce sent in TO2.ProveDevice")
 		}
+		if err := verifyOwnerKeyChain(&setupDevice.Payload.Val.Owner2Key, c.OwnerRoots); err != nil {
+			captureErr(ctx, protocol.InvalidMessageErrCode, "")
+			return protocol.Nonce{}, nil, fmt.Errorf("replacement owner key in TO2.SetupDevice: %w", err)
+		}
 		replacementOVH := &VoucherHeader{
 			GUID:            setupDevice.Payload.Val.GUID,
 			RvInfo:          setupDevice.Payload.Val.RendezvousInfo,
@@ -885,10 +1095,12 @@ func (s *TO2Server) setupDevice(ctx context.Context, msg io.Reader) (*cose.Sign1
 	// encoding of CBOR (even though FDO requires this).
 	var proof cose.Sign1Tag[cbor.RawBytes, []byte]
 	if err := cbor.NewDecoder(msg).Decode(&proof); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.ProveDevice request: %w", err)
 	}
-	var eat eatoken
-	if err := cbor.Unmarshal([]byte(proof.Payload.Val), &eat); err != nil {
+	eat, err := ParseEAT([]byte(proof.Payload.Val))
+	if err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.ProveDevice request: %w", err)
 	}
 
@@ -908,6 +1120,9 @@ func (s *TO2Server) setupDevice(ctx context.Context, msg io.Reader) (*cose.Sign1
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving associated device GUID of proof session: %w", err)
 	}
+	if err := s.leaseVoucher(ctx, guid); err != nil {
+		return nil, err
+	}
 	ov, err := s.Vouchers.Voucher(ctx, guid)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving voucher for device %x: %w", guid, err)
@@ -929,27 +1144,21 @@ func (s *TO2Server) setupDevice(ctx context.Context, msg io.Reader) (*cose.Sign1
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving ProveDevice nonce for session: %w", err)
 	}
-	nonceClaim, ok := eat[eatNonceClaim].([]byte)
-	if !ok {
-		return nil, fmt.Errorf("missing nonce claim from EAT")
-	}
-	if !bytes.Equal(nonceClaim, proveDeviceNonce[:]) {
+	if eat.Nonce != proveDeviceNonce {
 		return nil, fmt.Errorf("nonce claim from EAT does not match ProveDevice nonce")
 	}
-	ueidClaim, ok := eat[eatUeidClaim].([]byte)
-	if !ok {
-		return nil, fmt.Errorf("missing UEID claim from EAT")
+	if err := consumeNonce(ctx, s.Nonces, eat.Nonce); err != nil {
+		return nil, fmt.Errorf("nonce claim from EAT: %w", err)
 	}
-	if !bytes.Equal(ueidClaim, append([]byte{eatRandUeid}, guid[:]...)) {
+	if eat.GUID != guid {
 		return nil, fmt.Errorf("claim of UEID in EAT does not match the device GUID")
 	}
-	fdoClaim, ok := eat[eatFdoClaim].([]any)
-	if !ok || len(fdoClaim) != 1 {
+	if len(eat.FDO) != 1 {
 		return nil, fmt.Errorf("missing FDO claim from EAT")
 	}
 
 	// Complete key exchange using EAT FDO claim
-	xB, ok := fdoClaim[0].([]byte)
+	xB, ok := eat.FDO[0].([]byte)
 	if !ok {
 		return nil, fmt.Errorf("invalid EAT FDO claim: expected one item of type []byte")
 	}
@@ -959,7 +1168,7 @@ func (s *TO2Server) setupDevice(ctx context.Context, msg io.Reader) (*cose.Sign1
 	}
 	defer sess.Destroy()
 	keyType := ov.Header.Val.ManufacturerKey.Type
-	ownerKey, ownerPublicKey, err := s.ownerKey(keyType, ov.Header.Val.ManufacturerKey.Encoding)
+	ownerKey, ownerPublicKey, err := s.voucherOwnerKey(ctx, ov, keyType)
 	if err != nil {
 		return nil, err
 	}
@@ -978,7 +1187,12 @@ func (s *TO2Server) setupDevice(ctx context.Context, msg io.Reader) (*cose.Sign1
 		replacementGUID = ov.Header.Val.GUID
 		replacementRvInfo = ov.Header.Val.RvInfo
 	} else {
-		if _, err := rand.Read(replacementGUID[:]); err != nil {
+		// The replacement voucher is owned by the current owner key, which
+		// may differ from the key owning the voucher if it was rotated
+		if ownerKey, ownerPublicKey, err = s.ownerKey(keyType, s.ownerKeyEncoding(ov)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(randOrDefault(s.Rand), replacementGUID[:]); err != nil {
 			return nil, fmt.Errorf("error generating replacement GUID for device: %w", err)
 		}
 		if err := s.Session.SetReplacementGUID(ctx, replacementGUID); err != nil {
@@ -1019,18 +1233,18 @@ type deviceServiceInfoReady struct {
 // DeviceServiceInfoReady(66) -> OwnerServiceInfoReady(67)
 func sendReadyServiceInfo(ctx context.Context, transport Transport, alg protocol.HashAlg, replacementOVH *VoucherHeader, sess kex.Session, c *TO2Config) (maxDeviceServiceInfoSiz uint16, err error) {
 	// Calculate the new OVH HMac similar to DI.SetHMAC
-	var h hash.Hash
+	var hmacAlg protocol.HashAlg
 	switch alg {
 	case protocol.Sha256Hash, protocol.HmacSha256Hash:
-		h = c.HmacSha256
+		hmacAlg = protocol.HmacSha256Hash
 	case protocol.Sha384Hash, protocol.HmacSha384Hash:
-		h = c.HmacSha384
+		hmacAlg = protocol.HmacSha384Hash
 	default:
 		panic("only SHA256 and SHA384 are supported in FDO")
 	}
 	var hmac *protocol.Hash
 	if replacementOVH != nil {
-		replacementHmac, err := hmacHash(h, replacementOVH)
+		replacementHmac, err := hmacHash(ctx, c.deviceHmac(), hmacAlg, replacementOVH)
 		if err != nil {
 			return 0, fmt.Errorf("error computing HMAC of ownership voucher header: %w", err)
 		}
@@ -1086,6 +1300,7 @@ func (s *TO2Server) ownerServiceInfoReady(ctx context.Context, msg io.Reader) (*
 	// Parse request
 	var deviceReady deviceServiceInfoReady
 	if err := cbor.NewDecoder(msg).Decode(&deviceReady); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.DeviceServiceInfoReady request: %w", err)
 	}
 
@@ -1118,6 +1333,7 @@ func (s *TO2Server) ownerServiceInfoReady(ctx context.Context, msg io.Reader) (*
 
 	// If not using the Credential Reuse Protocol (i.e. device sends an HMAC),
 	// then store the HMAC and get the replacement GUID
+	currentGUID := guid
 	if deviceReady.Hmac != nil {
 		if err := s.Session.SetReplacementHmac(ctx, *deviceReady.Hmac); err != nil {
 			return nil, fmt.Errorf("error storing replacement voucher HMAC for device: %w", err)
@@ -1127,29 +1343,55 @@ func (s *TO2Server) ownerServiceInfoReady(ctx context.Context, msg io.Reader) (*
 		}
 	}
 
+	s.beginServiceInfo(ctx)
+	compressor := newServiceInfoCompressor(s.Compression)
+	modules := &moduleSession{
+		plugins:      make(map[string]plugin.Module),
+		compressor:   compressor,
+		moduleStarts: make(moduleStartTimes),
+		interleave:   s.InterleaveModules,
+	}
+
 	// Initialize service info modules
-	s.plugins = make(map[string]plugin.Module)
-	s.nextModule, s.stop = iter.Pull2(func() iter.Seq2[string, serviceinfo.OwnerModule] {
+	var pull func() (string, serviceinfo.OwnerModule, bool)
+	pull, modules.stop = iter.Pull2(func() iter.Seq2[string, serviceinfo.OwnerModule] {
 		var devmod devmodOwnerModule
 		var ownerModules iter.Seq2[string, serviceinfo.OwnerModule]
+		occurrences := make(map[string]int)
 
 		return func(yield func(string, serviceinfo.OwnerModule) bool) {
 			if ownerModules == nil {
 				if !yield("devmod", &devmod) {
 					return
 				}
-				ownerModules = s.OwnerModules(ctx, guid, info, deviceCertChain, devmod.Devmod, devmod.Modules)
+				if compressor != nil && slices.Contains(devmod.Modules, CompressionModuleName) {
+					if !yield(CompressionModuleName, &compressionOwnerModule{compressor: compressor}) {
+						return
+					}
+				}
+				ownerModules = s.ownerModules(ctx, ServiceInfoDevice{
+					GUID:            currentGUID,
+					ReplacementGUID: guid,
+					Info:            info,
+					Chain:           deviceCertChain,
+					Devmod:          devmod.Devmod,
+					Modules:         devmod.Modules,
+				})
 			}
 
 			ownerModules(func(moduleName string, mod serviceinfo.OwnerModule) bool {
+				key := moduleStateKey(moduleName, occurrences[moduleName])
+				occurrences[moduleName]++
 				if p, ok := mod.(plugin.Module); ok {
 					// Collect plugins before yielding the module
-					s.plugins[moduleName] = p
+					modules.plugins[key] = p
 				}
-				return yield(moduleName, mod)
+				return yield(moduleName, s.resumeModule(ctx, currentGUID, key, mod))
 			})
 		}
 	}())
+	modules.trackModules(pull)
+	s.startModules(currentGUID, modules)
 
 	// Send response
 	ownerReady := new(ownerServiceInfoReady)
@@ -1174,6 +1416,7 @@ func exchangeServiceInfo(ctx context.Context,
 	mtu uint16,
 	initInfo *serviceinfo.ChunkReader,
 	sess kex.Session,
+	compressor *serviceInfoCompressor,
 	c *TO2Config,
 ) error {
 	// Shadow context to ensure that any goroutines still running after this
@@ -1186,13 +1429,20 @@ func exchangeServiceInfo(ctx context.Context,
 	// item indicating "IsMoreServiceInfo"
 	mtu -= 5
 
-	// 1000 service info buffered in and out means up to ~1MB of data for
-	// the default MTU. If both queues fill, the device will deadlock. This
-	// should only happen for a poorly behaved owner service.
-	ownerInfo, ownerInfoIn := serviceinfo.NewChunkInPipe(1000)
+	// 1000 service info buffered in and out (the default) means up to ~1MB
+	// of data for the default MTU. If both queues fill, the device will
+	// deadlock. This should only happen for a poorly behaved owner service.
+	buffers := c.serviceInfoBuffers()
+	ownerInfo, ownerInfoIn := serviceinfo.NewChunkInPipe(buffers)
+
+	// Report progress of service info per module
+	progress := newServiceInfoProgress(c.Hooks)
+
+	// Enforce rate limits and quotas of service info
+	limiter := &deviceServiceInfoLimiter{limits: c.ServiceInfoLimits}
 
 	// Send initial device info (devmod)
-	totalRounds, done, err := exchangeServiceInfoRound(ctx, transport, mtu, initInfo, ownerInfoIn, sess)
+	totalRounds, done, err := exchangeServiceInfoRound(ctx, transport, mtu, initInfo, ownerInfoIn, sess, progress, limiter, compressor)
 	_ = initInfo.Close()
 	if err != nil {
 		return fmt.Errorf("error sending devmod: %w", err)
@@ -1208,7 +1458,13 @@ func exchangeServiceInfo(ctx context.Context,
 	}
 
 	// Track active modules
-	modules := deviceModuleMap{modules: c.DeviceModules, active: make(map[string]bool)}
+	modules := deviceModuleMap{
+		Registry: c.DeviceModules,
+		timeouts: c.ModuleTimeouts,
+		started:  make(moduleStartTimes),
+		failed:   make(map[string]bool),
+		hooks:    c.Hooks,
+	}
 	defer stopPlugins(&modules)
 
 	var prevModuleName string
@@ -1217,10 +1473,9 @@ func exchangeServiceInfo(ctx context.Context,
 		// info to send. Each service info grouping is automatically chunked
 		// and if it exceeds the MTU will have IsMoreServiceInfo=true.
 		//
-		// 1000 service info buffered in and out means up to ~1MB of data for
-		// the default MTU. If both queues fill, the device will deadlock. This
-		// should only happen for a poorly behaved owner module.
-		deviceInfo, deviceInfoIn := serviceinfo.NewChunkOutPipe(1000)
+		// If both queues fill, the device will deadlock. This should only
+		// happen for a poorly behaved owner module.
+		deviceInfo, deviceInfoIn := serviceinfo.NewChunkOutPipe(buffers)
 		ctxWithMTU := context.WithValue(ctx, serviceinfo.MTUKey{}, mtu)
 		// Track the owner module in use so that if the next round has no data
 		// exchanged, we can still yield to the appropriate device module.
@@ -1233,11 +1488,11 @@ func exchangeServiceInfo(ctx context.Context,
 		}()
 
 		// Send all device service info and receive all owner service info into
-		// a buffered pipe. Note that if >1000 service info are received from
-		// the owner service without it allowing the device to respond, the
-		// device will deadlock.
-		nextOwnerInfo, ownerInfoIn := serviceinfo.NewChunkInPipe(1000)
-		rounds, done, err := exchangeServiceInfoRound(ctx, transport, mtu, deviceInfo, ownerInfoIn, sess)
+		// a buffered pipe. Note that if more service info than buffered are
+		// received from the owner service without it allowing the device to
+		// respond, the device will deadlock.
+		nextOwnerInfo, ownerInfoIn := serviceinfo.NewChunkInPipe(buffers)
+		rounds, done, err := exchangeServiceInfoRound(ctx, transport, mtu, deviceInfo, ownerInfoIn, sess, progress, limiter, compressor)
 		if err != nil {
 			_ = ownerInfoIn.CloseWithError(err)
 			return err
@@ -1253,7 +1508,7 @@ func exchangeServiceInfo(ctx context.Context,
 		}
 		if done {
 			// Process final service info from message with IsDone
-			deviceInfo, discard := serviceinfo.NewChunkOutPipe(1000)
+			deviceInfo, discard := serviceinfo.NewChunkOutPipe(buffers)
 			go discardDeviceInfo(deviceInfo)
 			ctxWithMTU := context.WithValue(ctx, serviceinfo.MTUKey{}, mtu)
 			_ = handleOwnerModuleMessages(ctxWithMTU, prevModuleName, modules, nextOwnerInfo, discard)
@@ -1288,13 +1543,16 @@ func discardDeviceInfo(deviceInfo *serviceinfo.ChunkReader) {
 		if err != nil {
 			return
 		}
-		prettyValue, err := cdn.FromCBOR(kv.Val)
-		if err != nil {
-			prettyValue = "h'" + hex.EncodeToString(kv.Val) + "'"
+		prettyValue := "h'" + hex.EncodeToString(kv.Val) + "'"
+		if !smallheap.Enabled {
+			if value, err := cdn.FromCBOR(kv.Val); err == nil {
+				prettyValue = value
+			}
 		}
 		slog.Warn("discarding device service info message because owner sent IsDone",
 			"name", kv.Key, "value", prettyValue,
 		)
+		kv.Release()
 	}
 }
 
@@ -1367,10 +1625,13 @@ func (info ownerServiceInfo) String() string {
 // TODO: Track current round number and stop at 1e6 rather than only checking
 // if exceeded after this recursive function completes.
 func exchangeServiceInfoRound(ctx context.Context, transport Transport, mtu uint16,
-	r *serviceinfo.ChunkReader, w *serviceinfo.ChunkWriter, sess kex.Session,
+	r *serviceinfo.ChunkReader, w *serviceinfo.ChunkWriter, sess kex.Session, progress *serviceInfoProgress,
+	limiter *deviceServiceInfoLimiter, compressor *serviceInfoCompressor,
 ) (int, bool, error) {
-	// Create DeviceServiceInfo request structure
+	// Create DeviceServiceInfo request structure, compressing each chunk if
+	// negotiated
 	var msg deviceServiceInfo
+	var sent []*serviceinfo.KV
 	maxRead := mtu
 	for {
 		chunk, err := r.ReadChunk(maxRead)
@@ -1387,18 +1648,38 @@ func exchangeServiceInfoRound(ctx context.Context, transport Transport, mtu uint
 		if err != nil {
 			return 0, false, fmt.Errorf("error reading KV to send to owner: %w", err)
 		}
+		sent = append(sent, chunk)
+		if chunk, err = compressor.compress(chunk); err != nil {
+			return 0, false, err
+		}
 		maxRead -= chunk.Size()
 		msg.ServiceInfo = append(msg.ServiceInfo, chunk)
 	}
 
 	// Send request
+	if err := limiter.send(ctx, msg.ServiceInfo); err != nil {
+		return 0, false, err
+	}
 	ownerServiceInfo, err := sendDeviceServiceInfo(ctx, transport, msg, sess)
 	if err != nil {
 		return 0, false, err
 	}
+	if err := limiter.receive(ctx, ownerServiceInfo.ServiceInfo); err != nil {
+		return 0, false, err
+	}
+	received, err := compressor.decompressAll(ownerServiceInfo.ServiceInfo)
+	if err != nil {
+		return 0, false, err
+	}
+	progress.exchanged(sent, received)
+
+	// Sent chunks are no longer used, so their buffers can be reused
+	for _, kv := range sent {
+		kv.Release()
+	}
 
 	// Receive all owner service info
-	for _, kv := range ownerServiceInfo.ServiceInfo {
+	for _, kv := range received {
 		if err := w.WriteChunk(kv); err != nil {
 			return 0, false, fmt.Errorf("error piping owner service info to device module: %w", err)
 		}
@@ -1407,7 +1688,7 @@ func exchangeServiceInfoRound(ctx context.Context, transport Transport, mtu uint
 	// Recurse when there's more service info to send from device or receive
 	// from owner without allowing the other side to respond
 	if msg.IsMoreServiceInfo || ownerServiceInfo.IsMoreServiceInfo {
-		rounds, done, err := exchangeServiceInfoRound(ctx, transport, mtu, r, w, sess)
+		rounds, done, err := exchangeServiceInfoRound(ctx, transport, mtu, r, w, sess, progress, limiter, compressor)
 		return rounds + 1, done, err
 	}
 
@@ -1432,6 +1713,10 @@ func sendDeviceServiceInfo(ctx context.Context, transport Transport, msg deviceS
 			captureErr(ctx, protocol.MessageBodyErrCode, "")
 			return nil, fmt.Errorf("error parsing TO2.OwnerServiceInfo contents: %w", err)
 		}
+		if slices.Contains(ownerServiceInfo.ServiceInfo, nil) {
+			captureErr(ctx, protocol.MessageBodyErrCode, "")
+			return nil, fmt.Errorf("error parsing TO2.OwnerServiceInfo contents: service info must not be null")
+		}
 		return &ownerServiceInfo, nil
 
 	case protocol.ErrorMsgType:
@@ -1452,11 +1737,24 @@ func (s *TO2Server) ownerServiceInfo(ctx context.Context, msg io.Reader) (*owner
 	// Parse request
 	var deviceInfo deviceServiceInfo
 	if err := cbor.NewDecoder(msg).Decode(&deviceInfo); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.DeviceServiceInfo request: %w", err)
 	}
+	if slices.Contains(deviceInfo.ServiceInfo, nil) {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
+		return nil, fmt.Errorf("error decoding TO2.DeviceServiceInfo request: service info must not be null")
+	}
+	if err := s.limitDeviceServiceInfo(ctx, deviceInfo.ServiceInfo); err != nil {
+		return nil, err
+	}
 
 	// Get next owner service info module
-	moduleName, mod, ok := s.nextModule()
+	modules, err := s.moduleSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseModules(modules)
+	mod, ok := modules.nextModule()
 	if !ok {
 		return &ownerServiceInfo{
 			IsMoreServiceInfo: false,
@@ -1466,8 +1764,12 @@ func (s *TO2Server) ownerServiceInfo(ctx context.Context, msg io.Reader) (*owner
 	}
 
 	// Handle data with owner module
-	unchunked, unchunker := serviceinfo.NewChunkInPipe(len(deviceInfo.ServiceInfo))
-	for _, kv := range deviceInfo.ServiceInfo {
+	received, err := modules.compressor.decompressAll(deviceInfo.ServiceInfo)
+	if err != nil {
+		return nil, err
+	}
+	unchunked, unchunker := serviceinfo.NewChunkInPipe(len(received))
+	for _, kv := range received {
 		if err := unchunker.WriteChunk(kv); err != nil {
 			return nil, fmt.Errorf("error unchunking received device service info: write: %w", err)
 		}
@@ -1481,7 +1783,19 @@ func (s *TO2Server) ownerServiceInfo(ctx context.Context, msg io.Reader) (*owner
 			break
 		}
 		moduleName, messageName, _ := strings.Cut(key, ":")
-		if err := mod.HandleInfo(ctx, messageName, messageBody); err != nil {
+		receiver, err := modules.receivingModule(moduleName, mod)
+		if err != nil {
+			return nil, err
+		}
+		impl := receiver.OwnerModule
+		err = s.ModuleTimeouts.call(ctx, modules.moduleStarts, receiver.key, func(ctx context.Context, guard *moduleGuard) error {
+			return impl.HandleInfo(ctx, messageName, guard.reader(messageBody))
+		})
+		if ownerRecoverable(err) {
+			// Fail only the module and continue with the others
+			modules.failModule(ctx, receiver, err)
+			_, _ = io.Copy(io.Discard, messageBody)
+		} else if err != nil {
 			return nil, fmt.Errorf("error handling device service info %q: %w", key, err)
 		}
 		if n, err := io.Copy(io.Discard, messageBody); err != nil {
@@ -1497,7 +1811,7 @@ func (s *TO2Server) ownerServiceInfo(ctx context.Context, msg io.Reader) (*owner
 	}
 
 	if deviceInfo.IsMoreServiceInfo {
-		s.continueWithModule(moduleName, mod)
+		modules.continueWithModule(mod)
 
 		return &ownerServiceInfo{
 			IsMoreServiceInfo: false,
@@ -1506,45 +1820,72 @@ func (s *TO2Server) ownerServiceInfo(ctx context.Context, msg io.Reader) (*owner
 		}, nil
 	}
 
-	return s.produceOwnerServiceInfo(ctx, moduleName, mod)
+	return s.produceOwnerServiceInfo(ctx, modules, mod)
 }
 
 // Override nextModule so that the same module is used in the next round
-func (s *TO2Server) continueWithModule(moduleName string, mod serviceinfo.OwnerModule) {
-	nextModule := s.nextModule
-	s.nextModule = func() (string, serviceinfo.OwnerModule, bool) {
-		s.nextModule = nextModule
-		return moduleName, mod, true
+func (m *moduleSession) continueWithModule(mod *ownerModule) {
+	nextModule := m.nextModule
+	m.nextModule = func() (*ownerModule, bool) {
+		m.nextModule = nextModule
+		return mod, true
 	}
 }
 
 // Allow owner module to produce data
-func (s *TO2Server) produceOwnerServiceInfo(ctx context.Context, moduleName string, mod serviceinfo.OwnerModule) (*ownerServiceInfo, error) {
+func (s *TO2Server) produceOwnerServiceInfo(ctx context.Context, modules *moduleSession, mod *ownerModule) (*ownerServiceInfo, error) {
 	mtu, err := s.Session.MTU(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error getting max device service info size: %w", err)
 	}
 
-	producer := serviceinfo.NewProducer(moduleName, mtu)
-	explicitBlock, isComplete, err := mod.ProduceInfo(ctx, producer)
-	if err != nil {
+	// The module and producer are copied for the call, so that if the module
+	// times out, the session replaces them without racing the abandoned call
+	modules.startModule(mod)
+	producer := serviceinfo.NewProducer(mod.name, mtu)
+	impl, modProducer := mod.OwnerModule, producer
+	var explicitBlock, isComplete bool
+	err = s.ModuleTimeouts.call(ctx, modules.moduleStarts, mod.key, func(ctx context.Context, guard *moduleGuard) error {
+		block, complete, err := impl.ProduceInfo(ctx, modProducer)
+		_ = guard.do(func() error {
+			explicitBlock, isComplete = block, complete
+			return nil
+		})
+		return err
+	})
+	if ownerRecoverable(err) {
+		// Discard any partial service info of the failed module
+		modules.failModule(ctx, mod, err)
+		producer = serviceinfo.NewProducer(mod.name, mtu)
+		explicitBlock, isComplete = false, true
+	} else if err != nil {
 		return nil, fmt.Errorf("error producing owner service info from module: %w", err)
 	}
 
 	if size := serviceinfo.ArraySizeCBOR(producer.ServiceInfo()); size > int64(mtu) {
 		return nil, fmt.Errorf("owner service info module produced service info exceeding the MTU=%d - 3 (message overhead), size=%d", mtu, size)
 	}
+	info, err := modules.compressor.compressAll(producer.ServiceInfo())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.limitOwnerServiceInfo(ctx, info); err != nil {
+		return nil, err
+	}
+	if err := s.checkpointModule(ctx, mod.OwnerModule); err != nil {
+		return nil, err
+	}
 
-	// If module is not yet complete, override nextModule to return it again
+	// If module is not yet complete, schedule it to produce again
 	if !isComplete {
-		s.continueWithModule(moduleName, mod)
+		modules.requeueModule(mod, explicitBlock)
 	}
 
 	// Return chunked data
 	return &ownerServiceInfo{
 		IsMoreServiceInfo: explicitBlock,
 		IsDone:            false,
-		ServiceInfo:       producer.ServiceInfo(),
+		ServiceInfo:       info,
 	}, nil
 }
 
@@ -1553,6 +1894,7 @@ func (s *TO2Server) to2Done2(ctx context.Context, msg io.Reader) (*done2Msg, err
 	// Parse request
 	var done doneMsg
 	if err := cbor.NewDecoder(msg).Decode(&done); err != nil {
+		captureErr(ctx, protocol.MessageBodyErrCode, "")
 		return nil, fmt.Errorf("error decoding TO2.Done request: %w", err)
 	}
 
@@ -1571,6 +1913,13 @@ func (s *TO2Server) to2Done2(ctx context.Context, msg io.Reader) (*done2Msg, err
 		return nil, fmt.Errorf("nonce from TO2.ProveDevice did not match TO2.Done")
 	}
 
+	// Service info is complete, so owner module progress is no longer needed
+	currentGUID, err := s.Session.GUID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving associated device GUID of proof session: %w", err)
+	}
+	s.removeModuleStates(ctx, currentGUID)
+
 	// If the Credential Reuse Protocol is being used (replacement HMAC is not
 	// found), then immediately complete TO2 without replacing the voucher.
 	replacementHmac, err := s.Session.ReplacementHmac(ctx)
@@ -1581,10 +1930,6 @@ func (s *TO2Server) to2Done2(ctx context.Context, msg io.Reader) (*done2Msg, err
 	}
 
 	// Get current and replacement voucher values
-	currentGUID, err := s.Session.GUID(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error retrieving associated device GUID of proof session: %w", err)
-	}
 	currentOV, err := s.Vouchers.Voucher(ctx, currentGUID)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving voucher for device %x: %w", currentGUID, err)
@@ -1600,8 +1945,7 @@ func (s *TO2Server) to2Done2(ctx context.Context, msg io.Reader) (*done2Msg, err
 
 	// Create and store a new voucher
 	keyType := currentOV.Header.Val.ManufacturerKey.Type
-	keyEncoding := currentOV.Header.Val.ManufacturerKey.Encoding
-	_, ownerPublicKey, err := s.ownerKey(keyType, keyEncoding)
+	_, ownerPublicKey, err := s.ownerKey(keyType, s.ownerKeyEncoding(currentOV))
 	if err != nil {
 		return nil, err
 	}
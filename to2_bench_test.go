@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/loopback"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// BenchmarkTO2 measures the throughput of the owner service running
+// concurrent TO2 sessions against the in-memory store. Each session onboards
+// a device with its own loopback transport, so only the server is shared.
+// Devices are reused, onboarding again with their replacement credential.
+//
+// Results with GOMAXPROCS=1, before caching CBOR struct field order and
+// keeping owner module state per session (concurrent sessions previously
+// failed, so the numbers are of sessions run one after another):
+//
+//	BenchmarkTO2/sessions=1     8535983 ns/op  1768092 B/op  46719 allocs/op
+//	BenchmarkTO2/sessions=64   10982203 ns/op  1776561 B/op  46970 allocs/op
+//	BenchmarkTO2/sessions=1024 10848630 ns/op  1811238 B/op  48029 allocs/op
+//
+// After:
+//
+//	BenchmarkTO2/sessions=1     4119500 ns/op   910024 B/op  23996 allocs/op
+//	BenchmarkTO2/sessions=64    3977807 ns/op   911408 B/op  24040 allocs/op
+//	BenchmarkTO2/sessions=1024  4798461 ns/op   929272 B/op  24616 allocs/op
+func BenchmarkTO2(b *testing.B) {
+	for _, sessions := range []int{1, 64, 1024} {
+		b.Run(fmt.Sprintf("sessions=%d", sessions), func(b *testing.B) {
+			benchmarkTO2(b, sessions)
+		})
+	}
+}
+
+func benchmarkTO2(b *testing.B, sessions int) {
+	server := fdotest.NewServer(b)
+	devices := make(chan *fdotest.Device, sessions)
+	for range sessions {
+		devices <- server.NewDevice(b, protocol.Secp256r1KeyType)
+	}
+
+	// RunParallel runs parallelism * GOMAXPROCS goroutines
+	b.SetParallelism(max(1, sessions/runtime.GOMAXPROCS(0)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		for pb.Next() {
+			dev := <-devices
+			transport := &loopback.Transport{Tokens: server.State, TO2Responder: server.TO2}
+			cred, err := fdo.TO2(ctx, transport, nil, fdo.TO2Config{
+				Cred:       dev.Cred,
+				HmacSha256: dev.HmacSha256,
+				HmacSha384: dev.HmacSha384,
+				Key:        dev.Key,
+				Devmod: serviceinfo.Devmod{
+					Os:      runtime.GOOS,
+					Arch:    runtime.GOARCH,
+					Version: "go-fdo bench",
+					Device:  "go-validation",
+					FileSep: ";",
+					Bin:     runtime.GOARCH,
+				},
+				KeyExchange: kex.ECDH256Suite,
+				CipherSuite: kex.A128GcmCipher,
+			})
+			if err != nil {
+				b.Error(err)
+				devices <- dev
+				return
+			}
+			dev.Cred = *cred
+			devices <- dev
+		}
+	})
+}
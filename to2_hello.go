@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// HelloDeviceRecord is the TO2.HelloDevice message of a session, as received.
+type HelloDeviceRecord struct {
+	GUID protocol.GUID
+
+	// CorrelationID identifies the session in protocol traces. It is zero if
+	// the message was not traced.
+	CorrelationID uint
+
+	Time time.Time
+
+	// Raw is the CBOR-encoded message.
+	Raw []byte
+
+	// Hash is the HelloDeviceHash sent in TO2.ProveOVHdr. If the message
+	// failed before it was computed, it is the SHA-256 hash of Raw.
+	Hash protocol.Hash
+
+	// Err is the error which failed the message, if any.
+	Err string
+}
+
+// HelloDeviceParams are the parameters of a TO2.HelloDevice message which the
+// owner service negotiates with the device.
+type HelloDeviceParams struct {
+	MaxDeviceMessageSize uint16
+	KexSuiteName         kex.Suite
+	CipherSuite          kex.CipherSuiteID
+	SigInfoType          cose.SignatureAlgorithm
+	SigInfo              []byte
+}
+
+// Params decodes the negotiated parameters of the message.
+func (r HelloDeviceRecord) Params() (*HelloDeviceParams, error) {
+	var hello helloDeviceMsg
+	if err := cbor.Unmarshal(r.Raw, &hello); err != nil {
+		return nil, err
+	}
+	return &HelloDeviceParams{
+		MaxDeviceMessageSize: hello.MaxDeviceMessageSize,
+		KexSuiteName:         hello.KexSuiteName,
+		CipherSuite:          hello.CipherSuite,
+		SigInfoType:          hello.SigInfoA.Type,
+		SigInfo:              hello.SigInfoA.Info,
+	}, nil
+}
+
+// recordHelloDevice adds a HelloDevice message to the HelloDevices state.
+// Failure to record does not fail TO2.
+func (s *TO2Server) recordHelloDevice(ctx context.Context, guid protocol.GUID, raw []byte, hash protocol.Hash, msgErr error) {
+	if s.HelloDevices == nil {
+		return
+	}
+	if hash.Value == nil {
+		sum := sha256.Sum256(raw)
+		hash = protocol.Hash{Algorithm: protocol.Sha256Hash, Value: sum[:]}
+	}
+	record := HelloDeviceRecord{
+		GUID:          guid,
+		CorrelationID: protocol.TraceCorrelationID(ctx),
+		Time:          time.Now(),
+		Raw:           raw,
+		Hash:          hash,
+	}
+	if msgErr != nil {
+		record.Err = msgErr.Error()
+	}
+	if err := s.HelloDevices.AddHelloDevice(ctx, record); err != nil {
+		slog.Warn("error recording TO2.HelloDevice", "guid", guid, "error", err)
+	}
+}
@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// ErrDeviceDenied is returned by TO2.HelloDevice when the device is on the
+// deny list of the owner service.
+var ErrDeviceDenied = errors.New("device is denied onboarding")
+
+// ErrReonboarding is returned by TO2.HelloDevice when a device which already
+// completed onboarding presents the same voucher again and the
+// TO2Server.Reonboarding policy is ReonboardReject.
+var ErrReonboarding = errors.New("device has already onboarded")
+
+// ReonboardPolicy determines how TO2.HelloDevice treats a device whose
+// onboarding history shows that it already completed TO2 with the same GUID.
+// Unless the device was reset to onboard again, such as with the Credential
+// Reuse Protocol, this indicates that its credential was cloned.
+type ReonboardPolicy int
+
+// Reonboarding policies
+const (
+	// Devices are not checked for previous onboarding.
+	ReonboardAllow ReonboardPolicy = iota
+
+	// Devices which already onboarded are logged and recorded in the
+	// history as OnboardingRepeated, but continue onboarding.
+	ReonboardAlert
+
+	// Devices which already onboarded are recorded in the history as
+	// OnboardingRepeated and fail with ErrReonboarding.
+	ReonboardReject
+)
+
+func (p ReonboardPolicy) String() string {
+	switch p {
+	case ReonboardAllow:
+		return "allow"
+	case ReonboardAlert:
+		return "alert"
+	case ReonboardReject:
+		return "reject"
+	default:
+		return fmt.Sprintf("ReonboardPolicy(%d)", int(p))
+	}
+}
+
+// OnboardingState is the state of a device recorded by an onboarding event.
+type OnboardingState string
+
+// Onboarding states
+const (
+	// The voucher was added to the owner service.
+	OnboardingImported OnboardingState = "imported"
+
+	// The rendezvous blob was registered with TO0.
+	OnboardingRegistered OnboardingState = "registered"
+
+	// TO2.HelloDevice was accepted.
+	OnboardingStarted OnboardingState = "started"
+
+	// TO2.Done was accepted.
+	OnboardingCompleted OnboardingState = "completed"
+
+	// A TO2 message failed.
+	OnboardingFailed OnboardingState = "failed"
+
+	// TO2.HelloDevice was rejected because the device is denied, or the
+	// device was added to the deny list.
+	OnboardingDenied OnboardingState = "denied"
+
+	// TO2.HelloDevice was received for a device which already completed
+	// onboarding with the same GUID. See ReonboardPolicy.
+	OnboardingRepeated OnboardingState = "repeated"
+)
+
+// OnboardingEvent records a change of the onboarding state of a device.
+type OnboardingEvent struct {
+	// GUID is the GUID of the voucher when the event occurred.
+	GUID  protocol.GUID
+	Time  time.Time
+	State OnboardingState
+
+	// Err is the error message of a failed TO2 session.
+	Err string
+
+	// ReplacementGUID is set on completion if the device was given a new
+	// GUID, which identifies its replacement voucher.
+	ReplacementGUID *protocol.GUID
+
+	// OwnerKeyHash is set on completion to the SHA-256 hash of the CBOR
+	// encoded owner public key of the replacement voucher, identifying the
+	// owner the device onboarded to.
+	OwnerKeyHash []byte
+}
+
+// checkDenied fails if the DenyList contains the device.
+func (s *TO2Server) checkDenied(ctx context.Context, guid protocol.GUID) error {
+	if s.DenyList == nil {
+		return nil
+	}
+	denied, err := s.DenyList.DeviceDenied(ctx, guid)
+	if err != nil {
+		return fmt.Errorf("error checking deny list for device %x: %w", guid, err)
+	}
+	if denied {
+		captureErr(ctx, protocol.ResourceNotFound, "")
+		return fmt.Errorf("%w: %x", ErrDeviceDenied, guid)
+	}
+	return nil
+}
+
+// checkReonboarding applies the Reonboarding policy if the History shows that
+// the device already completed TO2 with the same GUID.
+func (s *TO2Server) checkReonboarding(ctx context.Context, guid protocol.GUID) error {
+	if s.Reonboarding == ReonboardAllow || s.History == nil {
+		return nil
+	}
+	history, err := s.History.OnboardingHistory(ctx, guid)
+	if err != nil {
+		return fmt.Errorf("error checking onboarding history of device %x: %w", guid, err)
+	}
+	var completed *OnboardingEvent
+	for i := range history {
+		if history[i].State == OnboardingCompleted {
+			completed = &history[i]
+		}
+	}
+	if completed == nil {
+		return nil
+	}
+
+	if s.Reonboarding == ReonboardReject {
+		captureErr(ctx, protocol.ResourceNotFound, "")
+		return fmt.Errorf("%w: %x completed TO2 at %s", ErrReonboarding, guid, completed.Time.Format(time.RFC3339))
+	}
+	slog.Warn("device which already onboarded is onboarding again", "guid", guid, "completed", completed.Time)
+	if err := s.History.AddOnboardingEvent(ctx, OnboardingEvent{
+		GUID:  guid,
+		Time:  time.Now(),
+		State: OnboardingRepeated,
+	}); err != nil {
+		slog.Warn("error recording onboarding event", "guid", guid, "state", OnboardingRepeated, "error", err)
+	}
+	return nil
+}
+
+// recordOnboarding adds an event to the History for the start, completion, or
+// failure of TO2. Failure to record does not fail TO2.
+func (s *TO2Server) recordOnboarding(ctx context.Context, msgType uint8, msgErr error) {
+	if s.History == nil {
+		return
+	}
+
+	var event OnboardingEvent
+	switch {
+	case errors.Is(msgErr, ErrDeviceDenied):
+		event.State = OnboardingDenied
+	case errors.Is(msgErr, ErrReonboarding):
+		event.State, event.Err = OnboardingRepeated, msgErr.Error()
+	case msgErr != nil:
+		event.State, event.Err = OnboardingFailed, msgErr.Error()
+	case msgType == protocol.TO2HelloDeviceMsgType:
+		event.State = OnboardingStarted
+	case msgType == protocol.TO2DoneMsgType:
+		event.State = OnboardingCompleted
+	default:
+		return
+	}
+
+	// Sessions which failed before the device was identified are not recorded
+	guid, err := s.Session.GUID(ctx)
+	if err != nil {
+		return
+	}
+	event.GUID, event.Time = guid, time.Now()
+	if event.State == OnboardingCompleted {
+		current := guid
+		if replacement, err := s.Session.ReplacementGUID(ctx); err == nil && replacement != guid {
+			event.ReplacementGUID, current = &replacement, replacement
+		}
+		if hash, err := s.ownerKeyHash(ctx, current); err != nil {
+			slog.Warn("error hashing owner key of onboarded device", "guid", current, "error", err)
+		} else {
+			event.OwnerKeyHash = hash
+		}
+	}
+
+	if err := s.History.AddOnboardingEvent(ctx, event); err != nil {
+		slog.Warn("error recording onboarding event", "guid", guid, "state", event.State, "error", err)
+	}
+}
+
+// ownerKeyHash returns the SHA-256 hash of the CBOR encoded owner public key
+// of a voucher.
+func (s *TO2Server) ownerKeyHash(ctx context.Context, guid protocol.GUID) ([]byte, error) {
+	ov, err := s.Vouchers.Voucher(ctx, guid)
+	if err != nil {
+		return nil, err
+	}
+	ownerKey := ov.Header.Val.ManufacturerKey
+	if len(ov.Entries) > 0 {
+		ownerKey = ov.Entries[len(ov.Entries)-1].Payload.Val.PublicKey
+	}
+	h := sha256.New()
+	if err := cbor.NewEncoder(h).Encode(&ownerKey); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
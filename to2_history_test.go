@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/loopback"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestReonboarding(t *testing.T) {
+	for _, test := range []struct {
+		policy fdo.ReonboardPolicy
+		fails  bool
+		states string
+	}{
+		{fdo.ReonboardAllow, false, "started,completed,started,completed"},
+		{fdo.ReonboardAlert, false, "started,completed,repeated,started,completed"},
+		{fdo.ReonboardReject, true, "started,completed,repeated"},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			server := fdotest.NewServer(t)
+			server.TO2.History = server.State
+			server.TO2.Reonboarding = test.policy
+
+			// With the Credential Reuse Protocol, the voucher of the device
+			// is unchanged, so onboarding again with the same credential
+			// looks like a cloned device
+			server.TO2.ReuseCredential = func(context.Context, fdo.Voucher) bool { return true }
+			dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+			if err := onboardTO2(server, dev); err != nil {
+				t.Fatal(err)
+			}
+			err := onboardTO2(server, dev)
+			switch {
+			case test.fails && err == nil:
+				t.Fatal("expected onboarding again to fail")
+			case test.fails && !strings.Contains(err.Error(), "already onboarded"):
+				t.Fatalf("expected reonboarding error, got %v", err)
+			case !test.fails && err != nil:
+				t.Fatal(err)
+			}
+
+			history, err := server.State.OnboardingHistory(context.Background(), dev.Cred.GUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var states []string
+			for _, event := range history {
+				states = append(states, string(event.State))
+			}
+			if got := strings.Join(states, ","); got != test.states {
+				t.Fatalf("expected history %s, got %s", test.states, got)
+			}
+			if history[1].ReplacementGUID != nil || len(history[1].OwnerKeyHash) != sha256.Size {
+				t.Fatalf("expected completed event with owner key hash, got %+v", history[1])
+			}
+		})
+	}
+}
+
+func TestReonboardingReplacedGUID(t *testing.T) {
+	server := fdotest.NewServer(t)
+	server.TO2.History = server.State
+	server.TO2.Reonboarding = fdo.ReonboardReject
+
+	// A clone of the original credential is rejected as already onboarded,
+	// rather than failing because its voucher was replaced
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	clone := *dev
+	if err := onboardTO2(server, dev); err != nil {
+		t.Fatal(err)
+	}
+	if dev.Cred.GUID == clone.Cred.GUID {
+		t.Fatal("expected replacement GUID")
+	}
+	var errMsg protocol.ErrorMessage
+	if err := onboardTO2(server, &clone); !errors.As(err, &errMsg) || !strings.Contains(errMsg.ErrString, "already onboarded") {
+		t.Fatalf("expected reonboarding error, got %v", err)
+	}
+
+	history, err := server.State.OnboardingHistory(context.Background(), clone.Cred.GUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := history[1]
+	if completed.State != fdo.OnboardingCompleted || completed.ReplacementGUID == nil || *completed.ReplacementGUID != dev.Cred.GUID {
+		t.Fatalf("expected completion with replacement GUID, got %+v", completed)
+	}
+}
+
+// onboardTO2 runs TO2 without TO1, allowing the Credential Reuse
+// Protocol, and updates the device credential if it was replaced.
+func onboardTO2(server *fdotest.Server, dev *fdotest.Device) error {
+	transport := &loopback.Transport{Tokens: server.State, TO2Responder: server.TO2}
+	cred, err := fdo.TO2(context.Background(), transport, nil, fdo.TO2Config{
+		Cred:       dev.Cred,
+		HmacSha256: dev.HmacSha256,
+		HmacSha384: dev.HmacSha384,
+		Key:        dev.Key,
+		Devmod: serviceinfo.Devmod{
+			Os:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Version: "go-fdo test",
+			Device:  "go-validation",
+			FileSep: ";",
+			Bin:     runtime.GOARCH,
+		},
+		KeyExchange:          kex.ECDH256Suite,
+		CipherSuite:          kex.A128GcmCipher,
+		AllowCredentialReuse: true,
+	})
+	if err != nil {
+		return err
+	}
+	if cred != nil {
+		dev.Cred = *cred
+	}
+	return nil
+}
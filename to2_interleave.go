@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ownerModule is an owner module started in a TO2 session. Owner modules may
+// share a name, such as several fdo.download modules, so modules are tracked
+// by instance rather than by name.
+type ownerModule struct {
+	serviceinfo.OwnerModule
+
+	name string
+
+	// key identifies the module among those of the session by its name and
+	// occurrence, as in moduleStateKey
+	key string
+}
+
+// moduleRotation tracks started owner modules and schedules unfinished ones
+// round-robin when TO2Server.InterleaveModules is set.
+type moduleRotation struct {
+	// Unfinished modules in the order they will next produce
+	queue []*ownerModule
+
+	// All modules pulled from the iterator, so that they can be closed
+	all []*ownerModule
+
+	// Modules which have not started, because an unfinished module of the
+	// same name is queued. The device module of a name handles one owner
+	// module at a time, so modules of the same name are never interleaved.
+	held []*ownerModule
+
+	// The last module of each name to produce service info, including
+	// finished ones, so that late device service info can still be handled
+	started map[string]*ownerModule
+}
+
+// queued reports whether an unfinished module of the name is queued.
+func (r *moduleRotation) queued(moduleName string) bool {
+	return slices.ContainsFunc(r.queue, func(mod *ownerModule) bool { return mod.name == moduleName })
+}
+
+// trackModules sets nextModule to start the modules of pull in turn. When
+// modules are interleaved, each module is started as soon as possible and
+// unfinished modules are then resumed in turn.
+func (m *moduleSession) trackModules(pull func() (string, serviceinfo.OwnerModule, bool)) {
+	m.rotation = moduleRotation{started: make(map[string]*ownerModule)}
+	occurrences := make(map[string]int)
+	pullModule := func() (*ownerModule, bool) {
+		moduleName, mod, ok := pull()
+		if !ok {
+			return nil, false
+		}
+		key := moduleStateKey(moduleName, occurrences[moduleName])
+		occurrences[moduleName]++
+		owner := &ownerModule{OwnerModule: mod, name: moduleName, key: key}
+		m.rotation.all = append(m.rotation.all, owner)
+		return owner, true
+	}
+	m.nextModule = pullModule
+	if m.interleave {
+		m.nextModule = func() (*ownerModule, bool) { return m.rotation.next(pullModule) }
+	}
+}
+
+// startModule records a module which is producing service info, so that it
+// handles device service info of its name, including service info sent after
+// the owner has moved on, such as the response to an interactive module's
+// last request.
+func (m *moduleSession) startModule(mod *ownerModule) {
+	m.rotation.started[mod.name] = mod
+}
+
+// next returns the next module to produce service info when modules are
+// interleaved: a held module which may now start, else a new module, else the
+// first queued one.
+func (r *moduleRotation) next(pull func() (*ownerModule, bool)) (*ownerModule, bool) {
+	for i, mod := range r.held {
+		if !r.queued(mod.name) {
+			r.held = slices.Delete(r.held, i, i+1)
+			return mod, true
+		}
+	}
+	for {
+		mod, ok := pull()
+		if !ok {
+			break
+		}
+		if r.queued(mod.name) {
+			r.held = append(r.held, mod)
+			continue
+		}
+		return mod, true
+	}
+	if len(r.queue) == 0 {
+		return nil, false
+	}
+	mod := r.queue[0]
+	r.queue = r.queue[1:]
+	return mod, true
+}
+
+// requeueModule schedules an unfinished module to produce service info again.
+// When modules are not interleaved, explicitly blocking the peer, or the
+// module is devmod, which must complete before other modules start, the
+// same module is used in the next round.
+func (m *moduleSession) requeueModule(mod *ownerModule, blockPeer bool) {
+	if !m.interleave || blockPeer || mod.name == "devmod" {
+		m.continueWithModule(mod)
+		return
+	}
+	m.rotation.queue = append(m.rotation.queue, mod)
+}
+
+// receivingModule returns the owner module to handle device service info of
+// the named module. Service info of a started module, including a finished
+// one, is always handled by the last started module of that name, even if
+// the current module is a later module of the same name which has not yet
+// produced service info. Otherwise, if it is for the current module or
+// modules are not interleaved, the current module handles it.
+func (m *moduleSession) receivingModule(moduleName string, current *ownerModule) (*ownerModule, error) {
+	mod, ok := m.rotation.started[moduleName]
+	switch {
+	case ok:
+		return mod, nil
+	case moduleName == current.name, !m.interleave:
+		return current, nil
+	default:
+		return nil, fmt.Errorf("received service info for module %q which has not been started", moduleName)
+	}
+}
+
+// failModule replaces an owner module which returned a recoverable error, so
+// that its further service info is discarded and it is not used again.
+func (m *moduleSession) failModule(ctx context.Context, mod *ownerModule, err error) {
+	slog.WarnContext(ctx, "owner service info module failed", "module", mod.key, "error", err)
+	mod.close()
+	mod.OwnerModule = abandonedModule{}
+}
+
+// close closes the owner module if it implements io.Closer.
+func (mod *ownerModule) close() {
+	impl := mod.OwnerModule
+	if keyed, ok := impl.(keyedModule); ok {
+		impl = keyed.ResumableOwnerModule
+	}
+	if closer, ok := impl.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Warn("error closing owner service info module", "module", mod.key, "error", err)
+		}
+	}
+}
+
+// ownerRecoverable reports whether an owner module failed recoverably. Only
+// errors created by the owner module count: an error decoded from the device
+// is fatal even if the device reported it as recoverable.
+func ownerRecoverable(err error) bool {
+	var modErr *serviceinfo.ModuleError
+	return errors.As(err, &modErr) && modErr.Recoverable && !modErr.Peer
+}
+
+// abandonedModule discards service info of an owner module which failed
+// recoverably.
+type abandonedModule struct{}
+
+func (abandonedModule) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	_, err := io.Copy(io.Discard, messageBody)
+	return err
+}
+
+func (abandonedModule) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	return false, true, nil
+}
@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ErrQuotaExceeded is used when more service info is exchanged in a TO2
+// session than allowed by ServiceInfoLimits.
+var ErrQuotaExceeded = errors.New("service info quota exceeded")
+
+// ServiceInfoLimits limits the rate and total size of service info exchanged
+// in TO2, so that onboarding traffic can coexist with other traffic on
+// constrained links and misbehaving modules cannot transfer unbounded data.
+//
+// Sizes are measured as the encoded size of service info KVs. Zero values
+// are unlimited.
+type ServiceInfoLimits struct {
+	// OwnerRate and DeviceRate limit owner to device and device to owner
+	// service info, respectively, in bytes per second. Rates are enforced by
+	// delaying the next message.
+	OwnerRate, DeviceRate int
+
+	// OwnerQuota and DeviceQuota limit the total owner to device and device
+	// to owner service info, respectively, of a TO2 session in bytes. When a
+	// quota is exceeded, TO2 fails with ErrQuotaExceeded.
+	OwnerQuota, DeviceQuota int64
+}
+
+// serviceInfoUsage is the total service info of a TO2 session in bytes.
+type serviceInfoUsage struct {
+	owner, device int64
+
+	// lastUsed is when service info of the session was last counted on the
+	// owner service, so that usage of abandoned sessions may be released
+	lastUsed time.Time
+}
+
+func (l ServiceInfoLimits) hasQuota() bool {
+	return l.OwnerQuota > 0 || l.DeviceQuota > 0
+}
+
+// addOwner records owner service info and enforces OwnerQuota.
+func (l ServiceInfoLimits) addOwner(usage *serviceInfoUsage, kvs []*serviceinfo.KV) error {
+	usage.owner += serviceInfoSize(kvs)
+	if l.OwnerQuota > 0 && usage.owner > l.OwnerQuota {
+		return fmt.Errorf("%w: owner service info exceeded %d bytes", ErrQuotaExceeded, l.OwnerQuota)
+	}
+	return nil
+}
+
+// addDevice records device service info and enforces DeviceQuota.
+func (l ServiceInfoLimits) addDevice(usage *serviceInfoUsage, kvs []*serviceinfo.KV) error {
+	usage.device += serviceInfoSize(kvs)
+	if l.DeviceQuota > 0 && usage.device > l.DeviceQuota {
+		return fmt.Errorf("%w: device service info exceeded %d bytes", ErrQuotaExceeded, l.DeviceQuota)
+	}
+	return nil
+}
+
+func serviceInfoSize(kvs []*serviceinfo.KV) (size int64) {
+	for _, kv := range kvs {
+		size += int64(kv.Size())
+	}
+	return size
+}
+
+// rateLimiter delays transfers so that they average a given rate. It is safe
+// for concurrent use.
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait reserves n bytes at rate bytes per second and blocks until previous
+// reservations have elapsed.
+func (l *rateLimiter) wait(ctx context.Context, rate int, n int64) error {
+	if rate <= 0 || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	return sleep(ctx, delay)
+}
+
+// serviceInfoLimiter enforces ServiceInfoLimits on the owner service.
+type serviceInfoLimiter struct {
+	owner, device rateLimiter
+
+	// Usage of each in-flight session on the owner service, by session key,
+	// so that quotas apply to each session rather than each device
+	mu    sync.Mutex
+	usage map[string]*serviceInfoUsage
+}
+
+// beginServiceInfo starts counting service info of a session.
+func (s *TO2Server) beginServiceInfo(ctx context.Context) {
+	if !s.ServiceInfoLimits.hasQuota() {
+		return
+	}
+	key := s.sessionKey(ctx)
+	if key == "" {
+		return
+	}
+	s.serviceInfo.mu.Lock()
+	defer s.serviceInfo.mu.Unlock()
+	if s.serviceInfo.usage == nil {
+		s.serviceInfo.usage = make(map[string]*serviceInfoUsage)
+	}
+	s.serviceInfo.usage[key] = &serviceInfoUsage{lastUsed: time.Now()}
+}
+
+// endServiceInfo stops counting service info of a session.
+func (s *TO2Server) endServiceInfo(ctx context.Context) {
+	if !s.ServiceInfoLimits.hasQuota() {
+		return
+	}
+	key := s.sessionKey(ctx)
+	s.serviceInfo.mu.Lock()
+	defer s.serviceInfo.mu.Unlock()
+	delete(s.serviceInfo.usage, key)
+}
+
+// expireServiceInfo stops counting service info of sessions which have not
+// exchanged service info for longer than the timeout.
+func (s *TO2Server) expireServiceInfo(now time.Time, timeout time.Duration) {
+	s.serviceInfo.mu.Lock()
+	defer s.serviceInfo.mu.Unlock()
+	for key, usage := range s.serviceInfo.usage {
+		if now.Sub(usage.lastUsed) > timeout {
+			delete(s.serviceInfo.usage, key)
+		}
+	}
+}
+
+// limitDeviceServiceInfo enforces limits on service info received in
+// TO2.DeviceServiceInfo.
+func (s *TO2Server) limitDeviceServiceInfo(ctx context.Context, kvs []*serviceinfo.KV) error {
+	if err := s.countServiceInfo(ctx, kvs, s.ServiceInfoLimits.addDevice); err != nil {
+		return err
+	}
+	return s.serviceInfo.device.wait(ctx, s.ServiceInfoLimits.DeviceRate, serviceInfoSize(kvs))
+}
+
+// limitOwnerServiceInfo enforces limits on service info to be sent in
+// TO2.OwnerServiceInfo.
+func (s *TO2Server) limitOwnerServiceInfo(ctx context.Context, kvs []*serviceinfo.KV) error {
+	if err := s.countServiceInfo(ctx, kvs, s.ServiceInfoLimits.addOwner); err != nil {
+		return err
+	}
+	return s.serviceInfo.owner.wait(ctx, s.ServiceInfoLimits.OwnerRate, serviceInfoSize(kvs))
+}
+
+func (s *TO2Server) countServiceInfo(ctx context.Context, kvs []*serviceinfo.KV, add func(*serviceInfoUsage, []*serviceinfo.KV) error) error {
+	if !s.ServiceInfoLimits.hasQuota() {
+		return nil
+	}
+	key := s.sessionKey(ctx)
+	if key == "" {
+		return errors.New("service info quotas require a TO2 session token")
+	}
+
+	s.serviceInfo.mu.Lock()
+	defer s.serviceInfo.mu.Unlock()
+	usage, ok := s.serviceInfo.usage[key]
+	if !ok {
+		// Sessions started before limits were set or by another server
+		// instance are counted from their first message
+		if s.serviceInfo.usage == nil {
+			s.serviceInfo.usage = make(map[string]*serviceInfoUsage)
+		}
+		usage = new(serviceInfoUsage)
+		s.serviceInfo.usage[key] = usage
+	}
+	usage.lastUsed = time.Now()
+	return add(usage, kvs)
+}
+
+// deviceServiceInfoLimiter enforces ServiceInfoLimits on the device for one
+// TO2 session.
+type deviceServiceInfoLimiter struct {
+	limits        ServiceInfoLimits
+	usage         serviceInfoUsage
+	owner, device rateLimiter
+}
+
+// send enforces limits on service info before it is sent in
+// TO2.DeviceServiceInfo.
+func (l *deviceServiceInfoLimiter) send(ctx context.Context, kvs []*serviceinfo.KV) error {
+	if err := l.limits.addDevice(&l.usage, kvs); err != nil {
+		return err
+	}
+	return l.device.wait(ctx, l.limits.DeviceRate, serviceInfoSize(kvs))
+}
+
+// receive enforces limits on service info received in TO2.OwnerServiceInfo.
+func (l *deviceServiceInfoLimiter) receive(ctx context.Context, kvs []*serviceinfo.KV) error {
+	if err := l.limits.addOwner(&l.usage, kvs); err != nil {
+		return err
+	}
+	return l.owner.wait(ctx, l.limits.OwnerRate, serviceInfoSize(kvs))
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"strings"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
@@ -41,8 +42,15 @@ func handleOwnerModuleMessages(ctx context.Context, prevModuleName string, modul
 		// Get next service info from the owner service and handle it.
 		key, messageBody, ok := ownerInfo.NextServiceInfo()
 		if !ok {
-			if mod, active := modules.Lookup(prevModuleName); active {
-				if err := handleOwnerModuleYield(ctx, mod, prevModuleName, send); err != nil {
+			if mod, active := modules.Lookup(prevModuleName); active && !modules.failed[prevModuleName] {
+				if err := modules.timeouts.call(ctx, modules.started, prevModuleName, func(ctx context.Context, guard *moduleGuard) error {
+					return handleOwnerModuleYield(ctx, mod, prevModuleName, send, guard)
+				}); serviceinfo.IsRecoverable(err) {
+					if err := modules.fail(prevModuleName, err, send); err != nil {
+						_ = send.CloseWithError(err)
+						return prevModuleName
+					}
+				} else if err != nil {
 					_ = send.CloseWithError(err)
 					return prevModuleName
 				}
@@ -53,6 +61,12 @@ func handleOwnerModuleMessages(ctx context.Context, prevModuleName string, modul
 		moduleName, messageName, _ := strings.Cut(key, ":")
 		prevModuleName = moduleName
 
+		// Discard service info of modules which failed recoverably
+		if modules.failed[moduleName] {
+			_, _ = io.Copy(io.Discard, messageBody)
+			continue
+		}
+
 		// Automatically receive and respond to active messages. This send is
 		// expected to be buffered until all receives are processed, unlike
 		// modules which must wait for all receives to occur before sending.
@@ -66,13 +80,17 @@ func handleOwnerModuleMessages(ctx context.Context, prevModuleName string, modul
 				_ = send.CloseWithError(err)
 				return prevModuleName
 			}
-			modules.active[moduleName] = newActive
+			modules.SetActive(moduleName, newActive)
 			continue
 		}
 		if !active {
 			_ = send.CloseWithError(fmt.Errorf("device has not activated module %q", moduleName))
 			return prevModuleName
 		}
+		if err := modules.checkSupported(moduleName, messageName); err != nil {
+			_ = send.CloseWithError(err)
+			return prevModuleName
+		}
 
 		// Call device module and provide it a function which can be used to
 		// send zero or more service info KVs. The function returns a writer to
@@ -80,8 +98,16 @@ func handleOwnerModuleMessages(ctx context.Context, prevModuleName string, modul
 		//
 		// If the device module returns an error then the pipe will be closed
 		// with an error, causing the error to propagate to the chunk reader,
-		// which is used in the ServiceInfo send loop.
-		if err := handleOwnerModuleMessage(ctx, mod, moduleName, messageName, messageBody, send); err != nil {
+		// which is used in the ServiceInfo send loop. A recoverable error
+		// instead fails only the module.
+		err := modules.timeouts.call(ctx, modules.started, moduleName, func(ctx context.Context, guard *moduleGuard) error {
+			return handleOwnerModuleMessage(ctx, mod, moduleName, messageName, messageBody, send, guard)
+		})
+		if serviceinfo.IsRecoverable(err) {
+			_, _ = io.Copy(io.Discard, messageBody)
+			err = modules.fail(moduleName, err, send)
+		}
+		if err != nil {
 			_ = send.CloseWithError(err)
 			return prevModuleName
 		}
@@ -120,26 +146,16 @@ func handleActive(prevActive bool, mod serviceinfo.DeviceModule, moduleName stri
 	return active, nil
 }
 
-func handleOwnerModuleYield(ctx context.Context, mod serviceinfo.DeviceModule, moduleName string, send *serviceinfo.UnchunkWriter) error {
-	respond := func(messageName string) io.Writer {
-		_ = send.NextServiceInfo(moduleName, messageName)
-		return send
-	}
-	yield := func() {
-		_ = send.ForceNewMessage()
-	}
+func handleOwnerModuleYield(ctx context.Context, mod serviceinfo.DeviceModule, moduleName string, send *serviceinfo.UnchunkWriter, guard *moduleGuard) error {
+	respond, yield := guardedResponders(moduleName, send, guard)
 	return mod.Yield(ctx, respond, yield)
 }
 
-func handleOwnerModuleMessage(ctx context.Context, mod serviceinfo.DeviceModule, moduleName, messageName string, messageBody io.Reader, send *serviceinfo.UnchunkWriter) error {
-	// Construct respond/yield callback functions
-	respond := func(messageName string) io.Writer {
-		_ = send.NextServiceInfo(moduleName, messageName)
-		return send
-	}
-	yield := func() {
-		_ = send.ForceNewMessage()
-	}
+func handleOwnerModuleMessage(ctx context.Context, mod serviceinfo.DeviceModule, moduleName, messageName string, messageBody io.Reader, send *serviceinfo.UnchunkWriter, guard *moduleGuard) error {
+	// Construct respond/yield callback functions and guard the message body,
+	// which are shared with the caller if the module times out
+	respond, yield := guardedResponders(moduleName, send, guard)
+	messageBody = guard.reader(messageBody)
 
 	// Handle message
 	if err := mod.Receive(ctx, messageName, messageBody, respond, yield); err != nil {
@@ -157,15 +173,63 @@ func handleOwnerModuleMessage(ctx context.Context, mod serviceinfo.DeviceModule,
 	return nil
 }
 
+// guardedResponders returns the respond and yield callbacks of a device
+// module, which send service info unless the module call has been abandoned.
+func guardedResponders(moduleName string, send *serviceinfo.UnchunkWriter, guard *moduleGuard) (func(string) io.Writer, func()) {
+	respond := func(messageName string) io.Writer {
+		_ = guard.do(func() error { return send.NextServiceInfo(moduleName, messageName) })
+		return guard.writer(send)
+	}
+	yield := func() {
+		_ = guard.do(send.ForceNewMessage)
+	}
+	return respond, yield
+}
+
 type deviceModuleMap struct {
-	modules map[string]serviceinfo.DeviceModule
-	active  map[string]bool
+	*serviceinfo.Registry
+
+	timeouts ModuleTimeouts
+	started  moduleStartTimes
+
+	// Modules which failed recoverably and are no longer used
+	failed map[string]bool
+	hooks  ClientHooks
+}
+
+// fail stops using a module which returned a recoverable error and reports
+// the error to the owner module.
+func (fm deviceModuleMap) fail(moduleName string, err error, send *serviceinfo.UnchunkWriter) error {
+	slog.Warn("service info module failed", "module", moduleName, "error", err)
+	fm.failed[moduleName] = true
+	fm.hooks.moduleError(moduleName, err)
+
+	respond := func(messageName string) io.Writer {
+		_ = send.NextServiceInfo(moduleName, messageName)
+		return send
+	}
+	return serviceinfo.RespondError(respond, err)
 }
 
 func (fm deviceModuleMap) Lookup(moduleName string) (mod serviceinfo.DeviceModule, active bool) {
-	module, known := fm.modules[moduleName]
+	module, _, known := fm.Module(moduleName)
 	if !known {
 		module = serviceinfo.UnknownModule{}
 	}
-	return module, fm.active[moduleName]
+	return module, fm.Active(moduleName)
+}
+
+// checkSupported returns an error if a module does not accept a message from
+// the owner module, according to its registered capabilities.
+func (fm deviceModuleMap) checkSupported(moduleName, messageName string) error {
+	_, info, known := fm.Module(moduleName)
+	if !known || info.Supports(messageName) {
+		return nil
+	}
+	version := ""
+	if info.Version != "" {
+		version = " version " + info.Version
+	}
+	return fmt.Errorf("owner sent unsupported message %q to device module %q%s, which supports: %s",
+		messageName, moduleName, version, strings.Join(info.Messages, ", "))
 }
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/plugin"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// moduleSession is the owner module state of the service info exchange of a
+// TO2 session. It is kept in memory, so service info messages of a session
+// must be handled by the same server instance, but sessions of different
+// devices are independent and may be handled concurrently.
+//
+// Devices send one message at a time, so the state of a session is not
+// guarded, except for its use, which is guarded by the lock of moduleSessions
+// so that sessions of devices which stop sending messages may be expired.
+type moduleSession struct {
+	nextModule   func() (*ownerModule, bool)
+	stop         func()
+	plugins      map[string]plugin.Module
+	compressor   *serviceInfoCompressor
+	rotation     moduleRotation
+	moduleStarts moduleStartTimes
+	interleave   bool
+
+	lastUsed time.Time
+	inUse    bool
+}
+
+// moduleSessions are the module sessions of a server by the current GUID of
+// their device.
+type moduleSessions struct {
+	mu     sync.Mutex
+	byGUID map[protocol.GUID]*moduleSession
+}
+
+// startModules begins the module session of a device, ending any previous
+// session of the same device which did not end, such as when TO2 is retried
+// after a lost connection.
+func (s *TO2Server) startModules(guid protocol.GUID, sess *moduleSession) {
+	sess.lastUsed = time.Now()
+	s.modules.mu.Lock()
+	prev := s.modules.byGUID[guid]
+	if s.modules.byGUID == nil {
+		s.modules.byGUID = make(map[protocol.GUID]*moduleSession)
+	}
+	s.modules.byGUID[guid] = sess
+	s.modules.mu.Unlock()
+
+	if prev != nil {
+		prev.close()
+	}
+}
+
+// moduleSession returns the module session of the device of a TO2 session.
+// The session is not expired until releaseModules is called.
+func (s *TO2Server) moduleSession(ctx context.Context) (*moduleSession, error) {
+	guid, err := s.Session.GUID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving associated device GUID of proof session: %w", err)
+	}
+	s.modules.mu.Lock()
+	defer s.modules.mu.Unlock()
+	sess, ok := s.modules.byGUID[guid]
+	if !ok {
+		return nil, fmt.Errorf("service info exchange for device %x has not started", guid)
+	}
+	sess.inUse = true
+	return sess, nil
+}
+
+// releaseModules marks a module session as no longer handling a message, so
+// that it expires if the device sends no more messages.
+func (s *TO2Server) releaseModules(sess *moduleSession) {
+	s.modules.mu.Lock()
+	defer s.modules.mu.Unlock()
+	sess.inUse = false
+	sess.lastUsed = time.Now()
+}
+
+// expireModules ends module sessions which have not been used for longer than
+// the timeout, such as those of devices which stopped sending messages.
+func (s *TO2Server) expireModules(now time.Time, timeout time.Duration) {
+	var expired []*moduleSession
+	s.modules.mu.Lock()
+	for guid, sess := range s.modules.byGUID {
+		if !sess.inUse && now.Sub(sess.lastUsed) > timeout {
+			expired = append(expired, sess)
+			delete(s.modules.byGUID, guid)
+		}
+	}
+	s.modules.mu.Unlock()
+
+	for _, sess := range expired {
+		sess.close()
+	}
+}
+
+// endModules ends the module session of the device of a TO2 session, if
+// any, stopping its owner modules.
+func (s *TO2Server) endModules(ctx context.Context) {
+	guid, err := s.Session.GUID(ctx)
+	if err != nil {
+		return
+	}
+	s.modules.mu.Lock()
+	sess, ok := s.modules.byGUID[guid]
+	delete(s.modules.byGUID, guid)
+	s.modules.mu.Unlock()
+
+	if ok {
+		sess.close()
+	}
+}
+
+// close stops the owner module iterator, closes its modules, and stops any
+// running plugins.
+func (m *moduleSession) close() {
+	m.stop()
+	for _, mod := range m.rotation.all {
+		mod.close()
+	}
+
+	// Start goroutines to gracefully/forcefully stop plugins. Stopping is
+	// given an absolute timeout not tied to the expiration of the request
+	// context.
+	for name, p := range m.plugins {
+		pluginGracefulStopCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+
+		// Allow graceful stop up to the timeout
+		go func(p plugin.Module) {
+			defer done()
+			if err := p.GracefulStop(pluginGracefulStopCtx); err != nil && !errors.Is(err, context.Canceled) { //nolint:revive,staticcheck
+				slog.Warn("graceful stop failed", "module", name, "error", err)
+			}
+		}(p)
+
+		// Force stop after the shared timeout expires or graceful stop
+		// completes
+		go func(p plugin.Module) {
+			<-pluginGracefulStopCtx.Done()
+			_ = p.Stop()
+			// TODO: Track state for whether plugins are still stopping
+		}(p)
+	}
+}
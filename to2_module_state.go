@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// moduleStateKey identifies the progress of the nth owner module with a given
+// name, so that modules used more than once, such as fdo.download for several
+// files, are resumed separately.
+func moduleStateKey(moduleName string, n int) string {
+	if n == 0 {
+		return moduleName
+	}
+	return fmt.Sprintf("%s#%d", moduleName, n)
+}
+
+// keyedModule is a resumable owner module along with the key of its progress.
+type keyedModule struct {
+	serviceinfo.ResumableOwnerModule
+	key string
+}
+
+// resumeModule restores the progress of a resumable owner module from a
+// previous TO2 session of the device. If restoring fails, the returned module
+// fails TO2 when it is first used.
+func (s *TO2Server) resumeModule(ctx context.Context, guid protocol.GUID, key string, mod serviceinfo.OwnerModule) serviceinfo.OwnerModule {
+	resumable, ok := mod.(serviceinfo.ResumableOwnerModule)
+	if s.ModuleState == nil || !ok {
+		return mod
+	}
+	keyed := keyedModule{ResumableOwnerModule: resumable, key: key}
+	state, err := s.ModuleState.ModuleState(ctx, guid, key)
+	if errors.Is(err, ErrNotFound) {
+		return keyed
+	}
+	if err == nil {
+		err = resumable.Resume(state)
+	}
+	if err != nil {
+		return failedModule{err: fmt.Errorf("error resuming owner module %q: %w", key, err)}
+	}
+	return keyed
+}
+
+// checkpointModule persists the progress of a resumable owner module.
+func (s *TO2Server) checkpointModule(ctx context.Context, mod serviceinfo.OwnerModule) error {
+	keyed, ok := mod.(keyedModule)
+	if s.ModuleState == nil || !ok {
+		return nil
+	}
+	guid, err := s.Session.GUID(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving associated device GUID of proof session: %w", err)
+	}
+	state, err := keyed.Checkpoint()
+	if err != nil {
+		return fmt.Errorf("error checkpointing owner module %q: %w", keyed.key, err)
+	}
+	if err := s.ModuleState.SetModuleState(ctx, guid, keyed.key, state); err != nil {
+		return fmt.Errorf("error storing owner module %q state: %w", keyed.key, err)
+	}
+	return nil
+}
+
+// removeModuleStates removes the progress of all owner modules once TO2 has
+// completed. Failure does not fail TO2, because stale state is only used if
+// the device onboards again with the same GUID.
+func (s *TO2Server) removeModuleStates(ctx context.Context, guid protocol.GUID) {
+	if s.ModuleState == nil {
+		return
+	}
+	if err := s.ModuleState.RemoveModuleStates(ctx, guid); err != nil {
+		slog.Warn("error removing owner module state", "guid", guid, "error", err)
+	}
+}
+
+// failedModule is used in place of an owner module which could not be
+// initialized.
+type failedModule struct{ err error }
+
+func (m failedModule) HandleInfo(context.Context, string, io.Reader) error { return m.err }
+
+func (m failedModule) ProduceInfo(context.Context, *serviceinfo.Producer) (bool, bool, error) {
+	return false, false, m.err
+}
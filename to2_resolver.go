@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ServiceInfoDevice describes an onboarding device once its devmod service
+// info has been received.
+type ServiceInfoDevice struct {
+	// GUID is the GUID of the voucher used for TO2 and ReplacementGUID is
+	// the GUID the device will have once TO2 completes. They are the same
+	// when the Credential Reuse Protocol is used.
+	GUID            protocol.GUID
+	ReplacementGUID protocol.GUID
+
+	// Info is the DeviceInfo string of the voucher header.
+	Info string
+
+	// Chain is the device certificate chain of the voucher, if any.
+	Chain []*x509.Certificate
+
+	// Devmod contains the device's devmod service info and Modules the names
+	// of the service info modules it supports.
+	Devmod  serviceinfo.Devmod
+	Modules []string
+}
+
+// ServiceInfoResolver chooses the owner service info modules, and their
+// parameters, such as which files to download and which commands to run, for
+// each onboarding device. This allows a single owner service to onboard a
+// heterogeneous fleet.
+type ServiceInfoResolver interface {
+	// ResolveServiceInfo returns an iterator of owner modules for a device,
+	// as with TO2Server.OwnerModules. Any error fails TO2.
+	ResolveServiceInfo(context.Context, ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error)
+}
+
+// ServiceInfoResolverFunc adapts a function to a ServiceInfoResolver.
+type ServiceInfoResolverFunc func(context.Context, ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error)
+
+// ResolveServiceInfo implements ServiceInfoResolver.
+func (f ServiceInfoResolverFunc) ResolveServiceInfo(ctx context.Context, device ServiceInfoDevice) (iter.Seq2[string, serviceinfo.OwnerModule], error) {
+	return f(ctx, device)
+}
+
+// resolverModuleName names the module which fails TO2 when service info
+// resolution fails. It never sends service info.
+const resolverModuleName = "resolver"
+
+// Supports returns whether the device supports a service info module.
+func (d ServiceInfoDevice) Supports(module string) bool {
+	return slices.Contains(d.Modules, module)
+}
+
+// failModules returns owner modules which fail TO2 with the given error.
+func failModules(err error) iter.Seq2[string, serviceinfo.OwnerModule] {
+	return func(yield func(string, serviceinfo.OwnerModule) bool) {
+		yield(resolverModuleName, failedModule{err: err})
+	}
+}
+
+// ownerModules returns the owner modules for a device from the ServiceInfo
+// resolver, if set, or otherwise from OwnerModules. The devmod service info of
+// the device is stored first.
+func (s *TO2Server) ownerModules(ctx context.Context, device ServiceInfoDevice) iter.Seq2[string, serviceinfo.OwnerModule] {
+	if err := s.storeDevmod(ctx, device); err != nil {
+		return failModules(err)
+	}
+
+	if s.ServiceInfo == nil {
+		if s.OwnerModules == nil {
+			return func(func(string, serviceinfo.OwnerModule) bool) {}
+		}
+		return s.OwnerModules(ctx, device.ReplacementGUID, device.Info, device.Chain, device.Devmod, device.Modules)
+	}
+
+	modules, err := s.ServiceInfo.ResolveServiceInfo(ctx, device)
+	if err != nil {
+		return failModules(fmt.Errorf("error resolving service info for device %x: %w", device.GUID, err))
+	}
+	if modules == nil {
+		return func(func(string, serviceinfo.OwnerModule) bool) {}
+	}
+	return modules
+}
+
+// storeDevmod persists the devmod service info of a device under its current
+// and replacement GUIDs.
+func (s *TO2Server) storeDevmod(ctx context.Context, device ServiceInfoDevice) error {
+	if s.Devmods == nil {
+		return nil
+	}
+	devmod := DeviceDevmod{
+		Devmod:  device.Devmod,
+		Modules: slices.Clone(device.Modules),
+		Time:    time.Now(),
+	}
+	for _, guid := range []protocol.GUID{device.GUID, device.ReplacementGUID} {
+		if err := s.Devmods.SetDevmod(ctx, guid, devmod); err != nil {
+			return fmt.Errorf("error storing devmod of device %x: %w", guid, err)
+		}
+		if device.ReplacementGUID == device.GUID {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultTO2SessionTimeout is the inactivity timeout used when
+// TO2Server.MaxSessions is set without TO2Server.SessionTimeout.
+const DefaultTO2SessionTimeout = 5 * time.Minute
+
+// ErrTooManySessions is used when TO2.HelloDevice is rejected because
+// TO2Server.MaxSessions sessions are already in flight.
+var ErrTooManySessions = errors.New("too many concurrent TO2 sessions")
+
+// ErrShuttingDown is used when TO2.HelloDevice is rejected because
+// TO2Server.Shutdown has been called.
+var ErrShuttingDown = errors.New("owner service is shutting down")
+
+// shutdownPollInterval is how often Shutdown checks for in-flight sessions.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// to2Sessions tracks in-flight TO2 sessions by token, recording the time of
+// the last message of each.
+type to2Sessions struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+
+	// When idle owner modules and service info usage are next expired,
+	// guarded by mu
+	nextIdleCheck time.Time
+
+	// Messages being handled and whether new sessions are rejected
+	inflight     atomic.Int64
+	shuttingDown atomic.Bool
+}
+
+func (s *TO2Server) tracksSessions() bool {
+	return s.MaxSessions > 0 || s.SessionTimeout > 0
+}
+
+func (s *TO2Server) sessionTimeout() time.Duration {
+	if s.SessionTimeout == 0 && s.MaxSessions > 0 {
+		return DefaultTO2SessionTimeout
+	}
+	return s.SessionTimeout
+}
+
+// idleTimeout is the session timeout, or DefaultTO2SessionTimeout if sessions
+// do not expire.
+func (s *TO2Server) idleTimeout() time.Duration {
+	if timeout := s.sessionTimeout(); timeout > 0 {
+		return timeout
+	}
+	return DefaultTO2SessionTimeout
+}
+
+// expireIdle stops the owner modules and releases the service info usage of
+// sessions which have not been used for longer than the session timeout, or
+// DefaultTO2SessionTimeout if sessions do not expire, such as those of devices
+// which stopped sending messages. They are checked at most every half of the
+// timeout, so that handling each message does not check every session.
+func (s *TO2Server) expireIdle() {
+	timeout := s.idleTimeout()
+	now := time.Now()
+	s.sessions.mu.Lock()
+	if now.Before(s.sessions.nextIdleCheck) {
+		s.sessions.mu.Unlock()
+		return
+	}
+	s.sessions.nextIdleCheck = now.Add(timeout / 2)
+	s.sessions.mu.Unlock()
+
+	s.expireModules(now, timeout)
+	s.expireServiceInfo(now, timeout)
+}
+
+// beginMessage expires inactive sessions and returns the token of the
+// session.
+func (s *TO2Server) beginMessage(ctx context.Context) string {
+	s.ExpireSessions(ctx)
+	return s.token(ctx)
+}
+
+// checkSessionLimit enforces MaxSessions for a new session and rejects new
+// sessions once shutting down. The slot of a new session is reserved under the
+// same lock, so that concurrent sessions cannot exceed the limit, and is
+// released by endMessage if TO2.HelloDevice fails.
+func (s *TO2Server) checkSessionLimit(token string) error {
+	if s.sessions.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+	if s.MaxSessions <= 0 || token == "" {
+		return nil
+	}
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	if _, ok := s.sessions.lastSeen[token]; ok {
+		return nil
+	}
+	if len(s.sessions.lastSeen) >= s.MaxSessions {
+		return ErrTooManySessions
+	}
+	if s.sessions.lastSeen == nil {
+		s.sessions.lastSeen = make(map[string]time.Time)
+	}
+	s.sessions.lastSeen[token] = time.Now()
+	return nil
+}
+
+// endMessage records activity for the session or stops tracking it if TO2
+// ended. prevToken is the token before handling the message, which may
+// differ if tokens are mutated on every message.
+func (s *TO2Server) endMessage(ctx context.Context, prevToken string, ended bool) {
+	token := s.token(ctx)
+
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	if s.sessions.lastSeen == nil {
+		s.sessions.lastSeen = make(map[string]time.Time)
+	}
+	delete(s.sessions.lastSeen, prevToken)
+	if ended || token == "" {
+		delete(s.sessions.lastSeen, token)
+		return
+	}
+	s.sessions.lastSeen[token] = time.Now()
+}
+
+// renameSession continues tracking a session after its token is mutated
+// outside of Respond, such as when the encryption session is saved.
+func (s *TO2Server) renameSession(prevToken, token string) {
+	if prevToken == token {
+		return
+	}
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	lastSeen, ok := s.sessions.lastSeen[prevToken]
+	if !ok {
+		return
+	}
+	delete(s.sessions.lastSeen, prevToken)
+	s.sessions.lastSeen[token] = lastSeen
+}
+
+// EndSession stops tracking the TO2 session of the token in the context,
+// freeing its slot toward MaxSessions and releasing its service info usage,
+// owner modules, and voucher. It does not invalidate the token.
+//
+// Transports should call it when a device ends TO2 by sending an error
+// message, since such messages are not passed to Respond.
+func (s *TO2Server) EndSession(ctx context.Context) {
+	s.endServiceInfo(ctx)
+	s.endModules(ctx)
+	s.releaseVoucher(ctx)
+
+	token := s.token(ctx)
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	delete(s.sessions.lastSeen, token)
+}
+
+// ExpireSessions stops tracking TO2 sessions which have been inactive for
+// longer than the session timeout and invalidates their tokens, releasing
+// session state such as key exchange parameters. It returns the number of
+// expired sessions.
+//
+// Inactive sessions are expired whenever a TO2 message is handled, but
+// ExpireSessions may also be called periodically so that state is released
+// when no devices are onboarding. The owner modules and service info usage of
+// inactive sessions are also released, whether or not sessions are tracked.
+func (s *TO2Server) ExpireSessions(ctx context.Context) int {
+	s.expireIdle()
+
+	timeout := s.sessionTimeout()
+	if timeout <= 0 {
+		return 0
+	}
+
+	var expired []string
+	now := time.Now()
+	s.sessions.mu.Lock()
+	for token, lastSeen := range s.sessions.lastSeen {
+		if now.Sub(lastSeen) > timeout {
+			expired = append(expired, token)
+			delete(s.sessions.lastSeen, token)
+		}
+	}
+	s.sessions.mu.Unlock()
+
+	// Invalidate outside of the lock, because it may block on I/O
+	tokens, ok := s.Session.(protocol.TokenService)
+	if !ok {
+		return len(expired)
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, token := range expired {
+		if err := tokens.InvalidateToken(tokens.TokenContext(ctx, token)); err != nil {
+			slog.Debug("error invalidating expired TO2 session", "error", err)
+		}
+	}
+	return len(expired)
+}
+
+// Shutdown stops accepting new TO2 sessions, failing TO2.HelloDevice with
+// ErrShuttingDown, and waits for in-flight sessions to end. Messages of
+// sessions which have already begun continue to be handled, so a transport
+// should keep serving until Shutdown returns. For HTTP, Shutdown should be
+// called before http.Server.Shutdown.
+//
+// Sessions are only known when tracked, i.e. when MaxSessions or
+// SessionTimeout is set. Otherwise, Shutdown only waits for messages being
+// handled. Inactive sessions are expired while waiting.
+//
+// If the context ends first, its error is returned along with the number of
+// sessions still in flight. If ModuleState is set, the service info progress
+// of these sessions has been stored, so that their devices may resume with
+// another instance of the owner service.
+func (s *TO2Server) Shutdown(ctx context.Context) error {
+	s.sessions.shuttingDown.Store(true)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.ExpireSessions(ctx)
+		sessions := s.ActiveSessions()
+		if sessions == 0 && s.sessions.inflight.Load() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d TO2 sessions still in flight: %w", sessions, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ActiveSessions returns the number of tracked in-flight TO2 sessions.
+func (s *TO2Server) ActiveSessions() int {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	return len(s.sessions.lastSeen)
+}
+
+// leaseVoucher marks the voucher of a device in use by the TO2 session, if
+// Vouchers implements OwnerVoucherLeasePersistentState, so that it is not
+// extended by OwnerKeyRotator while the session relies on it. The lease lasts
+// for the session timeout, or DefaultTO2SessionTimeout if sessions do not
+// expire, and is renewed by each message which reads the voucher.
+func (s *TO2Server) leaseVoucher(ctx context.Context, guid protocol.GUID) error {
+	leases, ok := s.Vouchers.(OwnerVoucherLeasePersistentState)
+	if !ok {
+		return nil
+	}
+	if err := leases.LeaseVoucher(ctx, guid, time.Now().Add(s.idleTimeout())); err != nil {
+		return fmt.Errorf("error leasing voucher for device %x: %w", guid, err)
+	}
+	return nil
+}
+
+// releaseVoucher ends the lease of the voucher of the device of the session,
+// once TO2 ends.
+func (s *TO2Server) releaseVoucher(ctx context.Context) {
+	leases, ok := s.Vouchers.(OwnerVoucherLeasePersistentState)
+	if !ok {
+		return
+	}
+	guid, err := s.Session.GUID(ctx)
+	if err != nil {
+		return
+	}
+	if err := leases.ReleaseVoucher(ctx, guid); err != nil {
+		slog.Debug("error releasing voucher lease", "guid", guid, "error", err)
+	}
+}
+
+func (s *TO2Server) token(ctx context.Context) string {
+	tokens, ok := s.Session.(protocol.TokenService)
+	if !ok {
+		return ""
+	}
+	token, _ := tokens.TokenFromContext(ctx)
+	return token
+}
+
+// sessionKey identifies the TO2 session of a context for the life of the
+// session, using its session ID if tokens change within a session, or else its
+// token. It is empty if the session cannot be identified.
+func (s *TO2Server) sessionKey(ctx context.Context) string {
+	if ids, ok := s.Session.(protocol.SessionIdentifier); ok {
+		id, err := ids.SessionID(ctx)
+		if err != nil {
+			return ""
+		}
+		return string(id)
+	}
+	return s.token(ctx)
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// ErrModuleTimeout is wrapped, along with a recoverable
+// serviceinfo.ModuleError, by the error of a service info module which exceeds
+// a deadline of ModuleTimeouts.
+var ErrModuleTimeout = errors.New("service info module timed out")
+
+// ModuleTimeouts limits the time service info modules may take, so that a
+// hung module, such as a stuck plugin process, fails with a recoverable
+// serviceinfo.ModuleError wrapping ErrModuleTimeout instead of stalling the
+// TO2 session indefinitely. As with other recoverable errors, TO2 continues
+// without the module.
+//
+// Modules are passed a context with the deadline, but a module which does not
+// return by the deadline is abandoned rather than waited on. Once abandoned,
+// its reads of the message body and writes of service info fail, so that it
+// cannot interfere with the rest of the session. Zero values are unlimited.
+type ModuleTimeouts struct {
+	// Message limits each call to a module to handle a message, yield, or
+	// produce service info.
+	Message time.Duration
+
+	// Module limits the total time of a module from its first call until its
+	// last.
+	Module time.Duration
+}
+
+// moduleStartTimes is the time each module of a TO2 session was first called,
+// by module key. Device modules are keyed by name. Owner modules are keyed by
+// instance, as in moduleStateKey, because several may share a name.
+type moduleStartTimes map[string]time.Time
+
+// call calls a module, enforcing timeouts. The module must only use resources
+// shared with the caller, such as the message body and service info writer,
+// through the guard, so that it cannot use them once abandoned.
+func (t ModuleTimeouts) call(ctx context.Context, started moduleStartTimes, moduleKey string, fn func(context.Context, *moduleGuard) error) error {
+	guard := new(moduleGuard)
+	if t == (ModuleTimeouts{}) {
+		return fn(ctx, guard)
+	}
+
+	var deadline time.Time
+	now := time.Now()
+	if t.Module > 0 {
+		start, ok := started[moduleKey]
+		if !ok {
+			start = now
+			started[moduleKey] = start
+		}
+		deadline = start.Add(t.Module)
+	}
+	if t.Message > 0 {
+		if d := now.Add(t.Message); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	callCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	// Call in a goroutine so that a module which ignores the context cannot
+	// block past the deadline
+	errc := make(chan error, 1)
+	go func() { errc <- fn(callCtx, guard) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-callCtx.Done():
+	}
+
+	// Stop the abandoned call from using shared resources, waiting for any
+	// use in progress
+	guard.abandon()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w: %w", ErrModuleTimeout, &serviceinfo.ModuleError{
+		Module:      moduleKey,
+		Message:     "exceeded its deadline",
+		Recoverable: true,
+	})
+}
+
+// moduleGuard serializes the use of resources shared between a module call
+// and its caller, so that they cannot be used by the call once it has been
+// abandoned.
+type moduleGuard struct {
+	mu        sync.Mutex
+	abandoned bool
+}
+
+// do calls fn unless the call has been abandoned.
+func (g *moduleGuard) do(fn func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.abandoned {
+		return ErrModuleTimeout
+	}
+	return fn()
+}
+
+func (g *moduleGuard) abandon() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.abandoned = true
+}
+
+// reader returns a reader which fails once the call is abandoned.
+func (g *moduleGuard) reader(r io.Reader) io.Reader { return guardedReader{g, r} }
+
+// writer returns a writer which fails once the call is abandoned.
+func (g *moduleGuard) writer(w io.Writer) io.Writer { return guardedWriter{g, w} }
+
+type guardedReader struct {
+	guard *moduleGuard
+	r     io.Reader
+}
+
+func (r guardedReader) Read(p []byte) (n int, err error) {
+	err = r.guard.do(func() error {
+		n, err = r.r.Read(p)
+		return err
+	})
+	return n, err
+}
+
+type guardedWriter struct {
+	guard *moduleGuard
+	w     io.Writer
+}
+
+func (w guardedWriter) Write(p []byte) (n int, err error) {
+	err = w.guard.do(func() error {
+		n, err = w.w.Write(p)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// GenerateAttestationKey creates a restricted EC P-256 signing key. The TPM
+// only signs structures it created with a restricted key, such as quotes, so
+// the key cannot be used as a crypto.Signer of arbitrary digests.
+func GenerateAttestationKey(t TPM) (Key, error) {
+	template := ecKeyTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgSHA256)
+	template.ObjectAttributes.Restricted = true
+	handle, err := newPrimaryKey(t, template)
+	if err != nil {
+		return nil, fmt.Errorf("creating attestation key: %w", err)
+	}
+	public, err := readPublicECKey(t, *handle)
+	if err != nil {
+		return nil, fmt.Errorf("reading attestation public key: %w", err)
+	}
+
+	return &key{
+		Device:    t,
+		Handle:    handle,
+		PublicKey: public,
+	}, nil
+}
+
+// Quote is a TPM2_Quote of SHA-256 PCRs.
+type Quote struct {
+	// Attest is the marshaled TPMS_ATTEST created by the TPM.
+	Attest []byte
+
+	// Signature is the marshaled TPMT_SIGNATURE of Attest.
+	Signature []byte
+
+	// PCRs are the values of the quoted PCRs.
+	PCRs map[int][]byte
+}
+
+// QuotePCRs reads and quotes SHA-256 PCRs with nonce as the qualifying data.
+// The attestation key must be created by GenerateAttestationKey.
+func QuotePCRs(t TPM, ak Key, nonce []byte, pcrs []int) (*Quote, error) {
+	k, ok := ak.(*key)
+	if !ok {
+		return nil, fmt.Errorf("unsupported attestation key type: %T", ak)
+	}
+	if len(pcrs) == 0 {
+		return nil, errors.New("no PCRs to quote")
+	}
+
+	values := make(map[int][]byte, len(pcrs))
+	indices := make([]uint, len(pcrs))
+	for i, pcr := range pcrs {
+		if pcr < 0 || pcr > 23 {
+			return nil, fmt.Errorf("invalid PCR index: %d", pcr)
+		}
+		indices[i] = uint(pcr)
+
+		// Read one PCR at a time, since TPMs return at most 8 digests
+		resp, err := tpm2.PCRRead{PCRSelectionIn: sha256Selection(uint(pcr))}.Execute(t)
+		if err != nil {
+			return nil, fmt.Errorf("reading PCR %d: %w", pcr, err)
+		}
+		if len(resp.PCRValues.Digests) != 1 {
+			return nil, fmt.Errorf("PCR %d not read", pcr)
+		}
+		values[pcr] = resp.PCRValues.Digests[0].Buffer
+	}
+
+	resp, err := tpm2.Quote{
+		SignHandle:     k.Handle,
+		QualifyingData: tpm2.TPM2BData{Buffer: nonce},
+		InScheme:       tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+		PCRSelect:      sha256Selection(indices...),
+	}.Execute(t)
+	if err != nil {
+		return nil, fmt.Errorf("quoting PCRs: %w", err)
+	}
+
+	return &Quote{
+		Attest:    resp.Quoted.Bytes(),
+		Signature: tpm2.Marshal(resp.Signature),
+		PCRs:      values,
+	}, nil
+}
+
+func sha256Selection(pcrs ...uint) tpm2.TPMLPCRSelection {
+	return tpm2.TPMLPCRSelection{
+		PCRSelections: []tpm2.TPMSPCRSelection{{
+			Hash:      tpm2.TPMAlgSHA256,
+			PCRSelect: tpm2.PCClientCompatible.PCRs(pcrs...),
+		}},
+	}
+}
+
+// VerifyQuote verifies that a quote was signed by an attestation key, that it
+// contains the nonce, and that its PCR values are the ones which were quoted.
+// Whether the attestation key belongs to the device and whether the PCR
+// values are acceptable must be decided by the caller.
+func VerifyQuote(ak crypto.PublicKey, quote *Quote, nonce []byte) error {
+	if err := verifyQuoteSignature(ak, quote); err != nil {
+		return err
+	}
+
+	attest, err := tpm2.Unmarshal[tpm2.TPMSAttest](quote.Attest)
+	if err != nil {
+		return fmt.Errorf("unmarshaling quote: %w", err)
+	}
+	if attest.Type != tpm2.TPMSTAttestQuote {
+		return fmt.Errorf("attestation is not a quote: type %#x", attest.Type)
+	}
+	if !bytes.Equal(attest.ExtraData.Buffer, nonce) {
+		return errors.New("quote nonce does not match")
+	}
+	info, err := attest.Attested.Quote()
+	if err != nil {
+		return fmt.Errorf("quote info: %w", err)
+	}
+
+	// Check that exactly the given SHA-256 PCRs were quoted
+	if len(info.PCRSelect.PCRSelections) != 1 || info.PCRSelect.PCRSelections[0].Hash != tpm2.TPMAlgSHA256 {
+		return errors.New("quote must select only SHA-256 PCRs")
+	}
+	var quoted []int
+	for i, b := range info.PCRSelect.PCRSelections[0].PCRSelect {
+		for bit := range 8 {
+			if b&(1<<bit) != 0 {
+				quoted = append(quoted, i*8+bit)
+			}
+		}
+	}
+	if given := slices.Sorted(maps.Keys(quote.PCRs)); !slices.Equal(quoted, given) {
+		return fmt.Errorf("quoted PCRs %v do not match given PCRs %v", quoted, given)
+	}
+
+	// PCR digest is the hash of the concatenated PCR values in index order
+	digest := sha256.New()
+	for _, pcr := range quoted {
+		digest.Write(quote.PCRs[pcr])
+	}
+	if !bytes.Equal(digest.Sum(nil), info.PCRDigest.Buffer) {
+		return errors.New("PCR values do not match quoted digest")
+	}
+	return nil
+}
+
+func verifyQuoteSignature(ak crypto.PublicKey, quote *Quote) error {
+	sig, err := tpm2.Unmarshal[tpm2.TPMTSignature](quote.Signature)
+	if err != nil {
+		return fmt.Errorf("unmarshaling quote signature: %w", err)
+	}
+
+	switch pub := ak.(type) {
+	case *ecdsa.PublicKey:
+		ecdsaSig, err := sig.Signature.ECDSA()
+		if err != nil {
+			return fmt.Errorf("unable to extract ECDSA signature data: %w", err)
+		}
+		digest, err := hashOf(ecdsaSig.Hash, quote.Attest)
+		if err != nil {
+			return err
+		}
+		r := new(big.Int).SetBytes(ecdsaSig.SignatureR.Buffer)
+		s := new(big.Int).SetBytes(ecdsaSig.SignatureS.Buffer)
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid quote signature")
+		}
+		return nil
+
+	case *rsa.PublicKey:
+		rsaSig, err := sig.Signature.RSASSA()
+		if err != nil {
+			return fmt.Errorf("unable to extract RSA-SSA signature data: %w", err)
+		}
+		digest, err := hashOf(rsaSig.Hash, quote.Attest)
+		if err != nil {
+			return err
+		}
+		h, _ := rsaSig.Hash.Hash()
+		if err := rsa.VerifyPKCS1v15(pub, h, digest, rsaSig.Sig.Buffer); err != nil {
+			return fmt.Errorf("invalid quote signature: %w", err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported attestation key type: %T", ak)
+	}
+}
+
+func hashOf(alg tpm2.TPMIAlgHash, data []byte) ([]byte, error) {
+	h, err := alg.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if !h.Available() {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", h)
+	}
+	hash := h.New()
+	hash.Write(data)
+	return hash.Sum(nil), nil
+}
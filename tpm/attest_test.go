@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tpm_test
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // SHA-1 digests are part of the event log format
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+
+	"github.com/fido-device-onboard/go-fdo/tpm"
+)
+
+func TestQuote(t *testing.T) {
+	sim, err := simulator.OpenSimulator()
+	if err != nil {
+		t.Fatalf("error opening opening TPM simulator: %v", err)
+	}
+	defer func() {
+		if err := sim.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ak, err := tpm.GenerateAttestationKey(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ak.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	nonce := []byte("0123456789abcdef")
+	quote, err := tpm.QuotePCRs(sim, ak, nonce, []int{0, 7, 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quote.PCRs) != 3 || len(quote.PCRs[16]) != sha256.Size {
+		t.Fatalf("unexpected PCR values: %x", quote.PCRs)
+	}
+	if err := tpm.VerifyQuote(ak.Public(), quote, nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tpm.VerifyQuote(ak.Public(), quote, []byte("other nonce")); err == nil {
+		t.Error("expected nonce mismatch to fail verification")
+	}
+	quote.PCRs[7] = bytes.Repeat([]byte{0xff}, sha256.Size)
+	if err := tpm.VerifyQuote(ak.Public(), quote, nonce); err == nil {
+		t.Error("expected modified PCR value to fail verification")
+	}
+	delete(quote.PCRs, 7)
+	if err := tpm.VerifyQuote(ak.Public(), quote, nonce); err == nil {
+		t.Error("expected missing PCR value to fail verification")
+	}
+
+	// Restricted keys cannot sign arbitrary digests
+	digest := sha256.Sum256([]byte("not a TPM structure"))
+	if _, err := ak.Sign(nil, digest[:], nil); err == nil {
+		t.Error("expected restricted key to refuse signing external digest")
+	}
+}
+
+func TestReplayEventLog(t *testing.T) {
+	var log bytes.Buffer
+	le := func(v any) {
+		if err := binary.Write(&log, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Spec ID event with SHA-1 and SHA-256 digests
+	var specID bytes.Buffer
+	specID.WriteString("Spec ID Event03\x00")
+	for _, v := range []any{uint32(0), uint8(0), uint8(2), uint8(0), uint8(2), uint32(2),
+		uint16(0x0004), uint16(sha1.Size), uint16(0x000b), uint16(sha256.Size), uint8(0)} {
+		if err := binary.Write(&specID, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	le(uint32(0))
+	le(uint32(3))
+	le([20]byte{})
+	le(uint32(specID.Len()))
+	log.Write(specID.Bytes())
+
+	event := func(pcr, typ uint32, data []byte) {
+		le(pcr)
+		le(typ)
+		le(uint32(2))
+		le(uint16(0x0004))
+		d1 := sha1.Sum(data) //nolint:gosec // SHA-1 digests are part of the event log format
+		log.Write(d1[:])
+		le(uint16(0x000b))
+		d256 := sha256.Sum256(data)
+		log.Write(d256[:])
+		le(uint32(len(data)))
+		log.Write(data)
+	}
+	event(0, 0x3, append([]byte("StartupLocality\x00"), 3))
+	event(0, 0x8, []byte("CRTM version"))
+	event(7, 0x80000001, []byte("SecureBoot"))
+	event(7, 0x80000001, []byte("PK"))
+
+	events, pcrs, err := tpm.ReplayEventLog(log.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 || events[2].PCR != 7 || string(events[2].Data) != "SecureBoot" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	extend := func(pcr []byte, data ...string) []byte {
+		for _, d := range data {
+			digest := sha256.Sum256([]byte(d))
+			next := sha256.Sum256(append(pcr, digest[:]...))
+			pcr = next[:]
+		}
+		return pcr
+	}
+	locality := make([]byte, sha256.Size)
+	locality[sha256.Size-1] = 3
+	if expect := extend(locality, "CRTM version"); !bytes.Equal(pcrs[0], expect) {
+		t.Errorf("PCR 0: expected %x, got %x", expect, pcrs[0])
+	}
+	if expect := extend(make([]byte, sha256.Size), "SecureBoot", "PK"); !bytes.Equal(pcrs[7], expect) {
+		t.Errorf("PCR 7: expected %x, got %x", expect, pcrs[7])
+	}
+
+	if _, _, err := tpm.ReplayEventLog(log.Bytes()[:log.Len()-1]); err == nil {
+		t.Error("expected truncated log to fail")
+	}
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tpm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// EV_NO_ACTION events are not extended into PCRs
+	evNoAction = 0x00000003
+
+	specIDEventSignature = "Spec ID Event03\x00"
+	startupLocality      = "StartupLocality\x00"
+	sha256AlgID          = 0x000b
+)
+
+// Event is a measurement of a TCG event log.
+type Event struct {
+	PCR    int
+	Type   uint32
+	Digest []byte
+	Data   []byte
+}
+
+// ReplayEventLog parses a crypto-agile (TCG2) binary event log, such as
+// /sys/kernel/security/tpm0/binary_bios_measurements, and returns its events
+// and the SHA-256 PCR values which result from replaying them. The PCR
+// values should be compared to quoted PCRs before the events are trusted.
+func ReplayEventLog(log []byte) ([]Event, map[int][]byte, error) {
+	r := bytes.NewReader(log)
+
+	// The first event uses the SHA-1 log format and describes the digests of
+	// each following event
+	var header struct {
+		PCR, Type uint32
+		Digest    [20]byte
+		Size      uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, nil, fmt.Errorf("reading spec ID event: %w", err)
+	}
+	specID, err := readN(r, header.Size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading spec ID event: %w", err)
+	}
+	digestSizes, err := parseSpecIDEvent(specID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := digestSizes[sha256AlgID]; !ok {
+		return nil, nil, errors.New("event log does not contain SHA-256 digests")
+	}
+
+	var events []Event
+	pcrs := make(map[int][]byte)
+	for r.Len() > 0 {
+		var eventHeader struct{ PCR, Type, Count uint32 }
+		if err := binary.Read(r, binary.LittleEndian, &eventHeader); err != nil {
+			return nil, nil, fmt.Errorf("reading event %d: %w", len(events), err)
+		}
+		if eventHeader.PCR > 23 {
+			return nil, nil, fmt.Errorf("event %d: invalid PCR index %d", len(events), eventHeader.PCR)
+		}
+		var digest []byte
+		for range eventHeader.Count {
+			var alg uint16
+			if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+				return nil, nil, fmt.Errorf("reading event %d: %w", len(events), err)
+			}
+			size, ok := digestSizes[alg]
+			if !ok {
+				return nil, nil, fmt.Errorf("event %d: unknown digest algorithm %#x", len(events), alg)
+			}
+			d, err := readN(r, uint32(size))
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading event %d: %w", len(events), err)
+			}
+			if alg == sha256AlgID {
+				digest = d
+			}
+		}
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, nil, fmt.Errorf("reading event %d: %w", len(events), err)
+		}
+		data, err := readN(r, size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading event %d: %w", len(events), err)
+		}
+		if digest == nil {
+			return nil, nil, fmt.Errorf("event %d: missing SHA-256 digest", len(events))
+		}
+
+		pcr := int(eventHeader.PCR)
+		events = append(events, Event{PCR: pcr, Type: eventHeader.Type, Digest: digest, Data: data})
+		if eventHeader.Type == evNoAction {
+			// A startup locality event sets the initial value of PCR 0
+			if pcr == 0 && len(data) == len(startupLocality)+1 && string(data[:len(startupLocality)]) == startupLocality {
+				initial := make([]byte, sha256.Size)
+				initial[sha256.Size-1] = data[len(startupLocality)]
+				pcrs[0] = initial
+			}
+			continue
+		}
+
+		value, ok := pcrs[pcr]
+		if !ok {
+			value = make([]byte, sha256.Size)
+		}
+		extended := sha256.Sum256(append(value, digest...))
+		pcrs[pcr] = extended[:]
+	}
+	return events, pcrs, nil
+}
+
+// parseSpecIDEvent returns the digest sizes of each algorithm in the log.
+func parseSpecIDEvent(data []byte) (map[uint16]uint16, error) {
+	r := bytes.NewReader(data)
+	var specID struct {
+		Signature     [16]byte
+		PlatformClass uint32
+		VersionMinor  uint8
+		VersionMajor  uint8
+		Errata        uint8
+		UintnSize     uint8
+		NumAlgorithms uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &specID); err != nil {
+		return nil, fmt.Errorf("reading spec ID event: %w", err)
+	}
+	if string(specID.Signature[:]) != specIDEventSignature {
+		return nil, errors.New("event log is not in the crypto-agile format")
+	}
+	sizes := make(map[uint16]uint16, specID.NumAlgorithms)
+	for range specID.NumAlgorithms {
+		var alg struct{ ID, Size uint16 }
+		if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+			return nil, fmt.Errorf("reading spec ID event: %w", err)
+		}
+		sizes[alg.ID] = alg.Size
+	}
+	return sizes, nil
+}
+
+// readN reads exactly n bytes, without allocating more than remain in r.
+func readN(r *bytes.Reader, n uint32) ([]byte, error) {
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,265 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultNVSize is the size of each NV index used to store a device
+// credential when NVStore.Size is zero.
+const DefaultNVSize = 1024
+
+// nvChunkSize is the number of octets read or written with a single command.
+// It is no larger than the minimum MAX_NV_BUFFER_SIZE of the PC Client
+// Platform TPM Profile.
+const nvChunkSize = 512
+
+// NVStore implements [fdo.CredentialStore] using TPM NV indices owned by the
+// storage hierarchy. Only the non-secret device credential is stored in NV;
+// the device secret and key are derived from the TPM seeds.
+//
+// Three consecutive NV indices are used: Index holds a single byte selecting
+// the active slot and Index+1 and Index+2 hold the credential slots. Stage
+// writes to the inactive slot and Commit switches the selector with a single,
+// atomic write.
+//
+// HMACs and Signer return values implement io.Closer and must be closed to
+// avoid TPM resource exhaustion.
+type NVStore struct {
+	TPM TPM
+
+	// First of three consecutive NV indices.
+	Index uint32
+
+	// Size of each credential slot. If zero, DefaultNVSize is used.
+	Size uint16
+
+	// KeyType selects the device key to derive from the TPM seed.
+	KeyType protocol.KeyType
+
+	// Slot of the staged credential (1 or 2) or zero if none
+	staged uint8
+}
+
+var _ fdo.CredentialStore = (*NVStore)(nil)
+
+// Save persists the device credential as the active credential, such as after
+// DI. NV indices are defined as needed.
+func (s *NVStore) Save(dc *DeviceCredential) error {
+	data, err := cbor.Marshal(dc)
+	if err != nil {
+		return fmt.Errorf("error encoding device credential: %w", err)
+	}
+	if err := s.writeIndex(s.Index+1, data, s.size()); err != nil {
+		return err
+	}
+	if err := s.writeIndex(s.Index, []byte{1}, 1); err != nil {
+		return err
+	}
+	s.staged = 0
+	return nil
+}
+
+// Load returns the active TPM device credential.
+func (s *NVStore) Load() (*DeviceCredential, error) {
+	slot, err := s.activeSlot()
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.readIndex(s.Index+uint32(slot), s.size())
+	if err != nil {
+		return nil, err
+	}
+	var dc DeviceCredential
+	if err := cbor.NewDecoder(bytes.NewReader(data)).Decode(&dc); err != nil {
+		return nil, fmt.Errorf("error decoding device credential: %w", err)
+	}
+	return &dc, nil
+}
+
+// Read returns the active device credential.
+func (s *NVStore) Read() (*fdo.DeviceCredential, error) {
+	dc, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &dc.DeviceCredential, nil
+}
+
+// HMACs returns HMAC-SHA256 and HMAC-SHA384 hashes keyed by the TPM.
+func (s *NVStore) HMACs() (hmacSha256, hmacSha384 hash.Hash, _ error) {
+	h256, err := NewHmac(s.TPM, crypto.SHA256)
+	if err != nil {
+		return nil, nil, err
+	}
+	h384, err := NewHmac(s.TPM, crypto.SHA384)
+	if err != nil {
+		_ = h256.Close()
+		return nil, nil, err
+	}
+	return h256, h384, nil
+}
+
+// Signer returns the device key for the configured key type.
+func (s *NVStore) Signer() (crypto.Signer, error) {
+	switch s.KeyType {
+	case protocol.Secp256r1KeyType:
+		return GenerateECKey(s.TPM, elliptic.P256())
+	case protocol.Secp384r1KeyType:
+		return GenerateECKey(s.TPM, elliptic.P384())
+	case protocol.Rsa2048RestrKeyType, protocol.RsaPkcsKeyType:
+		return GenerateRSAKey(s.TPM, 2048)
+	case protocol.RsaPssKeyType:
+		return GenerateRSAPSSKey(s.TPM, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", s.KeyType)
+	}
+}
+
+// Stage persists a replacement device credential to the inactive slot.
+func (s *NVStore) Stage(cred fdo.DeviceCredential) error {
+	dc, err := s.Load()
+	if err != nil {
+		return err
+	}
+	dc.DeviceCredential = cred
+	data, err := cbor.Marshal(dc)
+	if err != nil {
+		return fmt.Errorf("error encoding device credential: %w", err)
+	}
+
+	active, err := s.activeSlot()
+	if err != nil {
+		return err
+	}
+	inactive := 3 - active
+	if err := s.writeIndex(s.Index+uint32(inactive), data, s.size()); err != nil {
+		return fmt.Errorf("error staging device credential: %w", err)
+	}
+	s.staged = inactive
+	return nil
+}
+
+// Commit makes the staged credential active.
+func (s *NVStore) Commit() error {
+	if s.staged == 0 {
+		return errors.New("no staged device credential")
+	}
+	if err := s.writeIndex(s.Index, []byte{s.staged}, 1); err != nil {
+		return fmt.Errorf("error committing device credential: %w", err)
+	}
+	s.staged = 0
+	return nil
+}
+
+func (s *NVStore) size() uint16 {
+	if s.Size == 0 {
+		return DefaultNVSize
+	}
+	return s.Size
+}
+
+func (s *NVStore) activeSlot() (uint8, error) {
+	sel, err := s.readIndex(s.Index, 1)
+	if err != nil {
+		return 0, err
+	}
+	if sel[0] != 1 && sel[0] != 2 {
+		return 0, fmt.Errorf("invalid active credential slot %d", sel[0])
+	}
+	return sel[0], nil
+}
+
+// writeIndex writes data to an NV index, defining it with the given size if
+// it does not exist.
+func (s *NVStore) writeIndex(index uint32, data []byte, size uint16) error {
+	if len(data) > int(size) {
+		return fmt.Errorf("encoded data of %d bytes exceeds NV index size %d", len(data), size)
+	}
+	handle, err := s.nvHandle(index)
+	if err != nil {
+		if handle, err = s.defineIndex(index, size); err != nil {
+			return err
+		}
+	}
+	for offset := 0; offset < len(data); offset += nvChunkSize {
+		chunk := data[offset:min(offset+nvChunkSize, len(data))]
+		if _, err := (tpm2.NVWrite{
+			AuthHandle: tpm2.TPMRHOwner,
+			NVIndex:    *handle,
+			Data:       tpm2.TPM2BMaxNVBuffer{Buffer: chunk},
+			Offset:     uint16(offset),
+		}).Execute(s.TPM); err != nil {
+			return fmt.Errorf("error writing NV index %#x: %w", index, err)
+		}
+	}
+	return nil
+}
+
+// readIndex reads size bytes from an NV index.
+func (s *NVStore) readIndex(index uint32, size uint16) ([]byte, error) {
+	handle, err := s.nvHandle(index)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, size)
+	for offset := 0; offset < int(size); offset += nvChunkSize {
+		resp, err := (tpm2.NVRead{
+			AuthHandle: tpm2.TPMRHOwner,
+			NVIndex:    *handle,
+			Size:       uint16(min(nvChunkSize, int(size)-offset)),
+			Offset:     uint16(offset),
+		}).Execute(s.TPM)
+		if err != nil {
+			return nil, fmt.Errorf("error reading NV index %#x: %w", index, err)
+		}
+		data = append(data, resp.Data.Buffer...)
+	}
+	return data, nil
+}
+
+func (s *NVStore) nvHandle(index uint32) (*tpm2.NamedHandle, error) {
+	resp, err := (tpm2.NVReadPublic{NVIndex: tpm2.TPMHandle(index)}).Execute(s.TPM)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public area of NV index %#x: %w", index, err)
+	}
+	return &tpm2.NamedHandle{Handle: tpm2.TPMHandle(index), Name: resp.NVName}, nil
+}
+
+func (s *NVStore) defineIndex(index uint32, size uint16) (*tpm2.NamedHandle, error) {
+	pub := tpm2.TPMSNVPublic{
+		NVIndex: tpm2.TPMHandle(index),
+		NameAlg: tpm2.TPMAlgSHA256,
+		Attributes: tpm2.TPMANV{
+			OwnerWrite: true,
+			OwnerRead:  true,
+			NT:         tpm2.TPMNTOrdinary,
+			NoDA:       true,
+		},
+		DataSize: size,
+	}
+	if _, err := (tpm2.NVDefineSpace{
+		AuthHandle: tpm2.TPMRHOwner,
+		PublicInfo: tpm2.New2B(pub),
+	}).Execute(s.TPM); err != nil {
+		return nil, fmt.Errorf("error defining NV index %#x: %w", index, err)
+	}
+	name, err := tpm2.NVName(&pub)
+	if err != nil {
+		return nil, fmt.Errorf("error computing name of NV index %#x: %w", index, err)
+	}
+	return &tpm2.NamedHandle{Handle: tpm2.TPMHandle(index), Name: *name}, nil
+}
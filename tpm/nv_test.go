@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package tpm_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/tpm"
+)
+
+func TestNVStore(t *testing.T) {
+	sim, err := simulator.OpenSimulator()
+	if err != nil {
+		t.Fatalf("error opening opening TPM simulator: %v", err)
+	}
+	defer func() {
+		if err := sim.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	store := &tpm.NVStore{
+		TPM:     sim,
+		Index:   0x01c20000,
+		KeyType: protocol.Secp256r1KeyType,
+	}
+	if err := store.Save(&tpm.DeviceCredential{
+		DeviceCredential: fdo.DeviceCredential{
+			Version:    101,
+			DeviceInfo: "initial",
+			GUID:       protocol.GUID{1},
+		},
+		DeviceKey: tpm.FdoDeviceKey,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Staging must not affect the active credential
+	if err := store.Stage(fdo.DeviceCredential{
+		Version:    101,
+		DeviceInfo: "replacement",
+		GUID:       protocol.GUID{2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cred, err := store.Read(); err != nil {
+		t.Fatal(err)
+	} else if cred.GUID != (protocol.GUID{1}) {
+		t.Fatalf("expected initial credential before commit, got GUID %x", cred.GUID)
+	}
+
+	// Commit activates the staged credential
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(); err == nil {
+		t.Fatal("expected error committing without a staged credential")
+	}
+	dc, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.GUID != (protocol.GUID{2}) || dc.DeviceInfo != "replacement" {
+		t.Fatalf("expected replacement credential after commit, got %v", dc)
+	}
+	if dc.DeviceKey != tpm.FdoDeviceKey {
+		t.Fatalf("expected device key type to be retained, got %d", dc.DeviceKey)
+	}
+
+	// Secrets are derived from the TPM
+	h256, h384, err := store.HMACs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = h256.(tpm.Hmac).Close() }()
+	defer func() { _ = h384.(tpm.Hmac).Close() }()
+	key, err := store.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = key.(tpm.Key).Close() }()
+	digest := sha256.Sum256([]byte("hello world"))
+	sig, err := key.Sign(nil, digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(key.Public().(*ecdsa.PublicKey), digest[:], sig) {
+		t.Fatal("signature verification failed")
+	}
+}
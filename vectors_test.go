@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/testvectors"
+)
+
+// TestMessagesMatchVectors decodes the golden encoding of each message into
+// the type used by the implementation and encodes it again, so that the
+// message definitions of package testvectors cannot drift from those of the
+// implementation. Messages whose bodies are anonymous structs of a single
+// field are not checked.
+func TestMessagesMatchVectors(t *testing.T) {
+	types := map[string]any{
+		"DI.SetCredentials":          fdo.SetCredentialsMsg{},
+		"TO0.HelloAck":               fdo.To0Ack{},
+		"TO0.OwnerSign":              fdo.OwnerSign{},
+		"TO0.AcceptOwner":            fdo.To0AcceptOwner{},
+		"TO1.HelloRV":                fdo.HelloRV{},
+		"TO1.HelloRVAck":             fdo.RvAck{},
+		"TO2.HelloDevice":            fdo.HelloDeviceMsg{},
+		"TO2.ProveOVHdr":             cose.Sign1Tag[fdo.OvhProof, []byte]{},
+		"TO2.OVNextEntry":            fdo.OvEntry{},
+		"TO2.SetupDevice":            cose.Sign1Tag[fdo.DeviceSetup, []byte]{},
+		"TO2.DeviceServiceInfoReady": fdo.DeviceServiceInfoReady{},
+		"TO2.OwnerServiceInfoReady":  fdo.OwnerServiceInfoReady{},
+		"TO2.DeviceServiceInfo":      fdo.DeviceServiceInfo{},
+		"TO2.OwnerServiceInfo":       fdo.OwnerServiceInfo{},
+		"TO2.Done":                   fdo.DoneMsg{},
+		"TO2.Done2":                  fdo.Done2Msg{},
+	}
+
+	for _, v := range testvectors.Vectors() {
+		typ, ok := types[v.Name]
+		if !ok {
+			continue
+		}
+		delete(types, v.Name)
+		t.Run(v.Name, func(t *testing.T) {
+			decoded := reflect.New(reflect.TypeOf(typ))
+			if err := cbor.Unmarshal(v.CBOR, decoded.Interface()); err != nil {
+				t.Fatalf("error decoding into %T: %v", typ, err)
+			}
+			got, err := cbor.Marshal(decoded.Elem().Interface())
+			if err != nil {
+				t.Fatalf("error encoding %T: %v", typ, err)
+			}
+			if !bytes.Equal(got, v.CBOR) {
+				t.Fatalf("encoding of %T does not match golden\ngot:  %x\nwant: %x", typ, got, v.CBOR)
+			}
+		})
+	}
+	for name := range types {
+		t.Errorf("no vector for message %s", name)
+	}
+}
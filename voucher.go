@@ -5,6 +5,7 @@ package fdo
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -16,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"maps"
 	"slices"
 
 	"github.com/fido-device-onboard/go-fdo/cbor"
@@ -196,7 +198,13 @@ func (v *Voucher) OwnerPublicKey() (crypto.PublicKey, error) {
 // VerifyHeader checks that the OVHeader was not modified by comparing the HMAC
 // generated using the secret from the device credentials.
 func (v *Voucher) VerifyHeader(hmacSha256, hmacSha384 hash.Hash) error {
-	return hmacVerify(hmacSha256, hmacSha384, v.Hmac, &v.Header.Val)
+	return v.VerifyHeaderHmac(context.Background(), deviceHmacOrLocal(nil, hmacSha256, hmacSha384))
+}
+
+// VerifyHeaderHmac is like VerifyHeader, but the HMAC may be computed
+// externally, i.e. by a remote service holding the device secret.
+func (v *Voucher) VerifyHeaderHmac(ctx context.Context, h DeviceHmac) error {
+	return hmacVerify(ctx, h, v.Hmac, &v.Header.Val)
 }
 
 // VerifyDeviceCertChain using trusted roots. If roots is nil then the last
@@ -392,6 +400,23 @@ func (e *VoucherEntryPayload) VerifyOwnerCertChain(roots *x509.CertPool) error {
 	return verifyCertChain(chain, roots)
 }
 
+// verifyOwnerKeyChain requires an owner public key to be X5Chain encoded with
+// a certificate chain to one of the trusted roots. Any key is accepted if roots
+// is nil.
+func verifyOwnerKeyChain(pub *protocol.PublicKey, roots *x509.CertPool) error {
+	if roots == nil {
+		return nil
+	}
+	chain, err := pub.Chain()
+	if err != nil {
+		return fmt.Errorf("error parsing owner public key: %w", err)
+	}
+	if chain == nil {
+		return fmt.Errorf("%w: owner public key could not be verified against trusted roots, because it was not an X5Chain", ErrCryptoVerifyFailed)
+	}
+	return verifyCertChain(chain, roots)
+}
+
 func verifyCertChain(chain []*x509.Certificate, roots *x509.CertPool) error {
 	// All all intermediates (if any) to a pool
 	intermediates := x509.NewCertPool()
@@ -418,6 +443,26 @@ func verifyCertChain(chain []*x509.Certificate, roots *x509.CertPool) error {
 	return nil
 }
 
+// VoucherEntrySigner is an owner key which signs whole voucher entries rather
+// than digests, such as a key held by a signing service which must know what
+// it signs. ExtendVoucher uses SignVoucherEntry when the owner key implements
+// it.
+type VoucherEntrySigner interface {
+	crypto.Signer
+
+	// SignVoucherEntry signs the payload of the next entry of the voucher
+	// of a device. The GUID and device info are those of the voucher
+	// header, which the header hash of the payload covers.
+	SignVoucherEntry(guid protocol.GUID, deviceInfo string, usePSS bool, payload VoucherEntryPayload) (*cose.Sign1Tag[VoucherEntryPayload, []byte], error)
+}
+
+// SignVoucherEntry signs a voucher entry payload with an owner key, as
+// ExtendVoucher does. It is for implementations of VoucherEntrySigner which
+// hold the key.
+func SignVoucherEntry(owner crypto.Signer, usePSS bool, payload VoucherEntryPayload) (*cose.Sign1Tag[VoucherEntryPayload, []byte], error) {
+	return newSignedEntry(owner, usePSS, payload)
+}
+
 // ExtendVoucher adds a new signed voucher entry to the list and returns the
 // new extended voucher. Vouchers should be treated as immutable structures.
 //
@@ -474,12 +519,19 @@ func ExtendVoucher[T protocol.PublicKeyOrChain](v *Voucher, owner crypto.Signer,
 		return nil, fmt.Errorf("owner key for signing does not match the last signature of the voucher to be extended")
 	}
 
-	// Create the next owner PublicKey structure
-	asCOSE := v.Header.Val.ManufacturerKey.Encoding == protocol.CoseKeyEnc
-	if _, ok := any(nextOwner).([]*x509.Certificate); ok {
-		asCOSE = false
+	// Create the next owner PublicKey structure, using the encoding of the
+	// manufacturer key unless it is X5Chain and no chain was given
+	keyType, keyEncoding := v.Header.Val.ManufacturerKey.Type, v.Header.Val.ManufacturerKey.Encoding
+	var nextOwnerPublicKey *protocol.PublicKey
+	switch next := any(nextOwner).(type) {
+	case []*x509.Certificate:
+		nextOwnerPublicKey, err = protocol.PublicKeyFromChain(keyType, protocol.X5ChainKeyEnc, next)
+	default:
+		if keyEncoding == protocol.X5ChainKeyEnc {
+			keyEncoding = protocol.X509KeyEnc
+		}
+		nextOwnerPublicKey, err = protocol.PublicKeyFromCrypto(keyType, keyEncoding, next)
 	}
-	nextOwnerPublicKey, err := protocol.NewPublicKey(v.Header.Val.ManufacturerKey.Type, nextOwner, asCOSE)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling next owner public key: %w", err)
 	}
@@ -516,12 +568,18 @@ func ExtendVoucher[T protocol.PublicKeyOrChain](v *Voucher, owner crypto.Signer,
 
 	// Create and sign next entry
 	usePSS := v.Header.Val.ManufacturerKey.Type == protocol.RsaPssKeyType
-	entry, err := newSignedEntry(owner, usePSS, VoucherEntryPayload{
+	payload := VoucherEntryPayload{
 		PreviousHash: prevHash,
 		HeaderHash:   headerHash,
 		Extra:        cbor.NewBstr(extra),
 		PublicKey:    *nextOwnerPublicKey,
-	})
+	}
+	var entry *cose.Sign1Tag[VoucherEntryPayload, []byte]
+	if entrySigner, ok := owner.(VoucherEntrySigner); ok {
+		entry, err = entrySigner.SignVoucherEntry(v.Header.Val.GUID, v.Header.Val.DeviceInfo, usePSS, payload)
+	} else {
+		entry, err = newSignedEntry(owner, usePSS, payload)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -529,6 +587,62 @@ func ExtendVoucher[T protocol.PublicKeyOrChain](v *Voucher, owner crypto.Signer,
 	return xv, nil
 }
 
+// CounterSignVoucher returns a copy of the voucher with its last entry
+// countersigned by a notary, such as an auditor that must attest to each
+// transfer of ownership.
+//
+// Countersignatures are carried in the unprotected header of the entry, so the
+// owner signature is unaffected. However, the next entry contains a hash of
+// the whole previous entry, so the voucher must be countersigned immediately
+// after extending it and before it is extended again.
+func CounterSignVoucher(v *Voucher, notary crypto.Signer) (*Voucher, error) {
+	if len(v.Entries) == 0 {
+		return nil, fmt.Errorf("voucher has no entries to countersign")
+	}
+	usePSS := v.Header.Val.ManufacturerKey.Type == protocol.RsaPssKeyType
+	signOpts, err := signOptsFor(notary, usePSS)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the last entry and its unprotected header so that the original
+	// voucher is not modified
+	entry := v.Entries[len(v.Entries)-1]
+	entry.Unprotected = maps.Clone(entry.Unprotected)
+	if err := entry.CounterSign(notary, nil, nil, signOpts); err != nil {
+		return nil, fmt.Errorf("error countersigning voucher entry: %w", err)
+	}
+
+	xv := v.shallowClone()
+	xv.Entries = append(slices.Clone(v.Entries[:len(v.Entries)-1]), entry)
+	return xv, nil
+}
+
+// VerifyEntryCounterSignature checks that the entry at index i has a valid
+// countersignature from at least one of the notary keys. It does not verify
+// the entry itself.
+func (v *Voucher) VerifyEntryCounterSignature(i int, notaries []crypto.PublicKey) error {
+	if i < 0 || i >= len(v.Entries) {
+		return fmt.Errorf("voucher has no entry %d", i)
+	}
+	entry := v.Entries[i].Untag()
+	counterSigs, err := entry.CounterSignatures()
+	if err != nil {
+		return err
+	}
+	if len(counterSigs) == 0 {
+		return fmt.Errorf("entry is not countersigned")
+	}
+	for _, counterSig := range counterSigs {
+		for _, notary := range notaries {
+			if ok, err := entry.VerifyCounterSignature(counterSig, notary, nil, nil); err == nil && ok {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: no countersignature matched a notary key", ErrCryptoVerifyFailed)
+}
+
 // hashAlgFor determines the appropriate hash algorithm to use based on the
 // table in section 3.2.2 of the FDO spec
 func hashAlgFor(devicePubKey, ownerPubKey crypto.PublicKey) (protocol.HashAlg, error) {
@@ -563,7 +677,14 @@ func hashSizeForPubKey(pubKey crypto.PublicKey) (int, error) {
 		}
 
 	case *rsa.PublicKey:
-		return key.Size(), nil
+		switch bits := key.N.BitLen(); bits {
+		case 2048:
+			return 256, nil
+		case 3072:
+			return 384, nil
+		default:
+			return 0, fmt.Errorf("unsupported RSA key size: %d", bits)
+		}
 
 	default:
 		return 0, fmt.Errorf("unsupported key type: %T", key)
@@ -11,6 +11,7 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -19,6 +20,7 @@ import (
 	"github.com/fido-device-onboard/go-fdo/blob"
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
@@ -206,3 +208,66 @@ func TestVerifyExtendedVoucher(t *testing.T) {
 		t.Errorf("error verifying voucher entries: %v", err)
 	}
 }
+
+func TestVoucherFixtures(t *testing.T) {
+	for _, keyType := range []protocol.KeyType{
+		protocol.Secp256r1KeyType,
+		protocol.Secp384r1KeyType,
+		protocol.Rsa2048RestrKeyType,
+		protocol.RsaPkcsKeyType,
+		protocol.RsaPssKeyType,
+	} {
+		for _, keyEncoding := range []protocol.KeyEncoding{protocol.X509KeyEnc, protocol.X5ChainKeyEnc} {
+			// Long resale chains are only tested with EC keys, because RSA key
+			// generation is slow
+			entryCounts := []int{0, 1, 16}
+			if keyType != protocol.Secp256r1KeyType && keyType != protocol.Secp384r1KeyType {
+				entryCounts = []int{0, 2}
+			}
+			for _, entries := range entryCounts {
+				t.Run(fmt.Sprintf("%s %s %d entries", keyType, keyEncoding, entries), func(t *testing.T) {
+					f, err := fdotest.NewFixture(fdotest.FixtureOptions{
+						KeyType:     keyType,
+						KeyEncoding: keyEncoding,
+						Entries:     entries,
+						DeviceInfo:  "fixture",
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					ov := f.Voucher
+					if len(ov.Entries) != entries {
+						t.Fatalf("expected %d entries, got %d", entries, len(ov.Entries))
+					}
+					if err := ov.VerifyHeader(f.HmacSha256(), f.HmacSha384()); err != nil {
+						t.Fatal(err)
+					}
+					if err := ov.VerifyCertChainHash(); err != nil {
+						t.Fatal(err)
+					}
+					if err := ov.VerifyDeviceCertChain(nil); err != nil {
+						t.Fatal(err)
+					}
+					if err := ov.VerifyManufacturerKey(f.Cred.PublicKeyHash); err != nil {
+						t.Fatal(err)
+					}
+					if err := ov.VerifyEntries(); err != nil {
+						t.Fatal(err)
+					}
+					owner, err := ov.OwnerPublicKey()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !f.OwnerKey().Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(owner) {
+						t.Fatal("owner key of fixture does not match voucher")
+					}
+					if pub, err := ov.DevicePublicKey(); err != nil {
+						t.Fatal(err)
+					} else if !f.DeviceKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+						t.Fatal("device key of fixture does not match voucher")
+					}
+				})
+			}
+		}
+	}
+}
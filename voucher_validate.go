@@ -0,0 +1,359 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// VoucherCheckStatus is the outcome of a single check of a VoucherReport.
+type VoucherCheckStatus string
+
+// Voucher check outcomes
+const (
+	VoucherCheckPassed  VoucherCheckStatus = "passed"
+	VoucherCheckFailed  VoucherCheckStatus = "failed"
+	VoucherCheckSkipped VoucherCheckStatus = "skipped"
+)
+
+// VoucherCheck is the result of a single check of a voucher. Err is set only
+// when the check failed.
+type VoucherCheck struct {
+	Status VoucherCheckStatus
+	Err    error
+}
+
+func checkPassed() VoucherCheck          { return VoucherCheck{Status: VoucherCheckPassed} }
+func checkSkipped() VoucherCheck         { return VoucherCheck{Status: VoucherCheckSkipped} }
+func checkFailed(err error) VoucherCheck { return VoucherCheck{Status: VoucherCheckFailed, Err: err} }
+
+// checkResult returns a passed check if err is nil and a failed one otherwise.
+func checkResult(err error) VoucherCheck {
+	if err != nil {
+		return checkFailed(err)
+	}
+	return checkPassed()
+}
+
+// MarshalJSON encodes the check as an object with a status and, if failed, an
+// error string.
+func (c VoucherCheck) MarshalJSON() ([]byte, error) {
+	var msg struct {
+		Status VoucherCheckStatus `json:"status"`
+		Error  string             `json:"error,omitempty"`
+	}
+	msg.Status = c.Status
+	if c.Err != nil {
+		msg.Error = c.Err.Error()
+	}
+	return json.Marshal(msg)
+}
+
+// VoucherEntryReport contains the results of checking a single voucher entry.
+type VoucherEntryReport struct {
+	// Signature is whether the entry is signed by the previous owner.
+	Signature VoucherCheck `json:"signature"`
+
+	// HeaderHash is whether the entry's hash of the GUID and device info
+	// matches the voucher header.
+	HeaderHash VoucherCheck `json:"header_hash"`
+
+	// PreviousHash is whether the entry's hash of the previous entry, or of
+	// the header and its HMAC for the first entry, matches.
+	PreviousHash VoucherCheck `json:"previous_hash"`
+
+	// PublicKey is whether the entry's owner public key can be parsed.
+	PublicKey VoucherCheck `json:"public_key"`
+
+	// CounterSignature is whether the entry is countersigned by a notary. It
+	// is skipped unless notary keys are given.
+	CounterSignature VoucherCheck `json:"counter_signature"`
+}
+
+// VoucherReport contains the result of each check performed by
+// Voucher.Validate, so that invalid vouchers in a bulk import can be triaged.
+type VoucherReport struct {
+	GUID protocol.GUID `json:"guid"`
+
+	// Header is whether the header has a supported version and a parsable
+	// manufacturer public key.
+	Header VoucherCheck `json:"header"`
+
+	// HeaderHMAC is whether the header HMAC matches the device secret. It is
+	// skipped unless the secret is available, i.e. to the manufacturer.
+	HeaderHMAC VoucherCheck `json:"header_hmac"`
+
+	// CertChain is whether the device certificate chain matches its hash in
+	// the header and is valid. It is skipped for vouchers without a device
+	// certificate chain.
+	CertChain VoucherCheck `json:"cert_chain"`
+
+	// Entries contains the result of checking each voucher entry, in order.
+	Entries []VoucherEntryReport `json:"entries"`
+
+	// Owner is whether the owner of the voucher is one of the owner keys. It
+	// is skipped unless owner keys are given.
+	Owner VoucherCheck `json:"owner"`
+
+	// GUIDCollision is whether no voucher with the same GUID is already
+	// stored. It is skipped unless a voucher store is given.
+	GUIDCollision VoucherCheck `json:"guid_collision"`
+}
+
+// MarshalJSON encodes the report as an object with the GUID in hex.
+func (r VoucherReport) MarshalJSON() ([]byte, error) {
+	type report VoucherReport
+	return json.Marshal(struct {
+		GUID string `json:"guid"`
+		report
+	}{
+		GUID:   hex.EncodeToString(r.GUID[:]),
+		report: report(r),
+	})
+}
+
+// Valid reports whether no check failed.
+func (r *VoucherReport) Valid() bool { return r.Err() == nil }
+
+// Err returns the errors of all failed checks joined, or nil if none failed.
+func (r *VoucherReport) Err() error {
+	var errs []error
+	add := func(name string, c VoucherCheck) {
+		if c.Status == VoucherCheckFailed {
+			errs = append(errs, fmt.Errorf("%s: %w", name, c.Err))
+		}
+	}
+	add("header", r.Header)
+	add("header HMAC", r.HeaderHMAC)
+	add("device certificate chain", r.CertChain)
+	for i, entry := range r.Entries {
+		add(fmt.Sprintf("entry %d signature", i), entry.Signature)
+		add(fmt.Sprintf("entry %d header hash", i), entry.HeaderHash)
+		add(fmt.Sprintf("entry %d previous hash", i), entry.PreviousHash)
+		add(fmt.Sprintf("entry %d public key", i), entry.PublicKey)
+		add(fmt.Sprintf("entry %d countersignature", i), entry.CounterSignature)
+	}
+	add("owner", r.Owner)
+	add("GUID", r.GUIDCollision)
+	return errors.Join(errs...)
+}
+
+// VoucherValidateOptions configures the optional checks of Voucher.Validate.
+type VoucherValidateOptions struct {
+	// HmacSha256 and HmacSha384, if set, are the device secrets used to check
+	// the header HMAC.
+	HmacSha256 hash.Hash
+	HmacSha384 hash.Hash
+
+	// DeviceHmac, if set, is used to check the header HMAC in place of
+	// HmacSha256 and HmacSha384.
+	DeviceHmac DeviceHmac
+
+	// DeviceCertPolicy, if set, is used to validate the device certificate
+	// chain. Otherwise, the last certificate of the chain is trusted.
+	DeviceCertPolicy *DeviceCertPolicy
+
+	// OwnerKeys, if set, must contain the owner key of the voucher, including
+	// previous keys if it implements OwnerKeyRotationPersistentState.
+	OwnerKeys OwnerKeyPersistentState
+
+	// Vouchers, if set, is checked for an existing voucher with the same GUID.
+	Vouchers OwnerVoucherPersistentState
+
+	// Notaries, if set, are the keys of which at least one must have
+	// countersigned every voucher entry.
+	Notaries []crypto.PublicKey
+}
+
+// Validate performs every check of a voucher, continuing after failures, and
+// returns a report of the result of each. Unlike the Verify methods, this
+// identifies every problem with a voucher rather than only the first.
+func (v *Voucher) Validate(ctx context.Context, opts VoucherValidateOptions) *VoucherReport {
+	report := &VoucherReport{
+		GUID:          v.Header.Val.GUID,
+		HeaderHMAC:    checkSkipped(),
+		CertChain:     checkSkipped(),
+		Owner:         checkSkipped(),
+		GUIDCollision: checkSkipped(),
+	}
+
+	// Check header
+	mfgPubKey, err := v.Header.Val.ManufacturerKey.Public()
+	switch {
+	case !protocol.SupportedVersion(v.Header.Val.Version):
+		report.Header = checkFailed(fmt.Errorf("unsupported protocol version %d", v.Header.Val.Version))
+	case err != nil:
+		report.Header = checkFailed(fmt.Errorf("error parsing manufacturer public key: %w", err))
+	default:
+		report.Header = checkPassed()
+	}
+	if opts.DeviceHmac != nil || opts.HmacSha256 != nil {
+		if !supportedHashAlg(v.Hmac.Algorithm) {
+			report.HeaderHMAC = checkFailed(fmt.Errorf("unsupported hash algorithm %d", v.Hmac.Algorithm))
+		} else {
+			h := deviceHmacOrLocal(opts.DeviceHmac, opts.HmacSha256, opts.HmacSha384)
+			report.HeaderHMAC = checkResult(v.VerifyHeaderHmac(ctx, h))
+		}
+	}
+
+	// Check device certificate chain
+	if v.CertChain != nil || v.Header.Val.CertChainHash != nil {
+		report.CertChain = checkResult(v.validateCertChain(ctx, opts.DeviceCertPolicy))
+	}
+
+	// Check entries
+	report.Entries = v.validateEntries(mfgPubKey)
+	for i := range report.Entries {
+		if len(opts.Notaries) == 0 {
+			report.Entries[i].CounterSignature = checkSkipped()
+			continue
+		}
+		report.Entries[i].CounterSignature = checkResult(v.VerifyEntryCounterSignature(i, opts.Notaries))
+	}
+
+	// Check owner and GUID against service state
+	if opts.OwnerKeys != nil {
+		report.Owner = checkResult(v.validateOwner(ctx, opts.OwnerKeys))
+	}
+	if opts.Vouchers != nil {
+		_, err := opts.Vouchers.Voucher(ctx, v.Header.Val.GUID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			report.GUIDCollision = checkPassed()
+		case err != nil:
+			report.GUIDCollision = checkFailed(fmt.Errorf("error looking up voucher: %w", err))
+		default:
+			report.GUIDCollision = checkFailed(fmt.Errorf("voucher for %x already exists", v.Header.Val.GUID))
+		}
+	}
+
+	return report
+}
+
+func (v *Voucher) validateCertChain(ctx context.Context, policy *DeviceCertPolicy) error {
+	if cchash := v.Header.Val.CertChainHash; cchash != nil && !supportedHashAlg(cchash.Algorithm) {
+		return fmt.Errorf("unsupported hash algorithm %d", cchash.Algorithm)
+	}
+	if err := v.VerifyCertChainHash(); err != nil {
+		return err
+	}
+	if policy != nil {
+		return policy.Verify(ctx, v).Err
+	}
+	return v.VerifyDeviceCertChain(nil)
+}
+
+func (v *Voucher) validateOwner(ctx context.Context, keys OwnerKeyPersistentState) error {
+	owner, err := v.OwnerPublicKey()
+	if err != nil {
+		return fmt.Errorf("error parsing owner public key: %w", err)
+	}
+	if _, _, err := OwnerKeyFor(ctx, keys, v.Header.Val.ManufacturerKey.Type, owner); errors.Is(err, ErrNotFound) {
+		return errors.New("owner key does not match the owner of the voucher")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateEntries checks each entry independently, so that a bad entry does
+// not prevent later entries from being checked. Signatures are skipped when
+// the previous owner key could not be parsed.
+func (v *Voucher) validateEntries(mfgPubKey crypto.PublicKey) []VoucherEntryReport {
+	if len(v.Entries) == 0 {
+		return nil
+	}
+	reports := make([]VoucherEntryReport, len(v.Entries))
+
+	// The algorithm used for hashing entries should always match the one used
+	// during the very first extension
+	alg := v.Entries[0].Payload.Val.PreviousHash.Algorithm
+	if alg != protocol.Sha256Hash && alg != protocol.Sha384Hash {
+		err := fmt.Errorf("unsupported hash algorithm %d", alg)
+		for i := range reports {
+			reports[i] = VoucherEntryReport{
+				Signature:    checkSkipped(),
+				HeaderHash:   checkFailed(err),
+				PreviousHash: checkFailed(err),
+				PublicKey:    checkSkipped(),
+			}
+		}
+		return reports
+	}
+	headerInfo := alg.HashFunc().New()
+	_, _ = headerInfo.Write(v.Header.Val.GUID[:])
+	_, _ = headerInfo.Write([]byte(v.Header.Val.DeviceInfo))
+	headerInfoHash := headerInfo.Sum(nil)
+
+	// For entry 0, the previous hash is computed on OVHeader||OVHeaderHMac
+	prevHash := alg.HashFunc().New()
+	_ = cbor.NewEncoder(prevHash).Encode(&v.Header.Val)
+	_ = cbor.NewEncoder(prevHash).Encode(v.Hmac)
+
+	prevOwnerKey := mfgPubKey
+	for i, tagged := range v.Entries {
+		entry := tagged.Untag()
+		report := &reports[i]
+
+		if prevOwnerKey == nil {
+			report.Signature = checkSkipped()
+		} else if ok, err := entry.Verify(prevOwnerKey, nil, nil); err != nil {
+			report.Signature = checkFailed(fmt.Errorf("could not be verified: %w", err))
+		} else if !ok {
+			report.Signature = checkFailed(fmt.Errorf("%w: signature did not match previous owner key", ErrCryptoVerifyFailed))
+		} else {
+			report.Signature = checkPassed()
+		}
+
+		headerHash := entry.Payload.Val.HeaderHash
+		switch {
+		case headerHash.Algorithm != alg:
+			report.HeaderHash = checkFailed(fmt.Errorf("%w: computed with hash algorithm %d instead of %s", ErrCryptoVerifyFailed, headerHash.Algorithm, alg))
+		case !hmac.Equal(headerHash.Value, headerInfoHash):
+			report.HeaderHash = checkFailed(fmt.Errorf("%w: header hash did not match", ErrCryptoVerifyFailed))
+		default:
+			report.HeaderHash = checkPassed()
+		}
+
+		if hmac.Equal(prevHash.Sum(nil), entry.Payload.Val.PreviousHash.Value) {
+			report.PreviousHash = checkPassed()
+		} else {
+			report.PreviousHash = checkFailed(fmt.Errorf("%w: previous hash did not match", ErrCryptoVerifyFailed))
+		}
+
+		ownerKey, err := entry.Payload.Val.PublicKey.Public()
+		if err != nil {
+			report.PublicKey = checkFailed(fmt.Errorf("error parsing public key: %w", err))
+		} else {
+			report.PublicKey = checkPassed()
+		}
+		prevOwnerKey = ownerKey
+
+		// Hash entry for the next iteration
+		prevHash.Reset()
+		_ = cbor.NewEncoder(prevHash).Encode(entry.Tag())
+	}
+
+	return reports
+}
+
+// supportedHashAlg reports whether a hash algorithm from an untrusted voucher
+// is known, so that it may be used without panicking.
+func supportedHashAlg(alg protocol.HashAlg) bool {
+	switch alg {
+	case protocol.Sha256Hash, protocol.Sha384Hash, protocol.HmacSha256Hash, protocol.HmacSha384Hash:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package fdo_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestVoucherValidate(t *testing.T) {
+	f, err := fdotest.NewFixture(fdotest.FixtureOptions{
+		KeyType:    protocol.Secp384r1KeyType,
+		Entries:    3,
+		DeviceInfo: "fixture",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Without service state, only the voucher itself is checked
+	report := f.Voucher.Validate(ctx, fdo.VoucherValidateOptions{})
+	if err := report.Err(); err != nil {
+		t.Fatalf("expected valid voucher: %v", err)
+	}
+	if report.HeaderHMAC.Status != fdo.VoucherCheckSkipped || report.Owner.Status != fdo.VoucherCheckSkipped ||
+		report.GUIDCollision.Status != fdo.VoucherCheckSkipped {
+		t.Fatalf("expected optional checks to be skipped: %+v", report)
+	}
+	if report.CertChain.Status != fdo.VoucherCheckPassed {
+		t.Fatalf("expected device certificate chain check to pass: %+v", report.CertChain)
+	}
+	if len(report.Entries) != 3 {
+		t.Fatalf("expected 3 entry reports, got %d", len(report.Entries))
+	}
+
+	// The header HMAC is checked when the device secret is available
+	report = f.Voucher.Validate(ctx, fdo.VoucherValidateOptions{
+		HmacSha256: f.HmacSha256(),
+		HmacSha384: f.HmacSha384(),
+	})
+	if report.HeaderHMAC.Status != fdo.VoucherCheckPassed {
+		t.Fatalf("expected header HMAC check to pass: %+v", report.HeaderHMAC)
+	}
+
+	// Tampering with an entry is reported for that entry and the next, while
+	// other entries are still checked
+	ov := *f.Voucher
+	ov.Entries = append([]cose.Sign1Tag[fdo.VoucherEntryPayload, []byte](nil), f.Voucher.Entries...)
+	ov.Entries[1].Signature = append([]byte(nil), ov.Entries[1].Signature...)
+	ov.Entries[1].Signature[0] ^= 0xff
+	report = ov.Validate(ctx, fdo.VoucherValidateOptions{})
+	if report.Valid() {
+		t.Fatal("expected voucher with tampered entry to be invalid")
+	}
+	if got := report.Entries[1].Signature.Status; got != fdo.VoucherCheckFailed {
+		t.Errorf("expected entry 1 signature check to fail, got %s", got)
+	}
+	if got := report.Entries[2].PreviousHash.Status; got != fdo.VoucherCheckFailed {
+		t.Errorf("expected entry 2 previous hash check to fail, got %s", got)
+	}
+	for _, i := range []int{0, 2} {
+		if got := report.Entries[i].Signature.Status; got != fdo.VoucherCheckPassed {
+			t.Errorf("expected entry %d signature check to pass, got %s", i, got)
+		}
+	}
+
+	// Reports are encoded as JSON with a hex GUID and error strings
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		GUID    string `json:"guid"`
+		Entries []struct {
+			Signature struct {
+				Status string `json:"status"`
+				Error  string `json:"error"`
+			} `json:"signature"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.GUID != hex.EncodeToString(f.Voucher.Header.Val.GUID[:]) {
+		t.Errorf("unexpected GUID in JSON report: %s", decoded.GUID)
+	}
+	if sig := decoded.Entries[1].Signature; sig.Status != "failed" || sig.Error == "" {
+		t.Errorf("unexpected entry 1 signature in JSON report: %+v", sig)
+	}
+}
+
+func TestVoucherValidateServiceState(t *testing.T) {
+	server := fdotest.NewServer(t)
+	dev := server.NewDevice(t, protocol.Secp256r1KeyType)
+	ov := server.Voucher(t, dev.Cred.GUID)
+
+	// A stored voucher owned by this service collides with itself
+	report := ov.Validate(context.Background(), fdo.VoucherValidateOptions{
+		OwnerKeys: server.State,
+		Vouchers:  server.State,
+	})
+	if report.Owner.Status != fdo.VoucherCheckPassed {
+		t.Errorf("expected owner check to pass: %+v", report.Owner)
+	}
+	if report.GUIDCollision.Status != fdo.VoucherCheckFailed {
+		t.Errorf("expected GUID collision check to fail: %+v", report.GUIDCollision)
+	}
+
+	// A voucher owned by another service fails the owner check
+	f, err := fdotest.NewFixture(fdotest.FixtureOptions{KeyType: protocol.Secp256r1KeyType, Entries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = f.Voucher.Validate(context.Background(), fdo.VoucherValidateOptions{
+		OwnerKeys: server.State,
+		Vouchers:  server.State,
+	})
+	if report.Owner.Status != fdo.VoucherCheckFailed {
+		t.Errorf("expected owner check to fail: %+v", report.Owner)
+	}
+	if report.GUIDCollision.Status != fdo.VoucherCheckPassed {
+		t.Errorf("expected GUID collision check to pass: %+v", report.GUIDCollision)
+	}
+}
+
+func TestVoucherCounterSignature(t *testing.T) {
+	f, err := fdotest.NewFixture(fdotest.FixtureOptions{KeyType: protocol.Secp256r1KeyType, Entries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notary, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextOwner, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Countersign the first entry, then extend and countersign again
+	ov, err := fdo.CounterSignVoucher(f.Voucher, notary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Voucher.VerifyEntryCounterSignature(0, []crypto.PublicKey{notary.Public()}); err == nil {
+		t.Fatal("expected original voucher to be unmodified")
+	}
+	ov, err = fdo.ExtendVoucher(ov, f.OwnerKey(), nextOwner.Public().(*ecdsa.PublicKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov, err = fdo.CounterSignVoucher(ov, notary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ov.VerifyEntries(); err != nil {
+		t.Fatalf("countersignatures must not invalidate entries: %v", err)
+	}
+	report := ov.Validate(context.Background(), fdo.VoucherValidateOptions{
+		Notaries: []crypto.PublicKey{notary.Public()},
+	})
+	if err := report.Err(); err != nil {
+		t.Fatalf("expected valid countersigned voucher: %v", err)
+	}
+
+	// Entries without a countersignature from a notary key fail validation
+	otherNotary, err := fdotest.NewKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = ov.Validate(context.Background(), fdo.VoucherValidateOptions{
+		Notaries: []crypto.PublicKey{otherNotary.Public()},
+	})
+	for i, entry := range report.Entries {
+		if entry.CounterSignature.Status != fdo.VoucherCheckFailed {
+			t.Errorf("expected entry %d countersignature check to fail: %+v", i, entry.CounterSignature)
+		}
+	}
+	report = f.Voucher.Validate(context.Background(), fdo.VoucherValidateOptions{})
+	if got := report.Entries[0].CounterSignature.Status; got != fdo.VoucherCheckSkipped {
+		t.Errorf("expected countersignature check to be skipped without notaries, got %s", got)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/fido-device-onboard/go-fdo/wasm
+
+go 1.23.0
+
+replace github.com/fido-device-onboard/go-fdo => ../
+
+require (
+	github.com/fido-device-onboard/go-fdo v0.0.0-00010101000000-000000000000
+	github.com/tetratelabs/wazero v1.8.1
+)
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package wasm
+
+import (
+	"context"
+	"math"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+const hostModuleName = "fdo"
+
+// hostCallsKey is the context key of the *hostCalls of a call to a module.
+type hostCallsKey struct{}
+
+// hostCalls implements the host API for a single call to a module.
+type hostCalls struct {
+	module string
+
+	respond   func(messageName string, messageBody []byte) error
+	available func(messageName string) int
+	yield     func()
+
+	// Set by the error host function
+	err error
+}
+
+func instantiateHost(ctx context.Context, r wazero.Runtime) error {
+	_, err := r.NewHostModuleBuilder(hostModuleName).
+		NewFunctionBuilder().WithFunc(hostRespond).Export("respond").
+		NewFunctionBuilder().WithFunc(hostAvailable).Export("available").
+		NewFunctionBuilder().WithFunc(hostYield).Export("yield").
+		NewFunctionBuilder().WithFunc(hostError).Export("error").
+		Instantiate(ctx)
+	return err
+}
+
+func hostRespond(ctx context.Context, mod api.Module, namePtr, nameLen, bodyPtr, bodyLen uint32) uint32 {
+	host, ok := ctx.Value(hostCallsKey{}).(*hostCalls)
+	if !ok || host.respond == nil {
+		return 1
+	}
+	name, ok := mod.Memory().Read(namePtr, nameLen)
+	if !ok || len(name) == 0 {
+		return 1
+	}
+	body, ok := mod.Memory().Read(bodyPtr, bodyLen)
+	if !ok {
+		return 1
+	}
+	// Copy the body, because the module may reuse its memory
+	if err := host.respond(string(name), append([]byte(nil), body...)); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func hostAvailable(ctx context.Context, mod api.Module, namePtr, nameLen uint32) uint32 {
+	host, ok := ctx.Value(hostCallsKey{}).(*hostCalls)
+	if !ok || host.respond == nil {
+		return 0
+	}
+	if host.available == nil {
+		return math.MaxInt32
+	}
+	name, ok := mod.Memory().Read(namePtr, nameLen)
+	if !ok {
+		return 0
+	}
+	return uint32(max(host.available(string(name)), 0))
+}
+
+func hostYield(ctx context.Context) {
+	if host, ok := ctx.Value(hostCallsKey{}).(*hostCalls); ok && host.yield != nil {
+		host.yield()
+	}
+}
+
+func hostError(ctx context.Context, mod api.Module, msgPtr, msgLen, recoverable uint32) {
+	host, ok := ctx.Value(hostCallsKey{}).(*hostCalls)
+	if !ok {
+		return
+	}
+	msg, _ := mod.Memory().Read(msgPtr, msgLen)
+	host.err = &serviceinfo.ModuleError{
+		Module:      host.module,
+		Message:     string(msg),
+		Recoverable: recoverable != 0,
+	}
+}
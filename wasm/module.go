@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// DeviceModule adapts an instance of a WASM module to the device module
+// interface.
+type DeviceModule struct {
+	inst *instance
+}
+
+var _ serviceinfo.DeviceModule = (*DeviceModule)(nil)
+
+// Transition implements serviceinfo.DeviceModule.
+func (m *DeviceModule) Transition(active bool) error {
+	if m.inst.transition == nil {
+		return nil
+	}
+	var param uint64
+	if active {
+		param = 1
+	}
+
+	m.inst.mu.Lock()
+	defer m.inst.mu.Unlock()
+	_, err := m.inst.call(context.Background(), new(hostCalls), m.inst.transition, param)
+	return err
+}
+
+// Receive implements serviceinfo.DeviceModule.
+func (m *DeviceModule) Receive(ctx context.Context, messageName string, messageBody io.Reader, respond func(message string) io.Writer, yield func()) error {
+	return m.inst.receiveMessage(ctx, deviceHostCalls(respond, yield), messageName, messageBody)
+}
+
+// Yield implements serviceinfo.DeviceModule.
+func (m *DeviceModule) Yield(ctx context.Context, respond func(message string) io.Writer, yield func()) error {
+	if m.inst.yield == nil {
+		return nil
+	}
+
+	m.inst.mu.Lock()
+	defer m.inst.mu.Unlock()
+	_, err := m.inst.call(ctx, deviceHostCalls(respond, yield), m.inst.yield)
+	return err
+}
+
+// Close terminates the instance of the WASM module.
+func (m *DeviceModule) Close(ctx context.Context) error { return m.inst.close(ctx) }
+
+func deviceHostCalls(respond func(message string) io.Writer, yield func()) *hostCalls {
+	return &hostCalls{
+		respond: func(messageName string, messageBody []byte) error {
+			_, err := respond(messageName).Write(messageBody)
+			return err
+		},
+		yield: yield,
+	}
+}
+
+// OwnerModule adapts an instance of a WASM module to the owner module
+// interface.
+//
+// Messages sent while handling device service info are queued until the next
+// ProduceInfo, before fdo_produce is called.
+type OwnerModule struct {
+	inst    *instance
+	pending []message
+}
+
+type message struct {
+	name string
+	body []byte
+}
+
+var _ serviceinfo.OwnerModule = (*OwnerModule)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (m *OwnerModule) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	return m.inst.receiveMessage(ctx, &hostCalls{
+		respond: func(messageName string, messageBody []byte) error {
+			m.pending = append(m.pending, message{name: messageName, body: messageBody})
+			return nil
+		},
+	}, messageName, messageBody)
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (m *OwnerModule) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	// Send queued messages first, waiting for the next message when full
+	for len(m.pending) > 0 {
+		msg := m.pending[0]
+		if len(msg.body) > producer.Available(msg.name) {
+			if len(producer.ServiceInfo()) == 0 {
+				return false, false, fmt.Errorf("WASM module %q: message %q is larger than the MTU", m.inst.name, msg.name)
+			}
+			return true, false, nil
+		}
+		if err := producer.WriteChunk(msg.name, msg.body); err != nil {
+			return false, false, err
+		}
+		m.pending = m.pending[1:]
+	}
+	if m.inst.produce == nil {
+		return false, true, nil
+	}
+
+	m.inst.mu.Lock()
+	defer m.inst.mu.Unlock()
+	flags, err := m.inst.call(ctx, &hostCalls{
+		respond:   producer.WriteChunk,
+		available: producer.Available,
+	}, m.inst.produce)
+	if err != nil {
+		return false, false, err
+	}
+	return flags&1 != 0, flags&2 != 0, nil
+}
+
+// Close terminates the instance of the WASM module.
+func (m *OwnerModule) Close(ctx context.Context) error { return m.inst.close(ctx) }
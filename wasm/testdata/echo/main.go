@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build wasip1
+
+// Command echo is a WASM service info module for tests. It responds to
+// "ping" with "pong" and the same body and fails recoverably on "fail".
+package main
+
+import "unsafe"
+
+//go:wasmimport fdo respond
+func respond(namePtr, nameLen, bodyPtr, bodyLen uint32) uint32
+
+//go:wasmimport fdo error
+func setError(msgPtr, msgLen, recoverable uint32)
+
+const moduleName = "test.echo"
+
+// Buffers allocated for the host are kept until used, so that they are not
+// collected
+var buffers = make(map[uint32][]byte)
+
+func ptr(b []byte) uint32 {
+	if len(b) == 0 {
+		return 0
+	}
+	return uint32(uintptr(unsafe.Pointer(&b[0])))
+}
+
+func take(p, n uint32) []byte {
+	b := buffers[p]
+	delete(buffers, p)
+	return b[:n]
+}
+
+//go:wasmexport fdo_alloc
+func alloc(size uint32) uint32 {
+	b := make([]byte, size)
+	buffers[ptr(b)] = b
+	return ptr(b)
+}
+
+var name = []byte(moduleName)
+
+//go:wasmexport fdo_module_name
+func fdoModuleName() uint64 {
+	return uint64(ptr(name))<<32 | uint64(len(name))
+}
+
+//go:wasmexport fdo_receive
+func receive(namePtr, nameLen, bodyPtr, bodyLen uint32) int32 {
+	messageName, body := string(take(namePtr, nameLen)), take(bodyPtr, bodyLen)
+	switch messageName {
+	case "ping":
+		pong := []byte("pong")
+		if respond(ptr(pong), uint32(len(pong)), ptr(body), uint32(len(body))) != 0 {
+			return -1
+		}
+		return 0
+	case "fail":
+		msg := []byte("failed on request")
+		setError(ptr(msg), uint32(len(msg)), 1)
+		return -1
+	default:
+		return 0
+	}
+}
+
+func main() {}
@@ -0,0 +1,273 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package wasm hosts service info modules compiled to WebAssembly.
+//
+// Unlike executable plugins, WASM modules run in-process, so they can be used
+// where starting processes is impossible, and they are sandboxed: a module has
+// no access to files, the network, or the environment, and only exchanges
+// service info through the host API.
+//
+// # Host API
+//
+// A module is a WASI reactor, such as a Go program built with
+// GOOS=wasip1 GOARCH=wasm -buildmode=c-shared or a TinyGo program built with
+// -target=wasip1 -buildmode=c-shared. Message bodies are CBOR encoded. All
+// pointers and lengths refer to the linear memory of the module.
+//
+// The module must export:
+//
+//	fdo_alloc(size i32) i32
+//		Allocate a buffer which the host fills with a message name or body
+//		before passing it to the module. The module owns the buffer.
+//	fdo_module_name() i64
+//		Return the module name as a pointer in the high 32 bits and a length
+//		in the low 32 bits.
+//	fdo_receive(name_ptr, name_len, body_ptr, body_len i32) i32
+//		Handle a message from the peer module.
+//
+// And may export:
+//
+//	fdo_transition(active i32) i32
+//		Activate (1) or deactivate (0) a device module.
+//	fdo_yield() i32
+//		Called on a device module after all messages from the owner module
+//		have been received.
+//	fdo_produce() i32
+//		Produce service info from an owner module. Non-negative results are
+//		flags: 1 to block the device from sending service info and 2 when
+//		the module is done. Owner modules which do not export it are done
+//		once their responses are sent.
+//
+// Functions other than fdo_alloc and fdo_module_name return a negative value
+// to indicate an error. The host provides the "fdo" module to import:
+//
+//	respond(name_ptr, name_len, body_ptr, body_len i32) i32
+//		Send a message to the peer module. It returns non-zero if the
+//		message cannot be sent, such as when it does not fit in the current
+//		owner service info message.
+//	available(name_ptr, name_len i32) i32
+//		Return the largest message body which can be sent by respond.
+//	yield()
+//		Send the next message in a new device service info message.
+//	error(msg_ptr, msg_len, recoverable i32)
+//		Set the error of a function which returns a negative value. A
+//		recoverable error fails only the module, as with
+//		serviceinfo.RecoverableError.
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// Config configures the runtime of WASM modules. The zero value is valid.
+type Config struct {
+	// MemoryLimitPages limits the linear memory of each module instance, in
+	// 64KiB pages. Zero uses the WASM maximum of 4GiB.
+	MemoryLimitPages uint32
+
+	// Stderr receives the standard output and error of modules, which is
+	// discarded if nil.
+	Stderr io.Writer
+}
+
+// Runtime compiles a WASM module once and instantiates it for each use.
+// Instances are terminated when the context of a call to them is done, so a
+// deadline, such as from fdo.ModuleTimeouts, stops a hung module.
+type Runtime struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	config   wazero.ModuleConfig
+	name     string
+}
+
+// Compile compiles a WASM module and reads its module name.
+func Compile(ctx context.Context, wasm []byte, config *Config) (_ *Runtime, err error) {
+	if config == nil {
+		config = new(Config)
+	}
+	runtimeConfig := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if config.MemoryLimitPages > 0 {
+		runtimeConfig = runtimeConfig.WithMemoryLimitPages(config.MemoryLimitPages)
+	}
+	r := &Runtime{runtime: wazero.NewRuntimeWithConfig(ctx, runtimeConfig)}
+	defer func() {
+		if err != nil {
+			_ = r.runtime.Close(ctx)
+		}
+	}()
+
+	// Only the clocks and random source of WASI are backed by the host
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r.runtime); err != nil {
+		return nil, fmt.Errorf("error instantiating WASI: %w", err)
+	}
+	if err := instantiateHost(ctx, r.runtime); err != nil {
+		return nil, fmt.Errorf("error instantiating host module: %w", err)
+	}
+	if r.compiled, err = r.runtime.CompileModule(ctx, wasm); err != nil {
+		return nil, fmt.Errorf("error compiling WASM module: %w", err)
+	}
+	r.config = wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	if config.Stderr != nil {
+		r.config = r.config.WithStdout(config.Stderr).WithStderr(config.Stderr)
+	}
+
+	// Read the module name from a temporary instance
+	inst, err := r.instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = inst.close(ctx) }()
+	if r.name, err = inst.moduleName(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Name returns the service info module name of the WASM module.
+func (r *Runtime) Name() string { return r.name }
+
+// DeviceModule returns a new instance of the WASM module as a device module.
+func (r *Runtime) DeviceModule(ctx context.Context) (*DeviceModule, error) {
+	inst, err := r.instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceModule{inst: inst}, nil
+}
+
+// OwnerModule returns a new instance of the WASM module as an owner module.
+// Owner modules hold the state of a TO2 session, so a new one should be
+// created for each session.
+func (r *Runtime) OwnerModule(ctx context.Context) (*OwnerModule, error) {
+	inst, err := r.instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &OwnerModule{inst: inst}, nil
+}
+
+// Close closes the runtime and all instances of the module.
+func (r *Runtime) Close(ctx context.Context) error { return r.runtime.Close(ctx) }
+
+// instance is an instance of a WASM module. Calls to it are serialized.
+type instance struct {
+	mu   sync.Mutex
+	name string
+	mod  api.Module
+
+	alloc, receive, transition, yield, produce api.Function
+}
+
+func (r *Runtime) instantiate(ctx context.Context) (*instance, error) {
+	mod, err := r.runtime.InstantiateModule(ctx, r.compiled, r.config)
+	if err != nil {
+		return nil, fmt.Errorf("error instantiating WASM module: %w", err)
+	}
+	inst := &instance{
+		name:       r.name,
+		mod:        mod,
+		alloc:      mod.ExportedFunction("fdo_alloc"),
+		receive:    mod.ExportedFunction("fdo_receive"),
+		transition: mod.ExportedFunction("fdo_transition"),
+		yield:      mod.ExportedFunction("fdo_yield"),
+		produce:    mod.ExportedFunction("fdo_produce"),
+	}
+	if inst.alloc == nil || inst.receive == nil || mod.ExportedFunction("fdo_module_name") == nil {
+		_ = mod.Close(ctx)
+		return nil, errors.New("WASM module must export fdo_alloc, fdo_module_name, and fdo_receive")
+	}
+	return inst, nil
+}
+
+func (inst *instance) close(ctx context.Context) error { return inst.mod.Close(ctx) }
+
+func (inst *instance) moduleName(ctx context.Context) (string, error) {
+	results, err := inst.mod.ExportedFunction("fdo_module_name").Call(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting WASM module name: %w", err)
+	}
+	name, ok := inst.mod.Memory().Read(uint32(results[0]>>32), uint32(results[0]))
+	if !ok || len(name) == 0 {
+		return "", errors.New("WASM module returned an invalid module name")
+	}
+	return string(name), nil
+}
+
+// call calls a function of the module, which may use the host API to send
+// messages with the given callbacks. Negative results are returned as
+// errors.
+func (inst *instance) call(ctx context.Context, host *hostCalls, fn api.Function, params ...uint64) (uint64, error) {
+	host.module = inst.name
+	results, err := fn.Call(context.WithValue(ctx, hostCallsKey{}, host), params...)
+	if err != nil {
+		return 0, fmt.Errorf("WASM module %q: %w", inst.name, err)
+	}
+	if host.err != nil {
+		return 0, host.err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	if int32(results[0]) < 0 {
+		return 0, &serviceinfo.ModuleError{Module: inst.name, Message: "module returned an error"}
+	}
+	return results[0], nil
+}
+
+// write copies a message name or body to a buffer allocated by the module.
+func (inst *instance) write(ctx context.Context, b []byte) (ptr, size uint64, _ error) {
+	if len(b) == 0 {
+		return 0, 0, nil
+	}
+	if len(b) > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("WASM module %q: message too large", inst.name)
+	}
+	results, err := inst.alloc.Call(ctx, uint64(len(b)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("WASM module %q: error allocating memory: %w", inst.name, err)
+	}
+	if !inst.mod.Memory().Write(uint32(results[0]), b) {
+		return 0, 0, fmt.Errorf("WASM module %q: allocated memory out of range", inst.name)
+	}
+	return uint64(uint32(results[0])), uint64(len(b)), nil
+}
+
+// receiveMessage passes a message to fdo_receive.
+func (inst *instance) receiveMessage(ctx context.Context, host *hostCalls, messageName string, messageBody io.Reader) error {
+	body, err := io.ReadAll(messageBody)
+	if err != nil {
+		return err
+	}
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	namePtr, nameLen, err := inst.write(ctx, []byte(messageName))
+	if err != nil {
+		return err
+	}
+	bodyPtr, bodyLen, err := inst.write(ctx, body)
+	if err != nil {
+		return err
+	}
+	_, err = inst.call(ctx, host, inst.receive, namePtr, nameLen, bodyPtr, bodyLen)
+	return err
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package wasm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+	"github.com/fido-device-onboard/go-fdo/wasm"
+)
+
+// compileEcho builds the test module in testdata/echo and compiles it.
+func compileEcho(t *testing.T) *wasm.Runtime {
+	t.Helper()
+
+	out := filepath.Join(t.TempDir(), "echo.wasm")
+	build := exec.Command("go", "build", "-buildmode=c-shared", "-o", out, "./testdata/echo")
+	build.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("error building WASM module: %v\n%s", err, output)
+	}
+	bin, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	r, err := wasm.Compile(ctx, bin, &wasm.Config{MemoryLimitPages: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Close(ctx) })
+	return r
+}
+
+func TestModules(t *testing.T) {
+	r := compileEcho(t)
+	if r.Name() != "test.echo" {
+		t.Fatalf("expected module name test.echo, got %q", r.Name())
+	}
+	body, err := cbor.Marshal("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("device", func(t *testing.T) {
+		ctx := context.Background()
+		mod, err := r.DeviceModule(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = mod.Close(ctx) }()
+
+		if err := mod.Transition(true); err != nil {
+			t.Fatal(err)
+		}
+		var sent []string
+		var sentBody bytes.Buffer
+		respond := func(messageName string) io.Writer {
+			sent = append(sent, messageName)
+			return &sentBody
+		}
+		if err := mod.Receive(ctx, "ping", bytes.NewReader(body), respond, func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if len(sent) != 1 || sent[0] != "pong" || !bytes.Equal(sentBody.Bytes(), body) {
+			t.Fatalf("unexpected response %v: %x", sent, sentBody.Bytes())
+		}
+
+		err = mod.Receive(ctx, "fail", bytes.NewReader(nil), respond, func() {})
+		var modErr *serviceinfo.ModuleError
+		if !errors.As(err, &modErr) || !modErr.Recoverable || modErr.Module != "test.echo" {
+			t.Fatalf("expected recoverable module error, got %v", err)
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		ctx := context.Background()
+		mod, err := r.OwnerModule(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = mod.Close(ctx) }()
+
+		if err := mod.HandleInfo(ctx, "ping", bytes.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+		producer := serviceinfo.NewProducer(r.Name(), 1300)
+		blockPeer, moduleDone, err := mod.ProduceInfo(ctx, producer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blockPeer || !moduleDone {
+			t.Errorf("expected module to be done, got blockPeer=%t moduleDone=%t", blockPeer, moduleDone)
+		}
+		info := producer.ServiceInfo()
+		if len(info) != 1 || info[0].Key != "test.echo:pong" || !bytes.Equal(info[0].Val, body) {
+			t.Fatalf("unexpected service info: %v", info)
+		}
+	})
+}